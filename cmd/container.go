@@ -2,22 +2,59 @@ package cmd
 
 import (
 	"context"
+	"github.com/gin-gonic/gin"
 	"github.com/numary/ledger/pkg/api"
 	"github.com/numary/ledger/pkg/api/controllers"
+	"github.com/numary/ledger/pkg/api/middlewares"
+	"github.com/numary/ledger/pkg/api/routes"
+	"github.com/numary/ledger/pkg/audit"
 	"github.com/numary/ledger/pkg/ledger"
+	"github.com/numary/ledger/pkg/metrics"
 	"github.com/numary/ledger/pkg/storage"
 	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/jaeger"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.7.0"
 	"go.uber.org/fx"
 	"net/http"
+	"time"
 )
 
 type containerConfig struct {
 	version        string
 	ledgerLister   controllers.LedgerLister
 	basicAuth      string
+	apiKeys        []middlewares.APIKey
+	jwt            middlewares.JWTConfig
 	options        []fx.Option
 	cache          bool
 	rememberConfig bool
+	metrics        bool
+	jaegerEndpoint string
+	commitTimeout  time.Duration
+	ledgerDelete   bool
+	normalize      bool
+	conversionTol  int64
+	unchecked      []string
+	uncheckedBy    map[string][]string
+	referenceScope storage.ReferenceScope
+	referenceBy    map[string]storage.ReferenceScope
+	idGenerator    ledger.IDGenerator
+	limits         ledger.Limits
+	rateLimits     middlewares.RateLimits
+	auditSink      audit.Sink
+	auditBlocking  bool
+	ginMode        string
+	logFormat      routes.LogFormat
+	cors           api.CORSConfig
+	scriptCache    int
+	webhooks       bool
+	webhookConfig  ledger.WebhookConfig
+	reloadConfig   func() (ReloadableConfig, error)
 }
 
 type option func(*containerConfig)
@@ -40,6 +77,20 @@ func WithHttpBasicAuth(v string) option {
 	}
 }
 
+// WithAPIKeys authenticates the requests sent with one of the keys as bearer token, see middlewares.AuthMiddleware
+func WithAPIKeys(keys ...middlewares.APIKey) option {
+	return func(c *containerConfig) {
+		c.apiKeys = append(c.apiKeys, keys...)
+	}
+}
+
+// WithJWT authenticates the requests sent with a JWT as bearer token, see middlewares.JWTConfig
+func WithJWT(config middlewares.JWTConfig) option {
+	return func(c *containerConfig) {
+		c.jwt = config
+	}
+}
+
 func WithOption(providers ...fx.Option) option {
 	return func(c *containerConfig) {
 		c.options = append(c.options, providers...)
@@ -58,8 +109,153 @@ func WithRememberConfig(rememberConfig bool) option {
 	}
 }
 
+// WithMetrics enables the collection of the metrics, exposed on the /metrics route
+func WithMetrics(enabled bool) option {
+	return func(c *containerConfig) {
+		c.metrics = enabled
+	}
+}
+
+// WithJaegerTracing exports the traces to the jaeger collector at the given endpoint
+func WithJaegerTracing(endpoint string) option {
+	return func(c *containerConfig) {
+		c.jaegerEndpoint = endpoint
+	}
+}
+
+// WithCommitTimeout bounds the duration of the commits of the ledgers, see ledger.WithCommitTimeout
+func WithCommitTimeout(timeout time.Duration) option {
+	return func(c *containerConfig) {
+		c.commitTimeout = timeout
+	}
+}
+
+// WithLedgerDeletion allows to drop the ledgers with the DELETE /:ledger route, refused by default
+func WithLedgerDeletion(allowed bool) option {
+	return func(c *containerConfig) {
+		c.ledgerDelete = allowed
+	}
+}
+
+// WithAssetNormalization uppercases the asset codes of the new commits, see ledger.WithAssetNormalization
+func WithAssetNormalization(enabled bool) option {
+	return func(c *containerConfig) {
+		c.normalize = enabled
+	}
+}
+
+// WithConversionTolerance sets the difference allowed by the ledgers between the amounts of the conversions
+// and their rates, see ledger.WithConversionTolerance
+func WithConversionTolerance(tolerance int64) option {
+	return func(c *containerConfig) {
+		c.conversionTol = tolerance
+	}
+}
+
+// WithUncheckedAccounts sets the accounts whose balances are not checked by the ledgers, and the ones of
+// some of the ledgers by name, replacing the former, see ledger.WithUncheckedAccounts
+func WithUncheckedAccounts(accounts []string, byLedger map[string][]string) option {
+	return func(c *containerConfig) {
+		c.unchecked = accounts
+		c.uncheckedBy = byLedger
+	}
+}
+
+// WithReferenceScopes sets the scope within which the references of the transactions of the ledgers must be unique,
+// and the one of some of the ledgers by name, see ledger.WithReferenceScope
+func WithReferenceScopes(scope storage.ReferenceScope, byLedger map[string]storage.ReferenceScope) option {
+	return func(c *containerConfig) {
+		c.referenceScope = scope
+		c.referenceBy = byLedger
+	}
+}
+
+// WithIDGenerator sets the generator of the ids of the transactions of the ledgers, see ledger.WithIDGenerator
+func WithIDGenerator(generator ledger.IDGenerator) option {
+	return func(c *containerConfig) {
+		c.idGenerator = generator
+	}
+}
+
+// WithLimits bounds the size of the batches committed to the ledgers, see ledger.WithLimits
+func WithLimits(limits ledger.Limits) option {
+	return func(c *containerConfig) {
+		c.limits = limits
+	}
+}
+
+// WithRateLimits limits the requests of each client on each ledger, see middlewares.RateLimits
+func WithRateLimits(limits middlewares.RateLimits) option {
+	return func(c *containerConfig) {
+		c.rateLimits = limits
+	}
+}
+
+// WithAudit records the writes made through the API in the sink, see middlewares.NewAuditMiddleware
+func WithAudit(sink audit.Sink, blocking bool) option {
+	return func(c *containerConfig) {
+		c.auditSink = sink
+		c.auditBlocking = blocking
+	}
+}
+
+// WithGinMode sets the mode of gin, debug, release or test
+func WithGinMode(mode string) option {
+	return func(c *containerConfig) {
+		c.ginMode = mode
+	}
+}
+
+// WithAccessLogFormat sets the format of the access logs of the API, see routes.LogFormat
+func WithAccessLogFormat(format routes.LogFormat) option {
+	return func(c *containerConfig) {
+		c.logFormat = format
+	}
+}
+
+// WithCORS sets the CORS policy of the API, see api.CORSConfig
+func WithCORS(config api.CORSConfig) option {
+	return func(c *containerConfig) {
+		c.cors = config
+	}
+}
+
+// WithScriptCacheSize sets the number of compiled scripts kept by the ledgers, 0 to compile them on each execution
+func WithScriptCacheSize(size int) option {
+	return func(c *containerConfig) {
+		c.scriptCache = size
+	}
+}
+
+// WithWebhooks runs the delivery of the webhooks of the ledgers, see ledger.WebhookDispatcher.
+// It should only be enabled on one of the processes sharing the storage, the transactions being delivered
+// by each of them otherwise.
+func WithWebhooks(enabled bool, config ledger.WebhookConfig) option {
+	return func(c *containerConfig) {
+		c.webhooks = enabled
+		c.webhookConfig = config
+	}
+}
+
+// WithConfigReload allows to change the settings of ReloadableConfig without restarting the server,
+// reading them with the given function on each reload, see ConfigReloader
+func WithConfigReload(read func() (ReloadableConfig, error)) option {
+	return func(c *containerConfig) {
+		c.reloadConfig = read
+	}
+}
+
 var DefaultOptions = []option{
 	WithVersion("latest"),
+	WithGinMode(gin.ReleaseMode),
+	WithAccessLogFormat(routes.LogFormatJSON),
+	WithCORS(api.DefaultCORSConfig),
+	WithScriptCacheSize(ledger.DefaultScriptCacheSize),
+	WithWebhooks(false, ledger.DefaultWebhookConfig),
+	WithLimits(ledger.DefaultLimits),
+	WithUncheckedAccounts(ledger.DefaultUncheckedAccounts, nil),
+	WithReferenceScopes(storage.ReferenceScopeGlobal, nil),
+	WithIDGenerator(ledger.SequenceGenerator),
 	WithLedgerLister(controllers.LedgerListerFn(func(*http.Request) []string {
 		return []string{}
 	})),
@@ -78,15 +274,92 @@ func NewContainer(options ...option) *fx.App {
 		fx.Annotate(func(driver storage.Driver) string { return driver.Name() }, fx.ResultTags(`name:"storageDriver"`)),
 		fx.Annotate(func() controllers.LedgerLister { return cfg.ledgerLister }, fx.ResultTags(`name:"ledgerLister"`)),
 		fx.Annotate(func() string { return cfg.basicAuth }, fx.ResultTags(`name:"httpBasic"`)),
+		fx.Annotate(func() []middlewares.APIKey { return cfg.apiKeys }, fx.ResultTags(`name:"apiKeys"`)),
+		func() middlewares.JWTConfig { return cfg.jwt },
+		fx.Annotate(func() bool { return cfg.ledgerDelete }, fx.ResultTags(`name:"allowLedgerDelete"`)),
+		func() middlewares.RateLimits { return cfg.rateLimits },
+		func() audit.Sink { return cfg.auditSink },
+		fx.Annotate(func() bool { return cfg.auditBlocking }, fx.ResultTags(`name:"auditBlocking"`)),
+		func() *ConfigReloader {
+			if cfg.reloadConfig == nil {
+				return nil
+			}
+			return NewConfigReloader(cfg.reloadConfig)
+		},
+		fx.Annotate(
+			func(reloader *ConfigReloader) controllers.ConfigReloader {
+				if reloader == nil {
+					return nil
+				}
+				return reloader
+			},
+			fx.ResultTags(`name:"configReloader"`),
+		),
 		fx.Annotate(ledger.NewResolver, fx.ParamTags(`group:"resolverOptions"`)),
 		fx.Annotate(
 			ledger.WithStorageFactory,
 			fx.ResultTags(`group:"resolverOptions"`),
 			fx.As(new(ledger.ResolverOption)),
 		),
-		api.NewAPI,
-		func(driver storage.Driver) storage.Factory {
+		func() *metrics.Metrics {
+			if !cfg.metrics {
+				return nil
+			}
+			return metrics.New()
+		},
+		func() *ledger.ScriptCache {
+			if cfg.scriptCache <= 0 {
+				return nil
+			}
+			return ledger.NewScriptCache(cfg.scriptCache)
+		},
+		fx.Annotate(
+			func(m *metrics.Metrics, scriptCache *ledger.ScriptCache) ledger.ResolverOption {
+				return ledger.WithLedgerOptions(
+					ledger.WithMetrics(m),
+					ledger.WithScriptCache(scriptCache),
+					ledger.WithCommitTimeout(cfg.commitTimeout),
+					ledger.WithAssetNormalization(cfg.normalize),
+					ledger.WithConversionTolerance(cfg.conversionTol),
+					ledger.WithUncheckedAccounts(cfg.unchecked...),
+					ledger.WithReferenceScope(cfg.referenceScope),
+					ledger.WithIDGenerator(cfg.idGenerator),
+				)
+			},
+			fx.ResultTags(`group:"resolverOptions"`),
+		),
+		fx.Annotate(
+			func() ledger.ResolverOption {
+				return ledger.ResolveOptionFn(func(r *ledger.Resolver) error {
+					// The limits are set on the resolver rather than as options of the ledgers, to be reloadable
+					r.SetLimits(cfg.limits)
+					for name, accounts := range cfg.uncheckedBy {
+						err := ledger.WithNamedLedgerOptions(name, ledger.WithUncheckedAccounts(accounts...))(r)
+						if err != nil {
+							return err
+						}
+					}
+					for name, scope := range cfg.referenceBy {
+						err := ledger.WithNamedLedgerOptions(name, ledger.WithReferenceScope(scope))(r)
+						if err != nil {
+							return err
+						}
+					}
+					return nil
+				})
+			},
+			fx.ResultTags(`group:"resolverOptions"`),
+		),
+		func() routes.LogFormat { return cfg.logFormat },
+		fx.Annotate(func() string { return cfg.ginMode }, fx.ResultTags(`name:"ginMode"`)),
+		func() api.CORSConfig { return cfg.cors },
+		fx.Annotate(api.NewAPI, fx.ParamTags(``, `name:"ginMode"`, ``)),
+		func(driver storage.Driver, m *metrics.Metrics) storage.Factory {
 			f := storage.NewDefaultFactory(driver)
+			if m != nil {
+				// Wrapped first, to time the queries actually reaching the store
+				f = storage.NewMetricsStorageFactory(f, m)
+			}
 			if cfg.cache {
 				f = storage.NewCachedStorageFactory(f)
 			}
@@ -107,6 +380,79 @@ func NewContainer(options ...option) *fx.App {
 		})
 		return nil
 	})
+	if cfg.reloadConfig != nil {
+		invokes = append(invokes, func(
+			reloader *ConfigReloader,
+			h *api.API,
+			resolver *ledger.Resolver,
+			rateLimits *middlewares.RateLimitMiddleware,
+		) {
+			reloader.Observe(func(c ReloadableConfig) error {
+				logrus.SetLevel(c.LogLevel)
+				return nil
+			})
+			reloader.Observe(func(c ReloadableConfig) error {
+				return h.SetCORS(c.CORS)
+			})
+			reloader.Observe(func(c ReloadableConfig) error {
+				resolver.SetLimits(c.Limits)
+				return nil
+			})
+			reloader.Observe(func(c ReloadableConfig) error {
+				rateLimits.SetLimits(c.RateLimits)
+				return nil
+			})
+		})
+	}
+	if cfg.webhooks {
+		invokes = append(invokes, func(resolver *ledger.Resolver, lifecycle fx.Lifecycle) {
+			dispatcher := ledger.NewWebhookDispatcher(resolver, func() []string {
+				return cfg.ledgerLister.List(nil)
+			}, cfg.webhookConfig)
+			ctx, cancel := context.WithCancel(context.Background())
+			stopped := make(chan struct{})
+			lifecycle.Append(fx.Hook{
+				OnStart: func(context.Context) error {
+					go func() {
+						dispatcher.Run(ctx)
+						close(stopped)
+					}()
+					return nil
+				},
+				OnStop: func(stopCtx context.Context) error {
+					cancel()
+					select {
+					case <-stopped:
+						return nil
+					case <-stopCtx.Done():
+						return stopCtx.Err()
+					}
+				},
+			})
+		})
+	}
+	if cfg.jaegerEndpoint != "" {
+		invokes = append(invokes, func(lifecycle fx.Lifecycle) error {
+			exporter, err := jaeger.New(jaeger.WithCollectorEndpoint(jaeger.WithEndpoint(cfg.jaegerEndpoint)))
+			if err != nil {
+				return errors.Wrap(err, "creating jaeger exporter")
+			}
+			tp := sdktrace.NewTracerProvider(
+				sdktrace.WithBatcher(exporter),
+				sdktrace.WithResource(resource.NewWithAttributes(
+					semconv.SchemaURL,
+					semconv.ServiceNameKey.String("ledger"),
+					semconv.ServiceVersionKey.String(cfg.version),
+				)),
+			)
+			otel.SetTracerProvider(tp)
+			otel.SetTextMapPropagator(propagation.TraceContext{})
+			lifecycle.Append(fx.Hook{
+				OnStop: tp.Shutdown,
+			})
+			return nil
+		})
+	}
 	fxOptions := append(
 		[]fx.Option{
 			fx.Provide(providers...),
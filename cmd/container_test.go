@@ -1,17 +1,80 @@
 package cmd
 
 import (
+	"bufio"
 	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/numary/ledger/pkg/api"
+	"github.com/numary/ledger/pkg/api/middlewares"
+	"github.com/numary/ledger/pkg/api/routes"
+	"github.com/numary/ledger/pkg/audit"
+	"github.com/numary/ledger/pkg/core"
+	"github.com/numary/ledger/pkg/ledger"
 	"github.com/numary/ledger/pkg/ledgertesting"
 	"github.com/numary/ledger/pkg/storage"
+	"github.com/numary/ledger/pkg/storage/memorystorage"
+	"github.com/numary/ledger/pkg/storage/redisstorage"
 	"github.com/numary/ledger/pkg/storage/sqlstorage"
+	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 	"go.uber.org/fx"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestContainers(t *testing.T) {
 
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwksServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "key",
+				"n":   base64.RawURLEncoding.EncodeToString(rsaKey.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(rsaKey.E)).Bytes()),
+			}},
+		})
+	}))
+	defer jwksServer.Close()
+
+	sink := &auditSink{}
+
+	// The settings read by the reloads of the config_reload case
+	reloaded := ReloadableConfig{
+		LogLevel: logrus.DebugLevel,
+		Limits: ledger.Limits{
+			MaxPostingsPerTransaction: 1,
+			MaxTransactionsPerBatch:   1,
+			DefaultPageSize:           5,
+			MaxPageSize:               10,
+		},
+		RateLimits: middlewares.RateLimits{
+			Read: middlewares.RateLimit{Rate: 0.001, Burst: 1},
+		},
+		CORS: api.CORSConfig{
+			AllowedOrigins: []string{"https://app.example.com", "https://admin.example.com"},
+			AllowedMethods: []string{http.MethodGet},
+		},
+	}
+	var reloadErr error
+
 	type testCase struct {
 		name    string
 		options []option
@@ -26,6 +89,32 @@ func TestContainers(t *testing.T) {
 				})),
 			},
 		},
+		{
+			name: "metrics",
+			options: []option{
+				WithMetrics(true),
+				WithOption(fx.Provide(func() storage.Driver {
+					return sqlstorage.NewInMemorySQLiteDriver()
+				})),
+				WithOption(fx.Invoke(func(t *testing.T, resolver *ledger.Resolver, api *api.API) {
+					l, err := resolver.GetLedger(context.Background(), "metrics")
+					assert.NoError(t, err)
+					_, err = l.Commit(context.Background(), []core.Transaction{{
+						Postings: []core.Posting{
+							{Source: "world", Destination: "users:001", Amount: 100, Asset: "COIN"},
+						},
+					}})
+					assert.NoError(t, err)
+
+					rec := httptest.NewRecorder()
+					api.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+					assert.Equal(t, http.StatusOK, rec.Code)
+					assert.Contains(t, rec.Body.String(), `numary_ledger_commits_total{ledger="metrics"} 1`)
+					assert.Contains(t, rec.Body.String(), `numary_ledger_postings_total{ledger="metrics"} 1`)
+					assert.Contains(t, rec.Body.String(), `numary_storage_query_duration_seconds_count{ledger="metrics",query="save_transactions"} 1`)
+				})),
+			},
+		},
 		{
 			name: "pg",
 			options: []option{
@@ -41,6 +130,1230 @@ func TestContainers(t *testing.T) {
 				})),
 			},
 		},
+		{
+			name: "ledgers",
+			options: []option{
+				WithOption(fx.Provide(func() storage.Driver {
+					return sqlstorage.NewInMemorySQLiteDriver()
+				})),
+				WithOption(fx.Invoke(func(t *testing.T, api *api.API) {
+					rec := httptest.NewRecorder()
+					api.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/_ledgers/created", nil))
+					assert.Equal(t, http.StatusOK, rec.Code)
+
+					rec = httptest.NewRecorder()
+					api.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/_ledgers/created", nil))
+					assert.Equal(t, http.StatusConflict, rec.Code)
+
+					rec = httptest.NewRecorder()
+					api.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/created/transactions", nil))
+					assert.Equal(t, http.StatusOK, rec.Code)
+
+					rec = httptest.NewRecorder()
+					api.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/_ledgers", nil))
+					assert.Equal(t, http.StatusOK, rec.Code)
+					assert.JSONEq(t, `{"ok":true,"data":["created"]}`, rec.Body.String())
+
+					// Deletion is refused by default
+					rec = httptest.NewRecorder()
+					api.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/created?confirm=created", nil))
+					assert.Equal(t, http.StatusForbidden, rec.Code)
+				})),
+			},
+		},
+		{
+			name: "delete",
+			options: []option{
+				WithLedgerDeletion(true),
+				WithOption(fx.Provide(func() storage.Driver {
+					return memorystorage.NewDriver("memory")
+				})),
+				WithOption(fx.Invoke(func(t *testing.T, resolver *ledger.Resolver, api *api.API) {
+					l, err := resolver.GetLedger(context.Background(), "deleted")
+					assert.NoError(t, err)
+					_, err = l.Commit(context.Background(), []core.Transaction{{
+						Postings: []core.Posting{
+							{Source: "world", Destination: "users:001", Amount: 100, Asset: "COIN"},
+						},
+					}})
+					assert.NoError(t, err)
+
+					rec := httptest.NewRecorder()
+					api.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/deleted?confirm=other", nil))
+					assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+					rec = httptest.NewRecorder()
+					api.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/deleted?confirm=deleted", nil))
+					assert.Equal(t, http.StatusOK, rec.Code)
+
+					rec = httptest.NewRecorder()
+					api.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/deleted/stats", nil))
+					assert.Equal(t, http.StatusOK, rec.Code)
+					assert.JSONEq(t, `{"ok":true,"data":{"transactions":0,"accounts":0,"assets":[],"scripts":{"size":0,"capacity":1024,"hits":0,"misses":0}}}`, rec.Body.String())
+				})),
+			},
+		},
+		{
+			name: "limits",
+			options: []option{
+				WithLimits(ledger.Limits{
+					MaxPostingsPerTransaction: 10,
+					MaxPageSize:               20,
+				}),
+				WithOption(fx.Provide(func() storage.Driver {
+					return memorystorage.NewDriver("memory")
+				})),
+				WithOption(fx.Invoke(func(t *testing.T, api *api.API) {
+					rec := httptest.NewRecorder()
+					api.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/_info", nil))
+					assert.Equal(t, http.StatusOK, rec.Code)
+					assert.Contains(t, rec.Body.String(), `"limits":{"max_postings_per_transaction":10,"max_transactions_per_batch":0,"default_page_size":15,"max_page_size":20}`)
+
+					// The larger pages are clamped
+					rec = httptest.NewRecorder()
+					api.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/limited/transactions?limit=1000", nil))
+					assert.Equal(t, http.StatusOK, rec.Code)
+					assert.Contains(t, rec.Body.String(), `"page_size":20`)
+
+					rec = httptest.NewRecorder()
+					api.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/limited/accounts?page_size=0", nil))
+					assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+					// The configuration can't be reloaded without WithConfigReload
+					rec = httptest.NewRecorder()
+					api.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/_config/reload", nil))
+					assert.Equal(t, http.StatusNotImplemented, rec.Code)
+				})),
+			},
+		},
+		{
+			name: "metadata_version",
+			options: []option{
+				WithOption(fx.Provide(func() storage.Driver {
+					return memorystorage.NewDriver("memory")
+				})),
+				WithOption(fx.Invoke(func(t *testing.T, api *api.API) {
+					postMetadata := func(ifMatch, body string) *httptest.ResponseRecorder {
+						req := httptest.NewRequest(http.MethodPost, "/versioned/accounts/users:001/metadata", strings.NewReader(body))
+						req.Header.Set("Content-Type", "application/json")
+						if ifMatch != "" {
+							req.Header.Set("If-Match", ifMatch)
+						}
+						rec := httptest.NewRecorder()
+						api.ServeHTTP(rec, req)
+						return rec
+					}
+
+					rec := httptest.NewRecorder()
+					api.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/versioned/accounts/users:001", nil))
+					assert.Equal(t, http.StatusOK, rec.Code)
+					assert.Equal(t, `"0"`, rec.Header().Get("ETag"))
+
+					rec = postMetadata(`"0"`, `{"name":"John"}`)
+					assert.Equal(t, http.StatusOK, rec.Code)
+					assert.Equal(t, `"1"`, rec.Header().Get("ETag"))
+
+					rec = postMetadata(`"0"`, `{"name":"Jane"}`)
+					assert.Equal(t, http.StatusConflict, rec.Code)
+
+					rec = postMetadata("invalid", `{"name":"Jane"}`)
+					assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+					rec = postMetadata("", `{"name":"Jane"}`)
+					assert.Equal(t, http.StatusOK, rec.Code)
+					assert.Equal(t, `"2"`, rec.Header().Get("ETag"))
+
+					rec = httptest.NewRecorder()
+					api.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/versioned/accounts/users:001", nil))
+					assert.Equal(t, http.StatusOK, rec.Code)
+					assert.Equal(t, `"2"`, rec.Header().Get("ETag"))
+					assert.Contains(t, rec.Body.String(), `"metadata_version":2`)
+				})),
+			},
+		},
+		{
+			name: "batch",
+			options: []option{
+				WithOption(fx.Provide(func() storage.Driver {
+					return memorystorage.NewDriver("memory")
+				})),
+				WithOption(fx.Invoke(func(t *testing.T, api *api.API) {
+					postBatch := func(atomic bool) *httptest.ResponseRecorder {
+						req := httptest.NewRequest(http.MethodPost, "/batched/transactions/batch", strings.NewReader(fmt.Sprintf(`{
+							"atomic": %t,
+							"transactions": [
+								{"postings":[{"source":"world","destination":"users:001","amount":100,"asset":"COIN"}]},
+								{"postings":[{"source":"users:002","destination":"users:001","amount":100,"asset":"COIN"}]},
+								{"postings":[{"source":"users:001","destination":"users:002","amount":50,"asset":"COIN"}]}
+							]
+						}`, atomic)))
+						req.Header.Set("Content-Type", "application/json")
+						rec := httptest.NewRecorder()
+						api.ServeHTTP(rec, req)
+						return rec
+					}
+
+					rec := postBatch(true)
+					assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+					rec = httptest.NewRecorder()
+					api.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/batched/stats", nil))
+					assert.Equal(t, http.StatusOK, rec.Code)
+					assert.Contains(t, rec.Body.String(), `"transactions":0`)
+
+					rec = postBatch(false)
+					assert.Equal(t, http.StatusOK, rec.Code)
+					assert.JSONEq(t, `{"ok":true,"data":[
+						{"index":0,"txid":0},
+						{"index":1,"error":"balance.insufficient.COIN: account users:002 needs 100, has 0","error_code":400},
+						{"index":2,"txid":1}
+					]}`, rec.Body.String())
+
+					// The preconditions on the balances are checked before committing the atomic batches
+					postWithPreconditions := func(atomic bool, amount int) *httptest.ResponseRecorder {
+						req := httptest.NewRequest(http.MethodPost, "/batched/transactions/batch", strings.NewReader(fmt.Sprintf(`{
+							"atomic": %t,
+							"transactions": [
+								{"postings":[{"source":"users:001","destination":"users:002","amount":50,"asset":"COIN"}]}
+							],
+							"preconditions": [
+								{"account":"users:001","asset":"COIN","operator":">=","amount":%d}
+							]
+						}`, atomic, amount)))
+						req.Header.Set("Content-Type", "application/json")
+						rec := httptest.NewRecorder()
+						api.ServeHTTP(rec, req)
+						return rec
+					}
+
+					rec = postWithPreconditions(false, 50)
+					assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+					rec = postWithPreconditions(true, 60)
+					assert.Equal(t, http.StatusConflict, rec.Code)
+					assert.Contains(t, rec.Body.String(), "precondition 0 failed: balance of users:001 in COIN")
+					assert.Contains(t, rec.Body.String(), "is 50")
+
+					rec = postWithPreconditions(true, 50)
+					assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+					assert.JSONEq(t, `{"ok":true,"data":[{"index":0,"txid":2}]}`, rec.Body.String())
+				})),
+			},
+		},
+		{
+			name: "watch",
+			options: []option{
+				WithOption(fx.Provide(func() storage.Driver {
+					return memorystorage.NewDriver("memory")
+				})),
+				WithOption(fx.Invoke(func(t *testing.T, api *api.API) {
+					server := httptest.NewServer(api)
+					defer server.Close()
+
+					res, err := http.Get(server.URL + "/watched/accounts/users:001/watch?debounce=abc")
+					assert.NoError(t, err)
+					res.Body.Close()
+					assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+
+					ctx, cancel := context.WithCancel(context.Background())
+					defer cancel()
+					req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/watched/accounts/users:001/watch", nil)
+					res, err = http.DefaultClient.Do(req)
+					assert.NoError(t, err)
+					defer res.Body.Close()
+					assert.Equal(t, http.StatusOK, res.StatusCode)
+					assert.Contains(t, res.Header.Get("Content-Type"), "text/event-stream")
+
+					events := bufio.NewScanner(res.Body)
+					next := func() string {
+						var lines []string
+						for events.Scan() && events.Text() != "" {
+							lines = append(lines, events.Text())
+						}
+						return strings.Join(lines, "\n")
+					}
+
+					// The current state is sent first, then the new states
+					assert.Equal(t, "event:account\ndata:"+`{"address":"users:001","contract":"default","metadata":{}}`, next())
+
+					rec := httptest.NewRecorder()
+					req = httptest.NewRequest(http.MethodPost, "/watched/transactions", strings.NewReader(
+						`{"postings":[{"source":"world","destination":"users:001","amount":100,"asset":"COIN"}]}`,
+					))
+					req.Header.Set("Content-Type", "application/json")
+					api.ServeHTTP(rec, req)
+					assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+					event := next()
+					assert.True(t, strings.HasPrefix(event, "event:account\ndata:"), event)
+					assert.Contains(t, event, `"balances":{"COIN":100}`)
+				})),
+			},
+		},
+		{
+			name: "transactions_stream",
+			options: []option{
+				WithOption(fx.Provide(func() storage.Driver {
+					return memorystorage.NewDriver("memory")
+				})),
+				WithOption(fx.Invoke(func(t *testing.T, api *api.API) {
+					server := httptest.NewServer(api)
+					defer server.Close()
+
+					post := func(destination string) {
+						rec := httptest.NewRecorder()
+						req := httptest.NewRequest(http.MethodPost, "/streamed/transactions", strings.NewReader(
+							`{"postings":[{"source":"world","destination":"`+destination+`","amount":100,"asset":"COIN"}]}`,
+						))
+						req.Header.Set("Content-Type", "application/json")
+						api.ServeHTTP(rec, req)
+						assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+					}
+					post("users:001")
+					post("users:002")
+
+					res, err := http.Get(server.URL + "/streamed/transactions/stream?after=abc")
+					assert.NoError(t, err)
+					res.Body.Close()
+					assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+
+					ctx, cancel := context.WithCancel(context.Background())
+					defer cancel()
+					follow := func(query, lastEventID string) *bufio.Scanner {
+						req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/streamed/transactions/stream"+query, nil)
+						if lastEventID != "" {
+							req.Header.Set("Last-Event-ID", lastEventID)
+						}
+						res, err := http.DefaultClient.Do(req)
+						assert.NoError(t, err)
+						assert.Equal(t, http.StatusOK, res.StatusCode)
+						assert.Contains(t, res.Header.Get("Content-Type"), "text/event-stream")
+						return bufio.NewScanner(res.Body)
+					}
+					next := func(events *bufio.Scanner) string {
+						var lines []string
+						for events.Scan() && events.Text() != "" {
+							lines = append(lines, events.Text())
+						}
+						return strings.Join(lines, "\n")
+					}
+
+					// The stream resumes after the last transaction received
+					resumed := follow("?after=0&account=users:001", "")
+					reconnected := follow("", "0")
+					post("users:001")
+
+					event := next(resumed)
+					assert.True(t, strings.HasPrefix(event, "id:2\nevent:transaction\ndata:"), event)
+					assert.Contains(t, event, `"destination":"users:001"`)
+
+					assert.True(t, strings.HasPrefix(next(reconnected), "id:1\nevent:transaction\ndata:"))
+					assert.True(t, strings.HasPrefix(next(reconnected), "id:2\nevent:transaction\ndata:"))
+				})),
+			},
+		},
+		{
+			name: "posting_log",
+			options: []option{
+				WithOption(fx.Provide(func() storage.Driver {
+					return memorystorage.NewDriver("memory")
+				})),
+				WithOption(fx.Invoke(func(t *testing.T, api *api.API) {
+					server := httptest.NewServer(api)
+					defer server.Close()
+
+					post := func(postings string) {
+						rec := httptest.NewRecorder()
+						req := httptest.NewRequest(http.MethodPost, "/logged/transactions", strings.NewReader(
+							`{"postings":`+postings+`}`,
+						))
+						req.Header.Set("Content-Type", "application/json")
+						api.ServeHTTP(rec, req)
+						assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+					}
+					post(`[{"source":"world","destination":"users:001","amount":100,"asset":"COIN"}]`)
+					post(`[{"source":"users:001","destination":"users:002","amount":60,"asset":"COIN"},` +
+						`{"source":"users:001","destination":"users:003","amount":40,"asset":"COIN"}]`)
+
+					for _, query := range []string{"?after=abc", "?after=-1"} {
+						res, err := http.Get(server.URL + "/logged/postings/log" + query)
+						assert.NoError(t, err)
+						res.Body.Close()
+						assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+					}
+
+					ctx, cancel := context.WithCancel(context.Background())
+					defer cancel()
+					tail := func(query, lastEventID string) *bufio.Scanner {
+						req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/logged/postings/log"+query, nil)
+						if lastEventID != "" {
+							req.Header.Set("Last-Event-ID", lastEventID)
+						}
+						res, err := http.DefaultClient.Do(req)
+						assert.NoError(t, err)
+						assert.Equal(t, http.StatusOK, res.StatusCode)
+						assert.Contains(t, res.Header.Get("Content-Type"), "text/event-stream")
+						return bufio.NewScanner(res.Body)
+					}
+					next := func(events *bufio.Scanner) string {
+						var lines []string
+						for events.Scan() && events.Text() != "" {
+							lines = append(lines, events.Text())
+						}
+						return strings.Join(lines, "\n")
+					}
+
+					// The postings are sent from the first transaction, the last one of each transaction having its txid as id
+					log := tail("", "")
+					event := next(log)
+					assert.True(t, strings.HasPrefix(event, "id:0\nevent:posting\ndata:"), event)
+					assert.Contains(t, event, `"txid":0,"index":0,"last":true`)
+					event = next(log)
+					assert.True(t, strings.HasPrefix(event, "event:posting\ndata:"), event)
+					assert.Contains(t, event, `"txid":1,"index":0,"last":false`)
+					event = next(log)
+					assert.True(t, strings.HasPrefix(event, "id:1\nevent:posting\ndata:"), event)
+					assert.Contains(t, event, `"destination":"users:003"`)
+
+					// The log resumes after the last transaction received, and follows the new ones
+					resumed := tail("?after=0", "")
+					reconnected := tail("", "1")
+					post(`[{"source":"users:003","destination":"users:004","amount":10,"asset":"COIN"}]`)
+
+					assert.Contains(t, next(resumed), `"txid":1,"index":0`)
+					assert.Contains(t, next(resumed), `"txid":1,"index":1`)
+					for _, events := range []*bufio.Scanner{log, resumed, reconnected} {
+						event := next(events)
+						assert.True(t, strings.HasPrefix(event, "id:2\nevent:posting\ndata:"), event)
+						assert.Contains(t, event, `"destination":"users:004"`)
+					}
+				})),
+			},
+		},
+		{
+			name: "amounts",
+			options: []option{
+				WithOption(fx.Provide(func() storage.Driver {
+					return memorystorage.NewDriver("memory")
+				})),
+				WithOption(fx.Invoke(func(t *testing.T, api *api.API) {
+					post := func(amount string) *httptest.ResponseRecorder {
+						req := httptest.NewRequest(http.MethodPost, "/amounts/transactions", strings.NewReader(
+							`{"postings":[{"source":"world","destination":"users:001","amount":`+amount+`,"asset":"COIN"}]}`,
+						))
+						req.Header.Set("Content-Type", "application/json")
+						rec := httptest.NewRecorder()
+						api.ServeHTTP(rec, req)
+						return rec
+					}
+
+					// The amounts beyond the precision of the floats are not rounded
+					rec := post("9007199254740993")
+					assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+					assert.Contains(t, rec.Body.String(), `"amount":9007199254740993`)
+
+					for _, amount := range []string{"9223372036854775808", "100000000000000000000", "1.5", "1e3", `"100"`} {
+						rec = post(amount)
+						assert.Equal(t, http.StatusBadRequest, rec.Code, amount)
+						assert.Contains(t, rec.Body.String(), "invalid amount", amount)
+					}
+
+					rec = httptest.NewRecorder()
+					api.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/amounts/stats", nil))
+					assert.Contains(t, rec.Body.String(), `"transactions":1`)
+				})),
+			},
+		},
+		{
+			name: "string_amounts",
+			options: []option{
+				WithOption(fx.Provide(func() storage.Driver {
+					return memorystorage.NewDriver("memory")
+				})),
+				WithOption(fx.Invoke(func(t *testing.T, api *api.API) {
+					req := httptest.NewRequest(http.MethodPost, "/strings/transactions", strings.NewReader(
+						`{"postings":[{"source":"world","destination":"users:001","amount":9007199254740993,"asset":"COIN"}],"metadata":{"amount":1}}`,
+					))
+					req.Header.Set("Content-Type", "application/json")
+					rec := httptest.NewRecorder()
+					api.ServeHTTP(rec, req)
+					assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+					get := func(path, accept string) string {
+						req := httptest.NewRequest(http.MethodGet, path, nil)
+						if accept != "" {
+							req.Header.Set("Accept", accept)
+						}
+						rec := httptest.NewRecorder()
+						api.ServeHTTP(rec, req)
+						assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+						return rec.Body.String()
+					}
+
+					// The amounts are numbers by default
+					body := get("/strings/transactions/0", "")
+					assert.Contains(t, body, `"amount":9007199254740993`)
+					assert.Contains(t, get("/strings/accounts/users:001", "application/json"), `"balances":{"COIN":9007199254740993}`)
+
+					accept := "text/html, application/json; amounts=string"
+					body = get("/strings/transactions/0", accept)
+					assert.Contains(t, body, `"amount":"9007199254740993"`)
+					// The other numbers, and the metadata, are left as is
+					assert.Contains(t, body, `"txid":0`)
+					assert.Contains(t, body, `"metadata":{"amount":1}`)
+
+					body = get("/strings/accounts/users:001", accept)
+					assert.Contains(t, body, `"balances":{"COIN":"9007199254740993"}`)
+					assert.Contains(t, body, `"volumes":{"COIN":{"input":"9007199254740993","output":"0"}}`)
+					assert.Contains(t, get("/strings/transactions", accept), `"amount":"9007199254740993"`)
+					assert.Contains(t, get("/strings/balances?address=users:", accept), `"data":{"COIN":"9007199254740993"}`)
+				})),
+			},
+		},
+		{
+			name: "metadata_schema",
+			options: []option{
+				WithOption(fx.Provide(func() storage.Driver {
+					return memorystorage.NewDriver("memory")
+				})),
+				WithOption(fx.Invoke(func(t *testing.T, api *api.API) {
+					do := func(method, path, body string) *httptest.ResponseRecorder {
+						req := httptest.NewRequest(method, path, strings.NewReader(body))
+						req.Header.Set("Content-Type", "application/json")
+						rec := httptest.NewRecorder()
+						api.ServeHTTP(rec, req)
+						return rec
+					}
+
+					rec := do(http.MethodGet, "/schemas/schemas/account", "")
+					assert.Equal(t, http.StatusNotFound, rec.Code, rec.Body.String())
+
+					schema := `{"type":"object","properties":{"kyc_status":{"enum":["pending","verified"]}}}`
+					rec = do(http.MethodPut, "/schemas/schemas/account", schema)
+					assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+					rec = do(http.MethodPut, "/schemas/schemas/posting", schema)
+					assert.Equal(t, http.StatusBadRequest, rec.Code, rec.Body.String())
+					rec = do(http.MethodPut, "/schemas/schemas/account", `{"type":42}`)
+					assert.Equal(t, http.StatusBadRequest, rec.Code, rec.Body.String())
+
+					rec = do(http.MethodGet, "/schemas/schemas/account", "")
+					assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+					assert.Contains(t, rec.Body.String(), `"kyc_status"`)
+
+					rec = do(http.MethodPost, "/schemas/accounts/users:001/metadata", `{"kyc_status":"pending"}`)
+					assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+					// The fields not conforming to the schema are reported
+					rec = do(http.MethodPost, "/schemas/accounts/users:001/metadata", `{"kyc_status":"unknown"}`)
+					assert.Equal(t, http.StatusBadRequest, rec.Code, rec.Body.String())
+					assert.Contains(t, rec.Body.String(), `"errors":[{"field":"kyc_status"`)
+
+					rec = do(http.MethodDelete, "/schemas/schemas/account", "")
+					assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+					rec = do(http.MethodPost, "/schemas/accounts/users:001/metadata", `{"kyc_status":"unknown"}`)
+					assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+				})),
+			},
+		},
+		{
+			name: "revert_matching",
+			options: []option{
+				WithOption(fx.Provide(func() storage.Driver {
+					return memorystorage.NewDriver("memory")
+				})),
+				WithOption(fx.Invoke(func(t *testing.T, api *api.API) {
+					post := func(path, body string) *httptest.ResponseRecorder {
+						req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+						req.Header.Set("Content-Type", "application/json")
+						rec := httptest.NewRecorder()
+						api.ServeHTTP(rec, req)
+						return rec
+					}
+
+					for i, ref := range []string{"import_1", "payment_1", "import_2"} {
+						rec := post("/reverts/transactions", `{"postings":[{"source":"world","destination":"users:001","amount":10,"asset":"COIN"}],"reference":"`+ref+`"}`)
+						assert.Equal(t, http.StatusOK, rec.Code, i)
+					}
+
+					rec := post("/reverts/transactions/revert?confirm=true", "")
+					assert.Equal(t, http.StatusBadRequest, rec.Code, rec.Body.String())
+					rec = post("/reverts/transactions/revert?reference_prefix=import_", "")
+					assert.Equal(t, http.StatusBadRequest, rec.Code, rec.Body.String())
+					rec = post("/reverts/transactions/revert?reference_prefix=import_&after_timestamp=yesterday&confirm=true", "")
+					assert.Equal(t, http.StatusBadRequest, rec.Code, rec.Body.String())
+					assert.Contains(t, rec.Body.String(), "after_timestamp")
+
+					rec = post("/reverts/transactions/revert?reference_prefix=import_&dry_run=true", "")
+					assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+					assert.Contains(t, rec.Body.String(), `"reference":"revert_import_2"`)
+
+					rec = post("/reverts/transactions/revert?reference_prefix=import_&confirm=true", "")
+					assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+					assert.Contains(t, rec.Body.String(), `"reference":"revert_import_1"`)
+
+					rec = httptest.NewRecorder()
+					api.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/reverts/transactions?reference_prefix=revert_", nil))
+					assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+					assert.Contains(t, rec.Body.String(), `"reference":"revert_import_2"`)
+					assert.Contains(t, rec.Body.String(), `"reference":"revert_import_1"`)
+				})),
+			},
+		},
+		{
+			name: "search",
+			options: []option{
+				WithOption(fx.Provide(func() storage.Driver {
+					return memorystorage.NewDriver("memory")
+				})),
+				WithOption(fx.Invoke(func(t *testing.T, api *api.API) {
+					for i, tx := range []string{
+						`{"postings":[{"source":"world","destination":"users:001","amount":10,"asset":"COIN"}],"reference":"first","metadata":{"kind":"payin"}}`,
+						`{"postings":[{"source":"world","destination":"users:002","amount":10,"asset":"COIN"}],"metadata":{"kind":"payin"}}`,
+						`{"postings":[{"source":"world","destination":"users:001","amount":10,"asset":"GEM"}],"metadata":{"kind":"payin"}}`,
+						`{"postings":[{"source":"world","destination":"users:001","amount":10,"asset":"COIN"}],"metadata":{"kind":"fee"}}`,
+					} {
+						req := httptest.NewRequest(http.MethodPost, "/search/transactions", strings.NewReader(tx))
+						req.Header.Set("Content-Type", "application/json")
+						rec := httptest.NewRecorder()
+						api.ServeHTTP(rec, req)
+						assert.Equal(t, http.StatusOK, rec.Code, i)
+					}
+
+					get := func(params string) *httptest.ResponseRecorder {
+						rec := httptest.NewRecorder()
+						api.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/search/transactions?"+params, nil))
+						return rec
+					}
+					ids := func(params string) []int64 {
+						rec := get(params)
+						assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+						res := struct {
+							Cursor struct {
+								Data []core.Transaction `json:"data"`
+							} `json:"cursor"`
+						}{}
+						assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+						ids := []int64{}
+						for _, tx := range res.Cursor.Data {
+							ids = append(ids, tx.ID)
+						}
+						return ids
+					}
+
+					// The filters add up
+					assert.Equal(t, []int64{3, 2, 0}, ids("account=users:001"))
+					assert.Equal(t, []int64{0, 3}, ids("account=users:001&asset=COIN&order=asc"))
+					assert.Equal(t, []int64{0}, ids("account=users:001&asset=COIN&metadata[kind]=payin"))
+					assert.Equal(t, []int64{0}, ids("reference=first&asset=COIN"))
+					assert.Equal(t, []int64{}, ids("reference=first&asset=GEM"))
+					assert.Equal(t, []int64{0, 1}, ids("order=asc&limit=2"))
+
+					for param, value := range map[string]string{
+						"order":            "up",
+						"after_timestamp":  "yesterday",
+						"before_timestamp": "2021",
+						"page_size":        "-1",
+						"limit":            "many",
+					} {
+						rec := get(param + "=" + value)
+						assert.Equal(t, http.StatusBadRequest, rec.Code, param)
+						assert.Contains(t, rec.Body.String(), "'"+param+"'", param)
+					}
+				})),
+			},
+		},
+		{
+			name: "ratelimit",
+			options: []option{
+				WithRateLimits(middlewares.RateLimits{
+					Read:  middlewares.RateLimit{Rate: 0.001, Burst: 2},
+					Write: middlewares.RateLimit{Rate: 0.001, Burst: 1},
+				}),
+				WithOption(fx.Provide(func() storage.Driver {
+					return memorystorage.NewDriver("memory")
+				})),
+				WithOption(fx.Invoke(func(t *testing.T, api *api.API) {
+					for i := 0; i < 2; i++ {
+						rec := httptest.NewRecorder()
+						api.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/limited/transactions", nil))
+						assert.Equal(t, http.StatusOK, rec.Code)
+					}
+
+					rec := httptest.NewRecorder()
+					api.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/limited/transactions", nil))
+					assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+					assert.Equal(t, "1000", rec.Header().Get("Retry-After"))
+
+					// The other ledgers share the bucket of the client, which chooses them
+					rec = httptest.NewRecorder()
+					api.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/other/transactions", nil))
+					assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+
+					// The writes and the other clients have their own buckets
+					for _, status := range []int{http.StatusOK, http.StatusTooManyRequests} {
+						req := httptest.NewRequest(http.MethodPost, "/limited/transactions", strings.NewReader(
+							`{"postings":[{"source":"world","destination":"users:001","amount":100,"asset":"COIN"}]}`,
+						))
+						req.Header.Set("Content-Type", "application/json")
+						rec = httptest.NewRecorder()
+						api.ServeHTTP(rec, req)
+						assert.Equal(t, status, rec.Code)
+					}
+
+					req := httptest.NewRequest(http.MethodGet, "/limited/transactions", nil)
+					req.RemoteAddr = "192.0.2.2:1234"
+					rec = httptest.NewRecorder()
+					api.ServeHTTP(rec, req)
+					assert.Equal(t, http.StatusOK, rec.Code)
+				})),
+			},
+		},
+		{
+			name: "auth",
+			options: []option{
+				WithHttpBasicAuth("admin:secret"),
+				WithAPIKeys(
+					middlewares.APIKey{Name: "reader", Key: "reader-key", Scopes: []string{"ledger:*:*:read"}},
+					middlewares.APIKey{Name: "poster", Key: "poster-key", Scopes: []string{
+						"ledger:scoped:transactions:write",
+						"ledger:scoped:transactions:read",
+					}},
+					middlewares.APIKey{Name: "writer", Key: "writer-key", Scopes: []string{"ledger:scoped:*:write"}},
+					middlewares.APIKey{Name: "owner", Key: "owner-key", Scopes: []string{"ledger:scoped:ledger:admin"}},
+					middlewares.APIKey{Name: "operator", Key: "operator-key", Scopes: []string{
+						"ledger:*:ledgers:read",
+						"ledger:*:metrics:read",
+					}},
+				),
+				WithMetrics(true),
+				WithLedgerDeletion(true),
+				WithOption(fx.Provide(func() storage.Driver {
+					return memorystorage.NewDriver("memory")
+				})),
+				WithOption(fx.Invoke(func(t *testing.T, api *api.API) {
+					request := func(method, target, body string, auth func(r *http.Request)) int {
+						req := httptest.NewRequest(method, target, strings.NewReader(body))
+						req.Header.Set("Content-Type", "application/json")
+						auth(req)
+						rec := httptest.NewRecorder()
+						api.ServeHTTP(rec, req)
+						return rec.Code
+					}
+					none := func(r *http.Request) {}
+					basic := func(user, password string) func(r *http.Request) {
+						return func(r *http.Request) {
+							r.SetBasicAuth(user, password)
+						}
+					}
+					bearer := func(key string) func(r *http.Request) {
+						return func(r *http.Request) {
+							r.Header.Set("Authorization", "Bearer "+key)
+						}
+					}
+					tx := `{"postings":[{"source":"world","destination":"users:001","amount":100,"asset":"COIN"}]}`
+
+					assert.Equal(t, http.StatusUnauthorized, request(http.MethodGet, "/scoped/transactions", "", none))
+					assert.Equal(t, http.StatusUnauthorized, request(http.MethodGet, "/scoped/transactions", "", basic("admin", "wrong")))
+					assert.Equal(t, http.StatusUnauthorized, request(http.MethodGet, "/scoped/transactions", "", bearer("unknown")))
+					assert.Equal(t, http.StatusOK, request(http.MethodGet, "/scoped/transactions", "", basic("admin", "secret")))
+
+					assert.Equal(t, http.StatusOK, request(http.MethodGet, "/scoped/accounts", "", bearer("reader-key")))
+					assert.Equal(t, http.StatusForbidden, request(http.MethodPost, "/scoped/transactions", tx, bearer("reader-key")))
+
+					assert.Equal(t, http.StatusOK, request(http.MethodPost, "/scoped/transactions", tx, bearer("poster-key")))
+					assert.Equal(t, http.StatusForbidden, request(http.MethodPost, "/scoped/transactions/0/revert", "", bearer("poster-key")))
+					assert.Equal(t, http.StatusForbidden, request(http.MethodGet, "/scoped/accounts", "", bearer("poster-key")))
+					assert.Equal(t, http.StatusForbidden, request(http.MethodGet, "/other/transactions", "", bearer("poster-key")))
+					assert.Equal(t, http.StatusOK, request(http.MethodPost, "/scoped/transactions/0/revert", "", basic("admin", "secret")))
+
+					// The list of the ledgers and the metrics need their own scopes, whatever the scopes on the ledgers
+					for _, target := range []string{"/_ledgers", "/metrics"} {
+						assert.Equal(t, http.StatusForbidden, request(http.MethodGet, target, "", bearer("poster-key")), target)
+						assert.Equal(t, http.StatusForbidden, request(http.MethodGet, target, "", bearer("writer-key")), target)
+						assert.Equal(t, http.StatusOK, request(http.MethodGet, target, "", bearer("operator-key")), target)
+					}
+					assert.Equal(t, http.StatusForbidden, request(http.MethodGet, "/scoped/transactions", "", bearer("operator-key")))
+
+					// Deleting a ledger needs the admin scope of the ledger, the writes not being enough
+					assert.Equal(t, http.StatusForbidden, request(http.MethodDelete, "/scoped?confirm=scoped", "", bearer("writer-key")))
+					assert.Equal(t, http.StatusForbidden, request(http.MethodDelete, "/scoped?confirm=scoped", "", bearer("poster-key")))
+					assert.Equal(t, http.StatusForbidden, request(http.MethodDelete, "/other?confirm=other", "", bearer("owner-key")))
+					assert.Equal(t, http.StatusOK, request(http.MethodDelete, "/scoped?confirm=scoped", "", bearer("owner-key")))
+
+					// The probes are served without credentials
+					assert.Equal(t, http.StatusOK, request(http.MethodGet, "/_healthz", "", none))
+					assert.Equal(t, http.StatusOK, request(http.MethodGet, "/_readyz", "", none))
+					assert.Equal(t, http.StatusUnauthorized, request(http.MethodGet, "/_info", "", none))
+				})),
+			},
+		},
+		{
+			name: "jwt",
+			options: []option{
+				WithHttpBasicAuth("admin:secret"),
+				WithJWT(middlewares.JWTConfig{
+					Secret:       "jwt-secret",
+					Audience:     "ledger",
+					LedgersClaim: "ledgers",
+					ScopeMapping: map[string][]string{
+						"Reader": {"ledger:{ledger}:*:read"},
+					},
+				}),
+				WithOption(fx.Provide(func() storage.Driver {
+					return memorystorage.NewDriver("memory")
+				})),
+				WithOption(fx.Invoke(func(t *testing.T, api *api.API) {
+					sign := func(claims map[string]interface{}) string {
+						return signJWT(t, "HS256", claims, func(signed []byte) []byte {
+							mac := hmac.New(sha256.New, []byte("jwt-secret"))
+							mac.Write(signed)
+							return mac.Sum(nil)
+						})
+					}
+					request := func(method, target, token string) int {
+						req := httptest.NewRequest(method, target, nil)
+						req.Header.Set("Authorization", "Bearer "+token)
+						rec := httptest.NewRecorder()
+						api.ServeHTTP(rec, req)
+						return rec.Code
+					}
+					exp := time.Now().Add(time.Minute).Unix()
+
+					reader := sign(map[string]interface{}{"sub": "reader", "aud": "ledger", "exp": exp, "scope": "reader", "ledgers": []string{"jwt"}})
+					assert.Equal(t, http.StatusOK, request(http.MethodGet, "/jwt/transactions", reader))
+					assert.Equal(t, http.StatusForbidden, request(http.MethodGet, "/other/transactions", reader))
+					assert.Equal(t, http.StatusForbidden, request(http.MethodPost, "/jwt/transactions", reader))
+
+					writer := sign(map[string]interface{}{"sub": "writer", "aud": []string{"ledger"}, "exp": exp, "scope": []string{"ledger:jwt:accounts:write"}})
+					assert.Equal(t, http.StatusOK, request(http.MethodPost, "/jwt/accounts/users:001/metadata", writer))
+					assert.Equal(t, http.StatusForbidden, request(http.MethodGet, "/jwt/accounts", writer))
+
+					expired := sign(map[string]interface{}{"sub": "reader", "aud": "ledger", "exp": time.Now().Add(-time.Minute).Unix(), "scope": "reader"})
+					assert.Equal(t, http.StatusUnauthorized, request(http.MethodGet, "/jwt/transactions", expired))
+					audience := sign(map[string]interface{}{"sub": "reader", "aud": "other", "exp": exp, "scope": "reader"})
+					assert.Equal(t, http.StatusUnauthorized, request(http.MethodGet, "/jwt/transactions", audience))
+					assert.Equal(t, http.StatusUnauthorized, request(http.MethodGet, "/jwt/transactions", reader[:len(reader)-2]))
+
+					// Only the allowed algorithms are accepted, whatever the signature
+					claims := map[string]interface{}{"sub": "reader", "aud": "ledger", "exp": exp, "scope": "reader", "ledgers": []string{"jwt"}}
+					for _, alg := range []string{"SSS256", "HHS256", "none", "RS256"} {
+						token := signJWT(t, alg, claims, func(signed []byte) []byte {
+							mac := hmac.New(sha256.New, []byte("jwt-secret"))
+							mac.Write(signed)
+							return mac.Sum(nil)
+						})
+						assert.Equal(t, http.StatusUnauthorized, request(http.MethodGet, "/jwt/transactions", token), alg)
+					}
+
+					// The other schemes still apply
+					req := httptest.NewRequest(http.MethodGet, "/jwt/transactions", nil)
+					req.SetBasicAuth("admin", "secret")
+					rec := httptest.NewRecorder()
+					api.ServeHTTP(rec, req)
+					assert.Equal(t, http.StatusOK, rec.Code)
+				})),
+			},
+		},
+		{
+			name: "jwks",
+			options: []option{
+				WithJWT(middlewares.JWTConfig{
+					JWKSURL: jwksServer.URL,
+				}),
+				WithOption(fx.Provide(func() storage.Driver {
+					return memorystorage.NewDriver("memory")
+				})),
+				WithOption(fx.Invoke(func(t *testing.T, api *api.API) {
+					token := signJWT(t, "RS256", map[string]interface{}{
+						"sub":   "reader",
+						"exp":   time.Now().Add(time.Minute).Unix(),
+						"scope": "ledger:jwks:*:read",
+					}, func(signed []byte) []byte {
+						digest := sha256.Sum256(signed)
+						signature, err := rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, digest[:])
+						if err != nil {
+							t.Fatal(err)
+						}
+						return signature
+					})
+
+					req := httptest.NewRequest(http.MethodGet, "/jwks/transactions", nil)
+					req.Header.Set("Authorization", "Bearer "+token)
+					rec := httptest.NewRecorder()
+					api.ServeHTTP(rec, req)
+					assert.Equal(t, http.StatusOK, rec.Code)
+
+					req = httptest.NewRequest(http.MethodGet, "/jwks/transactions", nil)
+					req.Header.Set("Authorization", "Bearer "+token+"A")
+					rec = httptest.NewRecorder()
+					api.ServeHTTP(rec, req)
+					assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+					// A token signed with the modulus of the public key as hmac secret is not accepted
+					forged := signJWT(t, "HS256", map[string]interface{}{
+						"sub":   "reader",
+						"exp":   time.Now().Add(time.Minute).Unix(),
+						"scope": "ledger:jwks:*:read",
+					}, func(signed []byte) []byte {
+						mac := hmac.New(sha256.New, rsaKey.N.Bytes())
+						mac.Write(signed)
+						return mac.Sum(nil)
+					})
+					req = httptest.NewRequest(http.MethodGet, "/jwks/transactions", nil)
+					req.Header.Set("Authorization", "Bearer "+forged)
+					rec = httptest.NewRecorder()
+					api.ServeHTTP(rec, req)
+					assert.Equal(t, http.StatusUnauthorized, rec.Code)
+				})),
+			},
+		},
+		{
+			name: "revert_links",
+			options: []option{
+				WithOption(fx.Provide(func() storage.Driver {
+					return memorystorage.NewDriver("memory")
+				})),
+				WithOption(fx.Invoke(func(t *testing.T, resolver *ledger.Resolver, api *api.API) {
+					l, err := resolver.GetLedger(context.Background(), "links")
+					assert.NoError(t, err)
+					_, err = l.Commit(context.Background(), []core.Transaction{{
+						Postings: []core.Posting{{Source: "world", Destination: "users:001", Amount: 100, Asset: "COIN"}},
+					}})
+					assert.NoError(t, err)
+					assert.NoError(t, l.RevertTransaction(context.Background(), "0"))
+
+					get := func(id string) map[string]interface{} {
+						rec := httptest.NewRecorder()
+						api.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/links/transactions/"+id, nil))
+						assert.Equal(t, http.StatusOK, rec.Code)
+						res := struct {
+							Data map[string]interface{} `json:"data"`
+						}{}
+						assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+						return res.Data
+					}
+
+					reverted := get("0")
+					assert.EqualValues(t, 1, reverted["reverted_by"])
+					assert.NotContains(t, reverted, "reverts")
+					assert.Contains(t, reverted, "postings")
+
+					revert := get("1")
+					assert.EqualValues(t, 0, revert["reverts"])
+					assert.NotContains(t, revert, "reverted_by")
+				})),
+			},
+		},
+		{
+			name: "request_id",
+			options: []option{
+				WithHttpBasicAuth("admin:secret"),
+				WithAccessLogFormat(routes.LogFormatPretty),
+				WithOption(fx.Provide(func() storage.Driver {
+					return memorystorage.NewDriver("memory")
+				})),
+				WithOption(fx.Invoke(func(t *testing.T, api *api.API) {
+					req := httptest.NewRequest(http.MethodGet, "/traced/transactions", nil)
+					req.Header.Set(middlewares.RequestIDHeader, "client-id.1")
+					req.SetBasicAuth("admin", "secret")
+					rec := httptest.NewRecorder()
+					api.ServeHTTP(rec, req)
+					assert.Equal(t, http.StatusOK, rec.Code)
+					assert.Equal(t, "client-id.1", rec.Header().Get(middlewares.RequestIDHeader))
+
+					// An id is generated if missing or invalid, and returned in the errors
+					req = httptest.NewRequest(http.MethodGet, "/traced/transactions/42", nil)
+					req.Header.Set(middlewares.RequestIDHeader, "not a valid id")
+					req.SetBasicAuth("admin", "secret")
+					rec = httptest.NewRecorder()
+					api.ServeHTTP(rec, req)
+					assert.Equal(t, http.StatusNotFound, rec.Code)
+					id := rec.Header().Get(middlewares.RequestIDHeader)
+					assert.NotEmpty(t, id)
+					assert.NotEqual(t, "not a valid id", id)
+					assert.Contains(t, rec.Body.String(), `"request_id":"`+id+`"`)
+
+					rec = httptest.NewRecorder()
+					api.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/traced/transactions", nil))
+					assert.Equal(t, http.StatusUnauthorized, rec.Code)
+					assert.Contains(t, rec.Body.String(), `"request_id":"`+rec.Header().Get(middlewares.RequestIDHeader)+`"`)
+				})),
+			},
+		},
+		{
+			name: "cors",
+			options: []option{
+				WithHttpBasicAuth("admin:secret"),
+				WithCORS(api.CORSConfig{
+					AllowedOrigins:   []string{"https://app.example.com", "https://*.example.org"},
+					AllowedMethods:   []string{http.MethodGet, http.MethodPost},
+					AllowedHeaders:   []string{"Content-Type", "Authorization"},
+					AllowCredentials: true,
+					MaxAge:           time.Hour,
+				}),
+				WithOption(fx.Provide(func() storage.Driver {
+					return memorystorage.NewDriver("memory")
+				})),
+				WithOption(fx.Invoke(func(t *testing.T, api *api.API) {
+					preflight := func(origin string) *httptest.ResponseRecorder {
+						req := httptest.NewRequest(http.MethodOptions, "/cors/transactions", nil)
+						req.Header.Set("Origin", origin)
+						req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+						req.Header.Set("Access-Control-Request-Headers", "Content-Type")
+						rec := httptest.NewRecorder()
+						api.ServeHTTP(rec, req)
+						return rec
+					}
+
+					for _, origin := range []string{"https://app.example.com", "https://admin.example.org"} {
+						rec := preflight(origin)
+						assert.Equal(t, http.StatusNoContent, rec.Code)
+						assert.Equal(t, origin, rec.Header().Get("Access-Control-Allow-Origin"))
+						assert.Equal(t, "true", rec.Header().Get("Access-Control-Allow-Credentials"))
+						assert.Equal(t, "GET,POST", rec.Header().Get("Access-Control-Allow-Methods"))
+						assert.Equal(t, "Content-Type,Authorization", rec.Header().Get("Access-Control-Allow-Headers"))
+						assert.Equal(t, "3600", rec.Header().Get("Access-Control-Max-Age"))
+					}
+
+					rec := preflight("https://evil.example.net")
+					assert.Equal(t, http.StatusForbidden, rec.Code)
+					assert.Empty(t, rec.Header().Get("Access-Control-Allow-Origin"))
+				})),
+			},
+		},
+		{
+			name: "audit",
+			options: []option{
+				WithHttpBasicAuth("admin:secret"),
+				WithAudit(sink, false),
+				WithOption(fx.Provide(func() storage.Driver {
+					return memorystorage.NewDriver("memory")
+				})),
+				WithOption(fx.Invoke(func(t *testing.T, api *api.API) {
+					req := httptest.NewRequest(http.MethodPost, "/audited/transactions", strings.NewReader(
+						`{"postings":[{"source":"world","destination":"users:001","amount":100,"asset":"COIN"}]}`,
+					))
+					req.Header.Set("Content-Type", "application/json")
+					req.SetBasicAuth("admin", "secret")
+					rec := httptest.NewRecorder()
+					api.ServeHTTP(rec, req)
+					assert.Equal(t, http.StatusOK, rec.Code)
+
+					req = httptest.NewRequest(http.MethodPost, "/audited/transactions/0/revert", nil)
+					req.SetBasicAuth("admin", "secret")
+					rec = httptest.NewRecorder()
+					api.ServeHTTP(rec, req)
+					assert.Equal(t, http.StatusOK, rec.Code)
+
+					// The reads are not recorded
+					req = httptest.NewRequest(http.MethodGet, "/audited/transactions", nil)
+					req.SetBasicAuth("admin", "secret")
+					rec = httptest.NewRecorder()
+					api.ServeHTTP(rec, req)
+					assert.Equal(t, http.StatusOK, rec.Code)
+
+					if !assert.Len(t, sink.records, 2) {
+						return
+					}
+					assert.Equal(t, audit.StageCompleted, sink.records[0].Stage)
+					assert.Equal(t, "audited", sink.records[0].Ledger)
+					assert.Equal(t, "admin", sink.records[0].Principal)
+					assert.Equal(t, "192.0.2.1", sink.records[0].IP)
+					assert.Equal(t, "POST /:ledger/transactions", sink.records[0].Action)
+					assert.Equal(t, http.StatusOK, sink.records[0].Status)
+					assert.Equal(t, []int64{0}, sink.records[0].Transactions)
+					assert.Equal(t, "/audited/transactions/0/revert", sink.records[1].Path)
+					assert.Equal(t, []int64{1}, sink.records[1].Transactions)
+				})),
+			},
+		},
+		{
+			name: "audit_blocking",
+			options: []option{
+				WithAudit(&auditSink{err: errors.New("unavailable")}, true),
+				WithOption(fx.Provide(func() storage.Driver {
+					return memorystorage.NewDriver("memory")
+				})),
+				WithOption(fx.Invoke(func(t *testing.T, api *api.API) {
+					req := httptest.NewRequest(http.MethodPost, "/blocked/transactions", strings.NewReader(
+						`{"postings":[{"source":"world","destination":"users:001","amount":100,"asset":"COIN"}]}`,
+					))
+					req.Header.Set("Content-Type", "application/json")
+					rec := httptest.NewRecorder()
+					api.ServeHTTP(rec, req)
+					assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+
+					rec = httptest.NewRecorder()
+					api.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/blocked/stats", nil))
+					assert.Equal(t, http.StatusOK, rec.Code)
+					assert.Contains(t, rec.Body.String(), `"transactions":0`)
+				})),
+			},
+		},
+		{
+			name: "webhooks",
+			options: []option{
+				WithWebhooks(true, ledger.DefaultWebhookConfig),
+				WithOption(fx.Provide(func() storage.Driver {
+					return memorystorage.NewDriver("memory")
+				})),
+				WithOption(fx.Invoke(func(t *testing.T, api *api.API) {
+					post := func(body string) *httptest.ResponseRecorder {
+						req := httptest.NewRequest(http.MethodPost, "/hooked/webhooks", strings.NewReader(body))
+						req.Header.Set("Content-Type", "application/json")
+						rec := httptest.NewRecorder()
+						api.ServeHTTP(rec, req)
+						return rec
+					}
+
+					rec := post(`{"url":"localhost/hook"}`)
+					assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+					rec = post(`{"url":"http://localhost/hook","accounts":["users:*"]}`)
+					assert.Equal(t, http.StatusOK, rec.Code)
+					var registered struct {
+						Data core.Webhook `json:"data"`
+					}
+					assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &registered))
+					assert.NotEmpty(t, registered.Data.Secret)
+					assert.Equal(t, []string{"users:*"}, registered.Data.Accounts)
+
+					// The secret is only returned by the registration
+					rec = httptest.NewRecorder()
+					api.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/hooked/webhooks", nil))
+					assert.Equal(t, http.StatusOK, rec.Code)
+					assert.Contains(t, rec.Body.String(), registered.Data.ID)
+					assert.NotContains(t, rec.Body.String(), registered.Data.Secret)
+
+					rec = httptest.NewRecorder()
+					api.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/hooked/webhooks/"+registered.Data.ID+"/deliveries", nil))
+					assert.Equal(t, http.StatusOK, rec.Code)
+					assert.JSONEq(t, `{"ok":true,"data":[]}`, rec.Body.String())
+
+					rec = httptest.NewRecorder()
+					api.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/hooked/webhooks/unknown/deliveries", nil))
+					assert.Equal(t, http.StatusNotFound, rec.Code)
+				})),
+			},
+		},
+		{
+			name: "health",
+			options: []option{
+				WithOption(fx.Provide(func(t *testing.T) *miniredis.Miniredis {
+					server, err := miniredis.Run()
+					if err != nil {
+						t.Fatal(err)
+					}
+					return server
+				})),
+				WithOption(fx.Provide(func(server *miniredis.Miniredis) storage.Driver {
+					return redisstorage.NewDriver("redis", &redis.UniversalOptions{
+						Addrs: []string{server.Addr()},
+					})
+				})),
+				WithOption(fx.Invoke(func(t *testing.T, server *miniredis.Miniredis, resolver *ledger.Resolver, api *api.API) {
+					_, err := resolver.GetLedger(context.Background(), "health")
+					assert.NoError(t, err)
+
+					rec := httptest.NewRecorder()
+					api.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/_readyz", nil))
+					assert.Equal(t, http.StatusOK, rec.Code)
+					assert.JSONEq(t, `{"ok":true,"data":{"ready":true,"ledgers":{"health":{"backend":"redis","ready":true}}}}`, rec.Body.String())
+
+					// The liveness doesn't depend on the storage
+					server.Close()
+
+					rec = httptest.NewRecorder()
+					api.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/_healthz", nil))
+					assert.Equal(t, http.StatusOK, rec.Code)
+
+					rec = httptest.NewRecorder()
+					api.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/_readyz", nil))
+					assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+					assert.Contains(t, rec.Body.String(), `"health":{"backend":"redis","ready":false,"error":`)
+				})),
+			},
+		},
+		{
+			name: "config_reload",
+			options: []option{
+				WithAPIKeys(
+					middlewares.APIKey{Name: "reader", Key: "reader-key", Scopes: []string{"ledger:*:*:read"}},
+					middlewares.APIKey{Name: "owner", Key: "owner-key", Scopes: []string{"ledger:reloaded"}},
+					middlewares.APIKey{Name: "ops", Key: "ops-key", Scopes: []string{"ledger:*:config:write"}},
+				),
+				WithCORS(api.CORSConfig{
+					AllowedOrigins: []string{"https://app.example.com"},
+					AllowedMethods: []string{http.MethodGet},
+				}),
+				WithConfigReload(func() (ReloadableConfig, error) {
+					return reloaded, reloadErr
+				}),
+				WithOption(fx.Provide(func() storage.Driver {
+					return memorystorage.NewDriver("memory")
+				})),
+				WithOption(fx.Invoke(func(t *testing.T, api *api.API) {
+					request := func(method, target, key string, header http.Header) *httptest.ResponseRecorder {
+						req := httptest.NewRequest(method, target, nil)
+						req.Header.Set("Authorization", "Bearer "+key)
+						for name, values := range header {
+							req.Header[name] = values
+						}
+						rec := httptest.NewRecorder()
+						api.ServeHTTP(rec, req)
+						return rec
+					}
+					reload := func(key string) *httptest.ResponseRecorder {
+						return request(http.MethodPost, "/_config/reload", key, nil)
+					}
+					origin := func() string {
+						return request(http.MethodGet, "/reloaded/transactions", "reader-key", http.Header{
+							"Origin": []string{"https://admin.example.com"},
+						}).Header().Get("Access-Control-Allow-Origin")
+					}
+
+					// The reloads need the config scope on all the ledgers
+					assert.Equal(t, http.StatusForbidden, reload("reader-key").Code)
+					assert.Equal(t, http.StatusForbidden, reload("owner-key").Code)
+
+					assert.Empty(t, origin())
+					for i := 0; i < 3; i++ {
+						assert.Equal(t, http.StatusOK, request(http.MethodGet, "/reloaded/transactions", "reader-key", nil).Code)
+					}
+
+					level := logrus.GetLevel()
+					defer logrus.SetLevel(level)
+					rec := reload("ops-key")
+					assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+					assert.Equal(t, logrus.DebugLevel, logrus.GetLevel())
+
+					rec = request(http.MethodGet, "/_info", "reader-key", nil)
+					assert.Contains(t, rec.Body.String(), `"limits":{"max_postings_per_transaction":1,"max_transactions_per_batch":1,"default_page_size":5,"max_page_size":10}`)
+					rec = request(http.MethodGet, "/reloaded/transactions?limit=1000", "reader-key", nil)
+					assert.Equal(t, http.StatusOK, rec.Code)
+					assert.Contains(t, rec.Body.String(), `"page_size":10`)
+					rec = request(http.MethodGet, "/reloaded/transactions", "reader-key", nil)
+					assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+
+					// The CORS headers are set on the rejected requests too
+					assert.Equal(t, "https://admin.example.com", origin())
+
+					// An invalid configuration isn't applied
+					reloaded.LogLevel = logrus.InfoLevel
+					reloaded.Limits.DefaultPageSize = 100
+					rec = reload("ops-key")
+					assert.Equal(t, http.StatusInternalServerError, rec.Code)
+					assert.Contains(t, rec.Body.String(), "invalid default page size 100")
+					assert.Equal(t, logrus.DebugLevel, logrus.GetLevel())
+
+					reloaded.Limits.DefaultPageSize = 5
+					reloadErr = errors.New("unreadable config file")
+					rec = reload("ops-key")
+					assert.Equal(t, http.StatusInternalServerError, rec.Code)
+					assert.Contains(t, rec.Body.String(), "unreadable config file")
+				})),
+			},
+		},
 	} {
 		t.Run(tc.name, func(t *testing.T) {
 			run := make(chan struct{}, 1)
@@ -70,3 +1383,29 @@ func TestContainers(t *testing.T) {
 	}
 
 }
+
+// signJWT builds a token with the given claims, signed by sign
+func signJWT(t *testing.T, alg string, claims map[string]interface{}, sign func(signed []byte) []byte) string {
+	encode := func(v interface{}) string {
+		data, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+	signed := encode(map[string]string{"alg": alg, "typ": "JWT", "kid": "key"}) + "." + encode(claims)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sign([]byte(signed)))
+}
+
+type auditSink struct {
+	records []audit.Record
+	err     error
+}
+
+func (s *auditSink) Write(ctx context.Context, record audit.Record) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.records = append(s.records, record)
+	return nil
+}
@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/numary/ledger/pkg/api"
+	"github.com/numary/ledger/pkg/api/middlewares"
+	"github.com/numary/ledger/pkg/ledger"
+	"github.com/sirupsen/logrus"
+)
+
+// ReloadableConfig are the settings which can be changed without restarting the server, see WithConfigReload.
+// The storage, the authentication and the other settings are only read at startup.
+type ReloadableConfig struct {
+	LogLevel   logrus.Level
+	Limits     ledger.Limits
+	RateLimits middlewares.RateLimits
+	CORS       api.CORSConfig
+}
+
+// Validate checks the settings before they are applied
+func (c ReloadableConfig) Validate() error {
+	if c.Limits.MaxPageSize < 1 {
+		return fmt.Errorf("invalid max page size %d: expected at least 1", c.Limits.MaxPageSize)
+	}
+	if size := c.Limits.DefaultPageSize; size < 1 || size > c.Limits.MaxPageSize {
+		return fmt.Errorf("invalid default page size %d: expected an integer between 1 and the max page size", size)
+	}
+	return c.CORS.Validate()
+}
+
+// ConfigReloader reads the reloadable settings again and applies them to the components observing them
+type ConfigReloader struct {
+	mu        sync.Mutex
+	read      func() (ReloadableConfig, error)
+	observers []func(ReloadableConfig) error
+}
+
+// NewConfigReloader returns a reloader reading the settings with the given function
+func NewConfigReloader(read func() (ReloadableConfig, error)) *ConfigReloader {
+	return &ConfigReloader{
+		read: read,
+	}
+}
+
+// Observe registers a function applying the settings, called by each reload
+func (r *ConfigReloader) Observe(apply func(ReloadableConfig) error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.observers = append(r.observers, apply)
+}
+
+// Reload reads the settings and applies them, one reload at a time.
+// Nothing is applied if the settings can't be read or are invalid.
+func (r *ConfigReloader) Reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cfg, err := r.read()
+	if err != nil {
+		return fmt.Errorf("reading the configuration: %w", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	for _, apply := range r.observers {
+		if err := apply(cfg); err != nil {
+			return fmt.Errorf("applying the configuration: %w", err)
+		}
+	}
+	logrus.Infof("configuration reloaded")
+
+	return nil
+}
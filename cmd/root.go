@@ -6,9 +6,16 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
 	"github.com/numary/ledger/pkg/api"
 	"github.com/numary/ledger/pkg/api/controllers"
+	"github.com/numary/ledger/pkg/api/middlewares"
+	"github.com/numary/ledger/pkg/api/routes"
+	"github.com/numary/ledger/pkg/audit"
+	"github.com/numary/ledger/pkg/ledger"
 	"github.com/numary/ledger/pkg/storage"
+	"github.com/numary/ledger/pkg/storage/memorystorage"
+	"github.com/numary/ledger/pkg/storage/redisstorage"
 	"github.com/numary/ledger/pkg/storage/sqlstorage"
 	"github.com/numary/machine/script/compiler"
 	"github.com/pkg/errors"
@@ -20,9 +27,13 @@ import (
 	"net"
 	"net/http"
 	"os"
+	"os/signal"
 	"path"
+	"reflect"
 	"regexp"
 	"strings"
+	"syscall"
+	"time"
 )
 
 var (
@@ -32,8 +43,6 @@ var (
 )
 
 func NewRootCommand() *cobra.Command {
-	viper.SetDefault("version", Version)
-
 	root := &cobra.Command{
 		Use:               "numary",
 		Short:             "Numary",
@@ -63,9 +72,16 @@ func NewRootCommand() *cobra.Command {
 
 	start := &cobra.Command{
 		Use: "start",
+		Long: "Start the server.\n\n" +
+			"The settings below are read again from the config file and the environment when the server receives " +
+			"a SIGHUP or a POST /_config/reload request, the others being ignored until it restarts:\n  " +
+			strings.Join(reloadableKeys, "\n  "),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			app, err := createContainer(
-				WithOption(fx.Invoke(func(h *api.API) error {
+				WithConfigReload(func() (ReloadableConfig, error) {
+					return readReloadableConfig(cmd.Root())
+				}),
+				WithOption(fx.Invoke(func(h *api.API, reloader *ConfigReloader) error {
 					listener, err := net.Listen("tcp", viper.GetString("server.http.bind_address"))
 					if err != nil {
 						return err
@@ -82,6 +98,22 @@ func NewRootCommand() *cobra.Command {
 						}
 					}()
 
+					hup := make(chan os.Signal, 1)
+					signal.Notify(hup, syscall.SIGHUP)
+					go func() {
+						defer signal.Stop(hup)
+						for {
+							select {
+							case <-cmd.Context().Done():
+								return
+							case <-hup:
+								if err := reloader.Reload(); err != nil {
+									logrus.Errorf("reloading the configuration: %s", err)
+								}
+							}
+						}
+					}()
+
 					return nil
 				})),
 			)
@@ -147,6 +179,85 @@ func NewRootCommand() *cobra.Command {
 		},
 	})
 
+	store.AddCommand(&cobra.Command{
+		Use:   "consolidate-assets [ledger]",
+		Short: "Move the balances of the assets which code isn't uppercase to the uppercase assets",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			_, err := createContainer(
+				WithOption(fx.Invoke(func(resolver *ledger.Resolver) error {
+					l, err := resolver.GetLedger(context.Background(), args[0])
+					if err != nil {
+						return err
+					}
+					defer l.Close(context.Background())
+
+					ts, err := l.ConsolidateAssets(context.Background())
+					for _, tx := range ts {
+						fmt.Printf("Committed transaction %d, %d postings\n", tx.ID, len(tx.Postings))
+					}
+					return err
+				})),
+			)
+			if err != nil {
+				return err
+			}
+			return nil
+		},
+	})
+
+	migrate := &cobra.Command{
+		Use:   "migrate [ledger]",
+		Short: "Apply the pending migrations of the schema of a ledger, which are otherwise applied when the ledger is first used",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dryRun, err := cmd.Flags().GetBool("dry-run")
+			if err != nil {
+				return err
+			}
+			_, err = createContainer(
+				WithOption(fx.Invoke(func(driver storage.Driver) error {
+					s, err := driver.NewStore(args[0])
+					if err != nil {
+						return err
+					}
+					defer s.Close(context.Background())
+
+					store, ok := s.(*sqlstorage.Store)
+					if !ok {
+						fmt.Printf("The %s storage has no schema to migrate\n", driver.Name())
+						return nil
+					}
+
+					pending, err := store.PendingMigrations(context.Background())
+					if err != nil {
+						return err
+					}
+					if len(pending) == 0 {
+						fmt.Println("The schema is up to date")
+						return nil
+					}
+					for _, m := range pending {
+						fmt.Printf("Pending migration %s, %d statements\n", m.Name, len(m.Statements))
+					}
+					if dryRun {
+						return nil
+					}
+
+					err = store.Initialize(context.Background())
+					if err != nil {
+						return err
+					}
+					fmt.Printf("Applied %d migrations\n", len(pending))
+					return nil
+				})),
+			)
+			return err
+		},
+	}
+	migrate.Flags().Bool("dry-run", false, "Print the pending migrations without applying them")
+	store.AddCommand(migrate)
+
 	scriptExec := &cobra.Command{
 		Use:  "exec [ledger] [script]",
 		Args: cobra.ExactArgs(2),
@@ -235,62 +346,392 @@ func NewRootCommand() *cobra.Command {
 	}
 
 	root.PersistentFlags().Bool("debug", false, "Debug mode")
-	root.PersistentFlags().String("storage.driver", "sqlite", "Storage driver")
+	root.PersistentFlags().String("storage.driver", "sqlite", "Storage driver: sqlite, postgres, memory, redis, or a driver registered with storage.RegisterDriver")
 	root.PersistentFlags().String("storage.dir", path.Join(home, ".numary/data"), "Storage directory (for sqlite)")
 	root.PersistentFlags().String("storage.sqlite.db_name", "numary", "SQLite database name")
+	root.PersistentFlags().String("storage.sqlite.journal_mode", sqlstorage.DefaultSQLiteConfig.JournalMode, "SQLite journal mode, WAL for the readers not to block the writer")
+	root.PersistentFlags().Duration("storage.sqlite.busy_timeout", sqlstorage.DefaultSQLiteConfig.BusyTimeout, "How long a SQLite writer waits for a concurrent one before failing")
 	root.PersistentFlags().String("storage.postgres.conn_string", "postgresql://localhost/postgres", "Postgre connection string")
+	root.PersistentFlags().String("storage.postgres.replica_conn_string", "", "Postgre read replica connection string, the queries which don't write are sent to it if set")
+	root.PersistentFlags().Int("storage.postgres.max_conns", 0, "Maximum number of open Postgre connections, 0 for no limit")
+	root.PersistentFlags().Int("storage.postgres.min_conns", 2, "Number of Postgre connections kept open when idle")
+	root.PersistentFlags().Duration("storage.postgres.max_conn_lifetime", 0, "Duration after which a Postgre connection is closed, 0 to keep them forever")
+	root.PersistentFlags().Duration("storage.postgres.max_conn_idle_time", 0, "Duration after which an idle Postgre connection is closed, 0 to keep them forever")
+	root.PersistentFlags().Duration("storage.postgres.statement_timeout", 0, "Maximum duration of a Postgre statement, 0 to disable")
+	root.PersistentFlags().Bool("storage.postgres.partition_transactions", false, "Partition the transactions of the Postgre ledgers by month, converting the existing ledgers when they are opened")
+	root.PersistentFlags().StringSlice("storage.redis.addrs", []string{"localhost:6379"}, "Redis addresses (a single one unless using a cluster)")
+	root.PersistentFlags().String("storage.redis.password", "", "Redis password")
+	root.PersistentFlags().Int("storage.redis.db", 0, "Redis database")
 	root.PersistentFlags().Bool("storage.cache", true, "Storage cache")
 	root.PersistentFlags().Bool("persist-config", true, "Persist config on disk")
 	root.PersistentFlags().String("server.http.bind_address", "localhost:3068", "API bind address")
+	root.PersistentFlags().String("server.http.gin_mode", gin.ReleaseMode, "Mode of gin: debug, release or test")
+	root.PersistentFlags().String("server.http.log_format", string(routes.LogFormatJSON), "Format of the access logs: json or pretty")
+	root.PersistentFlags().StringSlice("server.http.cors.allowed_origins", api.DefaultCORSConfig.AllowedOrigins, "Origins allowed to call the API, * for all of them, or patterns such as https://*.example.com")
+	root.PersistentFlags().StringSlice("server.http.cors.allowed_methods", api.DefaultCORSConfig.AllowedMethods, "Methods allowed to the other origins")
+	root.PersistentFlags().StringSlice("server.http.cors.allowed_headers", api.DefaultCORSConfig.AllowedHeaders, "Headers allowed to the other origins")
+	root.PersistentFlags().Bool("server.http.cors.allow_credentials", api.DefaultCORSConfig.AllowCredentials, "Allow the other origins to send credentials, refused with the * origin")
+	root.PersistentFlags().Duration("server.http.cors.max_age", api.DefaultCORSConfig.MaxAge, "Duration the preflight responses can be cached by the browsers")
+	root.PersistentFlags().String("server.http.jwt.secret", "", "Secret of the JWT signed with HMAC, exclusive with the jwks url")
+	root.PersistentFlags().String("server.http.jwt.jwks_url", "", "URL of the keys of the JWT signed with RSA or ECDSA, exclusive with the secret")
+	root.PersistentFlags().String("server.http.jwt.issuer", "", "Expected issuer of the JWT, not checked if empty")
+	root.PersistentFlags().String("server.http.jwt.audience", "", "Expected audience of the JWT, not checked if empty")
+	root.PersistentFlags().String("server.http.jwt.scopes_claim", "scope", "Claim of the JWT listing their scopes")
+	root.PersistentFlags().String("server.http.jwt.ledgers_claim", "", "Claim of the JWT listing the ledgers replacing {ledger} in the mapped scopes")
 	root.PersistentFlags().String("ui.http.bind_address", "localhost:3068", "UI bind address")
 	root.PersistentFlags().StringSlice("ledgers", []string{"quickstart"}, "Ledgers")
+	root.PersistentFlags().Bool("metrics.enabled", true, "Collect metrics, exposed on the /metrics route")
+	root.PersistentFlags().Duration("commit.timeout", 30*time.Second, "Maximum duration of a commit, 0 to disable")
+	root.PersistentFlags().Bool("commit.normalize_assets", false, "Uppercase the asset codes of the new transactions, the existing ones are left as is")
+	root.PersistentFlags().Int64("commit.conversion_tolerance", 0, "Difference allowed between the amounts of the conversions of the transactions and their rates, in units of the converted assets")
+	root.PersistentFlags().StringSlice("commit.unchecked_accounts", ledger.DefaultUncheckedAccounts, "Accounts whose balances are not checked, addresses or patterns like external:*, replaced for some ledgers by commit.ledger_unchecked_accounts in the config file")
+	root.PersistentFlags().String("commit.reference_scope", string(storage.ReferenceScopeGlobal), "Scope within which the references of the transactions must be unique, global, per-asset or none, replaced for some ledgers by commit.ledger_reference_scopes in the config file")
+	root.PersistentFlags().String("commit.id_strategy", string(ledger.IDStrategySequence), "Strategy generating the ids of the new transactions, sequence or snowflake")
+	root.PersistentFlags().Int64("commit.id_node", 0, "Node of the snowflake ids, unique to each process sharing the storage, between 0 and 1023")
+	root.PersistentFlags().Int("commit.max_postings_per_transaction", ledger.DefaultLimits.MaxPostingsPerTransaction, "Maximum number of postings of a transaction, 0 for no limit")
+	root.PersistentFlags().Int("commit.max_transactions_per_batch", ledger.DefaultLimits.MaxTransactionsPerBatch, "Maximum number of transactions committed at once, 0 for no limit")
+	root.PersistentFlags().Int("pagination.default_page_size", ledger.DefaultLimits.DefaultPageSize, "Number of items of the pages of the lists without page size")
+	root.PersistentFlags().Int("pagination.max_page_size", ledger.DefaultLimits.MaxPageSize, "Maximum number of items of the pages of the lists, the larger page sizes are clamped")
+	root.PersistentFlags().Int("script.cache_size", ledger.DefaultScriptCacheSize, "Number of compiled scripts kept in memory, 0 to compile the scripts on each execution")
+	root.PersistentFlags().Bool("webhooks.enabled", true, "Deliver the transactions to the webhooks of the ledgers, to enable on a single process when several share the storage")
+	root.PersistentFlags().Int("webhooks.max_attempts", ledger.DefaultWebhookConfig.MaxAttempts, "Number of attempts of a webhook delivery before it is recorded as dead")
+	root.PersistentFlags().Duration("webhooks.backoff", ledger.DefaultWebhookConfig.Backoff, "Delay before the second attempt of a webhook delivery, doubled before each of the next ones")
+	root.PersistentFlags().Duration("webhooks.max_backoff", ledger.DefaultWebhookConfig.MaxBackoff, "Maximum delay between the attempts of a webhook delivery")
+	root.PersistentFlags().Duration("webhooks.timeout", ledger.DefaultWebhookConfig.Timeout, "Maximum duration of an attempt of a webhook delivery")
+	root.PersistentFlags().Duration("webhooks.poll_interval", ledger.DefaultWebhookConfig.PollInterval, "Interval at which the ledgers are checked for transactions to deliver to their webhooks")
+	root.PersistentFlags().Bool("allow_ledger_delete", false, "Allow to drop the ledgers with the DELETE /:ledger route")
+	root.PersistentFlags().Float64("rate_limit.read.rate", 0, "Number of reads per second allowed to each client, 0 for no limit")
+	root.PersistentFlags().Int("rate_limit.read.burst", 0, "Number of reads allowed at once to each client")
+	root.PersistentFlags().Float64("rate_limit.write.rate", 0, "Number of writes per second allowed to each client, 0 for no limit")
+	root.PersistentFlags().Int("rate_limit.write.burst", 0, "Number of writes allowed at once to each client")
+	root.PersistentFlags().String("audit.sink", "", "Sink of the audit records of the writes made through the API: log or sql, auditing is disabled if empty")
+	root.PersistentFlags().String("audit.log.file", "", "File the audit records are appended to by the log sink, stdout if empty")
+	root.PersistentFlags().Bool("audit.blocking", false, "Record the writes before making them, refusing them if the audit record can't be written")
+	root.PersistentFlags().String("otel.traces.jaeger.endpoint", "", "Jaeger collector endpoint the traces are exported to, tracing is disabled if empty")
 
 	viper.BindPFlags(root.PersistentFlags())
-	viper.SetConfigName("numary")
-	viper.SetConfigType("yaml")
-	viper.AddConfigPath("$HOME/.numary")
-	viper.AddConfigPath("/etc/numary")
+	configure(viper.GetViper())
 	viper.ReadInConfig()
 
-	viper.SetEnvPrefix("numary")
-	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_", "-", "_"))
-	viper.AutomaticEnv()
-
 	return root
 }
 
+// configure sets the defaults and where the configuration is read from: the config file and the environment variables
+func configure(v *viper.Viper) {
+	v.SetDefault("version", Version)
+
+	v.SetConfigName("numary")
+	v.SetConfigType("yaml")
+	v.AddConfigPath("$HOME/.numary")
+	v.AddConfigPath("/etc/numary")
+
+	v.SetEnvPrefix("numary")
+	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_", "-", "_"))
+	v.AutomaticEnv()
+}
+
 func PrintVersion(cmd *cobra.Command, args []string) {
 	fmt.Printf("Version: %s \n", Version)
 	fmt.Printf("Date: %s \n", BuildDate)
 	fmt.Printf("Commit: %s \n", Commit)
 }
 
+// sqliteConfig returns the configuration of the SQLite databases set by storage.sqlite
+func sqliteConfig() sqlstorage.SQLiteConfig {
+	return sqlstorage.SQLiteConfig{
+		JournalMode: viper.GetString("storage.sqlite.journal_mode"),
+		BusyTimeout: viper.GetDuration("storage.sqlite.busy_timeout"),
+	}
+}
+
+// newAuditSink opens the sink of the audit records configured by audit.sink, nil if disabled
+func newAuditSink() (audit.Sink, func() error, error) {
+	switch viper.GetString("audit.sink") {
+	case "":
+		return nil, nil, nil
+	case "log":
+		file := viper.GetString("audit.log.file")
+		if file == "" {
+			return audit.NewLogSink(os.Stdout), func() error { return nil }, nil
+		}
+		f, err := os.OpenFile(file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			return nil, nil, err
+		}
+		return audit.NewLogSink(f), f.Close, nil
+	case "sql":
+		var (
+			sink *sqlstorage.AuditSink
+			err  error
+		)
+		switch viper.GetString("storage.driver") {
+		case "sqlite":
+			sink, err = sqlstorage.NewAuditSink(context.Background(), sqlstorage.SQLite, sqliteConfig().ConnString(path.Join(
+				viper.GetString("storage.dir"),
+				fmt.Sprintf("%s_audit.db", viper.GetString("storage.sqlite.db_name")),
+			)))
+		case "postgres":
+			sink, err = sqlstorage.NewAuditSink(context.Background(), sqlstorage.PostgreSQL, viper.GetString("storage.postgres.conn_string"))
+		default:
+			return nil, nil, fmt.Errorf("the sql audit sink is not supported by the storage driver %s", viper.GetString("storage.driver"))
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		return sink, sink.Close, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown audit sink %s", viper.GetString("audit.sink"))
+	}
+}
+
+// reloadableKeys are the keys of the settings of ReloadableConfig, applied again by the reloads of the configuration
+var reloadableKeys = []string{
+	"debug",
+	"commit.max_postings_per_transaction",
+	"commit.max_transactions_per_batch",
+	"pagination.default_page_size",
+	"pagination.max_page_size",
+	"rate_limit.read.rate",
+	"rate_limit.read.burst",
+	"rate_limit.write.rate",
+	"rate_limit.write.burst",
+	"server.http.cors.allowed_origins",
+	"server.http.cors.allowed_methods",
+	"server.http.cors.allowed_headers",
+	"server.http.cors.allow_credentials",
+	"server.http.cors.max_age",
+}
+
+// reloadableConfig reads the settings which can be changed without restarting the server
+func reloadableConfig(v *viper.Viper) ReloadableConfig {
+	logLevel := logrus.InfoLevel
+	if v.GetBool("debug") {
+		logLevel = logrus.DebugLevel
+	}
+	return ReloadableConfig{
+		LogLevel: logLevel,
+		Limits: ledger.Limits{
+			MaxPostingsPerTransaction: v.GetInt("commit.max_postings_per_transaction"),
+			MaxTransactionsPerBatch:   v.GetInt("commit.max_transactions_per_batch"),
+			DefaultPageSize:           v.GetInt("pagination.default_page_size"),
+			MaxPageSize:               v.GetInt("pagination.max_page_size"),
+		},
+		RateLimits: middlewares.RateLimits{
+			Read: middlewares.RateLimit{
+				Rate:  v.GetFloat64("rate_limit.read.rate"),
+				Burst: v.GetInt("rate_limit.read.burst"),
+			},
+			Write: middlewares.RateLimit{
+				Rate:  v.GetFloat64("rate_limit.write.rate"),
+				Burst: v.GetInt("rate_limit.write.burst"),
+			},
+		},
+		CORS: api.CORSConfig{
+			AllowedOrigins:   v.GetStringSlice("server.http.cors.allowed_origins"),
+			AllowedMethods:   v.GetStringSlice("server.http.cors.allowed_methods"),
+			AllowedHeaders:   v.GetStringSlice("server.http.cors.allowed_headers"),
+			AllowCredentials: v.GetBool("server.http.cors.allow_credentials"),
+			MaxAge:           v.GetDuration("server.http.cors.max_age"),
+		},
+	}
+}
+
+// readReloadableConfig reads the config file, the environment and the flags of the root command again,
+// in a new viper instance for the settings read by the running server not to change, and returns
+// the reloadable settings. The changes of the other settings are logged, being ignored until the server restarts.
+func readReloadableConfig(root *cobra.Command) (ReloadableConfig, error) {
+	v := viper.New()
+	if err := v.BindPFlags(root.PersistentFlags()); err != nil {
+		return ReloadableConfig{}, err
+	}
+	configure(v)
+	if err := v.ReadInConfig(); err != nil {
+		if _, ok := err.(viper.ConfigFileNotFoundError); !ok {
+			return ReloadableConfig{}, err
+		}
+	}
+
+	keys := map[string]bool{}
+	for _, key := range append(v.AllKeys(), viper.AllKeys()...) {
+		keys[key] = true
+	}
+	for _, key := range reloadableKeys {
+		keys[key] = false
+	}
+	for key, structural := range keys {
+		if structural && !reflect.DeepEqual(v.Get(key), viper.Get(key)) {
+			logrus.Warnf("the setting %s can't be reloaded, its change is ignored until the server restarts", key)
+		}
+	}
+
+	return reloadableConfig(v), nil
+}
+
 func createContainer(opts ...option) (*fx.App, error) {
 
+	// The api keys are only read from the config file, being a list of structs
+	var apiKeys []middlewares.APIKey
+	if err := viper.UnmarshalKey("server.http.api_keys", &apiKeys); err != nil {
+		return nil, errors.Wrap(err, "reading api keys")
+	}
+	var scopeMapping map[string][]string
+	if err := viper.UnmarshalKey("server.http.jwt.scope_mapping", &scopeMapping); err != nil {
+		return nil, errors.Wrap(err, "reading jwt scope mapping")
+	}
+	// The unchecked accounts of each ledger are only read from the config file, being a map of lists
+	var ledgerUncheckedAccounts map[string][]string
+	if err := viper.UnmarshalKey("commit.ledger_unchecked_accounts", &ledgerUncheckedAccounts); err != nil {
+		return nil, errors.Wrap(err, "reading the unchecked accounts of the ledgers")
+	}
+	referenceScope, err := storage.ParseReferenceScope(viper.GetString("commit.reference_scope"))
+	if err != nil {
+		return nil, err
+	}
+	// Likewise for the reference scopes of the ledgers, being a map
+	var ledgerReferenceScopes map[string]string
+	if err := viper.UnmarshalKey("commit.ledger_reference_scopes", &ledgerReferenceScopes); err != nil {
+		return nil, errors.Wrap(err, "reading the reference scopes of the ledgers")
+	}
+	referenceScopes := map[string]storage.ReferenceScope{}
+	for name, v := range ledgerReferenceScopes {
+		scope, err := storage.ParseReferenceScope(v)
+		if err != nil {
+			return nil, errors.Wrapf(err, "ledger %s", name)
+		}
+		referenceScopes[name] = scope
+	}
+	idStrategy, err := ledger.ParseIDStrategy(viper.GetString("commit.id_strategy"))
+	if err != nil {
+		return nil, err
+	}
+	idGenerator, err := ledger.NewIDGenerator(idStrategy, viper.GetInt64("commit.id_node"))
+	if err != nil {
+		return nil, err
+	}
+	switch viper.GetString("server.http.gin_mode") {
+	case gin.DebugMode, gin.ReleaseMode, gin.TestMode:
+	default:
+		return nil, fmt.Errorf("unknown gin mode %s", viper.GetString("server.http.gin_mode"))
+	}
+	switch routes.LogFormat(viper.GetString("server.http.log_format")) {
+	case routes.LogFormatJSON, routes.LogFormatPretty:
+	default:
+		return nil, fmt.Errorf("unknown access log format %s", viper.GetString("server.http.log_format"))
+	}
+	if viper.GetInt64("commit.conversion_tolerance") < 0 {
+		return nil, fmt.Errorf("invalid conversion tolerance %d: expected a positive number", viper.GetInt64("commit.conversion_tolerance"))
+	}
+	if err := sqliteConfig().Validate(); err != nil {
+		return nil, fmt.Errorf("invalid sqlite configuration: %w", err)
+	}
+	reloadable := reloadableConfig(viper.GetViper())
+	if err := reloadable.Validate(); err != nil {
+		return nil, err
+	}
+	if viper.GetInt("webhooks.max_attempts") < 1 {
+		return nil, fmt.Errorf("invalid webhooks max attempts %d: expected at least 1", viper.GetInt("webhooks.max_attempts"))
+	}
+	if viper.GetDuration("webhooks.poll_interval") <= 0 {
+		return nil, fmt.Errorf("invalid webhooks poll interval %s: expected a positive duration", viper.GetDuration("webhooks.poll_interval"))
+	}
+	auditSink, closeAuditSink, err := newAuditSink()
+	if err != nil {
+		return nil, errors.Wrap(err, "opening audit sink")
+	}
+	if auditSink != nil {
+		opts = append(opts,
+			WithAudit(auditSink, viper.GetBool("audit.blocking")),
+			WithOption(fx.Invoke(func(lc fx.Lifecycle) {
+				lc.Append(fx.Hook{
+					OnStop: func(ctx context.Context) error {
+						return closeAuditSink()
+					},
+				})
+			})),
+		)
+	}
+
 	opts = append(opts,
 		WithVersion(Version),
 		WithOption(fx.Provide(func() (storage.Driver, error) {
 			switch viper.GetString("storage.driver") {
 			case "sqlite":
+				sqliteConfig := sqliteConfig()
 				return sqlstorage.NewOpenCloseDBDriver("sqlite", sqlstorage.SQLite, func(name string) string {
-					return sqlstorage.SQLiteFileConnString(path.Join(
+					return sqliteConfig.ConnString(path.Join(
 						viper.GetString("storage.dir"),
 						fmt.Sprintf("%s_%s.db", viper.GetString("storage.sqlite.db_name"), name),
 					))
 				}), nil
 			case "postgres":
+				options := []sqlstorage.CachedDBDriverOption{
+					sqlstorage.WithPool(sqlstorage.PoolConfig{
+						MaxConns:         viper.GetInt("storage.postgres.max_conns"),
+						MinConns:         viper.GetInt("storage.postgres.min_conns"),
+						MaxConnLifetime:  viper.GetDuration("storage.postgres.max_conn_lifetime"),
+						MaxConnIdleTime:  viper.GetDuration("storage.postgres.max_conn_idle_time"),
+						StatementTimeout: viper.GetDuration("storage.postgres.statement_timeout"),
+					}),
+				}
+				if replica := viper.GetString("storage.postgres.replica_conn_string"); replica != "" {
+					options = append(options, sqlstorage.WithReplica(replica))
+				}
+				if viper.GetBool("storage.postgres.partition_transactions") {
+					options = append(options, sqlstorage.WithTransactionsPartitioning())
+				}
 				return sqlstorage.NewCachedDBDriver("postgres", sqlstorage.PostgreSQL,
-					viper.GetString("storage.postgres.conn_string")), nil
+					viper.GetString("storage.postgres.conn_string"), options...), nil
+			case "memory":
+				return memorystorage.NewDriver("memory"), nil
+			case "redis":
+				return redisstorage.NewDriver("redis", &redis.UniversalOptions{
+					Addrs:    viper.GetStringSlice("storage.redis.addrs"),
+					Password: viper.GetString("storage.redis.password"),
+					DB:       viper.GetInt("storage.redis.db"),
+				}), nil
 			default:
-				return nil, fmt.Errorf("unknown storage driver %s", viper.GetString("storage.driver"))
+				name := viper.GetString("storage.driver")
+				return storage.NewRegisteredDriver(name, storage.Config(viper.GetStringMap("storage."+name)))
 			}
 		})),
 		WithCacheStorage(viper.GetBool("storage.cache")),
 		WithHttpBasicAuth(viper.GetString("server.http.basic_auth")),
+		WithGinMode(viper.GetString("server.http.gin_mode")),
+		WithAccessLogFormat(routes.LogFormat(viper.GetString("server.http.log_format"))),
+		WithCORS(reloadable.CORS),
+		WithAPIKeys(apiKeys...),
+		WithJWT(middlewares.JWTConfig{
+			Secret:       viper.GetString("server.http.jwt.secret"),
+			JWKSURL:      viper.GetString("server.http.jwt.jwks_url"),
+			Issuer:       viper.GetString("server.http.jwt.issuer"),
+			Audience:     viper.GetString("server.http.jwt.audience"),
+			ScopesClaim:  viper.GetString("server.http.jwt.scopes_claim"),
+			LedgersClaim: viper.GetString("server.http.jwt.ledgers_claim"),
+			ScopeMapping: scopeMapping,
+		}),
 		WithLedgerLister(controllers.LedgerListerFn(func(*http.Request) []string {
 			return viper.GetStringSlice("ledgers")
 		})),
 		WithRememberConfig(true),
+		WithMetrics(viper.GetBool("metrics.enabled")),
+		WithCommitTimeout(viper.GetDuration("commit.timeout")),
+		WithAssetNormalization(viper.GetBool("commit.normalize_assets")),
+		WithConversionTolerance(viper.GetInt64("commit.conversion_tolerance")),
+		WithUncheckedAccounts(viper.GetStringSlice("commit.unchecked_accounts"), ledgerUncheckedAccounts),
+		WithReferenceScopes(referenceScope, referenceScopes),
+		WithIDGenerator(idGenerator),
+		WithLimits(reloadable.Limits),
+		WithJaegerTracing(viper.GetString("otel.traces.jaeger.endpoint")),
+		WithScriptCacheSize(viper.GetInt("script.cache_size")),
+		WithWebhooks(viper.GetBool("webhooks.enabled"), ledger.WebhookConfig{
+			MaxAttempts:  viper.GetInt("webhooks.max_attempts"),
+			Backoff:      viper.GetDuration("webhooks.backoff"),
+			MaxBackoff:   viper.GetDuration("webhooks.max_backoff"),
+			Timeout:      viper.GetDuration("webhooks.timeout"),
+			PollInterval: viper.GetDuration("webhooks.poll_interval"),
+		}),
+		WithLedgerDeletion(viper.GetBool("allow_ledger_delete")),
+		WithRateLimits(reloadable.RateLimits),
 	)
 
 	return NewContainer(opts...), nil
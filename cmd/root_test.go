@@ -107,6 +107,10 @@ func TestServer(t *testing.T) {
 			}`))
 			assert.NoError(t, err)
 			assert.Equal(t, http.StatusOK, res.StatusCode)
+
+			res, err = http.DefaultClient.Post("http://localhost:3068/_config/reload", "application/json", nil)
+			assert.NoError(t, err)
+			assert.Equal(t, http.StatusOK, res.StatusCode)
 		})
 	}
 
@@ -482,6 +482,9 @@ var doc = `{
         "config.Config": {
             "type": "object",
             "properties": {
+                "limits": {
+                    "$ref": "#/definitions/config.Limits"
+                },
                 "storage": {
                     "$ref": "#/definitions/config.LedgerStorage"
                 }
@@ -506,6 +509,17 @@ var doc = `{
                 "ledgers": {}
             }
         },
+        "config.Limits": {
+            "type": "object",
+            "properties": {
+                "max_postings_per_transaction": {
+                    "type": "integer"
+                },
+                "max_transactions_per_batch": {
+                    "type": "integer"
+                }
+            }
+        },
         "controllers.BaseResponse": {
             "type": "object",
             "properties": {
@@ -537,6 +551,10 @@ var doc = `{
                 "metadata": {
                     "type": "object"
                 },
+                "metadata_version": {
+                    "type": "integer",
+                    "example": 3
+                },
                 "type": {
                     "type": "string",
                     "example": "virtual"
@@ -603,12 +621,42 @@ var doc = `{
                 }
             }
         },
+        "ledger.AssetStats": {
+            "type": "object",
+            "properties": {
+                "asset": {
+                    "type": "string"
+                },
+                "volume": {
+                    "description": "Volume is the sum of the amounts of the postings in the asset",
+                    "type": "integer"
+                }
+            }
+        },
         "ledger.Stats": {
             "type": "object",
             "properties": {
                 "accounts": {
                     "type": "integer"
                 },
+                "assets": {
+                    "description": "Assets are the assets of the postings, sorted by code",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/ledger.AssetStats"
+                    }
+                },
+                "first_transaction_at": {
+                    "description": "FirstTransactionAt and LastTransactionAt are the timestamps of the first and last transactions,\nempty if there is no transaction",
+                    "type": "string"
+                },
+                "head": {
+                    "description": "Head is the hash of the last transaction, which the hash of the next one chains to",
+                    "type": "string"
+                },
+                "last_transaction_at": {
+                    "type": "string"
+                },
                 "transactions": {
                     "type": "integer"
                 }
@@ -2,10 +2,15 @@ package api
 
 import (
 	_ "embed"
+	"errors"
+	"fmt"
 	"github.com/numary/ledger/pkg/api/controllers"
 	"github.com/numary/ledger/pkg/api/middlewares"
 	"github.com/numary/ledger/pkg/api/routes"
 	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
@@ -21,26 +26,110 @@ var Module = fx.Options(
 // API struct
 type API struct {
 	engine *gin.Engine
+	// cors holds the gin.HandlerFunc applying the CORS policy, replaced by SetCORS
+	cors atomic.Value
 }
 
 func (a *API) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	a.engine.ServeHTTP(w, r)
 }
 
-// NewAPI
+// CORSConfig is the CORS policy of the API
+type CORSConfig struct {
+	// AllowedOrigins are the origins allowed to call the API, * for all of them,
+	// or patterns with a wildcard such as https://*.example.com
+	AllowedOrigins   []string
+	AllowedMethods   []string
+	AllowedHeaders   []string
+	AllowCredentials bool
+	// MaxAge is the duration the preflight responses can be cached by the browsers
+	MaxAge time.Duration
+}
+
+// DefaultCORSConfig allows all the origins to call the API, without credentials
+var DefaultCORSConfig = CORSConfig{
+	AllowedOrigins: []string{"*"},
+	AllowedMethods: []string{
+		http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete, http.MethodHead,
+	},
+	AllowedHeaders: []string{
+		"Origin", "Content-Length", "Content-Type", "Authorization", "Idempotency-Key", "If-Match", middlewares.RequestIDHeader,
+	},
+	MaxAge: 12 * time.Hour,
+}
+
+// corsConfig validates the CORS policy and converts it to the config of the cors middleware
+func (c CORSConfig) corsConfig() (cors.Config, error) {
+	if len(c.AllowedOrigins) == 0 {
+		return cors.Config{}, errors.New("cors: no allowed origins")
+	}
+	if c.MaxAge < 0 {
+		return cors.Config{}, errors.New("cors: negative max age")
+	}
+
+	cc := cors.Config{
+		AllowMethods:     c.AllowedMethods,
+		AllowHeaders:     c.AllowedHeaders,
+		ExposeHeaders:    []string{middlewares.RequestIDHeader, "ETag"},
+		AllowCredentials: c.AllowCredentials,
+		MaxAge:           c.MaxAge,
+	}
+	for _, origin := range c.AllowedOrigins {
+		switch {
+		case origin == "*":
+			// The browsers refuse the credentials of the responses allowing all the origins
+			if c.AllowCredentials {
+				return cors.Config{}, errors.New("cors: the credentials can't be allowed to all the origins")
+			}
+			if len(c.AllowedOrigins) > 1 {
+				return cors.Config{}, errors.New("cors: * can't be combined with other origins")
+			}
+			cc.AllowAllOrigins = true
+		case strings.Contains(origin, "*"):
+			cc.AllowWildcard = true
+			cc.AllowOrigins = append(cc.AllowOrigins, origin)
+		default:
+			cc.AllowOrigins = append(cc.AllowOrigins, origin)
+		}
+	}
+
+	if err := cc.Validate(); err != nil {
+		return cors.Config{}, fmt.Errorf("cors: %w", err)
+	}
+	return cc, nil
+}
+
+// Validate checks that the CORS policy can be applied
+func (c CORSConfig) Validate() error {
+	_, err := c.corsConfig()
+	return err
+}
+
+// NewAPI serves the routes, in the gin mode given (debug, release or test), with the CORS policy given
 func NewAPI(
 	routes *routes.Routes,
-) *API {
-	gin.SetMode(gin.ReleaseMode)
+	ginMode string,
+	corsConfig CORSConfig,
+) (*API, error) {
+	gin.SetMode(ginMode)
 
-	cc := cors.DefaultConfig()
-	cc.AllowAllOrigins = true
-	cc.AllowCredentials = true
-	cc.AddAllowHeaders("authorization")
-
-	h := &API{
-		engine: routes.Engine(cc),
+	h := &API{}
+	if err := h.SetCORS(corsConfig); err != nil {
+		return nil, err
 	}
+	h.engine = routes.Engine(func(c *gin.Context) {
+		h.cors.Load().(gin.HandlerFunc)(c)
+	})
+
+	return h, nil
+}
 
-	return h
+// SetCORS replaces the CORS policy of the API, the policy in place being kept if the new one is invalid
+func (a *API) SetCORS(corsConfig CORSConfig) error {
+	cc, err := corsConfig.corsConfig()
+	if err != nil {
+		return err
+	}
+	a.cors.Store(cors.New(cc))
+	return nil
 }
@@ -0,0 +1,35 @@
+package api
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCORSConfig(t *testing.T) {
+	cc, err := DefaultCORSConfig.corsConfig()
+	assert.NoError(t, err)
+	assert.True(t, cc.AllowAllOrigins)
+	assert.False(t, cc.AllowCredentials)
+
+	cc, err = CORSConfig{
+		AllowedOrigins:   []string{"https://app.example.com", "https://*.example.org"},
+		AllowCredentials: true,
+	}.corsConfig()
+	assert.NoError(t, err)
+	assert.False(t, cc.AllowAllOrigins)
+	assert.True(t, cc.AllowWildcard)
+	assert.Equal(t, []string{"https://app.example.com", "https://*.example.org"}, cc.AllowOrigins)
+
+	for name, config := range map[string]CORSConfig{
+		"no origins":                {},
+		"wildcard with credentials": {AllowedOrigins: []string{"*"}, AllowCredentials: true},
+		"wildcard with origins":     {AllowedOrigins: []string{"*", "https://app.example.com"}},
+		"origin without scheme":     {AllowedOrigins: []string{"app.example.com"}},
+		"negative max age":          {AllowedOrigins: []string{"*"}, MaxAge: -time.Second},
+	} {
+		_, err := config.corsConfig()
+		assert.Error(t, err, name)
+	}
+}
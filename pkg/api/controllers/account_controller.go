@@ -1,12 +1,18 @@
 package controllers
 
 import (
+	"errors"
+	"fmt"
+	"io"
+
 	"github.com/numary/ledger/pkg/core"
 	"github.com/numary/ledger/pkg/ledger"
 	"github.com/numary/ledger/pkg/ledger/query"
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
 )
 
 // AccountController -
@@ -23,20 +29,67 @@ func NewAccountController() AccountController {
 // @Summary List All Accounts
 // @Schemes
 // @Param ledger path string true "ledger"
+// @Param page_size query int false "page size, clamped to the maximum page size"
+// @Param limit query int false "alias of page_size"
+// @Param pagination_token query string false "pagination token"
+// @Param sort query string false "address (default) or balance"
+// @Param order query string false "desc (default) or asc"
+// @Param asset query string false "asset of the balances, required to sort by balance"
+// @Param metadata query object false "metadata filters, like metadata[kyc_status]=verified, matching the nested keys"
 // @Accept json
 // @Produce json
 // @Success 200 {object} controllers.BaseResponse{cursor=query.Cursor{data=[]core.Account}}
 // @Router /{ledger}/accounts [get]
 func (ctl *AccountController) GetAccounts(c *gin.Context) {
 	l, _ := c.Get("ledger")
-	cursor, err := l.(*ledger.Ledger).FindAccounts(
-		c,
-		query.After(c.Query("after")),
+
+	modifiers, err := ctl.paginationModifiers(c)
+	if err != nil {
+		ctl.responseError(
+			c,
+			http.StatusBadRequest,
+			err,
+		)
+		return
+	}
+
+	modifiers = append(modifiers,
+		query.Address(c.Query("address")),
+		query.Account(c.Query("account")),
 	)
+	if c.Query("after") != "" {
+		modifiers = append(modifiers, query.After(c.Query("after")))
+	}
+	for key, value := range c.QueryMap("metadata") {
+		modifiers = append(modifiers, query.AccountMetadata(key, value))
+	}
+
+	// The pagination tokens carry the order of the first page
+	if c.Query("pagination_token") == "" && (c.Query("sort") != "" || c.Query("order") != "") {
+		sorting := query.Sort{
+			By:    c.DefaultQuery("sort", query.SortByAddress),
+			Asset: c.Query("asset"),
+		}
+		switch c.DefaultQuery("order", "desc") {
+		case "asc":
+			sorting.Asc = true
+		case "desc":
+		default:
+			ctl.responseError(
+				c,
+				http.StatusBadRequest,
+				errors.New("invalid 'order' query param: expected 'asc' or 'desc'"),
+			)
+			return
+		}
+		modifiers = append(modifiers, query.SortAccounts(sorting))
+	}
+
+	cursor, err := l.(*ledger.Ledger).FindAccounts(c.Request.Context(), modifiers...)
 	if err != nil {
 		ctl.responseError(
 			c,
-			http.StatusInternalServerError,
+			errorStatus(err),
 			err,
 		)
 		return
@@ -48,26 +101,144 @@ func (ctl *AccountController) GetAccounts(c *gin.Context) {
 	)
 }
 
+// GetBalances godoc
+// @Summary Aggregate balances across accounts
+// @Description Sum the balances of the accounts matching the address prefix, grouped by asset
+// @Schemes
+// @Param ledger path string true "ledger"
+// @Param address query string false "address prefix"
+// @Accept json
+// @Produce json
+// @Success 200 {object} controllers.BaseResponse{data=map[string]int64}
+// @Router /{ledger}/balances [get]
+func (ctl *AccountController) GetBalances(c *gin.Context) {
+	l, _ := c.Get("ledger")
+	balances, err := l.(*ledger.Ledger).AggregateBalances(
+		c.Request.Context(),
+		query.Address(c.Query("address")),
+	)
+	if err != nil {
+		ctl.responseError(
+			c,
+			errorStatus(err),
+			err,
+		)
+		return
+	}
+	if stringAmounts(c) {
+		ctl.response(
+			c,
+			http.StatusOK,
+			amountsAsStrings(balances),
+		)
+		return
+	}
+	ctl.response(
+		c,
+		http.StatusOK,
+		balances,
+	)
+}
+
+// GetBalancesCSV godoc
+// @Summary Export balances as CSV
+// @Description Stream the balances of the accounts matching the filters, with a column per asset or, in long format, a row per account and asset
+// @Schemes
+// @Param ledger path string true "ledger"
+// @Param address query string false "address prefix"
+// @Param account query string false "account address or pattern"
+// @Param format query string false "wide (default) or long"
+// @Param omit_zero query bool false "skip the accounts with only zero balances"
+// @Produce text/csv
+// @Success 200 {string} string
+// @Failure 400 {object} controllers.BaseResponse
+// @Router /{ledger}/accounts/balances.csv [get]
+func (ctl *AccountController) GetBalancesCSV(c *gin.Context) {
+	l, _ := c.Get("ledger")
+
+	opts := ledger.BalancesCSVOptions{
+		OmitZero: c.Query("omit_zero") == "true",
+	}
+	switch c.Query("format") {
+	case "", "wide":
+	case "long":
+		opts.Long = true
+	default:
+		ctl.responseError(
+			c,
+			http.StatusBadRequest,
+			errors.New("format must be wide or long"),
+		)
+		return
+	}
+
+	q := query.New([]query.QueryModifier{
+		query.Address(c.Query("address")),
+		query.Account(c.Query("account")),
+	})
+
+	c.Header("Content-Type", "text/csv")
+	c.Header("Content-Disposition", "attachment; filename=balances.csv")
+	err := l.(*ledger.Ledger).ExportBalancesCSV(c.Request.Context(), c.Writer, q, opts)
+	if err != nil {
+		if !c.Writer.Written() {
+			c.Header("Content-Type", "")
+			c.Header("Content-Disposition", "")
+			ctl.responseError(
+				c,
+				errorStatus(err),
+				err,
+			)
+			return
+		}
+		// The status has been sent along with the first rows, the export is truncated
+		logrus.Errorf("exporting balances of ledger %s: %s", c.Param("ledger"), err)
+	}
+}
+
 // GetAccount godoc
 // @Summary Get account by address
 // @Schemes
 // @Param ledger path string true "ledger"
 // @Param accountId path string true "accountId"
+// @Param at query string false "RFC3339 timestamp: return the balances and volumes of the account at that time"
 // @Accept json
 // @Produce json
 // @Success 200 {object} controllers.BaseResponse{account=core.Account}
+// @Failure 400 {object} controllers.BaseResponse
 // @Router /{ledger}/accounts/{accountId} [get]
 func (ctl *AccountController) GetAccount(c *gin.Context) {
 	l, _ := c.Get("ledger")
-	acc, err := l.(*ledger.Ledger).GetAccount(c, c.Param("address"))
+
+	var (
+		acc core.Account
+		err error
+	)
+	if c.Query("at") != "" {
+		at, parseErr := time.Parse(time.RFC3339, c.Query("at"))
+		if parseErr != nil {
+			ctl.responseError(
+				c,
+				http.StatusBadRequest,
+				errors.New("invalid 'at' query param: expected RFC3339 format"),
+			)
+			return
+		}
+		acc, err = l.(*ledger.Ledger).GetAccountAt(c.Request.Context(), c.Param("address"), at)
+	} else {
+		acc, err = l.(*ledger.Ledger).GetAccount(c.Request.Context(), c.Param("address"))
+	}
 	if err != nil {
 		ctl.responseError(
 			c,
-			http.StatusInternalServerError,
+			errorStatus(err),
 			err,
 		)
 		return
 	}
+	if c.Query("at") == "" {
+		etag(c, acc.MetadataVersion)
+	}
 	ctl.response(
 		c,
 		http.StatusOK,
@@ -75,29 +246,245 @@ func (ctl *AccountController) GetAccount(c *gin.Context) {
 	)
 }
 
+// WatchAccount godoc
+// @Summary Watch an account
+// @Description Stream the state of the account as server-sent events: an "account" event with its current state,
+// @Description then one after each commit changing its balances. The changes following a change during the debounce duration are sent at once.
+// @Schemes
+// @Param ledger path string true "ledger"
+// @Param accountId path string true "accountId"
+// @Param debounce query string false "duration, like 500ms"
+// @Produce text/event-stream
+// @Success 200 {object} core.Account
+// @Failure 400 {object} controllers.BaseResponse
+// @Router /{ledger}/accounts/{accountId}/watch [get]
+func (ctl *AccountController) WatchAccount(c *gin.Context) {
+	l, _ := c.Get("ledger")
+
+	opts := ledger.WatchOptions{}
+	if v := c.Query("debounce"); v != "" {
+		debounce, err := time.ParseDuration(v)
+		if err != nil || debounce < 0 {
+			ctl.responseError(
+				c,
+				http.StatusBadRequest,
+				errors.New("invalid 'debounce' query param: expected a duration like 500ms"),
+			)
+			return
+		}
+		opts.Debounce = debounce
+	}
+
+	// The account is watched before being read, so that no change is missed in between
+	accounts, err := l.(*ledger.Ledger).WatchAccountWithOptions(c.Request.Context(), c.Param("address"), opts)
+	if err != nil {
+		ctl.responseError(
+			c,
+			errorStatus(err),
+			err,
+		)
+		return
+	}
+	acc, err := l.(*ledger.Ledger).GetAccount(c.Request.Context(), c.Param("address"))
+	if err != nil {
+		ctl.responseError(
+			c,
+			errorStatus(err),
+			err,
+		)
+		return
+	}
+
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	c.SSEvent("account", acc)
+	c.Writer.Flush()
+
+	ticker := time.NewTicker(eventsKeepAlive)
+	defer ticker.Stop()
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case acc, ok := <-accounts:
+			if !ok {
+				return false
+			}
+			c.SSEvent("account", acc)
+		case <-ticker.C:
+			return keepAlive(w) == nil
+		}
+		return true
+	})
+}
+
 // PostAccountMetadata godoc
 // @Summary Add metadata to account
+// @Description The "overdraft" key, a positive integer, allows the account to go negative down to -overdraft in every asset.
+// @Description The metadata are only saved if they are still at the version of the If-Match header, the ETag of the account, and the new version is sent back as ETag.
 // @Schemes
 // @Param ledger path string true "ledger"
 // @Param accountId path string true "accountId"
+// @Param If-Match header string false "expected metadata version"
 // @Accept json
 // @Produce json
 // @Success 200 {object} controllers.BaseResponse
+// @Failure 400 {object} controllers.BaseResponse
+// @Failure 409 {object} controllers.BaseResponse
 // @Router /{ledger}/accounts/{accountId}/metadata [post]
 func (ctl *AccountController) PostAccountMetadata(c *gin.Context) {
+	ctl.saveMeta(c, "account", c.Param("address"))
+}
+
+// PostAccountOverdraft godoc
+// @Summary Set the overdraft limit of an account
+// @Description Allow the account to go negative in an asset, down to -limit. A zero limit removes the overdraft.
+// @Schemes
+// @Param ledger path string true "ledger"
+// @Param accountId path string true "accountId"
+// @Param overdraft body controllers.Overdraft true "overdraft"
+// @Accept json
+// @Produce json
+// @Success 200 {object} controllers.BaseResponse
+// @Router /{ledger}/accounts/{accountId}/overdrafts [post]
+func (ctl *AccountController) PostAccountOverdraft(c *gin.Context) {
 	l, _ := c.Get("ledger")
-	var m core.Metadata
-	c.ShouldBind(&m)
-	err := l.(*ledger.Ledger).SaveMeta(
+
+	var overdraft Overdraft
+	if err := c.ShouldBindJSON(&overdraft); err != nil {
+		ctl.responseError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	err := l.(*ledger.Ledger).SetOverdraft(
+		c.Request.Context(),
+		c.Param("address"),
+		overdraft.Asset,
+		overdraft.Limit,
+	)
+	if err != nil {
+		ctl.responseError(
+			c,
+			errorStatus(err),
+			err,
+		)
+		return
+	}
+	ctl.response(
 		c,
+		http.StatusOK,
+		nil,
+	)
+}
+
+// Overdraft is the body of the overdraft route
+type Overdraft struct {
+	Asset string `json:"asset"`
+	Limit int64  `json:"limit"`
+}
+
+// Flow is the amount of an asset sent from an account to another over a period
+type Flow struct {
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+	Asset       string `json:"asset"`
+	// Net tells whether the amount sent back from the destination to the source is deducted
+	Net    bool  `json:"net"`
+	Amount int64 `json:"amount"`
+}
+
+// GetAccountFlow godoc
+// @Summary Get the flow from an account to another
+// @Description Sum the amounts of an asset sent from the account to the destination by the transactions of a period,
+// @Description from the "from" timestamp included to the "to" one excluded, both optional
+// @Schemes
+// @Param ledger path string true "ledger"
+// @Param accountId path string true "accountId"
+// @Param destination query string true "destination account"
+// @Param asset query string true "asset"
+// @Param from query string false "RFC3339 timestamp: start of the period, included"
+// @Param to query string false "RFC3339 timestamp: end of the period, excluded"
+// @Param net query bool false "deduct the amount sent back from the destination"
+// @Accept json
+// @Produce json
+// @Success 200 {object} controllers.BaseResponse{data=controllers.Flow}
+// @Failure 400 {object} controllers.BaseResponse
+// @Router /{ledger}/accounts/{accountId}/flow [get]
+func (ctl *AccountController) GetAccountFlow(c *gin.Context) {
+	l, _ := c.Get("ledger")
+
+	flow := Flow{
+		Source:      c.Param("address"),
+		Destination: c.Query("destination"),
+		Asset:       c.Query("asset"),
+		Net:         c.Query("net") == "true",
+	}
+	if flow.Destination == "" || flow.Asset == "" {
+		ctl.responseError(
+			c,
+			http.StatusBadRequest,
+			errors.New("the 'destination' and 'asset' query params are required"),
+		)
+		return
+	}
+	period := map[string]time.Time{}
+	for _, param := range []string{"from", "to"} {
+		if c.Query(param) == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, c.Query(param))
+		if err != nil {
+			ctl.responseError(
+				c,
+				http.StatusBadRequest,
+				fmt.Errorf("invalid '%s' query param: expected RFC3339 format", param),
+			)
+			return
+		}
+		period[param] = t
+	}
+
+	aggregate := l.(*ledger.Ledger).FlowBetween
+	if flow.Net {
+		aggregate = l.(*ledger.Ledger).NetFlowBetween
+	}
+	amount, err := aggregate(c.Request.Context(), flow.Source, flow.Destination, flow.Asset, period["from"], period["to"])
+	if err != nil {
+		ctl.responseError(
+			c,
+			errorStatus(err),
+			err,
+		)
+		return
+	}
+	flow.Amount = amount
+	ctl.response(
+		c,
+		http.StatusOK,
+		flow,
+	)
+}
+
+// DeleteAccountMetadata godoc
+// @Summary Delete account metadata
+// @Schemes
+// @Param ledger path string true "ledger"
+// @Param accountId path string true "accountId"
+// @Param key path string true "key"
+// @Accept json
+// @Produce json
+// @Success 200 {object} controllers.BaseResponse
+// @Router /{ledger}/accounts/{accountId}/metadata/{key} [delete]
+func (ctl *AccountController) DeleteAccountMetadata(c *gin.Context) {
+	l, _ := c.Get("ledger")
+	err := l.(*ledger.Ledger).DeleteMeta(
+		c.Request.Context(),
 		"account",
 		c.Param("address"),
-		m,
+		[]string{c.Param("key")},
 	)
 	if err != nil {
 		ctl.responseError(
 			c,
-			http.StatusInternalServerError,
+			errorStatus(err),
 			err,
 		)
 		return
@@ -0,0 +1,82 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// amountFields are the fields of the responses holding amounts: the numbers they contain, directly
+// or nested like the balances and volumes by asset, are all amounts
+var amountFields = map[string]bool{
+	"amount":   true,
+	"balances": true,
+	"volume":   true,
+	"volumes":  true,
+}
+
+// stringAmounts reports whether the client asked for the amounts as strings, with an
+// Accept: application/json; amounts=string header. The JSON numbers of the amounts lose precision
+// above 2^53 in javascript, while their strings can be parsed as BigInt.
+func stringAmounts(c *gin.Context) bool {
+	for _, accepted := range strings.Split(c.GetHeader("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(accepted)
+		if err == nil && mediaType == "application/json" && params["amounts"] == "string" {
+			return true
+		}
+	}
+	return false
+}
+
+// withStringAmounts returns the JSON encoding of v with the numbers of the amount fields as strings
+func withStringAmounts(v interface{}) (interface{}, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var decoded interface{}
+	if err := dec.Decode(&decoded); err != nil {
+		return nil, err
+	}
+
+	return stringifyAmounts(decoded, false), nil
+}
+
+// stringifyAmounts replaces the numbers under the amount fields by strings, amount being true under one of them.
+// The metadata are left as is, whatever their keys.
+func stringifyAmounts(v interface{}, amount bool) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			if key == "metadata" {
+				continue
+			}
+			v[key] = stringifyAmounts(value, amount || amountFields[key])
+		}
+	case []interface{}:
+		for i, value := range v {
+			v[i] = stringifyAmounts(value, amount)
+		}
+	case json.Number:
+		if amount {
+			return v.String()
+		}
+	}
+	return v
+}
+
+// amountsAsStrings returns the amounts by asset as strings, for the responses holding only amounts
+func amountsAsStrings(amounts map[string]int64) map[string]string {
+	res := make(map[string]string, len(amounts))
+	for asset, amount := range amounts {
+		res[asset] = strconv.FormatInt(amount, 10)
+	}
+	return res
+}
@@ -1,12 +1,28 @@
 package controllers
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
 	"reflect"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/numary/ledger/pkg/core"
+	"github.com/numary/ledger/pkg/ledger"
 	"github.com/numary/ledger/pkg/ledger/query"
+	"github.com/numary/ledger/pkg/logging"
 )
 
+// eventsKeepAlive is the interval of the comments sent on the idle streams of server-sent events,
+// so that the proxies don't close them
+const eventsKeepAlive = 30 * time.Second
+
 // Controllers struct
 type BaseController struct{}
 
@@ -14,29 +30,187 @@ type BaseResponse struct {
 	Ok bool `json:"ok"`
 }
 
+// response responds with the data, with the amounts as strings if the client asked for them, see stringAmounts
 func (ctl *BaseController) response(c *gin.Context, status int, data interface{}) {
 	if data == nil {
 		c.Status(status)
 	}
+	res := gin.H{
+		"ok":   true,
+		"data": data,
+	}
 	if reflect.TypeOf(data) == reflect.TypeOf(query.Cursor{}) {
-		c.JSON(status, gin.H{
+		res = gin.H{
 			"ok":     true,
 			"cursor": data,
-		})
-	} else {
-		c.JSON(status, gin.H{
-			"ok":   true,
-			"data": data,
-		})
+		}
 	}
+	if data != nil && stringAmounts(c) {
+		converted, err := withStringAmounts(res)
+		if err != nil {
+			ctl.responseError(c, http.StatusInternalServerError, err)
+			return
+		}
+		c.JSON(status, converted)
+		return
+	}
+	c.JSON(status, res)
 }
 
 func (ctl *BaseController) responseError(c *gin.Context, status int, err error) {
-	c.Abort()
-	c.AbortWithStatusJSON(status, gin.H{
+	res := gin.H{
 		"ok":            false,
 		"error":         true,
 		"error_code":    status,
 		"error_message": err.Error(),
-	})
+		"request_id":    logging.RequestID(c.Request.Context()),
+	}
+
+	// The invalid fields are listed so that they can be reported to the user
+	var errs core.ValidationErrors
+	if errors.As(err, &errs) {
+		res["errors"] = errs
+	}
+	var schemaErr *ledger.MetadataSchemaError
+	if errors.As(err, &schemaErr) {
+		res["errors"] = schemaErr.Errors
+	}
+
+	c.Abort()
+	c.AbortWithStatusJSON(status, res)
+}
+
+// errorStatus returns the http status code matching an error returned by the ledger
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, ledger.ErrValidation),
+		errors.Is(err, ledger.ErrInsufficientFunds),
+		errors.Is(err, query.ErrInvalidToken):
+		return http.StatusBadRequest
+	case errors.Is(err, ledger.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ledger.ErrDuplicateReference),
+		errors.Is(err, ledger.ErrAlreadyReverted),
+		errors.Is(err, ledger.ErrHoldClosed),
+		errors.Is(err, ledger.ErrLedgerNotEmpty),
+		errors.Is(err, ledger.ErrLedgerAlreadyExists),
+		errors.Is(err, ledger.ErrConflict),
+		errors.Is(err, ledger.ErrMetadataVersionMismatch),
+		errors.Is(err, ledger.ErrPreconditionFailed):
+		return http.StatusConflict
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// etag sets the version of the metadata of the response as its ETag
+func etag(c *gin.Context, version int64) {
+	c.Header("ETag", strconv.Quote(strconv.FormatInt(version, 10)))
+}
+
+// ifMatch reads the version of the metadata expected by the If-Match header, false if there is none or if it is *
+func ifMatch(c *gin.Context) (int64, bool, error) {
+	header := c.GetHeader("If-Match")
+	if header == "" || header == "*" {
+		return 0, false, nil
+	}
+	version, err := strconv.ParseInt(strings.Trim(strings.TrimPrefix(header, "W/"), `"`), 10, 64)
+	if err != nil || version < 0 {
+		return 0, false, errors.New("invalid If-Match header: expected a metadata version")
+	}
+	return version, true, nil
+}
+
+// saveMeta saves the metadata of the body on a target, if they are still at the version of the If-Match header if any,
+// and responds with their new version as ETag
+func (ctl *BaseController) saveMeta(c *gin.Context, targetType, targetID string) {
+	l, _ := c.Get("ledger")
+
+	var m core.Metadata
+	c.ShouldBind(&m)
+
+	version, ok, err := ifMatch(c)
+	if err != nil {
+		ctl.responseError(
+			c,
+			http.StatusBadRequest,
+			err,
+		)
+		return
+	}
+	if ok {
+		version, err = l.(*ledger.Ledger).SaveMetaIfVersion(c.Request.Context(), targetType, targetID, m, version)
+	} else {
+		err = l.(*ledger.Ledger).SaveMeta(c.Request.Context(), targetType, targetID, m)
+		if err == nil {
+			version, err = l.(*ledger.Ledger).GetMetaVersion(c.Request.Context(), targetType, targetID)
+		}
+	}
+	if err != nil {
+		ctl.responseError(
+			c,
+			errorStatus(err),
+			err,
+		)
+		return
+	}
+	etag(c, version)
+	ctl.response(
+		c,
+		http.StatusOK,
+		nil,
+	)
+}
+
+// paginationModifiers reads the page_size (or limit) and pagination_token query params.
+// A pagination token continues a previous query forward or backward, the other filters must be passed again.
+// It can only be used against the ledger it was issued by.
+// The page sizes above the maximum of the ledger are clamped, the page size of the returned cursor is the applied one.
+func (ctl *BaseController) paginationModifiers(c *gin.Context) ([]query.QueryModifier, error) {
+	modifiers := []query.QueryModifier{}
+
+	if v := c.Query("pagination_token"); v != "" {
+		token, err := query.DecodeToken(c.Param("ledger"), v)
+		if err != nil {
+			return nil, err
+		}
+		modifiers = append(modifiers, token.Modifiers()...)
+	}
+
+	param := "page_size"
+	if c.Query(param) == "" {
+		param = "limit"
+	}
+	if v := c.Query(param); v != "" {
+		size, err := strconv.Atoi(v)
+		if err != nil || size < 1 {
+			return nil, fmt.Errorf("invalid '%s' query param: expected a positive integer", param)
+		}
+		modifiers = append(modifiers, query.Limit(size))
+	}
+
+	return modifiers, nil
+}
+
+// writeEvent writes a server-sent event, with the data as JSON and with an id if not empty
+func writeEvent(w io.Writer, id, event string, data interface{}) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if id != "" {
+		if _, err := fmt.Fprintf(w, "id:%s\n", id); err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(w, "event:%s\ndata:%s\n\n", event, payload)
+	return err
+}
+
+// keepAlive writes a comment on a stream of server-sent events, see eventsKeepAlive
+func keepAlive(w io.Writer) error {
+	_, err := io.WriteString(w, ": keep-alive\n\n")
+	return err
 }
@@ -1,13 +1,19 @@
 package controllers
 
 import (
+	"context"
+	"errors"
 	"net/http"
+	"sort"
+	"sync"
+	"time"
 
 	"github.com/swaggo/swag"
 
 	"github.com/gin-gonic/gin"
 	_ "github.com/numary/ledger/docs"
 	"github.com/numary/ledger/pkg/config"
+	"github.com/numary/ledger/pkg/ledger"
 )
 
 type LedgerLister interface {
@@ -19,23 +25,51 @@ func (fn LedgerListerFn) List(r *http.Request) []string {
 	return fn(r)
 }
 
+// ConfigReloader applies again the settings of the server which can be changed without restarting it
+type ConfigReloader interface {
+	Reload() error
+}
+
 // ConfigController -
 type ConfigController struct {
 	BaseController
 	Version       string
 	StorageDriver string
 	LedgerLister  LedgerLister
+	Resolver      *ledger.Resolver
+	// Reloader is nil if the configuration can't be reloaded
+	Reloader ConfigReloader
 }
 
 // NewConfigController -
-func NewConfigController(version string, storageDriver string, lister LedgerLister) ConfigController {
+func NewConfigController(version string, storageDriver string, lister LedgerLister, resolver *ledger.Resolver, reloader ConfigReloader) ConfigController {
 	return ConfigController{
 		Version:       version,
 		StorageDriver: storageDriver,
 		LedgerLister:  lister,
+		Resolver:      resolver,
+		Reloader:      reloader,
 	}
 }
 
+// ledgers returns the sorted names of the configured ledgers and of the ledgers opened or created since startup
+func (ctl *ConfigController) ledgers(r *http.Request) []string {
+	names := ctl.Resolver.Ledgers()
+	known := map[string]struct{}{}
+	for _, name := range names {
+		known[name] = struct{}{}
+	}
+	for _, name := range ctl.LedgerLister.List(r) {
+		if _, ok := known[name]; !ok {
+			known[name] = struct{}{}
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	return names
+}
+
 // GetInfo godoc
 // @Summary Server Info
 // @Description Show server informations
@@ -46,6 +80,8 @@ func NewConfigController(version string, storageDriver string, lister LedgerList
 // @Success 200 {object} config.ConfigInfo{}
 // @Router /_info [get]
 func (ctl *ConfigController) GetInfo(c *gin.Context) {
+	limits := ctl.Resolver.Limits()
+	defaultPageSize, maxPageSize := limits.PageSizes()
 	ctl.response(
 		c,
 		http.StatusOK,
@@ -55,13 +91,187 @@ func (ctl *ConfigController) GetInfo(c *gin.Context) {
 			Config: &config.Config{
 				LedgerStorage: &config.LedgerStorage{
 					Driver:  ctl.StorageDriver,
-					Ledgers: ctl.LedgerLister.List(c.Request),
+					Ledgers: ctl.ledgers(c.Request),
+				},
+				Limits: &config.Limits{
+					MaxPostingsPerTransaction: limits.MaxPostingsPerTransaction,
+					MaxTransactionsPerBatch:   limits.MaxTransactionsPerBatch,
+					DefaultPageSize:           defaultPageSize,
+					MaxPageSize:               maxPageSize,
 				},
 			},
 		},
 	)
 }
 
+// readinessTimeout bounds the time the storage backend of each ledger has to answer the readiness check
+const readinessTimeout = 2 * time.Second
+
+// Readiness is the state of the storage backends of the ledgers
+type Readiness struct {
+	Ready   bool                       `json:"ready"`
+	Ledgers map[string]LedgerReadiness `json:"ledgers"`
+}
+
+// LedgerReadiness is the state of the storage backend of a ledger
+type LedgerReadiness struct {
+	Backend string `json:"backend"`
+	Ready   bool   `json:"ready"`
+	Error   string `json:"error,omitempty"`
+}
+
+// GetHealthz godoc
+// @Summary Liveness
+// @Description Always succeed while the server is up, whatever the state of the storage
+// @Tags server
+// @Schemes
+// @Produce json
+// @Success 200 {object} controllers.BaseResponse
+// @Router /_healthz [get]
+func (ctl *ConfigController) GetHealthz(c *gin.Context) {
+	ctl.response(
+		c,
+		http.StatusOK,
+		nil,
+	)
+}
+
+// GetReadyz godoc
+// @Summary Readiness
+// @Description Ping the storage backend of each ledger, failing with a 503 if any of them can't be reached
+// @Tags server
+// @Schemes
+// @Produce json
+// @Success 200 {object} controllers.BaseResponse{data=controllers.Readiness}
+// @Failure 503 {object} controllers.BaseResponse{data=controllers.Readiness}
+// @Router /_readyz [get]
+func (ctl *ConfigController) GetReadyz(c *gin.Context) {
+	names := ctl.ledgers(c.Request)
+	readiness := Readiness{
+		Ready:   true,
+		Ledgers: make(map[string]LedgerReadiness, len(names)),
+	}
+
+	var (
+		wg   sync.WaitGroup
+		lock sync.Mutex
+	)
+	for _, name := range names {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+
+			ctx, cancel := context.WithTimeout(c.Request.Context(), readinessTimeout)
+			defer cancel()
+
+			state := LedgerReadiness{
+				Backend: ctl.StorageDriver,
+				Ready:   true,
+			}
+			if err := ctl.Resolver.Ping(ctx, name); err != nil {
+				state.Ready = false
+				state.Error = err.Error()
+			}
+
+			lock.Lock()
+			defer lock.Unlock()
+			readiness.Ledgers[name] = state
+			readiness.Ready = readiness.Ready && state.Ready
+		}(name)
+	}
+	wg.Wait()
+
+	status := http.StatusOK
+	if !readiness.Ready {
+		status = http.StatusServiceUnavailable
+	}
+	c.JSON(status, gin.H{
+		"ok":   readiness.Ready,
+		"data": readiness,
+	})
+}
+
+// GetLedgers godoc
+// @Summary List Ledgers
+// @Description List the configured ledgers and the ledgers opened or created since the server started
+// @Tags server
+// @Schemes
+// @Accept json
+// @Produce json
+// @Success 200 {object} controllers.BaseResponse{data=[]string}
+// @Router /_ledgers [get]
+func (ctl *ConfigController) GetLedgers(c *gin.Context) {
+	ctl.response(
+		c,
+		http.StatusOK,
+		ctl.ledgers(c.Request),
+	)
+}
+
+// PostLedger godoc
+// @Summary Create Ledger
+// @Description Provision the storage of a new ledger, which can then be used without restarting the server
+// @Tags server
+// @Schemes
+// @Param name path string true "name"
+// @Accept json
+// @Produce json
+// @Success 200 {object} controllers.BaseResponse
+// @Failure 400 {object} controllers.BaseResponse
+// @Failure 409 {object} controllers.BaseResponse
+// @Router /_ledgers/{name} [post]
+func (ctl *ConfigController) PostLedger(c *gin.Context) {
+	err := ctl.Resolver.CreateLedger(c.Request.Context(), c.Param("name"))
+	if err != nil {
+		ctl.responseError(
+			c,
+			errorStatus(err),
+			err,
+		)
+		return
+	}
+	ctl.response(
+		c,
+		http.StatusOK,
+		nil,
+	)
+}
+
+// PostConfigReload godoc
+// @Summary Reload Configuration
+// @Description Apply again the settings of the configuration which can be changed without restarting the server:
+// @Description the log level, the limits, the rate limits and the CORS policy. The other settings are ignored.
+// @Tags server
+// @Schemes
+// @Produce json
+// @Success 200 {object} controllers.BaseResponse
+// @Failure 500 {object} controllers.BaseResponse
+// @Failure 501 {object} controllers.BaseResponse
+// @Router /_config/reload [post]
+func (ctl *ConfigController) PostConfigReload(c *gin.Context) {
+	if ctl.Reloader == nil {
+		ctl.responseError(
+			c,
+			http.StatusNotImplemented,
+			errors.New("the configuration can't be reloaded"),
+		)
+		return
+	}
+	if err := ctl.Reloader.Reload(); err != nil {
+		ctl.responseError(
+			c,
+			http.StatusInternalServerError,
+			err,
+		)
+		return
+	}
+	ctl.response(
+		c,
+		http.StatusOK,
+		nil,
+	)
+}
+
 func (ctl *ConfigController) GetDocs(c *gin.Context) {
 	doc, err := swag.ReadDoc("swagger")
 	if err != nil {
@@ -1,15 +1,22 @@
 package controllers
 
 import (
+	"go.opentelemetry.io/otel"
 	"go.uber.org/fx"
 )
 
+var tracer = otel.Tracer("github.com/numary/ledger/pkg/api/controllers")
+
 var Module = fx.Options(
 	fx.Provide(
-		fx.Annotate(NewConfigController, fx.ParamTags(`name:"version"`, `name:"storageDriver"`, `name:"ledgerLister"`)),
+		fx.Annotate(NewConfigController, fx.ParamTags(`name:"version"`, `name:"storageDriver"`, `name:"ledgerLister"`, ``, `name:"configReloader"`)),
+	),
+	fx.Provide(
+		fx.Annotate(NewLedgerController, fx.ParamTags(``, `name:"allowLedgerDelete"`)),
 	),
-	fx.Provide(NewLedgerController),
 	fx.Provide(NewScriptController),
 	fx.Provide(NewAccountController),
 	fx.Provide(NewTransactionController),
+	fx.Provide(NewWebhookController),
+	fx.Provide(NewHoldController),
 )
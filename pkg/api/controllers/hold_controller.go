@@ -0,0 +1,192 @@
+package controllers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/numary/ledger/pkg/ledger"
+)
+
+// HoldController -
+type HoldController struct {
+	BaseController
+}
+
+// NewHoldController -
+func NewHoldController() HoldController {
+	return HoldController{}
+}
+
+// HoldRequest is the body of a hold
+type HoldRequest struct {
+	Account string `json:"account"`
+	// Destination is the account credited by the transaction capturing the hold
+	Destination string `json:"destination"`
+	Asset       string `json:"asset"`
+	Amount      int64  `json:"amount"`
+	// ExpiresAt is the time after which the hold is released, the hold doesn't expire if missing
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// PostHold godoc
+// @Summary Hold funds
+// @Description Reserve an amount of an account, unavailable to the other transactions until the hold is captured
+// @Description into a transaction to its destination, or released
+// @Tags holds
+// @Schemes
+// @Param ledger path string true "ledger"
+// @Param hold body controllers.HoldRequest true "hold"
+// @Accept json
+// @Produce json
+// @Success 200 {object} controllers.BaseResponse{data=core.Hold}
+// @Failure 400 {object} controllers.BaseResponse
+// @Router /{ledger}/holds [post]
+func (ctl *HoldController) PostHold(c *gin.Context) {
+	l, _ := c.Get("ledger")
+
+	var req HoldRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		ctl.responseError(c, http.StatusBadRequest, err)
+		return
+	}
+	var expiresAt time.Time
+	if req.ExpiresAt != nil {
+		expiresAt = *req.ExpiresAt
+	}
+
+	hold, err := l.(*ledger.Ledger).Hold(c.Request.Context(), req.Account, req.Destination, req.Asset, req.Amount, expiresAt)
+	if err != nil {
+		ctl.responseError(
+			c,
+			errorStatus(err),
+			err,
+		)
+		return
+	}
+	ctl.response(
+		c,
+		http.StatusOK,
+		hold,
+	)
+}
+
+// GetHold godoc
+// @Summary Get a hold
+// @Tags holds
+// @Schemes
+// @Param ledger path string true "ledger"
+// @Param id path string true "id"
+// @Produce json
+// @Success 200 {object} controllers.BaseResponse{data=core.Hold}
+// @Failure 404 {object} controllers.BaseResponse
+// @Router /{ledger}/holds/{id} [get]
+func (ctl *HoldController) GetHold(c *gin.Context) {
+	l, _ := c.Get("ledger")
+
+	hold, err := l.(*ledger.Ledger).GetHold(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		ctl.responseError(
+			c,
+			errorStatus(err),
+			err,
+		)
+		return
+	}
+	ctl.response(
+		c,
+		http.StatusOK,
+		hold,
+	)
+}
+
+// GetAccountHolds godoc
+// @Summary List the holds of an account
+// @Description List the active holds of an account, the oldest first
+// @Tags holds
+// @Schemes
+// @Param ledger path string true "ledger"
+// @Param accountId path string true "accountId"
+// @Produce json
+// @Success 200 {object} controllers.BaseResponse{data=[]core.Hold}
+// @Router /{ledger}/accounts/{accountId}/holds [get]
+func (ctl *HoldController) GetAccountHolds(c *gin.Context) {
+	l, _ := c.Get("ledger")
+
+	holds, err := l.(*ledger.Ledger).GetHolds(c.Request.Context(), c.Param("address"))
+	if err != nil {
+		ctl.responseError(
+			c,
+			errorStatus(err),
+			err,
+		)
+		return
+	}
+	ctl.response(
+		c,
+		http.StatusOK,
+		holds,
+	)
+}
+
+// CaptureHold godoc
+// @Summary Capture a hold
+// @Description Commit the transaction of an active hold, from its account to its destination
+// @Tags holds
+// @Schemes
+// @Param ledger path string true "ledger"
+// @Param id path string true "id"
+// @Produce json
+// @Success 200 {object} controllers.BaseResponse{data=core.Transaction}
+// @Failure 404 {object} controllers.BaseResponse
+// @Failure 409 {object} controllers.BaseResponse
+// @Router /{ledger}/holds/{id}/capture [post]
+func (ctl *HoldController) CaptureHold(c *gin.Context) {
+	l, _ := c.Get("ledger")
+
+	tx, err := l.(*ledger.Ledger).Capture(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		ctl.responseError(
+			c,
+			errorStatus(err),
+			err,
+		)
+		return
+	}
+	ctl.response(
+		c,
+		http.StatusOK,
+		tx,
+	)
+}
+
+// ReleaseHold godoc
+// @Summary Release a hold
+// @Description Give up an active or expired hold, making its amount available again
+// @Tags holds
+// @Schemes
+// @Param ledger path string true "ledger"
+// @Param id path string true "id"
+// @Produce json
+// @Success 200 {object} controllers.BaseResponse
+// @Failure 404 {object} controllers.BaseResponse
+// @Failure 409 {object} controllers.BaseResponse
+// @Router /{ledger}/holds/{id}/release [post]
+func (ctl *HoldController) ReleaseHold(c *gin.Context) {
+	l, _ := c.Get("ledger")
+
+	err := l.(*ledger.Ledger).Release(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		ctl.responseError(
+			c,
+			errorStatus(err),
+			err,
+		)
+		return
+	}
+	ctl.response(
+		c,
+		http.StatusOK,
+		nil,
+	)
+}
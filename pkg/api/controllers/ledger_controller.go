@@ -1,28 +1,36 @@
 package controllers
 
 import (
+	"errors"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
 	"github.com/numary/ledger/pkg/ledger"
+	"github.com/sirupsen/logrus"
 )
 
 // LedgerController -
 type LedgerController struct {
 	BaseController
+	Resolver *ledger.Resolver
+	// AllowDelete enables the DeleteLedger route
+	AllowDelete bool
 }
 
 // NewLedgerController -
-func NewLedgerController() LedgerController {
-	return LedgerController{}
+func NewLedgerController(resolver *ledger.Resolver, allowDelete bool) LedgerController {
+	return LedgerController{
+		Resolver:    resolver,
+		AllowDelete: allowDelete,
+	}
 }
 
 // GetStats godoc
 // @Summary Get Stats
-// @Description Get ledger stats (aggregate metrics on accounts and transactions)
+// @Description Get ledger stats: the number of transactions and accounts, the volume of each asset,
+// @Description the timestamps of the first and last transactions and the hash of the last one
 // @Tags stats
 // @Schemes
-// @Description The stats for account
 // @Accept json
 // @Produce json
 // @Param ledger path string true "ledger"
@@ -31,7 +39,7 @@ func NewLedgerController() LedgerController {
 func (ctl *LedgerController) GetStats(c *gin.Context) {
 	l, _ := c.Get("ledger")
 
-	stats, err := l.(*ledger.Ledger).Stats(c)
+	stats, err := l.(*ledger.Ledger).Stats(c.Request.Context())
 	if err != nil {
 		ctl.responseError(
 			c,
@@ -46,3 +54,280 @@ func (ctl *LedgerController) GetStats(c *gin.Context) {
 		stats,
 	)
 }
+
+// GetAssets godoc
+// @Summary List the assets
+// @Description List the distinct assets of the ledger, sorted: the ones of the postings along with the registered ones
+// @Tags stats
+// @Schemes
+// @Produce json
+// @Param ledger path string true "ledger"
+// @Success 200 {object} controllers.BaseResponse{data=[]string}
+// @Router /{ledger}/assets [get]
+func (ctl *LedgerController) GetAssets(c *gin.Context) {
+	l, _ := c.Get("ledger")
+
+	assets, err := l.(*ledger.Ledger).GetAssets(c.Request.Context())
+	if err != nil {
+		ctl.responseError(
+			c,
+			errorStatus(err),
+			err,
+		)
+		return
+	}
+	ctl.response(
+		c,
+		http.StatusOK,
+		assets,
+	)
+}
+
+// PutMetadataSchema godoc
+// @Summary Set the schema of the metadata
+// @Description Register the JSON Schema the metadata of the accounts or of the transactions must conform to,
+// @Description replacing the previous one. The metadata already saved are not validated again.
+// @Tags metadata
+// @Schemes
+// @Param ledger path string true "ledger"
+// @Param type path string true "account or transaction"
+// @Param schema body object true "JSON Schema"
+// @Accept json
+// @Produce json
+// @Success 200 {object} controllers.BaseResponse
+// @Failure 400 {object} controllers.BaseResponse
+// @Router /{ledger}/schemas/{type} [put]
+func (ctl *LedgerController) PutMetadataSchema(c *gin.Context) {
+	l, _ := c.Get("ledger")
+
+	schema, err := c.GetRawData()
+	if err != nil {
+		ctl.responseError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	err = l.(*ledger.Ledger).SetMetadataSchema(c.Request.Context(), c.Param("type"), schema)
+	if err != nil {
+		ctl.responseError(
+			c,
+			errorStatus(err),
+			err,
+		)
+		return
+	}
+	ctl.response(
+		c,
+		http.StatusOK,
+		nil,
+	)
+}
+
+// GetMetadataSchema godoc
+// @Summary Get the schema of the metadata
+// @Tags metadata
+// @Schemes
+// @Param ledger path string true "ledger"
+// @Param type path string true "account or transaction"
+// @Produce json
+// @Success 200 {object} controllers.BaseResponse{data=object}
+// @Failure 404 {object} controllers.BaseResponse
+// @Router /{ledger}/schemas/{type} [get]
+func (ctl *LedgerController) GetMetadataSchema(c *gin.Context) {
+	l, _ := c.Get("ledger")
+
+	schema, err := l.(*ledger.Ledger).GetMetadataSchema(c.Request.Context(), c.Param("type"))
+	if err != nil {
+		ctl.responseError(
+			c,
+			errorStatus(err),
+			err,
+		)
+		return
+	}
+	ctl.response(
+		c,
+		http.StatusOK,
+		schema,
+	)
+}
+
+// DeleteMetadataSchema godoc
+// @Summary Delete the schema of the metadata
+// @Description Remove the schema of the metadata of the accounts or of the transactions, which are then free-form
+// @Tags metadata
+// @Schemes
+// @Param ledger path string true "ledger"
+// @Param type path string true "account or transaction"
+// @Produce json
+// @Success 200 {object} controllers.BaseResponse
+// @Router /{ledger}/schemas/{type} [delete]
+func (ctl *LedgerController) DeleteMetadataSchema(c *gin.Context) {
+	l, _ := c.Get("ledger")
+
+	err := l.(*ledger.Ledger).DeleteMetadataSchema(c.Request.Context(), c.Param("type"))
+	if err != nil {
+		ctl.responseError(
+			c,
+			errorStatus(err),
+			err,
+		)
+		return
+	}
+	ctl.response(
+		c,
+		http.StatusOK,
+		nil,
+	)
+}
+
+// Head is the last transaction of a ledger, see ledger.Ledger.Head
+type Head struct {
+	// ID is the id of the last transaction, -1 if there is none
+	ID    int64  `json:"txid"`
+	Hash  string `json:"hash"`
+	Count int64  `json:"count"`
+}
+
+// GetHead godoc
+// @Summary Get the head of the chain
+// @Description Get the id and the hash of the last transaction along with the number of transactions, without reading the transaction,
+// @Description to watch the chain: the hash of an already seen id changing means that the ledger has been tampered with
+// @Tags ledger
+// @Schemes
+// @Produce json
+// @Param ledger path string true "ledger"
+// @Success 200 {object} controllers.BaseResponse{data=controllers.Head}
+// @Router /{ledger}/head [get]
+func (ctl *LedgerController) GetHead(c *gin.Context) {
+	l, _ := c.Get("ledger")
+
+	txid, hash, count, err := l.(*ledger.Ledger).Head(c.Request.Context())
+	if err != nil {
+		ctl.responseError(
+			c,
+			errorStatus(err),
+			err,
+		)
+		return
+	}
+	ctl.response(
+		c,
+		http.StatusOK,
+		Head{
+			ID:    txid,
+			Hash:  hash,
+			Count: count,
+		},
+	)
+}
+
+// Export godoc
+// @Summary Export Ledger
+// @Description Export the ledger as newline-delimited JSON: a header, then every transaction in chain order
+// @Tags ledger
+// @Schemes
+// @Produce application/x-ndjson
+// @Param ledger path string true "ledger"
+// @Success 200 {string} string
+// @Router /{ledger}/export [get]
+func (ctl *LedgerController) Export(c *gin.Context) {
+	l, _ := c.Get("ledger")
+
+	c.Header("Content-Type", "application/x-ndjson")
+	err := l.(*ledger.Ledger).Export(c.Request.Context(), c.Writer)
+	if err != nil {
+		if !c.Writer.Written() {
+			c.Header("Content-Type", "")
+			ctl.responseError(
+				c,
+				errorStatus(err),
+				err,
+			)
+			return
+		}
+		// The status has been sent along with the first records, the export is truncated
+		logrus.Errorf("exporting ledger %s: %s", c.Param("ledger"), err)
+	}
+}
+
+// Import godoc
+// @Summary Import Ledger
+// @Description Import an export of a ledger, verifying the hash chain of its transactions
+// @Tags ledger
+// @Schemes
+// @Accept application/x-ndjson
+// @Produce json
+// @Param ledger path string true "ledger"
+// @Param force query bool false "import into a ledger which already has the first transactions of the export"
+// @Success 200 {object} controllers.BaseResponse
+// @Failure 400 {object} controllers.BaseResponse
+// @Failure 409 {object} controllers.BaseResponse
+// @Router /{ledger}/import [post]
+func (ctl *LedgerController) Import(c *gin.Context) {
+	l, _ := c.Get("ledger")
+
+	err := l.(*ledger.Ledger).Import(c.Request.Context(), c.Request.Body, ledger.ImportOptions{
+		Force: c.Query("force") == "true",
+	})
+	if err != nil {
+		ctl.responseError(
+			c,
+			errorStatus(err),
+			err,
+		)
+		return
+	}
+	ctl.response(
+		c,
+		http.StatusOK,
+		nil,
+	)
+}
+
+// DeleteLedger godoc
+// @Summary Delete Ledger
+// @Description Drop all the transactions, accounts and metadata of the ledger. The deletion must be allowed
+// @Description by the server configuration, and confirmed by the name of the ledger.
+// @Tags ledger
+// @Schemes
+// @Param ledger path string true "ledger"
+// @Param confirm query string true "name of the ledger"
+// @Accept json
+// @Produce json
+// @Success 200 {object} controllers.BaseResponse
+// @Failure 400 {object} controllers.BaseResponse
+// @Failure 403 {object} controllers.BaseResponse
+// @Router /{ledger} [delete]
+func (ctl *LedgerController) DeleteLedger(c *gin.Context) {
+	if !ctl.AllowDelete {
+		ctl.responseError(
+			c,
+			http.StatusForbidden,
+			errors.New("ledger deletion is disabled by the server configuration"),
+		)
+		return
+	}
+	if c.Query("confirm") != c.Param("ledger") {
+		ctl.responseError(
+			c,
+			http.StatusBadRequest,
+			errors.New("the 'confirm' query param must be the name of the ledger"),
+		)
+		return
+	}
+
+	err := ctl.Resolver.DropLedger(c.Request.Context(), c.Param("ledger"))
+	if err != nil {
+		ctl.responseError(
+			c,
+			errorStatus(err),
+			err,
+		)
+		return
+	}
+	ctl.response(
+		c,
+		http.StatusOK,
+		nil,
+	)
+}
@@ -3,7 +3,9 @@ package controllers
 import (
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net/http"
 	"strings"
 
 	"github.com/gin-gonic/gin"
@@ -23,14 +25,14 @@ func NewScriptController() ScriptController {
 
 // PostScript godoc
 // @Summary Execute Numscript
-// @Description Execute a Numscript and create the transaction if any
+// @Description Execute a Numscript and create the transaction if any, returned along with the values of the script variables
 // @Tags script
 // @Schemes
 // @Param ledger path string true "ledger"
 // @Param script body core.Script true "script"
 // @Accept json
 // @Produce json
-// @Success 200 {object} controllers.BaseResponse
+// @Success 200 {object} controllers.BaseResponse{data=ledger.ScriptResult}
 // @Router /{ledger}/script [post]
 func (ctl *ScriptController) PostScript(c *gin.Context) {
 	l, _ := c.Get("ledger")
@@ -38,8 +40,154 @@ func (ctl *ScriptController) PostScript(c *gin.Context) {
 	var script core.Script
 	c.ShouldBind(&script)
 
-	err := l.(*ledger.Ledger).Execute(c, script)
+	result, err := l.(*ledger.Ledger).Execute(c.Request.Context(), script)
 
+	res := scriptResponse(err)
+	if err == nil {
+		res["data"] = result
+	}
+
+	c.JSON(200, res)
+}
+
+// PostScriptPreview godoc
+// @Summary Preview Numscript execution
+// @Description Execute a Numscript against the current balances and return the generated postings and balance deltas, without committing
+// @Tags script
+// @Schemes
+// @Param ledger path string true "ledger"
+// @Param script body core.Script true "script"
+// @Accept json
+// @Produce json
+// @Success 200 {object} controllers.BaseResponse{data=ledger.ScriptPreview}
+// @Router /{ledger}/script/preview [post]
+func (ctl *ScriptController) PostScriptPreview(c *gin.Context) {
+	l, _ := c.Get("ledger")
+
+	var script core.Script
+	c.ShouldBind(&script)
+
+	preview, err := l.(*ledger.Ledger).ExecutePreview(c.Request.Context(), script)
+
+	res := scriptResponse(err)
+	if err == nil {
+		res["data"] = preview
+	}
+
+	c.JSON(200, res)
+}
+
+// StoredScriptRequest is the body saving a new version of a stored script
+type StoredScriptRequest struct {
+	Name  string `json:"name"`
+	Plain string `json:"plain"`
+}
+
+// StoredScriptRun is the body running a stored script, with its last version unless a version is pinned
+type StoredScriptRun struct {
+	Vars    map[string]json.RawMessage `json:"vars"`
+	Version int64                      `json:"version,omitempty"`
+}
+
+// PostStoredScript godoc
+// @Summary Store a script
+// @Description Save a new version of a named script, numbered after its last version
+// @Tags script
+// @Schemes
+// @Param ledger path string true "ledger"
+// @Param script body controllers.StoredScriptRequest true "script"
+// @Accept json
+// @Produce json
+// @Success 200 {object} controllers.BaseResponse{data=core.StoredScript}
+// @Router /{ledger}/scripts [post]
+func (ctl *ScriptController) PostStoredScript(c *gin.Context) {
+	l, _ := c.Get("ledger")
+
+	var req StoredScriptRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		ctl.responseError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	script, err := l.(*ledger.Ledger).SaveScript(c.Request.Context(), req.Name, req.Plain)
+	if err != nil {
+		ctl.responseError(
+			c,
+			errorStatus(err),
+			err,
+		)
+		return
+	}
+
+	ctl.response(
+		c,
+		http.StatusOK,
+		script,
+	)
+}
+
+// GetStoredScripts godoc
+// @Summary List the stored scripts
+// @Description List the last version of each stored script, sorted by name
+// @Tags script
+// @Schemes
+// @Param ledger path string true "ledger"
+// @Produce json
+// @Success 200 {object} controllers.BaseResponse{data=[]core.StoredScript}
+// @Router /{ledger}/scripts [get]
+func (ctl *ScriptController) GetStoredScripts(c *gin.Context) {
+	l, _ := c.Get("ledger")
+
+	scripts, err := l.(*ledger.Ledger).Scripts(c.Request.Context())
+	if err != nil {
+		ctl.responseError(
+			c,
+			errorStatus(err),
+			err,
+		)
+		return
+	}
+
+	ctl.response(
+		c,
+		http.StatusOK,
+		scripts,
+	)
+}
+
+// RunStoredScript godoc
+// @Summary Run a stored script
+// @Description Execute the last version of a stored script, or the version pinned in the body, like a Numscript
+// @Tags script
+// @Schemes
+// @Param ledger path string true "ledger"
+// @Param name path string true "name"
+// @Param run body controllers.StoredScriptRun true "run"
+// @Accept json
+// @Produce json
+// @Success 200 {object} controllers.BaseResponse{data=ledger.ScriptResult}
+// @Router /{ledger}/scripts/{name}/run [post]
+func (ctl *ScriptController) RunStoredScript(c *gin.Context) {
+	l, _ := c.Get("ledger")
+
+	var run StoredScriptRun
+	c.ShouldBind(&run)
+
+	result, err := l.(*ledger.Ledger).ExecuteScript(c.Request.Context(), c.Param("name"), run.Version, run.Vars)
+	if errors.Is(err, ledger.ErrScriptNotFound) {
+		ctl.responseError(c, http.StatusNotFound, err)
+		return
+	}
+
+	res := scriptResponse(err)
+	if err == nil {
+		res["data"] = result
+	}
+
+	c.JSON(200, res)
+}
+
+func scriptResponse(err error) gin.H {
 	res := gin.H{
 		"ok": err == nil,
 	}
@@ -59,5 +207,5 @@ func (ctl *ScriptController) PostScript(c *gin.Context) {
 		res["details"] = link
 	}
 
-	c.JSON(200, res)
+	return res
 }
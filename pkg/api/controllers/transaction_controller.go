@@ -2,12 +2,20 @@ package controllers
 
 import (
 	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/numary/ledger/pkg/core"
 	"github.com/numary/ledger/pkg/ledger"
 	"github.com/numary/ledger/pkg/ledger/query"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // TransactionController -
@@ -25,24 +33,71 @@ func NewTransactionController() TransactionController {
 // @Description Get all ledger transactions
 // @Tags transactions
 // @Schemes
-// @Description List transactions
+// @Description List the transactions matching all the filters
 // @Param ledger path string true "ledger"
+// @Param page_size query int false "page size, clamped to the maximum page size"
+// @Param limit query int false "alias of page_size"
+// @Param pagination_token query string false "pagination token"
+// @Param order query string false "order of the ids, desc (default) or asc"
+// @Param account query string false "account address or pattern, like users:*"
+// @Param asset query string false "asset"
+// @Param reference query string false "reference"
+// @Param reference_prefix query string false "prefix of the references"
+// @Param after_timestamp query string false "RFC3339 time the transactions are strictly after"
+// @Param before_timestamp query string false "RFC3339 time the transactions are strictly before"
+// @Param metadata query object false "metadata filters, like metadata[customer.id]=42, matching the nested keys"
 // @Accept json
 // @Produce json
 // @Success 200 {object} controllers.BaseResponse{cursor=query.Cursor{data=[]core.Transaction}}
+// @Failure 400 {object} controllers.BaseResponse
 // @Router /{ledger}/transactions [get]
 func (ctl *TransactionController) GetTransactions(c *gin.Context) {
 	l, _ := c.Get("ledger")
-	cursor, err := l.(*ledger.Ledger).FindTransactions(
-		c,
-		query.After(c.Query("after")),
-		query.Reference(c.Query("reference")),
-		query.Account(c.Query("account")),
-	)
+
+	modifiers, err := ctl.paginationModifiers(c)
 	if err != nil {
 		ctl.responseError(
 			c,
-			http.StatusInternalServerError,
+			http.StatusBadRequest,
+			err,
+		)
+		return
+	}
+
+	// The pagination tokens carry the order of the first page
+	if c.Query("pagination_token") == "" && c.Query("order") != "" {
+		switch c.Query("order") {
+		case "asc", "desc":
+			modifiers = append(modifiers, query.SortTransactions(c.Query("order") == "asc"))
+		default:
+			ctl.responseError(
+				c,
+				http.StatusBadRequest,
+				errors.New("invalid 'order' query param: expected 'asc' or 'desc'"),
+			)
+			return
+		}
+	}
+
+	filters, err := transactionFilters(c)
+	if err != nil {
+		ctl.responseError(
+			c,
+			http.StatusBadRequest,
+			err,
+		)
+		return
+	}
+	modifiers = append(modifiers, filters...)
+	if c.Query("after") != "" {
+		modifiers = append(modifiers, query.After(c.Query("after")))
+	}
+
+	cursor, err := l.(*ledger.Ledger).FindTransactions(c.Request.Context(), modifiers...)
+	if err != nil {
+		ctl.responseError(
+			c,
+			errorStatus(err),
 			err,
 		)
 		return
@@ -54,6 +109,33 @@ func (ctl *TransactionController) GetTransactions(c *gin.Context) {
 	)
 }
 
+// transactionFilters returns the filters of the transactions set by the query params
+func transactionFilters(c *gin.Context) ([]query.QueryModifier, error) {
+	modifiers := []query.QueryModifier{
+		query.Reference(c.Query("reference")),
+		query.ReferencePrefix(c.Query("reference_prefix")),
+		query.Account(c.Query("account")),
+		query.Asset(c.Query("asset")),
+	}
+	for key, value := range c.QueryMap("metadata") {
+		modifiers = append(modifiers, query.Metadata(key, value))
+	}
+	for param, modifier := range map[string]func(time.Time) func(*query.Query){
+		"after_timestamp":  query.TimestampAfter,
+		"before_timestamp": query.TimestampBefore,
+	} {
+		if c.Query(param) == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, c.Query(param))
+		if err != nil {
+			return nil, fmt.Errorf("invalid '%s' query param: expected RFC3339 format", param)
+		}
+		modifiers = append(modifiers, modifier(t))
+	}
+	return modifiers, nil
+}
+
 // PostTransactions godoc
 // @Summary Create Transaction
 // @Description Create a new ledger transaction
@@ -62,21 +144,44 @@ func (ctl *TransactionController) GetTransactions(c *gin.Context) {
 // @Description Commit a new transaction to the ledger
 // @Param ledger path string true "ledger"
 // @Param transaction body core.Transaction true "transaction"
+// @Param Idempotency-Key header string false "idempotency key"
 // @Accept json
 // @Produce json
 // @Success 200 {object} controllers.BaseResponse
+// @Failure 400 {object} controllers.BaseResponse
+// @Failure 409 {object} controllers.BaseResponse
+// @Failure 504 {object} controllers.BaseResponse
 // @Router /{ledger}/transactions [post]
 func (ctl *TransactionController) PostTransaction(c *gin.Context) {
+	// The span is started from the request context, as the gin context doesn't carry its values,
+	// continuing the trace of the caller if any
+	ctx := otel.GetTextMapPropagator().Extract(c.Request.Context(), propagation.HeaderCarrier(c.Request.Header))
+	ctx, span := tracer.Start(ctx, "PostTransaction", trace.WithAttributes(
+		attribute.String("ledger", c.Param("ledger")),
+	))
+	defer span.End()
+
 	l, _ := c.Get("ledger")
 
 	var t core.Transaction
-	c.ShouldBind(&t)
+	if err := c.ShouldBind(&t); err != nil {
+		ctl.responseError(c, http.StatusBadRequest, err)
+		return
+	}
 
-	ts, err := l.(*ledger.Ledger).Commit(c, []core.Transaction{t})
+	var (
+		ts  []core.Transaction
+		err error
+	)
+	if key := c.GetHeader("Idempotency-Key"); key != "" {
+		ts, err = l.(*ledger.Ledger).CommitWithKey(ctx, key, []core.Transaction{t})
+	} else {
+		ts, err = l.(*ledger.Ledger).Commit(ctx, []core.Transaction{t})
+	}
 	if err != nil {
 		ctl.responseError(
 			c,
-			http.StatusInternalServerError,
+			errorStatus(err),
 			err,
 		)
 		return
@@ -88,9 +193,286 @@ func (ctl *TransactionController) PostTransaction(c *gin.Context) {
 	)
 }
 
+// TransactionsBatch is the body of the batch route
+type TransactionsBatch struct {
+	Transactions []core.Transaction `json:"transactions"`
+	// Atomic commits the transactions all or nothing, instead of each on its own
+	Atomic bool `json:"atomic"`
+	// Preconditions must hold for the atomic batch to be committed, see ledger.CommitOptions
+	Preconditions []ledger.BalancePrecondition `json:"preconditions,omitempty"`
+}
+
+// TransactionResult is the outcome of a transaction of a batch, either its id or the error rejecting it
+type TransactionResult struct {
+	Index     int    `json:"index"`
+	TxID      *int64 `json:"txid,omitempty"`
+	Error     string `json:"error,omitempty"`
+	ErrorCode int    `json:"error_code,omitempty"`
+}
+
+// TransactionWithLinks is a transaction along with the ids of the transactions it reverts or is reverted by,
+// read from its metadata
+type TransactionWithLinks struct {
+	core.Transaction
+	Reverts             *int64  `json:"reverts,omitempty"`
+	RevertedBy          *int64  `json:"reverted_by,omitempty"`
+	PartiallyRevertedBy []int64 `json:"partially_reverted_by,omitempty"`
+}
+
+// withLinks reads the links of a transaction to the transactions it reverts or is reverted by
+func withLinks(tx core.Transaction) TransactionWithLinks {
+	res := TransactionWithLinks{
+		Transaction: tx,
+	}
+	if id, ok := tx.Metadata.Reverts(); ok {
+		res.Reverts = &id
+	}
+	if id, ok := tx.Metadata.RevertedBy(); ok {
+		res.RevertedBy = &id
+	}
+	if ids, _, err := tx.Metadata.PartialReverts(); err == nil {
+		for _, v := range ids {
+			if id, err := strconv.ParseInt(v, 10, 64); err == nil {
+				res.PartiallyRevertedBy = append(res.PartiallyRevertedBy, id)
+			}
+		}
+	}
+	return res
+}
+
+// PostTransactionsBatch godoc
+// @Summary Create Transactions
+// @Description Commit many transactions at once. Unless the batch is atomic, each transaction is committed on its own
+// @Description and the failures are reported along with the ids of the committed transactions, in the order of the batch.
+// @Description An atomic batch is rejected as a whole if any of its transactions is, or if any of its preconditions on the balances doesn't hold.
+// @Tags transactions
+// @Schemes
+// @Param ledger path string true "ledger"
+// @Param batch body controllers.TransactionsBatch true "batch"
+// @Param Idempotency-Key header string false "idempotency key, only for the atomic batches"
+// @Accept json
+// @Produce json
+// @Success 200 {object} controllers.BaseResponse{data=[]controllers.TransactionResult}
+// @Failure 400 {object} controllers.BaseResponse
+// @Failure 409 {object} controllers.BaseResponse
+// @Router /{ledger}/transactions/batch [post]
+func (ctl *TransactionController) PostTransactionsBatch(c *gin.Context) {
+	ctx := otel.GetTextMapPropagator().Extract(c.Request.Context(), propagation.HeaderCarrier(c.Request.Header))
+	ctx, span := tracer.Start(ctx, "PostTransactionsBatch", trace.WithAttributes(
+		attribute.String("ledger", c.Param("ledger")),
+	))
+	defer span.End()
+
+	l, _ := c.Get("ledger")
+
+	var batch TransactionsBatch
+	if err := c.ShouldBindJSON(&batch); err != nil {
+		ctl.responseError(c, http.StatusBadRequest, err)
+		return
+	}
+	if len(batch.Transactions) == 0 {
+		ctl.responseError(c, http.StatusBadRequest, errors.New("empty batch"))
+		return
+	}
+	key := c.GetHeader("Idempotency-Key")
+	if key != "" && !batch.Atomic {
+		ctl.responseError(c, http.StatusBadRequest, errors.New("idempotency keys are only supported by atomic batches"))
+		return
+	}
+	if len(batch.Preconditions) > 0 && !batch.Atomic {
+		ctl.responseError(c, http.StatusBadRequest, errors.New("preconditions are only supported by atomic batches"))
+		return
+	}
+
+	results := make([]TransactionResult, len(batch.Transactions))
+	if batch.Atomic {
+		ts, err := l.(*ledger.Ledger).CommitWithOptions(ctx, batch.Transactions, ledger.CommitOptions{
+			IdempotencyKey: key,
+			Preconditions:  batch.Preconditions,
+		})
+		if err != nil {
+			ctl.responseError(
+				c,
+				errorStatus(err),
+				err,
+			)
+			return
+		}
+		for i := range ts {
+			results[i] = TransactionResult{
+				Index: i,
+				TxID:  &ts[i].ID,
+			}
+		}
+	} else {
+		for i, t := range batch.Transactions {
+			results[i].Index = i
+			ts, err := l.(*ledger.Ledger).Commit(ctx, []core.Transaction{t})
+			// The index of the transaction in its own batch would always be 0
+			var txErr *ledger.TransactionError
+			if errors.As(err, &txErr) {
+				err = txErr.Err
+			}
+			if err != nil {
+				results[i].Error = err.Error()
+				results[i].ErrorCode = errorStatus(err)
+				continue
+			}
+			results[i].TxID = &ts[0].ID
+		}
+	}
+
+	ctl.response(
+		c,
+		http.StatusOK,
+		results,
+	)
+}
+
+// StreamTransactions godoc
+// @Summary Stream the committed Transactions
+// @Description Push the transactions matching the filters as server-sent events, as they are committed: a "transaction" event per transaction,
+// @Description with the id of the transaction as event id. The stream starts from now, or resumes after the transaction of the "after" query param,
+// @Description or of the Last-Event-ID header sent by the reconnecting clients. The clients receiving the transactions slower than they are committed
+// @Description are sent an "error" event, and the stream is closed.
+// @Tags transactions
+// @Schemes
+// @Param ledger path string true "ledger"
+// @Param account query string false "account"
+// @Param asset query string false "asset"
+// @Param after query int false "id of the last transaction received"
+// @Param Last-Event-ID header int false "id of the last transaction received"
+// @Produce text/event-stream
+// @Success 200 {object} core.Transaction
+// @Failure 400 {object} controllers.BaseResponse
+// @Router /{ledger}/transactions/stream [get]
+func (ctl *TransactionController) StreamTransactions(c *gin.Context) {
+	l, _ := c.Get("ledger")
+
+	opts := ledger.FollowOptions{}
+	after := c.Query("after")
+	if after == "" {
+		after = c.GetHeader("Last-Event-ID")
+	}
+	if after != "" {
+		id, err := strconv.ParseInt(after, 10, 64)
+		if err != nil || id < 0 {
+			ctl.responseError(
+				c,
+				http.StatusBadRequest,
+				errors.New("invalid 'after' query param: expected a transaction id"),
+			)
+			return
+		}
+		opts.After = &id
+	}
+
+	txs, errs := l.(*ledger.Ledger).FollowTransactions(c.Request.Context(), opts,
+		query.Account(c.Query("account")),
+		query.Asset(c.Query("asset")),
+	)
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+
+	ticker := time.NewTicker(eventsKeepAlive)
+	defer ticker.Stop()
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case tx, ok := <-txs:
+			if !ok {
+				// The clients gone are not sent the error of the context
+				if err := <-errs; err != nil && c.Request.Context().Err() == nil {
+					writeEvent(w, "", "error", gin.H{
+						"error": err.Error(),
+					})
+				}
+				return false
+			}
+			return writeEvent(w, strconv.FormatInt(tx.ID, 10), "transaction", tx) == nil
+		case <-ticker.C:
+			return keepAlive(w) == nil
+		}
+	})
+}
+
+// StreamPostingLog godoc
+// @Summary Stream the Posting Log
+// @Description Push the postings as server-sent events, in the order of their commits, from the first transaction or after the transaction
+// @Description of the "after" query param, or of the Last-Event-ID header sent by the reconnecting clients, and then as they are committed.
+// @Description A "posting" event is sent per posting, the last posting of each transaction having the txid as event id, so that the clients
+// @Description resume after the last transaction they have received all the postings of.
+// @Tags transactions
+// @Schemes
+// @Param ledger path string true "ledger"
+// @Param after query int false "id of the last transaction received"
+// @Param Last-Event-ID header int false "id of the last transaction received"
+// @Produce text/event-stream
+// @Success 200 {object} core.LoggedPosting
+// @Failure 400 {object} controllers.BaseResponse
+// @Router /{ledger}/postings/log [get]
+func (ctl *TransactionController) StreamPostingLog(c *gin.Context) {
+	l, _ := c.Get("ledger")
+
+	after := c.Query("after")
+	if after == "" {
+		after = c.GetHeader("Last-Event-ID")
+	}
+	txid := int64(-1)
+	if after != "" {
+		id, err := strconv.ParseInt(after, 10, 64)
+		if err != nil || id < 0 {
+			ctl.responseError(
+				c,
+				http.StatusBadRequest,
+				errors.New("invalid 'after' query param: expected a transaction id"),
+			)
+			return
+		}
+		txid = id
+	}
+
+	postings, err := l.(*ledger.Ledger).PostingLog(c.Request.Context(), txid)
+	if err != nil {
+		ctl.responseError(
+			c,
+			errorStatus(err),
+			err,
+		)
+		return
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+
+	ticker := time.NewTicker(eventsKeepAlive)
+	defer ticker.Stop()
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case p, ok := <-postings:
+			if !ok {
+				return false
+			}
+			id := ""
+			if p.Last {
+				id = strconv.FormatInt(p.Txid, 10)
+			}
+			return writeEvent(w, id, "posting", p) == nil
+		case <-ticker.C:
+			return keepAlive(w) == nil
+		}
+	})
+}
+
 // GetTransaction godoc
 // @Summary Get Transaction
-// @Description Get transaction by transaction id
+// @Description Get transaction by transaction id, along with the ids of the transactions it reverts or is reverted by
 // @Tags transactions
 // @Schemes
 // @Param ledger path string true "ledger"
@@ -102,27 +484,116 @@ func (ctl *TransactionController) PostTransaction(c *gin.Context) {
 // @Router /{ledger}/transactions/{txid} [get]
 func (ctl *TransactionController) GetTransaction(c *gin.Context) {
 	l, _ := c.Get("ledger")
-	tx, err := l.(*ledger.Ledger).GetTransaction(c, c.Param("txid"))
+	tx, err := l.(*ledger.Ledger).GetTransaction(c.Request.Context(), c.Param("txid"))
+	if err != nil {
+		ctl.responseError(
+			c,
+			errorStatus(err),
+			err,
+		)
+		return
+	}
+	version, err := l.(*ledger.Ledger).GetMetaVersion(c.Request.Context(), "transaction", fmt.Sprintf("%d", tx.ID))
+	if err != nil {
+		ctl.responseError(
+			c,
+			errorStatus(err),
+			err,
+		)
+		return
+	}
+	etag(c, version)
+	ctl.response(
+		c,
+		http.StatusOK,
+		withLinks(tx),
+	)
+}
+
+// GetTransactionByHash godoc
+// @Summary Get Transaction By Hash
+// @Description Get transaction by hash, along with the ids of the transactions it reverts or is reverted by
+// @Tags transactions
+// @Schemes
+// @Param ledger path string true "ledger"
+// @Param hash path string true "hash"
+// @Accept json
+// @Produce json
+// @Success 200 {object} controllers.BaseResponse
+// @Failure 404 {object} controllers.BaseResponse
+// @Router /{ledger}/transactions/by-hash/{hash} [get]
+func (ctl *TransactionController) GetTransactionByHash(c *gin.Context) {
+	l, _ := c.Get("ledger")
+	tx, err := l.(*ledger.Ledger).GetTransactionByHash(c.Request.Context(), c.Param("hash"))
 	if err != nil {
 		ctl.responseError(
 			c,
-			http.StatusInternalServerError,
+			errorStatus(err),
 			err,
 		)
 		return
 	}
-	if tx.Postings == nil {
+	ctl.response(
+		c,
+		http.StatusOK,
+		withLinks(tx),
+	)
+}
+
+// GetTransactionProof godoc
+// @Summary Get the proof of inclusion of a Transaction
+// @Description Get the proof that a transaction is included in the Merkle tree of the hashes of the transactions of the ledger,
+// @Description or of its first transactions, to check the proof against the root published when the ledger had that many transactions
+// @Tags transactions
+// @Schemes
+// @Param ledger path string true "ledger"
+// @Param txid path string true "txid"
+// @Param size query int false "number of transactions of the tree, all of them by default"
+// @Produce json
+// @Success 200 {object} controllers.BaseResponse{data=core.MerkleProof}
+// @Failure 400 {object} controllers.BaseResponse
+// @Failure 404 {object} controllers.BaseResponse
+// @Router /{ledger}/transactions/{txid}/proof [get]
+func (ctl *TransactionController) GetTransactionProof(c *gin.Context) {
+	l, _ := c.Get("ledger")
+
+	txid, err := strconv.ParseInt(c.Param("txid"), 10, 64)
+	if err != nil {
 		ctl.responseError(
 			c,
 			http.StatusNotFound,
-			errors.New("transaction not found"),
+			fmt.Errorf("%w: %s", ledger.ErrTransactionNotFound, c.Param("txid")),
+		)
+		return
+	}
+
+	var proof core.MerkleProof
+	if v := c.Query("size"); v != "" {
+		size, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			ctl.responseError(
+				c,
+				http.StatusBadRequest,
+				errors.New("invalid 'size' query param: expected an integer"),
+			)
+			return
+		}
+		proof, err = l.(*ledger.Ledger).InclusionProofAt(c.Request.Context(), txid, size)
+	} else {
+		proof, err = l.(*ledger.Ledger).InclusionProof(c.Request.Context(), txid)
+	}
+	if err != nil {
+		ctl.responseError(
+			c,
+			errorStatus(err),
+			err,
 		)
 		return
 	}
 	ctl.response(
 		c,
 		http.StatusOK,
-		tx,
+		proof,
 	)
 }
 
@@ -136,14 +607,16 @@ func (ctl *TransactionController) GetTransaction(c *gin.Context) {
 // @Accept json
 // @Produce json
 // @Success 200 {object} controllers.BaseResponse
+// @Failure 404 {object} controllers.BaseResponse
+// @Failure 409 {object} controllers.BaseResponse
 // @Router /{ledger}/transactions/{txid}/revert [post]
 func (ctl *TransactionController) RevertTransaction(c *gin.Context) {
 	l, _ := c.Get("ledger")
-	err := l.(*ledger.Ledger).RevertTransaction(c, c.Param("txid"))
+	err := l.(*ledger.Ledger).RevertTransaction(c.Request.Context(), c.Param("txid"))
 	if err != nil {
 		ctl.responseError(
 			c,
-			http.StatusInternalServerError,
+			errorStatus(err),
 			err,
 		)
 		return
@@ -155,33 +628,105 @@ func (ctl *TransactionController) RevertTransaction(c *gin.Context) {
 	)
 }
 
+// RevertTransactions godoc
+// @Summary Revert the matching Transactions
+// @Description Revert the transactions matching all the filters, from the newest to the oldest, in a single batch committed atomically.
+// @Description The transactions already reverted are skipped. At least one filter is required, and the revert must be confirmed with confirm=true,
+// @Description unless it is a dry run returning the reverting transactions without committing them.
+// @Tags transactions
+// @Schemes
+// @Param ledger path string true "ledger"
+// @Param confirm query bool false "confirm the revert"
+// @Param dry_run query bool false "return the reverting transactions without committing them"
+// @Param account query string false "account address or pattern, like users:*"
+// @Param asset query string false "asset"
+// @Param reference query string false "reference"
+// @Param reference_prefix query string false "prefix of the references"
+// @Param after_timestamp query string false "RFC3339 time the transactions are strictly after"
+// @Param before_timestamp query string false "RFC3339 time the transactions are strictly before"
+// @Param metadata query object false "metadata filters, like metadata[customer.id]=42, matching the nested keys"
+// @Accept json
+// @Produce json
+// @Success 200 {object} controllers.BaseResponse{data=[]core.Transaction}
+// @Failure 400 {object} controllers.BaseResponse
+// @Failure 409 {object} controllers.BaseResponse
+// @Router /{ledger}/transactions/revert [post]
+func (ctl *TransactionController) RevertTransactions(c *gin.Context) {
+	l, _ := c.Get("ledger")
+
+	filters, err := transactionFilters(c)
+	if err != nil {
+		ctl.responseError(
+			c,
+			http.StatusBadRequest,
+			err,
+		)
+		return
+	}
+
+	rts, err := l.(*ledger.Ledger).RevertMatching(c.Request.Context(), ledger.RevertMatchingOptions{
+		DryRun:  c.Query("dry_run") == "true",
+		Confirm: c.Query("confirm") == "true",
+	}, filters...)
+	if err != nil {
+		ctl.responseError(
+			c,
+			errorStatus(err),
+			err,
+		)
+		return
+	}
+	ctl.response(
+		c,
+		http.StatusOK,
+		rts,
+	)
+}
+
 // PostTransactionMetadata godoc
 // @Summary Set Transaction Metadata
-// @Description Set a new metadata to a ledger transaction by transaction id
+// @Description Set a new metadata to a ledger transaction by transaction id.
+// @Description The metadata are only saved if they are still at the version of the If-Match header, the ETag of the transaction, and the new version is sent back as ETag.
 // @Tags transactions
 // @Schemes
 // @Param ledger path string true "ledger"
 // @Param txid path string true "txid"
+// @Param If-Match header string false "expected metadata version"
 // @Accept json
 // @Produce json
 // @Success 200 {object} controllers.BaseResponse
+// @Failure 400 {object} controllers.BaseResponse
+// @Failure 409 {object} controllers.BaseResponse
 // @Router /{ledger}/transactions/{txid}/metadata [post]
 func (ctl *TransactionController) PostTransactionMetadata(c *gin.Context) {
-	l, _ := c.Get("ledger")
+	ctl.saveMeta(c, "transaction", c.Param("txid"))
+}
 
-	var m core.Metadata
-	c.ShouldBind(&m)
+// DeleteTransactionMetadata godoc
+// @Summary Delete Transaction Metadata
+// @Description Delete a metadata key of a ledger transaction by transaction id
+// @Tags transactions
+// @Schemes
+// @Param ledger path string true "ledger"
+// @Param txid path string true "txid"
+// @Param key path string true "key"
+// @Accept json
+// @Produce json
+// @Success 200 {object} controllers.BaseResponse
+// @Router /{ledger}/transactions/{txid}/metadata/{key} [delete]
+func (ctl *TransactionController) DeleteTransactionMetadata(c *gin.Context) {
+	l, _ := c.Get("ledger")
 
-	err := l.(*ledger.Ledger).SaveMeta(
-		c,
+	err := l.(*ledger.Ledger).DeleteMeta(
+		c.Request.Context(),
 		"transaction",
 		c.Param("txid"),
-		m,
+		[]string{c.Param("key")},
 	)
 	if err != nil {
 		ctl.responseError(
 			c,
-			http.StatusInternalServerError,
+			errorStatus(err),
 			err,
 		)
 		return
@@ -0,0 +1,121 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/numary/ledger/pkg/ledger"
+)
+
+// WebhookController -
+type WebhookController struct {
+	BaseController
+}
+
+// NewWebhookController -
+func NewWebhookController() WebhookController {
+	return WebhookController{}
+}
+
+// WebhookRequest is the body registering a webhook
+type WebhookRequest struct {
+	URL string `json:"url"`
+	// Accounts restricts the deliveries to the transactions from or to one of the accounts, by address or pattern
+	Accounts []string `json:"accounts"`
+}
+
+// PostWebhook godoc
+// @Summary Register a webhook
+// @Description Register an endpoint notified of the transactions committed from now on, returned along with the secret signing the deliveries
+// @Tags webhooks
+// @Schemes
+// @Param ledger path string true "ledger"
+// @Param webhook body controllers.WebhookRequest true "webhook"
+// @Accept json
+// @Produce json
+// @Success 200 {object} controllers.BaseResponse{data=core.Webhook}
+// @Router /{ledger}/webhooks [post]
+func (ctl *WebhookController) PostWebhook(c *gin.Context) {
+	l, _ := c.Get("ledger")
+
+	var req WebhookRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		ctl.responseError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	webhook, err := l.(*ledger.Ledger).RegisterWebhook(c.Request.Context(), req.URL, req.Accounts)
+	if err != nil {
+		ctl.responseError(
+			c,
+			errorStatus(err),
+			err,
+		)
+		return
+	}
+
+	ctl.response(
+		c,
+		http.StatusOK,
+		webhook,
+	)
+}
+
+// GetWebhooks godoc
+// @Summary List the webhooks
+// @Description List the webhooks of the ledger in the order of their registration, without their secret
+// @Tags webhooks
+// @Schemes
+// @Param ledger path string true "ledger"
+// @Produce json
+// @Success 200 {object} controllers.BaseResponse{data=[]core.Webhook}
+// @Router /{ledger}/webhooks [get]
+func (ctl *WebhookController) GetWebhooks(c *gin.Context) {
+	l, _ := c.Get("ledger")
+
+	webhooks, err := l.(*ledger.Ledger).Webhooks(c.Request.Context())
+	if err != nil {
+		ctl.responseError(
+			c,
+			errorStatus(err),
+			err,
+		)
+		return
+	}
+
+	ctl.response(
+		c,
+		http.StatusOK,
+		webhooks,
+	)
+}
+
+// GetWebhookDeliveries godoc
+// @Summary List the deliveries of a webhook
+// @Description List the deliveries of a webhook, the most recent first, including the ones given up after the maximum number of attempts
+// @Tags webhooks
+// @Schemes
+// @Param ledger path string true "ledger"
+// @Param id path string true "id"
+// @Produce json
+// @Success 200 {object} controllers.BaseResponse{data=[]core.WebhookDelivery}
+// @Router /{ledger}/webhooks/{id}/deliveries [get]
+func (ctl *WebhookController) GetWebhookDeliveries(c *gin.Context) {
+	l, _ := c.Get("ledger")
+
+	deliveries, err := l.(*ledger.Ledger).WebhookDeliveries(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		ctl.responseError(
+			c,
+			errorStatus(err),
+			err,
+		)
+		return
+	}
+
+	ctl.response(
+		c,
+		http.StatusOK,
+		deliveries,
+	)
+}
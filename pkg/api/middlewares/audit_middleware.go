@@ -0,0 +1,79 @@
+package middlewares
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/numary/ledger/pkg/audit"
+	"github.com/numary/ledger/pkg/ledger"
+	"github.com/pborman/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// AuditMiddleware struct
+type AuditMiddleware struct {
+	sink     audit.Sink
+	blocking bool
+}
+
+// NewAuditMiddleware records the writes in the sink, if not nil.
+// If blocking, a started record is written before each write, which is refused with a 503 if it fails.
+func NewAuditMiddleware(sink audit.Sink, blocking bool) AuditMiddleware {
+	return AuditMiddleware{
+		sink:     sink,
+		blocking: blocking,
+	}
+}
+
+// AuditMiddleware records the requests which are not GET, HEAD or OPTIONS, with the transactions they committed.
+// It must come after the auth middleware, which sets the principal.
+func (m AuditMiddleware) AuditMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if m.sink == nil || c.FullPath() == "" {
+			return
+		}
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			return
+		}
+
+		record := audit.Record{
+			ID:        uuid.New(),
+			Ledger:    c.Param("ledger"),
+			Principal: c.GetString(PrincipalKey),
+			IP:        c.ClientIP(),
+			Method:    c.Request.Method,
+			Path:      c.Request.URL.Path,
+			Action:    c.Request.Method + " " + c.FullPath(),
+		}
+		if record.Ledger == "" {
+			record.Ledger = c.Param("name")
+		}
+
+		if m.blocking {
+			record.Stage = audit.StageStarted
+			record.Timestamp = time.Now().UTC().Format(time.RFC3339)
+			if err := m.sink.Write(c.Request.Context(), record); err != nil {
+				logrus.Errorf("writing audit record: %s", err)
+				abort(c, http.StatusServiceUnavailable, "audit unavailable")
+				return
+			}
+		}
+
+		ctx, recorder := ledger.WithTransactionRecorder(c.Request.Context())
+		c.Request = c.Request.WithContext(ctx)
+
+		c.Next()
+
+		record.Stage = audit.StageCompleted
+		record.Timestamp = time.Now().UTC().Format(time.RFC3339)
+		record.Status = c.Writer.Status()
+		record.Transactions = recorder.IDs()
+		// The action is done, the record must be written even if the client went away
+		if err := m.sink.Write(context.Background(), record); err != nil {
+			logrus.Errorf("writing audit record %s: %s", record.ID, err)
+		}
+	}
+}
@@ -1,31 +1,344 @@
 package middlewares
 
 import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
 	"strings"
 
 	"github.com/gin-gonic/gin"
 )
 
+// PrincipalKey is the key of the gin context holding the name of the authenticated principal
+const PrincipalKey = "principal"
+
+// Permission is what a request needs on a ledger, see routePermissions.
+// The resource is mostly the first segment of the route after the ledger (transactions, accounts, script...),
+// and the action is read for the GET requests, revert for the reverts of transactions, and write otherwise.
+// The deletion of a ledger needs the admin action on its ledger resource.
+// The reloads of the configuration, the list of the ledgers and the metrics of the server need the config,
+// ledgers and metrics resources on all the ledgers, *.
+type Permission struct {
+	Ledger   string
+	Resource string
+	Action   string
+}
+
+// Scope grants permissions, written ledger:<ledger>:<resource>:<action> with * matching anything.
+// The omitted trailing segments match anything, so that ledger:myledger grants everything on myledger.
+type Scope struct {
+	Ledger   string
+	Resource string
+	Action   string
+}
+
+// ParseScope parses a scope like ledger:myledger:transactions:write
+func ParseScope(s string) (Scope, error) {
+	segments := strings.Split(s, ":")
+	if segments[0] != "ledger" || len(segments) > 4 {
+		return Scope{}, fmt.Errorf("invalid scope %q: expected ledger:<ledger>:<resource>:<action>", s)
+	}
+	for len(segments) < 4 {
+		segments = append(segments, "*")
+	}
+	for _, segment := range segments {
+		if segment == "" {
+			return Scope{}, fmt.Errorf("invalid scope %q: empty segment", s)
+		}
+	}
+	return Scope{
+		Ledger:   segments[1],
+		Resource: segments[2],
+		Action:   segments[3],
+	}, nil
+}
+
+func (s Scope) String() string {
+	return fmt.Sprintf("ledger:%s:%s:%s", s.Ledger, s.Resource, s.Action)
+}
+
+// Allows tells if the scope grants the permission
+func (s Scope) Allows(p Permission) bool {
+	match := func(pattern, value string) bool {
+		return pattern == "*" || pattern == value
+	}
+	return match(s.Ledger, p.Ledger) && match(s.Resource, p.Resource) && match(s.Action, p.Action)
+}
+
+// Principal is an authenticated client, granted its scopes
+type Principal struct {
+	Name   string
+	Scopes []Scope
+}
+
+// Allows tells if one of the scopes of the principal grants the permission
+func (p Principal) Allows(permission Permission) bool {
+	for _, scope := range p.Scopes {
+		if scope.Allows(permission) {
+			return true
+		}
+	}
+	return false
+}
+
+// Authenticator authenticates the requests carrying its kind of credentials.
+// It returns a nil principal if the request doesn't carry them, and an error if they are invalid.
+type Authenticator interface {
+	Authenticate(r *http.Request) (*Principal, error)
+}
+
+// bearer returns the bearer token of the request
+func bearer(r *http.Request) (string, bool) {
+	header := r.Header.Get("Authorization")
+	token := strings.TrimPrefix(header, "Bearer ")
+	return token, token != header
+}
+
+type basicAuthenticator struct {
+	user     string
+	password string
+}
+
+// Authenticate grants everything to the user
+func (a basicAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	user, password, ok := r.BasicAuth()
+	if !ok {
+		return nil, nil
+	}
+	if subtle.ConstantTimeCompare([]byte(user), []byte(a.user)) != 1 ||
+		subtle.ConstantTimeCompare([]byte(password), []byte(a.password)) != 1 {
+		return nil, errors.New("invalid credentials")
+	}
+	return &Principal{
+		Name:   user,
+		Scopes: []Scope{{Ledger: "*", Resource: "*", Action: "*"}},
+	}, nil
+}
+
+// APIKey is a key sent as a bearer token, granting its scopes to the principal named by Name
+type APIKey struct {
+	Name   string   `mapstructure:"name"`
+	Key    string   `mapstructure:"key"`
+	Scopes []string `mapstructure:"scopes"`
+}
+
+type apiKey struct {
+	key       []byte
+	principal Principal
+}
+
+type apiKeyAuthenticator []apiKey
+
+// Authenticate leaves the unknown bearer tokens to the other authenticators, as they can be JWT
+func (a apiKeyAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	token, ok := bearer(r)
+	if !ok {
+		return nil, nil
+	}
+	for i := range a {
+		if subtle.ConstantTimeCompare(a[i].key, []byte(token)) == 1 {
+			return &a[i].principal, nil
+		}
+	}
+	return nil, nil
+}
+
 // AuthMiddleware struct
 type AuthMiddleware struct {
-	HTTPBasic string
+	authenticators []Authenticator
+	basic          bool
+}
+
+// NewAuthMiddleware authenticates the requests with the api keys, the JWT if configured and the http basic auth if set,
+// tried in this order
+func NewAuthMiddleware(httpBasic string, apiKeys []APIKey, jwt JWTConfig) (AuthMiddleware, error) {
+	m := AuthMiddleware{}
+
+	if len(apiKeys) > 0 {
+		authenticator := apiKeyAuthenticator{}
+		for _, k := range apiKeys {
+			if k.Name == "" || k.Key == "" {
+				return m, fmt.Errorf("invalid api key: expected a name and a key")
+			}
+			key := apiKey{
+				key: []byte(k.Key),
+				principal: Principal{
+					Name: k.Name,
+				},
+			}
+			for _, s := range k.Scopes {
+				scope, err := ParseScope(s)
+				if err != nil {
+					return m, fmt.Errorf("api key %s: %w", k.Name, err)
+				}
+				key.principal.Scopes = append(key.principal.Scopes, scope)
+			}
+			authenticator = append(authenticator, key)
+		}
+		m.authenticators = append(m.authenticators, authenticator)
+	}
+
+	if jwt.enabled() {
+		authenticator, err := NewJWTAuthenticator(jwt)
+		if err != nil {
+			return m, err
+		}
+		m.authenticators = append(m.authenticators, authenticator)
+	}
+
+	if httpBasic != "" {
+		segments := strings.SplitN(httpBasic, ":", 2)
+		if len(segments) != 2 {
+			return m, fmt.Errorf("invalid http basic auth: expected user:password")
+		}
+		m.authenticators = append(m.authenticators, basicAuthenticator{
+			user:     segments[0],
+			password: segments[1],
+		})
+		m.basic = true
+	}
+
+	return m, nil
+}
+
+// authenticate returns the principal of the first authenticator recognizing the credentials of the request
+func (m AuthMiddleware) authenticate(r *http.Request) (*Principal, error) {
+	for _, authenticator := range m.authenticators {
+		principal, err := authenticator.Authenticate(r)
+		if err != nil || principal != nil {
+			return principal, err
+		}
+	}
+	return nil, errors.New("missing credentials")
+}
+
+// routePermissions maps the routes, by method and path, to the permission they need. The ledger of a permission
+// starting with a colon is the parameter of the route it names, like :ledger.
+// The routes missing from the map need every permission on every ledger, so that a new route is only served
+// to the principals granted everything until it is mapped.
+var routePermissions = map[string]Permission{
+	"POST /_config/reload": {Ledger: "*", Resource: "config", Action: "write"},
+	"GET /_ledgers":        {Ledger: "*", Resource: "ledgers", Action: "read"},
+	"POST /_ledgers/:name": {Ledger: ":name", Resource: "ledger", Action: "write"},
+	"GET /metrics":         {Ledger: "*", Resource: "metrics", Action: "read"},
+	"DELETE /:ledger":      {Ledger: ":ledger", Resource: "ledger", Action: "admin"},
+	"GET /:ledger/stats":   {Ledger: ":ledger", Resource: "stats", Action: "read"},
+	"GET /:ledger/assets":  {Ledger: ":ledger", Resource: "assets", Action: "read"},
+	"GET /:ledger/head":    {Ledger: ":ledger", Resource: "head", Action: "read"},
+	"GET /:ledger/export":  {Ledger: ":ledger", Resource: "export", Action: "read"},
+	"POST /:ledger/import": {Ledger: ":ledger", Resource: "import", Action: "write"},
+
+	"PUT /:ledger/schemas/:type":    {Ledger: ":ledger", Resource: "schemas", Action: "write"},
+	"GET /:ledger/schemas/:type":    {Ledger: ":ledger", Resource: "schemas", Action: "read"},
+	"DELETE /:ledger/schemas/:type": {Ledger: ":ledger", Resource: "schemas", Action: "write"},
+
+	"GET /:ledger/transactions":                        {Ledger: ":ledger", Resource: "transactions", Action: "read"},
+	"POST /:ledger/transactions":                       {Ledger: ":ledger", Resource: "transactions", Action: "write"},
+	"POST /:ledger/transactions/batch":                 {Ledger: ":ledger", Resource: "transactions", Action: "write"},
+	"POST /:ledger/transactions/revert":                {Ledger: ":ledger", Resource: "transactions", Action: "revert"},
+	"GET /:ledger/transactions/stream":                 {Ledger: ":ledger", Resource: "transactions", Action: "read"},
+	"GET /:ledger/transactions/:txid":                  {Ledger: ":ledger", Resource: "transactions", Action: "read"},
+	"GET /:ledger/transactions/by-hash/:hash":          {Ledger: ":ledger", Resource: "transactions", Action: "read"},
+	"POST /:ledger/transactions/:txid/revert":          {Ledger: ":ledger", Resource: "transactions", Action: "revert"},
+	"GET /:ledger/transactions/:txid/proof":            {Ledger: ":ledger", Resource: "transactions", Action: "read"},
+	"POST /:ledger/transactions/:txid/metadata":        {Ledger: ":ledger", Resource: "transactions", Action: "write"},
+	"DELETE /:ledger/transactions/:txid/metadata/:key": {Ledger: ":ledger", Resource: "transactions", Action: "write"},
+	"GET /:ledger/postings/log":                        {Ledger: ":ledger", Resource: "postings", Action: "read"},
+
+	"GET /:ledger/accounts":                           {Ledger: ":ledger", Resource: "accounts", Action: "read"},
+	"GET /:ledger/accounts/balances.csv":              {Ledger: ":ledger", Resource: "accounts", Action: "read"},
+	"GET /:ledger/accounts/:address":                  {Ledger: ":ledger", Resource: "accounts", Action: "read"},
+	"GET /:ledger/balances":                           {Ledger: ":ledger", Resource: "balances", Action: "read"},
+	"POST /:ledger/accounts/:address/metadata":        {Ledger: ":ledger", Resource: "accounts", Action: "write"},
+	"DELETE /:ledger/accounts/:address/metadata/:key": {Ledger: ":ledger", Resource: "accounts", Action: "write"},
+	"POST /:ledger/accounts/:address/overdrafts":      {Ledger: ":ledger", Resource: "accounts", Action: "write"},
+	"GET /:ledger/accounts/:address/flow":             {Ledger: ":ledger", Resource: "accounts", Action: "read"},
+	"GET /:ledger/accounts/:address/watch":            {Ledger: ":ledger", Resource: "accounts", Action: "read"},
+	"GET /:ledger/accounts/:address/holds":            {Ledger: ":ledger", Resource: "accounts", Action: "read"},
+
+	"POST /:ledger/script":            {Ledger: ":ledger", Resource: "script", Action: "write"},
+	"POST /:ledger/script/preview":    {Ledger: ":ledger", Resource: "script", Action: "write"},
+	"POST /:ledger/scripts":           {Ledger: ":ledger", Resource: "scripts", Action: "write"},
+	"GET /:ledger/scripts":            {Ledger: ":ledger", Resource: "scripts", Action: "read"},
+	"POST /:ledger/scripts/:name/run": {Ledger: ":ledger", Resource: "scripts", Action: "write"},
+
+	"POST /:ledger/webhooks":               {Ledger: ":ledger", Resource: "webhooks", Action: "write"},
+	"GET /:ledger/webhooks":                {Ledger: ":ledger", Resource: "webhooks", Action: "read"},
+	"GET /:ledger/webhooks/:id/deliveries": {Ledger: ":ledger", Resource: "webhooks", Action: "read"},
+	"POST /:ledger/holds":                  {Ledger: ":ledger", Resource: "holds", Action: "write"},
+	"GET /:ledger/holds/:id":               {Ledger: ":ledger", Resource: "holds", Action: "read"},
+	"POST /:ledger/holds/:id/capture":      {Ledger: ":ledger", Resource: "holds", Action: "write"},
+	"POST /:ledger/holds/:id/release":      {Ledger: ":ledger", Resource: "holds", Action: "write"},
 }
 
-// NewAuthMiddleware
-func NewAuthMiddleware(httpBasic string) AuthMiddleware {
-	return AuthMiddleware{
-		HTTPBasic: httpBasic,
+// authenticatedPaths are the routes only needing the request to be authenticated
+var authenticatedPaths = map[string]struct{}{
+	"/_info":        {},
+	"/swagger.json": {},
+}
+
+// permission returns the permission needed by the route of the request,
+// false if the route only needs the request to be authenticated
+func permission(c *gin.Context) (Permission, bool) {
+	path := c.FullPath()
+	if _, ok := authenticatedPaths[path]; ok || path == "" {
+		// The requests matching no route are answered with a 404
+		return Permission{}, false
+	}
+
+	p, ok := routePermissions[c.Request.Method+" "+path]
+	if !ok {
+		return Permission{Ledger: "*", Resource: "*", Action: "*"}, true
+	}
+	if strings.HasPrefix(p.Ledger, ":") {
+		p.Ledger = c.Param(p.Ledger[1:])
 	}
+	return p, true
 }
 
-// AuthMiddleware
-func (m AuthMiddleware) AuthMiddleware(engine *gin.Engine) gin.HandlerFunc {
+// publicPaths are the routes served without authentication, the probes of the orchestrators carrying no credentials
+var publicPaths = map[string]struct{}{
+	"/_healthz": {},
+	"/_readyz":  {},
+}
+
+// AuthMiddleware refuses with a 401 the requests which are not authenticated,
+// and with a 403 the ones whose principal lacks the permission of the route
+func (m AuthMiddleware) AuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		if auth := m.HTTPBasic; auth != "" {
-			segment := strings.Split(auth, ":")
-			engine.Use(gin.BasicAuth(gin.Accounts{
-				segment[0]: segment[1],
-			}))
+		if len(m.authenticators) == 0 {
+			return
+		}
+		if _, ok := publicPaths[c.FullPath()]; ok {
+			return
+		}
+
+		principal, err := m.authenticate(c.Request)
+		if err != nil {
+			if m.basic {
+				c.Header("WWW-Authenticate", `Basic realm="ledger"`)
+			}
+			abort(c, http.StatusUnauthorized, err.Error())
+			return
+		}
+		if principal.Name != "" {
+			c.Set(PrincipalKey, principal.Name)
+		}
+
+		p, ok := permission(c)
+		if ok && !principal.Allows(p) {
+			abort(c, http.StatusForbidden, "missing scope "+Scope(p).String())
 		}
 	}
 }
+
+func abort(c *gin.Context, status int, message string) {
+	c.AbortWithStatusJSON(status, gin.H{
+		"ok":            false,
+		"error":         true,
+		"error_code":    status,
+		"error_message": message,
+		"request_id":    c.GetString(RequestIDKey),
+	})
+}
@@ -0,0 +1,269 @@
+package middlewares
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MicahParks/keyfunc"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// jwksRefreshInterval is the minimum duration between two fetches of the JWKS,
+// so that tokens with unknown key ids can't make the ledger hammer the JWKS url
+const jwksRefreshInterval = time.Minute
+
+// ledgerPlaceholder is replaced in the mapped scopes by the ledgers of the ledgers claim
+const ledgerPlaceholder = "{ledger}"
+
+var (
+	// secretMethods and jwksMethods are the only algorithms accepted, depending on how the tokens are verified
+	secretMethods = []string{"HS256", "HS384", "HS512"}
+	jwksMethods   = []string{"RS256", "RS384", "RS512", "ES256", "ES384", "ES512"}
+)
+
+// JWTConfig configures the validation of the JWT sent as bearer tokens, signed either with a shared secret (HS256, HS384, HS512)
+// or with one of the keys published at a JWKS url (RS256, RS384, RS512, ES256, ES384, ES512).
+// The tokens must have an expiry, and their subject is the name of the principal.
+type JWTConfig struct {
+	Secret  string
+	JWKSURL string
+	// Issuer and Audience are checked against the iss and aud claims if not empty
+	Issuer   string
+	Audience string
+	// ScopesClaim is the claim listing the scopes of the token, space separated or as an array, "scope" if empty
+	ScopesClaim string
+	// LedgersClaim is the claim listing the ledgers of the token, replacing {ledger} in the mapped scopes
+	LedgersClaim string
+	// ScopeMapping maps the values of the scopes claim, matched case insensitively, to scopes like ledger:{ledger}:*:read.
+	// The values which are not mapped are used as is if they are scopes.
+	ScopeMapping map[string][]string
+}
+
+func (c JWTConfig) enabled() bool {
+	return c.Secret != "" || c.JWKSURL != ""
+}
+
+type jwtAuthenticator struct {
+	config  JWTConfig
+	mapping map[string][]string
+	methods []string
+	jwks    *jwks
+	now     func() time.Time
+}
+
+// NewJWTAuthenticator validates the configuration and returns the authenticator of the JWT
+func NewJWTAuthenticator(config JWTConfig) (Authenticator, error) {
+	if config.Secret != "" && config.JWKSURL != "" {
+		return nil, errors.New("invalid jwt config: expected a secret or a jwks url, not both")
+	}
+	if config.ScopesClaim == "" {
+		config.ScopesClaim = "scope"
+	}
+
+	a := &jwtAuthenticator{
+		config:  config,
+		mapping: map[string][]string{},
+		methods: secretMethods,
+		now:     time.Now,
+	}
+	for value, scopes := range config.ScopeMapping {
+		for _, scope := range scopes {
+			if _, err := ParseScope(strings.ReplaceAll(scope, ledgerPlaceholder, "ledger")); err != nil {
+				return nil, fmt.Errorf("invalid jwt scope mapping of %s: %w", value, err)
+			}
+		}
+		a.mapping[strings.ToLower(value)] = scopes
+	}
+	if config.JWKSURL != "" {
+		a.methods = jwksMethods
+		a.jwks = &jwks{
+			url: config.JWKSURL,
+			client: &http.Client{
+				Timeout: 10 * time.Second,
+			},
+		}
+	}
+	return a, nil
+}
+
+// Authenticate leaves the bearer tokens which are not shaped like JWT to the other authenticators
+func (a *jwtAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	token, ok := bearer(r)
+	if !ok || strings.Count(token, ".") != 2 {
+		return nil, nil
+	}
+
+	claims, err := a.verify(token)
+	if err != nil {
+		return nil, fmt.Errorf("invalid token: %w", err)
+	}
+
+	principal := &Principal{}
+	principal.Name, _ = claims["sub"].(string)
+
+	var ledgers []string
+	if a.config.LedgersClaim != "" {
+		ledgers = stringsClaim(claims[a.config.LedgersClaim])
+	}
+	for _, value := range stringsClaim(claims[a.config.ScopesClaim]) {
+		mapped, ok := a.mapping[strings.ToLower(value)]
+		if !ok {
+			if scope, err := ParseScope(value); err == nil {
+				principal.Scopes = append(principal.Scopes, scope)
+			}
+			continue
+		}
+		for _, s := range mapped {
+			if !strings.Contains(s, ledgerPlaceholder) {
+				scope, _ := ParseScope(s)
+				principal.Scopes = append(principal.Scopes, scope)
+				continue
+			}
+			for _, ledger := range ledgers {
+				if scope, err := ParseScope(strings.ReplaceAll(s, ledgerPlaceholder, ledger)); err == nil {
+					principal.Scopes = append(principal.Scopes, scope)
+				}
+			}
+		}
+	}
+
+	return principal, nil
+}
+
+// verify checks the signature, the expiry, the issuer and the audience of the token and returns its claims
+func (a *jwtAuthenticator) verify(token string) (map[string]interface{}, error) {
+	claims := jwt.MapClaims{}
+	// The claims are checked below against a.now rather than the clock of the jwt package
+	parser := jwt.NewParser(jwt.WithValidMethods(a.methods), jwt.WithoutClaimsValidation())
+	if _, err := parser.ParseWithClaims(token, claims, a.key); err != nil {
+		return nil, err
+	}
+
+	now := a.now()
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return nil, errors.New("missing expiry")
+	}
+	if !now.Before(time.Unix(int64(exp), 0)) {
+		return nil, errors.New("expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Before(time.Unix(int64(nbf), 0)) {
+		return nil, errors.New("not valid yet")
+	}
+	if a.config.Issuer != "" && claims["iss"] != a.config.Issuer {
+		return nil, errors.New("unexpected issuer")
+	}
+	if a.config.Audience != "" && !contains(stringsClaim(claims["aud"]), a.config.Audience) {
+		return nil, errors.New("unexpected audience")
+	}
+
+	return claims, nil
+}
+
+// key returns the key verifying the token, the algorithm being already checked against a.methods
+func (a *jwtAuthenticator) key(token *jwt.Token) (interface{}, error) {
+	if a.jwks != nil {
+		return a.jwks.key(token)
+	}
+	return []byte(a.config.Secret), nil
+}
+
+// stringsClaim returns the values of a claim which is either a space separated string or an array of strings
+func stringsClaim(claim interface{}) []string {
+	switch claim := claim.(type) {
+	case string:
+		return strings.Fields(claim)
+	case []interface{}:
+		values := make([]string, 0, len(claim))
+		for _, v := range claim {
+			if s, ok := v.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// jwks holds the public keys published at a JWKS url, fetched again when a token references an unknown key.
+// The fetches are made without holding mu, so that the tokens with known keys are verified meanwhile.
+type jwks struct {
+	url    string
+	client *http.Client
+
+	mu        sync.Mutex
+	set       *keyfunc.JWKS
+	err       error
+	fetchedAt time.Time
+	// fetching is closed when the fetch in progress, if any, is done
+	fetching chan struct{}
+}
+
+func (k *jwks) key(token *jwt.Token) (interface{}, error) {
+	k.mu.Lock()
+	set := k.set
+	k.mu.Unlock()
+
+	if set != nil {
+		key, err := set.Keyfunc(token)
+		if !errors.Is(err, keyfunc.ErrKIDNotFound) {
+			return key, err
+		}
+	}
+	set, err := k.refresh()
+	if err != nil {
+		return nil, fmt.Errorf("fetching jwks: %w", err)
+	}
+	return set.Keyfunc(token)
+}
+
+// refresh fetches the JWKS unless it was fetched less than jwksRefreshInterval ago,
+// or waits for the fetch in progress, and returns the last keys fetched
+func (k *jwks) refresh() (*keyfunc.JWKS, error) {
+	k.mu.Lock()
+	if fetching := k.fetching; fetching != nil {
+		k.mu.Unlock()
+		<-fetching
+		k.mu.Lock()
+	} else if time.Since(k.fetchedAt) >= jwksRefreshInterval {
+		fetching := make(chan struct{})
+		k.fetching = fetching
+		k.fetchedAt = time.Now()
+		k.mu.Unlock()
+
+		set, err := keyfunc.Get(k.url, keyfunc.Options{
+			Client:         k.client,
+			RefreshTimeout: k.client.Timeout,
+		})
+
+		k.mu.Lock()
+		if err == nil {
+			k.set = set
+		}
+		k.err = err
+		k.fetching = nil
+		close(fetching)
+	}
+	defer k.mu.Unlock()
+
+	if k.set == nil {
+		if k.err != nil {
+			return nil, k.err
+		}
+		return nil, errors.New("no keys fetched")
+	}
+	return k.set, nil
+}
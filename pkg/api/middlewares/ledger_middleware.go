@@ -3,6 +3,7 @@ package middlewares
 import (
 	"github.com/gin-gonic/gin"
 	"github.com/numary/ledger/pkg/ledger"
+	"github.com/numary/ledger/pkg/storage"
 	"github.com/sirupsen/logrus"
 )
 
@@ -29,7 +30,7 @@ func (m *LedgerMiddleware) LedgerMiddleware() gin.HandlerFunc {
 			return
 		}
 
-		l, err := m.resolver.GetLedger(c, name)
+		l, err := m.resolver.GetLedger(c.Request.Context(), name)
 		if err != nil {
 			c.JSON(400, gin.H{
 				"ok":  false,
@@ -37,13 +38,18 @@ func (m *LedgerMiddleware) LedgerMiddleware() gin.HandlerFunc {
 			})
 		}
 		defer func() {
-			err := l.Close(c)
+			err := l.Close(c.Request.Context())
 			if err != nil {
 				logrus.Printf("error closing ledger: %s", err)
 			}
 		}()
 		c.Set("ledger", l)
 
+		// The reads can be sent to a replica of the storage, unless asked otherwise
+		if c.Query("consistent_read") == "true" {
+			c.Request = c.Request.WithContext(storage.WithConsistentRead(c.Request.Context()))
+		}
+
 		c.Next()
 	}
 }
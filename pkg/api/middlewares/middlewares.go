@@ -6,7 +6,11 @@ import (
 
 var Module = fx.Options(
 	fx.Provide(
-		fx.Annotate(NewAuthMiddleware, fx.ParamTags(`name:"httpBasic"`)),
+		fx.Annotate(NewAuthMiddleware, fx.ParamTags(`name:"httpBasic"`, `name:"apiKeys"`, ``)),
 	),
 	fx.Provide(NewLedgerMiddleware),
+	fx.Provide(NewRateLimitMiddleware),
+	fx.Provide(
+		fx.Annotate(NewAuditMiddleware, fx.ParamTags(``, `name:"auditBlocking"`)),
+	),
 )
@@ -0,0 +1,154 @@
+package middlewares
+
+import (
+	"container/list"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxBuckets is the number of buckets kept, the least recently used ones being dropped beyond it
+const maxBuckets = 10000
+
+// RateLimit configures a token bucket, refilled with Rate tokens per second up to Burst tokens.
+// A zero Rate disables the limit, and a Burst lower than one allows a single request at once.
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+func (l RateLimit) enabled() bool {
+	return l.Rate > 0
+}
+
+func (l RateLimit) capacity() float64 {
+	return math.Max(1, float64(l.Burst))
+}
+
+// RateLimits are the limits of the requests of each client, whatever the ledgers they target.
+// The writes are the requests which are not GET, HEAD or OPTIONS.
+type RateLimits struct {
+	Read  RateLimit
+	Write RateLimit
+}
+
+type bucket struct {
+	key    string
+	limit  RateLimit
+	tokens float64
+	last   time.Time
+}
+
+func (b *bucket) refill(now time.Time) {
+	b.tokens = math.Min(b.limit.capacity(), b.tokens+now.Sub(b.last).Seconds()*b.limit.Rate)
+	b.last = now
+}
+
+// take consumes a token if there is one, returning otherwise the duration until the next token
+func (b *bucket) take(now time.Time) (bool, time.Duration) {
+	b.refill(now)
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / b.limit.Rate * float64(time.Second))
+}
+
+// RateLimitMiddleware struct
+type RateLimitMiddleware struct {
+	limits  RateLimits
+	now     func() time.Time
+	mu      sync.Mutex
+	buckets map[string]*list.Element
+	// recent holds the buckets, the most recently used first
+	recent *list.List
+}
+
+// NewRateLimitMiddleware
+func NewRateLimitMiddleware(limits RateLimits) *RateLimitMiddleware {
+	return &RateLimitMiddleware{
+		limits:  limits,
+		now:     time.Now,
+		buckets: map[string]*list.Element{},
+		recent:  list.New(),
+	}
+}
+
+// SetLimits replaces the limits of the requests. The buckets are dropped, the clients starting again from a full one.
+func (m *RateLimitMiddleware) SetLimits(limits RateLimits) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.limits = limits
+	m.buckets = map[string]*list.Element{}
+	m.recent = list.New()
+}
+
+// current returns the limits of the requests, which may be replaced by SetLimits
+func (m *RateLimitMiddleware) current() RateLimits {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.limits
+}
+
+func (m *RateLimitMiddleware) take(key string, limit RateLimit) (bool, time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	if e, ok := m.buckets[key]; ok {
+		m.recent.MoveToFront(e)
+		return e.Value.(*bucket).take(now)
+	}
+
+	b := &bucket{
+		key:    key,
+		limit:  limit,
+		tokens: limit.capacity(),
+		last:   now,
+	}
+	m.buckets[key] = m.recent.PushFront(b)
+	for m.recent.Len() > maxBuckets {
+		oldest := m.recent.Back()
+		m.recent.Remove(oldest)
+		delete(m.buckets, oldest.Value.(*bucket).key)
+	}
+	return b.take(now)
+}
+
+// principal identifies the client, by the principal set by the auth middleware if authenticated or by its ip otherwise
+func principal(c *gin.Context) string {
+	if name := c.GetString(PrincipalKey); name != "" {
+		return "principal:" + name
+	}
+	return "ip:" + c.ClientIP()
+}
+
+// RateLimitMiddleware rejects with a 429 the requests of a client exceeding the limits. The buckets are keyed
+// by client only, as the ledgers in the paths are chosen by the clients.
+func (m *RateLimitMiddleware) RateLimitMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		limits := m.current()
+		kind, limit := "read", limits.Read
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+		default:
+			kind, limit = "write", limits.Write
+		}
+		if !limit.enabled() {
+			return
+		}
+
+		ok, wait := m.take(kind+"|"+principal(c), limit)
+		if ok {
+			return
+		}
+
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		abort(c, http.StatusTooManyRequests, "rate limit exceeded")
+	}
+}
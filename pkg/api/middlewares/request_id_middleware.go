@@ -0,0 +1,34 @@
+package middlewares
+
+import (
+	"regexp"
+
+	"github.com/gin-gonic/gin"
+	"github.com/numary/ledger/pkg/logging"
+	"github.com/pborman/uuid"
+)
+
+// RequestIDHeader is the header carrying the id of a request, and of its response
+const RequestIDHeader = "X-Request-ID"
+
+// RequestIDKey is the key of the gin context holding the id of the request
+const RequestIDKey = "requestID"
+
+// requestIDPattern restricts the ids sent by the clients, so that they can be logged as is
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// RequestIDMiddleware propagates the id of the request sent in the X-Request-ID header, or generates one if missing or invalid.
+// The id is returned in the header of the response and carried by the context of the request, so that it is logged
+// by the storage too. It must come first, for the id to be in the responses of the other middlewares.
+func RequestIDMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(RequestIDHeader)
+		if !requestIDPattern.MatchString(id) {
+			id = uuid.New()
+		}
+
+		c.Set(RequestIDKey, id)
+		c.Header(RequestIDHeader, id)
+		c.Request = c.Request.WithContext(logging.ContextWithRequestID(c.Request.Context(), id))
+	}
+}
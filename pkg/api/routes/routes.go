@@ -1,12 +1,16 @@
 package routes
 
 import (
-	"github.com/gin-contrib/cors"
+	"io"
+	"time"
+
 	"github.com/gin-contrib/logger"
 	"github.com/gin-gonic/gin"
 	"github.com/numary/ledger/pkg/api/controllers"
 	"github.com/numary/ledger/pkg/api/middlewares"
 	"github.com/numary/ledger/pkg/ledger"
+	"github.com/numary/ledger/pkg/metrics"
+	"github.com/rs/zerolog"
 	"go.uber.org/fx"
 )
 
@@ -14,16 +18,32 @@ var Module = fx.Options(
 	fx.Provide(NewRoutes),
 )
 
+// LogFormat is the format of the access logs
+type LogFormat string
+
+const (
+	// LogFormatJSON logs a JSON object per request, for the log collectors
+	LogFormatJSON LogFormat = "json"
+	// LogFormatPretty logs a colored line per request, for the development
+	LogFormatPretty LogFormat = "pretty"
+)
+
 // Routes -
 type Routes struct {
 	resolver         *ledger.Resolver
 	authMiddleware   middlewares.AuthMiddleware
 	ledgerMiddleware middlewares.LedgerMiddleware
+	rateLimitMiddleware *middlewares.RateLimitMiddleware
+	auditMiddleware     middlewares.AuditMiddleware
 	configController controllers.ConfigController
 	ledgerController      controllers.LedgerController
 	scriptController      controllers.ScriptController
 	accountController     controllers.AccountController
 	transactionController controllers.TransactionController
+	webhookController     controllers.WebhookController
+	holdController        controllers.HoldController
+	metrics               *metrics.Metrics
+	logFormat             LogFormat
 }
 
 // NewRoutes -
@@ -31,60 +51,141 @@ func NewRoutes(
 	resolver *ledger.Resolver,
 	authMiddleware middlewares.AuthMiddleware,
 	ledgerMiddleware middlewares.LedgerMiddleware,
+	rateLimitMiddleware *middlewares.RateLimitMiddleware,
+	auditMiddleware middlewares.AuditMiddleware,
 	configController controllers.ConfigController,
 	ledgerController controllers.LedgerController,
 	scriptController controllers.ScriptController,
 	accountController controllers.AccountController,
 	transactionController controllers.TransactionController,
+	webhookController controllers.WebhookController,
+	holdController controllers.HoldController,
+	metrics *metrics.Metrics,
+	logFormat LogFormat,
 ) *Routes {
 	return &Routes{
 		resolver:              resolver,
 		authMiddleware:        authMiddleware,
 		ledgerMiddleware:      ledgerMiddleware,
+		rateLimitMiddleware:   rateLimitMiddleware,
+		auditMiddleware:       auditMiddleware,
 		configController:      configController,
 		ledgerController:      ledgerController,
 		scriptController:      scriptController,
 		accountController:     accountController,
 		transactionController: transactionController,
+		webhookController:     webhookController,
+		holdController:        holdController,
+		metrics:               metrics,
+		logFormat:             logFormat,
+	}
+}
+
+// accessLogger logs the requests in the format of the routes, with their id and their ledger
+func (r *Routes) accessLogger(c *gin.Context, out io.Writer, latency time.Duration) zerolog.Logger {
+	if r.logFormat == LogFormatPretty {
+		out = zerolog.ConsoleWriter{Out: out, TimeFormat: time.RFC3339}
 	}
+
+	return zerolog.New(out).With().
+		Timestamp().
+		Str("request_id", c.GetString(middlewares.RequestIDKey)).
+		Str("ledger", c.Param("ledger")).
+		Int("status", c.Writer.Status()).
+		Str("method", c.Request.Method).
+		Str("path", c.Request.URL.Path).
+		Str("ip", c.ClientIP()).
+		Dur("latency", latency).
+		Str("user_agent", c.Request.UserAgent()).
+		Logger()
 }
 
-// Engine -
-func (r *Routes) Engine(cc cors.Config) *gin.Engine {
+// Engine builds the gin engine serving the routes, with the middleware applying the CORS policy of api.NewAPI
+func (r *Routes) Engine(corsMiddleware gin.HandlerFunc) *gin.Engine {
 	engine := gin.New()
 
 	// Default Middlewares
 	engine.Use(
-		cors.New(cc),
+		middlewares.RequestIDMiddleware(),
+		corsMiddleware,
 		gin.Recovery(),
-		logger.SetLogger(),
-		r.authMiddleware.AuthMiddleware(engine),
+		logger.SetLogger(logger.WithLogger(r.accessLogger)),
+		r.authMiddleware.AuthMiddleware(),
+		r.auditMiddleware.AuditMiddleware(),
 	)
 
 	engine.GET("/swagger.json", r.configController.GetDocs)
 
 	// API Routes
 	engine.GET("/_info", r.configController.GetInfo)
+	engine.GET("/_healthz", r.configController.GetHealthz)
+	engine.GET("/_readyz", r.configController.GetReadyz)
+	engine.GET("/_ledgers", r.configController.GetLedgers)
+	engine.POST("/_ledgers/:name", r.configController.PostLedger)
+	engine.POST("/_config/reload", r.configController.PostConfigReload)
+
+	// Metrics are disabled when there are no collectors
+	if r.metrics != nil {
+		engine.GET("/metrics", gin.WrapH(r.metrics.Handler()))
+	}
 
-	ledger := engine.Group("/:ledger", r.ledgerMiddleware.LedgerMiddleware())
+	// The rate limits are checked first so that the rejected requests don't open the ledger
+	ledger := engine.Group("/:ledger", r.rateLimitMiddleware.RateLimitMiddleware(), r.ledgerMiddleware.LedgerMiddleware())
 	{
 		// LedgerController
 		ledger.GET("/stats", r.ledgerController.GetStats)
+		ledger.GET("/assets", r.ledgerController.GetAssets)
+		ledger.GET("/head", r.ledgerController.GetHead)
+		ledger.DELETE("", r.ledgerController.DeleteLedger)
+		ledger.GET("/export", r.ledgerController.Export)
+		ledger.POST("/import", r.ledgerController.Import)
+		ledger.PUT("/schemas/:type", r.ledgerController.PutMetadataSchema)
+		ledger.GET("/schemas/:type", r.ledgerController.GetMetadataSchema)
+		ledger.DELETE("/schemas/:type", r.ledgerController.DeleteMetadataSchema)
 
 		// TransactionController
 		ledger.GET("/transactions", r.transactionController.GetTransactions)
 		ledger.POST("/transactions", r.transactionController.PostTransaction)
+		ledger.POST("/transactions/batch", r.transactionController.PostTransactionsBatch)
+		ledger.POST("/transactions/revert", r.transactionController.RevertTransactions)
+		ledger.GET("/transactions/stream", r.transactionController.StreamTransactions)
 		ledger.GET("/transactions/:txid", r.transactionController.GetTransaction)
+		ledger.GET("/transactions/by-hash/:hash", r.transactionController.GetTransactionByHash)
 		ledger.POST("/transactions/:txid/revert", r.transactionController.RevertTransaction)
+		ledger.GET("/transactions/:txid/proof", r.transactionController.GetTransactionProof)
 		ledger.POST("/transactions/:txid/metadata", r.transactionController.PostTransactionMetadata)
+		ledger.DELETE("/transactions/:txid/metadata/:key", r.transactionController.DeleteTransactionMetadata)
+		ledger.GET("/postings/log", r.transactionController.StreamPostingLog)
 
 		// AccountController
 		ledger.GET("/accounts", r.accountController.GetAccounts)
+		ledger.GET("/accounts/balances.csv", r.accountController.GetBalancesCSV)
 		ledger.GET("/accounts/:address", r.accountController.GetAccount)
+		ledger.GET("/balances", r.accountController.GetBalances)
 		ledger.POST("/accounts/:address/metadata", r.accountController.PostAccountMetadata)
+		ledger.DELETE("/accounts/:address/metadata/:key", r.accountController.DeleteAccountMetadata)
+		ledger.POST("/accounts/:address/overdrafts", r.accountController.PostAccountOverdraft)
+		ledger.GET("/accounts/:address/flow", r.accountController.GetAccountFlow)
+		ledger.GET("/accounts/:address/watch", r.accountController.WatchAccount)
 
 		// ScriptController
 		ledger.POST("/script", r.scriptController.PostScript)
+		ledger.POST("/script/preview", r.scriptController.PostScriptPreview)
+		ledger.POST("/scripts", r.scriptController.PostStoredScript)
+		ledger.GET("/scripts", r.scriptController.GetStoredScripts)
+		ledger.POST("/scripts/:name/run", r.scriptController.RunStoredScript)
+
+		// WebhookController
+		ledger.POST("/webhooks", r.webhookController.PostWebhook)
+		ledger.GET("/webhooks", r.webhookController.GetWebhooks)
+		ledger.GET("/webhooks/:id/deliveries", r.webhookController.GetWebhookDeliveries)
+
+		// HoldController
+		ledger.POST("/holds", r.holdController.PostHold)
+		ledger.GET("/holds/:id", r.holdController.GetHold)
+		ledger.POST("/holds/:id/capture", r.holdController.CaptureHold)
+		ledger.POST("/holds/:id/release", r.holdController.ReleaseHold)
+		ledger.GET("/accounts/:address/holds", r.holdController.GetAccountHolds)
 	}
 
 	return engine
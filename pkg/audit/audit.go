@@ -0,0 +1,63 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"sync"
+)
+
+const (
+	// StageStarted is the stage of the records written before the action, when the sink blocks the actions
+	StageStarted = "started"
+	// StageCompleted is the stage of the records written after the action, whether it succeeded or not
+	StageCompleted = "completed"
+)
+
+// Record is an action made through the API.
+// The records of the two stages of an action share the same id.
+type Record struct {
+	ID        string `json:"id"`
+	Stage     string `json:"stage"`
+	Timestamp string `json:"timestamp"`
+	Ledger    string `json:"ledger,omitempty"`
+	// Principal is the name of the authenticated client, empty if the authentication is disabled
+	Principal string `json:"principal,omitempty"`
+	IP        string `json:"ip"`
+	Method    string `json:"method"`
+	Path      string `json:"path"`
+	// Action is the route of the request, like POST /:ledger/transactions
+	Action string `json:"action"`
+	// Status is the http status of the response, 0 for the started records
+	Status int `json:"status,omitempty"`
+	// Transactions are the ids of the transactions committed by the action
+	Transactions []int64 `json:"transactions,omitempty"`
+}
+
+// Sink writes the records
+type Sink interface {
+	Write(ctx context.Context, record Record) error
+}
+
+// LogSink writes the records as lines of JSON
+type LogSink struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func NewLogSink(w io.Writer) *LogSink {
+	return &LogSink{
+		w: w,
+	}
+}
+
+func (s *LogSink) Write(ctx context.Context, record Record) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.w.Write(append(data, '\n'))
+	return err
+}
@@ -0,0 +1,234 @@
+// Package client is a Go client of the HTTP API of the ledger.
+//
+// The requests are authenticated with the credentials of the client, and retried with an exponential backoff
+// when the server is rate limiting them or fails to serve them, as long as retrying them is safe.
+// The errors returned by the server are returned as *Error, matching the errors of this package with errors.Is.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultMaxRetries = 3
+	defaultMinBackoff = 100 * time.Millisecond
+	defaultMaxBackoff = 5 * time.Second
+)
+
+// Client sends the requests to the API of a ledger server
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+	auth       func(r *http.Request)
+	maxRetries int
+	minBackoff time.Duration
+	maxBackoff time.Duration
+}
+
+// Option configures a Client
+type Option func(c *Client)
+
+// WithHTTPClient sends the requests with an http client other than http.DefaultClient
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// WithBasicAuth authenticates the requests with http basic authentication
+func WithBasicAuth(username, password string) Option {
+	return func(c *Client) {
+		c.auth = func(r *http.Request) {
+			r.SetBasicAuth(username, password)
+		}
+	}
+}
+
+// WithToken authenticates the requests with a bearer token, an API key or a JWT
+func WithToken(token string) Option {
+	return func(c *Client) {
+		c.auth = func(r *http.Request) {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+	}
+}
+
+// WithRetries retries a request up to maxRetries times, waiting minBackoff before the first retry,
+// twice as long before each of the following ones, up to maxBackoff. Zero retries disables them.
+func WithRetries(maxRetries int, minBackoff, maxBackoff time.Duration) Option {
+	return func(c *Client) {
+		c.maxRetries = maxRetries
+		c.minBackoff = minBackoff
+		c.maxBackoff = maxBackoff
+	}
+}
+
+// New returns a client of the server at baseURL, like "http://localhost:3068"
+func New(baseURL string, options ...Option) *Client {
+	c := &Client{
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		httpClient: http.DefaultClient,
+		maxRetries: defaultMaxRetries,
+		minBackoff: defaultMinBackoff,
+		maxBackoff: defaultMaxBackoff,
+	}
+	for _, option := range options {
+		option(c)
+	}
+	return c
+}
+
+// Ledger returns a client of the ledger named name
+func (c *Client) Ledger(name string) *Ledger {
+	return &Ledger{
+		client: c,
+		name:   name,
+	}
+}
+
+type request struct {
+	method string
+	path   string
+	query  url.Values
+	header http.Header
+	body   interface{}
+	// idempotent requests can be retried when the server fails, the others only when it rate limits them
+	idempotent bool
+}
+
+// envelope is the body of the successful responses
+type envelope struct {
+	Ok     bool            `json:"ok"`
+	Data   json.RawMessage `json:"data"`
+	Cursor *cursor         `json:"cursor"`
+	// Err and Details are set by the script executions failing, which are responded with a 200
+	Err     string `json:"err"`
+	Details string `json:"details"`
+}
+
+type cursor struct {
+	PageSize int             `json:"page_size"`
+	HasMore  bool            `json:"has_more"`
+	Previous string          `json:"previous"`
+	Next     string          `json:"next"`
+	Data     json.RawMessage `json:"data"`
+}
+
+// do sends the request, retrying it if needed, and decodes the body of the successful response
+func (c *Client) do(ctx context.Context, req request) (*envelope, http.Header, error) {
+	var body []byte
+	if req.body != nil {
+		var err error
+		body, err = json.Marshal(req.body)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	for attempt := 0; ; attempt++ {
+		res, err := c.send(ctx, req, body)
+		if err == nil && res.StatusCode < http.StatusBadRequest {
+			defer res.Body.Close()
+			env := &envelope{}
+			if err := json.NewDecoder(res.Body).Decode(env); err != nil && err != io.EOF {
+				return nil, nil, fmt.Errorf("invalid response: %w", err)
+			}
+			return env, res.Header, nil
+		}
+
+		wait := c.backoff(attempt)
+		retry := false
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, nil, ctx.Err()
+			}
+			retry = req.idempotent
+		} else {
+			apiErr := readError(res)
+			err = apiErr
+			switch {
+			case apiErr.StatusCode == http.StatusTooManyRequests:
+				retry = true
+				if apiErr.RetryAfter > wait {
+					wait = apiErr.RetryAfter
+				}
+			case apiErr.StatusCode >= http.StatusInternalServerError:
+				retry = req.idempotent
+			}
+		}
+		if !retry || attempt >= c.maxRetries {
+			return nil, nil, err
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+func (c *Client) send(ctx context.Context, req request, body []byte) (*http.Response, error) {
+	u := c.baseURL + req.path
+	if len(req.query) > 0 {
+		u += "?" + req.query.Encode()
+	}
+	r, err := http.NewRequestWithContext(ctx, req.method, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range req.header {
+		r.Header[key] = values
+	}
+	if body != nil {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	r.Header.Set("Accept", "application/json")
+	if c.auth != nil {
+		c.auth(r)
+	}
+	return c.httpClient.Do(r)
+}
+
+// backoff returns the duration to wait before retrying a request for the attempt+1 time
+func (c *Client) backoff(attempt int) time.Duration {
+	wait := c.minBackoff
+	for i := 0; i < attempt && wait < c.maxBackoff; i++ {
+		wait *= 2
+	}
+	if wait > c.maxBackoff {
+		wait = c.maxBackoff
+	}
+	return wait
+}
+
+// readError reads the structured error of a failed response
+func readError(res *http.Response) *Error {
+	defer res.Body.Close()
+
+	apiErr := &Error{}
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil || json.Unmarshal(data, apiErr) != nil || apiErr.Message == "" {
+		apiErr.Message = strings.TrimSpace(string(data))
+		if apiErr.Message == "" {
+			apiErr.Message = http.StatusText(res.StatusCode)
+		}
+	}
+	apiErr.StatusCode = res.StatusCode
+	if seconds, err := strconv.Atoi(res.Header.Get("Retry-After")); err == nil {
+		apiErr.RetryAfter = time.Duration(seconds) * time.Second
+	}
+	return apiErr
+}
@@ -0,0 +1,229 @@
+package client_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/numary/ledger/cmd"
+	"github.com/numary/ledger/pkg/api"
+	"github.com/numary/ledger/pkg/client"
+	"github.com/numary/ledger/pkg/core"
+	"github.com/numary/ledger/pkg/storage"
+	"github.com/numary/ledger/pkg/storage/memorystorage"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/fx"
+)
+
+// newServer runs the API in-process, behind a handler failing the requests as long as fail returns a status
+func newServer(t *testing.T, fail func(r *http.Request) int) *httptest.Server {
+	var handler http.Handler
+	app := cmd.NewContainer(
+		cmd.WithRememberConfig(false),
+		cmd.WithHttpBasicAuth("admin:secret"),
+		cmd.WithOption(fx.Provide(func() storage.Driver {
+			return memorystorage.NewDriver("memory")
+		})),
+		cmd.WithOption(fx.Invoke(func(a *api.API) {
+			handler = a
+		})),
+	)
+	if err := app.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		app.Stop(context.Background())
+	})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if status := fail(r); status != 0 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(status)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"ok":            false,
+				"error":         true,
+				"error_code":    status,
+				"error_message": http.StatusText(status),
+			})
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestClient(t *testing.T) {
+	var failures int32
+	var failStatus int32
+	server := newServer(t, func(r *http.Request) int {
+		if atomic.AddInt32(&failures, -1) >= 0 {
+			return int(atomic.LoadInt32(&failStatus))
+		}
+		return 0
+	})
+	failNext := func(n, status int) {
+		atomic.StoreInt32(&failStatus, int32(status))
+		atomic.StoreInt32(&failures, int32(n))
+	}
+
+	ctx := context.Background()
+	l := client.New(server.URL,
+		client.WithBasicAuth("admin", "secret"),
+		client.WithRetries(2, time.Millisecond, 10*time.Millisecond),
+	).Ledger("quickstart")
+
+	_, err := client.New(server.URL).Ledger("quickstart").GetAccount(ctx, "world")
+	assert.True(t, errors.Is(err, client.ErrUnauthorized), err)
+
+	for i := 0; i < 5; i++ {
+		tx, err := l.Commit(ctx, core.Transaction{
+			Reference: fmt.Sprintf("ref_%d", i),
+			Postings: []core.Posting{
+				{Source: "world", Destination: "users:001", Amount: 100, Asset: "COIN"},
+			},
+		})
+		assert.NoError(t, err)
+		assert.EqualValues(t, i, tx.ID)
+	}
+
+	// The rate limited requests are retried, even the commits
+	failNext(2, http.StatusTooManyRequests)
+	tx, err := l.Commit(ctx, core.Transaction{
+		Postings: []core.Posting{
+			{Source: "users:001", Destination: "users:002", Amount: 50, Asset: "COIN"},
+		},
+	})
+	assert.NoError(t, err)
+	assert.EqualValues(t, 5, tx.ID)
+
+	failNext(3, http.StatusTooManyRequests)
+	_, err = l.GetAccount(ctx, "users:001")
+	assert.True(t, errors.Is(err, client.ErrTooManyRequests), err)
+
+	// The failed requests are retried when they are idempotent only
+	failNext(1, http.StatusServiceUnavailable)
+	account, err := l.GetAccount(ctx, "users:001")
+	assert.NoError(t, err)
+	assert.EqualValues(t, 450, account.Balances["COIN"])
+
+	failNext(1, http.StatusServiceUnavailable)
+	_, err = l.Commit(ctx, core.Transaction{
+		Postings: []core.Posting{
+			{Source: "world", Destination: "users:003", Amount: 1, Asset: "COIN"},
+		},
+	})
+	assert.True(t, errors.Is(err, client.ErrServer), err)
+
+	failNext(1, http.StatusServiceUnavailable)
+	tx, err = l.CommitWithKey(ctx, "key_1", core.Transaction{
+		Postings: []core.Posting{
+			{Source: "world", Destination: "users:003", Amount: 1, Asset: "COIN"},
+		},
+	})
+	assert.NoError(t, err)
+	assert.EqualValues(t, 6, tx.ID)
+
+	// The structured errors are typed
+	_, err = l.Commit(ctx, core.Transaction{
+		Postings: []core.Posting{
+			{Source: "users:002", Destination: "users:003", Amount: 1000, Asset: "COIN"},
+		},
+	})
+	assert.True(t, errors.Is(err, client.ErrInsufficientFunds), err)
+	assert.True(t, errors.Is(err, client.ErrValidation), err)
+	apiErr := &client.Error{}
+	if assert.True(t, errors.As(err, &apiErr), err) {
+		assert.Equal(t, http.StatusBadRequest, apiErr.StatusCode)
+		assert.NotEmpty(t, apiErr.RequestID)
+	}
+
+	_, err = l.Commit(ctx, core.Transaction{
+		Reference: "ref_0",
+		Postings: []core.Posting{
+			{Source: "world", Destination: "users:003", Amount: 1, Asset: "COIN"},
+		},
+	})
+	assert.True(t, errors.Is(err, client.ErrConflict), err)
+
+	_, err = l.GetTransaction(ctx, 42)
+	assert.True(t, errors.Is(err, client.ErrNotFound), err)
+
+	// The pages are fetched as they are needed, with the filters
+	it := l.FindTransactions(client.TransactionsQuery{
+		ReferencePrefix: "ref_",
+		PageSize:        2,
+	})
+	refs := make([]string, 0)
+	for it.Next(ctx) {
+		refs = append(refs, it.Transaction().Reference)
+	}
+	assert.NoError(t, it.Err())
+	assert.Equal(t, []string{"ref_4", "ref_3", "ref_2", "ref_1", "ref_0"}, refs)
+
+	failNext(3, http.StatusInternalServerError)
+	it = l.FindTransactions(client.TransactionsQuery{})
+	assert.False(t, it.Next(ctx))
+	assert.True(t, errors.Is(it.Err(), client.ErrServer), it.Err())
+
+	// Scripts
+	result, err := l.PostScript(ctx, core.Script{
+		Plain: `vars {
+	account $user
+}
+send [COIN 10] (
+	source = $user
+	destination = @users:004
+)`,
+		Vars: map[string]json.RawMessage{
+			"user": json.RawMessage(`"users:001"`),
+		},
+	})
+	assert.NoError(t, err)
+	if assert.Len(t, result.Transactions, 1) {
+		assert.EqualValues(t, 10, result.Transactions[0].Postings[0].Amount)
+	}
+
+	_, err = l.PostScript(ctx, core.Script{
+		Plain: "this is not a valid script",
+	})
+	scriptErr := &client.ScriptError{}
+	if assert.True(t, errors.As(err, &scriptErr), err) {
+		assert.Contains(t, scriptErr.Message, "compile error")
+		assert.NotEmpty(t, scriptErr.Details)
+	}
+
+	// Revert
+	assert.NoError(t, l.RevertTransaction(ctx, 6))
+	err = l.RevertTransaction(ctx, 6)
+	assert.True(t, errors.Is(err, client.ErrConflict), err)
+
+	// Metadata
+	version, err := l.SaveAccountMetadata(ctx, "users:001", core.Metadata{
+		"kyc": json.RawMessage(`"verified"`),
+	})
+	assert.NoError(t, err)
+	account, err = l.GetAccount(ctx, "users:001")
+	assert.NoError(t, err)
+	assert.Equal(t, version, account.MetadataVersion)
+	assert.JSONEq(t, `"verified"`, string(account.Metadata["kyc"]))
+	assert.NoError(t, l.DeleteAccountMetadata(ctx, "users:001", "kyc"))
+	account, err = l.GetAccount(ctx, "users:001")
+	assert.NoError(t, err)
+	assert.NotContains(t, account.Metadata, "kyc")
+
+	_, err = l.SaveTransactionMetadata(ctx, 0, core.Metadata{
+		"order": json.RawMessage(`"42"`),
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, l.DeleteTransactionMetadata(ctx, 0, "order"))
+	tx, err = l.GetTransaction(ctx, 0)
+	assert.NoError(t, err)
+	assert.NotContains(t, tx.Metadata, "order")
+}
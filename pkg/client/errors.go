@@ -0,0 +1,87 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrValidation is matched by the requests rejected as invalid, with a 400
+	ErrValidation = errors.New("invalid request")
+	// ErrInsufficientFunds is matched by the commits rejected because an account can't be debited, with a 400
+	ErrInsufficientFunds = errors.New("insufficient funds")
+	// ErrUnauthorized is matched by the requests without valid credentials, with a 401
+	ErrUnauthorized = errors.New("unauthorized")
+	// ErrForbidden is matched by the requests whose credentials miss the scope, with a 403
+	ErrForbidden = errors.New("forbidden")
+	// ErrNotFound is matched when the ledger, account or transaction doesn't exist, with a 404
+	ErrNotFound = errors.New("not found")
+	// ErrConflict is matched by the requests conflicting with the state of the ledger, like a reference already used,
+	// a transaction already reverted or metadata updated since their version, with a 409
+	ErrConflict = errors.New("conflict")
+	// ErrTooManyRequests is matched by the requests still rate limited after the retries, with a 429
+	ErrTooManyRequests = errors.New("too many requests")
+	// ErrServer is matched by the requests the server failed to serve, with a 5xx
+	ErrServer = errors.New("server error")
+)
+
+// FieldError reports an invalid field of a request
+type FieldError struct {
+	// Transaction is the index of the transaction in the batch, for the fields of the committed transactions
+	Transaction int `json:"transaction"`
+	// Posting is the index of the posting in the transaction, or -1 if the field is not a posting field
+	Posting int    `json:"posting"`
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Error is an error responded by the server
+type Error struct {
+	StatusCode int          `json:"error_code"`
+	Message    string       `json:"error_message"`
+	RequestID  string       `json:"request_id"`
+	Errors     []FieldError `json:"errors"`
+	// RetryAfter is the duration the client was asked to wait before sending the request again, if any
+	RetryAfter time.Duration `json:"-"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("ledger: %d %s", e.StatusCode, e.Message)
+}
+
+// Is matches the errors of this package according to the status code of the error
+func (e *Error) Is(target error) bool {
+	switch target {
+	case ErrValidation:
+		return e.StatusCode == http.StatusBadRequest
+	case ErrInsufficientFunds:
+		return e.StatusCode == http.StatusBadRequest && strings.Contains(e.Message, "balance.insufficient.")
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized
+	case ErrForbidden:
+		return e.StatusCode == http.StatusForbidden
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrConflict:
+		return e.StatusCode == http.StatusConflict
+	case ErrTooManyRequests:
+		return e.StatusCode == http.StatusTooManyRequests
+	case ErrServer:
+		return e.StatusCode >= http.StatusInternalServerError
+	}
+	return false
+}
+
+// ScriptError is returned when the execution of a script fails
+type ScriptError struct {
+	Message string
+	// Details is a link to the playground reproducing the failure
+	Details string
+}
+
+func (e *ScriptError) Error() string {
+	return e.Message
+}
@@ -0,0 +1,186 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/numary/ledger/pkg/core"
+)
+
+// Ledger sends the requests to a ledger of the server
+type Ledger struct {
+	client *Client
+	name   string
+}
+
+// ScriptResult is the result of the execution of a script
+type ScriptResult struct {
+	// Transactions are the transactions committed
+	Transactions []core.Transaction `json:"transactions"`
+	// Vars holds the values of the variables of the script, passed or read from the metadata
+	Vars map[string]interface{} `json:"vars"`
+}
+
+func (l *Ledger) path(elems ...string) string {
+	p := "/" + url.PathEscape(l.name)
+	for _, elem := range elems {
+		p += "/" + url.PathEscape(elem)
+	}
+	return p
+}
+
+func (l *Ledger) do(ctx context.Context, req request, data interface{}) (http.Header, error) {
+	env, header, err := l.client.do(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if data != nil && len(env.Data) > 0 {
+		if err := json.Unmarshal(env.Data, data); err != nil {
+			return nil, fmt.Errorf("invalid response: %w", err)
+		}
+	}
+	return header, nil
+}
+
+// Commit commits a transaction and returns it as committed, with its id, timestamp and hash.
+// The commit is not retried when the server fails, as it may have been committed: see CommitWithKey.
+func (l *Ledger) Commit(ctx context.Context, tx core.Transaction) (core.Transaction, error) {
+	return l.commit(ctx, tx, "")
+}
+
+// CommitWithKey commits a transaction with an idempotency key, the server committing the transaction only once
+// for all the commits with the same key. Unlike Commit, the commit is retried when the server fails.
+func (l *Ledger) CommitWithKey(ctx context.Context, key string, tx core.Transaction) (core.Transaction, error) {
+	return l.commit(ctx, tx, key)
+}
+
+func (l *Ledger) commit(ctx context.Context, tx core.Transaction, key string) (core.Transaction, error) {
+	req := request{
+		method: http.MethodPost,
+		path:   l.path("transactions"),
+		body:   tx,
+	}
+	if key != "" {
+		req.header = http.Header{"Idempotency-Key": []string{key}}
+		req.idempotent = true
+	}
+
+	var txs []core.Transaction
+	if _, err := l.do(ctx, req, &txs); err != nil {
+		return core.Transaction{}, err
+	}
+	if len(txs) != 1 {
+		return core.Transaction{}, fmt.Errorf("invalid response: %d transactions committed", len(txs))
+	}
+	return txs[0], nil
+}
+
+// GetTransaction returns the transaction with the given id, or an error matching ErrNotFound
+func (l *Ledger) GetTransaction(ctx context.Context, id int64) (core.Transaction, error) {
+	var tx core.Transaction
+	_, err := l.do(ctx, request{
+		method:     http.MethodGet,
+		path:       l.path("transactions", strconv.FormatInt(id, 10)),
+		idempotent: true,
+	}, &tx)
+	return tx, err
+}
+
+// RevertTransaction reverts the transaction with the given id by committing its reverse.
+// It returns an error matching ErrConflict if the transaction has already been reverted.
+func (l *Ledger) RevertTransaction(ctx context.Context, id int64) error {
+	_, err := l.do(ctx, request{
+		method: http.MethodPost,
+		path:   l.path("transactions", strconv.FormatInt(id, 10), "revert"),
+	}, nil)
+	return err
+}
+
+// GetAccount returns an account with its balances, volumes and metadata.
+// The accounts which have never been used are returned empty.
+func (l *Ledger) GetAccount(ctx context.Context, address string) (core.Account, error) {
+	var account core.Account
+	_, err := l.do(ctx, request{
+		method:     http.MethodGet,
+		path:       l.path("accounts", address),
+		idempotent: true,
+	}, &account)
+	return account, err
+}
+
+// PostScript executes a Numscript script and commits the transactions it generates.
+// A failed execution is returned as a *ScriptError.
+func (l *Ledger) PostScript(ctx context.Context, script core.Script) (ScriptResult, error) {
+	env, _, err := l.client.do(ctx, request{
+		method: http.MethodPost,
+		path:   l.path("script"),
+		body:   script,
+	})
+	if err != nil {
+		return ScriptResult{}, err
+	}
+	if !env.Ok {
+		return ScriptResult{}, &ScriptError{
+			Message: env.Err,
+			Details: env.Details,
+		}
+	}
+
+	result := ScriptResult{}
+	if err := json.Unmarshal(env.Data, &result); err != nil {
+		return ScriptResult{}, fmt.Errorf("invalid response: %w", err)
+	}
+	return result, nil
+}
+
+// SaveAccountMetadata merges metadata into the metadata of an account, and returns their new version
+func (l *Ledger) SaveAccountMetadata(ctx context.Context, address string, m core.Metadata) (int64, error) {
+	return l.saveMetadata(ctx, l.path("accounts", address, "metadata"), m)
+}
+
+// SaveTransactionMetadata merges metadata into the metadata of a transaction, and returns their new version
+func (l *Ledger) SaveTransactionMetadata(ctx context.Context, id int64, m core.Metadata) (int64, error) {
+	return l.saveMetadata(ctx, l.path("transactions", strconv.FormatInt(id, 10), "metadata"), m)
+}
+
+func (l *Ledger) saveMetadata(ctx context.Context, path string, m core.Metadata) (int64, error) {
+	header, err := l.do(ctx, request{
+		method:     http.MethodPost,
+		path:       path,
+		body:       m,
+		idempotent: true,
+	}, nil)
+	if err != nil {
+		return 0, err
+	}
+	version, err := strconv.ParseInt(strings.Trim(header.Get("ETag"), `"`), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid response: missing metadata version")
+	}
+	return version, nil
+}
+
+// DeleteAccountMetadata deletes a key of the metadata of an account
+func (l *Ledger) DeleteAccountMetadata(ctx context.Context, address, key string) error {
+	_, err := l.do(ctx, request{
+		method:     http.MethodDelete,
+		path:       l.path("accounts", address, "metadata", key),
+		idempotent: true,
+	}, nil)
+	return err
+}
+
+// DeleteTransactionMetadata deletes a key of the metadata of a transaction
+func (l *Ledger) DeleteTransactionMetadata(ctx context.Context, id int64, key string) error {
+	_, err := l.do(ctx, request{
+		method:     http.MethodDelete,
+		path:       l.path("transactions", strconv.FormatInt(id, 10), "metadata", key),
+		idempotent: true,
+	}, nil)
+	return err
+}
@@ -0,0 +1,135 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/numary/ledger/pkg/core"
+)
+
+// TransactionsQuery filters the transactions listed by FindTransactions, the zero values matching all of them
+type TransactionsQuery struct {
+	Reference       string
+	ReferencePrefix string
+	// Account matches the transactions with a posting from or to the account
+	Account string
+	Asset   string
+	// Metadata matches the transactions with all the metadata, by key
+	Metadata map[string]string
+	// After and Before bound the timestamps of the transactions
+	After  time.Time
+	Before time.Time
+	// PageSize is the number of transactions fetched per request, the default of the server if zero
+	PageSize int
+}
+
+func (q TransactionsQuery) values() url.Values {
+	values := url.Values{}
+	for param, value := range map[string]string{
+		"reference":        q.Reference,
+		"reference_prefix": q.ReferencePrefix,
+		"account":          q.Account,
+		"asset":            q.Asset,
+	} {
+		if value != "" {
+			values.Set(param, value)
+		}
+	}
+	for key, value := range q.Metadata {
+		values.Set(fmt.Sprintf("metadata[%s]", key), value)
+	}
+	if !q.After.IsZero() {
+		values.Set("after_timestamp", q.After.Format(time.RFC3339))
+	}
+	if !q.Before.IsZero() {
+		values.Set("before_timestamp", q.Before.Format(time.RFC3339))
+	}
+	if q.PageSize > 0 {
+		values.Set("page_size", strconv.Itoa(q.PageSize))
+	}
+	return values
+}
+
+// TransactionIterator iterates over the transactions matching a query, from the newest to the oldest,
+// fetching the pages one after the other as they are needed:
+//
+//	it := l.FindTransactions(q)
+//	for it.Next(ctx) {
+//		tx := it.Transaction()
+//	}
+//	err := it.Err()
+type TransactionIterator struct {
+	ledger *Ledger
+	query  url.Values
+	page   []core.Transaction
+	tx     core.Transaction
+	next   string
+	done   bool
+	err    error
+}
+
+// FindTransactions returns an iterator over the transactions matching the query
+func (l *Ledger) FindTransactions(q TransactionsQuery) *TransactionIterator {
+	return &TransactionIterator{
+		ledger: l,
+		query:  q.values(),
+	}
+}
+
+// Next moves to the next transaction, fetching the next page if needed.
+// It returns false when there are no more transactions or when fetching them failed, see Err.
+func (it *TransactionIterator) Next(ctx context.Context) bool {
+	for len(it.page) == 0 {
+		if it.done || it.err != nil {
+			return false
+		}
+		it.err = it.fetch(ctx)
+	}
+	it.tx, it.page = it.page[0], it.page[1:]
+	return true
+}
+
+// Transaction returns the current transaction
+func (it *TransactionIterator) Transaction() core.Transaction {
+	return it.tx
+}
+
+// Err returns the error which stopped the iteration, if any
+func (it *TransactionIterator) Err() error {
+	return it.err
+}
+
+func (it *TransactionIterator) fetch(ctx context.Context) error {
+	// The filters are passed along with the pagination token, which doesn't hold them
+	values := url.Values{}
+	for key, v := range it.query {
+		values[key] = v
+	}
+	if it.next != "" {
+		values.Set("pagination_token", it.next)
+	}
+
+	env, _, err := it.ledger.client.do(ctx, request{
+		method:     http.MethodGet,
+		path:       it.ledger.path("transactions"),
+		query:      values,
+		idempotent: true,
+	})
+	if err != nil {
+		return err
+	}
+	if env.Cursor == nil {
+		return fmt.Errorf("invalid response: missing cursor")
+	}
+	if err := json.Unmarshal(env.Cursor.Data, &it.page); err != nil {
+		return fmt.Errorf("invalid response: %w", err)
+	}
+	it.next = env.Cursor.Next
+	it.done = !env.Cursor.HasMore || it.next == ""
+	return nil
+}
@@ -17,6 +17,16 @@ type ConfigInfo struct {
 // Config struct
 type Config struct {
 	LedgerStorage *LedgerStorage `json:"storage"`
+	Limits        *Limits        `json:"limits,omitempty"`
+}
+
+// Limits are the limits of the size of the committed batches, zero meaning unlimited,
+// and the default and maximum page sizes of the lists
+type Limits struct {
+	MaxPostingsPerTransaction int `json:"max_postings_per_transaction"`
+	MaxTransactionsPerBatch   int `json:"max_transactions_per_batch"`
+	DefaultPageSize           int `json:"default_page_size"`
+	MaxPageSize               int `json:"max_page_size"`
 }
 
 // LedgerStorage struct
@@ -9,6 +9,9 @@ type Account struct {
 	Contract string                      `json:"contract" example:"default"`
 	Type     string                      `json:"type,omitempty" example:"virtual"`
 	Balances map[string]int64            `json:"balances,omitempty" example:"COIN:100"`
+	Scales   map[string]int              `json:"scales,omitempty" example:"USD/2:2"`
 	Volumes  map[string]map[string]int64 `json:"volumes,omitempty"`
 	Metadata Metadata                    `json:"metadata" swaggertype:"object"`
+	// MetadataVersion is the version of the metadata, sent back in the If-Match header of their updates
+	MetadataVersion int64 `json:"metadata_version,omitempty" example:"3"`
 }
@@ -1,9 +1,40 @@
 package core
 
-import "regexp"
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
 
+// assetRegexp matches the asset codes made of uppercase letters and digits, starting with a letter,
+// with an optional scale suffix like "/2"
+var assetRegexp = regexp.MustCompile(`^[A-Z][A-Z0-9]{0,15}(/[0-9]{1,2})?$`)
+
+// AssetIsValid reports whether an asset code, like "COIN" or "USD/2", is well formed
 func AssetIsValid(v string) bool {
-	re := regexp.MustCompile("[A-Z]{1,8}")
+	return assetRegexp.MatchString(v)
+}
+
+// AssetCode returns the asset code of a base code with a scale, like "USD/2", without suffix for a scale of 0
+func AssetCode(base string, scale int) string {
+	if scale == 0 {
+		return base
+	}
+	return base + "/" + strconv.Itoa(scale)
+}
+
+// AssetScale splits an asset code like "USD/2" into its base code and its number of decimals.
+// Assets without a valid scale suffix are considered to have a scale of 0.
+func AssetScale(v string) (string, int) {
+	parts := strings.SplitN(v, "/", 2)
+	if len(parts) != 2 {
+		return v, 0
+	}
+
+	scale, err := strconv.Atoi(parts[1])
+	if err != nil || scale < 0 {
+		return v, 0
+	}
 
-	return re.Match([]byte(v))
+	return parts[0], scale
 }
@@ -0,0 +1,45 @@
+package core
+
+import (
+	"testing"
+)
+
+func TestAssetScale(t *testing.T) {
+	for _, tc := range []struct {
+		asset string
+		base  string
+		scale int
+	}{
+		{asset: "COIN", base: "COIN", scale: 0},
+		{asset: "USD/2", base: "USD", scale: 2},
+		{asset: "BTC/8", base: "BTC", scale: 8},
+		{asset: "USD/x", base: "USD/x", scale: 0},
+	} {
+		base, scale := AssetScale(tc.asset)
+		if base != tc.base || scale != tc.scale {
+			t.Errorf("AssetScale(%q) = (%q, %d), expected (%q, %d)", tc.asset, base, scale, tc.base, tc.scale)
+		}
+	}
+}
+
+func TestAssetIsValid(t *testing.T) {
+	for _, tc := range []struct {
+		asset string
+		valid bool
+	}{
+		{asset: "COIN", valid: true},
+		{asset: "USD/2", valid: true},
+		{asset: "USDC2", valid: true},
+		{asset: "COIm", valid: false},
+		{asset: "coin", valid: false},
+		{asset: "2USD", valid: false},
+		{asset: "USD/", valid: false},
+		{asset: "USD/x", valid: false},
+		{asset: "USD/100", valid: false},
+		{asset: "", valid: false},
+	} {
+		if valid := AssetIsValid(tc.asset); valid != tc.valid {
+			t.Errorf("AssetIsValid(%q) = %v, expected %v", tc.asset, valid, tc.valid)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// TransactionBuilder builds a transaction, validated by Build with the rules Commit applies to the batches,
+// see ValidateTransactions. It is not safe for concurrent use.
+type TransactionBuilder struct {
+	tx   Transaction
+	opts ValidationOptions
+	err  error
+}
+
+// NewTransaction returns a builder of a transaction without postings
+func NewTransaction() *TransactionBuilder {
+	return &TransactionBuilder{
+		tx: Transaction{
+			Postings: Postings{},
+			Metadata: Metadata{},
+		},
+	}
+}
+
+// Transfer returns a builder of a transaction sending amount of asset from source to destination
+func Transfer(source, destination, asset string, amount int64) *TransactionBuilder {
+	return NewTransaction().AddPosting(source, destination, asset, amount)
+}
+
+// AddPosting appends a posting sending amount of asset from source to destination
+func (b *TransactionBuilder) AddPosting(source, destination, asset string, amount int64) *TransactionBuilder {
+	b.tx.AppendPosting(Posting{
+		Source:      source,
+		Destination: destination,
+		Amount:      amount,
+		Asset:       asset,
+	})
+	return b
+}
+
+// WithReference sets the reference of the transaction
+func (b *TransactionBuilder) WithReference(reference string) *TransactionBuilder {
+	b.tx.Reference = reference
+	return b
+}
+
+// WithMetadata sets a metadata key of the transaction to the json encoding of value
+func (b *TransactionBuilder) WithMetadata(key string, value interface{}) *TransactionBuilder {
+	data, err := json.Marshal(value)
+	if err != nil {
+		if b.err == nil {
+			b.err = fmt.Errorf("%w: metadata %s: %s", ErrValidation, key, err)
+		}
+		return b
+	}
+	b.tx.Metadata[key] = data
+	return b
+}
+
+// WithValidationOptions sets the options Build validates the transaction with,
+// to match the ones of the ledger it is committed to
+func (b *TransactionBuilder) WithValidationOptions(opts ValidationOptions) *TransactionBuilder {
+	b.opts = opts
+	return b
+}
+
+// Build returns the transaction, or the error of the first invalid metadata value,
+// or the ValidationErrors of its invalid fields, reported as the transaction 0 of a batch
+func (b *TransactionBuilder) Build() (Transaction, error) {
+	if b.err != nil {
+		return Transaction{}, b.err
+	}
+
+	tx := b.tx
+	tx.Postings = append(Postings{}, b.tx.Postings...)
+	tx.Metadata = Metadata{}
+	for key, value := range b.tx.Metadata {
+		tx.Metadata[key] = value
+	}
+
+	if err := ValidateTransactions([]Transaction{tx}, b.opts); err != nil {
+		return Transaction{}, err
+	}
+	return tx, nil
+}
@@ -0,0 +1,105 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestTransactionBuilder(t *testing.T) {
+	tx, err := NewTransaction().
+		AddPosting("world", "central_bank", "COIN", 100).
+		AddPosting("central_bank", "users:001", "COIN", 100).
+		WithReference("mint_001").
+		WithMetadata("description", "mint then distribute").
+		Build()
+	if err != nil {
+		t.Fatalf("unexpected error for a valid transaction: %s", err)
+	}
+
+	expected := Transaction{
+		Postings: Postings{
+			{Source: "world", Destination: "central_bank", Amount: 100, Asset: "COIN"},
+			{Source: "central_bank", Destination: "users:001", Amount: 100, Asset: "COIN"},
+		},
+		Reference: "mint_001",
+		Metadata: Metadata{
+			"description": json.RawMessage(`"mint then distribute"`),
+		},
+	}
+	if diff := cmp.Diff(expected, tx); diff != "" {
+		t.Fatalf("unexpected transaction (-want +got):\n%s", diff)
+	}
+
+	tx, err = Transfer("world", "users:001", "COIN", 100).Build()
+	if err != nil {
+		t.Fatalf("unexpected error for a valid transfer: %s", err)
+	}
+	postings := Postings{{Source: "world", Destination: "users:001", Amount: 100, Asset: "COIN"}}
+	if diff := cmp.Diff(postings, tx.Postings); diff != "" {
+		t.Fatalf("unexpected postings (-want +got):\n%s", diff)
+	}
+}
+
+func TestTransactionBuilderValidation(t *testing.T) {
+	_, err := NewTransaction().Build()
+	if !errors.Is(err, ErrValidation) {
+		t.Fatalf("expected a validation error for a transaction without postings, got %v", err)
+	}
+
+	_, err = Transfer("users:001", "users:001", "COIN", 0).Build()
+	var errs ValidationErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("expected validation errors, got %v", err)
+	}
+	expected := ValidationErrors{
+		{Transaction: 0, Posting: 0, Field: "destination", Message: "must differ from the source"},
+		{Transaction: 0, Posting: 0, Field: "amount", Message: "must be positive"},
+	}
+	if diff := cmp.Diff(expected, errs); diff != "" {
+		t.Fatalf("unexpected errors (-want +got):\n%s", diff)
+	}
+
+	// The options of the ledger apply
+	_, err = Transfer("users:001", "users:001", "COIN", 10).
+		WithValidationOptions(ValidationOptions{AllowNoop: true}).
+		Build()
+	if err != nil {
+		t.Fatalf("unexpected error for an allowed noop posting: %s", err)
+	}
+	_, err = Transfer("world", "users:001", "COIN", 10).
+		AddPosting("world", "users:002", "COIN", 10).
+		WithValidationOptions(ValidationOptions{MaxPostings: 1}).
+		Build()
+	if !errors.Is(err, ErrValidation) {
+		t.Fatalf("expected a validation error for too many postings, got %v", err)
+	}
+
+	_, err = Transfer("world", "users:001", "COIN", 10).
+		WithMetadata("invalid", func() {}).
+		Build()
+	if !errors.Is(err, ErrValidation) {
+		t.Fatalf("expected a validation error for an invalid metadata value, got %v", err)
+	}
+}
+
+func TestTransactionBuilderCopies(t *testing.T) {
+	b := Transfer("world", "users:001", "COIN", 10).WithMetadata("step", 1)
+	first, err := b.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := b.AddPosting("world", "users:002", "COIN", 10).WithMetadata("step", 2).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(first.Postings) != 1 || string(first.Metadata["step"]) != "1" {
+		t.Fatalf("the built transaction changed along with the builder: %+v", first)
+	}
+	if len(second.Postings) != 2 || string(second.Metadata["step"]) != "2" {
+		t.Fatalf("unexpected transaction: %+v", second)
+	}
+}
@@ -0,0 +1,119 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+)
+
+// conversionsKey is the metadata key of the conversions of a transaction
+const conversionsKey = "scheme/conversions"
+
+// Conversion links the posting debiting an asset to the posting crediting another asset in exchange,
+// along with the rate applied. The conversions of a transaction are stored in its metadata, see Metadata.Conversions.
+type Conversion struct {
+	// Source is the index of the posting of the converted asset
+	Source int `json:"source"`
+	// Destination is the index of the posting of the asset it is converted to
+	Destination int `json:"destination"`
+	// Rate is the decimal number of units of the destination asset given for a unit of the source asset, like "1.0845".
+	// The units are the ones of the assets without their scale, USD rather than cents for USD/2.
+	Rate string `json:"rate"`
+}
+
+// SetConversions records the conversions of a transaction
+func (m Metadata) SetConversions(conversions []Conversion) {
+	data, _ := json.Marshal(conversions)
+	m[conversionsKey] = data
+}
+
+// Conversions returns the conversions of a transaction, nil if it has none
+func (m Metadata) Conversions() ([]Conversion, error) {
+	data, ok := m[conversionsKey]
+	if !ok {
+		return nil, nil
+	}
+
+	var conversions []Conversion
+	if err := json.Unmarshal(data, &conversions); err != nil {
+		return nil, fmt.Errorf("invalid conversions: %w", err)
+	}
+	return conversions, nil
+}
+
+// ParseRate parses the rate of a conversion, which must be a positive decimal number
+func ParseRate(rate string) (*big.Rat, error) {
+	r, ok := new(big.Rat).SetString(rate)
+	if !ok || r.Sign() <= 0 {
+		return nil, fmt.Errorf("invalid rate '%s': expected a positive decimal number", rate)
+	}
+	return r, nil
+}
+
+// ConvertAmount returns the amount of the destination asset given for an amount of the source asset at the rate,
+// rounded half away from zero. The amounts are in the units of the scales of their assets, see AssetScale.
+func ConvertAmount(amount int64, source, destination string, rate *big.Rat) *big.Int {
+	_, sourceScale := AssetScale(source)
+	_, destinationScale := AssetScale(destination)
+
+	converted := new(big.Rat).Mul(new(big.Rat).SetInt64(amount), rate)
+	scale := new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(abs(destinationScale-sourceScale))), nil))
+	if destinationScale > sourceScale {
+		converted.Mul(converted, scale)
+	} else {
+		converted.Quo(converted, scale)
+	}
+
+	// The amounts are positive, rounding half away from zero is adding a half before truncating
+	converted.Add(converted, big.NewRat(1, 2))
+	return new(big.Int).Quo(converted.Num(), converted.Denom())
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// validateConversions checks that the conversions of a transaction link postings of different assets,
+// which amounts match the rate within the tolerance
+func validateConversions(t Transaction, tolerance int64, invalid func(posting int, field, msg string)) {
+	conversions, err := t.Metadata.Conversions()
+	if err != nil {
+		invalid(-1, "metadata", fmt.Sprintf("%s %s", conversionsKey, err))
+		return
+	}
+
+	for k, c := range conversions {
+		if c.Source < 0 || c.Source >= len(t.Postings) || c.Destination < 0 || c.Destination >= len(t.Postings) {
+			invalid(-1, "metadata", fmt.Sprintf("%s conversion %d must link postings of the transaction", conversionsKey, k))
+			continue
+		}
+		source, destination := t.Postings[c.Source], t.Postings[c.Destination]
+		if source.Asset == destination.Asset {
+			invalid(c.Destination, "asset", fmt.Sprintf("must differ from the asset of posting %d, converted by conversion %d", c.Source, k))
+			continue
+		}
+		rate, err := ParseRate(c.Rate)
+		if err != nil {
+			invalid(-1, "metadata", fmt.Sprintf("%s conversion %d: %s", conversionsKey, k, err))
+			continue
+		}
+		if source.Amount < 1 || destination.Amount < 1 {
+			// Already reported as invalid amounts
+			continue
+		}
+
+		expected := ConvertAmount(source.Amount, source.Asset, destination.Asset, rate)
+		diff := new(big.Int).Sub(big.NewInt(destination.Amount), expected)
+		if diff.CmpAbs(big.NewInt(tolerance)) > 0 {
+			msg := fmt.Sprintf("must be %s", expected)
+			if tolerance > 0 {
+				msg += fmt.Sprintf(" within %d", tolerance)
+			}
+			invalid(c.Destination, "amount", fmt.Sprintf("%s, converted from posting %d at the rate %s of conversion %d",
+				msg, c.Source, c.Rate, k))
+		}
+	}
+}
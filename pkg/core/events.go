@@ -0,0 +1,9 @@
+package core
+
+// CommittedTransactions is the event emitted once a batch of transactions has been committed to a ledger
+type CommittedTransactions struct {
+	Ledger       string        `json:"ledger"`
+	Transactions []Transaction `json:"transactions"`
+	// Balances holds the balance delta of each account, by asset, resulting from the transactions
+	Balances map[string]map[string]int64 `json:"balances"`
+}
@@ -0,0 +1,259 @@
+package core
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"sort"
+	"strings"
+)
+
+// Hasher is the algorithm used to chain transaction hashes
+type Hasher interface {
+	// Algorithm identifies the hasher, it is recorded along with the hashes it computes
+	Algorithm() string
+	New() hash.Hash
+}
+
+type hasher struct {
+	algorithm string
+	new       func() hash.Hash
+}
+
+func (h hasher) Algorithm() string {
+	return h.algorithm
+}
+
+func (h hasher) New() hash.Hash {
+	return h.new()
+}
+
+var (
+	SHA256Hasher Hasher = hasher{algorithm: "sha256", new: sha256.New}
+	SHA512Hasher Hasher = hasher{algorithm: "sha512", new: sha512.New}
+
+	// DefaultHasher computes the hashes of ledgers configured without a specific hasher
+	DefaultHasher = SHA256Hasher
+)
+
+// NewHMACHasher returns a keyed SHA-256 HMAC hasher
+func NewHMACHasher(key []byte) Hasher {
+	return hasher{
+		algorithm: "hmac-sha256",
+		new: func() hash.Hash {
+			return hmac.New(sha256.New, key)
+		},
+	}
+}
+
+// HashAlgorithm returns the algorithm recorded in a hash computed by HashWith,
+// the hashes of the older versions without algorithm being computed with SHA-256
+func HashAlgorithm(hash string) string {
+	if i := strings.Index(hash, ":"); i >= 0 {
+		return hash[:i]
+	}
+	return SHA256Hasher.Algorithm()
+}
+
+// IsLegacyHash tells if a hash was computed by the older versions, which serialized the transactions
+// with their metadata and recorded the hashes without algorithm, see LegacyHash
+func IsLegacyHash(hash string) bool {
+	return hash != "" && !strings.Contains(hash, ":")
+}
+
+// canonical serializes the part of a transaction covered by the hash chain, with a fixed field order
+// that doesn't depend on the Transaction struct layout, the one json.Marshal produced for the first version
+// of the struct. The metadata is serialized as given, the digest of the metadata for the hashes of this version,
+// see HashWith. The hash of the transaction being hashed is blanked.
+func canonical(t *Transaction, withHash bool, metadata []byte) []byte {
+	if t == nil {
+		return []byte("null")
+	}
+
+	str := func(v string) string {
+		b, _ := json.Marshal(v)
+		return string(b)
+	}
+
+	buf := bytes.Buffer{}
+	fmt.Fprintf(&buf, `{"txid":%d,"postings":`, t.ID)
+	if t.Postings == nil {
+		buf.WriteString("null")
+	} else {
+		buf.WriteString("[")
+		for i, p := range t.Postings {
+			if i > 0 {
+				buf.WriteString(",")
+			}
+			fmt.Fprintf(&buf, `{"source":%s,"destination":%s,"amount":%d,"asset":%s}`,
+				str(p.Source), str(p.Destination), p.Amount, str(p.Asset))
+		}
+		buf.WriteString("]")
+	}
+
+	hash := ""
+	if withHash {
+		hash = t.Hash
+	}
+	fmt.Fprintf(&buf, `,"reference":%s,"timestamp":%s,"hash":%s,"metadata":%s}`,
+		str(t.Reference), str(t.Timestamp), str(hash), metadata)
+
+	return buf.Bytes()
+}
+
+// Hash computes the hash of t2 chained to its predecessor t1, which is nil for the first transaction, see HashWith
+func Hash(t1 *Transaction, t2 *Transaction) string {
+	return HashWith(DefaultHasher, t1, t2)
+}
+
+// HashWith computes the hash of t2 chained to its predecessor t1 with the given hasher, prefixed with the algorithm
+// of the hasher, like "sha256:<digest>:<metadata digest>". The timestamp and the metadata t2 is committed with
+// are part of the hashed content, so they are covered by the integrity chain. The metadata can be updated after
+// the transaction is committed, so they are hashed apart: the chain covers the digest of the metadata, which
+// is recorded in the hash, and the transactions whose metadata were updated since still verify, see VerifyChainedHash.
+func HashWith(hasher Hasher, t1 *Transaction, t2 *Transaction) string {
+	metadata := metadataDigest(hasher, t2.Metadata)
+	return fmt.Sprintf("%s:%s:%s", hasher.Algorithm(), chainDigest(hasher, t1, t2, metadata), metadata)
+}
+
+// chainDigest returns the digest of t2 chained to t1, the metadata of t2 being covered by their digest.
+// The metadata of t1 are covered by its hash.
+func chainDigest(hasher Hasher, t1 *Transaction, t2 *Transaction, metadata string) string {
+	h := hasher.New()
+	h.Write(canonical(t1, true, []byte("null")))
+	h.Write(canonical(t2, false, []byte(fmt.Sprintf("%q", metadata))))
+
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+// metadataDigest returns the digest of the canonical serialization of metadata, see canonicalMetadata
+func metadataDigest(hasher Hasher, m Metadata) string {
+	h := hasher.New()
+	h.Write(canonicalMetadata(m))
+
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+// canonicalMetadata serializes metadata with their keys sorted, the keys of the objects of their values too,
+// whatever the order they were inserted in and the formatting of their json values. Empty metadata are
+// serialized as {}, whether they are nil or not, the stores reading them back as such.
+func canonicalMetadata(m Metadata) []byte {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	buf := bytes.Buffer{}
+	buf.WriteString("{")
+	for i, k := range keys {
+		if i > 0 {
+			buf.WriteString(",")
+		}
+		key, _ := json.Marshal(k)
+		buf.Write(key)
+		buf.WriteString(":")
+		buf.Write(canonicalJSON(m[k]))
+	}
+	buf.WriteString("}")
+
+	return buf.Bytes()
+}
+
+// canonicalJSON re-encodes a json value, which objects are encoded with sorted keys by encoding/json.
+// The numbers are kept as written, the values which are not valid json as they are.
+func canonicalJSON(raw json.RawMessage) []byte {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+
+	var v interface{}
+	if err := dec.Decode(&v); err != nil || dec.More() {
+		return raw
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return raw
+	}
+	return b
+}
+
+// splitHash returns the digest and the metadata digest recorded in a hash computed by HashWith
+func splitHash(hash string) (string, string, bool) {
+	parts := strings.Split(hash, ":")
+	if len(parts) != 3 {
+		return "", "", false
+	}
+	return parts[1], parts[2], true
+}
+
+// LegacyHash computes the hash of t2 chained to t1 like the older versions did: the SHA-256 of the json encoding
+// of both transactions, along with their metadata, without algorithm.
+func LegacyHash(t1 *Transaction, t2 *Transaction) string {
+	h := sha256.New()
+	h.Write(canonical(t1, true, legacyMetadata(t1)))
+	h.Write(canonical(t2, false, legacyMetadata(t2)))
+
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+func legacyMetadata(t *Transaction) []byte {
+	if t == nil {
+		return nil
+	}
+	metadata, _ := json.Marshal(t.Metadata)
+	return metadata
+}
+
+// VerifyHash tells if the hash of t2 is the hash of t2 chained to t1 with the metadata of t2, computed with
+// the given hasher, or with LegacyHash for the hashes computed by the older versions, see VerifyChainedHash
+// and VerifyMetadata.
+func VerifyHash(hasher Hasher, t1 *Transaction, t2 *Transaction) bool {
+	return VerifyChainedHash(hasher, t1, t2) && VerifyMetadata(hasher, t2)
+}
+
+// VerifyChainedHash tells if the hash of t2 is the hash of t2 chained to t1, the metadata of t2 being covered
+// by the digest recorded in its hash rather than by their values, so that it is true for the transactions
+// whose metadata were updated since they were committed.
+// The older versions hashed the transactions with the metadata they were committed with, null when empty,
+// while their predecessors were hashed with the metadata read back from the store, {} when empty:
+// both are tried, so that the chains of the older versions verify as long as their metadata is unchanged.
+func VerifyChainedHash(hasher Hasher, t1 *Transaction, t2 *Transaction) bool {
+	if !IsLegacyHash(t2.Hash) {
+		digest, metadata, ok := splitHash(t2.Hash)
+		return ok && HashAlgorithm(t2.Hash) == hasher.Algorithm() && chainDigest(hasher, t1, t2, metadata) == digest
+	}
+
+	for _, previous := range legacyVariants(t1) {
+		for _, tx := range legacyVariants(t2) {
+			if LegacyHash(previous, tx) == t2.Hash {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// VerifyMetadata tells if the metadata of t are the ones it was committed with, as recorded in its hash.
+// The metadata of the older versions are verified along with the chain, see VerifyChainedHash.
+func VerifyMetadata(hasher Hasher, t *Transaction) bool {
+	if IsLegacyHash(t.Hash) {
+		return true
+	}
+	_, metadata, ok := splitHash(t.Hash)
+	return ok && metadataDigest(hasher, t.Metadata) == metadata
+}
+
+// legacyVariants returns the transaction with its metadata, and with both encodings of empty metadata if it has none
+func legacyVariants(t *Transaction) []*Transaction {
+	if t == nil || len(t.Metadata) > 0 {
+		return []*Transaction{t}
+	}
+	withNull, withEmpty := *t, *t
+	withNull.Metadata = nil
+	withEmpty.Metadata = Metadata{}
+	return []*Transaction{&withNull, &withEmpty}
+}
@@ -0,0 +1,49 @@
+package core
+
+import "time"
+
+const (
+	// HoldActive is the status of the holds reserving their amount
+	HoldActive = "active"
+	// HoldCaptured is the status of the holds committed as a transaction to their destination
+	HoldCaptured = "captured"
+	// HoldReleased is the status of the holds given up without a transaction
+	HoldReleased = "released"
+	// HoldExpired is the status of the active holds past their expiry, which are released
+	HoldExpired = "expired"
+)
+
+// Hold reserves an amount of an account, which is unavailable to the other transactions until the hold
+// is captured into a transaction to its destination, or released
+type Hold struct {
+	ID          string `json:"id"`
+	Account     string `json:"account"`
+	Destination string `json:"destination"`
+	Asset       string `json:"asset"`
+	Amount      int64  `json:"amount"`
+	Status      string `json:"status"`
+	// ExpiresAt is the time after which the hold is released, empty if it doesn't expire
+	ExpiresAt string `json:"expires_at,omitempty"`
+	// TxID is the id of the transaction of a captured hold
+	TxID      *int64 `json:"txid,omitempty"`
+	Timestamp string `json:"timestamp"`
+}
+
+// Expired reports whether the hold is active past its expiry
+func (h Hold) Expired(now time.Time) bool {
+	if h.Status != HoldActive || h.ExpiresAt == "" {
+		return false
+	}
+	expiresAt, err := time.Parse(time.RFC3339, h.ExpiresAt)
+	return err == nil && !now.Before(expiresAt)
+}
+
+// Posting returns the posting of the transaction capturing the hold
+func (h Hold) Posting() Posting {
+	return Posting{
+		Source:      h.Account,
+		Destination: h.Destination,
+		Amount:      h.Amount,
+		Asset:       h.Asset,
+	}
+}
@@ -0,0 +1,164 @@
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// The Merkle tree of a ledger is built over the hashes of its transactions, in chain order, the way RFC 6962
+// builds the trees of the certificate transparency logs: the leaves and the nodes are hashed with SHA-256
+// with distinct prefixes, and a tree of n leaves is split after the largest power of two lower than n.
+// The root of the tree of the first n transactions never changes, so that it can be published and the
+// inclusion of a transaction proved against it later on, with a path of log(n) hashes.
+const (
+	merkleLeafPrefix = 0x00
+	merkleNodePrefix = 0x01
+)
+
+// MerkleProof proves that a transaction is included in the Merkle tree of the first Size transactions of a ledger
+type MerkleProof struct {
+	TxID int64 `json:"txid"`
+	// Index is the position of the transaction in the chain, which is its id if the ids are a sequence
+	Index int64 `json:"index"`
+	// Hash is the hash of the transaction, which is the leaf of the tree
+	Hash string `json:"hash"`
+	Size int64  `json:"size"`
+	// Path holds the siblings of the nodes from the leaf up to the root
+	Path []MerkleNode `json:"path"`
+	Root string       `json:"root"`
+}
+
+// MerkleNode is a node of the path of a MerkleProof
+type MerkleNode struct {
+	Hash string `json:"hash"`
+	// Left is true when the node is the left child of its parent, its sibling being on the right
+	Left bool `json:"left"`
+}
+
+func merkleLeaf(hash string) []byte {
+	h := sha256.New()
+	h.Write([]byte{merkleLeafPrefix})
+	h.Write([]byte(hash))
+	return h.Sum(nil)
+}
+
+func merkleNode(left, right []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{merkleNodePrefix})
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
+
+// merkleSplit returns the largest power of two lower than n, n being greater than 1
+func merkleSplit(n int64) int64 {
+	k := int64(1)
+	for k<<1 < n {
+		k <<= 1
+	}
+	return k
+}
+
+func merkleTreeRoot(leaves [][]byte) []byte {
+	switch len(leaves) {
+	case 0:
+		h := sha256.Sum256(nil)
+		return h[:]
+	case 1:
+		return leaves[0]
+	}
+	k := merkleSplit(int64(len(leaves)))
+	return merkleNode(merkleTreeRoot(leaves[:k]), merkleTreeRoot(leaves[k:]))
+}
+
+func merklePath(leaves [][]byte, index int64) []MerkleNode {
+	if len(leaves) < 2 {
+		return []MerkleNode{}
+	}
+	k := merkleSplit(int64(len(leaves)))
+	if index < k {
+		return append(merklePath(leaves[:k], index), MerkleNode{
+			Hash: hex.EncodeToString(merkleTreeRoot(leaves[k:])),
+		})
+	}
+	return append(merklePath(leaves[k:], index-k), MerkleNode{
+		Hash: hex.EncodeToString(merkleTreeRoot(leaves[:k])),
+		Left: true,
+	})
+}
+
+// merkleSides returns the Left flags of the path of the leaf at index in a tree of size leaves
+func merkleSides(index, size int64) []bool {
+	if size < 2 {
+		return []bool{}
+	}
+	k := merkleSplit(size)
+	if index < k {
+		return append(merkleSides(index, k), false)
+	}
+	return append(merkleSides(index-k, size-k), true)
+}
+
+func merkleLeaves(hashes []string) [][]byte {
+	leaves := make([][]byte, len(hashes))
+	for i, hash := range hashes {
+		leaves[i] = merkleLeaf(hash)
+	}
+	return leaves
+}
+
+// MerkleRoot returns the root of the Merkle tree of the hashes of transactions, in chain order
+func MerkleRoot(hashes []string) string {
+	return hex.EncodeToString(merkleTreeRoot(merkleLeaves(hashes)))
+}
+
+// NewMerkleProof returns the proof of inclusion of the transaction at index in the Merkle tree of the hashes
+// of transactions, in chain order. The index must be within the hashes. The proof is of the transaction
+// of id index, its TxID must be set otherwise.
+func NewMerkleProof(hashes []string, index int64) MerkleProof {
+	leaves := merkleLeaves(hashes)
+	return MerkleProof{
+		TxID:  index,
+		Index: index,
+		Hash:  hashes[index],
+		Size:  int64(len(hashes)),
+		Path:  merklePath(leaves, index),
+		Root:  hex.EncodeToString(merkleTreeRoot(leaves)),
+	}
+}
+
+// ComputeRoot returns the root of the tree obtained by hashing the leaf of the proof along its path
+func (p MerkleProof) ComputeRoot() string {
+	node := merkleLeaf(p.Hash)
+	for _, sibling := range p.Path {
+		h, err := hex.DecodeString(sibling.Hash)
+		if err != nil {
+			return ""
+		}
+		if sibling.Left {
+			node = merkleNode(h, node)
+		} else {
+			node = merkleNode(node, h)
+		}
+	}
+	return hex.EncodeToString(node)
+}
+
+// Verify reports whether the proof leads to the given root, like a root published for the size of the proof,
+// from the leaf of its transaction: the path must be the one of the position of the transaction in the tree.
+// A third party holding the transaction checks its hash against the one of the proof too.
+func (p MerkleProof) Verify(root string) bool {
+	if p.Index < 0 || p.Index >= p.Size {
+		return false
+	}
+	sides := merkleSides(p.Index, p.Size)
+	if len(sides) != len(p.Path) {
+		return false
+	}
+	for i, left := range sides {
+		if p.Path[i].Left != left {
+			return false
+		}
+	}
+	return p.Root == root && p.ComputeRoot() == root
+}
@@ -0,0 +1,56 @@
+package core
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMerkleProof(t *testing.T) {
+	for size := 1; size <= 9; size++ {
+		hashes := make([]string, size)
+		for i := range hashes {
+			hashes[i] = fmt.Sprintf("hash%d", i)
+		}
+		root := MerkleRoot(hashes)
+
+		for i := range hashes {
+			proof := NewMerkleProof(hashes, int64(i))
+			assert.Equal(t, root, proof.Root)
+			assert.True(t, proof.Verify(root), "size %d, txid %d", size, i)
+		}
+	}
+
+	// The tree of 5 transactions is split after the first 4, so that its root doesn't change as transactions are added
+	hashes := []string{"hash0", "hash1", "hash2", "hash3", "hash4"}
+	assert.Equal(t, MerkleRoot(hashes[:4]), NewMerkleProof(hashes, 4).Path[0].Hash)
+	assert.NotEqual(t, MerkleRoot(hashes[:4]), MerkleRoot(hashes))
+}
+
+func TestMerkleProofTampered(t *testing.T) {
+	hashes := []string{"hash0", "hash1", "hash2", "hash3", "hash4", "hash5"}
+	root := MerkleRoot(hashes)
+
+	tampered := NewMerkleProof(hashes, 2)
+	tampered.Hash = "forged"
+	assert.False(t, tampered.Verify(root))
+
+	tampered = NewMerkleProof(hashes, 2)
+	tampered.Path = append([]MerkleNode{}, tampered.Path...)
+	tampered.Path[1].Hash = MerkleRoot([]string{"forged"})
+	assert.False(t, tampered.Verify(root))
+
+	// The proof of a transaction doesn't prove the inclusion of another one at its place
+	tampered = NewMerkleProof(hashes, 2)
+	tampered.Index = 3
+	assert.False(t, tampered.Verify(root))
+
+	// A root computed by the holder of the proof is not the published one
+	tampered = NewMerkleProof(hashes, 2)
+	tampered.Hash = "forged"
+	tampered.Root = tampered.ComputeRoot()
+	assert.False(t, tampered.Verify(root))
+
+	assert.False(t, NewMerkleProof(hashes, 2).Verify(MerkleRoot(hashes[:5])))
+}
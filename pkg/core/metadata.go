@@ -3,11 +3,96 @@ package core
 import (
 	"encoding/json"
 	"fmt"
+	"strconv"
 )
 
 type Metadata map[string]json.RawMessage
 
+// MarkRevertedBy marks a transaction as reverted by the transaction txID
 func (m Metadata) MarkRevertedBy(txID string) {
 	m["scheme/state"] = []byte("\"reverted\"")
 	m["scheme/state/reverted-by"] = []byte(fmt.Sprintf("\"%s\"", txID))
 }
+
+// MarkReverts marks a transaction as reverting the transaction txID
+func (m Metadata) MarkReverts(txID string) {
+	m["scheme/state/reverts"] = []byte(fmt.Sprintf("\"%s\"", txID))
+}
+
+// IsReverted reports whether the transaction has been reverted
+func (m Metadata) IsReverted() bool {
+	return string(m["scheme/state"]) == "\"reverted\""
+}
+
+// IsRevert reports whether the transaction reverts another transaction
+func (m Metadata) IsRevert() bool {
+	_, ok := m["scheme/state/reverts"]
+	return ok
+}
+
+// Reverts returns the id of the transaction reverted by the transaction, false if it isn't a revert
+func (m Metadata) Reverts() (int64, bool) {
+	return m.txID("scheme/state/reverts")
+}
+
+// RevertedBy returns the id of the transaction which reverted the transaction, false if it hasn't been reverted
+func (m Metadata) RevertedBy() (int64, bool) {
+	return m.txID("scheme/state/reverted-by")
+}
+
+// txID reads the id of a transaction from the metadata, stored as a json string
+func (m Metadata) txID(key string) (int64, bool) {
+	var id string
+	if err := json.Unmarshal(m[key], &id); err != nil {
+		return 0, false
+	}
+	v, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return v, true
+}
+
+// MarkCreated marks an account as explicitly created, rather than implicitly by a posting
+func (m Metadata) MarkCreated() {
+	m["scheme/state/created"] = []byte("true")
+}
+
+// IsCreated reports whether the account has been explicitly created
+func (m Metadata) IsCreated() bool {
+	_, ok := m["scheme/state/created"]
+	return ok
+}
+
+// MarkPartiallyReverted marks a transaction as partially reverted by the transactions txIDs,
+// which reverted the given postings in total
+func (m Metadata) MarkPartiallyReverted(txIDs []string, reverted Postings) {
+	ids, _ := json.Marshal(txIDs)
+	postings, _ := json.Marshal(reverted)
+	m["scheme/state/partially-reverted-by"] = ids
+	m["scheme/state/reverted-postings"] = postings
+}
+
+// IsPartiallyReverted reports whether the transaction has been partially reverted
+func (m Metadata) IsPartiallyReverted() bool {
+	_, ok := m["scheme/state/partially-reverted-by"]
+	return ok
+}
+
+// PartialReverts returns the ids of the transactions partially reverting the transaction,
+// along with the postings they reverted in total
+func (m Metadata) PartialReverts() ([]string, Postings, error) {
+	if !m.IsPartiallyReverted() {
+		return nil, nil, nil
+	}
+
+	var ids []string
+	if err := json.Unmarshal(m["scheme/state/partially-reverted-by"], &ids); err != nil {
+		return nil, nil, fmt.Errorf("invalid partial reverts: %w", err)
+	}
+	var reverted Postings
+	if err := json.Unmarshal(m["scheme/state/reverted-postings"], &reverted); err != nil {
+		return nil, nil, fmt.Errorf("invalid reverted postings: %w", err)
+	}
+	return ids, reverted, nil
+}
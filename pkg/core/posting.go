@@ -1,5 +1,14 @@
 package core
 
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+)
+
 type Posting struct {
 	Source      string `json:"source"`
 	Destination string `json:"destination"`
@@ -7,6 +16,58 @@ type Posting struct {
 	Asset       string `json:"asset"`
 }
 
+// UnmarshalJSON decodes a posting, its amount being decoded exactly whatever its size.
+// The amounts out of the range of int64 and the ones which are not integers, like 1.5 or 1e3,
+// are rejected with an error matching ErrValidation, rather than rounded or wrapped.
+func (p *Posting) UnmarshalJSON(data []byte) error {
+	type posting Posting
+	var v struct {
+		posting
+		Amount json.RawMessage `json:"amount"`
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+
+	amount, err := parseAmount(v.Amount)
+	if err != nil {
+		return err
+	}
+	*p = Posting(v.posting)
+	p.Amount = amount
+	return nil
+}
+
+// parseAmount decodes the JSON amount of a posting, zero if missing or null
+func parseAmount(data json.RawMessage) (int64, error) {
+	if data == nil {
+		return 0, nil
+	}
+
+	var v interface{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		return 0, err
+	}
+	switch v := v.(type) {
+	case nil:
+		return 0, nil
+	case json.Number:
+		amount, err := strconv.ParseInt(v.String(), 10, 64)
+		switch {
+		case errors.Is(err, strconv.ErrRange):
+			return 0, fmt.Errorf("%w: invalid amount %s: expected an integer between %d and %d",
+				ErrValidation, v, int64(math.MinInt64), int64(math.MaxInt64))
+		case err != nil:
+			return 0, fmt.Errorf("%w: invalid amount %s: expected an integer", ErrValidation, v)
+		}
+		return amount, nil
+	default:
+		return 0, fmt.Errorf("%w: invalid amount %s: expected an integer", ErrValidation, data)
+	}
+}
+
 type Postings []Posting
 
 func (ps Postings) Reverse() {
@@ -21,3 +82,15 @@ func (ps Postings) Reverse() {
 		ps[opp].Source, ps[opp].Destination = ps[opp].Destination, ps[opp].Source
 	}
 }
+
+// LoggedPosting is a posting of the log of the postings of a ledger, which lists them in the order of their commits
+type LoggedPosting struct {
+	Txid int64 `json:"txid"`
+	// Index is the index of the posting in the postings of its transaction
+	Index int `json:"index"`
+	// Last tells if the posting is the last one of its transaction, whose txid can then be recorded
+	// as the position to resume the log from
+	Last      bool    `json:"last"`
+	Timestamp string  `json:"timestamp"`
+	Posting   Posting `json:"posting"`
+}
@@ -1,6 +1,9 @@
 package core
 
 import (
+	"encoding/json"
+	"errors"
+	"math"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
@@ -69,3 +72,36 @@ func TestReverseSingle(t *testing.T) {
 		t.Errorf("Reverse() mismatch (-want +got):\n%s", diff)
 	}
 }
+
+func TestPostingAmount(t *testing.T) {
+	for data, expected := range map[string]int64{
+		`9007199254740993`:     9007199254740993,
+		`9223372036854775807`:  math.MaxInt64,
+		`-9223372036854775808`: math.MinInt64,
+		`0`:                    0,
+		`null`:                 0,
+	} {
+		var p Posting
+		err := json.Unmarshal([]byte(`{"source":"world","destination":"users:001","amount":`+data+`,"asset":"COIN"}`), &p)
+		if err != nil {
+			t.Fatalf("unexpected error for the amount %s: %s", data, err)
+		}
+		if diff := cmp.Diff(Posting{Source: "world", Destination: "users:001", Amount: expected, Asset: "COIN"}, p); diff != "" {
+			t.Fatalf("unexpected posting for the amount %s (-want +got):\n%s", data, diff)
+		}
+	}
+
+	for _, data := range []string{`9223372036854775808`, `-9223372036854775809`, `1.5`, `100.0`, `1e3`, `"100"`, `true`} {
+		var p Posting
+		err := json.Unmarshal([]byte(`{"amount":`+data+`}`), &p)
+		if !errors.Is(err, ErrValidation) {
+			t.Fatalf("expected a validation error for the amount %s, got %v", data, err)
+		}
+	}
+
+	var ts []Transaction
+	err := json.Unmarshal([]byte(`[{"postings":[{"amount":1.5}]}]`), &ts)
+	if !errors.Is(err, ErrValidation) {
+		t.Fatalf("expected a validation error for a posting of a transaction, got %v", err)
+	}
+}
@@ -3,6 +3,18 @@ package core
 import "encoding/json"
 
 type Script struct {
-	Plain string                     `json:"plain"`
-	Vars  map[string]json.RawMessage `json:"vars" swaggertype:"object"`
+	Plain string `json:"plain"`
+	// Vars binds the variables declared by the script, by name without the $ sign: a string for
+	// the account and asset variables, an integer for the number variables and an object like
+	// {"asset": "USD/2", "amount": 100} for the monetary variables
+	Vars map[string]json.RawMessage `json:"vars" swaggertype:"object"`
+}
+
+// StoredScript is a version of a script stored by name, to be executed by its name with the values of its variables.
+// The versions of a script are numbered from 1.
+type StoredScript struct {
+	Name      string `json:"name"`
+	Version   int64  `json:"version"`
+	Plain     string `json:"plain"`
+	Timestamp string `json:"timestamp"`
 }
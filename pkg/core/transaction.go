@@ -1,11 +1,5 @@
 package core
 
-import (
-	"crypto/sha256"
-	"encoding/json"
-	"fmt"
-)
-
 type Transaction struct {
 	ID        int64    `json:"txid"`
 	Postings  Postings `json:"postings"`
@@ -19,23 +13,18 @@ func (t *Transaction) AppendPosting(p Posting) {
 	t.Postings = append(t.Postings, p)
 }
 
+// Reverse returns a transaction cancelling the postings of t.
+// Its reference is the reference of t prefixed by "revert_", if t has one.
 func (t *Transaction) Reverse() Transaction {
-	postings := t.Postings
+	postings := append(Postings{}, t.Postings...)
 	postings.Reverse()
 
-	return Transaction{
-		Postings:  postings,
-		Reference: "revert_" + t.Reference,
+	rt := Transaction{
+		Postings: postings,
+	}
+	if t.Reference != "" {
+		rt.Reference = "revert_" + t.Reference
 	}
-}
-
-func Hash(t1 *Transaction, t2 *Transaction) string {
-	b1, _ := json.Marshal(t1)
-	b2, _ := json.Marshal(t2)
-
-	h := sha256.New()
-	h.Write(b1)
-	h.Write(b2)
 
-	return fmt.Sprintf("%x", h.Sum(nil))
+	return rt
 }
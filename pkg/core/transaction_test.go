@@ -1,6 +1,12 @@
 package core
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"strings"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
@@ -33,15 +39,239 @@ func TestHash(t *testing.T) {
 
 	h1 := Hash(nil, &a)
 
-	if h1 != "3d60910b8f0aab20d17e3e8aa71ca9fe54634fe03466ec7ca49822bc4c5cac7f" {
+	if h1 != "sha256:9364af47cd940be6387a064de8ac636b28900db237ac994e74265ac8c49e12c7:44136fa355b3678a1146ad16f7e8649e94fb4fc21fe77e8310c060f61caaff8a" {
 		t.Fail()
 	}
 
 	a.Hash = h1
 	h2 := Hash(&a, &b)
 
-	if h2 != "b604e920f4f0d20fd2a2b09038ab9fc21d5761f05cdbd33148000a3f2ab7e65c" {
-		t.Fail()
+	if h2 != "sha256:7d846fc74320333f7cce273f4d1408a799a54e58a77a3f5bf2922a0797c432da:44136fa355b3678a1146ad16f7e8649e94fb4fc21fe77e8310c060f61caaff8a" {
+		t.Errorf("unexpected hash %s", h2)
+	}
+
+	b.Hash = h2
+	if !VerifyHash(SHA256Hasher, nil, &a) || !VerifyHash(SHA256Hasher, &a, &b) {
+		t.Error("hash chain not verified")
+	}
+	b.Postings[0].Amount = 101
+	if VerifyHash(SHA256Hasher, &a, &b) {
+		t.Error("tampered transaction verified")
+	}
+}
+
+// baselineHash is the hash function of the first versions, which recorded the hashes without algorithm
+func baselineHash(t1 *Transaction, t2 *Transaction) string {
+	b1, _ := json.Marshal(t1)
+	b2, _ := json.Marshal(t2)
+
+	h := sha256.New()
+	h.Write(b1)
+	h.Write(b2)
+
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+func TestLegacyHash(t *testing.T) {
+	// A chain hashed by the first versions, which hashed the transactions with the metadata they were committed with,
+	// while their predecessors were read back from the store, with {} as metadata when they had none
+	chain := []Transaction{
+		{
+			ID:        0,
+			Postings:  Postings{{Source: "world", Destination: "users:001", Amount: 100, Asset: "COIN"}},
+			Timestamp: "2021-01-01T00:00:00Z",
+		},
+		{
+			ID:        1,
+			Postings:  Postings{{Source: "users:001", Destination: "users:002", Amount: 10, Asset: "COIN"}},
+			Reference: "ref:1",
+			Timestamp: "2021-01-01T00:00:01Z",
+			Metadata:  Metadata{"foo": json.RawMessage(`{ "bar": "baz" }`), "a": json.RawMessage(`1`)},
+		},
+		{
+			ID:        2,
+			Postings:  Postings{{Source: "users:002", Destination: "users:003", Amount: 5, Asset: "COIN"}},
+			Timestamp: "2021-01-01T00:00:02Z",
+		},
+	}
+	var previous *Transaction
+	for i := range chain {
+		chain[i].Hash = baselineHash(previous, &chain[i])
+		if chain[i].Metadata == nil {
+			chain[i].Metadata = Metadata{}
+		}
+		previous = &chain[i]
+	}
+
+	previous = nil
+	for i := range chain {
+		tx := chain[i]
+		if !IsLegacyHash(tx.Hash) || HashAlgorithm(tx.Hash) != "sha256" {
+			t.Errorf("transaction %d: unexpected legacy hash %s", i, tx.Hash)
+		}
+		if !VerifyHash(DefaultHasher, previous, &tx) {
+			t.Errorf("transaction %d: legacy hash not verified", i)
+		}
+		previous = &chain[i]
+	}
+
+	tampered := chain[1]
+	tampered.Metadata = Metadata{"foo": json.RawMessage(`"qux"`)}
+	if VerifyHash(DefaultHasher, &chain[0], &tampered) {
+		t.Error("tampered metadata verified")
+	}
+	tampered = chain[1]
+	tampered.Timestamp = "2021-01-01T00:00:03Z"
+	if VerifyHash(DefaultHasher, &chain[0], &tampered) {
+		t.Error("tampered timestamp verified")
+	}
+}
+
+func TestHashCanonical(t *testing.T) {
+	tx := Transaction{
+		ID: 0,
+		Postings: []Posting{
+			{
+				Source:      "world",
+				Destination: "users:<001>",
+				Amount:      100,
+				Asset:       "COIN",
+			},
+		},
+		Reference: "ref\"1",
+		Timestamp: "2021-01-01T00:00:00Z",
+		Hash:      "foo",
+		Metadata:  Metadata{},
+	}
+	for i := 0; i < 100; i++ {
+		tx.Metadata[fmt.Sprintf("key-%d", i)] = json.RawMessage(fmt.Sprintf(`{"value":%d}`, i))
+	}
+
+	// The serialization must stay the one of the json encoding of the original struct
+	cp := tx
+	cp.Hash = ""
+	cp.Metadata = nil
+	expected, _ := json.Marshal(cp)
+	if !bytes.Equal(canonical(&tx, false, []byte("null")), expected) {
+		t.Errorf("unexpected serialization %s", canonical(&tx, false, []byte("null")))
+	}
+
+	// The legacy serialization is the json encoding of the struct, metadata included
+	cp.Metadata = tx.Metadata
+	expected, _ = json.Marshal(cp)
+	if !bytes.Equal(canonical(&tx, false, legacyMetadata(&tx)), expected) {
+		t.Errorf("unexpected legacy serialization %s", canonical(&tx, false, legacyMetadata(&tx)))
+	}
+
+	h := Hash(nil, &tx)
+	for i := 0; i < 100; i++ {
+		if Hash(nil, &tx) != h {
+			t.Fatal("hash is not stable")
+		}
+	}
+
+	// The metadata are hashed with their keys sorted, whatever the order they were inserted in
+	shuffled := tx
+	shuffled.Metadata = Metadata{}
+	for _, i := range rand.Perm(100) {
+		shuffled.Metadata[fmt.Sprintf("key-%d", i)] = json.RawMessage(fmt.Sprintf(`{ "value": %d }`, i))
+	}
+	if Hash(nil, &shuffled) != h {
+		t.Error("hash depends on the order of the metadata")
+	}
+
+	nested := tx
+	nested.Metadata = Metadata{"foo": json.RawMessage(`{"b":{"d":1,"c":[2,{"f":3,"e":4}]},"a":1.50}`)}
+	reordered := tx
+	reordered.Metadata = Metadata{"foo": json.RawMessage(`{"a":1.50,"b":{"c":[2,{"e":4,"f":3}],"d":1}}`)}
+	if Hash(nil, &nested) != Hash(nil, &reordered) {
+		t.Error("hash depends on the order of the keys of the metadata values")
+	}
+
+	changed := tx
+	changed.Metadata = Metadata{}
+	for k, v := range tx.Metadata {
+		changed.Metadata[k] = v
+	}
+	changed.Metadata["key-42"] = json.RawMessage(`{"value":43}`)
+	if Hash(nil, &changed) == h {
+		t.Error("hash doesn't depend on the values of the metadata")
+	}
+
+	empty := tx
+	empty.Metadata = nil
+	withEmpty := tx
+	withEmpty.Metadata = Metadata{}
+	if Hash(nil, &empty) == h || Hash(nil, &empty) != Hash(nil, &withEmpty) {
+		t.Error("unexpected hash of empty metadata")
+	}
+}
+
+func TestVerifyUpdatedMetadata(t *testing.T) {
+	tx := Transaction{
+		ID: 0,
+		Postings: []Posting{
+			{Source: "world", Destination: "users:001", Amount: 100, Asset: "COIN"},
+		},
+		Timestamp: "2021-01-01T00:00:00Z",
+		Metadata:  Metadata{"foo": json.RawMessage(`"bar"`)},
+	}
+	tx.Hash = Hash(nil, &tx)
+	if !VerifyHash(SHA256Hasher, nil, &tx) {
+		t.Fatal("hash not verified")
+	}
+
+	// The metadata updated since the commit don't verify, the chain does
+	updated := tx
+	updated.Metadata = Metadata{"foo": json.RawMessage(`"baz"`)}
+	if VerifyHash(SHA256Hasher, nil, &updated) || VerifyMetadata(SHA256Hasher, &updated) {
+		t.Error("updated metadata verified")
+	}
+	if !VerifyChainedHash(SHA256Hasher, nil, &updated) {
+		t.Error("chain of updated metadata not verified")
+	}
+
+	tampered := updated
+	tampered.Postings = []Posting{
+		{Source: "world", Destination: "users:001", Amount: 101, Asset: "COIN"},
+	}
+	if VerifyChainedHash(SHA256Hasher, nil, &tampered) {
+		t.Error("tampered transaction verified")
+	}
+	tampered = tx
+	tampered.Hash = tx.Hash + "0"
+	if VerifyChainedHash(SHA256Hasher, nil, &tampered) {
+		t.Error("tampered metadata digest verified")
+	}
+}
+
+func TestHashWith(t *testing.T) {
+	tx := Transaction{
+		ID: 0,
+		Postings: []Posting{
+			{
+				Source:      "world",
+				Destination: "users:001",
+				Amount:      100,
+				Asset:       "COIN",
+			},
+		},
+	}
+
+	h := HashWith(SHA256Hasher, nil, &tx)
+	if h != Hash(nil, &tx) || !strings.HasPrefix(h, "sha256:") || HashAlgorithm(h) != "sha256" || IsLegacyHash(h) {
+		t.Errorf("unexpected default hash %s", h)
+	}
+
+	h = HashWith(SHA512Hasher, nil, &tx)
+	if !strings.HasPrefix(h, "sha512:") || len(h) != len("sha512:")+128+1+128 || HashAlgorithm(h) != "sha512" {
+		t.Errorf("unexpected sha512 hash %s", h)
+	}
+
+	h1 := HashWith(NewHMACHasher([]byte("foo")), nil, &tx)
+	h2 := HashWith(NewHMACHasher([]byte("bar")), nil, &tx)
+	if h1 == h2 || HashAlgorithm(h1) != "hmac-sha256" {
+		t.Errorf("unexpected hmac hashes %s, %s", h1, h2)
 	}
 }
 
@@ -85,4 +315,13 @@ func TestReverseTransaction(t *testing.T) {
 	if diff := cmp.Diff(expected, tx.Reverse()); diff != "" {
 		t.Errorf("Reverse() mismatch (-want +got):\n%s", diff)
 	}
+
+	if tx.Postings[0].Source != "world" {
+		t.Errorf("Reverse() modified the postings of the reversed transaction")
+	}
+
+	tx.Reference = ""
+	if ref := tx.Reverse().Reference; ref != "" {
+		t.Errorf("Reverse() of a transaction without reference got reference %s", ref)
+	}
 }
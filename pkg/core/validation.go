@@ -0,0 +1,114 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrValidation is matched by the errors reporting an invalid input
+var ErrValidation = errors.New("validation error")
+
+// ValidationError reports an invalid field of a transaction of a batch
+type ValidationError struct {
+	// Transaction is the index of the transaction in the batch
+	Transaction int `json:"transaction"`
+	// Posting is the index of the posting in the transaction, or -1 if the field is not a posting field
+	Posting int    `json:"posting"`
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func (e ValidationError) Error() string {
+	if e.Posting < 0 {
+		return fmt.Sprintf("transaction %d: %s %s", e.Transaction, e.Field, e.Message)
+	}
+	return fmt.Sprintf("transaction %d, posting %d: %s %s", e.Transaction, e.Posting, e.Field, e.Message)
+}
+
+// ValidationErrors lists the validation errors of a batch, it matches ErrValidation
+type ValidationErrors []ValidationError
+
+func (e ValidationErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func (e ValidationErrors) Is(target error) bool {
+	return target == ErrValidation
+}
+
+// ValidationOptions relax the checks of ValidateTransactions
+type ValidationOptions struct {
+	// AllowNoop accepts the postings which source and destination are the same account.
+	// Such postings don't change any balance, they are most likely a mistake.
+	AllowNoop bool
+	// MaxPostings, if not zero, is the number of postings a transaction can have at most
+	MaxPostings int
+	// ConversionTolerance is the difference allowed between the amount of the destination posting of a conversion
+	// and the amount converted from its source posting at its rate, in units of the destination asset.
+	// The converted amount is rounded, a zero tolerance only allows the rounding.
+	ConversionTolerance int64
+}
+
+// ValidateTransactions checks the fields of the transactions of a batch, without looking at the ledger state,
+// along with the rates of their conversions, see Conversion.
+// It returns the ValidationErrors of all the invalid fields, or nil.
+func ValidateTransactions(ts []Transaction, opts ValidationOptions) error {
+	errs := ValidationErrors{}
+
+	for i, t := range ts {
+		invalid := func(posting int, field, msg string) {
+			errs = append(errs, ValidationError{
+				Transaction: i,
+				Posting:     posting,
+				Field:       field,
+				Message:     msg,
+			})
+		}
+
+		if len(t.Postings) == 0 {
+			invalid(-1, "postings", "must not be empty")
+		}
+		if opts.MaxPostings > 0 && len(t.Postings) > opts.MaxPostings {
+			invalid(-1, "postings", fmt.Sprintf("must not exceed %d postings", opts.MaxPostings))
+			// Each of the postings of an oversized transaction isn't worth reporting
+			continue
+		}
+
+		if t.Timestamp != "" {
+			if _, err := time.Parse(time.RFC3339, t.Timestamp); err != nil {
+				invalid(-1, "timestamp", "must be in RFC3339 format")
+			}
+		}
+
+		for j, p := range t.Postings {
+			if p.Source == "" {
+				invalid(j, "source", "must not be empty")
+			}
+			if p.Destination == "" {
+				invalid(j, "destination", "must not be empty")
+			}
+			if p.Source != "" && p.Source == p.Destination && !opts.AllowNoop {
+				invalid(j, "destination", "must differ from the source")
+			}
+			if p.Amount < 1 {
+				invalid(j, "amount", "must be positive")
+			}
+			if p.Asset == "" {
+				invalid(j, "asset", "must not be empty")
+			}
+		}
+
+		validateConversions(t, opts.ConversionTolerance, invalid)
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
@@ -0,0 +1,169 @@
+package core
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestValidateTransactions(t *testing.T) {
+	valid := Posting{
+		Source:      "world",
+		Destination: "users:001",
+		Amount:      100,
+		Asset:       "COIN",
+	}
+
+	err := ValidateTransactions([]Transaction{
+		{
+			Postings: Postings{valid},
+		},
+	}, ValidationOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error for a valid transaction: %s", err)
+	}
+
+	err = ValidateTransactions([]Transaction{
+		{
+			Postings: Postings{valid},
+		},
+		{
+			Timestamp: "yesterday",
+		},
+		{
+			Postings: Postings{
+				valid,
+				valid,
+				{
+					Source:      "users:001",
+					Destination: "users:001",
+					Amount:      0,
+				},
+			},
+		},
+	}, ValidationOptions{})
+	if !errors.Is(err, ErrValidation) {
+		t.Fatalf("expected a validation error, got %v", err)
+	}
+
+	errs := ValidationErrors{}
+	if !errors.As(err, &errs) {
+		t.Fatalf("expected validation errors, got %T", err)
+	}
+
+	expected := ValidationErrors{
+		{Transaction: 1, Posting: -1, Field: "postings", Message: "must not be empty"},
+		{Transaction: 1, Posting: -1, Field: "timestamp", Message: "must be in RFC3339 format"},
+		{Transaction: 2, Posting: 2, Field: "destination", Message: "must differ from the source"},
+		{Transaction: 2, Posting: 2, Field: "amount", Message: "must be positive"},
+		{Transaction: 2, Posting: 2, Field: "asset", Message: "must not be empty"},
+	}
+	if diff := cmp.Diff(expected, errs); diff != "" {
+		t.Errorf("ValidateTransactions() mismatch (-want +got):\n%s", diff)
+	}
+
+	if msg := errs[3].Error(); msg != "transaction 2, posting 2: amount must be positive" {
+		t.Errorf("unexpected error message %s", msg)
+	}
+}
+
+func TestValidateNoopPostings(t *testing.T) {
+	ts := []Transaction{
+		{
+			Postings: Postings{
+				{
+					Source:      "a",
+					Destination: "a",
+					Amount:      1,
+					Asset:       "X",
+				},
+			},
+		},
+	}
+
+	err := ValidateTransactions(ts, ValidationOptions{})
+	if !errors.Is(err, ErrValidation) {
+		t.Fatalf("expected a validation error, got %v", err)
+	}
+
+	err = ValidateTransactions(ts, ValidationOptions{AllowNoop: true})
+	if err != nil {
+		t.Fatalf("unexpected error with noop postings allowed: %s", err)
+	}
+}
+
+func TestValidateConversions(t *testing.T) {
+	conversion := func(rate string, amount int64, conversions ...Conversion) Transaction {
+		tx := Transaction{
+			Postings: Postings{
+				{Source: "users:001", Destination: "fx", Amount: 10000, Asset: "EUR/2"},
+				{Source: "fx", Destination: "users:001", Amount: amount, Asset: "USD/2"},
+			},
+			Metadata: Metadata{},
+		}
+		if conversions == nil {
+			conversions = []Conversion{{Source: 0, Destination: 1, Rate: rate}}
+		}
+		tx.Metadata.SetConversions(conversions)
+		return tx
+	}
+
+	err := ValidateTransactions([]Transaction{
+		conversion("1.0845", 10845),
+		conversion("1.08455", 10846),
+	}, ValidationOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error for valid conversions: %s", err)
+	}
+
+	err = ValidateTransactions([]Transaction{
+		conversion("1.0845", 10846),
+		conversion("-1", 10845),
+		conversion("1", 100, Conversion{Source: 0, Destination: 2, Rate: "1"}),
+		conversion("1", 100, Conversion{Source: 0, Destination: 0, Rate: "1"}),
+	}, ValidationOptions{})
+	errs := ValidationErrors{}
+	if !errors.As(err, &errs) {
+		t.Fatalf("expected validation errors, got %v", err)
+	}
+	expected := ValidationErrors{
+		{Transaction: 0, Posting: 1, Field: "amount", Message: "must be 10845, converted from posting 0 at the rate 1.0845 of conversion 0"},
+		{Transaction: 1, Posting: -1, Field: "metadata", Message: "scheme/conversions conversion 0: invalid rate '-1': expected a positive decimal number"},
+		{Transaction: 2, Posting: -1, Field: "metadata", Message: "scheme/conversions conversion 0 must link postings of the transaction"},
+		{Transaction: 3, Posting: 0, Field: "asset", Message: "must differ from the asset of posting 0, converted by conversion 0"},
+	}
+	if diff := cmp.Diff(expected, errs); diff != "" {
+		t.Errorf("ValidateTransactions() mismatch (-want +got):\n%s", diff)
+	}
+
+	// The tolerance allows the amounts computed with a rounded rate
+	err = ValidateTransactions([]Transaction{conversion("1.0845", 10846)}, ValidationOptions{ConversionTolerance: 1})
+	if err != nil {
+		t.Fatalf("unexpected error within the tolerance: %s", err)
+	}
+}
+
+func TestConvertAmount(t *testing.T) {
+	for _, tc := range []struct {
+		amount      int64
+		source      string
+		destination string
+		rate        string
+		expected    int64
+	}{
+		{amount: 10000, source: "EUR/2", destination: "USD/2", rate: "1.0845", expected: 10845},
+		{amount: 1, source: "BTC", destination: "USD/2", rate: "30000.125", expected: 3000013},
+		{amount: 3000013, source: "USD/2", destination: "BTC/8", rate: "0.0000333", expected: 99900433},
+		{amount: 150, source: "JPY", destination: "USD/2", rate: "0.00675", expected: 101},
+		{amount: 1, source: "USD/2", destination: "JPY", rate: "149", expected: 1},
+	} {
+		rate, err := ParseRate(tc.rate)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if converted := ConvertAmount(tc.amount, tc.source, tc.destination, rate); converted.Int64() != tc.expected {
+			t.Errorf("ConvertAmount(%d %s to %s at %s) = %s, expected %d", tc.amount, tc.source, tc.destination, tc.rate, converted, tc.expected)
+		}
+	}
+}
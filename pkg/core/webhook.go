@@ -0,0 +1,36 @@
+package core
+
+const (
+	// WebhookDelivered is the status of the deliveries acknowledged by the endpoint with a 2xx response
+	WebhookDelivered = "delivered"
+	// WebhookDead is the status of the deliveries given up after the maximum number of attempts
+	WebhookDead = "dead"
+)
+
+// Webhook is an endpoint notified of the transactions committed to a ledger
+type Webhook struct {
+	ID  string `json:"id"`
+	URL string `json:"url"`
+	// Secret signs the deliveries, it is only returned when the webhook is registered
+	Secret string `json:"secret,omitempty"`
+	// Accounts restricts the deliveries to the transactions with a posting from or to one of the accounts,
+	// given by address or pattern like users:*. All the transactions are delivered if empty.
+	Accounts []string `json:"accounts,omitempty"`
+	// Cursor is the id of the last transaction handled for the webhook, whether delivered or filtered out
+	Cursor    int64  `json:"cursor"`
+	Timestamp string `json:"timestamp"`
+}
+
+// WebhookDelivery is the outcome of the delivery of a transaction to a webhook
+type WebhookDelivery struct {
+	WebhookID     string `json:"webhook_id"`
+	TransactionID int64  `json:"transaction_id"`
+	// Status is WebhookDelivered or WebhookDead
+	Status   string `json:"status"`
+	Attempts int    `json:"attempts"`
+	// StatusCode is the http status of the last response, 0 if there was none
+	StatusCode int `json:"status_code,omitempty"`
+	// Error is the reason of the failure of the last attempt, empty if delivered
+	Error     string `json:"error,omitempty"`
+	Timestamp string `json:"timestamp"`
+}
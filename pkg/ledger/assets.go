@@ -0,0 +1,283 @@
+package ledger
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/numary/ledger/pkg/core"
+	"github.com/numary/ledger/pkg/ledger/query"
+	"github.com/numary/ledger/pkg/storage"
+	"github.com/pkg/errors"
+)
+
+const (
+	// targetTypeAsset is the metadata target type of the registered assets, keyed by base code
+	targetTypeAsset = "asset"
+	assetScaleKey   = "scale"
+	maxAssetScale   = 18
+)
+
+// RegisterAsset adds an asset to the registry of the ledger, checked by the commits made with
+// CommitOptions.StrictAssets. The code is the base code, like "USD", the postings then using
+// "USD/2" for a scale of 2. Registering an asset again with the same scale does nothing, while
+// a base code can't be registered with another scale, as its balances would be split.
+func (l *Ledger) RegisterAsset(ctx context.Context, code string, scale int) error {
+	ctx = storage.WithConsistentRead(ctx)
+	if scale < 0 || scale > maxAssetScale {
+		return newValidationError("invalid scale %d for asset %s: expected between 0 and %d", scale, code, maxAssetScale)
+	}
+	if base, _ := core.AssetScale(code); base != code || !core.AssetIsValid(code) {
+		return newValidationError("invalid asset code '%s': expected uppercase letters and digits, without scale", code)
+	}
+
+	unlock, err := l.locker.Lock(l.name)
+	if err != nil {
+		return errors.Wrap(err, "unable to acquire lock")
+	}
+	defer unlock()
+
+	registered, ok, err := l.assetScale(ctx, code)
+	if err != nil {
+		return err
+	}
+	if ok {
+		if registered != scale {
+			return newValidationError("asset %s is already registered with scale %d", code, registered)
+		}
+		return nil
+	}
+
+	lastMetaID, err := l.store.LastMetaID(ctx)
+	if err != nil {
+		return err
+	}
+
+	return l.store.SaveMetaBatch(ctx, []storage.MetaEntry{{
+		ID:         lastMetaID + 1,
+		Timestamp:  time.Now().Format(time.RFC3339),
+		TargetType: targetTypeAsset,
+		TargetID:   code,
+		Key:        assetScaleKey,
+		Value:      fmt.Sprint(scale),
+	}})
+}
+
+// assetScale returns the scale an asset base code is registered with, and whether it is registered
+func (l *Ledger) assetScale(ctx context.Context, code string) (int, bool, error) {
+	meta, err := l.store.GetMeta(ctx, targetTypeAsset, code)
+	if err != nil {
+		return 0, false, err
+	}
+
+	value, ok := meta[assetScaleKey]
+	if !ok {
+		return 0, false, nil
+	}
+
+	var scale int
+	err = json.Unmarshal(value, &scale)
+	if err != nil {
+		return 0, false, errors.Wrapf(err, "reading scale of asset %s", code)
+	}
+
+	return scale, true, nil
+}
+
+// GetAssets returns the distinct assets of the ledger, sorted: the ones of the postings along with
+// the registered ones, with their scale, which may not have been used yet
+func (l *Ledger) GetAssets(ctx context.Context) ([]string, error) {
+	assets, err := l.store.FindAssets(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	registered, err := l.store.FindMetaTargets(ctx, targetTypeAsset)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]struct{}, len(assets))
+	for _, asset := range assets {
+		seen[asset] = struct{}{}
+	}
+	for _, code := range registered {
+		scale, ok, err := l.assetScale(ctx, code)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		asset := core.AssetCode(code, scale)
+		if _, ok := seen[asset]; !ok {
+			seen[asset] = struct{}{}
+			assets = append(assets, asset)
+		}
+	}
+	sort.Strings(assets)
+
+	return assets, nil
+}
+
+// checkAssets returns a TransactionError for the first posting using an asset which isn't registered,
+// or which scale differs from the registered one
+func (l *Ledger) checkAssets(ctx context.Context, ts []core.Transaction) error {
+	registered := map[string]bool{}
+	for i := range ts {
+		for _, p := range ts[i].Postings {
+			if _, ok := registered[p.Asset]; !ok {
+				base, scale := core.AssetScale(p.Asset)
+
+				s, ok, err := l.assetScale(ctx, base)
+				if err != nil {
+					return err
+				}
+				registered[p.Asset] = ok && s == scale && core.AssetCode(base, scale) == p.Asset
+			}
+			if !registered[p.Asset] {
+				return &TransactionError{
+					Index: i,
+					Err:   fmt.Errorf("%w: %s", ErrUnknownAsset, p.Asset),
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// checkScales returns a TransactionError for the first posting using an asset with a scale differing from the one
+// of the postings of the ledger. The ledgers committed by the older versions may use several scales of an asset,
+// which all remain accepted. Only the assets of the base codes of the transactions are read.
+func (l *Ledger) checkScales(ctx context.Context, ts []core.Transaction) error {
+	codes := make([]string, 0)
+	seen := map[string]struct{}{}
+	for i := range ts {
+		for _, p := range ts[i].Postings {
+			base, _ := core.AssetScale(p.Asset)
+			if _, ok := seen[base]; !ok {
+				seen[base] = struct{}{}
+				codes = append(codes, base)
+			}
+		}
+	}
+
+	assets, err := l.store.FindAssetsOf(ctx, codes)
+	if err != nil {
+		return err
+	}
+
+	used := map[string]map[int]bool{}
+	for _, asset := range assets {
+		base, scale := core.AssetScale(asset)
+		if used[base] == nil {
+			used[base] = map[int]bool{}
+		}
+		used[base][scale] = true
+	}
+
+	for i := range ts {
+		for _, p := range ts[i].Postings {
+			base, scale := core.AssetScale(p.Asset)
+			if used[base] != nil && !used[base][scale] {
+				return &TransactionError{
+					Index: i,
+					Err:   newValidationError("asset.scale.inconsistent.%s", base),
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// normalizeAssets returns a copy of the transactions with the asset codes of their postings uppercased
+func normalizeAssets(ts []core.Transaction) []core.Transaction {
+	normalized := make([]core.Transaction, len(ts))
+	for i, t := range ts {
+		postings := make(core.Postings, len(t.Postings))
+		for j, p := range t.Postings {
+			p.Asset = strings.ToUpper(p.Asset)
+			postings[j] = p
+		}
+		t.Postings = postings
+		normalized[i] = t
+	}
+	return normalized
+}
+
+// ConsolidateAssets moves the balances held in an asset which code isn't uppercase, like "gem",
+// to the uppercase asset, "GEM", by committing transactions sending them back to the world account
+// and issuing them again in the uppercase asset. History is not rewritten: the transactions
+// committed before are kept as is, and the hash chain stays valid. Running it again does nothing
+// once the balances are consolidated. Negative balances, out of the world account, can't be moved
+// and are left as is. It returns the committed transactions.
+func (l *Ledger) ConsolidateAssets(ctx context.Context) ([]core.Transaction, error) {
+	ctx = storage.WithConsistentRead(ctx)
+	committed := make([]core.Transaction, 0)
+
+	q := query.New()
+	q.Limit = streamPageSize
+
+	for {
+		c, err := l.store.FindBalances(ctx, q)
+		if err != nil {
+			return committed, err
+		}
+
+		page := c.Data.([]core.Account)
+		postings := make(core.Postings, 0)
+		for _, account := range page {
+			if account.Address == "world" {
+				continue
+			}
+
+			assets := make([]string, 0)
+			for asset, amount := range account.Balances {
+				if amount > 0 && asset != strings.ToUpper(asset) {
+					assets = append(assets, asset)
+				}
+			}
+			sort.Strings(assets)
+
+			for _, asset := range assets {
+				amount := account.Balances[asset]
+				postings = append(postings, core.Posting{
+					Source:      account.Address,
+					Destination: "world",
+					Amount:      amount,
+					Asset:       asset,
+				}, core.Posting{
+					Source:      "world",
+					Destination: account.Address,
+					Amount:      amount,
+					Asset:       strings.ToUpper(asset),
+				})
+			}
+		}
+
+		if len(postings) > 0 {
+			ts, err := l.CommitWithOptions(ctx, []core.Transaction{{
+				Postings: postings,
+				Metadata: core.Metadata{
+					"consolidated_assets": json.RawMessage("true"),
+				},
+			}}, CommitOptions{
+				keepAssets: true,
+			})
+			if err != nil {
+				return committed, err
+			}
+			committed = append(committed, ts...)
+		}
+
+		if !c.HasMore || len(page) == 0 {
+			return committed, nil
+		}
+		q.After = page[len(page)-1].Address
+	}
+}
@@ -0,0 +1,192 @@
+package ledger
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/numary/ledger/pkg/core"
+	"github.com/numary/ledger/pkg/ledger/query"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegisterAsset(t *testing.T) {
+	with(func(l *Ledger) {
+		err := l.RegisterAsset(context.Background(), "REGCOIN", 0)
+		assert.NoError(t, err)
+
+		err = l.RegisterAsset(context.Background(), "REGUSD", 2)
+		assert.NoError(t, err)
+
+		// Registering again with the same scale does nothing
+		err = l.RegisterAsset(context.Background(), "REGUSD", 2)
+		assert.NoError(t, err)
+
+		err = l.RegisterAsset(context.Background(), "REGUSD", 3)
+		assert.True(t, errors.Is(err, ErrValidation), err)
+
+		for _, code := range []string{"", "REGcoin", "REGUSD/2", "1REG"} {
+			err = l.RegisterAsset(context.Background(), code, 0)
+			assert.True(t, errors.Is(err, ErrValidation), code)
+		}
+		err = l.RegisterAsset(context.Background(), "REGEUR", -1)
+		assert.True(t, errors.Is(err, ErrValidation), err)
+
+		posting := func(asset string) []core.Transaction {
+			return []core.Transaction{{
+				Postings: []core.Posting{
+					{Source: "world", Destination: "users:assets", Amount: 100, Asset: asset},
+				},
+			}}
+		}
+		strict := CommitOptions{
+			StrictAssets: true,
+		}
+
+		for _, asset := range []string{"REGCOIN", "REGUSD/2"} {
+			_, err = l.CommitWithOptions(context.Background(), posting(asset), strict)
+			assert.NoError(t, err, asset)
+		}
+
+		for _, asset := range []string{"REGCOIm", "REGUSD", "REGUSD/3", "REGUSD/02", "REGCOIN/2"} {
+			_, err = l.CommitWithOptions(context.Background(), posting(asset), strict)
+			assert.True(t, errors.Is(err, ErrUnknownAsset), asset)
+			assert.True(t, errors.Is(err, ErrValidation), asset)
+
+			txErr := &TransactionError{}
+			if assert.True(t, errors.As(err, &txErr), asset) {
+				assert.Equal(t, 0, txErr.Index)
+			}
+		}
+
+		// Without strict mode, any asset is accepted
+		_, err = l.Commit(context.Background(), posting("REGCOIm"))
+		assert.NoError(t, err)
+
+		// Registered assets can't be overwritten through the metadata
+		err = l.SaveMeta(context.Background(), targetTypeAsset, "REGCOIN", core.Metadata{
+			assetScaleKey: []byte("2"),
+		})
+		assert.Error(t, err)
+	})
+}
+
+func TestGetAssets(t *testing.T) {
+	with(func(*Ledger) {
+		l := newEmptyLedger(t)
+
+		assets, err := l.GetAssets(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, []string{}, assets)
+
+		assert.NoError(t, l.RegisterAsset(context.Background(), "USD", 2))
+		assert.NoError(t, l.RegisterAsset(context.Background(), "EUR", 0))
+		_, err = l.Commit(context.Background(), []core.Transaction{{
+			Postings: []core.Posting{
+				{Source: "world", Destination: "users:001", Amount: 100, Asset: "USD/2"},
+				{Source: "world", Destination: "users:001", Amount: 100, Asset: "COIN"},
+			},
+		}})
+		assert.NoError(t, err)
+
+		// The registered assets are merged with the ones of the postings
+		assets, err = l.GetAssets(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"COIN", "EUR", "USD/2"}, assets)
+	})
+}
+
+func TestAssetNormalization(t *testing.T) {
+	with(func(*Ledger) {
+		l := newEmptyLedger(t)
+
+		_, err := l.Commit(context.Background(), []core.Transaction{{
+			Postings: []core.Posting{
+				{Source: "world", Destination: "users:001", Amount: 100, Asset: "gem"},
+			},
+		}})
+		assert.NoError(t, err)
+
+		WithAssetNormalization(true)(l)
+
+		postings := []core.Posting{
+			{Source: "world", Destination: "users:001", Amount: 10, Asset: "Gem"},
+			{Source: "users:001", Destination: "users:002", Amount: 5, Asset: "gem/2"},
+		}
+		_, err = l.Commit(context.Background(), []core.Transaction{{
+			Postings: postings,
+		}})
+		assert.Error(t, err, "users:001 has no GEM/2")
+
+		postings[1].Asset = "gem"
+		ts, err := l.Commit(context.Background(), []core.Transaction{{
+			Postings: postings,
+		}})
+		assert.NoError(t, err)
+		assert.Equal(t, "GEM", ts[0].Postings[0].Asset)
+		assert.Equal(t, "GEM", ts[0].Postings[1].Asset)
+		// The postings of the caller are left as is
+		assert.Equal(t, "Gem", postings[0].Asset)
+
+		// The transactions committed before are not rewritten
+		account, err := l.GetAccount(context.Background(), "users:001")
+		assert.NoError(t, err)
+		assert.EqualValues(t, map[string]int64{"gem": 100, "GEM": 5}, account.Balances)
+
+		balance, err := l.GetAccountBalance(context.Background(), "users:001", "gEm")
+		assert.NoError(t, err)
+		assert.EqualValues(t, 5, balance)
+
+		c, err := l.FindTransactions(context.Background(), query.Asset("gem"))
+		assert.NoError(t, err)
+		assert.Len(t, c.Data, 1)
+	})
+}
+
+func TestConsolidateAssets(t *testing.T) {
+	with(func(*Ledger) {
+		l := newEmptyLedger(t)
+
+		_, err := l.Commit(context.Background(), []core.Transaction{{
+			Postings: []core.Posting{
+				{Source: "world", Destination: "users:001", Amount: 100, Asset: "gem"},
+				{Source: "world", Destination: "users:001", Amount: 10, Asset: "Gem"},
+				{Source: "world", Destination: "users:002", Amount: 20, Asset: "GEM"},
+				{Source: "world", Destination: "users:002", Amount: 3, Asset: "usd/2"},
+				{Source: "users:002", Destination: "users:003", Amount: 3, Asset: "usd/2"},
+			},
+		}})
+		assert.NoError(t, err)
+		WithAssetNormalization(true)(l)
+
+		ts, err := l.ConsolidateAssets(context.Background())
+		assert.NoError(t, err)
+		if assert.Len(t, ts, 1) {
+			assert.Len(t, ts[0].Postings, 6)
+		}
+
+		balances, err := l.AggregateBalances(context.Background())
+		assert.NoError(t, err)
+		assert.EqualValues(t, map[string]int64{"gem": 0, "Gem": 0, "GEM": 0, "usd/2": 0, "USD/2": 0}, balances)
+
+		for address, expected := range map[string]map[string]int64{
+			"users:001": {"gem": 0, "Gem": 0, "GEM": 110},
+			"users:002": {"GEM": 20, "usd/2": 0},
+			"users:003": {"usd/2": 0, "USD/2": 3},
+			"world":     {"gem": 0, "Gem": 0, "GEM": -130, "usd/2": 0, "USD/2": -3},
+		} {
+			account, err := l.GetAccount(context.Background(), address)
+			assert.NoError(t, err)
+			assert.EqualValues(t, expected, account.Balances, address)
+		}
+
+		// Consolidating again does nothing
+		ts, err = l.ConsolidateAssets(context.Background())
+		assert.NoError(t, err)
+		assert.Len(t, ts, 0)
+
+		ok, _, err := l.VerifyHashChain(context.Background())
+		assert.NoError(t, err)
+		assert.True(t, ok)
+	})
+}
@@ -0,0 +1,137 @@
+package ledger
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+
+	"github.com/numary/ledger/pkg/core"
+	"github.com/numary/ledger/pkg/ledger/query"
+)
+
+type BalancesCSVOptions struct {
+	// Long writes a row per account and asset, with the address, asset and amount columns,
+	// instead of a row per account with a column per asset
+	Long bool
+	// OmitZero skips the accounts whose balances are all zero, and in long format the zero balances
+	OmitZero bool
+}
+
+// ExportBalancesCSV writes the balances of the accounts matching the query to w as CSV, with a header row.
+// Accounts are ordered as FindAccounts and assets by code, so that the exports of a same ledger can be diffed.
+// Balances are read page by page from the store: memory stays bounded whatever the number of accounts,
+// but the transactions committed while exporting may be reflected in some pages only.
+func (l *Ledger) ExportBalancesCSV(ctx context.Context, w io.Writer, q query.Query, opts BalancesCSVOptions) error {
+	var assets []string
+	if !opts.Long {
+		sums, err := l.store.SumBalances(ctx, q)
+		if err != nil {
+			return err
+		}
+		for asset := range sums {
+			assets = append(assets, asset)
+		}
+		sort.Strings(assets)
+	}
+
+	cw := csv.NewWriter(w)
+	if opts.Long {
+		err := cw.Write([]string{"address", "asset", "amount"})
+		if err != nil {
+			return err
+		}
+	} else {
+		err := cw.Write(append([]string{"address"}, assets...))
+		if err != nil {
+			return err
+		}
+	}
+
+	q.Limit = streamPageSize
+	q.Before = ""
+
+	for {
+		c, err := l.store.FindBalances(ctx, q)
+		if err != nil {
+			return err
+		}
+
+		page := c.Data.([]core.Account)
+		for _, account := range page {
+			if opts.OmitZero && zeroBalances(account.Balances) {
+				continue
+			}
+
+			if opts.Long {
+				err = writeLongBalances(cw, account, opts.OmitZero)
+			} else {
+				err = writeWideBalances(cw, account, assets)
+			}
+			if err != nil {
+				return err
+			}
+		}
+
+		cw.Flush()
+		if err := cw.Error(); err != nil {
+			return err
+		}
+
+		if !c.HasMore || len(page) == 0 {
+			return nil
+		}
+		q.After = page[len(page)-1].Address
+	}
+}
+
+func writeLongBalances(cw *csv.Writer, account core.Account, omitZero bool) error {
+	assets := make([]string, 0, len(account.Balances))
+	for asset := range account.Balances {
+		assets = append(assets, asset)
+	}
+	sort.Strings(assets)
+
+	for _, asset := range assets {
+		amount := account.Balances[asset]
+		if omitZero && amount == 0 {
+			continue
+		}
+
+		err := cw.Write([]string{account.Address, asset, strconv.FormatInt(amount, 10)})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func writeWideBalances(cw *csv.Writer, account core.Account, assets []string) error {
+	// The columns are known before reading the accounts: a new asset can only come from a concurrent commit
+	for asset := range account.Balances {
+		i := sort.SearchStrings(assets, asset)
+		if i == len(assets) || assets[i] != asset {
+			return fmt.Errorf("asset %s of account %s appeared during the export", asset, account.Address)
+		}
+	}
+
+	row := make([]string, 0, len(assets)+1)
+	row = append(row, account.Address)
+	for _, asset := range assets {
+		row = append(row, strconv.FormatInt(account.Balances[asset], 10))
+	}
+
+	return cw.Write(row)
+}
+
+func zeroBalances(balances map[string]int64) bool {
+	for _, amount := range balances {
+		if amount != 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,110 @@
+package ledger
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"testing"
+
+	"github.com/numary/ledger/pkg/core"
+	"github.com/numary/ledger/pkg/ledger/query"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExportBalancesCSV(t *testing.T) {
+	with(func(*Ledger) {
+		// A ledger of its own, so that the accounts of the other tests are not exported
+		l := newEmptyLedger(t)
+
+		_, err := l.Commit(context.Background(), []core.Transaction{{
+			Postings: []core.Posting{
+				{Source: "world", Destination: "users:001", Amount: 100, Asset: "USD"},
+				{Source: "world", Destination: "users:002", Amount: 5, Asset: "EUR"},
+				{Source: "users:001", Destination: "bank", Amount: 100, Asset: "USD"},
+			},
+		}})
+		assert.NoError(t, err)
+
+		buf := bytes.Buffer{}
+		err = l.ExportBalancesCSV(context.Background(), &buf, query.New(), BalancesCSVOptions{})
+		assert.NoError(t, err)
+		assert.Equal(t, `address,EUR,USD
+world,-5,-100
+users:002,5,0
+users:001,0,0
+bank,0,100
+`, buf.String())
+
+		buf.Reset()
+		q := query.New()
+		q.Modify(query.Address("users:"))
+		err = l.ExportBalancesCSV(context.Background(), &buf, q, BalancesCSVOptions{
+			Long: true,
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, `address,asset,amount
+users:002,EUR,5
+users:001,USD,0
+`, buf.String())
+
+		buf.Reset()
+		err = l.ExportBalancesCSV(context.Background(), &buf, query.New(), BalancesCSVOptions{
+			Long:     true,
+			OmitZero: true,
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, `address,asset,amount
+world,EUR,-5
+world,USD,-100
+users:002,EUR,5
+bank,USD,100
+`, buf.String())
+
+		buf.Reset()
+		err = l.ExportBalancesCSV(context.Background(), &buf, query.New(), BalancesCSVOptions{
+			OmitZero: true,
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, `address,EUR,USD
+world,-5,-100
+users:002,5,0
+bank,0,100
+`, buf.String())
+	})
+}
+
+func TestExportBalancesCSVPages(t *testing.T) {
+	with(func(*Ledger) {
+		// A ledger of its own, so that the accounts of the other tests are not exported
+		l := newEmptyLedger(t)
+
+		postings := make([]core.Posting, 0)
+		for i := 0; i < 2*streamPageSize+10; i++ {
+			postings = append(postings, core.Posting{
+				Source:      "world",
+				Destination: fmt.Sprintf("users:%04d", i),
+				Amount:      int64(i + 1),
+				Asset:       "COIN",
+			})
+		}
+		_, err := l.Commit(context.Background(), []core.Transaction{{
+			Postings: postings,
+		}})
+		assert.NoError(t, err)
+
+		buf := bytes.Buffer{}
+		q := query.New()
+		q.Modify(query.Address("users:"))
+		err = l.ExportBalancesCSV(context.Background(), &buf, q, BalancesCSVOptions{})
+		assert.NoError(t, err)
+
+		records, err := csv.NewReader(&buf).ReadAll()
+		assert.NoError(t, err)
+		assert.Len(t, records, len(postings)+1)
+		for i, record := range records[1:] {
+			p := postings[len(postings)-1-i]
+			assert.Equal(t, []string{p.Destination, fmt.Sprint(p.Amount)}, record)
+		}
+	})
+}
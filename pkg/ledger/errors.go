@@ -0,0 +1,132 @@
+package ledger
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/numary/ledger/pkg/core"
+	"github.com/numary/ledger/pkg/storage"
+)
+
+// The errors returned by the ledger can be matched with errors.Is against the following sentinels,
+// to tell the errors caused by the input from the errors of the store.
+var (
+	// ErrInsufficientFunds is matched by the InsufficientFundsError returned when a transaction
+	// would overdraw an account
+	ErrInsufficientFunds = errors.New("insufficient funds")
+	// ErrDuplicateReference is matched when committing a transaction with a reference already used within
+	// the reference scope of the ledger, see WithReferenceScope
+	ErrDuplicateReference = storage.ErrDuplicateReference
+	// ErrValidation is matched when the input is invalid, like a transaction without postings.
+	// The invalid fields of the transactions committed are reported with core.ValidationErrors.
+	ErrValidation = core.ErrValidation
+	// ErrNotFound is matched when a transaction or an account doesn't exist
+	ErrNotFound = errors.New("not found")
+)
+
+var (
+	ErrAccountNotFound     = fmt.Errorf("account %w", ErrNotFound)
+	ErrTransactionNotFound = fmt.Errorf("transaction %w", ErrNotFound)
+	ErrScriptNotFound      = fmt.Errorf("script %w", ErrNotFound)
+	ErrWebhookNotFound     = fmt.Errorf("webhook %w", ErrNotFound)
+	ErrHoldNotFound        = fmt.Errorf("hold %w", ErrNotFound)
+	// ErrMetadataSchemaNotFound is returned by GetMetadataSchema when no schema is registered for the target type
+	ErrMetadataSchemaNotFound = fmt.Errorf("metadata schema %w", ErrNotFound)
+	// ErrHoldClosed is returned when capturing or releasing a hold which has already been captured or released
+	ErrHoldClosed = errors.New("hold already captured or released")
+	// ErrAlreadyReverted is returned when reverting a transaction which has already been reverted
+	ErrAlreadyReverted = errors.New("transaction already reverted")
+	// ErrRevertOfRevert is returned when reverting a transaction which reverts another transaction
+	ErrRevertOfRevert = newValidationError("transaction is a revert and cannot be reverted")
+	// ErrRevertNotConfirmed is returned by RevertMatching when reverting without confirmation, outside of a dry run
+	ErrRevertNotConfirmed = newValidationError("revert of the matching transactions not confirmed")
+	// ErrRevertExceeded is returned by PartialRevert when the postings don't reverse postings of the transaction,
+	// or when the partial reverts of the transaction would revert more than its amounts
+	ErrRevertExceeded = newValidationError("reverted amount exceeds the transaction")
+	// ErrUnknownAsset is returned by the commits made with CommitOptions.StrictAssets
+	// when a posting uses an asset which isn't registered
+	ErrUnknownAsset = newValidationError("asset not registered")
+	// ErrScriptVariable is returned when executing a script with a missing, undeclared
+	// or mistyped variable
+	ErrScriptVariable = newValidationError("invalid script variable")
+	// ErrLedgerNotEmpty is returned by Import when the ledger already has transactions
+	ErrLedgerNotEmpty = errors.New("ledger is not empty")
+	// ErrLedgerAlreadyExists is returned by Resolver.CreateLedger when the ledger has already been opened or created
+	ErrLedgerAlreadyExists = errors.New("ledger already exists")
+	// ErrBrokenChain is returned by Import when the hash of a transaction doesn't match the chain
+	ErrBrokenChain = newValidationError("hash chain broken")
+	// ErrConflict is returned by Commit when the transactions kept conflicting with concurrent commits,
+	// made by other processes sharing the store, until the retries were exhausted
+	ErrConflict = storage.ErrConflict
+	// ErrBatchTooLarge is returned by Commit when the batch has more transactions than allowed by the limits of the ledger
+	ErrBatchTooLarge = newValidationError("batch too large")
+	// ErrMetadataVersionMismatch is returned by SaveMetaIfVersion when the metadata have been updated since the given version
+	ErrMetadataVersionMismatch = errors.New("metadata version mismatch")
+)
+
+// InsufficientFundsError is returned by Commit when the balance of an account is lower
+// than the amount the batch debits from it. It matches ErrInsufficientFunds.
+type InsufficientFundsError struct {
+	Account string
+	Asset   string
+	// Needed is the amount debited by the batch
+	Needed int64
+	// Available is the balance of the account before the batch
+	Available int64
+	// Overdraft is how far the account is allowed to go negative in the asset, see Ledger.SetOverdraft
+	Overdraft int64
+	// Held is the part of the balance reserved by the active holds of the account, see Ledger.Hold
+	Held int64
+}
+
+func (e *InsufficientFundsError) Error() string {
+	msg := fmt.Sprintf("balance.insufficient.%s: account %s needs %d, has %d", e.Asset, e.Account, e.Needed, e.Available)
+	if e.Held > 0 {
+		msg += fmt.Sprintf(" of which %d held", e.Held)
+	}
+	if e.Overdraft > 0 {
+		msg += fmt.Sprintf(" with an overdraft limit of %d", e.Overdraft)
+	}
+	return msg
+}
+
+func (e *InsufficientFundsError) Is(target error) bool {
+	return target == ErrInsufficientFunds
+}
+
+// validationError is an error caused by an invalid input, it matches ErrValidation
+type validationError struct {
+	msg string
+}
+
+func newValidationError(format string, args ...interface{}) error {
+	return &validationError{
+		msg: fmt.Sprintf(format, args...),
+	}
+}
+
+func (e *validationError) Error() string {
+	return e.msg
+}
+
+func (e *validationError) Is(target error) bool {
+	return target == ErrValidation
+}
+
+// TransactionError is returned by Commit when a transaction of the batch is rejected,
+// in which case none of the transactions of the batch are committed.
+// An insufficient balance is reported on the first transaction debiting the account beyond its funds,
+// the previous transactions of the batch being applied.
+type TransactionError struct {
+	// Index of the transaction in the batch
+	Index int
+	Err   error
+}
+
+func (e *TransactionError) Error() string {
+	return fmt.Sprintf("transaction %d: %s", e.Index, e.Err)
+}
+
+func (e *TransactionError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,105 @@
+package ledger
+
+import (
+	"sync"
+
+	"github.com/numary/ledger/pkg/core"
+)
+
+// EventBus dispatches the events of the ledgers to their subscribers.
+// Each subscriber receives the events in order from its own goroutine, the events being queued
+// so that a slow subscriber never blocks the commits.
+type EventBus struct {
+	lock        sync.Mutex
+	subscribers map[*subscriber]struct{}
+}
+
+func NewEventBus() *EventBus {
+	return &EventBus{
+		subscribers: map[*subscriber]struct{}{},
+	}
+}
+
+// Subscribe registers a handler called with each batch of committed transactions.
+// The events are shared between the subscribers and must not be modified.
+// It returns a function unsubscribing the handler, the events not delivered yet are dropped.
+func (b *EventBus) Subscribe(handler func(core.CommittedTransactions)) func() {
+	s := &subscriber{
+		handler: handler,
+		ready:   make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+	go s.run()
+
+	b.lock.Lock()
+	b.subscribers[s] = struct{}{}
+	b.lock.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			b.lock.Lock()
+			delete(b.subscribers, s)
+			b.lock.Unlock()
+			close(s.done)
+		})
+	}
+}
+
+func (b *EventBus) publish(e core.CommittedTransactions) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	for s := range b.subscribers {
+		s.push(e)
+	}
+}
+
+type subscriber struct {
+	handler func(core.CommittedTransactions)
+	lock    sync.Mutex
+	queue   []core.CommittedTransactions
+	// ready is signaled when events are queued
+	ready chan struct{}
+	done  chan struct{}
+}
+
+func (s *subscriber) push(e core.CommittedTransactions) {
+	s.lock.Lock()
+	s.queue = append(s.queue, e)
+	s.lock.Unlock()
+
+	select {
+	case s.ready <- struct{}{}:
+	default:
+	}
+}
+
+func (s *subscriber) run() {
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-s.ready:
+		}
+
+		for {
+			s.lock.Lock()
+			if len(s.queue) == 0 {
+				s.lock.Unlock()
+				break
+			}
+			e := s.queue[0]
+			s.queue[0] = core.CommittedTransactions{}
+			s.queue = s.queue[1:]
+			s.lock.Unlock()
+
+			select {
+			case <-s.done:
+				return
+			default:
+			}
+			s.handler(e)
+		}
+	}
+}
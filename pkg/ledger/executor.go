@@ -4,51 +4,122 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"strings"
 
 	"github.com/numary/ledger/pkg/core"
+	"github.com/numary/ledger/pkg/storage"
 	machine "github.com/numary/machine/core"
 	"github.com/numary/machine/script/compiler"
 	"github.com/numary/machine/vm"
+	"github.com/numary/machine/vm/program"
 )
 
-func (l *Ledger) Execute(ctx context.Context, script core.Script) error {
+type ScriptPreview struct {
+	Postings core.Postings               `json:"postings"`
+	Deltas   map[string]map[string]int64 `json:"deltas"`
+	// Vars holds the values of the variables of the script, passed or read from the metadata
+	Vars map[string]interface{} `json:"vars"`
+}
+
+type ScriptResult struct {
+	// Transactions are the transactions committed, as returned by Commit
+	Transactions []core.Transaction `json:"transactions"`
+	// Vars holds the values of the variables of the script, passed or read from the metadata
+	Vars map[string]interface{} `json:"vars"`
+}
+
+// Execute runs the script against the current balances and commits the transaction it generates
+func (l *Ledger) Execute(ctx context.Context, script core.Script) (*ScriptResult, error) {
+	ctx = storage.WithConsistentRead(ctx)
+	t, vars, err := l.run(ctx, script)
+	if err != nil {
+		return nil, err
+	}
+
+	ts, err := l.Commit(ctx, []core.Transaction{*t})
+	if err != nil {
+		return nil, err
+	}
+
+	return &ScriptResult{
+		Transactions: ts,
+		Vars:         vars,
+	}, nil
+}
+
+// ExecutePreview runs the script against the current balances and returns the generated postings
+// along with the resulting balance deltas, without committing anything.
+// Conditions that would make the commit fail, like insufficient funds, are returned as errors.
+func (l *Ledger) ExecutePreview(ctx context.Context, script core.Script) (*ScriptPreview, error) {
+	ctx = storage.WithConsistentRead(ctx)
+	t, vars, err := l.run(ctx, script)
+	if err != nil {
+		return nil, err
+	}
+
+	deltas, err := l.CommitPreview(ctx, []core.Transaction{*t})
+	if err != nil {
+		return nil, err
+	}
+
+	return &ScriptPreview{
+		Postings: t.Postings,
+		Deltas:   deltas,
+		Vars:     vars,
+	}, nil
+}
+
+// compile compiles the script, or reads its program from the script cache of the ledger if any
+func (l *Ledger) compile(source string) (*program.Program, error) {
+	if l.scriptCache == nil {
+		return compiler.Compile(source)
+	}
+	return l.scriptCache.compile(source)
+}
+
+// run executes the script and returns the transaction it generates, along with the values of its variables
+func (l *Ledger) run(ctx context.Context, script core.Script) (*core.Transaction, map[string]interface{}, error) {
 	if script.Plain == "" {
-		return errors.New("no script to execute")
+		return nil, nil, errors.New("no script to execute")
 	}
 
-	p, err := compiler.Compile(script.Plain)
+	p, err := l.compile(script.Plain)
 	if err != nil {
-		return fmt.Errorf("compile error: %v", err)
+		return nil, nil, fmt.Errorf("compile error: %v", err)
 	}
 
 	m := vm.NewMachine(p)
 
-	err = m.SetVarsFromJSON(script.Vars)
+	vars, err := scriptVars(p, script.Vars)
 	if err != nil {
-		return fmt.Errorf("could not set variables: %v", err)
+		return nil, nil, err
+	}
+	err = m.SetVars(vars)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not set variables: %v", err)
 	}
 
 	{
 		ch, err := m.ResolveResources()
 		if err != nil {
-			return fmt.Errorf("could not resolve program resources: %v", err)
+			return nil, nil, fmt.Errorf("could not resolve program resources: %v", err)
 		}
 		for req := range ch {
 			if req.Error != nil {
-				return fmt.Errorf("could not resolve program resources: %v", req.Error)
+				return nil, nil, fmt.Errorf("could not resolve program resources: %v", req.Error)
 			}
 			account, err := l.GetAccount(ctx, req.Account)
 			if err != nil {
-				return fmt.Errorf("could not get account %q: %v", req.Account, err)
+				return nil, nil, fmt.Errorf("could not get account %q: %v", req.Account, err)
 			}
 			meta := account.Metadata
 			entry, ok := meta[req.Key]
 			if !ok {
-				return fmt.Errorf("missing key %v in metadata for account %v", req.Key, req.Account)
+				return nil, nil, fmt.Errorf("missing key %v in metadata for account %v", req.Key, req.Account)
 			}
 			value, err := machine.NewValueFromTypedJSON(entry)
 			if err != nil {
-				return fmt.Errorf("invalid format for metadata at key %v for account %v: %v", req.Key, req.Account, err)
+				return nil, nil, fmt.Errorf("invalid format for metadata at key %v for account %v: %v", req.Key, req.Account, err)
 			}
 			req.Response <- *value
 		}
@@ -57,17 +128,27 @@ func (l *Ledger) Execute(ctx context.Context, script core.Script) error {
 	{
 		ch, err := m.ResolveBalances()
 		if err != nil {
-			return fmt.Errorf("could not resolve balances: %v", err)
+			return nil, nil, fmt.Errorf("could not resolve balances: %v", err)
 		}
 		for req := range ch {
 			if req.Error != nil {
-				return fmt.Errorf("could not resolve balances: %v", err)
+				return nil, nil, fmt.Errorf("could not resolve balances: %v", err)
 			}
 			account, err := l.GetAccount(ctx, req.Account)
 			if err != nil {
-				return fmt.Errorf("could not get account %q: %v", req.Account, err)
+				return nil, nil, fmt.Errorf("could not get account %q: %v", req.Account, err)
 			}
-			amt := account.Balances[req.Asset]
+			asset := req.Asset
+			if l.normalizeAssets {
+				// The postings of the script are normalized when committed
+				asset = strings.ToUpper(asset)
+			}
+			overdrafts, err := l.overdrafts(ctx, req.Account)
+			if err != nil {
+				return nil, nil, fmt.Errorf("could not get overdrafts of account %q: %v", req.Account, err)
+			}
+			// The script can send the balance and the overdraft, checked again by Commit
+			amt := account.Balances[asset] + overdrafts.limit(asset)
 			if amt < 0 {
 				amt = 0
 			}
@@ -77,16 +158,13 @@ func (l *Ledger) Execute(ctx context.Context, script core.Script) error {
 
 	c, err := m.Execute()
 	if err != nil {
-		return fmt.Errorf("script failed: %v", err)
+		return nil, nil, fmt.Errorf("script failed: %v", err)
 	}
 	if c == vm.EXIT_FAIL {
-		return errors.New("script exited with error code EXIT_FAIL")
+		return nil, nil, errors.New("script exited with error code EXIT_FAIL")
 	}
 
-	t := core.Transaction{
+	return &core.Transaction{
 		Postings: m.Postings,
-	}
-
-	_, err = l.Commit(ctx, []core.Transaction{t})
-	return err
+	}, scriptValues(script.Plain, p, m.Resources), nil
 }
@@ -8,6 +8,7 @@ import (
 	"testing"
 
 	"github.com/numary/ledger/pkg/core"
+	"github.com/stretchr/testify/assert"
 )
 
 func assertBalance(t *testing.T, l *Ledger, account string, asset string, amount int64) {
@@ -33,7 +34,7 @@ func TestTransactionInvalidScript(t *testing.T) {
 			Plain: "this is not a valid script",
 		}
 
-		err := l.Execute(context.Background(), script)
+		_, err := l.Execute(context.Background(), script)
 
 		if err == nil {
 			t.Error(errors.New(
@@ -50,7 +51,7 @@ func TestTransactionFail(t *testing.T) {
 			Plain: "fail",
 		}
 
-		err := l.Execute(context.Background(), script)
+		_, err := l.Execute(context.Background(), script)
 
 		if err == nil {
 			t.Error(errors.New(
@@ -71,7 +72,7 @@ func TestSend(t *testing.T) {
 			)`,
 		}
 
-		err := l.Execute(context.Background(), script)
+		_, err := l.Execute(context.Background(), script)
 
 		if err != nil {
 			t.Error(err)
@@ -96,7 +97,7 @@ func TestVariables(t *testing.T) {
 			}`),
 			&script)
 
-		err := l.Execute(context.Background(), script)
+		_, err := l.Execute(context.Background(), script)
 
 		if err != nil {
 			t.Error(err)
@@ -120,6 +121,72 @@ func TestVariables(t *testing.T) {
 	})
 }
 
+func TestTypedVariables(t *testing.T) {
+	with(func(l *Ledger) {
+		defer l.Close(context.Background())
+
+		// The asset and number variables are only checked, numscript can't build a monetary from them yet
+		plain := `vars {
+			account $dest
+			asset $asset
+			number $count
+			monetary $fee
+		}
+		send [VAR 12] (
+			source = @world
+			destination = $dest
+		)
+		send $fee (
+			source = @world
+			destination = @fees:typed
+		)`
+
+		vars := func(values string) map[string]json.RawMessage {
+			m := map[string]json.RawMessage{}
+			assert.NoError(t, json.Unmarshal([]byte(values), &m))
+			return m
+		}
+
+		_, err := l.Execute(context.Background(), core.Script{
+			Plain: plain,
+			Vars:  vars(`{"dest": "users:typed", "asset": "VAR", "count": 12, "fee": {"asset": "EUR/2", "amount": 3}}`),
+		})
+		assert.NoError(t, err)
+		assertBalance(t, l, "users:typed", "VAR", 12)
+		assertBalance(t, l, "fees:typed", "EUR/2", 3)
+
+		for _, values := range []string{
+			`{"asset": "VAR", "count": 12, "fee": {"asset": "EUR/2", "amount": 3}}`,
+			`{"dest": "users:typed", "asset": "VAR", "count": 12, "fee": {"asset": "EUR/2", "amount": 3}, "other": 1}`,
+			`{"dest": "@users:typed", "asset": "VAR", "count": 12, "fee": {"asset": "EUR/2", "amount": 3}}`,
+			`{"dest": "users:typed) destination = @other", "asset": "VAR", "count": 12, "fee": {"asset": "EUR/2", "amount": 3}}`,
+			`{"dest": 42, "asset": "VAR", "count": 12, "fee": {"asset": "EUR/2", "amount": 3}}`,
+			`{"dest": "users:typed", "asset": "VAR 12]", "count": 12, "fee": {"asset": "EUR/2", "amount": 3}}`,
+			`{"dest": "users:typed", "asset": "VAR", "count": "12", "fee": {"asset": "EUR/2", "amount": 3}}`,
+			`{"dest": "users:typed", "asset": "VAR", "count": -12, "fee": {"asset": "EUR/2", "amount": 3}}`,
+			`{"dest": "users:typed", "asset": "VAR", "count": 1.5, "fee": {"asset": "EUR/2", "amount": 3}}`,
+			`{"dest": "users:typed", "asset": "VAR", "count": 12, "fee": {"amount": 3}}`,
+			`{"dest": "users:typed", "asset": "VAR", "count": 12, "fee": {"asset": "EUR/2", "amount": "3"}}`,
+			`{"dest": "users:typed", "asset": "VAR", "count": 12, "fee": {"asset": "EUR/2", "amount": 3, "scale": 2}}`,
+			`{"dest": "users:typed", "asset": "VAR", "count": 12, "fee": "[EUR/2 3]"}`,
+		} {
+			_, err = l.Execute(context.Background(), core.Script{
+				Plain: plain,
+				Vars:  vars(values),
+			})
+			assert.True(t, errors.Is(err, ErrScriptVariable), values)
+			assert.True(t, errors.Is(err, ErrValidation), values)
+		}
+		assertBalance(t, l, "users:typed", "VAR", 12)
+
+		_, err = l.ExecutePreview(context.Background(), core.Script{
+			Plain: plain,
+			Vars:  vars(`{"dest": "users:typed", "asset": "VAR", "count": 12}`),
+		})
+		assert.EqualError(t, err, "invalid script variable: $fee of type monetary is missing")
+	})
+}
+
 func TestEnoughFunds(t *testing.T) {
 	with(func(l *Ledger) {
 		defer l.Close(context.Background())
@@ -153,7 +220,7 @@ func TestEnoughFunds(t *testing.T) {
 			return
 		}
 
-		err = l.Execute(context.Background(), script)
+		_, err = l.Execute(context.Background(), script)
 
 		if err != nil {
 			t.Error(err)
@@ -191,7 +258,7 @@ func TestNotEnoughFunds(t *testing.T) {
 			}`),
 			&script)
 
-		err = l.Execute(context.Background(), script)
+		_, err = l.Execute(context.Background(), script)
 
 		if err == nil {
 			t.Error("error wasn't supposed to be nil")
@@ -200,6 +267,43 @@ func TestNotEnoughFunds(t *testing.T) {
 	})
 }
 
+func TestExecutePreview(t *testing.T) {
+	with(func(l *Ledger) {
+		defer l.Close(context.Background())
+
+		script := core.Script{
+			Plain: `send [COIN 100] (
+				source=@world
+				destination=@user:preview
+			)`,
+		}
+
+		preview, err := l.ExecutePreview(context.Background(), script)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(preview.Postings) != 1 || preview.Postings[0].Destination != "user:preview" {
+			t.Fatalf("unexpected postings: %v", preview.Postings)
+		}
+		if d := preview.Deltas["user:preview"]["COIN"]; d != 100 {
+			t.Fatalf("wrong COIN delta for account user:preview, expected: %d got: %d", 100, d)
+		}
+
+		assertBalance(t, l, "user:preview", "COIN", 0)
+
+		_, err = l.ExecutePreview(context.Background(), core.Script{
+			Plain: `send [COIN 100] (
+				source=@user:preview
+				destination=@world
+			)`,
+		})
+		if err == nil {
+			t.Error("error wasn't supposed to be nil")
+		}
+	})
+}
+
 func TestMetadata(t *testing.T) {
 	with(func(l *Ledger) {
 		defer l.Close(context.Background())
@@ -262,12 +366,24 @@ func TestMetadata(t *testing.T) {
 			},
 		}
 
-		err = l.Execute(context.Background(), script)
+		result, err := l.Execute(context.Background(), script)
 
 		if err != nil {
 			t.Fatalf("execution error: %v", err)
 		}
 
+		assert.Equal(t, map[string]interface{}{
+			"sale":       "sales:042",
+			"seller":     "users:053",
+			"commission": "31/200",
+		}, result.Vars)
+		if assert.Len(t, result.Transactions, 1) {
+			last, err := l.GetLastTransaction(context.Background())
+			assert.NoError(t, err)
+			assert.Equal(t, last.ID, result.Transactions[0].ID)
+			assert.Equal(t, last.Hash, result.Transactions[0].Hash)
+		}
+
 		assertBalance(t, l, "sales:042", "COIN", 0)
 
 		assertBalance(t, l, "users:053", "COIN", 85)
@@ -0,0 +1,85 @@
+package ledger
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+
+	"github.com/numary/ledger/pkg/core"
+)
+
+// ExportVersion is the version of the format written by Export
+const ExportVersion = 1
+
+// ExportHeader is the first record of an export. It allows an importer to check
+// that it received all the transactions, and that they chain up to the same hash.
+type ExportHeader struct {
+	Version      int    `json:"version"`
+	Ledger       string `json:"ledger"`
+	Transactions int64  `json:"transactions"`
+	// Hash is the hash of the last transaction, empty if the ledger has no transactions
+	Hash string `json:"hash"`
+}
+
+// Export writes the ledger to w as newline-delimited JSON: an ExportHeader,
+// then every transaction with its metadata and hash, in chain order.
+// Transactions are read by pages, so memory stays bounded whatever the size of the ledger.
+// The transactions committed while exporting are not part of the export.
+func (l *Ledger) Export(ctx context.Context, w io.Writer) error {
+	header, err := l.exportHeader(ctx)
+	if err != nil {
+		return err
+	}
+	count := header.Transactions
+
+	enc := json.NewEncoder(w)
+	err = enc.Encode(header)
+	if err != nil {
+		return err
+	}
+
+	written := int64(0)
+	return l.walkTransactions(ctx, -1, func(tx core.Transaction) (bool, error) {
+		if written == count {
+			return false, nil
+		}
+		written++
+		return true, enc.Encode(tx)
+	})
+}
+
+// exportHeader returns the header of an export of the transactions of the ledger. The ids being sparse unless
+// generated by SequenceGenerator, the last transaction is read between two counts of the transactions,
+// which are read again if a transaction was committed meanwhile.
+func (l *Ledger) exportHeader(ctx context.Context) (ExportHeader, error) {
+	count, err := l.store.CountTransactions(ctx)
+	if err != nil {
+		return ExportHeader{}, err
+	}
+	for {
+		header := ExportHeader{
+			Version:      ExportVersion,
+			Ledger:       l.name,
+			Transactions: count,
+		}
+		if count == 0 {
+			return header, nil
+		}
+
+		last, err := l.store.LastTransaction(ctx)
+		if err != nil {
+			return ExportHeader{}, err
+		}
+		if last != nil {
+			header.Hash = last.Hash
+		}
+
+		count, err = l.store.CountTransactions(ctx)
+		if err != nil {
+			return ExportHeader{}, err
+		}
+		if count == header.Transactions {
+			return header, nil
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package ledger
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/numary/ledger/pkg/core"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExport(t *testing.T) {
+	with(func(l *Ledger) {
+		committed, err := l.Commit(context.Background(), []core.Transaction{
+			{
+				Postings: []core.Posting{
+					{
+						Source:      "world",
+						Destination: "users:export",
+						Amount:      100,
+						Asset:       "COIN",
+					},
+				},
+				Reference: "export_01",
+				Metadata: core.Metadata{
+					"foo": json.RawMessage(`"bar"`),
+				},
+			},
+		})
+		assert.NoError(t, err)
+
+		count, err := l.store.CountTransactions(context.Background())
+		assert.NoError(t, err)
+
+		buf := bytes.Buffer{}
+		err = l.Export(context.Background(), &buf)
+		assert.NoError(t, err)
+
+		scanner := bufio.NewScanner(&buf)
+		assert.True(t, scanner.Scan())
+
+		header := ExportHeader{}
+		err = json.Unmarshal(scanner.Bytes(), &header)
+		assert.NoError(t, err)
+		assert.Equal(t, ExportHeader{
+			Version:      ExportVersion,
+			Ledger:       "test",
+			Transactions: count,
+			Hash:         committed[0].Hash,
+		}, header)
+
+		var previous *core.Transaction
+		for scanner.Scan() {
+			tx := core.Transaction{}
+			err := json.Unmarshal(scanner.Bytes(), &tx)
+			assert.NoError(t, err)
+
+			if previous == nil {
+				assert.EqualValues(t, 0, tx.ID)
+			} else {
+				assert.Equal(t, previous.ID+1, tx.ID)
+			}
+
+			// The exported transactions hash to the same chain
+			hasher, err := l.hasherFor(core.HashAlgorithm(tx.Hash))
+			assert.NoError(t, err)
+			assert.Equal(t, tx.Hash, core.HashWith(hasher, previous, &tx))
+
+			if tx.ID == committed[0].ID {
+				assert.Equal(t, "export_01", tx.Reference)
+				assert.EqualValues(t, `"bar"`, tx.Metadata["foo"])
+			}
+			previous = &tx
+		}
+		assert.NoError(t, scanner.Err())
+		if assert.NotNil(t, previous) {
+			assert.Equal(t, count-1, previous.ID)
+		}
+	})
+}
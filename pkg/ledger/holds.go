@@ -0,0 +1,319 @@
+package ledger
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/numary/ledger/pkg/core"
+	"github.com/numary/ledger/pkg/storage"
+	"github.com/pborman/uuid"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// targetTypeHold is the metadata target type of the holds, keyed by id, with the hold under holdKey
+	targetTypeHold = "hold"
+	holdKey        = "hold"
+	// targetTypeAccountHolds indexes the holds of the accounts which may still be active, keyed by account
+	// then hold id, so that the commits read the holds of the accounts they debit at once
+	targetTypeAccountHolds = "holds"
+	// holdReferencePrefix is followed by the id of a hold in the reference of the transaction capturing it,
+	// so that a hold is never captured twice, even by concurrent processes
+	holdReferencePrefix = "capture_"
+)
+
+// Hold reserves an amount of an account, to be captured later into a transaction to the destination, or released.
+// The held amount is unavailable to the transactions and holds debiting the account, which are checked against
+// its balance minus the amounts of its active holds, the overdraft of the account included. The hold is released
+// once expiresAt has passed, unless it is zero. Like the overdrafts, the holds are checked with the ledger lock,
+// against the commits of this process.
+func (l *Ledger) Hold(ctx context.Context, account, destination, asset string, amount int64, expiresAt time.Time) (core.Hold, error) {
+	ctx = storage.WithConsistentRead(ctx)
+	if l.normalizeAssets {
+		asset = strings.ToUpper(asset)
+	}
+	if l.unchecked(account) {
+		return core.Hold{}, newValidationError("the account %s can't be held: its balances are not checked", account)
+	}
+
+	now := time.Now().UTC()
+	hold := core.Hold{
+		ID:          uuid.New(),
+		Account:     account,
+		Destination: destination,
+		Asset:       asset,
+		Amount:      amount,
+		Status:      core.HoldActive,
+		Timestamp:   now.Format(time.RFC3339),
+	}
+	if !expiresAt.IsZero() {
+		if !expiresAt.After(now) {
+			return core.Hold{}, newValidationError("invalid hold expiry %s: expected a time in the future", expiresAt.Format(time.RFC3339))
+		}
+		hold.ExpiresAt = expiresAt.UTC().Format(time.RFC3339)
+	}
+	// The transaction capturing the hold is validated upfront, so that it can be committed whenever captured
+	err := core.ValidateTransactions([]core.Transaction{{
+		Postings: core.Postings{hold.Posting()},
+	}}, core.ValidationOptions{})
+	if err != nil {
+		return core.Hold{}, err
+	}
+
+	unlock, err := l.locker.Lock(l.name)
+	if err != nil {
+		return core.Hold{}, errors.Wrap(err, "unable to acquire lock")
+	}
+	defer unlock()
+
+	held, closed, err := l.heldAmounts(ctx, account, "")
+	if err != nil {
+		return core.Hold{}, err
+	}
+	balances, err := l.store.AggregateBalances(ctx, account)
+	if err != nil {
+		return core.Hold{}, err
+	}
+	if available := balances[asset] - held[asset]; available < amount {
+		overdrafts, err := l.overdrafts(ctx, account)
+		if err != nil {
+			return core.Hold{}, err
+		}
+		if limit := overdrafts.limit(asset); available+limit < amount {
+			return core.Hold{}, &InsufficientFundsError{
+				Account:   account,
+				Asset:     asset,
+				Needed:    amount,
+				Available: balances[asset],
+				Overdraft: limit,
+				Held:      held[asset],
+			}
+		}
+	}
+
+	err = l.saveHold(ctx, hold)
+	if err != nil {
+		return core.Hold{}, err
+	}
+	l.unindexHolds(ctx, account, closed...)
+
+	return hold, nil
+}
+
+// GetHold returns a hold by id, with the status HoldExpired if it is active past its expiry.
+// It returns ErrHoldNotFound if there is none.
+func (l *Ledger) GetHold(ctx context.Context, id string) (core.Hold, error) {
+	meta, err := l.store.GetMeta(ctx, targetTypeHold, id)
+	if err != nil {
+		return core.Hold{}, err
+	}
+	value, ok := meta[holdKey]
+	if !ok {
+		return core.Hold{}, fmt.Errorf("%w: %s", ErrHoldNotFound, id)
+	}
+
+	var hold core.Hold
+	if err := json.Unmarshal(value, &hold); err != nil {
+		return core.Hold{}, errors.Wrapf(err, "reading hold %s", id)
+	}
+	if hold.Expired(time.Now()) {
+		hold.Status = core.HoldExpired
+	}
+	return hold, nil
+}
+
+// GetHolds returns the active holds of an account, the oldest first
+func (l *Ledger) GetHolds(ctx context.Context, account string) ([]core.Hold, error) {
+	holds, _, err := l.accountHolds(ctx, account)
+	return holds, err
+}
+
+// Capture commits the transaction of an active hold, from its account to its destination, with the reference
+// capture_<id>. The held amount is available to the transaction, unlike the amounts of the other holds.
+func (l *Ledger) Capture(ctx context.Context, id string) (core.Transaction, error) {
+	ctx = storage.WithConsistentRead(ctx)
+	hold, err := l.GetHold(ctx, id)
+	if err != nil {
+		return core.Transaction{}, err
+	}
+	if err := checkHold(hold); err != nil {
+		return core.Transaction{}, err
+	}
+
+	ts, err := l.CommitWithOptions(ctx, []core.Transaction{{
+		Postings:  core.Postings{hold.Posting()},
+		Reference: holdReferencePrefix + id,
+		Metadata:  core.Metadata{},
+	}}, CommitOptions{
+		capture:    &hold,
+		keepAssets: true,
+	})
+	if err != nil {
+		return core.Transaction{}, err
+	}
+	l.unindexHolds(ctx, hold.Account, id)
+
+	return ts[0], nil
+}
+
+// Release gives up an active hold, making its amount available again. The expired holds can be released too.
+func (l *Ledger) Release(ctx context.Context, id string) error {
+	ctx = storage.WithConsistentRead(ctx)
+
+	unlock, err := l.locker.Lock(l.name)
+	if err != nil {
+		return errors.Wrap(err, "unable to acquire lock")
+	}
+	defer unlock()
+
+	hold, err := l.GetHold(ctx, id)
+	if err != nil {
+		return err
+	}
+	if hold.Status != core.HoldActive && hold.Status != core.HoldExpired {
+		return fmt.Errorf("%w: %s is %s", ErrHoldClosed, id, hold.Status)
+	}
+
+	hold.Status = core.HoldReleased
+	err = l.saveHold(ctx, hold)
+	if err != nil {
+		return err
+	}
+	l.unindexHolds(ctx, hold.Account, id)
+
+	return nil
+}
+
+// checkHold checks that a hold can be captured
+func checkHold(hold core.Hold) error {
+	switch hold.Status {
+	case core.HoldActive:
+		return nil
+	case core.HoldExpired:
+		return newValidationError("hold %s expired at %s", hold.ID, hold.ExpiresAt)
+	default:
+		return fmt.Errorf("%w: %s is %s", ErrHoldClosed, hold.ID, hold.Status)
+	}
+}
+
+// checkCapture checks that the hold id can still be captured
+func (l *Ledger) checkCapture(ctx context.Context, id string) error {
+	hold, err := l.GetHold(ctx, id)
+	if err != nil {
+		return err
+	}
+	return checkHold(hold)
+}
+
+// saveHold saves a hold along with its entry in the index of the holds of its account
+func (l *Ledger) saveHold(ctx context.Context, hold core.Hold) error {
+	lastMetaID, err := l.store.LastMetaID(ctx)
+	if err != nil {
+		return err
+	}
+
+	entries := holdMeta(hold, time.Now().UTC().Format(time.RFC3339))
+	for i := range entries {
+		entries[i].ID = lastMetaID + 1 + int64(i)
+	}
+	return l.store.SaveMetaBatch(ctx, entries)
+}
+
+// holdMeta returns the metadata entries of a hold and of its entry in the index of the holds of its account
+func holdMeta(hold core.Hold, timestamp string) []storage.MetaEntry {
+	value, err := json.Marshal(hold)
+	if err != nil {
+		panic(err)
+	}
+
+	return []storage.MetaEntry{
+		{
+			Timestamp:  timestamp,
+			TargetType: targetTypeHold,
+			TargetID:   hold.ID,
+			Key:        holdKey,
+			Value:      string(value),
+		},
+		{
+			Timestamp:  timestamp,
+			TargetType: targetTypeAccountHolds,
+			TargetID:   hold.Account,
+			Key:        hold.ID,
+			Value:      string(value),
+		},
+	}
+}
+
+// capturedMeta returns the metadata entries marking a hold as captured by a transaction,
+// saved along with the transaction
+func capturedMeta(hold core.Hold, tx core.Transaction) []storage.MetaEntry {
+	txid := tx.ID
+	hold.Status = core.HoldCaptured
+	hold.TxID = &txid
+	return holdMeta(hold, tx.Timestamp)
+}
+
+// accountHolds returns the active holds of an account, the oldest first,
+// along with the ids of the holds of its index which are not active anymore
+func (l *Ledger) accountHolds(ctx context.Context, account string) ([]core.Hold, []string, error) {
+	meta, err := l.store.GetMeta(ctx, targetTypeAccountHolds, account)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	holds := make([]core.Hold, 0)
+	closed := make([]string, 0)
+	for id, value := range meta {
+		var hold core.Hold
+		if err := json.Unmarshal(value, &hold); err != nil {
+			return nil, nil, errors.Wrapf(err, "reading hold %s of account %s", id, account)
+		}
+		if hold.Status != core.HoldActive || hold.Expired(now) {
+			closed = append(closed, id)
+			continue
+		}
+		holds = append(holds, hold)
+	}
+	sort.Slice(holds, func(i, j int) bool {
+		if holds[i].Timestamp != holds[j].Timestamp {
+			return holds[i].Timestamp < holds[j].Timestamp
+		}
+		return holds[i].ID < holds[j].ID
+	})
+
+	return holds, closed, nil
+}
+
+// heldAmounts returns the amounts of the active holds of an account by asset, but the hold being captured if any,
+// along with the ids of the holds of its index which are not active anymore
+func (l *Ledger) heldAmounts(ctx context.Context, account, capture string) (map[string]int64, []string, error) {
+	holds, closed, err := l.accountHolds(ctx, account)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	held := map[string]int64{}
+	for _, hold := range holds {
+		if hold.ID != capture {
+			held[hold.Asset] += hold.Amount
+		}
+	}
+	return held, closed, nil
+}
+
+// unindexHolds removes holds which are not active anymore from the index of the holds of their account.
+// The holds left in the index are ignored, the failures are only logged.
+func (l *Ledger) unindexHolds(ctx context.Context, account string, ids ...string) {
+	if len(ids) == 0 {
+		return
+	}
+	if err := l.store.DeleteMeta(ctx, targetTypeAccountHolds, account, ids); err != nil {
+		logrus.Errorf("ledger %s: removing the closed holds of account %s: %s", l.name, account, err)
+	}
+}
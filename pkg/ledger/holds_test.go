@@ -0,0 +1,171 @@
+package ledger
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/numary/ledger/pkg/core"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHold(t *testing.T) {
+	with(func(l *Ledger) {
+		send := func(amount int64) error {
+			_, err := l.Commit(context.Background(), []core.Transaction{{
+				Postings: []core.Posting{
+					{Source: "users:held", Destination: "payouts:held", Amount: amount, Asset: "HLD"},
+				},
+			}})
+			return err
+		}
+
+		_, err := l.Commit(context.Background(), []core.Transaction{{
+			Postings: []core.Posting{
+				{Source: "world", Destination: "users:held", Amount: 100, Asset: "HLD"},
+			},
+		}})
+		assert.NoError(t, err)
+
+		hold, err := l.Hold(context.Background(), "users:held", "merchants:held", "HLD", 60, time.Time{})
+		assert.NoError(t, err)
+		assert.Equal(t, core.HoldActive, hold.Status)
+		assert.NotEmpty(t, hold.ID)
+
+		holds, err := l.GetHolds(context.Background(), "users:held")
+		assert.NoError(t, err)
+		assert.Equal(t, []core.Hold{hold}, holds)
+
+		// The held amount stays in the balance but can't be debited
+		assertBalance(t, l, "users:held", "HLD", 100)
+		err = send(41)
+		insufficient := &InsufficientFundsError{}
+		if assert.True(t, errors.As(err, &insufficient), err) {
+			assert.Equal(t, &InsufficientFundsError{
+				Account:   "users:held",
+				Asset:     "HLD",
+				Needed:    41,
+				Available: 100,
+				Held:      60,
+			}, insufficient)
+		}
+		assert.Contains(t, err.Error(), "of which 60 held")
+
+		_, err = l.Hold(context.Background(), "users:held", "merchants:held", "HLD", 41, time.Time{})
+		assert.True(t, errors.Is(err, ErrInsufficientFunds), err)
+
+		assert.NoError(t, send(30))
+
+		// Capturing the hold commits its transaction, from the held amount
+		tx, err := l.Capture(context.Background(), hold.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, "capture_"+hold.ID, tx.Reference)
+		assert.Equal(t, core.Postings{hold.Posting()}, tx.Postings)
+		assertBalance(t, l, "users:held", "HLD", 10)
+		assertBalance(t, l, "merchants:held", "HLD", 60)
+
+		captured, err := l.GetHold(context.Background(), hold.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, core.HoldCaptured, captured.Status)
+		if assert.NotNil(t, captured.TxID) {
+			assert.Equal(t, tx.ID, *captured.TxID)
+		}
+
+		_, err = l.Capture(context.Background(), hold.ID)
+		assert.True(t, errors.Is(err, ErrHoldClosed), err)
+		err = l.Release(context.Background(), hold.ID)
+		assert.True(t, errors.Is(err, ErrHoldClosed), err)
+
+		holds, err = l.GetHolds(context.Background(), "users:held")
+		assert.NoError(t, err)
+		assert.Empty(t, holds)
+		assert.NoError(t, send(10))
+	})
+}
+
+func TestHoldRelease(t *testing.T) {
+	with(func(l *Ledger) {
+		_, err := l.Commit(context.Background(), []core.Transaction{{
+			Postings: []core.Posting{
+				{Source: "world", Destination: "users:released", Amount: 100, Asset: "HLD"},
+			},
+		}})
+		assert.NoError(t, err)
+
+		hold, err := l.Hold(context.Background(), "users:released", "merchants:released", "HLD", 100, time.Time{})
+		assert.NoError(t, err)
+
+		_, err = l.Hold(context.Background(), "users:released", "merchants:released", "HLD", 1, time.Time{})
+		assert.True(t, errors.Is(err, ErrInsufficientFunds), err)
+
+		assert.NoError(t, l.Release(context.Background(), hold.ID))
+
+		released, err := l.GetHold(context.Background(), hold.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, core.HoldReleased, released.Status)
+
+		_, err = l.Capture(context.Background(), hold.ID)
+		assert.True(t, errors.Is(err, ErrHoldClosed), err)
+
+		_, err = l.Hold(context.Background(), "users:released", "merchants:released", "HLD", 100, time.Time{})
+		assert.NoError(t, err)
+	})
+}
+
+func TestHoldExpiry(t *testing.T) {
+	with(func(l *Ledger) {
+		_, err := l.Commit(context.Background(), []core.Transaction{{
+			Postings: []core.Posting{
+				{Source: "world", Destination: "users:expired", Amount: 100, Asset: "HLD"},
+			},
+		}})
+		assert.NoError(t, err)
+
+		_, err = l.Hold(context.Background(), "users:expired", "merchants:expired", "HLD", 10, time.Now().Add(-time.Second))
+		assert.True(t, errors.Is(err, ErrValidation), err)
+
+		hold, err := l.Hold(context.Background(), "users:expired", "merchants:expired", "HLD", 100, time.Now().Add(time.Second))
+		assert.NoError(t, err)
+		assert.NotEmpty(t, hold.ExpiresAt)
+
+		expiresAt, err := time.Parse(time.RFC3339, hold.ExpiresAt)
+		assert.NoError(t, err)
+		time.Sleep(time.Until(expiresAt))
+
+		expired, err := l.GetHold(context.Background(), hold.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, core.HoldExpired, expired.Status)
+
+		_, err = l.Capture(context.Background(), hold.ID)
+		assert.True(t, errors.Is(err, ErrValidation), err)
+
+		// The expired amount is available again
+		_, err = l.Commit(context.Background(), []core.Transaction{{
+			Postings: []core.Posting{
+				{Source: "users:expired", Destination: "payouts:expired", Amount: 100, Asset: "HLD"},
+			},
+		}})
+		assert.NoError(t, err)
+	})
+}
+
+func TestHoldErrors(t *testing.T) {
+	with(func(l *Ledger) {
+		_, err := l.Hold(context.Background(), "world", "users:001", "HLD", 10, time.Time{})
+		assert.True(t, errors.Is(err, ErrValidation), err)
+
+		_, err = l.Hold(context.Background(), "users:001", "users:002", "HLD", -10, time.Time{})
+		assert.True(t, errors.Is(err, ErrValidation), err)
+
+		_, err = l.GetHold(context.Background(), "unknown")
+		assert.True(t, errors.Is(err, ErrHoldNotFound), err)
+		assert.True(t, errors.Is(err, ErrNotFound), err)
+
+		_, err = l.Capture(context.Background(), "unknown")
+		assert.True(t, errors.Is(err, ErrNotFound), err)
+
+		err = l.Release(context.Background(), "unknown")
+		assert.True(t, errors.Is(err, ErrNotFound), err)
+	})
+}
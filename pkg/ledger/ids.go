@@ -0,0 +1,137 @@
+package ledger
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/numary/ledger/pkg/core"
+)
+
+// IDStrategy names a strategy generating the ids of the committed transactions, see ParseIDStrategy
+type IDStrategy string
+
+const (
+	// IDStrategySequence numbers the transactions from 0, the id of a transaction being its position in the chain
+	IDStrategySequence IDStrategy = "sequence"
+	// IDStrategySnowflake generates snowflake ids, see NewSnowflakeGenerator
+	IDStrategySnowflake IDStrategy = "snowflake"
+)
+
+// ParseIDStrategy parses the name of an id strategy
+func ParseIDStrategy(v string) (IDStrategy, error) {
+	switch strategy := IDStrategy(v); strategy {
+	case IDStrategySequence, IDStrategySnowflake:
+		return strategy, nil
+	}
+	return "", fmt.Errorf("invalid id strategy '%s': expected %s or %s", v, IDStrategySequence, IDStrategySnowflake)
+}
+
+// IDGenerator generates the ids of the committed transactions. The ids must be greater than the id
+// of the last transaction of the ledger, and increase within a batch: the transactions are chained
+// in the order of their ids.
+type IDGenerator interface {
+	// NextIDs returns the ids of n transactions following last, nil if the ledger has no transaction yet.
+	// count is the number of transactions of the ledger.
+	NextIDs(last *core.Transaction, count int64, n int) []int64
+}
+
+type sequenceGenerator struct{}
+
+func (sequenceGenerator) NextIDs(last *core.Transaction, count int64, n int) []int64 {
+	next := count
+	if last != nil && last.ID >= next {
+		next = last.ID + 1
+	}
+	ids := make([]int64, n)
+	for i := range ids {
+		ids[i] = next + int64(i)
+	}
+	return ids
+}
+
+// SequenceGenerator numbers the transactions from 0, see IDStrategySequence. The ids follow the last one
+// if the ids were generated by another strategy before.
+var SequenceGenerator IDGenerator = sequenceGenerator{}
+
+const (
+	snowflakeNodeBits     = 10
+	snowflakeSequenceBits = 12
+	// SnowflakeMaxNode is the greatest node of a snowflake generator
+	SnowflakeMaxNode = 1<<snowflakeNodeBits - 1
+)
+
+// SnowflakeEpoch is the time the timestamps of the snowflake ids are counted from
+var SnowflakeEpoch = time.Date(2021, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+type snowflakeGenerator struct {
+	mu   sync.Mutex
+	node int64
+	last int64
+	now  func() time.Time
+}
+
+// NewSnowflakeGenerator returns a generator of snowflake ids: the milliseconds elapsed since SnowflakeEpoch
+// on 41 bits, then the node on 10 bits and a sequence on 12 bits. The ids of the nodes don't collide and
+// are roughly sorted by time, without the nodes sharing a sequence. They don't reveal the number of
+// transactions of the ledger either.
+// The ids are greater than the last id of the ledger even if the clock goes backward or other nodes
+// committed ids ahead of it, the timestamp of the ids then being the one of the last id.
+func NewSnowflakeGenerator(node int64) (IDGenerator, error) {
+	if node < 0 || node > SnowflakeMaxNode {
+		return nil, fmt.Errorf("invalid snowflake node %d: expected an integer between 0 and %d", node, SnowflakeMaxNode)
+	}
+	return &snowflakeGenerator{
+		node: node,
+		now:  time.Now,
+	}, nil
+}
+
+func (g *snowflakeGenerator) NextIDs(last *core.Transaction, count int64, n int) []int64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	id := g.last
+	if last != nil && last.ID > id {
+		id = last.ID
+	}
+	ms := g.now().Sub(SnowflakeEpoch).Milliseconds()
+
+	ids := make([]int64, n)
+	for i := range ids {
+		id = g.next(id, ms)
+		ids[i] = id
+	}
+	g.last = id
+	return ids
+}
+
+// next returns the first id of the node greater than id, at the millisecond ms or later
+func (g *snowflakeGenerator) next(id, ms int64) int64 {
+	const timestampShift = snowflakeNodeBits + snowflakeSequenceBits
+	if last := id >> timestampShift; last > ms {
+		ms = last
+	}
+	next := ms<<timestampShift | g.node<<snowflakeSequenceBits
+	if next > id {
+		return next
+	}
+	// The last id is of the same millisecond, its sequence is followed if it is of the same node
+	// and is not exhausted, the next millisecond is taken otherwise
+	sequenceMask := int64(1<<snowflakeSequenceBits - 1)
+	if id>>snowflakeSequenceBits == next>>snowflakeSequenceBits && id&sequenceMask < sequenceMask {
+		return id + 1
+	}
+	return (ms+1)<<timestampShift | g.node<<snowflakeSequenceBits
+}
+
+// NewIDGenerator returns the generator of the strategy, the node being used by the snowflake ids
+func NewIDGenerator(strategy IDStrategy, node int64) (IDGenerator, error) {
+	switch strategy {
+	case IDStrategySequence:
+		return SequenceGenerator, nil
+	case IDStrategySnowflake:
+		return NewSnowflakeGenerator(node)
+	}
+	return nil, fmt.Errorf("invalid id strategy '%s'", strategy)
+}
@@ -0,0 +1,160 @@
+package ledger
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/numary/ledger/pkg/core"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseIDStrategy(t *testing.T) {
+	for _, v := range []string{"sequence", "snowflake"} {
+		strategy, err := ParseIDStrategy(v)
+		assert.NoError(t, err)
+		assert.EqualValues(t, v, strategy)
+	}
+
+	_, err := ParseIDStrategy("uuid")
+	assert.Error(t, err)
+
+	_, err = NewSnowflakeGenerator(SnowflakeMaxNode + 1)
+	assert.Error(t, err)
+}
+
+func TestSnowflakeGenerator(t *testing.T) {
+	now := SnowflakeEpoch.Add(time.Hour)
+	generator, err := NewSnowflakeGenerator(3)
+	assert.NoError(t, err)
+	g := generator.(*snowflakeGenerator)
+	g.now = func() time.Time {
+		return now
+	}
+
+	ms := time.Hour.Milliseconds()
+	ids := g.NextIDs(nil, 0, 3)
+	assert.Equal(t, []int64{ms<<22 | 3<<12, ms<<22 | 3<<12 + 1, ms<<22 | 3<<12 + 2}, ids)
+
+	// The ids still increase when the clock goes backward
+	now = now.Add(-time.Second)
+	next := g.NextIDs(&core.Transaction{ID: ids[2]}, 3, 1)
+	assert.Equal(t, []int64{ids[2] + 1}, next)
+
+	// They follow the ids of the other nodes committed ahead of the generator, on the next millisecond
+	// if the node of the generator is before theirs
+	now = SnowflakeEpoch.Add(time.Hour)
+	ahead := (ms+10)<<22 | 7<<12
+	next = g.NextIDs(&core.Transaction{ID: ahead}, 4, 1)
+	assert.Equal(t, []int64{(ms+11)<<22 | 3<<12}, next)
+
+	// The sequence of a millisecond is exhausted after 4096 ids
+	g.last = 0
+	exhausted := ms<<22 | 3<<12 | 4095
+	next = g.NextIDs(&core.Transaction{ID: exhausted}, 5, 1)
+	assert.Equal(t, []int64{(ms+1)<<22 | 3<<12}, next)
+
+	// The ids of the batches of a node increase
+	g.now = time.Now
+	previous := int64(-1)
+	for i := 0; i < 100; i++ {
+		for _, id := range g.NextIDs(nil, 0, 50) {
+			assert.Greater(t, id, previous)
+			previous = id
+		}
+	}
+}
+
+func TestCommitSnowflakeIDs(t *testing.T) {
+	l := newEmptyLedger(t)
+	generator, err := NewSnowflakeGenerator(1)
+	assert.NoError(t, err)
+	WithIDGenerator(generator)(l)
+
+	committed := make([]core.Transaction, 0)
+	for i := 0; i < 3; i++ {
+		txs, err := l.CommitWithKey(context.Background(), fmt.Sprintf("snowflake_%d", i), []core.Transaction{
+			{
+				Postings: []core.Posting{
+					{Source: "world", Destination: "users:001", Amount: 100, Asset: "COIN"},
+				},
+			},
+			{
+				Postings: []core.Posting{
+					{Source: "users:001", Destination: "users:002", Amount: 10, Asset: "COIN"},
+				},
+			},
+		})
+		assert.NoError(t, err)
+		committed = append(committed, txs...)
+	}
+	for i := 1; i < len(committed); i++ {
+		assert.Greater(t, committed[i].ID, committed[i-1].ID)
+		assert.Greater(t, committed[i].ID, int64(len(committed)))
+	}
+
+	// The transactions committed with a key are found again by their ids
+	txs, err := l.CommitWithKey(context.Background(), "snowflake_1", []core.Transaction{})
+	assert.NoError(t, err)
+	if assert.Len(t, txs, 2) {
+		assert.Equal(t, committed[2].ID, txs[0].ID)
+		assert.Equal(t, committed[3].Hash, txs[1].Hash)
+	}
+
+	tx, err := l.GetTransaction(context.Background(), fmt.Sprint(committed[3].ID))
+	assert.NoError(t, err)
+	assert.Equal(t, committed[3].Hash, tx.Hash)
+
+	ok, broken, err := l.VerifyHashChain(context.Background())
+	assert.NoError(t, err)
+	assert.True(t, ok, broken)
+
+	ok, broken, err = l.VerifyHashChainFrom(context.Background(), committed[2].ID)
+	assert.NoError(t, err)
+	assert.True(t, ok, broken)
+
+	txid, hash, count, err := l.Head(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, committed[5].ID, txid)
+	assert.Equal(t, committed[5].Hash, hash)
+	assert.EqualValues(t, 6, count)
+
+	stats, err := l.Stats(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, committed[0].Timestamp, stats.FirstTransactionAt)
+
+	root, size, err := l.MerkleRoot(context.Background())
+	assert.NoError(t, err)
+	assert.EqualValues(t, 6, size)
+	proof, err := l.InclusionProof(context.Background(), committed[3].ID)
+	assert.NoError(t, err)
+	assert.Equal(t, committed[3].ID, proof.TxID)
+	assert.EqualValues(t, 3, proof.Index)
+	assert.True(t, proof.Verify(root))
+
+	_, err = l.InclusionProof(context.Background(), committed[5].ID+1)
+	assert.ErrorIs(t, err, ErrTransactionNotFound)
+
+	buf := bytes.Buffer{}
+	assert.NoError(t, l.Export(context.Background(), &buf))
+	imported := newEmptyLedger(t)
+	assert.NoError(t, imported.Import(context.Background(), &buf, ImportOptions{}))
+	txid, hash, _, err = imported.Head(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, committed[5].ID, txid)
+	assert.Equal(t, committed[5].Hash, hash)
+
+	// The sequence follows the snowflake ids
+	WithIDGenerator(SequenceGenerator)(l)
+	txs, err = l.Commit(context.Background(), []core.Transaction{
+		{
+			Postings: []core.Posting{
+				{Source: "world", Destination: "users:001", Amount: 100, Asset: "COIN"},
+			},
+		},
+	})
+	assert.NoError(t, err)
+	assert.Greater(t, txs[0].ID, committed[5].ID)
+}
@@ -0,0 +1,156 @@
+package ledger
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/numary/ledger/pkg/core"
+	"github.com/numary/ledger/pkg/storage"
+	"github.com/pkg/errors"
+)
+
+const importBatchSize = 100
+
+type ImportOptions struct {
+	// Force allows importing into a ledger which already has transactions.
+	// They must be the first transactions of the export, as after an interrupted import, and are skipped.
+	Force bool
+}
+
+// Import reads an export written by Export into the ledger, keeping the ids, timestamps and hashes
+// of the transactions. The hash of each transaction is verified against the chain before it is saved,
+// the import stops at the first one which doesn't match with ErrBrokenChain. The metadata which differ
+// from the ones recorded in the hash were updated after the commit, they are imported as updates.
+// Transactions are saved by batches as they are read: on error, the transactions verified so far
+// are kept, and the import can be resumed with ImportOptions.Force.
+func (l *Ledger) Import(ctx context.Context, r io.Reader, opts ImportOptions) error {
+	ctx = storage.WithConsistentRead(ctx)
+	ctx = storage.WithReferenceScope(ctx, l.referenceScope)
+	unlock, err := l.locker.Lock(l.name)
+	if err != nil {
+		return errors.Wrap(err, "unable to acquire lock")
+	}
+	defer unlock()
+
+	dec := json.NewDecoder(r)
+
+	header := ExportHeader{}
+	err = dec.Decode(&header)
+	if err != nil {
+		return newValidationError("invalid export header: %s", err)
+	}
+	if header.Version != ExportVersion {
+		return newValidationError("unsupported export version %d", header.Version)
+	}
+
+	count, err := l.store.CountTransactions(ctx)
+	if err != nil {
+		return err
+	}
+	if count > 0 && !opts.Force {
+		return fmt.Errorf("%w: %d transactions", ErrLedgerNotEmpty, count)
+	}
+	if count > header.Transactions {
+		return newValidationError("the ledger has %d transactions, more than the %d of the export", count, header.Transactions)
+	}
+
+	batch := make([]core.Transaction, 0, importBatchSize)
+	// updates holds the metadata of the transactions of the batch which were updated since their commit,
+	// saved as updates so that their versions keep tracking it
+	updates := make([]storage.MetaEntry, 0)
+	save := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		var err error
+		if len(updates) > 0 {
+			err = l.store.SaveTransactionsWithMeta(ctx, batch, updates)
+		} else {
+			err = l.store.SaveTransactions(ctx, batch)
+		}
+		if err == nil {
+			recordTransactions(ctx, batch)
+		}
+		// The store may keep a reference to the saved transactions
+		batch = make([]core.Transaction, 0, importBatchSize)
+		updates = make([]storage.MetaEntry, 0)
+		return err
+	}
+
+	var previous *core.Transaction
+	// read is the number of transactions read, their ids increase but are sparse unless generated by SequenceGenerator
+	read := int64(0)
+	for {
+		tx := core.Transaction{}
+		err := dec.Decode(&tx)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return newValidationError("transaction %d: invalid record: %s", read, err)
+		}
+		if tx.ID < 0 || previous != nil && tx.ID <= previous.ID {
+			return newValidationError("transaction %d: unexpected id %d", read, tx.ID)
+		}
+
+		hasher, err := l.hasherFor(core.HashAlgorithm(tx.Hash))
+		if err != nil {
+			return fmt.Errorf("transaction %d: %w", tx.ID, err)
+		}
+		if !core.VerifyChainedHash(hasher, previous, &tx) {
+			return fmt.Errorf("%w: transaction %d", ErrBrokenChain, tx.ID)
+		}
+
+		if read < count {
+			// The transactions of the ledger are the first ones of the export, the hash of each one
+			// being chained to the ones before
+			stored, err := l.store.GetTransaction(ctx, fmt.Sprint(tx.ID))
+			if err != nil {
+				return err
+			}
+			if stored.Hash != tx.Hash {
+				return newValidationError("transaction %d differs from the transaction of the ledger", tx.ID)
+			}
+		} else {
+			saved := tx
+			if !core.VerifyMetadata(hasher, &tx) {
+				for key, value := range tx.Metadata {
+					updates = append(updates, storage.MetaEntry{
+						Timestamp:  tx.Timestamp,
+						TargetType: targetTypeTransaction,
+						TargetID:   fmt.Sprint(tx.ID),
+						Key:        key,
+						Value:      string(value),
+					})
+				}
+				saved.Metadata = nil
+			}
+			batch = append(batch, saved)
+			if len(batch) == importBatchSize {
+				err := save()
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		previous = &tx
+		read++
+	}
+
+	err = save()
+	if err != nil {
+		return err
+	}
+
+	if read != header.Transactions {
+		return newValidationError("export truncated: %d transactions read, %d expected", read, header.Transactions)
+	}
+	if previous != nil && previous.Hash != header.Hash {
+		return fmt.Errorf("%w: the last transaction doesn't match the export header", ErrBrokenChain)
+	}
+
+	return nil
+}
@@ -0,0 +1,201 @@
+package ledger
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/numary/ledger/pkg/core"
+	"github.com/numary/ledger/pkg/storage"
+	"github.com/numary/ledger/pkg/storage/sqlstorage"
+	"github.com/stretchr/testify/assert"
+)
+
+// newEmptyLedger returns a ledger backed by a new store of the test driver.
+// The ledgers of the in memory sqlite driver share the same tables, so a distinct database is used instead.
+func newEmptyLedger(t *testing.T) *Ledger {
+	name := fmt.Sprintf("import_%d", time.Now().UnixNano())
+
+	var (
+		store storage.Store
+		err   error
+	)
+	if driver.Name() == "sqlite" {
+		db, err := sql.Open(sqlstorage.SQLiteDriverName, fmt.Sprintf("file:%s?mode=memory&cache=shared", name))
+		assert.NoError(t, err)
+		store, err = sqlstorage.NewStore(name, sqlstorage.SQLite, db, func(ctx context.Context) error {
+			return db.Close()
+		})
+		assert.NoError(t, err)
+	} else {
+		store, err = driver.NewStore(name)
+		assert.NoError(t, err)
+	}
+	t.Cleanup(func() {
+		store.Close(context.Background())
+	})
+
+	err = store.Initialize(context.Background())
+	assert.NoError(t, err)
+
+	l, err := NewLedger(name, store, NewInMemoryLocker())
+	assert.NoError(t, err)
+
+	return l
+}
+
+func TestImport(t *testing.T) {
+	with(func(l *Ledger) {
+		users := make([]string, 10)
+		for i := range users {
+			users[i] = fmt.Sprintf("users:import:%d", i)
+			_, err := l.Commit(context.Background(), []core.Transaction{{
+				Postings: []core.Posting{
+					{Source: "world", Destination: users[i], Amount: 1000, Asset: "COIN"},
+				},
+			}})
+			assert.NoError(t, err)
+		}
+		for i := 0; i < 200; i++ {
+			source := users[rand.Intn(len(users))]
+			destination := users[rand.Intn(len(users))]
+			if source == destination {
+				continue
+			}
+			_, err := l.Commit(context.Background(), []core.Transaction{{
+				Postings: []core.Posting{
+					{Source: source, Destination: destination, Amount: rand.Int63n(50) + 1, Asset: "COIN"},
+				},
+				Metadata: core.Metadata{
+					"round": json.RawMessage(fmt.Sprint(i)),
+				},
+			}})
+			if !errors.Is(err, ErrInsufficientFunds) {
+				assert.NoError(t, err)
+			}
+		}
+
+		export := bytes.Buffer{}
+		err := l.Export(context.Background(), &export)
+		assert.NoError(t, err)
+
+		imported := newEmptyLedger(t)
+		err = imported.Import(context.Background(), bytes.NewReader(export.Bytes()), ImportOptions{})
+		assert.NoError(t, err)
+
+		for _, address := range append(users, "world") {
+			expected, err := l.GetAccount(context.Background(), address)
+			assert.NoError(t, err)
+			account, err := imported.GetAccount(context.Background(), address)
+			assert.NoError(t, err)
+			assert.Equal(t, expected.Balances, account.Balances, address)
+		}
+
+		// The transactions are exported as they were, ids, timestamps and hashes included
+		reexport := bytes.Buffer{}
+		err = imported.Export(context.Background(), &reexport)
+		assert.NoError(t, err)
+		expected := export.Bytes()[bytes.IndexByte(export.Bytes(), '\n'):]
+		actual := reexport.Bytes()[bytes.IndexByte(reexport.Bytes(), '\n'):]
+		assert.Equal(t, string(expected), string(actual))
+
+		err = imported.Import(context.Background(), bytes.NewReader(export.Bytes()), ImportOptions{})
+		assert.True(t, errors.Is(err, ErrLedgerNotEmpty), err)
+
+		err = imported.Import(context.Background(), bytes.NewReader(export.Bytes()), ImportOptions{
+			Force: true,
+		})
+		assert.NoError(t, err)
+	})
+}
+
+func TestImportBrokenChain(t *testing.T) {
+	with(func(l *Ledger) {
+		_, err := l.Commit(context.Background(), []core.Transaction{{
+			Postings: []core.Posting{
+				{Source: "world", Destination: "users:tampered", Amount: 100, Asset: "COIN"},
+			},
+		}})
+		assert.NoError(t, err)
+
+		export := bytes.Buffer{}
+		err = l.Export(context.Background(), &export)
+		assert.NoError(t, err)
+
+		// Raise the amount of the first posting of the last transaction
+		tampered := bytes.Buffer{}
+		scanner := bufio.NewScanner(&export)
+		for scanner.Scan() {
+			line := scanner.Bytes()
+			tx := core.Transaction{}
+			if json.Unmarshal(line, &tx) == nil && tx.Postings != nil && tx.Postings[0].Destination == "users:tampered" {
+				tx.Postings[0].Amount = 1000
+				line, err = json.Marshal(tx)
+				assert.NoError(t, err)
+			}
+			tampered.Write(line)
+			tampered.WriteString("\n")
+		}
+
+		imported := newEmptyLedger(t)
+		err = imported.Import(context.Background(), &tampered, ImportOptions{})
+		assert.True(t, errors.Is(err, ErrBrokenChain), err)
+		assert.True(t, errors.Is(err, ErrValidation), err)
+
+		balance, err := imported.store.AggregateBalance(context.Background(), "users:tampered", "COIN")
+		assert.NoError(t, err)
+		assert.EqualValues(t, 0, balance)
+	})
+}
+
+func TestImportUpdatedMetadata(t *testing.T) {
+	with(func(l *Ledger) {
+		committed, err := l.Commit(context.Background(), []core.Transaction{
+			{
+				Postings: []core.Posting{
+					{Source: "world", Destination: "users:updated", Amount: 100, Asset: "COIN"},
+				},
+				Metadata: core.Metadata{
+					"foo": json.RawMessage(`"bar"`),
+				},
+			},
+			{
+				Postings: []core.Posting{
+					{Source: "users:updated", Destination: "world", Amount: 10, Asset: "COIN"},
+				},
+			},
+		})
+		assert.NoError(t, err)
+		assert.NoError(t, l.SaveMeta(context.Background(), targetTypeTransaction, fmt.Sprint(committed[0].ID), core.Metadata{
+			"foo": json.RawMessage(`"updated"`),
+		}))
+		assert.NoError(t, l.RevertTransaction(context.Background(), fmt.Sprint(committed[1].ID)))
+
+		export := bytes.Buffer{}
+		assert.NoError(t, l.Export(context.Background(), &export))
+
+		// The metadata updated since the commit are imported as updates, so that the chain still verifies
+		imported := newEmptyLedger(t)
+		assert.NoError(t, imported.Import(context.Background(), &export, ImportOptions{}))
+		ok, tx, err := imported.VerifyHashChain(context.Background())
+		assert.NoError(t, err)
+		assert.True(t, ok, tx)
+
+		stored, err := imported.GetTransaction(context.Background(), fmt.Sprint(committed[0].ID))
+		assert.NoError(t, err)
+		assert.JSONEq(t, `"updated"`, string(stored.Metadata["foo"]))
+		version, err := imported.GetMetaVersion(context.Background(), targetTypeTransaction, fmt.Sprint(committed[0].ID))
+		assert.NoError(t, err)
+		assert.EqualValues(t, 1, version)
+		stored, err = imported.GetTransaction(context.Background(), fmt.Sprint(committed[1].ID))
+		assert.NoError(t, err)
+		assert.True(t, stored.Metadata.IsReverted())
+	})
+}
@@ -3,8 +3,15 @@ package ledger
 import (
 	"context"
 	"fmt"
+	"github.com/numary/ledger/pkg/metrics"
 	"github.com/numary/ledger/pkg/storage"
 	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/numary/ledger/pkg/core"
@@ -16,57 +23,339 @@ const (
 	targetTypeTransaction = "transaction"
 )
 
+var tracer = otel.Tracer("github.com/numary/ledger/pkg/ledger")
+
+const (
+	DefaultIdempotencyKeyTTL = 24 * time.Hour
+	DefaultCommitRetries     = 3
+)
+
+// DefaultUncheckedAccounts are the accounts of the ledgers created without WithUncheckedAccounts
+var DefaultUncheckedAccounts = []string{"world"}
+
+// Limits bound the size of the batches accepted by Commit, which rejects the larger ones
+// before hashing them. A zero limit disables it.
+// They also bound the pages of the lists: the pages default to DefaultPageSize items and the larger
+// ones are clamped to MaxPageSize, which the page size of the cursor reports. A zero page size
+// falls back to query.DEFAULT_LIMIT and query.MAX_LIMIT.
+type Limits struct {
+	MaxPostingsPerTransaction int
+	MaxTransactionsPerBatch   int
+	DefaultPageSize           int
+	MaxPageSize               int
+}
+
+// DefaultLimits are the limits of the ledgers created without WithLimits
+var DefaultLimits = Limits{
+	MaxPostingsPerTransaction: 1000,
+	MaxTransactionsPerBatch:   1000,
+	DefaultPageSize:           query.DEFAULT_LIMIT,
+	MaxPageSize:               query.MAX_LIMIT,
+}
+
+// PageSizes returns the default and maximum page sizes of the lists
+func (l Limits) PageSizes() (int, int) {
+	def, max := l.DefaultPageSize, l.MaxPageSize
+	if max <= 0 {
+		max = query.MAX_LIMIT
+	}
+	if def <= 0 {
+		def = query.DEFAULT_LIMIT
+	}
+	if def > max {
+		def = max
+	}
+	return def, max
+}
+
 type Ledger struct {
-	locker Locker
-	name   string
-	store  storage.Store
+	locker            Locker
+	name              string
+	store             storage.Store
+	idempotencyKeyTTL time.Duration
+	hasher            core.Hasher
+	commitRetries     int
+	commitTimeout     time.Duration
+	bus               *EventBus
+	metrics           *metrics.Metrics
+	normalizeAssets   bool
+	limits            Limits
+	scriptCache       *ScriptCache
+	// conversionTolerance, see core.ValidationOptions
+	conversionTolerance int64
+	uncheckedAccounts   []string
+	referenceScope      storage.ReferenceScope
+	idGenerator         IDGenerator
+}
+
+type LedgerOption func(*Ledger)
+
+// WithIdempotencyKeyTTL sets how long an idempotency key passed to CommitWithKey is remembered
+func WithIdempotencyKeyTTL(ttl time.Duration) LedgerOption {
+	return func(l *Ledger) {
+		l.idempotencyKeyTTL = ttl
+	}
 }
 
-func NewLedger(name string, store storage.Store, locker Locker) (*Ledger, error) {
-	return &Ledger{
-		store:  store,
-		name:   name,
-		locker: locker,
-	}, nil
+// WithCommitRetries sets how many times Commit processes the transactions again when saving them conflicts
+// with a concurrent commit, before giving up with ErrConflict
+func WithCommitRetries(retries int) LedgerOption {
+	return func(l *Ledger) {
+		l.commitRetries = retries
+	}
 }
 
-func (l *Ledger) Close(ctx context.Context) error {
-	err := l.store.Close(ctx)
+// WithCommitTimeout bounds the duration of Commit, in addition to the deadline of its context if any.
+// The transactions are not saved when it expires, and Commit returns context.DeadlineExceeded.
+// A zero timeout disables it.
+func WithCommitTimeout(timeout time.Duration) LedgerOption {
+	return func(l *Ledger) {
+		l.commitTimeout = timeout
+	}
+}
+
+// WithHasher sets the algorithm used to hash new transactions.
+// Transactions hashed with another algorithm can still be verified, see VerifyHashChain.
+func WithHasher(hasher core.Hasher) LedgerOption {
+	return func(l *Ledger) {
+		l.hasher = hasher
+	}
+}
+
+// WithEventBus sets the bus the ledger publishes its events to, so that they can be shared between ledgers
+func WithEventBus(bus *EventBus) LedgerOption {
+	return func(l *Ledger) {
+		l.bus = bus
+	}
+}
+
+// WithMetrics sets the collectors the ledger records its commits and the duration of its operations to
+func WithMetrics(m *metrics.Metrics) LedgerOption {
+	return func(l *Ledger) {
+		l.metrics = m
+	}
+}
+
+// WithAssetNormalization makes the ledger uppercase the asset codes of the committed postings
+// and of the asset queries, so that "gem" and "GEM" are the same asset. The transactions committed
+// before are left as is, see ConsolidateAssets.
+func WithAssetNormalization(enabled bool) LedgerOption {
+	return func(l *Ledger) {
+		l.normalizeAssets = enabled
+	}
+}
+
+// WithLimits sets the limits of the size of the committed batches and of the pages of the lists
+func WithLimits(limits Limits) LedgerOption {
+	return func(l *Ledger) {
+		l.limits = limits
+	}
+}
+
+// WithScriptCache makes the ledger keep the compiled scripts in the cache, which can be shared between ledgers.
+// The scripts are compiled on each execution otherwise.
+func WithScriptCache(cache *ScriptCache) LedgerOption {
+	return func(l *Ledger) {
+		l.scriptCache = cache
+	}
+}
+
+// WithConversionTolerance sets the difference allowed between the amounts of the postings of the conversions
+// of the committed transactions and the amounts computed from their rate, in units of the converted assets.
+// The computed amounts are rounded, a zero tolerance only allows the rounding. See core.Conversion.
+func WithConversionTolerance(tolerance int64) LedgerOption {
+	return func(l *Ledger) {
+		l.conversionTolerance = tolerance
+	}
+}
+
+// WithUncheckedAccounts sets the accounts whose balances are not checked by the commits, which can go negative
+// without any overdraft, like the world account by default. The accounts are addresses, or patterns ending
+// with a ":*" wildcard segment, like "external:*", matching all the accounts under "external:".
+// Only world is unbounded in the scripts though, which send the balances and overdrafts of the other accounts.
+func WithUncheckedAccounts(accounts ...string) LedgerOption {
+	return func(l *Ledger) {
+		l.uncheckedAccounts = accounts
+	}
+}
+
+// WithReferenceScope sets the scope within which the references of the committed transactions must be unique,
+// global by default. The transactions reusing a reference within the scope are rejected with ErrDuplicateReference.
+// Changing the scope of a ledger applies to the transactions committed afterwards, which are checked
+// against the references of all the transactions.
+func WithReferenceScope(scope storage.ReferenceScope) LedgerOption {
+	return func(l *Ledger) {
+		l.referenceScope = scope
+	}
+}
+
+// WithIDGenerator sets the generator of the ids of the committed transactions, SequenceGenerator by default.
+// The transactions committed before keep their ids, the generated ids following the last one.
+func WithIDGenerator(generator IDGenerator) LedgerOption {
+	return func(l *Ledger) {
+		l.idGenerator = generator
+	}
+}
+
+func NewLedger(name string, store storage.Store, locker Locker, options ...LedgerOption) (*Ledger, error) {
+	l := &Ledger{
+		store:             store,
+		name:              name,
+		locker:            locker,
+		idempotencyKeyTTL: DefaultIdempotencyKeyTTL,
+		hasher:            core.DefaultHasher,
+		commitRetries:     DefaultCommitRetries,
+		bus:               NewEventBus(),
+		limits:            DefaultLimits,
+		uncheckedAccounts: DefaultUncheckedAccounts,
+		referenceScope:    storage.ReferenceScopeGlobal,
+		idGenerator:       SequenceGenerator,
+	}
+	for _, opt := range options {
+		opt(l)
+	}
+	return l, nil
+}
+
+// Subscribe registers a handler called with the transactions committed by the ledger,
+// once they are saved to the store. See EventBus.Subscribe.
+func (l *Ledger) Subscribe(handler func(core.CommittedTransactions)) func() {
+	return l.bus.Subscribe(handler)
+}
+
+// Drop deletes all the transactions, accounts and metadata of the ledger.
+// The store must be initialized again before the ledger can be used, see Resolver.DropLedger.
+func (l *Ledger) Drop(ctx context.Context) error {
+	ctx = storage.WithConsistentRead(ctx)
+	unlock, err := l.locker.Lock(l.name)
 	if err != nil {
-		return errors.Wrap(err, "closing store")
+		return errors.Wrap(err, "unable to acquire lock")
 	}
-	return nil
+	defer unlock()
+
+	return l.store.Drop(ctx)
 }
 
-func (l *Ledger) Commit(ctx context.Context, ts []core.Transaction) ([]core.Transaction, error) {
+// RecomputeBalances rebuilds the balances and volumes of the accounts, maintained by the store along with
+// the transactions, from the postings of all the transactions. It is a maintenance routine recovering
+// from a drift of the maintained balances, the commits waiting for it to complete.
+func (l *Ledger) RecomputeBalances(ctx context.Context) error {
+	defer l.metrics.ObserveOperation(l.name, "recompute_balances", time.Now())
+
+	ctx = storage.WithConsistentRead(ctx)
 	unlock, err := l.locker.Lock(l.name)
 	if err != nil {
-		return nil, errors.Wrap(err, "unable to acquire lock")
+		return errors.Wrap(err, "unable to acquire lock")
 	}
 	defer unlock()
 
+	return l.store.RecomputeVolumes(ctx)
+}
+
+func (l *Ledger) Close(ctx context.Context) error {
+	err := l.store.Close(ctx)
+	if err != nil {
+		return errors.Wrap(err, "closing store")
+	}
+	return nil
+}
+
+// chain is the state of the hash chain of a ledger which the processed transactions follow
+type chain struct {
+	// last is the id of the last transaction, -1 if none
+	last int64
+	// count is the number of transactions
+	count int64
+}
+
+// process assigns ids, timestamps and hashes to the transactions and checks balances and references.
+// The transactions must have been validated with core.ValidateTransactions.
+// The transactions which can't be committed are reported with a TransactionError.
+// Balances are checked transaction by transaction, see checkBalances. It returns the balance delta of each account,
+// and the chain the transactions follow.
+func (l *Ledger) process(ctx context.Context, ts []core.Transaction, opts CommitOptions) (map[string]map[string]int64, chain, error) {
 	count, _ := l.store.CountTransactions(ctx)
 	rf := map[string]map[string]int64{}
-	timestamp := time.Now().Format(time.RFC3339)
+	scales := map[string]int{}
+	references := map[storage.ReferenceKey]struct{}{}
+	now := time.Now().UTC().Truncate(time.Second)
 
 	last, err := l.store.LastTransaction(ctx)
 	if err != nil {
-		return nil, err
+		return nil, chain{}, err
 	}
 
-	for i := range ts {
+	c := chain{
+		last:  -1,
+		count: count,
+	}
+	var previous time.Time
+	if last != nil {
+		c.last = last.ID
+		// Transactions committed by older versions may not carry a valid timestamp
+		previous, _ = time.Parse(time.RFC3339, last.Timestamp)
+	}
 
-		if len(ts[i].Postings) == 0 {
-			return ts, errors.New("transaction has no postings")
+	// The ids are assigned here rather than by a sequence of the database, the transactions being chained
+	// in the order of their ids. The concurrent commits of a ledger fail with ErrConflict, see
+	// storage.WithPreviousTransaction.
+	ids := l.idGenerator.NextIDs(last, count, len(ts))
+	for i := range ts {
+		for _, k := range storage.ReferenceKeys(l.referenceScope, ts[i]) {
+			if _, ok := references[k]; ok {
+				return nil, chain{}, &TransactionError{
+					Index: i,
+					Err:   fmt.Errorf("%w: %s", storage.ErrDuplicateReference, ts[i].Reference),
+				}
+			}
+			references[k] = struct{}{}
 		}
 
-		ts[i].ID = count + int64(i)
-		ts[i].Timestamp = timestamp
+		ts[i].ID = ids[i]
+
+		timestamp := now
+		if ts[i].Timestamp != "" {
+			timestamp, err = time.Parse(time.RFC3339, ts[i].Timestamp)
+			if err != nil {
+				return nil, chain{}, &TransactionError{
+					Index: i,
+					Err:   newValidationError("invalid timestamp '%s': expected RFC3339 format", ts[i].Timestamp),
+				}
+			}
+			if timestamp.Before(previous) && !opts.ClampTimestamps {
+				return nil, chain{}, &TransactionError{
+					Index: i,
+					Err: newValidationError(
+						"timestamp '%s' is before the previous transaction timestamp '%s'",
+						ts[i].Timestamp,
+						previous.UTC().Format(time.RFC3339),
+					),
+				}
+			}
+		}
+		// The server clock may also drift backward, timestamps must never decrease with ids
+		if timestamp.Before(previous) {
+			timestamp = previous
+		}
+		ts[i].Timestamp = timestamp.UTC().Format(time.RFC3339)
+		previous = timestamp
 
-		ts[i].Hash = core.Hash(last, &ts[i])
+		ts[i].Hash = core.HashWith(l.hasher, last, &ts[i])
 		last = &ts[i]
 
 		for _, p := range ts[i].Postings {
+			base, scale := core.AssetScale(p.Asset)
+			if s, ok := scales[base]; ok && s != scale {
+				return nil, chain{}, &TransactionError{
+					Index: i,
+					Err: newValidationError(
+						"asset.scale.inconsistent.%s",
+						base,
+					),
+				}
+			}
+			scales[base] = scale
+
 			if _, ok := rf[p.Source]; !ok {
 				rf[p.Source] = map[string]int64{}
 			}
@@ -81,185 +370,1402 @@ func (l *Ledger) Commit(ctx context.Context, ts []core.Transaction) ([]core.Tran
 		}
 	}
 
-	for addr := range rf {
-		if addr == "world" {
-			continue
+	// The references are looked up among all the transactions rather than by the keys of the store,
+	// which only cover the transactions committed since the scope of the ledger was last changed
+	keys := make([]storage.ReferenceKey, 0)
+	// The index in the batch of the transaction of each key
+	indexes := make([]int, 0)
+	for i := range ts {
+		for _, k := range storage.ReferenceKeys(l.referenceScope, ts[i]) {
+			keys = append(keys, k)
+			indexes = append(indexes, i)
 		}
-
-		checks := map[string]int64{}
-
-		for asset := range rf[addr] {
-			if rf[addr][asset] <= 0 {
-				continue
+	}
+	if len(keys) > 0 {
+		used, err := l.store.FindUsedReferences(ctx, keys)
+		if err != nil {
+			return nil, chain{}, err
+		}
+		found := make(map[storage.ReferenceKey]struct{}, len(used))
+		for _, k := range used {
+			found[k] = struct{}{}
+		}
+		// The first transaction of the batch using a reference is reported, whatever the order of used
+		for j := 0; len(found) > 0 && j < len(keys); j++ {
+			if _, ok := found[keys[j]]; ok {
+				return nil, chain{}, &TransactionError{
+					Index: indexes[j],
+					Err:   fmt.Errorf("%w: %s", storage.ErrDuplicateReference, keys[j].Reference),
+				}
 			}
-
-			checks[asset] = rf[addr][asset]
 		}
+	}
 
-		if len(checks) == 0 {
-			continue
+	if opts.RequireExistingAccounts {
+		created := map[string]bool{}
+		for i := range ts {
+			for _, p := range ts[i].Postings {
+				if _, ok := created[p.Destination]; !ok {
+					meta, err := l.store.GetMeta(ctx, targetTypeAccount, p.Destination)
+					if err != nil {
+						return nil, chain{}, err
+					}
+					created[p.Destination] = meta.IsCreated()
+				}
+				if !created[p.Destination] {
+					return nil, chain{}, &TransactionError{
+						Index: i,
+						Err:   fmt.Errorf("%w: %s", ErrAccountNotFound, p.Destination),
+					}
+				}
+			}
 		}
+	}
 
-		balances, err := l.store.AggregateBalances(ctx, addr)
-
+	if opts.StrictAssets {
+		err := l.checkAssets(ctx, ts)
 		if err != nil {
-			return ts, err
+			return nil, chain{}, err
 		}
+	}
 
-		for asset := range checks {
-			balance, ok := balances[asset]
+	// The scales are consistent within the batch, and must be with the ones of the postings of the ledger
+	err = l.checkScales(ctx, ts)
+	if err != nil {
+		return nil, chain{}, err
+	}
 
-			if !ok || balance < checks[asset] {
-				return ts, fmt.Errorf(
-					"balance.insufficient.%s",
-					asset,
-				)
-			}
+	// The reverts and captures only carry the metadata set by the ledger
+	if opts.reverts == "" && opts.bulkReverts == nil && opts.capture == nil {
+		err := l.checkTransactionsMetaSchema(ctx, ts)
+		if err != nil {
+			return nil, chain{}, err
 		}
 	}
 
-	err = l.store.SaveTransactions(ctx, ts)
+	err = l.checkPreconditions(ctx, opts.Preconditions)
 	if err != nil {
-		return nil, err
+		return nil, chain{}, err
 	}
 
-	return ts, err
-}
+	err = l.checkBalances(ctx, ts, opts)
+	if err != nil {
+		return nil, chain{}, err
+	}
 
-func (l *Ledger) GetLastTransaction(ctx context.Context) (core.Transaction, error) {
-	var tx core.Transaction
+	deltas := map[string]map[string]int64{}
+	for addr := range rf {
+		deltas[addr] = map[string]int64{}
+		for asset, amount := range rf[addr] {
+			deltas[addr][asset] = -amount
+		}
+	}
 
-	q := query.New()
-	q.Modify(query.Limit(1))
+	return deltas, c, nil
+}
 
-	c, err := l.store.FindTransactions(ctx, q)
+// funds are the amounts available to the transactions debiting an account, read once per batch
+type funds struct {
+	balances   map[string]int64
+	held       map[string]int64
+	overdrafts *overdrafts
+}
 
-	if err != nil {
-		return tx, err
+// checkBalances checks that the accounts debited by the transactions have enough funds, with a TransactionError
+// wrapping an InsufficientFundsError otherwise. Each transaction sees the previous transactions of the batch
+// as applied: a transaction can spend the funds received by an account earlier in the batch, but not the ones
+// it receives later. The postings of a transaction are checked on their net effect, so that a transaction can
+// credit an account then debit it.
+func (l *Ledger) checkBalances(ctx context.Context, ts []core.Transaction, opts CommitOptions) error {
+	// The amounts of the holds of the accounts are unavailable, but the one of the hold captured
+	capture := ""
+	if opts.capture != nil {
+		capture = opts.capture.ID
 	}
 
-	txs := (c.Data).([]core.Transaction)
-
-	if len(txs) == 0 {
-		return tx, nil
+	accounts := map[string]*funds{}
+	// net amount debited from each account by the transactions checked so far, by address then asset
+	debited := map[string]map[string]int64{}
+	add := func(m map[string]map[string]int64, addr, asset string, amount int64) {
+		if _, ok := m[addr]; !ok {
+			m[addr] = map[string]int64{}
+		}
+		m[addr][asset] += amount
 	}
 
-	tx = txs[0]
+	for i := range ts {
+		// net amount debited from each account by the transaction
+		net := map[string]map[string]int64{}
+		for _, p := range ts[i].Postings {
+			add(net, p.Source, p.Asset, p.Amount)
+			add(net, p.Destination, p.Asset, -p.Amount)
+			add(debited, p.Source, p.Asset, p.Amount)
+			add(debited, p.Destination, p.Asset, -p.Amount)
+		}
 
-	return tx, nil
-}
+		for addr := range net {
+			if l.unchecked(addr) {
+				continue
+			}
 
-func (l *Ledger) FindTransactions(ctx context.Context, m ...query.QueryModifier) (query.Cursor, error) {
-	q := query.New(m)
-	c, err := l.store.FindTransactions(ctx, q)
+			for asset, amount := range net[addr] {
+				if amount <= 0 {
+					continue
+				}
 
-	return c, err
-}
+				f, ok := accounts[addr]
+				if !ok {
+					balances, err := l.store.AggregateBalances(ctx, addr)
+					if err != nil {
+						return err
+					}
+					held, _, err := l.heldAmounts(ctx, addr, capture)
+					if err != nil {
+						return err
+					}
+					f = &funds{
+						balances: balances,
+						held:     held,
+					}
+					accounts[addr] = f
+				}
 
-func (l *Ledger) GetTransaction(ctx context.Context, id string) (core.Transaction, error) {
-	tx, err := l.store.GetTransaction(ctx, id)
+				// The balance of the account once the previous transactions of the batch are applied
+				balance := f.balances[asset] - debited[addr][asset] + amount
+				if balance-f.held[asset] >= amount {
+					continue
+				}
+				// The overdraft limits are only read when the balances don't suffice
+				if f.overdrafts == nil {
+					var err error
+					f.overdrafts, err = l.overdrafts(ctx, addr)
+					if err != nil {
+						return err
+					}
+				}
+				if limit := f.overdrafts.limit(asset); balance-f.held[asset]+limit < amount {
+					return &TransactionError{
+						Index: i,
+						Err: &InsufficientFundsError{
+							Account:   addr,
+							Asset:     asset,
+							Needed:    amount,
+							Available: balance,
+							Overdraft: limit,
+							Held:      f.held[asset],
+						},
+					}
+				}
+			}
+		}
+	}
 
-	return tx, err
+	return nil
 }
 
-func (l *Ledger) RevertTransaction(ctx context.Context, id string) error {
-	tx, err := l.store.GetTransaction(ctx, id)
-	if err != nil {
-		return err
-	}
-
-	lastTransaction, err := l.store.LastTransaction(ctx)
-	if err != nil {
-		return err
+// unchecked reports whether the balances of an account are not checked, see WithUncheckedAccounts
+func (l *Ledger) unchecked(address string) bool {
+	for _, account := range l.uncheckedAccounts {
+		if prefix, ok := query.AccountPattern(account); ok {
+			if strings.HasPrefix(address, prefix) {
+				return true
+			}
+			continue
+		}
+		if address == account {
+			return true
+		}
 	}
+	return false
+}
 
-	rt := tx.Reverse()
-	rt.Metadata = core.Metadata{}
-	rt.Metadata.MarkRevertedBy(fmt.Sprint(lastTransaction.ID))
-	_, err = l.Commit(ctx, []core.Transaction{rt})
-
-	return err
+type CommitOptions struct {
+	// IdempotencyKey, if set, is recorded along with the transactions.
+	// Committing again with the same key returns the originally committed transactions
+	// instead of committing the batch again, until the key expires.
+	IdempotencyKey string
+	// ClampTimestamps makes Commit replace a caller supplied timestamp older than the previous
+	// transaction by the previous transaction timestamp, instead of rejecting the batch.
+	ClampTimestamps bool
+	// AllowNoop makes Commit accept the postings which source and destination are the same account,
+	// which are rejected by default.
+	AllowNoop bool
+	// RequireExistingAccounts makes Commit reject the postings crediting an account
+	// which has not been created with CreateAccount. Accounts are implicitly created otherwise.
+	RequireExistingAccounts bool
+	// StrictAssets makes Commit reject the postings using an asset which hasn't been registered
+	// with RegisterAsset, with its registered scale. Any asset is accepted otherwise.
+	StrictAssets bool
+	// AllowNegativeAmounts makes Commit accept the postings with a negative amount, sending the amount
+	// from the destination to the source. They are stored normalized, with their source and destination swapped
+	// and a positive amount, so that the hashes and the volumes don't depend on how the posting was sent.
+	// Zero amounts are still rejected.
+	AllowNegativeAmounts bool
+	// Preconditions are asserted on the balances of the accounts before the batch, along with the checks
+	// of the funds: Commit returns a PreconditionError, committing none of the transactions, if one doesn't hold.
+	Preconditions []BalancePrecondition
+	// reverts is the id of the transaction reverted by the committed transaction
+	reverts string
+	// partialRevert makes the committed transaction revert a part of the postings of the transaction reverts
+	partialRevert bool
+	// bulkReverts are the ids of the transactions reverted by the committed transactions, by index
+	bulkReverts []string
+	// keepAssets commits the asset codes as is, even if the ledger normalizes them
+	keepAssets bool
+	// capture is the hold captured by the committed transaction
+	capture *core.Hold
 }
 
-func (l *Ledger) FindAccounts(ctx context.Context, m ...query.QueryModifier) (query.Cursor, error) {
-	q := query.New(m)
+func (l *Ledger) Commit(ctx context.Context, ts []core.Transaction) ([]core.Transaction, error) {
+	return l.CommitWithOptions(ctx, ts, CommitOptions{})
+}
 
-	c, err := l.store.FindAccounts(ctx, q)
+// CommitWithKey commits the transactions like Commit, recording the idempotency key along with them.
+func (l *Ledger) CommitWithKey(ctx context.Context, key string, ts []core.Transaction) ([]core.Transaction, error) {
+	if key == "" {
+		return nil, newValidationError("empty idempotency key")
+	}
 
-	return c, err
+	return l.CommitWithOptions(ctx, ts, CommitOptions{
+		IdempotencyKey: key,
+	})
 }
 
-func (l *Ledger) GetAccount(ctx context.Context, address string) (core.Account, error) {
-	account := core.Account{
-		Address:  address,
-		Contract: "default",
+func (l *Ledger) CommitWithOptions(ctx context.Context, ts []core.Transaction, opts CommitOptions) ([]core.Transaction, error) {
+	// The writes depend on the balances and ids read beforehand, which must not come from a lagging replica
+	ctx = storage.WithConsistentRead(ctx)
+	ctx = storage.WithReferenceScope(ctx, l.referenceScope)
+	if l.normalizeAssets && !opts.keepAssets {
+		ts = normalizeAssets(ts)
+		opts.Preconditions = normalizePreconditions(opts.Preconditions)
+	}
+	if opts.AllowNegativeAmounts {
+		ts = normalizeAmounts(ts)
 	}
 
-	balances, err := l.store.AggregateBalances(ctx, address)
-
-	if err != nil {
-		return account, err
+	postings := 0
+	assets := map[string]struct{}{}
+	for _, t := range ts {
+		postings += len(t.Postings)
+		for _, p := range t.Postings {
+			assets[p.Asset] = struct{}{}
+		}
 	}
+	assetSet := make([]string, 0, len(assets))
+	for asset := range assets {
+		assetSet = append(assetSet, asset)
+	}
+	sort.Strings(assetSet)
 
-	account.Balances = balances
+	ctx, span := tracer.Start(ctx, "Commit", trace.WithAttributes(
+		attribute.String("ledger", l.name),
+		attribute.Int("transactions", len(ts)),
+		attribute.Int("postings", postings),
+		attribute.StringSlice("assets", assetSet),
+	))
+	defer span.End()
 
-	volumes, err := l.store.AggregateVolumes(ctx, address)
+	if l.commitTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, l.commitTimeout)
+		defer cancel()
+	}
 
+	ts, err := l.commit(ctx, ts, opts)
+	if ctxErr := ctx.Err(); err != nil && ctxErr != nil {
+		// The store reports the expired context in its own way, like an interrupted query,
+		// nothing has been saved in any case
+		err = ctxErr
+	}
 	if err != nil {
-		return account, err
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return ts, err
 	}
+	recordTransactions(ctx, ts)
+	return ts, nil
+}
 
-	account.Volumes = volumes
+// normalizeAmounts returns a copy of the transactions with the postings of negative amounts
+// turned into postings of the opposite amount, from their destination to their source
+func normalizeAmounts(ts []core.Transaction) []core.Transaction {
+	normalized := make([]core.Transaction, len(ts))
+	for i, t := range ts {
+		postings := make(core.Postings, len(t.Postings))
+		for j, p := range t.Postings {
+			if p.Amount < 0 {
+				p.Source, p.Destination = p.Destination, p.Source
+				p.Amount = -p.Amount
+			}
+			postings[j] = p
+		}
+		t.Postings = postings
+		normalized[i] = t
+	}
+	return normalized
+}
 
-	meta, err := l.store.GetMeta(ctx, "account", address)
+func (l *Ledger) commit(ctx context.Context, ts []core.Transaction, opts CommitOptions) ([]core.Transaction, error) {
+	if max := l.limits.MaxTransactionsPerBatch; max > 0 && len(ts) > max {
+		return nil, fmt.Errorf("%w: %d transactions, the limit is %d", ErrBatchTooLarge, len(ts), max)
+	}
+
+	err := core.ValidateTransactions(ts, core.ValidationOptions{
+		AllowNoop:           opts.AllowNoop,
+		MaxPostings:         l.limits.MaxPostingsPerTransaction,
+		ConversionTolerance: l.conversionTolerance,
+	})
 	if err != nil {
-		return account, err
+		return nil, err
+	}
+	err = validatePreconditions(opts.Preconditions)
+	if err != nil {
+		return nil, err
 	}
-	account.Metadata = meta
 
-	return account, nil
-}
+	defer l.metrics.ObserveOperation(l.name, "commit", time.Now())
 
-func (l *Ledger) SaveMeta(ctx context.Context, targetType string, targetID string, m core.Metadata) error {
 	unlock, err := l.locker.Lock(l.name)
 	if err != nil {
-		return errors.Wrap(err, "unable to acquire lock")
+		return nil, errors.Wrap(err, "unable to acquire lock")
 	}
 	defer unlock()
 
-	if targetType == "" {
-		return errors.New("empty target type")
-	}
-	if targetType != targetTypeTransaction && targetType != targetTypeAccount {
-		return fmt.Errorf("unknown target type '%s'", targetType)
-	}
-	if targetID == "" {
-		return errors.New("empty target id")
+	if opts.IdempotencyKey != "" {
+		committed, err := l.committedWithKey(ctx, opts.IdempotencyKey)
+		if err != nil {
+			return nil, err
+		}
+		if committed != nil {
+			return committed, nil
+		}
 	}
 
-	lastMetaID, err := l.store.LastMetaID(ctx)
-	if err != nil {
-		return err
-	}
+	// The lock only guards against the commits of this process, other processes sharing the store
+	// may commit concurrently. The store then fails with a conflict and the balances are checked again.
+	input := append([]core.Transaction{}, ts...)
+	for attempt := 0; ; attempt++ {
+		copy(ts, input)
 
-	timestamp := time.Now().Format(time.RFC3339)
+		// The amounts already reverted are read again on each attempt, so that the partial reverts
+		// committed concurrently are accounted for. The reference of the partial revert is numbered
+		// after them, it must be set before the transaction is hashed.
+		var partial partialRevert
+		if opts.partialRevert {
+			partial, err = l.checkPartialRevert(ctx, opts.reverts, &ts[0])
+			if err != nil {
+				return ts, err
+			}
+		}
 
-	for key, value := range m {
-		lastMetaID++
+		var deltas map[string]map[string]int64
+		var c chain
+		deltas, c, err = l.process(ctx, ts, opts)
 
-		err := l.store.SaveMeta(
-			ctx,
-			lastMetaID,
-			timestamp,
-			targetType,
-			targetID,
-			key,
-			string(value),
-		)
+		// The reverted transaction is checked and marked along with the reverting transaction,
+		// a concurrent revert makes the save fail with a conflict and the check run again.
+		// It is checked after the balances, so that a concurrent revert committed in between
+		// is reported as such rather than as an insufficient balance.
+		if opts.reverts != "" && !opts.partialRevert && !errors.Is(err, storage.ErrConflict) {
+			if revertErr := l.checkRevert(ctx, opts.reverts); revertErr != nil {
+				err = revertErr
+			}
+		}
+		if opts.bulkReverts != nil && !errors.Is(err, storage.ErrConflict) {
+			for i, id := range opts.bulkReverts {
+				if revertErr := l.checkRevert(ctx, id); revertErr != nil {
+					err = &TransactionError{
+						Index: i,
+						Err:   revertErr,
+					}
+					break
+				}
+			}
+		}
+		// Likewise, the captured hold is checked and marked along with the transaction capturing it
+		if opts.capture != nil && !errors.Is(err, storage.ErrConflict) {
+			if holdErr := l.checkCapture(ctx, opts.capture.ID); holdErr != nil {
+				err = holdErr
+			}
+		}
 
 		if err != nil {
-			return err
+			// The reads may also conflict with the writes of other processes
+			if errors.Is(err, storage.ErrConflict) && attempt < l.commitRetries {
+				continue
+			}
+			if errors.Is(err, ErrInsufficientFunds) {
+				l.metrics.InsufficientFunds(l.name)
+			}
+			return ts, err
+		}
+
+		// The transactions are saved only if none was saved since the one they are chained to
+		saveCtx := storage.WithPreviousTransaction(ctx, c.last)
+		switch {
+		case opts.IdempotencyKey != "":
+			err = l.store.SaveTransactionsWithKey(saveCtx, opts.IdempotencyKey, ts)
+		case opts.partialRevert:
+			err = l.store.SaveTransactionsWithMeta(saveCtx, ts, partiallyRevertedMeta(opts.reverts, ts[0], partial))
+		case opts.reverts != "":
+			err = l.store.SaveTransactionsWithMeta(saveCtx, ts, revertedMeta(opts.reverts, ts[0]))
+		case opts.bulkReverts != nil:
+			meta := make([]storage.MetaEntry, 0, len(ts))
+			for i, id := range opts.bulkReverts {
+				meta = append(meta, revertedMeta(id, ts[i])...)
+			}
+			err = l.store.SaveTransactionsWithMeta(saveCtx, ts, meta)
+		case opts.capture != nil:
+			err = l.store.SaveTransactionsWithMeta(saveCtx, ts, capturedMeta(*opts.capture, ts[0]))
+		default:
+			err = l.store.SaveTransactions(saveCtx, ts)
+		}
+		if err == nil {
+			if len(ts) > 0 {
+				postings := 0
+				for _, t := range ts {
+					postings += len(t.Postings)
+				}
+				l.metrics.Committed(l.name, postings, c.count+int64(len(ts)))
+			}
+
+			l.bus.publish(core.CommittedTransactions{
+				Ledger:       l.name,
+				Transactions: append([]core.Transaction{}, ts...),
+				Balances:     deltas,
+			})
+			return ts, nil
+		}
+		if !errors.Is(err, storage.ErrConflict) {
+			return nil, err
+		}
+		if attempt >= l.commitRetries {
+			return nil, fmt.Errorf("giving up after %d attempts: %w", attempt+1, err)
 		}
 	}
-	return nil
+}
+
+// committedWithKey returns the transactions committed with the given idempotency key,
+// or nil if the key is unknown or has expired, the TTL running from the time the store saved the key.
+func (l *Ledger) committedWithKey(ctx context.Context, key string) ([]core.Transaction, error) {
+	ik, err := l.store.GetIdempotencyKey(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if ik == nil {
+		return nil, nil
+	}
+
+	committedAt, err := time.Parse(time.RFC3339, ik.Timestamp)
+	if err != nil {
+		return nil, err
+	}
+	if time.Since(committedAt) >= l.idempotencyKeyTTL {
+		return nil, nil
+	}
+
+	committed := make([]core.Transaction, 0)
+	err = l.walkTransactions(ctx, ik.FirstTxID-1, func(tx core.Transaction) (bool, error) {
+		if tx.ID > ik.LastTxID {
+			return false, nil
+		}
+		committed = append(committed, tx)
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return committed, nil
+}
+
+// CommitPreview validates the transactions as Commit would, without saving them,
+// and returns the balance delta of each account, keyed by address then asset.
+func (l *Ledger) CommitPreview(ctx context.Context, ts []core.Transaction) (map[string]map[string]int64, error) {
+	ctx = storage.WithConsistentRead(ctx)
+	err := core.ValidateTransactions(ts, core.ValidationOptions{
+		ConversionTolerance: l.conversionTolerance,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	unlock, err := l.locker.Lock(l.name)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to acquire lock")
+	}
+	defer unlock()
+
+	txs := make([]core.Transaction, len(ts))
+	copy(txs, ts)
+	if l.normalizeAssets {
+		txs = normalizeAssets(txs)
+	}
+
+	deltas, _, err := l.process(ctx, txs, CommitOptions{})
+	return deltas, err
+}
+
+func (l *Ledger) GetLastTransaction(ctx context.Context) (core.Transaction, error) {
+	var tx core.Transaction
+
+	q := query.New()
+	q.Modify(query.Limit(1))
+
+	c, err := l.store.FindTransactions(ctx, q)
+
+	if err != nil {
+		return tx, err
+	}
+
+	txs := (c.Data).([]core.Transaction)
+
+	if len(txs) == 0 {
+		return tx, nil
+	}
+
+	tx = txs[0]
+
+	return tx, nil
+}
+
+// Head returns the id and the hash of the last transaction along with the number of transactions, without reading
+// the transaction itself, so that the chain can be watched cheaply: the hash of an id never changes unless the ledger
+// has been tampered with. The id is -1 if there is no transaction.
+func (l *Ledger) Head(ctx context.Context) (txid int64, hash string, count int64, err error) {
+	txid, hash, err = l.store.Head(ctx)
+	if err != nil {
+		return 0, "", 0, err
+	}
+	if txid < 0 {
+		return txid, hash, 0, nil
+	}
+
+	// The ids are sparse unless generated by SequenceGenerator
+	count, err = l.store.CountTransactions(ctx)
+	if err != nil {
+		return 0, "", 0, err
+	}
+	return txid, hash, count, nil
+}
+
+// pageQuery builds the query of a page of a list, of the default page size of the ledger
+// unless limited by m, and clamped to its maximum page size
+func (l *Ledger) pageQuery(m []query.QueryModifier) query.Query {
+	def, max := l.limits.PageSizes()
+	q := query.New([]query.QueryModifier{query.Limit(def)}, m)
+	if q.Limit > max {
+		q.Limit = max
+	}
+	return q
+}
+
+// FindTransactions returns a page of the transactions matching all the filters, sorted by id
+// in descending order unless sorted with query.SortTransactions
+func (l *Ledger) FindTransactions(ctx context.Context, m ...query.QueryModifier) (query.Cursor, error) {
+	defer l.metrics.ObserveOperation(l.name, "find_transactions", time.Now())
+
+	q := l.pageQuery(m)
+	if _, ok := q.Params["sort"]; ok && q.Sorting().By != query.SortByTxID {
+		return query.Cursor{}, newValidationError("the transactions can only be sorted by %s", query.SortByTxID)
+	}
+	if l.normalizeAssets && q.HasParam("asset") {
+		q.Params["asset"] = strings.ToUpper(q.Params["asset"].(string))
+	}
+	c, err := l.store.FindTransactions(ctx, q)
+	if err != nil {
+		return c, err
+	}
+
+	txs := c.Data.([]core.Transaction)
+	keysets := make([]string, len(txs))
+	for i, tx := range txs {
+		keysets[i] = fmt.Sprint(tx.ID)
+	}
+	l.paginate(q, &c, keysets)
+
+	return c, nil
+}
+
+// paginate sets the tokens of the pages surrounding a cursor, given the keysets of its items.
+// A previous page token is always set when the page has items, as newer items may have been
+// committed since: going previous from the first page returns an empty page.
+// An empty previous page keeps pointing to the same place so that it can be polled.
+func (l *Ledger) paginate(q query.Query, c *query.Cursor, keysets []string) {
+	// The pages keep the order of the query
+	var sorting *query.Sort
+	if s, ok := q.Params["sort"].(query.Sort); ok {
+		sorting = &s
+	}
+
+	if len(keysets) == 0 {
+		if q.Before != "" {
+			c.Previous = query.Token{
+				Ledger:   l.name,
+				Before:   q.Before,
+				PageSize: c.PageSize,
+				Sort:     sorting,
+			}.Encode()
+		}
+		return
+	}
+
+	c.Previous = query.Token{
+		Ledger:   l.name,
+		Before:   keysets[0],
+		PageSize: c.PageSize,
+		Sort:     sorting,
+	}.Encode()
+
+	// When going backward, the page we come from follows this one
+	if c.HasMore || q.Before != "" {
+		c.Next = query.Token{
+			Ledger:   l.name,
+			After:    keysets[len(keysets)-1],
+			PageSize: c.PageSize,
+			Sort:     sorting,
+		}.Encode()
+	}
+}
+
+// GetTransaction returns the transaction with the given id, or ErrTransactionNotFound
+func (l *Ledger) GetTransaction(ctx context.Context, id string) (core.Transaction, error) {
+	tx, err := l.store.GetTransaction(ctx, id)
+	if err != nil {
+		return tx, err
+	}
+	if len(tx.Postings) == 0 {
+		return tx, fmt.Errorf("%w: %s", ErrTransactionNotFound, id)
+	}
+
+	return tx, nil
+}
+
+// GetTransactionByHash returns the transaction with the given hash.
+// It returns ErrTransactionNotFound if no transaction has the hash.
+func (l *Ledger) GetTransactionByHash(ctx context.Context, hash string) (core.Transaction, error) {
+	tx, err := l.store.GetTransactionByHash(ctx, hash)
+	if err != nil {
+		return tx, err
+	}
+	if len(tx.Postings) == 0 {
+		return tx, fmt.Errorf("%w: %s", ErrTransactionNotFound, hash)
+	}
+
+	return tx, nil
+}
+
+func (l *Ledger) RevertTransaction(ctx context.Context, id string) error {
+	ctx = storage.WithConsistentRead(ctx)
+	tx, err := l.store.GetTransaction(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	return l.revert(ctx, tx)
+}
+
+// RevertTransactionByReference reverts the transaction with the given reference.
+// It returns ErrTransactionNotFound if no transaction has the reference.
+func (l *Ledger) RevertTransactionByReference(ctx context.Context, ref string) error {
+	ctx = storage.WithConsistentRead(ctx)
+	if ref == "" {
+		return newValidationError("empty reference")
+	}
+
+	c, err := l.store.FindTransactions(ctx, query.New([]query.QueryModifier{
+		query.Reference(ref),
+		query.Limit(2),
+	}))
+	if err != nil {
+		return err
+	}
+
+	txs := c.Data.([]core.Transaction)
+	switch {
+	case len(txs) == 0:
+		return fmt.Errorf("%w: %s", ErrTransactionNotFound, ref)
+	case len(txs) > 1:
+		return fmt.Errorf("multiple transactions with reference %s", ref)
+	}
+
+	return l.revert(ctx, txs[0])
+}
+
+// revert commits a transaction reversing the postings of tx, its reference being prefixed by "revert_".
+// The reverted transaction is marked with the id of the reverting transaction and can't be reverted again.
+func (l *Ledger) revert(ctx context.Context, tx core.Transaction) error {
+	if len(tx.Postings) == 0 {
+		return fmt.Errorf("%w: %d", ErrTransactionNotFound, tx.ID)
+	}
+
+	rt := tx.Reverse()
+	rt.Metadata = core.Metadata{}
+	rt.Metadata.MarkReverts(fmt.Sprint(tx.ID))
+	_, err := l.CommitWithOptions(ctx, []core.Transaction{rt}, CommitOptions{
+		reverts: fmt.Sprint(tx.ID),
+	})
+
+	return err
+}
+
+// checkRevert checks that the transaction id can be reverted
+func (l *Ledger) checkRevert(ctx context.Context, id string) error {
+	tx, err := l.store.GetTransaction(ctx, id)
+	if err != nil {
+		return err
+	}
+	if tx.Metadata.IsReverted() {
+		return fmt.Errorf("%w: %s", ErrAlreadyReverted, id)
+	}
+	if tx.Metadata.IsRevert() {
+		return fmt.Errorf("%w: %s", ErrRevertOfRevert, id)
+	}
+	// The remaining amounts of a partially reverted transaction can only be reverted partially
+	if tx.Metadata.IsPartiallyReverted() {
+		return fmt.Errorf("%w: %s is partially reverted", ErrAlreadyReverted, id)
+	}
+	return nil
+}
+
+// PartialRevert commits a transaction reverting a part of the transaction id, like the refund of a part of a payment.
+// Each posting must reverse a posting of the transaction, from its destination to its source in the same asset,
+// and the partial reverts of the transaction can't revert more than its amounts in total. The reference
+// of the reverting transaction is partial_revert_<id>_<n>, n counting the partial reverts of the transaction.
+// The transaction is marked as reverted once its amounts have been reverted entirely.
+func (l *Ledger) PartialRevert(ctx context.Context, id string, postings []core.Posting) error {
+	if len(postings) == 0 {
+		return newValidationError("no postings to revert")
+	}
+
+	rt := core.Transaction{
+		Postings: append(core.Postings{}, postings...),
+		Metadata: core.Metadata{},
+	}
+	rt.Metadata.MarkReverts(id)
+	_, err := l.CommitWithOptions(ctx, []core.Transaction{rt}, CommitOptions{
+		reverts:       id,
+		partialRevert: true,
+	})
+
+	return err
+}
+
+// partialRevert is the state of the partial reverts of a transaction, including the one being committed
+type partialRevert struct {
+	// ids are the ids of the previous partial reverts
+	ids []string
+	// reverted are the postings reverted in total, by reversed posting of the transaction
+	reverted core.Postings
+	// complete is set when the amounts of the transaction are entirely reverted
+	complete bool
+}
+
+// checkPartialRevert checks that the postings of rt can be reverted from the transaction id,
+// sets the reference of rt and returns the partial reverts of the transaction including rt
+func (l *Ledger) checkPartialRevert(ctx context.Context, id string, rt *core.Transaction) (partialRevert, error) {
+	tx, err := l.store.GetTransaction(ctx, id)
+	if err != nil {
+		return partialRevert{}, err
+	}
+	switch {
+	case len(tx.Postings) == 0:
+		return partialRevert{}, fmt.Errorf("%w: %s", ErrTransactionNotFound, id)
+	case tx.Metadata.IsRevert():
+		return partialRevert{}, fmt.Errorf("%w: %s", ErrRevertOfRevert, id)
+	case tx.Metadata.IsReverted():
+		return partialRevert{}, fmt.Errorf("%w: %s", ErrAlreadyReverted, id)
+	}
+
+	ids, reverted, err := tx.Metadata.PartialReverts()
+	if err != nil {
+		return partialRevert{}, err
+	}
+
+	// The amounts are summed by reversed posting, in the order of the postings of the transaction
+	type key struct {
+		source, destination, asset string
+	}
+	keys := make([]key, 0)
+	available := map[key]int64{}
+	for _, p := range tx.Postings {
+		k := key{source: p.Destination, destination: p.Source, asset: p.Asset}
+		if _, ok := available[k]; !ok {
+			keys = append(keys, k)
+		}
+		available[k] += p.Amount
+	}
+	total := map[key]int64{}
+	for _, p := range reverted {
+		total[key{source: p.Source, destination: p.Destination, asset: p.Asset}] += p.Amount
+	}
+	for i, p := range rt.Postings {
+		k := key{source: p.Source, destination: p.Destination, asset: p.Asset}
+		if _, ok := available[k]; !ok {
+			return partialRevert{}, fmt.Errorf("%w: posting %d doesn't reverse a posting of transaction %s", ErrRevertExceeded, i, id)
+		}
+		total[k] += p.Amount
+		if total[k] > available[k] {
+			return partialRevert{}, fmt.Errorf("%w: %d %s from %s to %s reverted out of %d", ErrRevertExceeded,
+				total[k], k.asset, k.source, k.destination, available[k])
+		}
+	}
+
+	state := partialRevert{
+		ids:      ids,
+		reverted: make(core.Postings, 0, len(keys)),
+		complete: true,
+	}
+	for _, k := range keys {
+		if total[k] < available[k] {
+			state.complete = false
+		}
+		if total[k] > 0 {
+			state.reverted = append(state.reverted, core.Posting{
+				Source:      k.source,
+				Destination: k.destination,
+				Amount:      total[k],
+				Asset:       k.asset,
+			})
+		}
+	}
+
+	rt.Reference = fmt.Sprintf("partial_revert_%s_%d", id, len(ids)+1)
+
+	return state, nil
+}
+
+// partiallyRevertedMeta returns the metadata entries marking the transaction id as partially reverted by rt,
+// and as reverted by rt if its amounts are entirely reverted
+func partiallyRevertedMeta(id string, rt core.Transaction, state partialRevert) []storage.MetaEntry {
+	meta := core.Metadata{}
+	meta.MarkPartiallyReverted(append(state.ids, fmt.Sprint(rt.ID)), state.reverted)
+	if state.complete {
+		meta.MarkRevertedBy(fmt.Sprint(rt.ID))
+	}
+
+	entries := make([]storage.MetaEntry, 0, len(meta))
+	for key, value := range meta {
+		entries = append(entries, storage.MetaEntry{
+			Timestamp:  rt.Timestamp,
+			TargetType: targetTypeTransaction,
+			TargetID:   id,
+			Key:        key,
+			Value:      string(value),
+		})
+	}
+
+	return entries
+}
+
+// revertedMeta returns the metadata entries marking the transaction id as reverted by rt
+func revertedMeta(id string, rt core.Transaction) []storage.MetaEntry {
+	meta := core.Metadata{}
+	meta.MarkRevertedBy(fmt.Sprint(rt.ID))
+
+	entries := make([]storage.MetaEntry, 0, len(meta))
+	for key, value := range meta {
+		entries = append(entries, storage.MetaEntry{
+			Timestamp:  rt.Timestamp,
+			TargetType: targetTypeTransaction,
+			TargetID:   id,
+			Key:        key,
+			Value:      string(value),
+		})
+	}
+
+	return entries
+}
+
+// FindAccounts returns a page of accounts, sorted by address in descending order unless sorted with
+// query.SortAccounts. When sorted by balance, the balances are pinned to the last transaction at the
+// time of the first page, so that the next pages follow the same order whatever is committed meanwhile,
+// and the accounts come with their balance in the asset of the sort.
+func (l *Ledger) FindAccounts(ctx context.Context, m ...query.QueryModifier) (query.Cursor, error) {
+	q := l.pageQuery(m)
+
+	sorting := q.Sorting()
+	switch sorting.By {
+	case query.SortByAddress:
+	case query.SortByBalance:
+		if sorting.Asset == "" {
+			return query.Cursor{}, newValidationError("an asset is required to sort the accounts by balance")
+		}
+		if l.normalizeAssets {
+			sorting.Asset = strings.ToUpper(sorting.Asset)
+		}
+		if sorting.TxID == nil {
+			last, err := l.store.LastTransaction(ctx)
+			if err != nil {
+				return query.Cursor{}, err
+			}
+			txid := int64(-1)
+			if last != nil {
+				txid = last.ID
+			}
+			sorting.TxID = &txid
+		}
+		query.SortAccounts(sorting)(&q)
+	default:
+		return query.Cursor{}, newValidationError("invalid sort %q: expected %q or %q",
+			sorting.By, query.SortByAddress, query.SortByBalance)
+	}
+
+	c, err := l.store.FindAccounts(ctx, q)
+	if err != nil {
+		return c, err
+	}
+
+	accounts := c.Data.([]core.Account)
+	keysets := make([]string, len(accounts))
+	for i, account := range accounts {
+		if sorting.By == query.SortByBalance {
+			keysets[i] = query.BalanceKeyset(account.Balances[sorting.Asset], account.Address)
+		} else {
+			keysets[i] = account.Address
+		}
+	}
+	l.paginate(q, &c, keysets)
+
+	return c, nil
+}
+
+func (l *Ledger) GetAccount(ctx context.Context, address string) (core.Account, error) {
+	defer l.metrics.ObserveOperation(l.name, "get_account", time.Now())
+
+	account := core.Account{
+		Address:  address,
+		Contract: "default",
+	}
+
+	balances, err := l.store.AggregateBalances(ctx, address)
+
+	if err != nil {
+		return account, err
+	}
+
+	account.Balances = balances
+	account.Scales = map[string]int{}
+	for asset := range balances {
+		_, account.Scales[asset] = core.AssetScale(asset)
+	}
+
+	volumes, err := l.store.AggregateVolumes(ctx, address)
+
+	if err != nil {
+		return account, err
+	}
+
+	account.Volumes = volumes
+
+	meta, err := l.store.GetMeta(ctx, "account", address)
+	if err != nil {
+		return account, err
+	}
+	account.Metadata = meta
+
+	account.MetadataVersion, err = l.store.GetMetaVersion(ctx, "account", address)
+	if err != nil {
+		return account, err
+	}
+
+	return account, nil
+}
+
+// GetAccounts returns the accounts with the given addresses, in the same order, with their balances
+// and metadata but without their volumes. They are read at once from the store, rather than an account
+// at a time as GetAccount does. The accounts which never appeared are returned with empty balances
+// and nil metadata.
+func (l *Ledger) GetAccounts(ctx context.Context, addresses []string) ([]core.Account, error) {
+	defer l.metrics.ObserveOperation(l.name, "get_accounts", time.Now())
+
+	if len(addresses) > query.MAX_LIMIT {
+		return nil, newValidationError("too many addresses: expected at most %d", query.MAX_LIMIT)
+	}
+
+	accounts, err := l.store.GetAccounts(ctx, addresses)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range accounts {
+		accounts[i].Scales = map[string]int{}
+		for asset := range accounts[i].Balances {
+			_, accounts[i].Scales[asset] = core.AssetScale(asset)
+		}
+	}
+
+	return accounts, nil
+}
+
+// GetAccountAt returns an account as it was at a point in time: its balances and volumes only take
+// into account the transactions with a timestamp at or before at. As timestamps are monotonic along ids,
+// these are the transactions up to the last one committed at or before at, so that transactions sharing
+// a timestamp are ordered by id. Timestamps have a second precision. The metadata are the current ones.
+func (l *Ledger) GetAccountAt(ctx context.Context, address string, at time.Time) (core.Account, error) {
+	defer l.metrics.ObserveOperation(l.name, "get_account_at", time.Now())
+
+	account := core.Account{
+		Address:  address,
+		Contract: "default",
+		Balances: map[string]int64{},
+		Scales:   map[string]int{},
+		Volumes:  map[string]map[string]int64{},
+	}
+
+	q := query.New()
+	q.Modify(query.Limit(1))
+	q.Modify(query.TimestampBefore(at.Truncate(time.Second).Add(time.Second)))
+	c, err := l.store.FindTransactions(ctx, q)
+	if err != nil {
+		return account, err
+	}
+
+	if txs := c.Data.([]core.Transaction); len(txs) > 0 {
+		volumes, err := l.store.AggregateVolumesAt(ctx, address, txs[0].ID)
+		if err != nil {
+			return account, err
+		}
+
+		account.Volumes = volumes
+		for asset := range volumes {
+			account.Balances[asset] = volumes[asset]["input"] - volumes[asset]["output"]
+			_, account.Scales[asset] = core.AssetScale(asset)
+		}
+	}
+
+	meta, err := l.store.GetMeta(ctx, "account", address)
+	if err != nil {
+		return account, err
+	}
+	account.Metadata = meta
+
+	return account, nil
+}
+
+// CreateAccount explicitly creates an account, with optional initial metadata.
+// The account can then be credited by the commits made with CommitOptions.RequireExistingAccounts.
+// Creating an account again merges the metadata as SaveMeta does.
+func (l *Ledger) CreateAccount(ctx context.Context, address string, m core.Metadata) error {
+	ctx = storage.WithConsistentRead(ctx)
+	unlock, err := l.locker.Lock(l.name)
+	if err != nil {
+		return errors.Wrap(err, "unable to acquire lock")
+	}
+	defer unlock()
+
+	err = validateMeta(targetTypeAccount, address, m)
+	if err != nil {
+		return err
+	}
+	err = l.checkMetaSchema(ctx, targetTypeAccount, address, m, nil)
+	if err != nil {
+		return err
+	}
+
+	meta := core.Metadata{}
+	for key, value := range m {
+		meta[key] = value
+	}
+	meta.MarkCreated()
+
+	lastMetaID, err := l.store.LastMetaID(ctx)
+	if err != nil {
+		return err
+	}
+
+	timestamp := time.Now().Format(time.RFC3339)
+	entries := make([]storage.MetaEntry, 0, len(meta))
+	for key, value := range meta {
+		lastMetaID++
+		entries = append(entries, storage.MetaEntry{
+			ID:         lastMetaID,
+			Timestamp:  timestamp,
+			TargetType: targetTypeAccount,
+			TargetID:   address,
+			Key:        key,
+			Value:      string(value),
+		})
+	}
+
+	return l.store.SaveMetaBatch(ctx, entries)
+}
+
+// GetAccountBalance returns the balance of an account for a single asset.
+// It returns 0 if the account has never touched the asset, and ErrAccountNotFound
+// if the account has never appeared in any posting.
+func (l *Ledger) GetAccountBalance(ctx context.Context, address, asset string) (int64, error) {
+	exists, err := l.store.AccountExists(ctx, address)
+	if err != nil {
+		return 0, err
+	}
+	if !exists {
+		return 0, ErrAccountNotFound
+	}
+	if l.normalizeAssets {
+		asset = strings.ToUpper(asset)
+	}
+
+	return l.store.AggregateBalance(ctx, address, asset)
+}
+
+// FlowBetween returns the amount of an asset sent from source to destination by the transactions with
+// a timestamp from from included to to excluded, for the settlement of the two accounts. The zero times
+// leave the period open. The amounts sent back from destination to source are not deducted, see NetFlowBetween.
+func (l *Ledger) FlowBetween(ctx context.Context, source, destination, asset string, from, to time.Time) (int64, error) {
+	if !from.IsZero() && !to.IsZero() && !from.Before(to) {
+		return 0, newValidationError("invalid period from %s to %s: expected a start before the end",
+			from.UTC().Format(time.RFC3339), to.UTC().Format(time.RFC3339))
+	}
+	if l.normalizeAssets {
+		asset = strings.ToUpper(asset)
+	}
+
+	return l.store.AggregateFlow(ctx, source, destination, asset, from, to)
+}
+
+// NetFlowBetween returns the amount of an asset sent from source to destination over a period, like FlowBetween,
+// minus the amount sent back from destination to source. It is negative if destination sent more than it received.
+func (l *Ledger) NetFlowBetween(ctx context.Context, source, destination, asset string, from, to time.Time) (int64, error) {
+	flow, err := l.FlowBetween(ctx, source, destination, asset, from, to)
+	if err != nil {
+		return 0, err
+	}
+	reverse, err := l.FlowBetween(ctx, destination, source, asset, from, to)
+	if err != nil {
+		return 0, err
+	}
+
+	return flow - reverse, nil
+}
+
+// AggregateBalances sums, per asset, the balances of all the accounts matching the query
+func (l *Ledger) AggregateBalances(ctx context.Context, m ...query.QueryModifier) (map[string]int64, error) {
+	q := query.New(m)
+
+	return l.store.SumBalances(ctx, q)
+}
+
+// SaveMeta merges the metadata into the metadata of a target, whatever their current version
+func (l *Ledger) SaveMeta(ctx context.Context, targetType string, targetID string, m core.Metadata) error {
+	_, err := l.saveMeta(ctx, targetType, targetID, m, nil)
+	return err
+}
+
+// SaveMetaIfVersion merges the metadata into the metadata of a target if they are still at the given version,
+// as returned by GetMetaVersion, and returns ErrMetadataVersionMismatch otherwise.
+// It returns the version of the metadata after the update.
+func (l *Ledger) SaveMetaIfVersion(ctx context.Context, targetType string, targetID string, m core.Metadata, version int64) (int64, error) {
+	return l.saveMeta(ctx, targetType, targetID, m, &version)
+}
+
+func (l *Ledger) saveMeta(ctx context.Context, targetType string, targetID string, m core.Metadata, version *int64) (int64, error) {
+	ctx = storage.WithConsistentRead(ctx)
+	unlock, err := l.locker.Lock(l.name)
+	if err != nil {
+		return 0, errors.Wrap(err, "unable to acquire lock")
+	}
+	defer unlock()
+
+	err = validateMeta(targetType, targetID, m)
+	if err != nil {
+		return 0, err
+	}
+	err = l.checkMetaSchema(ctx, targetType, targetID, m, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(m) == 0 {
+		if version == nil {
+			return 0, nil
+		}
+		current, err := l.store.GetMetaVersion(ctx, targetType, targetID)
+		if err != nil {
+			return 0, err
+		}
+		if current != *version {
+			return 0, ErrMetadataVersionMismatch
+		}
+		return current, nil
+	}
+
+	var current int64
+	if version != nil {
+		// The store compares the version in the same write as it increments it, the other processes sharing
+		// it may update the metadata concurrently
+		ctx = storage.WithMetaVersion(ctx, *version)
+		current = *version
+	}
+
+	for attempt := 0; ; attempt++ {
+		lastMetaID, err := l.store.LastMetaID(ctx)
+		if err != nil {
+			return 0, err
+		}
+
+		timestamp := time.Now().Format(time.RFC3339)
+		entries := make([]storage.MetaEntry, 0, len(m))
+		for key, value := range m {
+			lastMetaID++
+			entries = append(entries, storage.MetaEntry{
+				ID:         lastMetaID,
+				Timestamp:  timestamp,
+				TargetType: targetType,
+				TargetID:   targetID,
+				Key:        key,
+				Value:      string(value),
+			})
+		}
+
+		err = l.store.SaveMetaBatch(ctx, entries)
+		switch {
+		case errors.Is(err, storage.ErrMetaVersionMismatch):
+			return 0, ErrMetadataVersionMismatch
+		case errors.Is(err, storage.ErrConflict) && attempt < l.commitRetries:
+			continue
+		case err != nil:
+			return 0, err
+		}
+		return current + 1, nil
+	}
+}
+
+// GetMetaVersion returns the version of the metadata of a target, incremented by each update of the metadata,
+// and 0 if they have never been updated
+func (l *Ledger) GetMetaVersion(ctx context.Context, targetType string, targetID string) (int64, error) {
+	err := validateMetaTarget(targetType, targetID)
+	if err != nil {
+		return 0, err
+	}
+
+	return l.store.GetMetaVersion(ctx, targetType, targetID)
+}
+
+// DeleteMeta removes the given keys from the metadata of a target. Missing keys are ignored.
+func (l *Ledger) DeleteMeta(ctx context.Context, targetType string, targetID string, keys []string) error {
+	ctx = storage.WithConsistentRead(ctx)
+	unlock, err := l.locker.Lock(l.name)
+	if err != nil {
+		return errors.Wrap(err, "unable to acquire lock")
+	}
+	defer unlock()
+
+	err = validateMetaTarget(targetType, targetID)
+	if err != nil {
+		return err
+	}
+	err = l.checkMetaSchema(ctx, targetType, targetID, nil, keys)
+	if err != nil {
+		return err
+	}
+
+	return l.store.DeleteMeta(ctx, targetType, targetID, keys)
+}
+
+func validateMetaTarget(targetType, targetID string) error {
+	if err := validateMetaTargetType(targetType); err != nil {
+		return err
+	}
+	if targetID == "" {
+		return newValidationError("empty target id")
+	}
+	return nil
+}
+
+func validateMetaTargetType(targetType string) error {
+	if targetType == "" {
+		return newValidationError("empty target type")
+	}
+	if targetType != targetTypeTransaction && targetType != targetTypeAccount {
+		return newValidationError("unknown target type '%s'", targetType)
+	}
+	return nil
+}
+
+// validateMeta checks the target of the metadata, and the value of the keys recognized by the ledger
+func validateMeta(targetType, targetID string, m core.Metadata) error {
+	err := validateMetaTarget(targetType, targetID)
+	if err != nil {
+		return err
+	}
+
+	if value, ok := m[OverdraftMetadataKey]; ok && targetType == targetTypeAccount {
+		if _, ok := parseOverdraft(value); !ok {
+			return newValidationError("invalid '%s' metadata: expected a positive integer, got %s", OverdraftMetadataKey, string(value))
+		}
+	}
+
+	return nil
+}
+
+type MetaUpdate struct {
+	TargetType string        `json:"target_type"`
+	TargetID   string        `json:"target_id"`
+	Metadata   core.Metadata `json:"metadata"`
+}
+
+type MetaUpdateFailure struct {
+	MetaUpdate
+	Error error `json:"-"`
+}
+
+// SaveMetaBatch saves the metadata of many targets at once, with the same merge semantics as SaveMeta.
+// Invalid updates are reported as failures while the valid ones are still saved.
+// If the store rejects the batch, every valid update is reported as failed.
+func (l *Ledger) SaveMetaBatch(ctx context.Context, updates []MetaUpdate) ([]MetaUpdateFailure, error) {
+	ctx = storage.WithConsistentRead(ctx)
+	unlock, err := l.locker.Lock(l.name)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to acquire lock")
+	}
+	defer unlock()
+
+	lastMetaID, err := l.store.LastMetaID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	timestamp := time.Now().Format(time.RFC3339)
+	failures := make([]MetaUpdateFailure, 0)
+	valid := make([]MetaUpdate, 0)
+	entries := make([]storage.MetaEntry, 0)
+
+	for _, u := range updates {
+		err := validateMeta(u.TargetType, u.TargetID, u.Metadata)
+		if err == nil {
+			err = l.checkMetaSchema(ctx, u.TargetType, u.TargetID, u.Metadata, nil)
+		}
+		if err != nil {
+			failures = append(failures, MetaUpdateFailure{
+				MetaUpdate: u,
+				Error:      err,
+			})
+			continue
+		}
+		valid = append(valid, u)
+
+		for key, value := range u.Metadata {
+			lastMetaID++
+			entries = append(entries, storage.MetaEntry{
+				ID:         lastMetaID,
+				Timestamp:  timestamp,
+				TargetType: u.TargetType,
+				TargetID:   u.TargetID,
+				Key:        key,
+				Value:      string(value),
+			})
+		}
+	}
+
+	err = l.store.SaveMetaBatch(ctx, entries)
+	if err != nil {
+		for _, u := range valid {
+			failures = append(failures, MetaUpdateFailure{
+				MetaUpdate: u,
+				Error:      err,
+			})
+		}
+	}
+
+	return failures, nil
 }
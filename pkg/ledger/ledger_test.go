@@ -6,15 +6,26 @@ import (
 	"errors"
 	"flag"
 	"fmt"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
 	"github.com/numary/ledger/pkg/ledgertesting"
 	"github.com/numary/ledger/pkg/storage"
+	"github.com/numary/ledger/pkg/storage/memorystorage"
+	"github.com/numary/ledger/pkg/storage/redisstorage"
 	"github.com/numary/ledger/pkg/storage/sqlstorage"
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 	"math/rand"
 	"os"
 	"reflect"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/numary/ledger/pkg/core"
@@ -101,6 +112,19 @@ func TestMain(m *testing.M) {
 				return pgServer.ConnString()
 			},
 		)
+	case "memory":
+		driver = memorystorage.NewDriver("memory")
+	case "redis":
+		redisServer, err := miniredis.Run()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		defer redisServer.Close()
+
+		driver = redisstorage.NewDriver("redis", &redis.UniversalOptions{
+			Addrs: []string{redisServer.Addr()},
+		})
 	}
 
 	code = m.Run()
@@ -188,235 +212,1226 @@ func TestBalance(t *testing.T) {
 				"balance was insufficient yet the transation was commited",
 			))
 		}
+
+		assert.True(t, errors.Is(err, ErrInsufficientFunds))
+		insufficient := &InsufficientFundsError{}
+		assert.True(t, errors.As(err, &insufficient))
+		assert.Equal(t, &InsufficientFundsError{
+			Account:   "empty_wallet",
+			Asset:     "COIN",
+			Needed:    1,
+			Available: 0,
+		}, insufficient)
 	})
 }
 
-func TestReference(t *testing.T) {
+func TestValidation(t *testing.T) {
 	with(func(l *Ledger) {
-		tx := core.Transaction{
-			Reference: "payment_processor_id_01",
-			Postings: []core.Posting{
-				{
-					Source:      "world",
-					Destination: "payments:001",
-					Amount:      100,
-					Asset:       "COIN",
-				},
-			},
-		}
-
-		_, err := l.Commit(context.Background(), []core.Transaction{tx})
+		count, err := l.store.CountTransactions(context.Background())
+		assert.NoError(t, err)
 
-		if err != nil {
-			t.Error(err)
+		valid := core.Posting{
+			Source:      "world",
+			Destination: "users:validation",
+			Amount:      100,
+			Asset:       "COIN",
 		}
+		_, err = l.Commit(context.Background(), []core.Transaction{
+			{Postings: []core.Posting{valid}},
+			{Postings: []core.Posting{valid}},
+			{Postings: []core.Posting{valid, valid, valid, {
+				Source:      "world",
+				Destination: "users:validation",
+				Amount:      0,
+			}}},
+		})
 
-		_, err = l.Commit(context.Background(), []core.Transaction{tx})
+		errs := core.ValidationErrors{}
+		assert.True(t, errors.As(err, &errs))
+		assert.Equal(t, core.ValidationErrors{
+			{Transaction: 2, Posting: 3, Field: "amount", Message: "must be positive"},
+			{Transaction: 2, Posting: 3, Field: "asset", Message: "must not be empty"},
+		}, errs)
 
-		if err == nil {
-			t.Fail()
-		}
+		after, err := l.store.CountTransactions(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, count, after)
 	})
 }
 
-func TestLast(t *testing.T) {
+func TestNoopPostings(t *testing.T) {
 	with(func(l *Ledger) {
-		_, err := l.GetLastTransaction(context.Background())
+		_, err := l.Commit(context.Background(), []core.Transaction{
+			{
+				Postings: []core.Posting{
+					{Source: "a", Destination: "a", Amount: 1, Asset: "X"},
+				},
+			},
+		})
+		errs := core.ValidationErrors{}
+		assert.True(t, errors.As(err, &errs))
+		assert.Equal(t, core.ValidationErrors{
+			{Transaction: 0, Posting: 0, Field: "destination", Message: "must differ from the source"},
+		}, errs)
 
-		if err != nil {
-			t.Error(err)
-		}
+		_, err = l.CommitWithOptions(context.Background(), []core.Transaction{
+			{
+				Postings: []core.Posting{
+					{Source: "world", Destination: "world", Amount: 1, Asset: "X"},
+				},
+			},
+		}, CommitOptions{
+			AllowNoop: true,
+		})
+		assert.NoError(t, err)
 	})
 }
 
-func TestAccountMetadata(t *testing.T) {
+func TestNegativeAmounts(t *testing.T) {
 	with(func(l *Ledger) {
-		err := l.SaveMeta(context.Background(), "account", "users:001", core.Metadata{
-			"a random metadata": json.RawMessage(`"old value"`),
+		negative := []core.Transaction{
+			{
+				Postings: []core.Posting{
+					{Source: "users:refund", Destination: "world", Amount: -100, Asset: "REFUND"},
+				},
+			},
+		}
+
+		_, err := l.Commit(context.Background(), negative)
+		assert.True(t, errors.Is(err, ErrValidation))
+
+		committed, err := l.CommitWithOptions(context.Background(), negative, CommitOptions{
+			AllowNegativeAmounts: true,
 		})
 		assert.NoError(t, err)
+		assert.Equal(t, core.Postings{
+			{Source: "world", Destination: "users:refund", Amount: 100, Asset: "REFUND"},
+		}, committed[0].Postings)
+		assert.EqualValues(t, -100, negative[0].Postings[0].Amount)
 
-		err = l.SaveMeta(context.Background(), "account", "users:001", core.Metadata{
-			"a random metadata": json.RawMessage(`"new value"`),
-		})
+		balance, err := l.GetAccountBalance(context.Background(), "users:refund", "REFUND")
 		assert.NoError(t, err)
+		assert.EqualValues(t, 100, balance)
 
-		{
-			acc, err := l.GetAccount(context.Background(), "users:001")
-			if err != nil {
-				t.Fatal(err)
-			}
+		_, err = l.CommitWithOptions(context.Background(), []core.Transaction{
+			{
+				Postings: []core.Posting{
+					{Source: "world", Destination: "users:refund", Amount: 0, Asset: "REFUND"},
+				},
+			},
+		}, CommitOptions{
+			AllowNegativeAmounts: true,
+		})
+		assert.True(t, errors.Is(err, ErrValidation))
+	})
+}
 
-			if meta, ok := acc.Metadata["a random metadata"]; ok {
-				var value string
-				err := json.Unmarshal(meta, &value)
-				if err != nil {
-					t.Fatal(err)
-				}
-				if value != "new value" {
-					t.Fatalf("metadata entry did not match in get: expected \"new value\", got %v", value)
-				}
-			}
-		}
+func TestLimits(t *testing.T) {
+	with(func(*Ledger) {
+		l := newEmptyLedger(t)
+		WithLimits(Limits{
+			MaxPostingsPerTransaction: 2,
+			MaxTransactionsPerBatch:   2,
+		})(l)
 
-		{
-			cursor, err := l.FindAccounts(context.Background(), query.Account("users:001"))
-			if err != nil {
-				t.Fatal(err)
-			}
+		posting := core.Posting{Source: "world", Destination: "users:001", Amount: 1, Asset: "COIN"}
 
-			accounts, ok := cursor.Data.([]core.Account)
-			if !ok {
-				t.Fatalf("wrong cursor type: %v", reflect.TypeOf(cursor.Data))
-			}
-			if len(accounts) == 0 {
-				t.Fatal("no accounts returned by find")
-			}
+		_, err := l.Commit(context.Background(), []core.Transaction{
+			{Postings: core.Postings{posting}},
+			{Postings: core.Postings{posting}},
+			{Postings: core.Postings{posting}},
+		})
+		assert.True(t, errors.Is(err, ErrBatchTooLarge))
+		assert.True(t, errors.Is(err, ErrValidation))
 
-			if meta, ok := accounts[0].Metadata["a random metadata"]; ok {
-				var value string
-				err := json.Unmarshal(meta, &value)
-				if err != nil {
-					t.Fatal(err)
-				}
-				if value != "new value" {
-					t.Fatalf("metadata entry did not match in find: expected \"new value\", got %v", value)
-				}
-			}
+		_, err = l.Commit(context.Background(), []core.Transaction{
+			{Postings: core.Postings{posting, posting, posting}},
+		})
+		errs := core.ValidationErrors{}
+		assert.True(t, errors.As(err, &errs))
+		assert.Equal(t, core.ValidationErrors{
+			{Transaction: 0, Posting: -1, Field: "postings", Message: "must not exceed 2 postings"},
+		}, errs)
+
+		_, err = l.Commit(context.Background(), []core.Transaction{
+			{Postings: core.Postings{posting, posting}},
+			{Postings: core.Postings{posting, posting}},
+		})
+		assert.NoError(t, err)
+	})
+}
+
+func TestPageSizes(t *testing.T) {
+	with(func(*Ledger) {
+		l := newEmptyLedger(t)
+		WithLimits(Limits{
+			DefaultPageSize: 2,
+			MaxPageSize:     3,
+		})(l)
+
+		for i := 0; i < 5; i++ {
+			_, err := l.Commit(context.Background(), []core.Transaction{{
+				Postings: core.Postings{
+					{Source: "world", Destination: fmt.Sprintf("users:page:%d", i), Amount: 1, Asset: "COIN"},
+				},
+			}})
+			assert.NoError(t, err)
 		}
+
+		cursor, err := l.FindTransactions(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, 2, cursor.PageSize)
+		assert.Len(t, cursor.Data, 2)
+
+		// The larger pages are clamped
+		cursor, err = l.FindTransactions(context.Background(), query.Limit(1000))
+		assert.NoError(t, err)
+		assert.Equal(t, 3, cursor.PageSize)
+		assert.Len(t, cursor.Data, 3)
+		assert.True(t, cursor.HasMore)
+
+		cursor, err = l.FindAccounts(context.Background(), query.Account("users:page:*"), query.Limit(1000))
+		assert.NoError(t, err)
+		assert.Equal(t, 3, cursor.PageSize)
+		assert.Len(t, cursor.Data, 3)
+
+		def, max := Limits{}.PageSizes()
+		assert.Equal(t, query.DEFAULT_LIMIT, def)
+		assert.Equal(t, query.MAX_LIMIT, max)
 	})
 }
 
-func TestTransactionMetadata(t *testing.T) {
+func TestRequireExistingAccounts(t *testing.T) {
 	with(func(l *Ledger) {
-		l.Commit(context.Background(), []core.Transaction{{
+		tx := core.Transaction{
 			Postings: []core.Posting{
-				{
-					Source:      "world",
-					Destination: "payments:001",
-					Amount:      100,
-					Asset:       "COIN",
-				},
+				{Source: "world", Destination: "users:kyc", Amount: 100, Asset: "COIN"},
 			},
-		}})
-
-		tx, err := l.GetLastTransaction(context.Background())
-		if err != nil {
-			t.Error(err)
+		}
+		opts := CommitOptions{
+			RequireExistingAccounts: true,
 		}
 
-		l.SaveMeta(context.Background(), "transaction", fmt.Sprintf("%d", tx.ID), core.Metadata{
-			"a random metadata": json.RawMessage(`"old value"`),
-		})
-		l.SaveMeta(context.Background(), "transaction", fmt.Sprintf("%d", tx.ID), core.Metadata{
-			"a random metadata": json.RawMessage(`"new value"`),
+		_, err := l.CommitWithOptions(context.Background(), []core.Transaction{tx}, opts)
+		txErr := &TransactionError{}
+		assert.True(t, errors.As(err, &txErr))
+		assert.True(t, errors.Is(err, ErrAccountNotFound), err)
+
+		err = l.CreateAccount(context.Background(), "users:kyc", core.Metadata{
+			"tier": json.RawMessage(`"gold"`),
 		})
+		assert.NoError(t, err)
 
-		tx, err = l.GetLastTransaction(context.Background())
-		if err != nil {
-			t.Error(err)
-		}
+		_, err = l.CommitWithOptions(context.Background(), []core.Transaction{tx}, opts)
+		assert.NoError(t, err)
 
-		if meta, ok := tx.Metadata["a random metadata"]; ok {
-			var value string
-			err := json.Unmarshal(meta, &value)
-			if err != nil {
-				t.Fatal(err)
-			}
-			if value != "new value" {
-				t.Fatalf("metadata entry did not match: expected \"new value\", got %v", value)
-			}
-		}
+		account, err := l.GetAccount(context.Background(), "users:kyc")
+		assert.NoError(t, err)
+		assert.True(t, account.Metadata.IsCreated())
+		assert.EqualValues(t, `"gold"`, account.Metadata["tier"])
+		assert.EqualValues(t, 100, account.Balances["COIN"])
+
+		// Accounts are still implicitly created by default
+		_, err = l.Commit(context.Background(), []core.Transaction{{
+			Postings: []core.Posting{
+				{Source: "world", Destination: "users:implicit", Amount: 100, Asset: "COIN"},
+			},
+		}})
+		assert.NoError(t, err)
 	})
 }
 
-func TestSaveTransactionMetadata(t *testing.T) {
+// blockedStore is a store which saves only once the context of the commit is done,
+// like a congested database would
+type blockedStore struct {
+	storage.Store
+}
+
+func (s *blockedStore) SaveTransactions(ctx context.Context, ts []core.Transaction) error {
+	<-ctx.Done()
+	return s.Store.SaveTransactions(ctx, ts)
+}
+
+func TestCommitTimeout(t *testing.T) {
 	with(func(l *Ledger) {
+		count, err := l.store.CountTransactions(context.Background())
+		assert.NoError(t, err)
 
-		l.Commit(context.Background(), []core.Transaction{{
+		blocked, err := NewLedger("test", &blockedStore{l.store}, NewInMemoryLocker(), WithCommitTimeout(50*time.Millisecond))
+		assert.NoError(t, err)
+
+		start := time.Now()
+		_, err = blocked.Commit(context.Background(), []core.Transaction{{
 			Postings: []core.Posting{
-				{
-					Source:      "world",
-					Destination: "payments:001",
-					Amount:      100,
-					Asset:       "COIN",
-				},
-			},
-			Metadata: core.Metadata{
-				"a metadata": json.RawMessage(`"a value"`),
+				{Source: "world", Destination: "users:timeout", Amount: 100, Asset: "COIN"},
 			},
 		}})
+		assert.True(t, errors.Is(err, context.DeadlineExceeded), err)
+		assert.Less(t, int64(time.Since(start)), int64(time.Second))
 
-		tx, err := l.GetLastTransaction(context.Background())
-		if err != nil {
-			t.Error(err)
-		}
+		after, err := l.store.CountTransactions(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, count, after)
 
-		if meta, ok := tx.Metadata["a metadata"]; ok {
-			var value string
-			err := json.Unmarshal(meta, &value)
-			if err != nil {
-				t.Fatal(err)
-			}
-			if value != "a value" {
-				t.Fatalf("metadata entry did not match: expected \"a value\", got %v", value)
-			}
-		}
+		balance, err := l.store.AggregateBalance(context.Background(), "users:timeout", "COIN")
+		assert.NoError(t, err)
+		assert.EqualValues(t, 0, balance)
 	})
 }
 
-func TestGetTransaction(t *testing.T) {
+func TestTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	otel.SetTracerProvider(tp)
+	defer tp.Shutdown(context.Background())
+
 	with(func(l *Ledger) {
-		l.Commit(context.Background(), []core.Transaction{{
-			Reference: "bar",
+		_, err := l.Commit(context.Background(), []core.Transaction{{
 			Postings: []core.Posting{
-				{
-					Source:      "world",
-					Destination: "payments:001",
-					Amount:      100,
-					Asset:       "COIN",
-				},
+				{Source: "world", Destination: "users:tracing", Amount: 100, Asset: "COIN"},
 			},
 		}})
+		assert.NoError(t, err)
 
-		last, err := l.GetLastTransaction(context.Background())
-		if err != nil {
-			t.Error(err)
-		}
+		_, err = l.Commit(context.Background(), []core.Transaction{{
+			Postings: []core.Posting{
+				{Source: "users:tracing", Destination: "users:tracing:2", Amount: 50, Asset: "COIN"},
+				{Source: "world", Destination: "users:tracing:2", Amount: 10, Asset: "GEM"},
+			},
+		}})
+		assert.NoError(t, err)
 
-		tx, err := l.GetTransaction(context.Background(), fmt.Sprint(last.ID))
-		if err != nil {
-			t.Fatal(err)
+		var commit sdktrace.ReadOnlySpan
+		for _, span := range recorder.Ended() {
+			if span.Name() == "Commit" {
+				commit = span
+			}
+		}
+		if !assert.NotNil(t, commit) {
+			return
 		}
+		assert.Contains(t, commit.Attributes(), attribute.String("ledger", "test"))
+		assert.Contains(t, commit.Attributes(), attribute.Int("postings", 2))
+		assert.Contains(t, commit.Attributes(), attribute.StringSlice("assets", []string{"COIN", "GEM"}))
 
-		if !reflect.DeepEqual(tx, last) {
-			t.Fail()
+		if _, ok := l.store.(*sqlstorage.Store); !ok {
+			return
+		}
+		children := map[string]int{}
+		for _, span := range recorder.Ended() {
+			if span.Parent().SpanID() == commit.SpanContext().SpanID() {
+				children[span.Name()]++
+			}
 		}
+		assert.Equal(t, 1, children["AggregateVolumes"], children)
+		assert.Equal(t, 1, children["InsertTransactions"], children)
 	})
 }
 
-func TestFindTransactions(t *testing.T) {
+func TestErrors(t *testing.T) {
 	with(func(l *Ledger) {
+		_, err := l.Commit(context.Background(), []core.Transaction{{}})
+		assert.True(t, errors.Is(err, ErrValidation), err)
+
+		_, err = l.Commit(context.Background(), []core.Transaction{
+			{
+				Postings: []core.Posting{
+					{
+						Source:      "world",
+						Destination: "users:errors",
+						Amount:      1,
+						Asset:       "COIN",
+					},
+				},
+				Timestamp: "yesterday",
+			},
+		})
+		assert.True(t, errors.Is(err, ErrValidation), err)
+
 		tx := core.Transaction{
 			Postings: []core.Posting{
 				{
 					Source:      "world",
-					Destination: "test_find_transactions",
-					Amount:      100,
+					Destination: "users:errors",
+					Amount:      1,
 					Asset:       "COIN",
 				},
 			},
+			Reference: "errors",
 		}
+		_, err = l.Commit(context.Background(), []core.Transaction{tx})
+		assert.NoError(t, err)
+		_, err = l.Commit(context.Background(), []core.Transaction{tx})
+		assert.True(t, errors.Is(err, ErrDuplicateReference), err)
 
-		l.Commit(context.Background(), []core.Transaction{tx})
-
-		res, err := l.FindTransactions(context.Background())
-
-		if err != nil {
-			t.Error(err)
-		}
+		_, err = l.GetTransaction(context.Background(), "999999")
+		assert.True(t, errors.Is(err, ErrNotFound), err)
+		assert.True(t, errors.Is(err, ErrTransactionNotFound), err)
 
-		txs := res.Data.([]core.Transaction)
+		_, err = l.GetAccountBalance(context.Background(), "users:unknown", "COIN")
+		assert.True(t, errors.Is(err, ErrNotFound), err)
+	})
+}
+
+func TestGetAccountBalance(t *testing.T) {
+	with(func(l *Ledger) {
+		_, err := l.Commit(context.Background(), []core.Transaction{
+			{
+				Postings: []core.Posting{
+					{
+						Source:      "world",
+						Destination: "users:balance",
+						Amount:      100,
+						Asset:       "COIN",
+					},
+					{
+						Source:      "world",
+						Destination: "users:balance",
+						Amount:      50,
+						Asset:       "GEM",
+					},
+				},
+			},
+		})
+		assert.NoError(t, err)
+
+		balance, err := l.GetAccountBalance(context.Background(), "users:balance", "COIN")
+		assert.NoError(t, err)
+		assert.EqualValues(t, 100, balance)
+
+		balance, err = l.GetAccountBalance(context.Background(), "users:balance", "USD")
+		assert.NoError(t, err)
+		assert.EqualValues(t, 0, balance)
+
+		_, err = l.GetAccountBalance(context.Background(), "users:unknown", "COIN")
+		assert.Equal(t, ErrAccountNotFound, err)
+	})
+}
+
+func TestGetAccounts(t *testing.T) {
+	with(func(l *Ledger) {
+		_, err := l.Commit(context.Background(), []core.Transaction{
+			{
+				Postings: []core.Posting{
+					{
+						Source:      "world",
+						Destination: "users:bulk:001",
+						Amount:      100,
+						Asset:       "USD/2",
+					},
+				},
+			},
+		})
+		assert.NoError(t, err)
+
+		err = l.SaveMeta(context.Background(), "account", "users:bulk:001", core.Metadata{
+			"name": json.RawMessage(`"bulk"`),
+		})
+		assert.NoError(t, err)
+
+		accounts, err := l.GetAccounts(context.Background(), []string{"users:bulk:002", "users:bulk:001"})
+		assert.NoError(t, err)
+		assert.Equal(t, []core.Account{
+			{
+				Address:  "users:bulk:002",
+				Contract: "default",
+				Balances: map[string]int64{},
+				Scales:   map[string]int{},
+			},
+			{
+				Address:  "users:bulk:001",
+				Contract: "default",
+				Balances: map[string]int64{"USD/2": 100},
+				Scales:   map[string]int{"USD/2": 2},
+				Metadata: core.Metadata{"name": json.RawMessage(`"bulk"`)},
+			},
+		}, accounts)
+
+		account, err := l.GetAccount(context.Background(), "users:bulk:001")
+		assert.NoError(t, err)
+		assert.Equal(t, account.Balances, accounts[1].Balances)
+		assert.Equal(t, account.Metadata, accounts[1].Metadata)
+
+		_, err = l.GetAccounts(context.Background(), make([]string, query.MAX_LIMIT+1))
+		assert.True(t, errors.Is(err, ErrValidation), err)
+	})
+}
+
+func TestFindAccountsByBalance(t *testing.T) {
+	with(func(l *Ledger) {
+		fund := func(address string, amount int64) {
+			_, err := l.Commit(context.Background(), []core.Transaction{
+				{
+					Postings: []core.Posting{
+						{
+							Source:      "world",
+							Destination: address,
+							Amount:      amount,
+							Asset:       "TOP",
+						},
+					},
+				},
+			})
+			assert.NoError(t, err)
+		}
+		fund("holders:a", 30)
+		fund("holders:b", 20)
+		fund("holders:c", 10)
+
+		sorting := query.SortAccounts(query.Sort{By: query.SortByBalance, Asset: "TOP"})
+		cursor, err := l.FindAccounts(context.Background(), sorting, query.Account("holders:*"), query.Limit(2))
+		assert.NoError(t, err)
+		accounts := cursor.Data.([]core.Account)
+		if assert.Len(t, accounts, 2) {
+			assert.Equal(t, "holders:a", accounts[0].Address)
+			assert.Equal(t, map[string]int64{"TOP": 20}, accounts[1].Balances)
+		}
+
+		// The next page is sorted by the balances of the first page
+		fund("holders:c", 100)
+
+		token, err := query.DecodeToken(l.name, cursor.Next)
+		assert.NoError(t, err)
+		cursor, err = l.FindAccounts(context.Background(), append(token.Modifiers(), query.Account("holders:*"))...)
+		assert.NoError(t, err)
+		assert.Equal(t, []core.Account{
+			{
+				Address:  "holders:c",
+				Contract: "default",
+				Balances: map[string]int64{"TOP": 10},
+				Metadata: core.Metadata{},
+			},
+		}, cursor.Data)
+		assert.False(t, cursor.HasMore)
+
+		cursor, err = l.FindAccounts(context.Background(), sorting, query.Account("holders:*"), query.Limit(1))
+		assert.NoError(t, err)
+		assert.Equal(t, "holders:c", cursor.Data.([]core.Account)[0].Address)
+
+		_, err = l.FindAccounts(context.Background(), query.SortAccounts(query.Sort{By: query.SortByBalance}))
+		assert.True(t, errors.Is(err, ErrValidation), err)
+
+		_, err = l.FindAccounts(context.Background(), query.SortAccounts(query.Sort{By: "metadata"}))
+		assert.True(t, errors.Is(err, ErrValidation), err)
+	})
+}
+
+func TestGetAccountVolumes(t *testing.T) {
+	with(func(l *Ledger) {
+		_, err := l.Commit(context.Background(), []core.Transaction{
+			{
+				Postings: []core.Posting{
+					{
+						Source:      "world",
+						Destination: "users:volumes",
+						Amount:      100,
+						Asset:       "COIN",
+					},
+					{
+						Source:      "users:volumes",
+						Destination: "world",
+						Amount:      30,
+						Asset:       "COIN",
+					},
+					{
+						Source:      "world",
+						Destination: "users:volumes",
+						Amount:      50,
+						Asset:       "GEM",
+					},
+				},
+			},
+		})
+		assert.NoError(t, err)
+
+		account, err := l.GetAccount(context.Background(), "users:volumes")
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]map[string]int64{
+			"COIN": {
+				"input":  100,
+				"output": 30,
+			},
+			"GEM": {
+				"input":  50,
+				"output": 0,
+			},
+		}, account.Volumes)
+
+		for asset, volumes := range account.Volumes {
+			assert.Equal(t, account.Balances[asset], volumes["input"]-volumes["output"], asset)
+		}
+	})
+}
+
+func TestAssetScale(t *testing.T) {
+	with(func(l *Ledger) {
+		_, err := l.Commit(context.Background(), []core.Transaction{
+			{
+				Postings: []core.Posting{
+					{
+						Source:      "world",
+						Destination: "users:scaled",
+						Amount:      1234,
+						Asset:       "USD/2",
+					},
+					{
+						Source:      "world",
+						Destination: "users:scaled",
+						Amount:      1,
+						Asset:       "USD",
+					},
+				},
+			},
+		})
+		assert.Error(t, err)
+
+		_, err = l.Commit(context.Background(), []core.Transaction{
+			{
+				Postings: []core.Posting{
+					{
+						Source:      "world",
+						Destination: "users:scaled",
+						Amount:      1234,
+						Asset:       "USD/2",
+					},
+				},
+			},
+		})
+		assert.NoError(t, err)
+
+		account, err := l.GetAccount(context.Background(), "users:scaled")
+		assert.NoError(t, err)
+		assert.EqualValues(t, 1234, account.Balances["USD/2"])
+		assert.Equal(t, 2, account.Scales["USD/2"])
+	})
+}
+
+func TestAssetScaleAcrossCommits(t *testing.T) {
+	l := newEmptyLedger(t)
+	send := func(asset string) []core.Transaction {
+		return []core.Transaction{{
+			Postings: []core.Posting{
+				{Source: "world", Destination: "users:scaled", Amount: 100, Asset: asset},
+			},
+		}}
+	}
+
+	_, err := l.Commit(context.Background(), send("USD/2"))
+	assert.NoError(t, err)
+
+	// The scale of the asset is the one of the transactions committed before
+	for _, asset := range []string{"USD/3", "USD"} {
+		_, err = l.Commit(context.Background(), send(asset))
+		assert.True(t, errors.Is(err, ErrValidation), asset)
+		txErr := &TransactionError{}
+		if assert.True(t, errors.As(err, &txErr), asset) {
+			assert.Equal(t, 0, txErr.Index)
+		}
+	}
+
+	_, err = l.Commit(context.Background(), send("USD/2"))
+	assert.NoError(t, err)
+
+	// The scales used by the transactions of the older versions all remain accepted
+	legacy := newEmptyLedger(t)
+	err = legacy.store.SaveTransactions(context.Background(), []core.Transaction{
+		{
+			ID: 0,
+			Postings: []core.Posting{
+				{Source: "world", Destination: "users:scaled", Amount: 100, Asset: "EUR/2"},
+				{Source: "world", Destination: "users:scaled", Amount: 100, Asset: "EUR/3"},
+			},
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+		},
+	})
+	assert.NoError(t, err)
+	for _, asset := range []string{"EUR/2", "EUR/3"} {
+		_, err = legacy.Commit(context.Background(), send(asset))
+		assert.NoError(t, err, asset)
+	}
+	_, err = legacy.Commit(context.Background(), send("EUR/4"))
+	assert.True(t, errors.Is(err, ErrValidation), err)
+}
+
+func TestCommitPreview(t *testing.T) {
+	with(func(l *Ledger) {
+		txs := []core.Transaction{
+			{
+				Postings: []core.Posting{
+					{
+						Source:      "world",
+						Destination: "payments:preview",
+						Amount:      100,
+						Asset:       "COIN",
+					},
+					{
+						Source:      "payments:preview",
+						Destination: "users:preview",
+						Amount:      100,
+						Asset:       "COIN",
+					},
+				},
+			},
+		}
+
+		deltas, err := l.CommitPreview(context.Background(), txs)
+		assert.NoError(t, err)
+		assert.EqualValues(t, -100, deltas["world"]["COIN"])
+		assert.EqualValues(t, 0, deltas["payments:preview"]["COIN"])
+		assert.EqualValues(t, 100, deltas["users:preview"]["COIN"])
+
+		_, err = l.GetAccountBalance(context.Background(), "users:preview", "COIN")
+		assert.Equal(t, ErrAccountNotFound, err)
+
+		_, err = l.CommitPreview(context.Background(), []core.Transaction{
+			{
+				Postings: []core.Posting{
+					{
+						Source:      "users:preview",
+						Destination: "world",
+						Amount:      100,
+						Asset:       "COIN",
+					},
+				},
+			},
+		})
+		assert.Error(t, err)
+	})
+}
+
+func TestCommitWithKey(t *testing.T) {
+	with(func(l *Ledger) {
+		tx := core.Transaction{
+			Postings: []core.Posting{
+				{
+					Source:      "world",
+					Destination: "users:idempotent",
+					Amount:      100,
+					Asset:       "COIN",
+				},
+			},
+		}
+
+		committed, err := l.CommitWithKey(context.Background(), "batch-1", []core.Transaction{tx})
+		assert.NoError(t, err)
+
+		replayed, err := l.CommitWithKey(context.Background(), "batch-1", []core.Transaction{tx})
+		assert.NoError(t, err)
+		assert.Len(t, replayed, 1)
+		assert.Equal(t, committed[0].ID, replayed[0].ID)
+		assert.Equal(t, committed[0].Hash, replayed[0].Hash)
+
+		balance, err := l.GetAccountBalance(context.Background(), "users:idempotent", "COIN")
+		assert.NoError(t, err)
+		assert.EqualValues(t, 100, balance)
+
+		expiring, err := NewLedger("test", l.store, NewInMemoryLocker(), WithIdempotencyKeyTTL(0))
+		assert.NoError(t, err)
+
+		_, err = expiring.CommitWithKey(context.Background(), "batch-1", []core.Transaction{tx})
+		assert.NoError(t, err)
+
+		balance, err = l.GetAccountBalance(context.Background(), "users:idempotent", "COIN")
+		assert.NoError(t, err)
+		assert.EqualValues(t, 200, balance)
+	})
+}
+
+func TestCommitWithKeyBackdated(t *testing.T) {
+	l := newEmptyLedger(t)
+
+	// The keys expire after the time they were saved, whatever the timestamps of the transactions set by the client
+	tx := core.Transaction{
+		Postings: []core.Posting{
+			{
+				Source:      "world",
+				Destination: "users:idempotent",
+				Amount:      100,
+				Asset:       "COIN",
+			},
+		},
+		Timestamp: time.Now().Add(-2 * DefaultIdempotencyKeyTTL).UTC().Format(time.RFC3339),
+	}
+	committed, err := l.CommitWithKey(context.Background(), "backdated", []core.Transaction{tx})
+	assert.NoError(t, err)
+
+	replayed, err := l.CommitWithKey(context.Background(), "backdated", []core.Transaction{tx})
+	assert.NoError(t, err)
+	if assert.Len(t, replayed, 1) {
+		assert.Equal(t, committed[0].ID, replayed[0].ID)
+	}
+
+	balance, err := l.GetAccountBalance(context.Background(), "users:idempotent", "COIN")
+	assert.NoError(t, err)
+	assert.EqualValues(t, 100, balance)
+}
+
+func TestReference(t *testing.T) {
+	with(func(l *Ledger) {
+		tx := core.Transaction{
+			Reference: "payment_processor_id_01",
+			Postings: []core.Posting{
+				{
+					Source:      "world",
+					Destination: "payments:001",
+					Amount:      100,
+					Asset:       "COIN",
+				},
+			},
+		}
+
+		_, err := l.Commit(context.Background(), []core.Transaction{tx})
+
+		if err != nil {
+			t.Error(err)
+		}
+
+		_, err = l.Commit(context.Background(), []core.Transaction{tx})
+
+		if err == nil {
+			t.Fail()
+		}
+	})
+}
+
+func TestReferenceScope(t *testing.T) {
+	tx := func(ref string, assets ...string) core.Transaction {
+		t := core.Transaction{
+			Reference: ref,
+		}
+		for _, asset := range assets {
+			t.Postings = append(t.Postings, core.Posting{Source: "world", Destination: "payments:001", Amount: 100, Asset: asset})
+		}
+		return t
+	}
+
+	l := newEmptyLedger(t)
+	WithReferenceScope(storage.ReferenceScopePerAsset)(l)
+
+	_, err := l.Commit(context.Background(), []core.Transaction{tx("payment_01", "USD"), tx("payment_01", "EUR")})
+	assert.NoError(t, err)
+	_, err = l.Commit(context.Background(), []core.Transaction{tx("payment_01", "GEM", "EUR")})
+	assert.True(t, errors.Is(err, ErrDuplicateReference), err)
+	_, err = l.Commit(context.Background(), []core.Transaction{tx("payment_02", "GEM"), tx("payment_02", "GEM")})
+	assert.True(t, errors.Is(err, ErrDuplicateReference), err)
+	txErr := &TransactionError{}
+	if assert.True(t, errors.As(err, &txErr), err) {
+		assert.Equal(t, 1, txErr.Index)
+	}
+
+	// The references committed in another scope are checked as well
+	WithReferenceScope(storage.ReferenceScopeGlobal)(l)
+	_, err = l.Commit(context.Background(), []core.Transaction{tx("payment_01", "GEM")})
+	assert.True(t, errors.Is(err, ErrDuplicateReference), err)
+
+	WithReferenceScope(storage.ReferenceScopeNone)(l)
+	_, err = l.Commit(context.Background(), []core.Transaction{tx("payment_01", "USD"), tx("payment_01", "USD")})
+	assert.NoError(t, err)
+
+	// A reference shared by several transactions can't be reverted by reference
+	err = l.RevertTransactionByReference(context.Background(), "payment_01")
+	assert.Error(t, err)
+}
+
+func TestBatchDuplicateReference(t *testing.T) {
+	tx := func(ref string) core.Transaction {
+		return core.Transaction{
+			Reference: ref,
+			Postings:  []core.Posting{{Source: "world", Destination: "payments:001", Amount: 100, Asset: "USD"}},
+		}
+	}
+
+	l := newEmptyLedger(t)
+	_, err := l.Commit(context.Background(), []core.Transaction{tx("payment_a"), tx("payment_z")})
+	assert.NoError(t, err)
+
+	// The first transaction of the batch reusing a committed reference is reported, wherever it is
+	for _, tc := range []struct {
+		batch []core.Transaction
+		index int
+	}{
+		{batch: []core.Transaction{tx("payment_b"), tx("payment_a")}, index: 1},
+		{batch: []core.Transaction{tx("payment_b"), tx("payment_z"), tx("payment_a")}, index: 1},
+		{batch: []core.Transaction{tx("payment_b"), tx("payment_c"), tx("payment_d"), tx("payment_z")}, index: 3},
+	} {
+		_, err = l.Commit(context.Background(), tc.batch)
+		assert.True(t, errors.Is(err, ErrDuplicateReference), err)
+		txErr := &TransactionError{}
+		if assert.True(t, errors.As(err, &txErr), err) {
+			assert.Equal(t, tc.index, txErr.Index)
+		}
+	}
+
+	// Nothing was committed by the rejected batches
+	_, err = l.Commit(context.Background(), []core.Transaction{tx("payment_b")})
+	assert.NoError(t, err)
+}
+
+func TestLast(t *testing.T) {
+	with(func(l *Ledger) {
+		_, err := l.GetLastTransaction(context.Background())
+
+		if err != nil {
+			t.Error(err)
+		}
+	})
+}
+
+func TestAccountMetadata(t *testing.T) {
+	with(func(l *Ledger) {
+		err := l.SaveMeta(context.Background(), "account", "users:001", core.Metadata{
+			"a random metadata": json.RawMessage(`"old value"`),
+		})
+		assert.NoError(t, err)
+
+		err = l.SaveMeta(context.Background(), "account", "users:001", core.Metadata{
+			"a random metadata": json.RawMessage(`"new value"`),
+		})
+		assert.NoError(t, err)
+
+		{
+			acc, err := l.GetAccount(context.Background(), "users:001")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if meta, ok := acc.Metadata["a random metadata"]; ok {
+				var value string
+				err := json.Unmarshal(meta, &value)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if value != "new value" {
+					t.Fatalf("metadata entry did not match in get: expected \"new value\", got %v", value)
+				}
+			}
+		}
+
+		{
+			cursor, err := l.FindAccounts(context.Background(), query.Account("users:001"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			accounts, ok := cursor.Data.([]core.Account)
+			if !ok {
+				t.Fatalf("wrong cursor type: %v", reflect.TypeOf(cursor.Data))
+			}
+			if len(accounts) == 0 {
+				t.Fatal("no accounts returned by find")
+			}
+
+			if meta, ok := accounts[0].Metadata["a random metadata"]; ok {
+				var value string
+				err := json.Unmarshal(meta, &value)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if value != "new value" {
+					t.Fatalf("metadata entry did not match in find: expected \"new value\", got %v", value)
+				}
+			}
+		}
+	})
+}
+
+func TestSaveMetaBatch(t *testing.T) {
+	with(func(l *Ledger) {
+		failures, err := l.SaveMetaBatch(context.Background(), []MetaUpdate{
+			{
+				TargetType: "account",
+				TargetID:   "users:batch:001",
+				Metadata: core.Metadata{
+					"a": json.RawMessage(`"first"`),
+					"b": json.RawMessage(`"kept"`),
+				},
+			},
+			{
+				TargetType: "account",
+				TargetID:   "users:batch:001",
+				Metadata: core.Metadata{
+					"a": json.RawMessage(`"second"`),
+				},
+			},
+			{
+				TargetType: "unknown",
+				TargetID:   "users:batch:002",
+				Metadata: core.Metadata{
+					"a": json.RawMessage(`"value"`),
+				},
+			},
+		})
+		assert.NoError(t, err)
+		assert.Len(t, failures, 1)
+		assert.Equal(t, "users:batch:002", failures[0].TargetID)
+		assert.Error(t, failures[0].Error)
+
+		acc, err := l.GetAccount(context.Background(), "users:batch:001")
+		assert.NoError(t, err)
+		assert.Equal(t, core.Metadata{
+			"a": json.RawMessage(`"second"`),
+			"b": json.RawMessage(`"kept"`),
+		}, acc.Metadata)
+	})
+}
+
+func TestSaveMetaIfVersion(t *testing.T) {
+	with(func(l *Ledger) {
+		version, err := l.GetMetaVersion(context.Background(), "account", "users:versioned")
+		assert.NoError(t, err)
+		assert.EqualValues(t, 0, version)
+
+		version, err = l.SaveMetaIfVersion(context.Background(), "account", "users:versioned", core.Metadata{
+			"name": json.RawMessage(`"John"`),
+		}, version)
+		assert.NoError(t, err)
+		assert.EqualValues(t, 1, version)
+
+		err = l.SaveMeta(context.Background(), "account", "users:versioned", core.Metadata{
+			"name": json.RawMessage(`"Jane"`),
+		})
+		assert.NoError(t, err)
+
+		_, err = l.SaveMetaIfVersion(context.Background(), "account", "users:versioned", core.Metadata{
+			"name": json.RawMessage(`"Jack"`),
+		}, version)
+		assert.True(t, errors.Is(err, ErrMetadataVersionMismatch))
+
+		acc, err := l.GetAccount(context.Background(), "users:versioned")
+		assert.NoError(t, err)
+		assert.EqualValues(t, 2, acc.MetadataVersion)
+		assert.Equal(t, core.Metadata{
+			"name": json.RawMessage(`"Jane"`),
+		}, acc.Metadata)
+	})
+}
+
+// racingMetaStore saves concurrent metadata of the target before the first batch of metadata, as another process
+// sharing the store would between the check of the version and the write
+type racingMetaStore struct {
+	storage.Store
+	raced bool
+}
+
+func (s *racingMetaStore) SaveMetaBatch(ctx context.Context, entries []storage.MetaEntry) error {
+	if !s.raced {
+		s.raced = true
+		concurrent := entries[0]
+		concurrent.ID = entries[len(entries)-1].ID + 1
+		concurrent.Value = `"concurrent"`
+		err := s.Store.SaveMetaBatch(context.Background(), []storage.MetaEntry{concurrent})
+		if err != nil {
+			return err
+		}
+	}
+	return s.Store.SaveMetaBatch(ctx, entries)
+}
+
+func TestSaveMetaIfVersionConcurrent(t *testing.T) {
+	l := newEmptyLedger(t)
+	racing, err := NewLedger(l.name, &racingMetaStore{Store: l.store}, NewInMemoryLocker())
+	assert.NoError(t, err)
+
+	_, err = racing.SaveMetaIfVersion(context.Background(), "account", "users:raced", core.Metadata{
+		"name": json.RawMessage(`"John"`),
+	}, 0)
+	assert.True(t, errors.Is(err, ErrMetadataVersionMismatch), err)
+
+	acc, err := l.GetAccount(context.Background(), "users:raced")
+	assert.NoError(t, err)
+	assert.EqualValues(t, 1, acc.MetadataVersion)
+	assert.Equal(t, core.Metadata{
+		"name": json.RawMessage(`"concurrent"`),
+	}, acc.Metadata)
+}
+
+func TestDeleteAccountMetadata(t *testing.T) {
+	with(func(l *Ledger) {
+		err := l.SaveMeta(context.Background(), "account", "users:delete", core.Metadata{
+			"email": json.RawMessage(`"john@example.com"`),
+			"name":  json.RawMessage(`"John"`),
+		})
+		assert.NoError(t, err)
+
+		err = l.DeleteMeta(context.Background(), "account", "users:delete", []string{"email", "missing"})
+		assert.NoError(t, err)
+
+		acc, err := l.GetAccount(context.Background(), "users:delete")
+		assert.NoError(t, err)
+		assert.Equal(t, core.Metadata{
+			"name": json.RawMessage(`"John"`),
+		}, acc.Metadata)
+
+		err = l.DeleteMeta(context.Background(), "account", "users:delete", []string{"name"})
+		assert.NoError(t, err)
+
+		acc, err = l.GetAccount(context.Background(), "users:delete")
+		assert.NoError(t, err)
+		assert.NotNil(t, acc.Metadata)
+		assert.Len(t, acc.Metadata, 0)
+
+		err = l.SaveMeta(context.Background(), "account", "users:delete", core.Metadata{
+			"name": json.RawMessage(`"Jane"`),
+		})
+		assert.NoError(t, err)
+	})
+}
+
+func TestTransactionMetadata(t *testing.T) {
+	with(func(l *Ledger) {
+		l.Commit(context.Background(), []core.Transaction{{
+			Postings: []core.Posting{
+				{
+					Source:      "world",
+					Destination: "payments:001",
+					Amount:      100,
+					Asset:       "COIN",
+				},
+			},
+		}})
+
+		tx, err := l.GetLastTransaction(context.Background())
+		if err != nil {
+			t.Error(err)
+		}
+
+		l.SaveMeta(context.Background(), "transaction", fmt.Sprintf("%d", tx.ID), core.Metadata{
+			"a random metadata": json.RawMessage(`"old value"`),
+		})
+		l.SaveMeta(context.Background(), "transaction", fmt.Sprintf("%d", tx.ID), core.Metadata{
+			"a random metadata": json.RawMessage(`"new value"`),
+		})
+
+		tx, err = l.GetLastTransaction(context.Background())
+		if err != nil {
+			t.Error(err)
+		}
+
+		if meta, ok := tx.Metadata["a random metadata"]; ok {
+			var value string
+			err := json.Unmarshal(meta, &value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if value != "new value" {
+				t.Fatalf("metadata entry did not match: expected \"new value\", got %v", value)
+			}
+		}
+	})
+}
+
+func TestSaveTransactionMetadata(t *testing.T) {
+	with(func(l *Ledger) {
+
+		l.Commit(context.Background(), []core.Transaction{{
+			Postings: []core.Posting{
+				{
+					Source:      "world",
+					Destination: "payments:001",
+					Amount:      100,
+					Asset:       "COIN",
+				},
+			},
+			Metadata: core.Metadata{
+				"a metadata": json.RawMessage(`"a value"`),
+			},
+		}})
+
+		tx, err := l.GetLastTransaction(context.Background())
+		if err != nil {
+			t.Error(err)
+		}
+
+		if meta, ok := tx.Metadata["a metadata"]; ok {
+			var value string
+			err := json.Unmarshal(meta, &value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if value != "a value" {
+				t.Fatalf("metadata entry did not match: expected \"a value\", got %v", value)
+			}
+		}
+	})
+}
+
+func TestGetTransaction(t *testing.T) {
+	with(func(l *Ledger) {
+		l.Commit(context.Background(), []core.Transaction{{
+			Reference: "bar",
+			Postings: []core.Posting{
+				{
+					Source:      "world",
+					Destination: "payments:001",
+					Amount:      100,
+					Asset:       "COIN",
+				},
+			},
+		}})
+
+		last, err := l.GetLastTransaction(context.Background())
+		if err != nil {
+			t.Error(err)
+		}
+
+		tx, err := l.GetTransaction(context.Background(), fmt.Sprint(last.ID))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(tx, last) {
+			t.Fail()
+		}
+	})
+}
+
+func TestGetTransactionByHash(t *testing.T) {
+	with(func(l *Ledger) {
+		committed, err := l.Commit(context.Background(), []core.Transaction{{
+			Postings: []core.Posting{
+				{
+					Source:      "world",
+					Destination: "payments:001",
+					Amount:      100,
+					Asset:       "COIN",
+				},
+			},
+		}})
+		assert.NoError(t, err)
+
+		tx, err := l.GetTransactionByHash(context.Background(), committed[0].Hash)
+		assert.NoError(t, err)
+		assert.Equal(t, committed[0].ID, tx.ID)
+		assert.Equal(t, committed[0].Postings, tx.Postings)
+
+		_, err = l.GetTransactionByHash(context.Background(), "unknown")
+		assert.True(t, errors.Is(err, ErrTransactionNotFound), err)
+		assert.True(t, errors.Is(err, ErrNotFound), err)
+	})
+}
+
+func TestFindTransactions(t *testing.T) {
+	with(func(l *Ledger) {
+		tx := core.Transaction{
+			Postings: []core.Posting{
+				{
+					Source:      "world",
+					Destination: "test_find_transactions",
+					Amount:      100,
+					Asset:       "COIN",
+				},
+			},
+		}
+
+		l.Commit(context.Background(), []core.Transaction{tx})
+
+		res, err := l.FindTransactions(context.Background())
+
+		if err != nil {
+			t.Error(err)
+		}
+
+		txs := res.Data.([]core.Transaction)
 
 		if txs[0].Postings[0].Destination != "test_find_transactions" {
 			t.Error()
@@ -424,6 +1439,514 @@ func TestFindTransactions(t *testing.T) {
 	})
 }
 
+func TestPagination(t *testing.T) {
+	with(func(l *Ledger) {
+		for i := 0; i < 3; i++ {
+			_, err := l.Commit(context.Background(), []core.Transaction{
+				{
+					Postings: []core.Posting{
+						{
+							Source:      "world",
+							Destination: fmt.Sprintf("users:pagination:%d", i),
+							Amount:      100,
+							Asset:       "COIN",
+						},
+					},
+				},
+			})
+			assert.NoError(t, err)
+		}
+
+		page := func(token string) query.Cursor {
+			decoded, err := query.DecodeToken(l.name, token)
+			assert.NoError(t, err)
+
+			m := append([]query.QueryModifier{query.Account("users:pagination:*")}, decoded.Modifiers()...)
+			c, err := l.FindTransactions(context.Background(), m...)
+			assert.NoError(t, err)
+			return c
+		}
+		destinations := func(c query.Cursor) []string {
+			destinations := []string{}
+			for _, tx := range c.Data.([]core.Transaction) {
+				destinations = append(destinations, tx.Postings[0].Destination)
+			}
+			return destinations
+		}
+
+		first, err := l.FindTransactions(context.Background(), query.Account("users:pagination:*"), query.Limit(2))
+		assert.NoError(t, err)
+		assert.True(t, first.HasMore)
+		assert.Equal(t, 2, first.PageSize)
+		assert.Equal(t, []string{"users:pagination:2", "users:pagination:1"}, destinations(first))
+
+		_, err = query.DecodeToken("other", first.Next)
+		assert.Equal(t, query.ErrInvalidToken, err)
+
+		second := page(first.Next)
+		assert.False(t, second.HasMore)
+		assert.Empty(t, second.Next)
+		assert.Equal(t, []string{"users:pagination:0"}, destinations(second))
+
+		back := page(second.Previous)
+		assert.Equal(t, destinations(first), destinations(back))
+		assert.Equal(t, first.Previous, back.Previous)
+		assert.NotEmpty(t, back.Next)
+		assert.Equal(t, destinations(second), destinations(page(back.Next)))
+
+		before := page(first.Previous)
+		assert.False(t, before.HasMore)
+		assert.Empty(t, before.Data)
+		assert.Empty(t, before.Next)
+		assert.Equal(t, first.Previous, before.Previous)
+
+		c, err := l.FindAccounts(context.Background(), query.Account("users:pagination:*"), query.Limit(2))
+		assert.NoError(t, err)
+		assert.True(t, c.HasMore)
+
+		token, err := query.DecodeToken(l.name, c.Next)
+		assert.NoError(t, err)
+		assert.Equal(t, "users:pagination:1", token.After)
+
+		c, err = l.FindAccounts(context.Background(), query.Account("users:pagination:*"), query.Before("users:pagination:0"), query.Limit(1))
+		assert.NoError(t, err)
+		assert.True(t, c.HasMore)
+		assert.Equal(t, "users:pagination:1", c.Data.([]core.Account)[0].Address)
+	})
+}
+
+func TestPaginationAscending(t *testing.T) {
+	with(func(l *Ledger) {
+		for i := 0; i < 3; i++ {
+			_, err := l.Commit(context.Background(), []core.Transaction{{
+				Postings: []core.Posting{
+					{Source: "world", Destination: fmt.Sprintf("users:ascending:%d", i), Amount: 100, Asset: "COIN"},
+				},
+			}})
+			assert.NoError(t, err)
+		}
+
+		page := func(token string) query.Cursor {
+			decoded, err := query.DecodeToken(l.name, token)
+			assert.NoError(t, err)
+
+			m := append([]query.QueryModifier{query.Account("users:ascending:*")}, decoded.Modifiers()...)
+			c, err := l.FindTransactions(context.Background(), m...)
+			assert.NoError(t, err)
+			return c
+		}
+		destinations := func(c query.Cursor) []string {
+			destinations := []string{}
+			for _, tx := range c.Data.([]core.Transaction) {
+				destinations = append(destinations, tx.Postings[0].Destination)
+			}
+			return destinations
+		}
+
+		first, err := l.FindTransactions(context.Background(), query.Account("users:ascending:*"), query.SortTransactions(true), query.Limit(2))
+		assert.NoError(t, err)
+		assert.True(t, first.HasMore)
+		assert.Equal(t, []string{"users:ascending:0", "users:ascending:1"}, destinations(first))
+
+		// The tokens carry the order
+		second := page(first.Next)
+		assert.False(t, second.HasMore)
+		assert.Equal(t, []string{"users:ascending:2"}, destinations(second))
+		assert.Equal(t, destinations(first), destinations(page(second.Previous)))
+
+		_, err = l.FindTransactions(context.Background(), query.SortAccounts(query.Sort{By: query.SortByAddress}))
+		assert.True(t, errors.Is(err, ErrValidation), err)
+	})
+}
+
+func TestFindTransactionsByTimestamp(t *testing.T) {
+	with(func(l *Ledger) {
+		now := time.Now().UTC().Truncate(time.Second)
+		txs := []core.Transaction{}
+		for i := 0; i < 3; i++ {
+			txs = append(txs, core.Transaction{
+				Postings: []core.Posting{
+					{
+						Source:      "world",
+						Destination: "users:timestamps",
+						Amount:      100,
+						Asset:       "COIN",
+					},
+				},
+				Timestamp: now.Add(time.Duration(i+1) * time.Hour).Format(time.RFC3339),
+			})
+		}
+		_, err := l.Commit(context.Background(), txs)
+		assert.NoError(t, err)
+
+		cursor, err := l.FindTransactions(context.Background(),
+			query.Account("users:timestamps"),
+			query.TimestampAfter(now.Add(time.Hour)),
+			query.TimestampBefore(now.Add(3*time.Hour)),
+		)
+		assert.NoError(t, err)
+		found := cursor.Data.([]core.Transaction)
+		assert.Len(t, found, 1)
+		assert.Equal(t, txs[1].ID, found[0].ID)
+
+		_, err = l.Commit(context.Background(), []core.Transaction{
+			{
+				Postings: []core.Posting{
+					{
+						Source:      "world",
+						Destination: "users:timestamps",
+						Amount:      100,
+						Asset:       "COIN",
+					},
+				},
+				Timestamp: "yesterday",
+			},
+		})
+		assert.Error(t, err)
+	})
+}
+
+func TestFindTransactionsByMetadata(t *testing.T) {
+	with(func(l *Ledger) {
+		committed, err := l.Commit(context.Background(), []core.Transaction{
+			{
+				Postings: []core.Posting{
+					{Source: "world", Destination: "users:reconciled", Amount: 100, Asset: "COIN"},
+				},
+				Metadata: core.Metadata{
+					"reconciliation": json.RawMessage(`{"order_id": "order-reconciled"}`),
+				},
+			},
+		})
+		assert.NoError(t, err)
+
+		cursor, err := l.FindTransactions(context.Background(), query.Metadata("reconciliation.order_id", "order-reconciled"))
+		assert.NoError(t, err)
+		found := cursor.Data.([]core.Transaction)
+		assert.Len(t, found, 1)
+		assert.Equal(t, committed[0].ID, found[0].ID)
+	})
+}
+
+func TestMonotonicTimestamps(t *testing.T) {
+	with(func(l *Ledger) {
+		tx := func(timestamp string) core.Transaction {
+			return core.Transaction{
+				Postings: []core.Posting{
+					{
+						Source:      "world",
+						Destination: "users:monotonic",
+						Amount:      100,
+						Asset:       "COIN",
+					},
+				},
+				Timestamp: timestamp,
+			}
+		}
+
+		committed, err := l.Commit(context.Background(), []core.Transaction{tx("")})
+		assert.NoError(t, err)
+		previous := committed[0].Timestamp
+
+		past := time.Now().UTC().Add(-24 * time.Hour).Format(time.RFC3339)
+
+		_, err = l.Commit(context.Background(), []core.Transaction{tx(past)})
+		assert.Error(t, err)
+
+		committed, err = l.CommitWithOptions(context.Background(), []core.Transaction{tx(past)}, CommitOptions{
+			ClampTimestamps: true,
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, previous, committed[0].Timestamp)
+	})
+}
+
+func TestAtomicBatch(t *testing.T) {
+	with(func(l *Ledger) {
+		transfer := func(source, destination string) core.Transaction {
+			return core.Transaction{
+				Postings: []core.Posting{
+					{
+						Source:      source,
+						Destination: destination,
+						Amount:      100,
+						Asset:       "COIN",
+					},
+				},
+			}
+		}
+
+		_, err := l.Commit(context.Background(), []core.Transaction{
+			transfer("world", "users:atomic:a"),
+		})
+		assert.NoError(t, err)
+
+		used := transfer("world", "users:atomic:b")
+		used.Reference = "atomic"
+		_, err = l.Commit(context.Background(), []core.Transaction{used})
+		assert.NoError(t, err)
+
+		count, err := l.store.CountTransactions(context.Background())
+		assert.NoError(t, err)
+
+		for name, bad := range map[string]struct {
+			tx     core.Transaction
+			reason error
+		}{
+			"insufficient balance": {
+				tx: transfer("users:atomic:empty", "users:atomic:b"),
+			},
+			"duplicate reference": {
+				tx:     used,
+				reason: storage.ErrDuplicateReference,
+			},
+		} {
+			t.Run(name, func(t *testing.T) {
+				_, err := l.Commit(context.Background(), []core.Transaction{
+					transfer("users:atomic:a", "users:atomic:b"),
+					transfer("world", "users:atomic:a"),
+					bad.tx,
+					transfer("world", "users:atomic:b"),
+				})
+
+				txErr := &TransactionError{}
+				assert.True(t, errors.As(err, &txErr))
+				assert.Equal(t, 2, txErr.Index)
+				if bad.reason != nil {
+					assert.True(t, errors.Is(err, bad.reason))
+				}
+
+				after, err := l.store.CountTransactions(context.Background())
+				assert.NoError(t, err)
+				assert.Equal(t, count, after)
+
+				for address, expected := range map[string]int64{
+					"users:atomic:a": 100,
+					"users:atomic:b": 100,
+				} {
+					balance, err := l.GetAccountBalance(context.Background(), address, "COIN")
+					assert.NoError(t, err)
+					assert.Equal(t, expected, balance)
+				}
+			})
+		}
+	})
+}
+
+func TestBatchOrdering(t *testing.T) {
+	with(func(l *Ledger) {
+		transfer := func(source, destination string, amount int64) core.Transaction {
+			return core.Transaction{
+				Postings: []core.Posting{
+					{Source: source, Destination: destination, Amount: amount, Asset: "COIN"},
+				},
+			}
+		}
+
+		// Spending the funds received later in the batch fails, and nothing is committed
+		_, err := l.Commit(context.Background(), []core.Transaction{
+			transfer("users:ordering:a", "users:ordering:b", 100),
+			transfer("world", "users:ordering:a", 100),
+		})
+		txErr := &TransactionError{}
+		if assert.True(t, errors.As(err, &txErr), err) {
+			assert.Equal(t, 0, txErr.Index)
+		}
+		insufficient := &InsufficientFundsError{}
+		if assert.True(t, errors.As(err, &insufficient), err) {
+			assert.Equal(t, &InsufficientFundsError{
+				Account: "users:ordering:a",
+				Asset:   "COIN",
+				Needed:  100,
+			}, insufficient)
+		}
+		assertBalance(t, l, "users:ordering:a", "COIN", 0)
+
+		// Spending the funds received earlier in the batch succeeds
+		_, err = l.Commit(context.Background(), []core.Transaction{
+			transfer("world", "users:ordering:a", 100),
+			transfer("users:ordering:a", "users:ordering:b", 100),
+		})
+		assert.NoError(t, err)
+		assertBalance(t, l, "users:ordering:a", "COIN", 0)
+		assertBalance(t, l, "users:ordering:b", "COIN", 100)
+
+		// The failing transaction sees the balance left by the previous ones
+		_, err = l.Commit(context.Background(), []core.Transaction{
+			transfer("users:ordering:b", "users:ordering:a", 60),
+			transfer("users:ordering:b", "users:ordering:c", 60),
+			transfer("world", "users:ordering:b", 20),
+		})
+		if assert.True(t, errors.As(err, &txErr), err) {
+			assert.Equal(t, 1, txErr.Index)
+		}
+		if assert.True(t, errors.As(err, &insufficient), err) {
+			assert.Equal(t, &InsufficientFundsError{
+				Account:   "users:ordering:b",
+				Asset:     "COIN",
+				Needed:    60,
+				Available: 40,
+			}, insufficient)
+		}
+		assertBalance(t, l, "users:ordering:b", "COIN", 100)
+
+		// The postings of a transaction are checked on their net effect
+		_, err = l.Commit(context.Background(), []core.Transaction{{
+			Postings: []core.Posting{
+				{Source: "users:ordering:c", Destination: "users:ordering:a", Amount: 50, Asset: "COIN"},
+				{Source: "users:ordering:b", Destination: "users:ordering:c", Amount: 50, Asset: "COIN"},
+			},
+		}})
+		assert.NoError(t, err)
+		assertBalance(t, l, "users:ordering:c", "COIN", 0)
+	})
+}
+
+func TestConcurrentCommits(t *testing.T) {
+	with(func(l *Ledger) {
+		drain := func(t *testing.T, account string, ledgers func(i int) *Ledger) {
+			_, err := l.Commit(context.Background(), []core.Transaction{
+				{
+					Postings: []core.Posting{
+						{
+							Source:      "world",
+							Destination: account,
+							Amount:      100,
+							Asset:       "COIN",
+						},
+					},
+				},
+			})
+			assert.NoError(t, err)
+
+			var (
+				wg        sync.WaitGroup
+				succeeded int64
+			)
+			for i := 0; i < 20; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					_, err := ledgers(i).Commit(context.Background(), []core.Transaction{
+						{
+							Postings: []core.Posting{
+								{
+									Source:      account,
+									Destination: "world",
+									Amount:      10,
+									Asset:       "COIN",
+								},
+							},
+						},
+					})
+					if err == nil {
+						atomic.AddInt64(&succeeded, 1)
+						return
+					}
+					if !errors.Is(err, ErrConflict) {
+						txErr := &TransactionError{}
+						assert.True(t, errors.As(err, &txErr))
+						assert.True(t, errors.Is(txErr.Err, ErrInsufficientFunds))
+					}
+				}(i)
+			}
+			wg.Wait()
+
+			balance, err := l.GetAccountBalance(context.Background(), account, "COIN")
+			assert.NoError(t, err)
+			assert.GreaterOrEqual(t, balance, int64(0))
+			assert.Equal(t, 100-10*succeeded, balance)
+		}
+
+		t.Run("same ledger", func(t *testing.T) {
+			drain(t, "users:drain:1", func(i int) *Ledger {
+				return l
+			})
+		})
+
+		// Ledgers with their own locker behave like distinct processes sharing the store
+		t.Run("distinct ledgers", func(t *testing.T) {
+			drain(t, "users:drain:2", func(i int) *Ledger {
+				other, err := NewLedger("test", l.store, NewInMemoryLocker(), WithCommitRetries(20))
+				assert.NoError(t, err)
+				return other
+			})
+		})
+	})
+}
+
+func TestSubscribe(t *testing.T) {
+	with(func(l *Ledger) {
+		var (
+			lock     sync.Mutex
+			events   int
+			txs      int
+			balances = map[string]int64{}
+		)
+		unsubscribe := l.Subscribe(func(e core.CommittedTransactions) {
+			lock.Lock()
+			defer lock.Unlock()
+			events++
+			txs += len(e.Transactions)
+			balances["users:events"] += e.Balances["users:events"]["COIN"]
+		})
+		defer unsubscribe()
+
+		// A slow subscriber must not block the commits
+		release := make(chan struct{})
+		defer l.Subscribe(func(core.CommittedTransactions) {
+			<-release
+		})()
+		defer close(release)
+
+		committed := 0
+		for i := 1; i <= 5; i++ {
+			batch := []core.Transaction{}
+			for j := 0; j < i; j++ {
+				batch = append(batch, core.Transaction{
+					Postings: []core.Posting{
+						{
+							Source:      "world",
+							Destination: "users:events",
+							Amount:      10,
+							Asset:       "COIN",
+						},
+					},
+				})
+			}
+			_, err := l.Commit(context.Background(), batch)
+			assert.NoError(t, err)
+			committed += len(batch)
+		}
+
+		// Rejected transactions must not emit events
+		_, err := l.Commit(context.Background(), []core.Transaction{
+			{
+				Postings: []core.Posting{
+					{
+						Source:      "users:events",
+						Destination: "world",
+						Amount:      1000,
+						Asset:       "COIN",
+					},
+				},
+			},
+		})
+		assert.Error(t, err)
+
+		assert.Eventually(t, func() bool {
+			lock.Lock()
+			defer lock.Unlock()
+			return events == 5
+		}, time.Second, 10*time.Millisecond)
+
+		lock.Lock()
+		defer lock.Unlock()
+		assert.Equal(t, committed, txs)
+		assert.EqualValues(t, 10*committed, balances["users:events"])
+	})
+}
+
 func TestRevertTransaction(t *testing.T) {
 	with(func(l *Ledger) {
 		revertAmt := int64(100)
@@ -484,6 +2007,302 @@ func TestRevertTransaction(t *testing.T) {
 	})
 }
 
+func TestTransactionRecorder(t *testing.T) {
+	with(func(l *Ledger) {
+		ctx, recorder := WithTransactionRecorder(context.Background())
+
+		txs, err := l.Commit(ctx, []core.Transaction{
+			{Postings: []core.Posting{{Source: "world", Destination: "recorder:001", Amount: 100, Asset: "REC"}}},
+			{Postings: []core.Posting{{Source: "world", Destination: "recorder:002", Amount: 100, Asset: "REC"}}},
+		})
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		err = l.RevertTransaction(ctx, fmt.Sprint(txs[0].ID))
+		if !assert.NoError(t, err) {
+			return
+		}
+		revert, err := l.GetLastTransaction(context.Background())
+		assert.NoError(t, err)
+
+		// The failed commits and the commits made with other contexts are not recorded
+		_, err = l.Commit(ctx, []core.Transaction{
+			{Postings: []core.Posting{{Source: "recorder:001", Destination: "world", Amount: 1000, Asset: "REC"}}},
+		})
+		assert.Error(t, err)
+		_, err = l.Commit(context.Background(), []core.Transaction{
+			{Postings: []core.Posting{{Source: "world", Destination: "recorder:001", Amount: 100, Asset: "REC"}}},
+		})
+		assert.NoError(t, err)
+
+		assert.Equal(t, []int64{txs[0].ID, txs[1].ID, revert.ID}, recorder.IDs())
+	})
+}
+
+func TestRevertTransactionTwice(t *testing.T) {
+	with(func(l *Ledger) {
+		txs, err := l.Commit(context.Background(), []core.Transaction{{
+			Postings: []core.Posting{
+				{
+					Source:      "world",
+					Destination: "payments:003",
+					Amount:      100,
+					Asset:       "COIN",
+				},
+			},
+		}})
+		assert.NoError(t, err)
+		id := fmt.Sprint(txs[0].ID)
+
+		err = l.RevertTransaction(context.Background(), id)
+		assert.NoError(t, err)
+
+		revertTx, err := l.GetLastTransaction(context.Background())
+		assert.NoError(t, err)
+		assert.True(t, revertTx.Metadata.IsRevert())
+
+		reverted, err := l.GetTransaction(context.Background(), id)
+		assert.NoError(t, err)
+		assert.True(t, reverted.Metadata.IsReverted())
+		assert.Equal(t, json.RawMessage(fmt.Sprintf(`"%d"`, revertTx.ID)), reverted.Metadata["scheme/state/reverted-by"])
+
+		revertedBy, ok := reverted.Metadata.RevertedBy()
+		assert.True(t, ok)
+		assert.Equal(t, revertTx.ID, revertedBy)
+		reverts, ok := revertTx.Metadata.Reverts()
+		assert.True(t, ok)
+		assert.Equal(t, txs[0].ID, reverts)
+
+		err = l.RevertTransaction(context.Background(), id)
+		assert.True(t, errors.Is(err, ErrAlreadyReverted), err)
+
+		err = l.RevertTransaction(context.Background(), fmt.Sprint(revertTx.ID))
+		assert.True(t, errors.Is(err, ErrRevertOfRevert), err)
+
+		err = l.RevertTransaction(context.Background(), "999999")
+		assert.True(t, errors.Is(err, ErrTransactionNotFound), err)
+
+		payments, err := l.GetAccount(context.Background(), "payments:003")
+		assert.NoError(t, err)
+		assert.EqualValues(t, 0, payments.Balances["COIN"])
+	})
+}
+
+func TestRevertTransactionConcurrently(t *testing.T) {
+	with(func(l *Ledger) {
+		txs, err := l.Commit(context.Background(), []core.Transaction{{
+			Postings: []core.Posting{
+				{
+					Source:      "world",
+					Destination: "payments:004",
+					Amount:      100,
+					Asset:       "COIN",
+				},
+			},
+		}})
+		assert.NoError(t, err)
+
+		var (
+			wg       sync.WaitGroup
+			reverted int32
+		)
+		for i := 0; i < 5; i++ {
+			// Ledgers with their own locker behave like distinct processes sharing the store
+			other, err := NewLedger("test", l.store, NewInMemoryLocker(), WithCommitRetries(20))
+			assert.NoError(t, err)
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				err := other.RevertTransaction(context.Background(), fmt.Sprint(txs[0].ID))
+				if err == nil {
+					atomic.AddInt32(&reverted, 1)
+					return
+				}
+				assert.True(t, errors.Is(err, ErrAlreadyReverted) || errors.Is(err, ErrConflict), err)
+			}()
+		}
+		wg.Wait()
+
+		assert.EqualValues(t, 1, reverted)
+	})
+}
+
+func TestPartialRevert(t *testing.T) {
+	with(func(l *Ledger) {
+		txs, err := l.Commit(context.Background(), []core.Transaction{{
+			Postings: []core.Posting{
+				{
+					Source:      "world",
+					Destination: "users:partial",
+					Amount:      100,
+					Asset:       "COIN",
+				},
+				{
+					Source:      "users:partial",
+					Destination: "merchants:partial",
+					Amount:      100,
+					Asset:       "COIN",
+				},
+			},
+			Reference: "payment_partial",
+		}})
+		assert.NoError(t, err)
+		id := fmt.Sprint(txs[0].ID)
+
+		refund := func(amount int64) []core.Posting {
+			return []core.Posting{{
+				Source:      "merchants:partial",
+				Destination: "users:partial",
+				Amount:      amount,
+				Asset:       "COIN",
+			}}
+		}
+
+		// Partial refund
+		assert.NoError(t, l.PartialRevert(context.Background(), id, refund(30)))
+
+		first, err := l.GetLastTransaction(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, "partial_revert_"+id+"_1", first.Reference)
+		assert.True(t, first.Metadata.IsRevert())
+
+		tx, err := l.GetTransaction(context.Background(), id)
+		assert.NoError(t, err)
+		assert.True(t, tx.Metadata.IsPartiallyReverted())
+		assert.False(t, tx.Metadata.IsReverted())
+		ids, reverted, err := tx.Metadata.PartialReverts()
+		assert.NoError(t, err)
+		assert.Equal(t, []string{fmt.Sprint(first.ID)}, ids)
+		assert.Equal(t, core.Postings{{Source: "merchants:partial", Destination: "users:partial", Amount: 30, Asset: "COIN"}}, reverted)
+
+		// Over-refund, across the partial reverts
+		err = l.PartialRevert(context.Background(), id, refund(71))
+		assert.True(t, errors.Is(err, ErrRevertExceeded), err)
+		assert.True(t, errors.Is(err, ErrValidation), err)
+
+		// Postings which don't reverse the transaction
+		err = l.PartialRevert(context.Background(), id, []core.Posting{{
+			Source:      "merchants:partial",
+			Destination: "world",
+			Amount:      10,
+			Asset:       "COIN",
+		}})
+		assert.True(t, errors.Is(err, ErrRevertExceeded), err)
+
+		// The transaction can't be reverted entirely once partially reverted
+		err = l.RevertTransaction(context.Background(), id)
+		assert.True(t, errors.Is(err, ErrAlreadyReverted), err)
+
+		// Exact refund of the remaining amounts
+		assert.NoError(t, l.PartialRevert(context.Background(), id, append(refund(70), core.Posting{
+			Source:      "users:partial",
+			Destination: "world",
+			Amount:      100,
+			Asset:       "COIN",
+		})))
+
+		last, err := l.GetLastTransaction(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, "partial_revert_"+id+"_2", last.Reference)
+
+		tx, err = l.GetTransaction(context.Background(), id)
+		assert.NoError(t, err)
+		assert.True(t, tx.Metadata.IsReverted())
+		assert.Equal(t, json.RawMessage(fmt.Sprintf(`"%d"`, last.ID)), tx.Metadata["scheme/state/reverted-by"])
+		ids, reverted, err = tx.Metadata.PartialReverts()
+		assert.NoError(t, err)
+		assert.Equal(t, []string{fmt.Sprint(first.ID), fmt.Sprint(last.ID)}, ids)
+		assert.Equal(t, core.Postings{
+			{Source: "users:partial", Destination: "world", Amount: 100, Asset: "COIN"},
+			{Source: "merchants:partial", Destination: "users:partial", Amount: 100, Asset: "COIN"},
+		}, reverted)
+
+		err = l.PartialRevert(context.Background(), id, refund(1))
+		assert.True(t, errors.Is(err, ErrAlreadyReverted), err)
+
+		err = l.PartialRevert(context.Background(), fmt.Sprint(last.ID), refund(1))
+		assert.True(t, errors.Is(err, ErrRevertOfRevert), err)
+
+		for _, address := range []string{"users:partial", "merchants:partial"} {
+			account, err := l.GetAccount(context.Background(), address)
+			assert.NoError(t, err)
+			assert.EqualValues(t, 0, account.Balances["COIN"], address)
+		}
+	})
+}
+
+func TestRevertTransactionByReference(t *testing.T) {
+	with(func(l *Ledger) {
+		_, err := l.Commit(context.Background(), []core.Transaction{{
+			Reference: "payment_processor_id_42",
+			Postings: []core.Posting{
+				{
+					Source:      "world",
+					Destination: "payments:002",
+					Amount:      100,
+					Asset:       "COIN",
+				},
+			},
+		}})
+		assert.NoError(t, err)
+
+		err = l.RevertTransactionByReference(context.Background(), "payment_processor_id_42")
+		assert.NoError(t, err)
+
+		revertTx, err := l.GetLastTransaction(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, "revert_payment_processor_id_42", revertTx.Reference)
+		assert.Equal(t, "payments:002", revertTx.Postings[0].Source)
+		assert.Equal(t, "world", revertTx.Postings[0].Destination)
+
+		payments, err := l.GetAccount(context.Background(), "payments:002")
+		assert.NoError(t, err)
+		assert.EqualValues(t, 0, payments.Balances["COIN"])
+
+		// A transaction followed by more transactions than a page of the lookup is found too
+		txs := []core.Transaction{{
+			Reference: "payment_processor_id_43",
+			Postings: []core.Posting{
+				{
+					Source:      "world",
+					Destination: "payments:003",
+					Amount:      100,
+					Asset:       "COIN",
+				},
+			},
+		}}
+		for i := 0; i < 5; i++ {
+			txs = append(txs, core.Transaction{
+				Postings: []core.Posting{
+					{
+						Source:      "world",
+						Destination: "payments:004",
+						Amount:      100,
+						Asset:       "COIN",
+					},
+				},
+			})
+		}
+		_, err = l.Commit(context.Background(), txs)
+		assert.NoError(t, err)
+
+		err = l.RevertTransactionByReference(context.Background(), "payment_processor_id_43")
+		assert.NoError(t, err)
+
+		payments, err = l.GetAccount(context.Background(), "payments:003")
+		assert.NoError(t, err)
+		assert.EqualValues(t, 0, payments.Balances["COIN"])
+
+		err = l.RevertTransactionByReference(context.Background(), "unknown")
+		assert.True(t, errors.Is(err, ErrTransactionNotFound))
+
+		err = l.RevertTransactionByReference(context.Background(), "")
+		assert.Error(t, err)
+	})
+}
+
 func BenchmarkTransaction1(b *testing.B) {
 	with(func(l *Ledger) {
 		for n := 0; n < b.N; n++ {
@@ -545,3 +2364,214 @@ func BenchmarkFindTransactions(b *testing.B) {
 		}
 	})
 }
+
+func TestGetAccountAt(t *testing.T) {
+	with(func(*Ledger) {
+		// A ledger of its own, so that the timestamps can be chosen
+		l := newEmptyLedger(t)
+
+		start := time.Date(2021, time.December, 1, 10, 0, 0, 0, time.UTC)
+		for i, offset := range []time.Duration{0, time.Hour, time.Hour, 2 * time.Hour} {
+			_, err := l.Commit(context.Background(), []core.Transaction{{
+				Postings: []core.Posting{
+					{Source: "world", Destination: "users:history", Amount: int64(i + 1), Asset: "COIN"},
+				},
+				Timestamp: start.Add(offset).Format(time.RFC3339),
+			}})
+			assert.NoError(t, err)
+		}
+
+		for _, tc := range []struct {
+			at      time.Time
+			balance int64
+		}{
+			{at: start.Add(-time.Second), balance: 0},
+			{at: start, balance: 1},
+			{at: start.Add(time.Hour - time.Second), balance: 1},
+			// Both transactions sharing the timestamp are included
+			{at: start.Add(time.Hour), balance: 6},
+			{at: start.Add(time.Hour + 500*time.Millisecond), balance: 6},
+			{at: start.Add(3 * time.Hour), balance: 10},
+		} {
+			account, err := l.GetAccountAt(context.Background(), "users:history", tc.at)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.balance, account.Balances["COIN"], tc.at)
+			if tc.balance > 0 {
+				assert.Equal(t, map[string]int64{
+					"input":  tc.balance,
+					"output": 0,
+				}, account.Volumes["COIN"], tc.at)
+			}
+		}
+	})
+}
+
+func TestRecomputeBalances(t *testing.T) {
+	with(func(*Ledger) {
+		l := newEmptyLedger(t)
+
+		_, err := l.Commit(context.Background(), []core.Transaction{
+			{
+				Postings: []core.Posting{
+					{Source: "world", Destination: "users:001", Amount: 100, Asset: "COIN"},
+					{Source: "world", Destination: "users:002", Amount: 50, Asset: "GEM"},
+				},
+			},
+			{
+				Postings: []core.Posting{
+					{Source: "users:001", Destination: "users:002", Amount: 40, Asset: "COIN"},
+				},
+			},
+		})
+		assert.NoError(t, err)
+		assert.NoError(t, l.RevertTransaction(context.Background(), "1"))
+
+		// The balances maintained by the store are the ones aggregated from the postings
+		consistent := func() {
+			for _, address := range []string{"world", "users:001", "users:002"} {
+				account, err := l.GetAccount(context.Background(), address)
+				assert.NoError(t, err)
+				aggregated, err := l.GetAccountAt(context.Background(), address, time.Now().Add(time.Hour))
+				assert.NoError(t, err)
+				assert.Equal(t, aggregated.Balances, account.Balances, address)
+				assert.Equal(t, aggregated.Volumes, account.Volumes, address)
+			}
+		}
+		consistent()
+
+		assert.NoError(t, l.RecomputeBalances(context.Background()))
+		consistent()
+
+		balance, err := l.GetAccountBalance(context.Background(), "users:001", "COIN")
+		assert.NoError(t, err)
+		assert.EqualValues(t, 100, balance)
+	})
+}
+
+func TestConversions(t *testing.T) {
+	with(func(l *Ledger) {
+		defer l.Close(context.Background())
+
+		conversion := func(amount int64) core.Transaction {
+			tx := core.Transaction{
+				Postings: []core.Posting{
+					{Source: "world", Destination: "users:fx", Amount: 10000, Asset: "EUR/2"},
+					{Source: "users:fx", Destination: "desk:fx", Amount: 10000, Asset: "EUR/2"},
+					{Source: "world", Destination: "users:fx", Amount: amount, Asset: "USD/2"},
+				},
+				Metadata: core.Metadata{},
+			}
+			tx.Metadata.SetConversions([]core.Conversion{{Source: 1, Destination: 2, Rate: "1.0845"}})
+			return tx
+		}
+
+		_, err := l.Commit(context.Background(), []core.Transaction{conversion(10800)})
+		assert.True(t, errors.Is(err, ErrValidation), err)
+
+		committed, err := l.Commit(context.Background(), []core.Transaction{conversion(10845)})
+		assert.NoError(t, err)
+
+		// The rate is stored along with the transaction
+		tx, err := l.GetTransaction(context.Background(), fmt.Sprint(committed[0].ID))
+		assert.NoError(t, err)
+		conversions, err := tx.Metadata.Conversions()
+		assert.NoError(t, err)
+		assert.Equal(t, []core.Conversion{{Source: 1, Destination: 2, Rate: "1.0845"}}, conversions)
+
+		WithConversionTolerance(50)(l)
+		_, err = l.Commit(context.Background(), []core.Transaction{conversion(10800)})
+		assert.NoError(t, err)
+	})
+}
+
+func TestUncheckedAccounts(t *testing.T) {
+	with(func(l *Ledger) {
+		send := func(source string, amount int64) error {
+			_, err := l.Commit(context.Background(), []core.Transaction{{
+				Postings: []core.Posting{
+					{Source: source, Destination: "users:unchecked", Amount: amount, Asset: "UNC"},
+				},
+			}})
+			return err
+		}
+
+		assert.True(t, errors.Is(send("mint", 100), ErrInsufficientFunds))
+
+		WithUncheckedAccounts("world", "mint", "external:*")(l)
+		defer WithUncheckedAccounts(DefaultUncheckedAccounts...)(l)
+
+		assert.NoError(t, send("mint", 100))
+		assert.NoError(t, send("external:bank:001", 50))
+		assert.NoError(t, send("world", 10))
+		assertBalance(t, l, "mint", "UNC", -100)
+		assertBalance(t, l, "external:bank:001", "UNC", -50)
+
+		// The pattern only matches the accounts under "external:", and the other accounts are still checked
+		assert.True(t, errors.Is(send("external", 1), ErrInsufficientFunds))
+		assert.True(t, errors.Is(send("minted", 1), ErrInsufficientFunds))
+		_, err := l.Commit(context.Background(), []core.Transaction{{
+			Postings: []core.Posting{
+				{Source: "users:unchecked", Destination: "burn", Amount: 161, Asset: "UNC"},
+			},
+		}})
+		assert.True(t, errors.Is(err, ErrInsufficientFunds), err)
+
+		// Without the default, world is checked like any account
+		WithUncheckedAccounts("mint")(l)
+		assert.True(t, errors.Is(send("world", 1), ErrInsufficientFunds))
+		assert.NoError(t, send("mint", 1))
+	})
+}
+
+func TestFlowBetween(t *testing.T) {
+	with(func(l *Ledger) {
+		committed, err := l.Commit(context.Background(), []core.Transaction{
+			{
+				Postings: []core.Posting{
+					{Source: "world", Destination: "users:flow", Amount: 100, Asset: "FLW"},
+					{Source: "users:flow", Destination: "merchants:flow", Amount: 60, Asset: "FLW"},
+				},
+			},
+			{
+				Postings: []core.Posting{
+					{Source: "users:flow", Destination: "merchants:flow", Amount: 30, Asset: "FLW"},
+				},
+			},
+			{
+				Postings: []core.Posting{
+					{Source: "merchants:flow", Destination: "users:flow", Amount: 20, Asset: "FLW"},
+				},
+			},
+		})
+		assert.NoError(t, err)
+
+		// The timestamps follow the ones of the transactions committed before, which may be in the future
+		first, err := time.Parse(time.RFC3339, committed[0].Timestamp)
+		assert.NoError(t, err)
+		last, err := time.Parse(time.RFC3339, committed[2].Timestamp)
+		assert.NoError(t, err)
+
+		flow, err := l.FlowBetween(context.Background(), "users:flow", "merchants:flow", "FLW", time.Time{}, time.Time{})
+		assert.NoError(t, err)
+		assert.EqualValues(t, 90, flow)
+
+		flow, err = l.FlowBetween(context.Background(), "users:flow", "merchants:flow", "FLW", first, last.Add(time.Second))
+		assert.NoError(t, err)
+		assert.EqualValues(t, 90, flow)
+
+		flow, err = l.FlowBetween(context.Background(), "users:flow", "merchants:flow", "FLW", last.Add(time.Second), time.Time{})
+		assert.NoError(t, err)
+		assert.EqualValues(t, 0, flow)
+
+		flow, err = l.NetFlowBetween(context.Background(), "users:flow", "merchants:flow", "FLW", time.Time{}, time.Time{})
+		assert.NoError(t, err)
+		assert.EqualValues(t, 70, flow)
+
+		flow, err = l.NetFlowBetween(context.Background(), "merchants:flow", "users:flow", "FLW", time.Time{}, time.Time{})
+		assert.NoError(t, err)
+		assert.EqualValues(t, -70, flow)
+
+		_, err = l.FlowBetween(context.Background(), "users:flow", "merchants:flow", "FLW", last, first.Add(-time.Second))
+		assert.True(t, errors.Is(err, ErrValidation), err)
+	})
+}
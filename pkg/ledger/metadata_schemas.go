@@ -0,0 +1,216 @@
+package ledger
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/numary/ledger/pkg/core"
+	"github.com/numary/ledger/pkg/storage"
+	"github.com/pkg/errors"
+	"github.com/xeipuuv/gojsonschema"
+)
+
+const (
+	// targetTypeMetadataSchema is the metadata target type of the schemas of the metadata, keyed by target type
+	targetTypeMetadataSchema = "metadata_schema"
+	metadataSchemaKey        = "schema"
+	// reservedMetadataPrefix prefixes the metadata keys managed by the ledger, like the revert marks,
+	// which the schemas don't apply to
+	reservedMetadataPrefix = "scheme/"
+)
+
+// MetadataFieldError reports a metadata field which doesn't conform to the schema of its target type
+type MetadataFieldError struct {
+	// Field is the path of the field in the metadata, like "customer.id", or "(root)" for the metadata themselves
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// MetadataSchemaError is returned when metadata don't conform to the schema registered for their target type,
+// see SetMetadataSchema. It matches ErrValidation.
+type MetadataSchemaError struct {
+	TargetType string
+	// TargetID is the target of the metadata, empty for the metadata of a committed transaction
+	TargetID string
+	Errors   []MetadataFieldError
+}
+
+func (e *MetadataSchemaError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		msgs[i] = fmt.Sprintf("%s: %s", err.Field, err.Message)
+	}
+	target := e.TargetType
+	if e.TargetID != "" {
+		target += " " + e.TargetID
+	}
+	return fmt.Sprintf("metadata of %s don't conform to the schema: %s", target, strings.Join(msgs, "; "))
+}
+
+func (e *MetadataSchemaError) Is(target error) bool {
+	return target == ErrValidation
+}
+
+// SetMetadataSchema registers the JSON Schema the metadata of a target type, account or transaction, must conform to.
+// The metadata saved afterwards are validated once merged with the current ones, as well as the metadata
+// of the committed transactions, while the metadata already saved are left as is. The keys managed by the ledger,
+// prefixed with "scheme/", are not validated. Setting the schema again replaces it.
+func (l *Ledger) SetMetadataSchema(ctx context.Context, targetType string, schema json.RawMessage) error {
+	ctx = storage.WithConsistentRead(ctx)
+	if err := validateMetaTargetType(targetType); err != nil {
+		return err
+	}
+	if _, err := gojsonschema.NewSchema(gojsonschema.NewBytesLoader(schema)); err != nil {
+		return newValidationError("invalid metadata schema: %s", err)
+	}
+
+	unlock, err := l.locker.Lock(l.name)
+	if err != nil {
+		return errors.Wrap(err, "unable to acquire lock")
+	}
+	defer unlock()
+
+	lastMetaID, err := l.store.LastMetaID(ctx)
+	if err != nil {
+		return err
+	}
+
+	return l.store.SaveMetaBatch(ctx, []storage.MetaEntry{{
+		ID:         lastMetaID + 1,
+		Timestamp:  time.Now().Format(time.RFC3339),
+		TargetType: targetTypeMetadataSchema,
+		TargetID:   targetType,
+		Key:        metadataSchemaKey,
+		Value:      string(schema),
+	}})
+}
+
+// GetMetadataSchema returns the schema of the metadata of a target type, or ErrMetadataSchemaNotFound
+func (l *Ledger) GetMetadataSchema(ctx context.Context, targetType string) (json.RawMessage, error) {
+	if err := validateMetaTargetType(targetType); err != nil {
+		return nil, err
+	}
+
+	meta, err := l.store.GetMeta(ctx, targetTypeMetadataSchema, targetType)
+	if err != nil {
+		return nil, err
+	}
+	schema, ok := meta[metadataSchemaKey]
+	if !ok {
+		return nil, ErrMetadataSchemaNotFound
+	}
+
+	return schema, nil
+}
+
+// DeleteMetadataSchema removes the schema of the metadata of a target type, which are then free-form again
+func (l *Ledger) DeleteMetadataSchema(ctx context.Context, targetType string) error {
+	ctx = storage.WithConsistentRead(ctx)
+	if err := validateMetaTargetType(targetType); err != nil {
+		return err
+	}
+
+	unlock, err := l.locker.Lock(l.name)
+	if err != nil {
+		return errors.Wrap(err, "unable to acquire lock")
+	}
+	defer unlock()
+
+	return l.store.DeleteMeta(ctx, targetTypeMetadataSchema, targetType, []string{metadataSchemaKey})
+}
+
+// metadataSchema returns the compiled schema of the metadata of a target type, nil if there is none
+func (l *Ledger) metadataSchema(ctx context.Context, targetType string) (*gojsonschema.Schema, error) {
+	meta, err := l.store.GetMeta(ctx, targetTypeMetadataSchema, targetType)
+	if err != nil {
+		return nil, err
+	}
+	raw, ok := meta[metadataSchemaKey]
+	if !ok {
+		return nil, nil
+	}
+
+	schema, err := gojsonschema.NewSchema(gojsonschema.NewBytesLoader(raw))
+	if err != nil {
+		return nil, errors.Wrapf(err, "compiling metadata schema of %s", targetType)
+	}
+	return schema, nil
+}
+
+// checkMetaSchema validates the metadata of a target, once the metadata m are merged into its current metadata
+// and the deleted keys removed, against the schema of its type. It does nothing without schema.
+func (l *Ledger) checkMetaSchema(ctx context.Context, targetType, targetID string, m core.Metadata, deleted []string) error {
+	schema, err := l.metadataSchema(ctx, targetType)
+	if err != nil || schema == nil {
+		return err
+	}
+
+	merged, err := l.store.GetMeta(ctx, targetType, targetID)
+	if err != nil {
+		return err
+	}
+	for key, value := range m {
+		merged[key] = value
+	}
+	for _, key := range deleted {
+		delete(merged, key)
+	}
+	return validateMetaSchema(schema, targetType, targetID, merged)
+}
+
+// checkTransactionsMetaSchema validates the metadata of the transactions against the schema of the
+// transactions, reporting the first invalid one with a TransactionError
+func (l *Ledger) checkTransactionsMetaSchema(ctx context.Context, ts []core.Transaction) error {
+	schema, err := l.metadataSchema(ctx, targetTypeTransaction)
+	if err != nil || schema == nil {
+		return err
+	}
+
+	for i, tx := range ts {
+		if err := validateMetaSchema(schema, targetTypeTransaction, "", tx.Metadata); err != nil {
+			return &TransactionError{
+				Index: i,
+				Err:   err,
+			}
+		}
+	}
+	return nil
+}
+
+// validateMetaSchema returns a MetadataSchemaError if the metadata, without the keys managed by the ledger,
+// don't conform to the schema
+func validateMetaSchema(schema *gojsonschema.Schema, targetType, targetID string, m core.Metadata) error {
+	document := make(map[string]json.RawMessage, len(m))
+	for key, value := range m {
+		if !strings.HasPrefix(key, reservedMetadataPrefix) {
+			document[key] = value
+		}
+	}
+	data, err := json.Marshal(document)
+	if err != nil {
+		return newValidationError("invalid metadata: %s", err)
+	}
+
+	result, err := schema.Validate(gojsonschema.NewBytesLoader(data))
+	if err != nil {
+		return err
+	}
+	if result.Valid() {
+		return nil
+	}
+
+	schemaErr := &MetadataSchemaError{
+		TargetType: targetType,
+		TargetID:   targetID,
+	}
+	for _, e := range result.Errors() {
+		schemaErr.Errors = append(schemaErr.Errors, MetadataFieldError{
+			Field:   e.Field(),
+			Message: e.Description(),
+		})
+	}
+	return schemaErr
+}
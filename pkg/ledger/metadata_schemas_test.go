@@ -0,0 +1,118 @@
+package ledger
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/numary/ledger/pkg/core"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetadataSchema(t *testing.T) {
+	l := newEmptyLedger(t)
+	ctx := context.Background()
+
+	_, err := l.GetMetadataSchema(ctx, "account")
+	assert.True(t, errors.Is(err, ErrMetadataSchemaNotFound), err)
+	assert.True(t, errors.Is(err, ErrNotFound), err)
+
+	err = l.SetMetadataSchema(ctx, "account", json.RawMessage(`{"type": 42}`))
+	assert.True(t, errors.Is(err, ErrValidation), err)
+	err = l.SetMetadataSchema(ctx, "posting", json.RawMessage(`{}`))
+	assert.True(t, errors.Is(err, ErrValidation), err)
+
+	accountSchema := json.RawMessage(`{
+		"type": "object",
+		"required": ["kyc_status"],
+		"properties": {
+			"kyc_status": {"enum": ["pending", "verified"]}
+		}
+	}`)
+	assert.NoError(t, l.SetMetadataSchema(ctx, "account", accountSchema))
+
+	schema, err := l.GetMetadataSchema(ctx, "account")
+	assert.NoError(t, err)
+	assert.JSONEq(t, string(accountSchema), string(schema))
+
+	assert.NoError(t, l.SaveMeta(ctx, "account", "users:001", core.Metadata{
+		"kyc_status": json.RawMessage(`"pending"`),
+	}))
+	// The other keys are merged with the current metadata
+	assert.NoError(t, l.SaveMeta(ctx, "account", "users:001", core.Metadata{
+		"name": json.RawMessage(`"alice"`),
+	}))
+
+	err = l.SaveMeta(ctx, "account", "users:001", core.Metadata{
+		"kyc_status": json.RawMessage(`"unknown"`),
+	})
+	assert.True(t, errors.Is(err, ErrValidation), err)
+	schemaErr := &MetadataSchemaError{}
+	if assert.True(t, errors.As(err, &schemaErr), err) {
+		assert.Equal(t, "users:001", schemaErr.TargetID)
+		if assert.Len(t, schemaErr.Errors, 1) {
+			assert.Equal(t, "kyc_status", schemaErr.Errors[0].Field)
+		}
+	}
+
+	err = l.SaveMeta(ctx, "account", "users:002", core.Metadata{
+		"name": json.RawMessage(`"bob"`),
+	})
+	assert.True(t, errors.Is(err, ErrValidation), err)
+
+	err = l.DeleteMeta(ctx, "account", "users:001", []string{"kyc_status"})
+	assert.True(t, errors.Is(err, ErrValidation), err)
+	assert.NoError(t, l.DeleteMeta(ctx, "account", "users:001", []string{"name"}))
+
+	err = l.CreateAccount(ctx, "users:003", core.Metadata{})
+	assert.True(t, errors.Is(err, ErrValidation), err)
+
+	// The metadata of the committed transactions are validated, the keys managed by the ledger excepted
+	assert.NoError(t, l.SetMetadataSchema(ctx, "transaction", json.RawMessage(`{
+		"type": "object",
+		"additionalProperties": false,
+		"properties": {
+			"order_id": {"type": "string"}
+		}
+	}`)))
+
+	txs, err := l.Commit(ctx, []core.Transaction{{
+		Postings: []core.Posting{
+			{Source: "world", Destination: "users:001", Amount: 100, Asset: "COIN"},
+		},
+		Metadata: core.Metadata{
+			"order_id": json.RawMessage(`"42"`),
+		},
+	}})
+	assert.NoError(t, err)
+
+	_, err = l.Commit(ctx, []core.Transaction{{
+		Postings: []core.Posting{
+			{Source: "world", Destination: "users:001", Amount: 100, Asset: "COIN"},
+		},
+	}, {
+		Postings: []core.Posting{
+			{Source: "world", Destination: "users:001", Amount: 100, Asset: "COIN"},
+		},
+		Metadata: core.Metadata{
+			"order_id": json.RawMessage(`42`),
+		},
+	}})
+	assert.True(t, errors.Is(err, ErrValidation), err)
+	txErr := &TransactionError{}
+	if assert.True(t, errors.As(err, &txErr), err) {
+		assert.Equal(t, 1, txErr.Index)
+	}
+
+	assert.NoError(t, l.RevertTransaction(ctx, fmt.Sprint(txs[0].ID)))
+
+	// Without schema, the metadata are free-form again
+	assert.NoError(t, l.DeleteMetadataSchema(ctx, "account"))
+	assert.NoError(t, l.SaveMeta(ctx, "account", "users:002", core.Metadata{
+		"kyc_status": json.RawMessage(`"unknown"`),
+	}))
+	_, err = l.GetMetadataSchema(ctx, "account")
+	assert.True(t, errors.Is(err, ErrMetadataSchemaNotFound), err)
+}
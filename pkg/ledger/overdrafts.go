@@ -0,0 +1,134 @@
+package ledger
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/numary/ledger/pkg/storage"
+	"github.com/pkg/errors"
+)
+
+const (
+	// targetTypeOverdraft is the metadata target type of the overdraft limits, keyed by account then asset
+	targetTypeOverdraft = "overdraft"
+	// OverdraftMetadataKey is the account metadata key allowing the account to go negative in every asset,
+	// down to -value, like {"overdraft": 100000}. The value must be a positive integer.
+	OverdraftMetadataKey = "overdraft"
+)
+
+// SetOverdraft allows an account to go negative in an asset, down to -limit. The limit is checked
+// by Commit along with the balance, whether the postings come from a script or not, and added to the
+// balance the scripts can send from the account. It takes precedence over the overdraft of the
+// account metadata, see OverdraftMetadataKey. A zero limit removes the overdraft.
+func (l *Ledger) SetOverdraft(ctx context.Context, address, asset string, limit int64) error {
+	ctx = storage.WithConsistentRead(ctx)
+	if address == "" {
+		return newValidationError("empty account address")
+	}
+	if asset == "" {
+		return newValidationError("empty asset")
+	}
+	if limit < 0 {
+		return newValidationError("invalid overdraft limit %d: expected a positive amount", limit)
+	}
+
+	if l.normalizeAssets {
+		asset = strings.ToUpper(asset)
+	}
+
+	unlock, err := l.locker.Lock(l.name)
+	if err != nil {
+		return errors.Wrap(err, "unable to acquire lock")
+	}
+	defer unlock()
+
+	if limit == 0 {
+		return l.store.DeleteMeta(ctx, targetTypeOverdraft, address, []string{asset})
+	}
+
+	lastMetaID, err := l.store.LastMetaID(ctx)
+	if err != nil {
+		return err
+	}
+
+	return l.store.SaveMetaBatch(ctx, []storage.MetaEntry{{
+		ID:         lastMetaID + 1,
+		Timestamp:  time.Now().Format(time.RFC3339),
+		TargetType: targetTypeOverdraft,
+		TargetID:   address,
+		Key:        asset,
+		Value:      fmt.Sprint(limit),
+	}})
+}
+
+// GetOverdrafts returns the overdraft limits set on an account with SetOverdraft, keyed by asset
+func (l *Ledger) GetOverdrafts(ctx context.Context, address string) (map[string]int64, error) {
+	meta, err := l.store.GetMeta(ctx, targetTypeOverdraft, address)
+	if err != nil {
+		return nil, err
+	}
+
+	limits := make(map[string]int64, len(meta))
+	for asset, value := range meta {
+		var limit int64
+		err = json.Unmarshal(value, &limit)
+		if err != nil {
+			return nil, errors.Wrapf(err, "reading overdraft of account %s in %s", address, asset)
+		}
+		limits[asset] = limit
+	}
+
+	return limits, nil
+}
+
+// overdrafts are the limits an account can go negative down to
+type overdrafts struct {
+	// assets are the limits set with SetOverdraft
+	assets map[string]int64
+	// account is the limit of the account metadata, for the other assets
+	account int64
+}
+
+func (o *overdrafts) limit(asset string) int64 {
+	if limit, ok := o.assets[asset]; ok {
+		return limit
+	}
+	return o.account
+}
+
+// overdrafts returns the overdraft limits of an account. The unchecked accounts, like world,
+// have no limit at all, see WithUncheckedAccounts.
+func (l *Ledger) overdrafts(ctx context.Context, address string) (*overdrafts, error) {
+	assets, err := l.GetOverdrafts(ctx, address)
+	if err != nil {
+		return nil, err
+	}
+
+	meta, err := l.store.GetMeta(ctx, targetTypeAccount, address)
+	if err != nil {
+		return nil, err
+	}
+	// Values saved before the key was recognized may not be valid, they are ignored
+	account, _ := parseOverdraft(meta[OverdraftMetadataKey])
+
+	return &overdrafts{
+		assets:  assets,
+		account: account,
+	}, nil
+}
+
+// parseOverdraft reads the value of the overdraft metadata key
+func parseOverdraft(value json.RawMessage) (int64, bool) {
+	if value == nil {
+		return 0, false
+	}
+	var limit int64
+	err := json.Unmarshal(value, &limit)
+	if err != nil || limit < 0 {
+		return 0, false
+	}
+	return limit, true
+}
@@ -0,0 +1,156 @@
+package ledger
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/numary/ledger/pkg/core"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOverdraft(t *testing.T) {
+	with(func(l *Ledger) {
+		send := func(amount int64) error {
+			_, err := l.Commit(context.Background(), []core.Transaction{{
+				Postings: []core.Posting{
+					{Source: "users:overdraft", Destination: "payouts:overdraft", Amount: amount, Asset: "ODC"},
+				},
+			}})
+			return err
+		}
+
+		_, err := l.Commit(context.Background(), []core.Transaction{{
+			Postings: []core.Posting{
+				{Source: "world", Destination: "users:overdraft", Amount: 10, Asset: "ODC"},
+			},
+		}})
+		assert.NoError(t, err)
+
+		err = l.SetOverdraft(context.Background(), "users:overdraft", "ODC", 50)
+		assert.NoError(t, err)
+
+		overdrafts, err := l.GetOverdrafts(context.Background(), "users:overdraft")
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]int64{"ODC": 50}, overdrafts)
+
+		assert.NoError(t, send(40))
+		assertBalance(t, l, "users:overdraft", "ODC", -30)
+
+		err = send(21)
+		insufficient := &InsufficientFundsError{}
+		if assert.True(t, errors.As(err, &insufficient), err) {
+			assert.Equal(t, &InsufficientFundsError{
+				Account:   "users:overdraft",
+				Asset:     "ODC",
+				Needed:    21,
+				Available: -30,
+				Overdraft: 50,
+			}, insufficient)
+		}
+		assert.Contains(t, err.Error(), "overdraft limit of 50")
+
+		// The scripts can send the balance and the overdraft
+		_, err = l.Execute(context.Background(), core.Script{
+			Plain: `send [ODC *] (
+				source = @users:overdraft
+				destination = @payouts:overdraft
+			)`,
+		})
+		assert.NoError(t, err)
+		assertBalance(t, l, "users:overdraft", "ODC", -50)
+
+		_, err = l.Execute(context.Background(), core.Script{
+			Plain: `send [ODC 1] (
+				source = @users:overdraft
+				destination = @payouts:overdraft
+			)`,
+		})
+		assert.Error(t, err)
+
+		// Removing the overdraft keeps the balance, which can't be debited anymore
+		err = l.SetOverdraft(context.Background(), "users:overdraft", "ODC", 0)
+		assert.NoError(t, err)
+
+		overdrafts, err = l.GetOverdrafts(context.Background(), "users:overdraft")
+		assert.NoError(t, err)
+		assert.Empty(t, overdrafts)
+
+		_, err = l.Commit(context.Background(), []core.Transaction{{
+			Postings: []core.Posting{
+				{Source: "world", Destination: "users:overdraft", Amount: 60, Asset: "ODC"},
+			},
+		}})
+		assert.NoError(t, err)
+		assert.True(t, errors.Is(send(11), ErrInsufficientFunds))
+		assert.NoError(t, send(10))
+
+		err = l.SetOverdraft(context.Background(), "users:overdraft", "ODC", -1)
+		assert.True(t, errors.Is(err, ErrValidation), err)
+	})
+}
+
+func TestOverdraftMetadata(t *testing.T) {
+	with(func(l *Ledger) {
+		send := func(source string, amount int64) error {
+			_, err := l.Commit(context.Background(), []core.Transaction{{
+				Postings: []core.Posting{
+					{Source: source, Destination: "payouts:floor", Amount: amount, Asset: "FLC"},
+				},
+			}})
+			return err
+		}
+
+		err := l.SaveMeta(context.Background(), targetTypeAccount, "clearing:floor", core.Metadata{
+			OverdraftMetadataKey: json.RawMessage(`100`),
+		})
+		assert.NoError(t, err)
+
+		// Down to the floor
+		assert.NoError(t, send("clearing:floor", 60))
+		assert.NoError(t, send("clearing:floor", 40))
+		assertBalance(t, l, "clearing:floor", "FLC", -100)
+
+		// Below the floor
+		err = send("clearing:floor", 1)
+		insufficient := &InsufficientFundsError{}
+		if assert.True(t, errors.As(err, &insufficient), err) {
+			assert.Equal(t, &InsufficientFundsError{
+				Account:   "clearing:floor",
+				Asset:     "FLC",
+				Needed:    1,
+				Available: -100,
+				Overdraft: 100,
+			}, insufficient)
+		}
+
+		// Without the metadata, the floor is zero
+		err = send("users:floor", 1)
+		if assert.True(t, errors.As(err, &insufficient), err) {
+			assert.EqualValues(t, 0, insufficient.Overdraft)
+		}
+
+		// The world account has no floor at all
+		assert.NoError(t, send("world", 1000))
+
+		// An overdraft set for the asset takes precedence over the metadata
+		err = l.SetOverdraft(context.Background(), "clearing:floor", "FLC", 150)
+		assert.NoError(t, err)
+		assert.NoError(t, send("clearing:floor", 50))
+		assert.Error(t, send("clearing:floor", 1))
+
+		for _, value := range []string{`-1`, `1.5`, `"100"`, `{"FLC": 100}`} {
+			err = l.SaveMeta(context.Background(), targetTypeAccount, "clearing:floor", core.Metadata{
+				OverdraftMetadataKey: json.RawMessage(value),
+			})
+			assert.True(t, errors.Is(err, ErrValidation), value)
+		}
+
+		// The key is only recognized on the accounts
+		err = l.SaveMeta(context.Background(), targetTypeTransaction, "0", core.Metadata{
+			OverdraftMetadataKey: json.RawMessage(`"none"`),
+		})
+		assert.NoError(t, err)
+	})
+}
@@ -0,0 +1,119 @@
+package ledger
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/numary/ledger/pkg/core"
+	"github.com/numary/ledger/pkg/ledger/query"
+	"github.com/numary/ledger/pkg/storage"
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultPostingLogPollInterval is the default of PostingLogOptions.PollInterval
+const DefaultPostingLogPollInterval = time.Second
+
+// PostingLogOptions are the options of PostingLogWithOptions
+type PostingLogOptions struct {
+	// PollInterval is the interval at which the store is read for the transactions committed by the other
+	// processes sharing it, DefaultPostingLogPollInterval if zero. The transactions committed by this process
+	// are read as soon as they are committed.
+	PollInterval time.Duration
+}
+
+// PostingLog returns a channel receiving the postings of the transactions following the transaction afterTxid,
+// -1 to start from the first one, in the order of their commits, which is the order of the ids of their transactions,
+// and then the postings of the transactions committed from now on. The postings are read from the store by pages,
+// using the txid as key, so that the whole history can be read with a bounded memory, and a consumer recording
+// the txid of the last transaction it has received all the postings of, see core.LoggedPosting.Last,
+// can resume from there exactly.
+// The channel is closed once the context is done, or if the store can't be read.
+func (l *Ledger) PostingLog(ctx context.Context, afterTxid int64) (<-chan core.LoggedPosting, error) {
+	return l.PostingLogWithOptions(ctx, afterTxid, PostingLogOptions{})
+}
+
+// PostingLogWithOptions reads the log of the postings like PostingLog, with options
+func (l *Ledger) PostingLogWithOptions(ctx context.Context, afterTxid int64, opts PostingLogOptions) (<-chan core.LoggedPosting, error) {
+	if afterTxid < -1 {
+		return nil, newValidationError("invalid txid %d: expected a transaction id, or -1 to start from the first transaction", afterTxid)
+	}
+	if opts.PollInterval < 0 {
+		return nil, newValidationError("negative poll interval")
+	}
+	if opts.PollInterval == 0 {
+		opts.PollInterval = DefaultPostingLogPollInterval
+	}
+
+	// committed is signaled by the commits of the ledger, the signals pending being merged
+	committed := make(chan struct{}, 1)
+	// The bus may be shared by the ledgers of a resolver
+	unsubscribe := l.bus.Subscribe(func(e core.CommittedTransactions) {
+		if e.Ledger != l.name {
+			return
+		}
+		select {
+		case committed <- struct{}{}:
+		default:
+		}
+	})
+
+	postings := make(chan core.LoggedPosting)
+	go func() {
+		defer close(postings)
+		defer unsubscribe()
+
+		ticker := time.NewTicker(opts.PollInterval)
+		defer ticker.Stop()
+
+		q := query.New()
+		q.Modify(query.SortTransactions(true))
+		q.Limit = streamPageSize
+		last := afterTxid
+		for {
+			// The pages are read until the end of the log, the transactions committed meanwhile included
+			for {
+				if last >= 0 {
+					q.After = fmt.Sprint(last)
+				}
+				c, err := l.store.FindTransactions(storage.WithConsistentRead(ctx), q)
+				if err != nil {
+					if ctx.Err() == nil {
+						logrus.Errorf("reading the posting log of ledger %s after transaction %d: %s", l.name, last, err)
+					}
+					return
+				}
+
+				page := c.Data.([]core.Transaction)
+				for _, tx := range page {
+					for i, p := range tx.Postings {
+						select {
+						case <-ctx.Done():
+							return
+						case postings <- core.LoggedPosting{
+							Txid:      tx.ID,
+							Index:     i,
+							Last:      i == len(tx.Postings)-1,
+							Timestamp: tx.Timestamp,
+							Posting:   p,
+						}:
+						}
+					}
+					last = tx.ID
+				}
+				if !c.HasMore || len(page) == 0 {
+					break
+				}
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-committed:
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	return postings, nil
+}
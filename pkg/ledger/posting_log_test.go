@@ -0,0 +1,111 @@
+package ledger
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/numary/ledger/pkg/core"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPostingLog(t *testing.T) {
+	l := newEmptyLedger(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	commit := func(l *Ledger, txs ...core.Transaction) {
+		_, err := l.Commit(context.Background(), txs)
+		assert.NoError(t, err)
+	}
+	next := func(postings <-chan core.LoggedPosting) core.LoggedPosting {
+		select {
+		case p, ok := <-postings:
+			assert.True(t, ok, "posting log closed")
+			return p
+		case <-time.After(5 * time.Second):
+			t.Fatal("no posting received")
+			return core.LoggedPosting{}
+		}
+	}
+
+	_, err := l.PostingLog(ctx, -2)
+	assert.True(t, errors.Is(err, ErrValidation), err)
+
+	// More transactions than a page, for the log to be read by several pages
+	txs := make([]core.Transaction, 0, streamPageSize+50)
+	for i := 0; i < streamPageSize+50; i++ {
+		txs = append(txs, core.Transaction{
+			Postings: []core.Posting{
+				{Source: "world", Destination: "users:001", Amount: 100, Asset: "COIN"},
+				{Source: "users:001", Destination: "users:002", Amount: 10, Asset: "COIN"},
+			},
+		})
+	}
+	commit(l, txs...)
+
+	postings, err := l.PostingLog(ctx, -1)
+	assert.NoError(t, err)
+	for i := 0; i < 2*len(txs); i++ {
+		p := next(postings)
+		assert.EqualValues(t, i/2, p.Txid)
+		assert.Equal(t, i%2, p.Index)
+		assert.Equal(t, i%2 == 1, p.Last)
+		assert.Equal(t, txs[i/2].Postings[i%2], p.Posting)
+		assert.NotEmpty(t, p.Timestamp)
+	}
+
+	// The log is resumed after a transaction, and followed
+	last := int64(len(txs) - 1)
+	resumed, err := l.PostingLog(ctx, last-1)
+	assert.NoError(t, err)
+	assert.Equal(t, core.LoggedPosting{Txid: last, Index: 0}, withoutPosting(next(resumed)))
+	assert.Equal(t, core.LoggedPosting{Txid: last, Index: 1, Last: true}, withoutPosting(next(resumed)))
+
+	commit(l, core.Transaction{
+		Postings: []core.Posting{
+			{Source: "users:002", Destination: "users:003", Amount: 5, Asset: "COIN"},
+		},
+	})
+	for _, postings := range []<-chan core.LoggedPosting{postings, resumed} {
+		p := next(postings)
+		assert.EqualValues(t, last+1, p.Txid)
+		assert.Equal(t, "users:003", p.Posting.Destination)
+	}
+
+	// The transactions committed by the other processes sharing the store are read by polling it
+	polled, err := l.PostingLogWithOptions(ctx, last+1, PostingLogOptions{
+		PollInterval: 50 * time.Millisecond,
+	})
+	assert.NoError(t, err)
+	other, err := NewLedger(l.name, l.store, l.locker)
+	assert.NoError(t, err)
+	commit(other, core.Transaction{
+		Postings: []core.Posting{
+			{Source: "users:003", Destination: "users:004", Amount: 5, Asset: "COIN"},
+		},
+	})
+	p := next(polled)
+	assert.EqualValues(t, last+2, p.Txid)
+	assert.Equal(t, "users:004", p.Posting.Destination)
+
+	cancel()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case _, ok := <-polled:
+			if !ok {
+				return
+			}
+		case <-timeout:
+			t.Fatal("posting log not closed")
+		}
+	}
+}
+
+func withoutPosting(p core.LoggedPosting) core.LoggedPosting {
+	p.Timestamp = ""
+	p.Posting = core.Posting{}
+	return p
+}
@@ -0,0 +1,114 @@
+package ledger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/numary/ledger/pkg/core"
+)
+
+// ErrPreconditionFailed is matched by the PreconditionError returned when a precondition of a commit doesn't hold
+var ErrPreconditionFailed = errors.New("precondition failed")
+
+// BalanceOperator compares the balance of an account with the amount of a BalancePrecondition
+type BalanceOperator string
+
+const (
+	BalanceAtLeast BalanceOperator = ">="
+	BalanceEqual   BalanceOperator = "=="
+	BalanceAtMost  BalanceOperator = "<="
+)
+
+// BalancePrecondition asserts the balance of an account in an asset, like "users:001 has at least 100 COIN",
+// see CommitOptions.Preconditions
+type BalancePrecondition struct {
+	Account  string          `json:"account"`
+	Asset    string          `json:"asset"`
+	Operator BalanceOperator `json:"operator" example:">="`
+	Amount   int64           `json:"amount"`
+}
+
+func (p BalancePrecondition) String() string {
+	return fmt.Sprintf("balance of %s in %s %s %d", p.Account, p.Asset, p.Operator, p.Amount)
+}
+
+func (p BalancePrecondition) holds(balance int64) bool {
+	switch p.Operator {
+	case BalanceAtLeast:
+		return balance >= p.Amount
+	case BalanceEqual:
+		return balance == p.Amount
+	default:
+		return balance <= p.Amount
+	}
+}
+
+// PreconditionError is returned by Commit when a precondition of the commit doesn't hold,
+// in which case none of the transactions of the batch are committed. It matches ErrPreconditionFailed.
+type PreconditionError struct {
+	// Index of the precondition in CommitOptions.Preconditions
+	Index        int
+	Precondition BalancePrecondition
+	// Balance is the balance of the account before the batch
+	Balance int64
+}
+
+func (e *PreconditionError) Error() string {
+	return fmt.Sprintf("precondition %d failed: %s, is %d", e.Index, e.Precondition, e.Balance)
+}
+
+func (e *PreconditionError) Is(target error) bool {
+	return target == ErrPreconditionFailed
+}
+
+func validatePreconditions(preconditions []BalancePrecondition) error {
+	for i, p := range preconditions {
+		switch {
+		case p.Account == "":
+			return newValidationError("precondition %d: missing account", i)
+		case !core.AssetIsValid(p.Asset):
+			return newValidationError("precondition %d: invalid asset '%s'", i, p.Asset)
+		case p.Operator != BalanceAtLeast && p.Operator != BalanceEqual && p.Operator != BalanceAtMost:
+			return newValidationError("precondition %d: invalid operator '%s': expected %s, %s or %s",
+				i, p.Operator, BalanceAtLeast, BalanceEqual, BalanceAtMost)
+		}
+	}
+	return nil
+}
+
+// normalizePreconditions returns a copy of the preconditions with the asset codes in uppercase, see WithAssetNormalization
+func normalizePreconditions(preconditions []BalancePrecondition) []BalancePrecondition {
+	normalized := make([]BalancePrecondition, len(preconditions))
+	for i, p := range preconditions {
+		p.Asset = strings.ToUpper(p.Asset)
+		normalized[i] = p
+	}
+	return normalized
+}
+
+// checkPreconditions checks the preconditions against the balances before the batch. It is run by process,
+// along with the checks of the balances: a concurrent commit of another process makes the save conflict
+// and the preconditions be checked again.
+func (l *Ledger) checkPreconditions(ctx context.Context, preconditions []BalancePrecondition) error {
+	balances := map[string]map[string]int64{}
+	for i, p := range preconditions {
+		if _, ok := balances[p.Account]; !ok {
+			b, err := l.store.AggregateBalances(ctx, p.Account)
+			if err != nil {
+				return err
+			}
+			balances[p.Account] = b
+		}
+
+		if balance := balances[p.Account][p.Asset]; !p.holds(balance) {
+			return &PreconditionError{
+				Index:        i,
+				Precondition: p,
+				Balance:      balance,
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package ledger
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/numary/ledger/pkg/core"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPreconditions(t *testing.T) {
+	l := newEmptyLedger(t)
+	ctx := context.Background()
+
+	_, err := l.Commit(ctx, []core.Transaction{{
+		Postings: []core.Posting{
+			{Source: "world", Destination: "users:001", Amount: 100, Asset: "COIN"},
+		},
+	}})
+	assert.NoError(t, err)
+
+	debit := func(amount int64, preconditions ...BalancePrecondition) error {
+		_, err := l.CommitWithOptions(ctx, []core.Transaction{{
+			Postings: []core.Posting{
+				{Source: "users:001", Destination: "users:002", Amount: amount, Asset: "COIN"},
+			},
+		}}, CommitOptions{
+			Preconditions: preconditions,
+		})
+		return err
+	}
+
+	for _, p := range []BalancePrecondition{
+		{Asset: "COIN", Operator: BalanceAtLeast, Amount: 10},
+		{Account: "users:001", Asset: "coin?", Operator: BalanceAtLeast, Amount: 10},
+		{Account: "users:001", Asset: "COIN", Operator: ">", Amount: 10},
+	} {
+		err = debit(10, p)
+		assert.True(t, errors.Is(err, ErrValidation), err)
+	}
+
+	// The debit is only committed if the balance is high enough beforehand
+	assert.NoError(t, debit(40, BalancePrecondition{
+		Account: "users:001", Asset: "COIN", Operator: BalanceAtLeast, Amount: 60,
+	}))
+	err = debit(40, BalancePrecondition{
+		Account: "users:001", Asset: "COIN", Operator: BalanceAtLeast, Amount: 70,
+	})
+	assert.True(t, errors.Is(err, ErrPreconditionFailed), err)
+	preconditionErr := &PreconditionError{}
+	if assert.True(t, errors.As(err, &preconditionErr), err) {
+		assert.Equal(t, 0, preconditionErr.Index)
+		assert.EqualValues(t, 60, preconditionErr.Balance)
+	}
+
+	// All the preconditions must hold, the accounts not debited included
+	err = debit(10,
+		BalancePrecondition{Account: "users:001", Asset: "COIN", Operator: BalanceEqual, Amount: 60},
+		BalancePrecondition{Account: "users:002", Asset: "COIN", Operator: BalanceAtMost, Amount: 30},
+	)
+	preconditionErr = &PreconditionError{}
+	if assert.True(t, errors.As(err, &preconditionErr), err) {
+		assert.Equal(t, 1, preconditionErr.Index)
+		assert.EqualValues(t, 40, preconditionErr.Balance)
+	}
+	assert.NoError(t, debit(10,
+		BalancePrecondition{Account: "users:001", Asset: "COIN", Operator: BalanceEqual, Amount: 60},
+		BalancePrecondition{Account: "users:002", Asset: "COIN", Operator: BalanceAtMost, Amount: 40},
+		BalancePrecondition{Account: "users:003", Asset: "COIN", Operator: BalanceEqual, Amount: 0},
+	))
+
+	account, err := l.GetAccount(ctx, "users:001")
+	assert.NoError(t, err)
+	assert.EqualValues(t, 50, account.Balances["COIN"])
+	account, err = l.GetAccount(ctx, "users:002")
+	assert.NoError(t, err)
+	assert.EqualValues(t, 50, account.Balances["COIN"])
+}
@@ -1,12 +1,22 @@
 package query
 
+import (
+	"strings"
+	"time"
+)
+
 const (
 	DEFAULT_LIMIT = 15
+	MAX_LIMIT     = 100
 )
 
 type Query struct {
-	Limit  int
-	After  string
+	Limit int
+	// After restricts the query to the items following a keyset, to fetch the next page
+	After string
+	// Before restricts the query to the items preceding a keyset, to fetch the previous page.
+	// The items are still sorted in the same order.
+	Before string
 	Params map[string]interface{}
 }
 
@@ -57,6 +67,14 @@ func After(v string) func(*Query) {
 	}
 }
 
+func Before(v string) func(*Query) {
+	return func(q *Query) {
+		q.Before = v
+	}
+}
+
+// Account restricts the query to an account address. The address can end with a ":*" wildcard segment,
+// like "users:001:*", to match all the accounts under "users:001:" (but neither "users:001" nor "users:0010").
 func Account(v string) func(*Query) {
 	return func(q *Query) {
 		q.Params["account"] = v
@@ -80,3 +98,71 @@ func Reference(v string) func(*Query) {
 		q.Params["reference"] = v
 	}
 }
+
+// ReferencePrefix restricts the query to the transactions whose reference starts with the given prefix
+func ReferencePrefix(prefix string) func(*Query) {
+	return func(q *Query) {
+		q.Params["reference_prefix"] = prefix
+	}
+}
+
+func Asset(v string) func(*Query) {
+	return func(q *Query) {
+		q.Params["asset"] = v
+	}
+}
+
+// Metadata restricts the query to the transactions which metadata have the value at the key.
+// The key can be the path of a nested value, like "customer.id", its first segment being the metadata key.
+// The value is matched exactly against a string, or against the JSON text of a number or a boolean.
+// The filters add up, the transactions must match all of them.
+func Metadata(key, value string) func(*Query) {
+	return metadataFilter("metadata", key, value)
+}
+
+// AccountMetadata restricts the query to the accounts which metadata have the value at the key,
+// matched as with the Metadata filter of the transactions
+func AccountMetadata(key, value string) func(*Query) {
+	return metadataFilter("account_metadata", key, value)
+}
+
+func metadataFilter(param, key, value string) func(*Query) {
+	return func(q *Query) {
+		filters, ok := q.Params[param].(map[string]string)
+		if !ok {
+			filters = map[string]string{}
+			q.Params[param] = filters
+		}
+		filters[key] = value
+	}
+}
+
+// TimestampAfter restricts the query to the transactions strictly after t
+func TimestampAfter(t time.Time) func(*Query) {
+	return func(q *Query) {
+		q.Params["after_timestamp"] = t
+	}
+}
+
+// TimestampBefore restricts the query to the transactions strictly before t
+func TimestampBefore(t time.Time) func(*Query) {
+	return func(q *Query) {
+		q.Params["before_timestamp"] = t
+	}
+}
+
+// Address restricts the query to the accounts whose address starts with the given prefix
+func Address(prefix string) func(*Query) {
+	return func(q *Query) {
+		q.Params["address"] = prefix
+	}
+}
+
+// AccountPattern returns the address prefix matched by an account pattern ending with a ":*" wildcard segment.
+// It returns false if the pattern is a plain address.
+func AccountPattern(v string) (string, bool) {
+	if !strings.HasSuffix(v, ":*") {
+		return "", false
+	}
+	return strings.TrimSuffix(v, "*"), true
+}
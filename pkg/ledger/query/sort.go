@@ -0,0 +1,74 @@
+package query
+
+import (
+	"strconv"
+	"strings"
+)
+
+const (
+	SortByAddress = "address"
+	SortByBalance = "balance"
+	SortByTxID    = "txid"
+)
+
+// Sort is the order of the accounts returned by FindAccounts, or of the transactions returned by FindTransactions
+type Sort struct {
+	// By is SortByAddress or SortByBalance for the accounts, SortByTxID for the transactions
+	By string `json:"by"`
+	// Asset is the asset of the balances the accounts are sorted by
+	Asset string `json:"asset,omitempty"`
+	// Asc sorts the accounts in ascending order, they are sorted in descending order otherwise
+	Asc bool `json:"asc,omitempty"`
+	// TxID is the id of the last transaction the balances are computed at, so that the pages of
+	// a same query are sorted the same way while new transactions are committed. It is set by the
+	// ledger when fetching the first page. Accounts without postings up to TxID are not returned.
+	TxID *int64 `json:"txid,omitempty"`
+}
+
+// SortAccounts sorts the accounts as described by s. Accounts with a same balance are sorted by address,
+// in the same direction as the balances, so that the pages are stable.
+func SortAccounts(s Sort) func(*Query) {
+	return func(q *Query) {
+		q.Params["sort"] = s
+	}
+}
+
+// SortTransactions sorts the transactions by id, in ascending order if asc and in descending order otherwise
+func SortTransactions(asc bool) func(*Query) {
+	return SortAccounts(Sort{
+		By:  SortByTxID,
+		Asc: asc,
+	})
+}
+
+// Sorting returns the order of the items, by address in descending order by default.
+// The transactions are sorted by id in the direction of the order.
+func (q *Query) Sorting() Sort {
+	if s, ok := q.Params["sort"].(Sort); ok {
+		return s
+	}
+	return Sort{
+		By: SortByAddress,
+	}
+}
+
+// BalanceKeyset returns the keyset of an account of the pages sorted by balance
+func BalanceKeyset(balance int64, address string) string {
+	return strconv.FormatInt(balance, 10) + ":" + address
+}
+
+// ParseBalanceKeyset reads a keyset returned by BalanceKeyset.
+// It returns ErrInvalidToken if the keyset is malformed.
+func ParseBalanceKeyset(v string) (int64, string, error) {
+	i := strings.Index(v, ":")
+	if i < 0 {
+		return 0, "", ErrInvalidToken
+	}
+
+	balance, err := strconv.ParseInt(v[:i], 10, 64)
+	if err != nil {
+		return 0, "", ErrInvalidToken
+	}
+
+	return balance, v[i+1:], nil
+}
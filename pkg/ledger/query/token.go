@@ -0,0 +1,57 @@
+package query
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+)
+
+var ErrInvalidToken = errors.New("invalid pagination token")
+
+// Token is the continuation token of a paginated query, clients should handle it as an opaque string.
+// It holds the keyset of the last (or first) item of a page, so that the next (or previous) page
+// can be fetched efficiently.
+// It is bound to a ledger, and points either to the next or to the previous page.
+type Token struct {
+	Ledger   string `json:"ledger"`
+	After    string `json:"after,omitempty"`
+	Before   string `json:"before,omitempty"`
+	PageSize int    `json:"page_size"`
+	// Sort is the order of the items of the pages, if not the default one
+	Sort *Sort `json:"sort,omitempty"`
+}
+
+func (t Token) Encode() string {
+	b, _ := json.Marshal(t)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// DecodeToken decodes a token, which must have been issued for the given ledger
+func DecodeToken(ledger, v string) (Token, error) {
+	t := Token{}
+
+	b, err := base64.RawURLEncoding.DecodeString(v)
+	if err != nil {
+		return t, ErrInvalidToken
+	}
+
+	err = json.Unmarshal(b, &t)
+	if err != nil || t.Ledger != ledger || (t.After == "") == (t.Before == "") {
+		return t, ErrInvalidToken
+	}
+
+	return t, nil
+}
+
+// Modifiers returns the modifiers fetching the page the token points to
+func (t Token) Modifiers() []QueryModifier {
+	modifiers := []QueryModifier{
+		After(t.After),
+		Before(t.Before),
+		Limit(t.PageSize),
+	}
+	if t.Sort != nil {
+		modifiers = append(modifiers, SortAccounts(*t.Sort))
+	}
+	return modifiers
+}
@@ -0,0 +1,63 @@
+package query
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestToken(t *testing.T) {
+	txid := int64(42)
+	for _, token := range []Token{
+		{
+			Ledger:   "quickstart",
+			After:    "42",
+			PageSize: 10,
+		},
+		{
+			Ledger:   "quickstart",
+			Before:   "42",
+			PageSize: 10,
+		},
+		{
+			Ledger:   "quickstart",
+			After:    BalanceKeyset(-100, "users:001"),
+			PageSize: 10,
+			Sort: &Sort{
+				By:    SortByBalance,
+				Asset: "USD",
+				Asc:   true,
+				TxID:  &txid,
+			},
+		},
+	} {
+		decoded, err := DecodeToken("quickstart", token.Encode())
+		assert.NoError(t, err)
+		assert.Equal(t, token, decoded)
+
+		_, err = DecodeToken("other", token.Encode())
+		assert.Equal(t, ErrInvalidToken, err)
+	}
+
+	for _, v := range []string{
+		"",
+		"foo",
+		Token{Ledger: "quickstart"}.Encode(),
+		Token{Ledger: "quickstart", After: "1", Before: "2"}.Encode(),
+	} {
+		_, err := DecodeToken("quickstart", v)
+		assert.Equal(t, ErrInvalidToken, err, v)
+	}
+}
+
+func TestBalanceKeyset(t *testing.T) {
+	balance, address, err := ParseBalanceKeyset(BalanceKeyset(-100, "users:001:wallet"))
+	assert.NoError(t, err)
+	assert.EqualValues(t, -100, balance)
+	assert.Equal(t, "users:001:wallet", address)
+
+	for _, v := range []string{"", "users:001", "a:users:001"} {
+		_, _, err = ParseBalanceKeyset(v)
+		assert.Equal(t, ErrInvalidToken, err, v)
+	}
+}
@@ -0,0 +1,42 @@
+package ledger
+
+import (
+	"context"
+	"sync"
+
+	"github.com/numary/ledger/pkg/core"
+)
+
+type recorderKey struct{}
+
+// TransactionRecorder collects the ids of the transactions committed with a context, see WithTransactionRecorder
+type TransactionRecorder struct {
+	mu  sync.Mutex
+	ids []int64
+}
+
+// WithTransactionRecorder returns a context recording the ids of the transactions committed or imported with it,
+// whichever the method of the ledger, like the reverting transactions of RevertTransaction
+func WithTransactionRecorder(ctx context.Context) (context.Context, *TransactionRecorder) {
+	r := &TransactionRecorder{}
+	return context.WithValue(ctx, recorderKey{}, r), r
+}
+
+// IDs returns the ids recorded so far, in the order of the commits
+func (r *TransactionRecorder) IDs() []int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]int64{}, r.ids...)
+}
+
+func recordTransactions(ctx context.Context, ts []core.Transaction) {
+	r, ok := ctx.Value(recorderKey{}).(*TransactionRecorder)
+	if !ok {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, t := range ts {
+		r.ids = append(r.ids, t.ID)
+	}
+}
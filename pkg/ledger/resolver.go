@@ -3,13 +3,20 @@ package ledger
 import (
 	"context"
 	"fmt"
+	"github.com/numary/ledger/pkg/core"
 	"github.com/numary/ledger/pkg/storage"
 	"github.com/numary/ledger/pkg/storage/sqlstorage"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
+	"regexp"
+	"sort"
 	"sync"
 )
 
+// ledgerNameRegexp restricts the names of the ledgers created with Resolver.CreateLedger.
+// Ledger names are used as postgres schema names, and the names starting with an underscore are reserved for the API.
+var ledgerNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_-]{0,62}$`)
+
 type ResolverOption interface {
 	apply(r *Resolver) error
 }
@@ -33,22 +40,46 @@ func WithLocker(locker Locker) ResolveOptionFn {
 	})
 }
 
+// WithLedgerOptions adds options applied to the ledgers returned by the resolver
+func WithLedgerOptions(options ...LedgerOption) ResolveOptionFn {
+	return ResolveOptionFn(func(r *Resolver) error {
+		r.ledgerOptions = append(r.ledgerOptions, options...)
+		return nil
+	})
+}
+
+// WithNamedLedgerOptions adds options applied to the ledger of the given name only, after the other options
+func WithNamedLedgerOptions(name string, options ...LedgerOption) ResolveOptionFn {
+	return ResolveOptionFn(func(r *Resolver) error {
+		r.namedLedgerOptions[name] = append(r.namedLedgerOptions[name], options...)
+		return nil
+	})
+}
+
 var DefaultResolverOptions = []ResolverOption{
 	WithStorageFactory(storage.NewDefaultFactory(sqlstorage.NewInMemorySQLiteDriver())),
 	WithLocker(NewInMemoryLocker()),
 }
 
 type Resolver struct {
-	storageFactory    storage.Factory
-	locker            Locker
-	lock              sync.RWMutex
-	initializedStores map[string]struct{}
+	storageFactory     storage.Factory
+	locker             Locker
+	ledgerOptions      []LedgerOption
+	namedLedgerOptions map[string][]LedgerOption
+	bus                *EventBus
+	lock               sync.RWMutex
+	initializedStores  map[string]struct{}
+	limitsLock         sync.RWMutex
+	limits             Limits
 }
 
 func NewResolver(options ...ResolverOption) *Resolver {
 	options = append(DefaultResolverOptions, options...)
 	r := &Resolver{
-		initializedStores: map[string]struct{}{},
+		initializedStores:  map[string]struct{}{},
+		namedLedgerOptions: map[string][]LedgerOption{},
+		bus:                NewEventBus(),
+		limits:             DefaultLimits,
 	}
 	for _, opt := range options {
 		err := opt.apply(r)
@@ -60,6 +91,7 @@ func NewResolver(options ...ResolverOption) *Resolver {
 	return r
 }
 
+// GetLedger returns a ledger, initializing its store the first time it is requested
 func (r *Resolver) GetLedger(ctx context.Context, name string) (*Ledger, error) {
 
 	store, err := r.storageFactory.GetStore(name)
@@ -89,5 +121,115 @@ func (r *Resolver) GetLedger(ctx context.Context, name string) (*Ledger, error)
 	}
 
 ret:
-	return NewLedger(name, store, r.locker)
+	options := append([]LedgerOption{WithEventBus(r.bus)}, r.options(name)...)
+	return NewLedger(name, store, r.locker, options...)
+}
+
+// options returns the options of the ledger of the given name
+func (r *Resolver) options(name string) []LedgerOption {
+	options := append([]LedgerOption{WithLimits(r.Limits())}, r.ledgerOptions...)
+	return append(options, r.namedLedgerOptions[name]...)
+}
+
+// SetLimits sets the limits of the ledgers returned by the resolver from now on, DefaultLimits by default.
+// The limits set by the options of the ledgers take precedence.
+func (r *Resolver) SetLimits(limits Limits) {
+	r.limitsLock.Lock()
+	defer r.limitsLock.Unlock()
+	r.limits = limits
+}
+
+// Limits returns the limits of the ledgers returned by the resolver, see SetLimits
+func (r *Resolver) Limits() Limits {
+	r.limitsLock.RLock()
+	defer r.limitsLock.RUnlock()
+	return r.limits
+}
+
+// CreateLedger provisions the store of a new ledger, running its migrations, so that it is listed by Ledgers
+// without having to commit to it first. Creating a ledger already opened or created by the resolver fails
+// with ErrLedgerAlreadyExists, while a ledger existing in the store but not opened yet is left as is.
+func (r *Resolver) CreateLedger(ctx context.Context, name string) error {
+	if !ledgerNameRegexp.MatchString(name) {
+		return newValidationError("invalid ledger name '%s': expected up to 63 letters, digits, '_' or '-', not starting with '_' or '-'", name)
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if _, ok := r.initializedStores[name]; ok {
+		return fmt.Errorf("%w: %s", ErrLedgerAlreadyExists, name)
+	}
+
+	store, err := r.storageFactory.GetStore(name)
+	if err != nil {
+		return err
+	}
+	// The ledgers returned by GetLedger get their own store
+	defer store.Close(ctx)
+
+	err = store.Initialize(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to initialize store: %w", err)
+	}
+	r.initializedStores[name] = struct{}{}
+
+	return nil
+}
+
+// DropLedger deletes all the data of a ledger. The ledger is no longer listed by Ledgers,
+// and its store is initialized again, as a new ledger, the next time it is requested.
+func (r *Resolver) DropLedger(ctx context.Context, name string) error {
+	store, err := r.storageFactory.GetStore(name)
+	if err != nil {
+		return err
+	}
+	defer store.Close(ctx)
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	l, err := NewLedger(name, store, r.locker, r.options(name)...)
+	if err != nil {
+		return err
+	}
+
+	err = l.Drop(ctx)
+	if err != nil {
+		return err
+	}
+	delete(r.initializedStores, name)
+
+	return nil
+}
+
+// Ping checks that the storage backend of a ledger can be reached, without initializing its store
+func (r *Resolver) Ping(ctx context.Context, name string) error {
+	store, err := r.storageFactory.GetStore(name)
+	if err != nil {
+		return err
+	}
+	defer store.Close(ctx)
+
+	return store.Ping(ctx)
+}
+
+// Ledgers returns the sorted names of the ledgers opened or created since the resolver was created
+func (r *Resolver) Ledgers() []string {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	names := make([]string, 0, len(r.initializedStores))
+	for name := range r.initializedStores {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// Subscribe registers a handler called with the transactions committed by the ledgers
+// returned by the resolver. See EventBus.Subscribe.
+func (r *Resolver) Subscribe(handler func(core.CommittedTransactions)) func() {
+	return r.bus.Subscribe(handler)
 }
@@ -0,0 +1,137 @@
+package ledger
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/numary/ledger/pkg/core"
+	"github.com/numary/ledger/pkg/storage"
+	"github.com/numary/ledger/pkg/storage/memorystorage"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolverLedgers(t *testing.T) {
+	with(func(*Ledger) {
+		resolver := NewResolver(WithStorageFactory(storage.NewDefaultFactory(driver)))
+		assert.Empty(t, resolver.Ledgers())
+
+		err := resolver.CreateLedger(context.Background(), "resolver-created")
+		assert.NoError(t, err)
+
+		err = resolver.CreateLedger(context.Background(), "resolver-created")
+		assert.True(t, errors.Is(err, ErrLedgerAlreadyExists), err)
+
+		for _, name := range []string{"", "_info", "-ledger", `quoted"ledger`, "a/b"} {
+			err = resolver.CreateLedger(context.Background(), name)
+			assert.True(t, errors.Is(err, ErrValidation), name)
+		}
+
+		l, err := resolver.GetLedger(context.Background(), "resolver_opened")
+		assert.NoError(t, err)
+		defer l.Close(context.Background())
+
+		assert.Equal(t, []string{"resolver-created", "resolver_opened"}, resolver.Ledgers())
+	})
+}
+
+func TestResolverDropLedger(t *testing.T) {
+	// The ledgers of the in memory sqlite driver share the same tables, dropping one would drop them all
+	resolver := NewResolver(WithStorageFactory(storage.NewDefaultFactory(memorystorage.NewDriver("memory"))))
+
+	l, err := resolver.GetLedger(context.Background(), "dropped")
+	assert.NoError(t, err)
+	_, err = l.Commit(context.Background(), []core.Transaction{{
+		Postings: []core.Posting{
+			{Source: "world", Destination: "users:dropped", Amount: 100, Asset: "COIN"},
+		},
+	}})
+	assert.NoError(t, err)
+	assert.NoError(t, l.Close(context.Background()))
+
+	err = resolver.DropLedger(context.Background(), "dropped")
+	assert.NoError(t, err)
+	assert.Empty(t, resolver.Ledgers())
+
+	l, err = resolver.GetLedger(context.Background(), "dropped")
+	assert.NoError(t, err)
+	defer l.Close(context.Background())
+
+	stats, err := l.Stats(context.Background())
+	assert.NoError(t, err)
+	assert.EqualValues(t, 0, stats.Transactions)
+	assert.EqualValues(t, 0, stats.Accounts)
+
+	balance, err := l.GetAccountBalance(context.Background(), "users:dropped", "COIN")
+	assert.True(t, errors.Is(err, ErrAccountNotFound), err)
+	assert.EqualValues(t, 0, balance)
+
+	committed, err := l.Commit(context.Background(), []core.Transaction{{
+		Postings: []core.Posting{
+			{Source: "world", Destination: "users:dropped", Amount: 10, Asset: "COIN"},
+		},
+	}})
+	assert.NoError(t, err)
+	assert.EqualValues(t, 0, committed[0].ID)
+
+	// The hash chain starts again as well
+	assert.NoError(t, l.Verify(context.Background()))
+}
+
+func TestResolverNamedLedgerOptions(t *testing.T) {
+	resolver := NewResolver(
+		WithStorageFactory(storage.NewDefaultFactory(memorystorage.NewDriver("memory"))),
+		WithLedgerOptions(WithUncheckedAccounts("world", "mint")),
+		WithNamedLedgerOptions("minting", WithUncheckedAccounts("world", "mint", "burn")),
+	)
+
+	send := func(name string) error {
+		l, err := resolver.GetLedger(context.Background(), name)
+		if err != nil {
+			return err
+		}
+		defer l.Close(context.Background())
+
+		_, err = l.Commit(context.Background(), []core.Transaction{{
+			Postings: []core.Posting{
+				{Source: "mint", Destination: "users:001", Amount: 100, Asset: "COIN"},
+				{Source: "burn", Destination: "users:001", Amount: 100, Asset: "COIN"},
+			},
+		}})
+		return err
+	}
+
+	assert.NoError(t, send("minting"))
+	err := send("other")
+	assert.True(t, errors.Is(err, ErrInsufficientFunds), err)
+}
+
+func TestResolverSetLimits(t *testing.T) {
+	resolver := NewResolver(WithStorageFactory(storage.NewDefaultFactory(memorystorage.NewDriver("memory"))))
+	assert.Equal(t, DefaultLimits, resolver.Limits())
+
+	commit := func() error {
+		l, err := resolver.GetLedger(context.Background(), "limited")
+		if err != nil {
+			return err
+		}
+		defer l.Close(context.Background())
+
+		_, err = l.Commit(context.Background(), []core.Transaction{{
+			Postings: []core.Posting{
+				{Source: "world", Destination: "users:001", Amount: 100, Asset: "COIN"},
+				{Source: "world", Destination: "users:002", Amount: 100, Asset: "COIN"},
+			},
+		}})
+		return err
+	}
+	assert.NoError(t, commit())
+
+	// The ledgers returned after the change get the new limits
+	limits := DefaultLimits
+	limits.MaxPostingsPerTransaction = 1
+	resolver.SetLimits(limits)
+	assert.Equal(t, limits, resolver.Limits())
+	err := commit()
+	assert.True(t, errors.Is(err, ErrValidation), err)
+}
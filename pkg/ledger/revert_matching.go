@@ -0,0 +1,119 @@
+package ledger
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/numary/ledger/pkg/core"
+	"github.com/numary/ledger/pkg/ledger/query"
+	"github.com/numary/ledger/pkg/storage"
+	"github.com/pkg/errors"
+)
+
+// RevertMatchingOptions are the options of RevertMatching
+type RevertMatchingOptions struct {
+	// DryRun makes RevertMatching return the reverting transactions it would commit, without committing them
+	DryRun bool
+	// Confirm must be set to commit the reverting transactions, RevertMatching returns ErrRevertNotConfirmed otherwise
+	Confirm bool
+}
+
+// RevertMatching reverts the transactions matching the filters of the query, like a reference prefix
+// or a time range, in a single batch committed atomically. The transactions are reverted from the newest
+// to the oldest, as RevertTransaction would. The transactions already reverted, partially or entirely,
+// and the reverting transactions are skipped. It returns the reverting transactions, in the order of the batch.
+//
+// At least one filter is required, and the batch is subject to the limits of the commits.
+// Reverting must be confirmed with RevertMatchingOptions.Confirm, unless the revert is a dry run.
+func (l *Ledger) RevertMatching(ctx context.Context, opts RevertMatchingOptions, m ...query.QueryModifier) ([]core.Transaction, error) {
+	ctx = storage.WithConsistentRead(ctx)
+
+	q := query.New(m)
+	// The transactions are walked in descending order, the newest being reverted first
+	delete(q.Params, "sort")
+	filtered := false
+	for name := range q.Params {
+		filtered = filtered || q.HasParam(name)
+	}
+	if !filtered {
+		return nil, newValidationError("a filter is required to revert the matching transactions")
+	}
+	if !opts.DryRun && !opts.Confirm {
+		return nil, ErrRevertNotConfirmed
+	}
+
+	matching, err := l.revertibleTransactions(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	if len(matching) == 0 {
+		return []core.Transaction{}, nil
+	}
+
+	rts := make([]core.Transaction, len(matching))
+	ids := make([]string, len(matching))
+	for i, tx := range matching {
+		ids[i] = fmt.Sprint(tx.ID)
+		rts[i] = tx.Reverse()
+		rts[i].Metadata = core.Metadata{}
+		rts[i].Metadata.MarkReverts(ids[i])
+	}
+
+	if opts.DryRun {
+		return l.previewReverts(ctx, rts, ids)
+	}
+
+	return l.CommitWithOptions(ctx, rts, CommitOptions{
+		bulkReverts: ids,
+	})
+}
+
+// revertibleTransactions returns all the transactions matching the query, from the newest to the oldest,
+// except the transactions which can't be reverted
+func (l *Ledger) revertibleTransactions(ctx context.Context, q query.Query) ([]core.Transaction, error) {
+	q.Limit = query.MAX_LIMIT
+	q.Before = ""
+	q.After = ""
+
+	txs := make([]core.Transaction, 0)
+	for {
+		c, err := l.store.FindTransactions(ctx, q)
+		if err != nil {
+			return nil, err
+		}
+
+		page := c.Data.([]core.Transaction)
+		for _, tx := range page {
+			if tx.Metadata.IsReverted() || tx.Metadata.IsPartiallyReverted() || tx.Metadata.IsRevert() {
+				continue
+			}
+			txs = append(txs, tx)
+		}
+		if !c.HasMore || len(page) == 0 {
+			return txs, nil
+		}
+		q.After = fmt.Sprint(page[len(page)-1].ID)
+	}
+}
+
+// previewReverts validates the reverting transactions as a commit would, without saving them,
+// and returns them with the ids, timestamps and hashes they would be committed with
+func (l *Ledger) previewReverts(ctx context.Context, rts []core.Transaction, ids []string) ([]core.Transaction, error) {
+	if max := l.limits.MaxTransactionsPerBatch; max > 0 && len(rts) > max {
+		return nil, fmt.Errorf("%w: %d transactions, the limit is %d", ErrBatchTooLarge, len(rts), max)
+	}
+
+	unlock, err := l.locker.Lock(l.name)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to acquire lock")
+	}
+	defer unlock()
+
+	_, _, err = l.process(ctx, rts, CommitOptions{
+		bulkReverts: ids,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rts, nil
+}
@@ -0,0 +1,112 @@
+package ledger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/numary/ledger/pkg/core"
+	"github.com/numary/ledger/pkg/ledger/query"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRevertMatching(t *testing.T) {
+	l := newEmptyLedger(t)
+	ctx := context.Background()
+
+	commit := func(ref, src, dst string, amount int64) core.Transaction {
+		txs, err := l.Commit(ctx, []core.Transaction{{
+			Reference: ref,
+			Postings: []core.Posting{
+				{Source: src, Destination: dst, Amount: amount, Asset: "COIN"},
+			},
+		}})
+		assert.NoError(t, err)
+		return txs[0]
+	}
+	balance := func(address string) int64 {
+		account, err := l.GetAccount(ctx, address)
+		assert.NoError(t, err)
+		return account.Balances["COIN"]
+	}
+
+	bad1 := commit("import_1", "world", "users:001", 100)
+	bad2 := commit("import_2", "world", "users:001", 50)
+	commit("payment_1", "world", "users:002", 10)
+	bad3 := commit("import_3", "world", "users:001", 25)
+	assert.NoError(t, l.RevertTransaction(ctx, fmt.Sprint(bad2.ID)))
+
+	_, err := l.RevertMatching(ctx, RevertMatchingOptions{Confirm: true})
+	assert.True(t, errors.Is(err, ErrValidation), err)
+	_, err = l.RevertMatching(ctx, RevertMatchingOptions{}, query.ReferencePrefix("import_"))
+	assert.True(t, errors.Is(err, ErrRevertNotConfirmed), err)
+	assert.True(t, errors.Is(err, ErrValidation), err)
+
+	// The dry run reports the reverting transactions, the already reverted ones being skipped
+	preview, err := l.RevertMatching(ctx, RevertMatchingOptions{DryRun: true}, query.ReferencePrefix("import_"))
+	assert.NoError(t, err)
+	if assert.Len(t, preview, 2) {
+		assert.Equal(t, "revert_import_3", preview[0].Reference)
+		assert.Equal(t, "revert_import_1", preview[1].Reference)
+	}
+	assert.EqualValues(t, 125, balance("users:001"))
+	last, err := l.GetLastTransaction(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "revert_import_2", last.Reference)
+
+	rts, err := l.RevertMatching(ctx, RevertMatchingOptions{Confirm: true}, query.ReferencePrefix("import_"))
+	assert.NoError(t, err)
+	if assert.Len(t, rts, 2) {
+		assert.Equal(t, preview[0].ID, rts[0].ID)
+		assert.Equal(t, "revert_import_3", rts[0].Reference)
+		assert.Equal(t, "revert_import_1", rts[1].Reference)
+	}
+	assert.EqualValues(t, 0, balance("users:001"))
+	assert.EqualValues(t, 10, balance("users:002"))
+	for _, tx := range []core.Transaction{bad1, bad3} {
+		tx, err = l.GetTransaction(ctx, fmt.Sprint(tx.ID))
+		assert.NoError(t, err)
+		assert.True(t, tx.Metadata.IsReverted(), tx.Reference)
+	}
+
+	rts, err = l.RevertMatching(ctx, RevertMatchingOptions{Confirm: true}, query.ReferencePrefix("import_"))
+	assert.NoError(t, err)
+	assert.Empty(t, rts)
+
+	// The reverts are committed atomically, none of them if one of them can't be
+	commit("batch_1", "world", "users:003", 100)
+	commit("other", "users:003", "users:004", 60)
+	commit("batch_2", "world", "users:003", 10)
+	_, err = l.RevertMatching(ctx, RevertMatchingOptions{Confirm: true}, query.ReferencePrefix("batch_"))
+	assert.True(t, errors.Is(err, ErrInsufficientFunds), err)
+	txErr := &TransactionError{}
+	if assert.True(t, errors.As(err, &txErr), err) {
+		assert.Equal(t, 1, txErr.Index)
+	}
+	assert.EqualValues(t, 50, balance("users:003"))
+	last, err = l.GetLastTransaction(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "batch_2", last.Reference)
+
+	// The matching transactions followed by more transactions than a page are found too
+	commit("old_1", "world", "users:005", 100)
+	commit("old_2", "world", "users:005", 10)
+	txs := make([]core.Transaction, 0, query.MAX_LIMIT+10)
+	for i := 0; i < query.MAX_LIMIT+10; i++ {
+		txs = append(txs, core.Transaction{
+			Postings: []core.Posting{
+				{Source: "world", Destination: "users:006", Amount: 1, Asset: "COIN"},
+			},
+		})
+	}
+	_, err = l.Commit(ctx, txs)
+	assert.NoError(t, err)
+	rts, err = l.RevertMatching(ctx, RevertMatchingOptions{Confirm: true}, query.ReferencePrefix("old_"))
+	assert.NoError(t, err)
+	if assert.Len(t, rts, 2) {
+		assert.Equal(t, "revert_old_2", rts[0].Reference)
+		assert.Equal(t, "revert_old_1", rts[1].Reference)
+	}
+	assert.EqualValues(t, 0, balance("users:005"))
+}
@@ -0,0 +1,102 @@
+package ledger
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"sync"
+
+	"github.com/numary/machine/script/compiler"
+	"github.com/numary/machine/vm/program"
+)
+
+// DefaultScriptCacheSize is the number of compiled scripts kept by default
+const DefaultScriptCacheSize = 1024
+
+// ScriptCacheStats are the counters of a ScriptCache
+type ScriptCacheStats struct {
+	// Size is the number of compiled scripts in the cache, up to Capacity
+	Size     int   `json:"size"`
+	Capacity int   `json:"capacity"`
+	Hits     int64 `json:"hits"`
+	Misses   int64 `json:"misses"`
+}
+
+// ScriptCache keeps the programs compiled from the scripts, keyed by the hash of their source, so that the scripts
+// executed again are not compiled again. The least recently used programs are evicted beyond its capacity.
+// It can be shared by the ledgers, see WithScriptCache, the programs being only read by their executions.
+type ScriptCache struct {
+	mu       sync.Mutex
+	capacity int
+	entries  map[[sha256.Size]byte]*list.Element
+	// recent holds the scriptCacheEntry of the entries, the most recently used first
+	recent *list.List
+	hits   int64
+	misses int64
+}
+
+type scriptCacheEntry struct {
+	key     [sha256.Size]byte
+	program *program.Program
+}
+
+// NewScriptCache returns a cache keeping up to capacity compiled scripts
+func NewScriptCache(capacity int) *ScriptCache {
+	return &ScriptCache{
+		capacity: capacity,
+		entries:  map[[sha256.Size]byte]*list.Element{},
+		recent:   list.New(),
+	}
+}
+
+// compile returns the program of the script, compiling it if it isn't in the cache.
+// The scripts which fail to compile are not kept.
+func (c *ScriptCache) compile(source string) (*program.Program, error) {
+	key := sha256.Sum256([]byte(source))
+
+	c.mu.Lock()
+	if e, ok := c.entries[key]; ok {
+		c.recent.MoveToFront(e)
+		c.hits++
+		c.mu.Unlock()
+		return e.Value.(*scriptCacheEntry).program, nil
+	}
+	c.misses++
+	c.mu.Unlock()
+
+	// Compiled out of the lock, a script submitted concurrently may be compiled twice
+	p, err := compiler.Compile(source)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[key]; ok {
+		c.recent.MoveToFront(e)
+		return e.Value.(*scriptCacheEntry).program, nil
+	}
+	c.entries[key] = c.recent.PushFront(&scriptCacheEntry{
+		key:     key,
+		program: p,
+	})
+	for c.recent.Len() > c.capacity {
+		oldest := c.recent.Back()
+		c.recent.Remove(oldest)
+		delete(c.entries, oldest.Value.(*scriptCacheEntry).key)
+	}
+
+	return p, nil
+}
+
+// Stats returns the counters of the cache
+func (c *ScriptCache) Stats() ScriptCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return ScriptCacheStats{
+		Size:     c.recent.Len(),
+		Capacity: c.capacity,
+		Hits:     c.hits,
+		Misses:   c.misses,
+	}
+}
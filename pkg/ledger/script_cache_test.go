@@ -0,0 +1,76 @@
+package ledger
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/numary/ledger/pkg/core"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScriptCache(t *testing.T) {
+	cache := NewScriptCache(2)
+	script := func(amount int) string {
+		return fmt.Sprintf(`send [COIN %d] (
+			source = @world
+			destination = @users:001
+		)`, amount)
+	}
+
+	first, err := cache.compile(script(1))
+	assert.NoError(t, err)
+	again, err := cache.compile(script(1))
+	assert.NoError(t, err)
+	assert.Same(t, first, again)
+	assert.Equal(t, ScriptCacheStats{Size: 1, Capacity: 2, Hits: 1, Misses: 1}, cache.Stats())
+
+	// The least recently used script is evicted
+	_, err = cache.compile(script(2))
+	assert.NoError(t, err)
+	_, err = cache.compile(script(1))
+	assert.NoError(t, err)
+	_, err = cache.compile(script(3))
+	assert.NoError(t, err)
+	assert.Equal(t, ScriptCacheStats{Size: 2, Capacity: 2, Hits: 2, Misses: 3}, cache.Stats())
+
+	again, err = cache.compile(script(1))
+	assert.NoError(t, err)
+	assert.Same(t, first, again)
+	_, err = cache.compile(script(2))
+	assert.NoError(t, err)
+	assert.Equal(t, ScriptCacheStats{Size: 2, Capacity: 2, Hits: 3, Misses: 4}, cache.Stats())
+
+	// The scripts which don't compile are not kept
+	_, err = cache.compile("not a script")
+	assert.Error(t, err)
+	_, err = cache.compile("not a script")
+	assert.Error(t, err)
+	assert.Equal(t, ScriptCacheStats{Size: 2, Capacity: 2, Hits: 3, Misses: 6}, cache.Stats())
+}
+
+func TestExecuteWithScriptCache(t *testing.T) {
+	with(func(l *Ledger) {
+		defer l.Close(context.Background())
+		WithScriptCache(NewScriptCache(DefaultScriptCacheSize))(l)
+
+		script := core.Script{
+			Plain: `send [COIN 10] (
+				source = @world
+				destination = @users:cached
+			)`,
+		}
+		for i := 0; i < 3; i++ {
+			_, err := l.Execute(context.Background(), script)
+			assert.NoError(t, err)
+		}
+
+		balance, err := l.GetAccountBalance(context.Background(), "users:cached", "COIN")
+		assert.NoError(t, err)
+		assert.EqualValues(t, 30, balance)
+
+		stats, err := l.Stats(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, &ScriptCacheStats{Size: 1, Capacity: DefaultScriptCacheSize, Hits: 2, Misses: 1}, stats.Scripts)
+	})
+}
@@ -0,0 +1,178 @@
+package ledger
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+
+	machine "github.com/numary/machine/core"
+	"github.com/numary/machine/vm/program"
+)
+
+var (
+	// The values of the account and asset variables must be valid numscript literals,
+	// so that a variable can't hold what the script itself couldn't
+	scriptAccountRegexp = regexp.MustCompile(`^[a-z_]+[a-z0-9_:]*$`)
+	scriptAssetRegexp   = regexp.MustCompile(`^[A-Z/0-9]+$`)
+)
+
+// scriptVars checks the variables passed to a script against the variables it declares,
+// and returns their values. Every declared variable must be passed, with a value of its type,
+// and no other variable can be passed. The errors match ErrScriptVariable.
+func scriptVars(p *program.Program, vars map[string]json.RawMessage) (map[string]machine.Value, error) {
+	values := map[string]machine.Value{}
+	for _, res := range p.Resources {
+		param, ok := res.(program.Parameter)
+		if !ok {
+			continue
+		}
+
+		data, ok := vars[param.Name]
+		if !ok {
+			return nil, fmt.Errorf("%w: $%s of type %s is missing", ErrScriptVariable, param.Name, param.Typ)
+		}
+
+		value, err := scriptVar(param.Typ, data)
+		if err != nil {
+			return nil, fmt.Errorf("%w: $%s of type %s: %s", ErrScriptVariable, param.Name, param.Typ, err)
+		}
+		values[param.Name] = value
+	}
+
+	extra := make([]string, 0)
+	for name := range vars {
+		if _, ok := values[name]; !ok {
+			extra = append(extra, name)
+		}
+	}
+	if len(extra) > 0 {
+		sort.Strings(extra)
+		return nil, fmt.Errorf("%w: $%s is not declared by the script", ErrScriptVariable, extra[0])
+	}
+
+	return values, nil
+}
+
+// scriptVar decodes the JSON value of a variable of the given type
+func scriptVar(typ machine.Type, data json.RawMessage) (machine.Value, error) {
+	switch typ {
+	case machine.TYPE_ACCOUNT:
+		var account string
+		if err := json.Unmarshal(data, &account); err != nil || !scriptAccountRegexp.MatchString(account) {
+			return nil, fmt.Errorf("expected an account address, like \"users:001\"")
+		}
+		return machine.Account(account), nil
+	case machine.TYPE_ASSET:
+		var asset string
+		if err := json.Unmarshal(data, &asset); err != nil || !scriptAssetRegexp.MatchString(asset) {
+			return nil, fmt.Errorf("expected an asset code, like \"USD/2\"")
+		}
+		return machine.Asset(asset), nil
+	case machine.TYPE_NUMBER:
+		number, err := scriptNumber(data)
+		if err != nil {
+			return nil, fmt.Errorf("expected a positive integer")
+		}
+		return machine.Number(number), nil
+	case machine.TYPE_MONETARY:
+		var monetary struct {
+			Asset  *string         `json:"asset"`
+			Amount json.RawMessage `json:"amount"`
+		}
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		err := dec.Decode(&monetary)
+		if err == nil && (monetary.Asset == nil || !scriptAssetRegexp.MatchString(*monetary.Asset)) {
+			err = fmt.Errorf("invalid asset")
+		}
+		var amount uint64
+		if err == nil {
+			amount, err = scriptNumber(monetary.Amount)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("expected a monetary, like {\"asset\": \"USD/2\", \"amount\": 100}")
+		}
+		return machine.Monetary{
+			Asset:  machine.Asset(*monetary.Asset),
+			Amount: amount,
+		}, nil
+	default:
+		value, err := machine.NewValueFromJSON(typ, data)
+		if err != nil {
+			return nil, err
+		}
+		return *value, nil
+	}
+}
+
+// scriptNumber decodes a JSON integer, rejecting the strings, decimals and negative numbers
+func scriptNumber(data json.RawMessage) (uint64, error) {
+	var v interface{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	err := dec.Decode(&v)
+	if err != nil {
+		return 0, err
+	}
+	number, ok := v.(json.Number)
+	if !ok {
+		return 0, fmt.Errorf("not a number")
+	}
+	return strconv.ParseUint(number.String(), 10, 64)
+}
+
+var (
+	scriptCommentRegexp = regexp.MustCompile(`(?s)/\*.*?\*/|//[^\n]*`)
+	scriptVarsRegexp    = regexp.MustCompile(`^\s*vars\s*\{([^}]*)\}`)
+	scriptVarDeclRegexp = regexp.MustCompile(`(?:account|asset|number|monetary|portion)\s+\$([a-z_][a-z0-9_]*)`)
+)
+
+// scriptValues returns the values of the variables of a compiled script, by name, in the format of
+// the variables passed to it. The compiler allocates a resource per variable, in the order of
+// their declaration, while the names of the variables are read from the declarations.
+func scriptValues(plain string, p *program.Program, resources []machine.Value) map[string]interface{} {
+	values := map[string]interface{}{}
+
+	decls := scriptVarsRegexp.FindStringSubmatch(scriptCommentRegexp.ReplaceAllString(plain, ""))
+	if decls == nil {
+		return values
+	}
+	names := scriptVarDeclRegexp.FindAllStringSubmatch(decls[1], -1)
+
+	n := 0
+	for i, res := range p.Resources {
+		switch res.(type) {
+		case program.Parameter, program.Metadata:
+		default:
+			continue
+		}
+		if n >= len(names) || i >= len(resources) {
+			break
+		}
+		values[names[n][1]] = scriptValue(resources[i])
+		n++
+	}
+
+	return values
+}
+
+func scriptValue(v machine.Value) interface{} {
+	switch v := v.(type) {
+	case machine.Account:
+		return string(v)
+	case machine.Asset:
+		return string(v)
+	case machine.Number:
+		return uint64(v)
+	case machine.Monetary:
+		return map[string]interface{}{
+			"asset":  string(v.Asset),
+			"amount": v.Amount,
+		}
+	default:
+		return fmt.Sprint(v)
+	}
+}
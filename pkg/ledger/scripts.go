@@ -0,0 +1,87 @@
+package ledger
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/numary/ledger/pkg/core"
+	"github.com/numary/ledger/pkg/storage"
+	"github.com/pkg/errors"
+)
+
+var scriptNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_-]{0,62}$`)
+
+// SaveScript stores a new version of the named script, numbered after the last one, and returns it.
+// The script is compiled first, so that a script which can't run is refused.
+func (l *Ledger) SaveScript(ctx context.Context, name, plain string) (core.StoredScript, error) {
+	ctx = storage.WithConsistentRead(ctx)
+	if !scriptNameRegexp.MatchString(name) {
+		return core.StoredScript{}, newValidationError("invalid script name '%s': expected up to 63 letters, digits, '_' or '-', not starting with '_' or '-'", name)
+	}
+	if plain == "" {
+		return core.StoredScript{}, newValidationError("empty script")
+	}
+	if _, err := l.compile(plain); err != nil {
+		return core.StoredScript{}, newValidationError("compile error: %v", err)
+	}
+
+	unlock, err := l.locker.Lock(l.name)
+	if err != nil {
+		return core.StoredScript{}, errors.Wrap(err, "unable to acquire lock")
+	}
+	defer unlock()
+
+	// A version saved concurrently by another process makes the save fail with a conflict
+	last, err := l.store.GetScript(ctx, name, 0)
+	if err != nil {
+		return core.StoredScript{}, err
+	}
+	script := core.StoredScript{
+		Name:      name,
+		Version:   1,
+		Plain:     plain,
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+	}
+	if last != nil {
+		script.Version = last.Version + 1
+	}
+
+	return script, l.store.SaveScript(ctx, script)
+}
+
+// GetScript returns a version of the named script, or its last version if the version is 0
+func (l *Ledger) GetScript(ctx context.Context, name string, version int64) (core.StoredScript, error) {
+	script, err := l.store.GetScript(ctx, name, version)
+	if err != nil {
+		return core.StoredScript{}, err
+	}
+	if script == nil {
+		if version != 0 {
+			return core.StoredScript{}, fmt.Errorf("%w: %s version %d", ErrScriptNotFound, name, version)
+		}
+		return core.StoredScript{}, fmt.Errorf("%w: %s", ErrScriptNotFound, name)
+	}
+	return *script, nil
+}
+
+// Scripts returns the last version of each stored script, sorted by name
+func (l *Ledger) Scripts(ctx context.Context) ([]core.StoredScript, error) {
+	return l.store.FindScripts(ctx)
+}
+
+// ExecuteScript runs a version of the named script, or its last version if the version is 0, like Execute
+func (l *Ledger) ExecuteScript(ctx context.Context, name string, version int64, vars map[string]json.RawMessage) (*ScriptResult, error) {
+	ctx = storage.WithConsistentRead(ctx)
+	script, err := l.GetScript(ctx, name, version)
+	if err != nil {
+		return nil, err
+	}
+
+	return l.Execute(ctx, core.Script{
+		Plain: script.Plain,
+		Vars:  vars,
+	})
+}
@@ -0,0 +1,78 @@
+package ledger
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStoredScripts(t *testing.T) {
+	with(func(l *Ledger) {
+		defer l.Close(context.Background())
+
+		first, err := l.SaveScript(context.Background(), "payout", `vars {
+			account $user
+		}
+		send [COIN 10] (
+			source = @world
+			destination = $user
+		)`)
+		assert.NoError(t, err)
+		assert.EqualValues(t, 1, first.Version)
+		assert.NotEmpty(t, first.Timestamp)
+
+		second, err := l.SaveScript(context.Background(), "payout", `vars {
+			account $user
+		}
+		send [COIN 20] (
+			source = @world
+			destination = $user
+		)`)
+		assert.NoError(t, err)
+		assert.EqualValues(t, 2, second.Version)
+
+		_, err = l.SaveScript(context.Background(), "fee", `send [COIN 1] (
+			source = @world
+			destination = @fees
+		)`)
+		assert.NoError(t, err)
+
+		scripts, err := l.Scripts(context.Background())
+		assert.NoError(t, err)
+		if assert.Len(t, scripts, 2) {
+			assert.Equal(t, "fee", scripts[0].Name)
+			assert.Equal(t, second, scripts[1])
+		}
+
+		// The last version is run unless a version is pinned
+		vars := map[string]json.RawMessage{
+			"user": json.RawMessage(`"users:stored"`),
+		}
+		_, err = l.ExecuteScript(context.Background(), "payout", 0, vars)
+		assert.NoError(t, err)
+		_, err = l.ExecuteScript(context.Background(), "payout", 1, vars)
+		assert.NoError(t, err)
+
+		balance, err := l.GetAccountBalance(context.Background(), "users:stored", "COIN")
+		assert.NoError(t, err)
+		assert.EqualValues(t, 30, balance)
+
+		_, err = l.ExecuteScript(context.Background(), "payout", 3, vars)
+		assert.True(t, errors.Is(err, ErrScriptNotFound), err)
+		_, err = l.ExecuteScript(context.Background(), "unknown", 0, nil)
+		assert.True(t, errors.Is(err, ErrScriptNotFound), err)
+
+		// The invalid scripts and names are refused
+		_, err = l.SaveScript(context.Background(), "invalid", "not a script")
+		assert.True(t, errors.Is(err, ErrValidation), err)
+		_, err = l.SaveScript(context.Background(), "-payout", first.Plain)
+		assert.True(t, errors.Is(err, ErrValidation), err)
+
+		script, err := l.GetScript(context.Background(), "payout", 1)
+		assert.NoError(t, err)
+		assert.Equal(t, first, script)
+	})
+}
@@ -1,12 +1,35 @@
 package ledger
 
-import "context"
+import (
+	"context"
+	"sort"
+
+	"github.com/numary/ledger/pkg/core"
+	"github.com/numary/ledger/pkg/ledger/query"
+)
 
 type Stats struct {
 	Transactions int64 `json:"transactions"`
 	Accounts     int64 `json:"accounts"`
+	// Assets are the assets of the postings, sorted by code
+	Assets []AssetStats `json:"assets"`
+	// FirstTransactionAt and LastTransactionAt are the timestamps of the first and last transactions,
+	// empty if there is no transaction
+	FirstTransactionAt string `json:"first_transaction_at,omitempty"`
+	LastTransactionAt  string `json:"last_transaction_at,omitempty"`
+	// Head is the hash of the last transaction, which the hash of the next one chains to
+	Head string `json:"head,omitempty"`
+	// Scripts are the counters of the script cache of the ledger if any, which may be shared with other ledgers
+	Scripts *ScriptCacheStats `json:"scripts,omitempty"`
+}
+
+type AssetStats struct {
+	Asset string `json:"asset"`
+	// Volume is the sum of the amounts of the postings in the asset
+	Volume int64 `json:"volume"`
 }
 
+// Stats returns an overview of the ledger, computed by aggregations of the store
 func (l *Ledger) Stats(ctx context.Context) (Stats, error) {
 	var stats Stats
 
@@ -22,8 +45,51 @@ func (l *Ledger) Stats(ctx context.Context) (Stats, error) {
 		return stats, err
 	}
 
-	return Stats{
+	volumes, err := l.store.AssetVolumes(ctx)
+	if err != nil {
+		return stats, err
+	}
+
+	stats = Stats{
 		Transactions: tt,
 		Accounts:     ta,
-	}, nil
+		Assets:       make([]AssetStats, 0, len(volumes)),
+	}
+	for asset, volume := range volumes {
+		stats.Assets = append(stats.Assets, AssetStats{
+			Asset:  asset,
+			Volume: volume,
+		})
+	}
+	sort.Slice(stats.Assets, func(i, j int) bool {
+		return stats.Assets[i].Asset < stats.Assets[j].Asset
+	})
+	if l.scriptCache != nil {
+		scripts := l.scriptCache.Stats()
+		stats.Scripts = &scripts
+	}
+
+	last, err := l.store.LastTransaction(ctx)
+	if err != nil {
+		return stats, err
+	}
+	if last == nil {
+		return stats, nil
+	}
+	stats.LastTransactionAt = last.Timestamp
+	stats.Head = last.Hash
+
+	// The ids are sparse unless generated by SequenceGenerator
+	q := query.New()
+	q.Modify(query.SortTransactions(true))
+	q.Limit = 1
+	c, err := l.store.FindTransactions(ctx, q)
+	if err != nil {
+		return stats, err
+	}
+	if first := c.Data.([]core.Transaction); len(first) > 0 {
+		stats.FirstTransactionAt = first[0].Timestamp
+	}
+
+	return stats, nil
 }
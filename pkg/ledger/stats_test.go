@@ -3,6 +3,9 @@ package ledger
 import (
 	"context"
 	"testing"
+
+	"github.com/numary/ledger/pkg/core"
+	"github.com/stretchr/testify/assert"
 )
 
 func TestStats(t *testing.T) {
@@ -13,5 +16,70 @@ func TestStats(t *testing.T) {
 
 			t.Error(err)
 		}
+
+		l = newEmptyLedger(t)
+
+		stats, err := l.Stats(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, Stats{Assets: []AssetStats{}}, stats)
+
+		committed, err := l.Commit(context.Background(), []core.Transaction{
+			{
+				Postings: []core.Posting{
+					{Source: "world", Destination: "users:001", Amount: 100, Asset: "USD"},
+					{Source: "world", Destination: "users:001", Amount: 5, Asset: "EUR"},
+				},
+			},
+			{
+				Postings: []core.Posting{
+					{Source: "users:001", Destination: "users:002", Amount: 30, Asset: "USD"},
+				},
+			},
+		})
+		assert.NoError(t, err)
+
+		stats, err = l.Stats(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, Stats{
+			Transactions: 2,
+			Accounts:     3,
+			Assets: []AssetStats{
+				{Asset: "EUR", Volume: 5},
+				{Asset: "USD", Volume: 130},
+			},
+			FirstTransactionAt: committed[0].Timestamp,
+			LastTransactionAt:  committed[1].Timestamp,
+			Head:               committed[1].Hash,
+		}, stats)
 	})
 }
+
+func TestHead(t *testing.T) {
+	l := newEmptyLedger(t)
+
+	txid, hash, count, err := l.Head(context.Background())
+	assert.NoError(t, err)
+	assert.EqualValues(t, -1, txid)
+	assert.Empty(t, hash)
+	assert.EqualValues(t, 0, count)
+
+	committed, err := l.Commit(context.Background(), []core.Transaction{
+		{
+			Postings: []core.Posting{
+				{Source: "world", Destination: "users:001", Amount: 100, Asset: "USD"},
+			},
+		},
+		{
+			Postings: []core.Posting{
+				{Source: "users:001", Destination: "users:002", Amount: 30, Asset: "USD"},
+			},
+		},
+	})
+	assert.NoError(t, err)
+
+	txid, hash, count, err = l.Head(context.Background())
+	assert.NoError(t, err)
+	assert.EqualValues(t, 1, txid)
+	assert.Equal(t, committed[1].Hash, hash)
+	assert.EqualValues(t, 2, count)
+}
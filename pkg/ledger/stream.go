@@ -0,0 +1,178 @@
+package ledger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/numary/ledger/pkg/core"
+	"github.com/numary/ledger/pkg/ledger/query"
+	"github.com/numary/ledger/pkg/storage"
+)
+
+const streamPageSize = 100
+
+// DefaultFollowBuffer is the default of FollowOptions.Buffer
+const DefaultFollowBuffer = 1000
+
+// ErrFollowerTooSlow ends the streams of FollowTransactions when the transactions are committed faster than received
+var ErrFollowerTooSlow = errors.New("transactions committed faster than received")
+
+// StreamTransactions returns the transactions matching the query, most recent first.
+// Pages are fetched lazily from the store using the txid as key, so memory stays bounded
+// whatever the size of the ledger. Both channels are closed once the stream ends, either
+// because all transactions were sent, an error occurred or the context was cancelled.
+func (l *Ledger) StreamTransactions(ctx context.Context, q query.Query) (<-chan core.Transaction, <-chan error) {
+	txs := make(chan core.Transaction)
+	errs := make(chan error, 1)
+
+	go func() {
+		defer close(txs)
+		defer close(errs)
+
+		q.Limit = streamPageSize
+
+		for {
+			c, err := l.store.FindTransactions(ctx, q)
+			if err != nil {
+				errs <- err
+				return
+			}
+
+			page := (c.Data).([]core.Transaction)
+			for _, tx := range page {
+				select {
+				case txs <- tx:
+				case <-ctx.Done():
+					errs <- ctx.Err()
+					return
+				}
+			}
+
+			if !c.HasMore || len(page) == 0 {
+				return
+			}
+			q.After = fmt.Sprint(page[len(page)-1].ID)
+		}
+	}()
+
+	return txs, errs
+}
+
+// FollowOptions are the options of FollowTransactions
+type FollowOptions struct {
+	// After resumes a stream after the transaction with the given id, the transactions committed since being sent first.
+	// The stream starts with the transactions committed from now on if nil.
+	After *int64
+	// Buffer is the number of transactions queued while they are being received, DefaultFollowBuffer if zero.
+	// The stream is ended with ErrFollowerTooSlow once it is full, rather than queuing the transactions without bound.
+	Buffer int
+}
+
+// FollowTransactions returns the transactions matching the filters of the query as they are committed,
+// in ascending order of id. Only the transactions committed by this process are followed, along with
+// the transactions of the store following FollowOptions.After. The error channel receives the error
+// ending the stream: ErrFollowerTooSlow, an error of the store or the error of the context.
+// Both channels are closed once the stream ends.
+func (l *Ledger) FollowTransactions(ctx context.Context, opts FollowOptions, m ...query.QueryModifier) (<-chan core.Transaction, <-chan error) {
+	q := query.New(m)
+	buffer := opts.Buffer
+	if buffer <= 0 {
+		buffer = DefaultFollowBuffer
+	}
+
+	// The transactions are followed before the ones committed since FollowOptions.After are read,
+	// so that none is missed in between, the transactions read from both being sent once
+	committed := make(chan core.Transaction, buffer)
+	overflow := make(chan struct{})
+	var once sync.Once
+	unsubscribe := l.bus.Subscribe(func(e core.CommittedTransactions) {
+		if e.Ledger != l.name {
+			return
+		}
+		for _, tx := range e.Transactions {
+			if !storage.MatchTransaction(q, tx) {
+				continue
+			}
+			select {
+			case committed <- tx:
+			default:
+				once.Do(func() {
+					close(overflow)
+				})
+				return
+			}
+		}
+	})
+
+	txs := make(chan core.Transaction)
+	errs := make(chan error, 1)
+
+	go func() {
+		defer close(txs)
+		defer close(errs)
+		defer unsubscribe()
+
+		send := func(tx core.Transaction) bool {
+			select {
+			case txs <- tx:
+				return true
+			case <-overflow:
+				errs <- ErrFollowerTooSlow
+			case <-ctx.Done():
+				errs <- ctx.Err()
+			}
+			return false
+		}
+
+		last := int64(-1)
+		if opts.After != nil {
+			last = *opts.After
+			// The query matching the committed transactions is read concurrently, the store is queried with another
+			replay := query.New(m)
+			replay.Modify(query.SortTransactions(true))
+			replay.Limit = streamPageSize
+			for {
+				replay.After = fmt.Sprint(last)
+				c, err := l.store.FindTransactions(ctx, replay)
+				if err != nil {
+					errs <- err
+					return
+				}
+
+				page := c.Data.([]core.Transaction)
+				for _, tx := range page {
+					if !send(tx) {
+						return
+					}
+					last = tx.ID
+				}
+				if !c.HasMore || len(page) == 0 {
+					break
+				}
+			}
+		}
+
+		for {
+			select {
+			case tx := <-committed:
+				if tx.ID <= last {
+					continue
+				}
+				if !send(tx) {
+					return
+				}
+				last = tx.ID
+			case <-overflow:
+				errs <- ErrFollowerTooSlow
+				return
+			case <-ctx.Done():
+				errs <- ctx.Err()
+				return
+			}
+		}
+	}()
+
+	return txs, errs
+}
@@ -0,0 +1,151 @@
+package ledger
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/numary/ledger/pkg/core"
+	"github.com/numary/ledger/pkg/ledger/query"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStreamTransactions(t *testing.T) {
+	with(func(l *Ledger) {
+		batch := []core.Transaction{}
+		for i := 0; i < 250; i++ {
+			batch = append(batch, core.Transaction{
+				Postings: []core.Posting{
+					{
+						Source:      "world",
+						Destination: "users:stream",
+						Amount:      1,
+						Asset:       "COIN",
+					},
+				},
+			})
+		}
+		_, err := l.Commit(context.Background(), batch)
+		assert.NoError(t, err)
+
+		count, err := l.store.CountTransactions(context.Background())
+		assert.NoError(t, err)
+
+		txs, errs := l.StreamTransactions(context.Background(), query.New())
+
+		received := int64(0)
+		previous := int64(-1)
+		for tx := range txs {
+			if previous != -1 && tx.ID >= previous {
+				t.Fatalf("transactions not ordered: %d after %d", tx.ID, previous)
+			}
+			previous = tx.ID
+			received++
+		}
+		assert.NoError(t, <-errs)
+		assert.Equal(t, count, received)
+	})
+}
+
+func TestStreamTransactionsCancel(t *testing.T) {
+	with(func(l *Ledger) {
+		_, err := l.Commit(context.Background(), []core.Transaction{
+			{
+				Postings: []core.Posting{
+					{
+						Source:      "world",
+						Destination: "users:stream",
+						Amount:      1,
+						Asset:       "COIN",
+					},
+				},
+			},
+			{
+				Postings: []core.Posting{
+					{
+						Source:      "world",
+						Destination: "users:stream",
+						Amount:      1,
+						Asset:       "COIN",
+					},
+				},
+			},
+		})
+		assert.NoError(t, err)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		txs, errs := l.StreamTransactions(ctx, query.New())
+
+		<-txs
+		cancel()
+
+		assert.Equal(t, context.Canceled, <-errs)
+	})
+}
+
+func TestFollowTransactions(t *testing.T) {
+	l := newEmptyLedger(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	send := func(destination string) {
+		_, err := l.Commit(context.Background(), []core.Transaction{{
+			Postings: []core.Posting{
+				{Source: "world", Destination: destination, Amount: 1, Asset: "COIN"},
+			},
+		}})
+		assert.NoError(t, err)
+	}
+	next := func(txs <-chan core.Transaction) core.Transaction {
+		select {
+		case tx, ok := <-txs:
+			assert.True(t, ok, "stream ended")
+			return tx
+		case <-time.After(5 * time.Second):
+			t.Fatal("no transaction received")
+			return core.Transaction{}
+		}
+	}
+
+	send("users:001")
+	send("users:002")
+	send("users:001")
+
+	// The stream starts from now by default
+	live, liveErrs := l.FollowTransactions(ctx, FollowOptions{})
+	// The transactions committed since the given one are sent first
+	after := int64(0)
+	resumed, _ := l.FollowTransactions(ctx, FollowOptions{
+		After: &after,
+	}, query.Account("users:001"))
+
+	send("users:002")
+	send("users:001")
+
+	assert.EqualValues(t, 3, next(live).ID)
+	assert.EqualValues(t, 4, next(live).ID)
+	assert.EqualValues(t, 2, next(resumed).ID)
+	assert.EqualValues(t, 4, next(resumed).ID)
+
+	// The slow followers are dropped, rather than the transactions being queued without bound
+	slow, slowErrs := l.FollowTransactions(ctx, FollowOptions{
+		Buffer: 1,
+	})
+	send("users:001")
+	send("users:001")
+	send("users:001")
+	select {
+	case err := <-slowErrs:
+		assert.True(t, errors.Is(err, ErrFollowerTooSlow), err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("slow follower not dropped")
+	}
+	for range slow {
+	}
+
+	cancel()
+	for range live {
+	}
+	assert.True(t, errors.Is(<-liveErrs, context.Canceled))
+}
@@ -1,6 +1,228 @@
 package ledger
 
-func (l *Ledger) Verify() error {
-	// TODO: iterate over all transactions
+import (
+	"context"
+	"fmt"
+
+	"github.com/numary/ledger/pkg/core"
+	"github.com/numary/ledger/pkg/ledger/query"
+)
+
+// Verify returns an error if the hash chain of the ledger is broken, see VerifyHashChain
+func (l *Ledger) Verify(ctx context.Context) error {
+	ok, tx, err := l.VerifyHashChain(ctx)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("hash chain broken at transaction %d", tx.ID)
+	}
 	return nil
 }
+
+// VerifyHashChain walks all the transactions in order and recomputes their hashes,
+// each one with the algorithm recorded in its stored hash. The metadata of the transactions are verified
+// unless they were updated since their commit, see GetMetaVersion.
+// It returns false along with the first transaction whose stored hash diverges,
+// and an error if a transaction was hashed with an algorithm unknown to the ledger.
+func (l *Ledger) VerifyHashChain(ctx context.Context) (bool, *core.Transaction, error) {
+	return l.VerifyHashChainFrom(ctx, 0)
+}
+
+// VerifyHashChainFrom is like VerifyHashChain but starts at the given txid,
+// trusting the stored hash of the preceding transaction.
+func (l *Ledger) VerifyHashChainFrom(ctx context.Context, txid int64) (bool, *core.Transaction, error) {
+	previous, err := l.previousTransaction(ctx, txid)
+	if err != nil {
+		return false, nil, err
+	}
+
+	var broken *core.Transaction
+	err = l.walkTransactions(ctx, txid-1, func(tx core.Transaction) (bool, error) {
+		hasher, err := l.hasherFor(core.HashAlgorithm(tx.Hash))
+		if err != nil {
+			return false, fmt.Errorf("transaction %d: %w", tx.ID, err)
+		}
+
+		ok, err := l.verifyHash(ctx, hasher, previous, &tx)
+		if err != nil {
+			return false, err
+		}
+		if !ok {
+			broken = &tx
+			return false, nil
+		}
+		previous = &tx
+		return true, nil
+	})
+	if err != nil {
+		return false, nil, err
+	}
+
+	return broken == nil, broken, nil
+}
+
+// verifyHash tells if the hash of tx is chained to previous, and if its metadata are the ones it was committed with
+// unless they were updated since, as tracked by their version
+func (l *Ledger) verifyHash(ctx context.Context, hasher core.Hasher, previous *core.Transaction, tx *core.Transaction) (bool, error) {
+	if !core.VerifyChainedHash(hasher, previous, tx) {
+		return false, nil
+	}
+	if core.VerifyMetadata(hasher, tx) {
+		return true, nil
+	}
+	version, err := l.store.GetMetaVersion(ctx, targetTypeTransaction, fmt.Sprint(tx.ID))
+	if err != nil {
+		return false, err
+	}
+	return version > 0, nil
+}
+
+// previousTransaction returns the transaction preceding txid in the chain, nil if there is none
+func (l *Ledger) previousTransaction(ctx context.Context, txid int64) (*core.Transaction, error) {
+	if txid <= 0 {
+		return nil, nil
+	}
+
+	q := query.New()
+	q.Limit = 1
+	q.After = fmt.Sprint(txid)
+	c, err := l.store.FindTransactions(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	page := c.Data.([]core.Transaction)
+	if len(page) == 0 {
+		return nil, nil
+	}
+	return &page[0], nil
+}
+
+// walkTransactions calls fn with the transactions following the transaction after, -1 to start from the first one,
+// in the order of the chain, which is the order of their ids, until fn returns false. The transactions are read
+// by pages, using the txid as key, so that the whole chain can be walked with a bounded memory.
+func (l *Ledger) walkTransactions(ctx context.Context, after int64, fn func(core.Transaction) (bool, error)) error {
+	q := query.New()
+	q.Modify(query.SortTransactions(true))
+	q.Limit = streamPageSize
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if after >= 0 {
+			q.After = fmt.Sprint(after)
+		}
+		c, err := l.store.FindTransactions(ctx, q)
+		if err != nil {
+			return err
+		}
+
+		page := c.Data.([]core.Transaction)
+		for _, tx := range page {
+			ok, err := fn(tx)
+			if err != nil || !ok {
+				return err
+			}
+			after = tx.ID
+		}
+		if !c.HasMore || len(page) == 0 {
+			return nil
+		}
+	}
+}
+
+// MerkleRoot returns the root of the Merkle tree of the transactions of the ledger, see core.MerkleRoot,
+// along with the number of transactions it covers. The root can be published, for the inclusion
+// of the transactions to be proved against it, see InclusionProofAt.
+func (l *Ledger) MerkleRoot(ctx context.Context) (string, int64, error) {
+	count, err := l.store.CountTransactions(ctx)
+	if err != nil {
+		return "", 0, err
+	}
+
+	hashes, err := l.merkleHashes(ctx, count)
+	if err != nil {
+		return "", 0, err
+	}
+
+	return core.MerkleRoot(hashes), count, nil
+}
+
+// InclusionProof returns the proof that the transaction is included in the Merkle tree of all the transactions
+// of the ledger. It returns ErrTransactionNotFound if the transaction doesn't exist.
+func (l *Ledger) InclusionProof(ctx context.Context, txid int64) (core.MerkleProof, error) {
+	count, err := l.store.CountTransactions(ctx)
+	if err != nil {
+		return core.MerkleProof{}, err
+	}
+
+	return l.InclusionProofAt(ctx, txid, count)
+}
+
+// InclusionProofAt is like InclusionProof but proves the inclusion in the tree of the first size transactions,
+// to be checked against the root published when the ledger had size transactions.
+func (l *Ledger) InclusionProofAt(ctx context.Context, txid int64, size int64) (core.MerkleProof, error) {
+	count, err := l.store.CountTransactions(ctx)
+	if err != nil {
+		return core.MerkleProof{}, err
+	}
+	if txid < 0 {
+		return core.MerkleProof{}, fmt.Errorf("%w: %d", ErrTransactionNotFound, txid)
+	}
+
+	// The leaves are the transactions in the order of the chain, the ids being sparse unless
+	// generated by SequenceGenerator
+	index := int64(-1)
+	hashes := make([]string, 0)
+	err = l.walkTransactions(ctx, -1, func(tx core.Transaction) (bool, error) {
+		if tx.ID == txid {
+			index = int64(len(hashes))
+		}
+		if int64(len(hashes)) < size {
+			hashes = append(hashes, tx.Hash)
+		}
+		return index < 0 || int64(len(hashes)) < size, nil
+	})
+	if err != nil {
+		return core.MerkleProof{}, err
+	}
+	if index < 0 {
+		return core.MerkleProof{}, fmt.Errorf("%w: %d", ErrTransactionNotFound, txid)
+	}
+	if size <= index || size > count {
+		return core.MerkleProof{}, newValidationError("invalid size %d: expected between %d and %d", size, index+1, count)
+	}
+
+	proof := core.NewMerkleProof(hashes, index)
+	proof.TxID = txid
+	return proof, nil
+}
+
+// merkleHashes returns the hashes of the first size transactions, which are the leaves of the Merkle tree.
+// The transactions are read by pages, only their hashes are kept.
+func (l *Ledger) merkleHashes(ctx context.Context, size int64) ([]string, error) {
+	hashes := make([]string, 0, size)
+	err := l.walkTransactions(ctx, -1, func(tx core.Transaction) (bool, error) {
+		if int64(len(hashes)) >= size {
+			return false, nil
+		}
+		hashes = append(hashes, tx.Hash)
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return hashes, nil
+}
+
+// hasherFor returns the hasher of an algorithm, keyed algorithms are only known if the ledger uses them
+func (l *Ledger) hasherFor(algorithm string) (core.Hasher, error) {
+	for _, h := range []core.Hasher{l.hasher, core.SHA256Hasher, core.SHA512Hasher} {
+		if h.Algorithm() == algorithm {
+			return h, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown hash algorithm '%s'", algorithm)
+}
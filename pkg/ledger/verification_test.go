@@ -1,15 +1,374 @@
 package ledger
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"testing"
+
+	"github.com/numary/ledger/pkg/core"
+	"github.com/numary/ledger/pkg/ledger/query"
+	"github.com/numary/ledger/pkg/storage"
+	"github.com/stretchr/testify/assert"
 )
 
+type tamperedStore struct {
+	storage.Store
+	txid string
+	// metadata replaces the metadata of the transaction if set, its amount is changed otherwise
+	metadata core.Metadata
+}
+
+func (s tamperedStore) GetTransaction(ctx context.Context, id string) (core.Transaction, error) {
+	tx, err := s.Store.GetTransaction(ctx, id)
+	if err != nil {
+		return tx, err
+	}
+	if id == s.txid {
+		tx = s.tamper(tx)
+	}
+	return tx, nil
+}
+
+func (s tamperedStore) FindTransactions(ctx context.Context, q query.Query) (query.Cursor, error) {
+	c, err := s.Store.FindTransactions(ctx, q)
+	if err != nil {
+		return c, err
+	}
+	txs := append([]core.Transaction{}, c.Data.([]core.Transaction)...)
+	for i := range txs {
+		if fmt.Sprint(txs[i].ID) == s.txid {
+			txs[i] = s.tamper(txs[i])
+		}
+	}
+	c.Data = txs
+	return c, nil
+}
+
+// tamper changes the amount of the first posting or the metadata, without changing the transaction of the store
+func (s tamperedStore) tamper(tx core.Transaction) core.Transaction {
+	if s.metadata != nil {
+		tx.Metadata = s.metadata
+		return tx
+	}
+	tx.Postings = append([]core.Posting{}, tx.Postings...)
+	tx.Postings[0].Amount++
+	return tx
+}
+
 func TestVerify(t *testing.T) {
 	with(func(l *Ledger) {
-		err := l.Verify()
+		err := l.Verify(context.Background())
 
 		if err != nil {
 			t.Error(err)
 		}
 	})
 }
+
+func TestVerifyHashChain(t *testing.T) {
+	with(func(l *Ledger) {
+		for i := 0; i < 3; i++ {
+			_, err := l.Commit(context.Background(), []core.Transaction{
+				{
+					Postings: []core.Posting{
+						{
+							Source:      "world",
+							Destination: "users:chain",
+							Amount:      100,
+							Asset:       "COIN",
+						},
+					},
+					Metadata: core.Metadata{
+						"foo": json.RawMessage(`"bar"`),
+					},
+				},
+				{
+					Postings: []core.Posting{
+						{
+							Source:      "users:chain",
+							Destination: "world",
+							Amount:      10,
+							Asset:       "COIN",
+						},
+					},
+				},
+			})
+			assert.NoError(t, err)
+		}
+
+		ok, tx, err := l.VerifyHashChain(context.Background())
+		assert.NoError(t, err)
+		assert.True(t, ok)
+		assert.Nil(t, tx)
+
+		last, err := l.GetLastTransaction(context.Background())
+		assert.NoError(t, err)
+
+		tampered, err := NewLedger("test", tamperedStore{
+			Store: l.store,
+			txid:  "1",
+		}, NewInMemoryLocker())
+		assert.NoError(t, err)
+
+		ok, tx, err = tampered.VerifyHashChain(context.Background())
+		assert.NoError(t, err)
+		assert.False(t, ok)
+		assert.EqualValues(t, 1, tx.ID)
+
+		ok, _, err = tampered.VerifyHashChainFrom(context.Background(), 3)
+		assert.NoError(t, err)
+		assert.True(t, ok)
+
+		ok, _, err = tampered.VerifyHashChainFrom(context.Background(), last.ID+1)
+		assert.NoError(t, err)
+		assert.True(t, ok)
+	})
+}
+
+// legacyHash is the hash function of the first versions, which hashed the json encoding of the transactions
+func legacyHash(t1 *core.Transaction, t2 *core.Transaction) string {
+	b1, _ := json.Marshal(t1)
+	b2, _ := json.Marshal(t2)
+
+	h := sha256.New()
+	h.Write(b1)
+	h.Write(b2)
+
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+func TestVerifyHashChainMetadata(t *testing.T) {
+	l := newEmptyLedger(t)
+
+	committed, err := l.Commit(context.Background(), []core.Transaction{
+		{
+			Postings: []core.Posting{
+				{Source: "world", Destination: "users:meta", Amount: 100, Asset: "COIN"},
+			},
+			Metadata: core.Metadata{
+				"foo": json.RawMessage(`{"bar": "baz", "qux": 1}`),
+			},
+		},
+		{
+			Postings: []core.Posting{
+				{Source: "users:meta", Destination: "world", Amount: 10, Asset: "COIN"},
+			},
+		},
+	})
+	assert.NoError(t, err)
+	ok, tx, err := l.VerifyHashChain(context.Background())
+	assert.NoError(t, err)
+	assert.True(t, ok, tx)
+
+	// The metadata are covered by the chain
+	tampered, err := NewLedger("test", tamperedStore{
+		Store:    l.store,
+		txid:     fmt.Sprint(committed[0].ID),
+		metadata: core.Metadata{"foo": json.RawMessage(`"tampered"`)},
+	}, NewInMemoryLocker())
+	assert.NoError(t, err)
+	ok, tx, err = tampered.VerifyHashChain(context.Background())
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	assert.Equal(t, committed[0].ID, tx.ID)
+
+	// unless they were updated since the commit, as by the reverts
+	assert.NoError(t, l.SaveMeta(context.Background(), targetTypeTransaction, fmt.Sprint(committed[0].ID), core.Metadata{
+		"foo": json.RawMessage(`"updated"`),
+	}))
+	assert.NoError(t, l.RevertTransaction(context.Background(), fmt.Sprint(committed[1].ID)))
+	ok, tx, err = l.VerifyHashChain(context.Background())
+	assert.NoError(t, err)
+	assert.True(t, ok, tx)
+
+}
+
+func TestVerifyLegacyHashChain(t *testing.T) {
+	l := newEmptyLedger(t)
+
+	// A chain committed by the first versions, which hashed each transaction along with the metadata
+	// it was committed with, chained to its predecessor as read back from the store
+	txs := []core.Transaction{
+		{
+			ID: 0,
+			Postings: []core.Posting{
+				{Source: "world", Destination: "users:legacy", Amount: 100, Asset: "COIN"},
+			},
+			Timestamp: "2021-01-01T00:00:00Z",
+			Metadata: core.Metadata{
+				"foo": json.RawMessage(`{"bar": "baz"}`),
+			},
+		},
+		{
+			ID: 1,
+			Postings: []core.Posting{
+				{Source: "users:legacy", Destination: "world", Amount: 10, Asset: "COIN"},
+			},
+			Reference: "legacy",
+			Timestamp: "2021-01-01T00:00:01Z",
+		},
+		{
+			ID: 2,
+			Postings: []core.Posting{
+				{Source: "users:legacy", Destination: "world", Amount: 10, Asset: "COIN"},
+			},
+			Timestamp: "2021-01-01T00:00:02Z",
+		},
+		{
+			// The timestamps were hashed as they were committed, with their offsets and fractions of seconds
+			ID: 3,
+			Postings: []core.Posting{
+				{Source: "users:legacy", Destination: "world", Amount: 10, Asset: "COIN"},
+			},
+			Timestamp: "2021-01-01T02:00:03.5+02:00",
+		},
+	}
+	for i := range txs {
+		var previous *core.Transaction
+		if i > 0 {
+			stored, err := l.GetTransaction(context.Background(), fmt.Sprint(i-1))
+			assert.NoError(t, err)
+			previous = &stored
+		}
+		txs[i].Hash = legacyHash(previous, &txs[i])
+		assert.NoError(t, l.store.SaveTransactions(context.Background(), txs[i:i+1]))
+	}
+
+	ok, tx, err := l.VerifyHashChain(context.Background())
+	assert.NoError(t, err)
+	assert.True(t, ok, tx)
+
+	// The transactions committed since are chained to the legacy ones with the current format
+	_, err = l.Commit(context.Background(), []core.Transaction{{
+		Postings: []core.Posting{
+			{Source: "world", Destination: "users:legacy", Amount: 100, Asset: "COIN"},
+		},
+	}})
+	assert.NoError(t, err)
+	ok, tx, err = l.VerifyHashChain(context.Background())
+	assert.NoError(t, err)
+	assert.True(t, ok, tx)
+
+	tampered, err := NewLedger("test", tamperedStore{
+		Store: l.store,
+		txid:  "1",
+	}, NewInMemoryLocker())
+	assert.NoError(t, err)
+	ok, tx, err = tampered.VerifyHashChain(context.Background())
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	assert.EqualValues(t, 1, tx.ID)
+}
+
+func TestVerifyHashChainMixedAlgorithms(t *testing.T) {
+	with(func(l *Ledger) {
+		tx := core.Transaction{
+			Postings: []core.Posting{
+				{
+					Source:      "world",
+					Destination: "users:hasher",
+					Amount:      100,
+					Asset:       "COIN",
+				},
+			},
+		}
+
+		sha512, err := NewLedger("test", l.store, NewInMemoryLocker(), WithHasher(core.SHA512Hasher))
+		assert.NoError(t, err)
+
+		committed, err := sha512.Commit(context.Background(), []core.Transaction{tx})
+		assert.NoError(t, err)
+		assert.Equal(t, "sha512", core.HashAlgorithm(committed[0].Hash))
+
+		committed, err = l.Commit(context.Background(), []core.Transaction{tx})
+		assert.NoError(t, err)
+		assert.Equal(t, "sha256", core.HashAlgorithm(committed[0].Hash))
+
+		ok, _, err := l.VerifyHashChain(context.Background())
+		assert.NoError(t, err)
+		assert.True(t, ok)
+
+		_, err = l.hasherFor("hmac-sha256")
+		assert.Error(t, err)
+
+		hmac, err := NewLedger("test", l.store, NewInMemoryLocker(), WithHasher(core.NewHMACHasher([]byte("secret"))))
+		assert.NoError(t, err)
+
+		_, err = hmac.hasherFor("hmac-sha256")
+		assert.NoError(t, err)
+	})
+}
+
+func BenchmarkVerifyHashChain(b *testing.B) {
+	with(func(l *Ledger) {
+		txs := []core.Transaction{}
+		for i := 0; i < 1e3; i++ {
+			txs = append(txs, core.Transaction{
+				Postings: []core.Posting{
+					{
+						Source:      "world",
+						Destination: "benchmark",
+						Asset:       "COIN",
+						Amount:      10,
+					},
+				},
+			})
+		}
+		l.Commit(context.Background(), txs)
+
+		b.ResetTimer()
+		for n := 0; n < b.N; n++ {
+			l.VerifyHashChain(context.Background())
+		}
+	})
+}
+
+func TestInclusionProof(t *testing.T) {
+	l := newEmptyLedger(t)
+
+	for i := 0; i < 5; i++ {
+		_, err := l.Commit(context.Background(), []core.Transaction{{
+			Postings: []core.Posting{
+				{Source: "world", Destination: "users:001", Amount: 100, Asset: "COIN"},
+			},
+		}})
+		assert.NoError(t, err)
+	}
+
+	root, size, err := l.MerkleRoot(context.Background())
+	assert.NoError(t, err)
+	assert.EqualValues(t, 5, size)
+
+	tx, err := l.GetTransaction(context.Background(), "3")
+	assert.NoError(t, err)
+	proof, err := l.InclusionProof(context.Background(), 3)
+	assert.NoError(t, err)
+	assert.Equal(t, tx.Hash, proof.Hash)
+	assert.True(t, proof.Verify(root))
+
+	tampered := proof
+	tampered.Hash = core.Hash(nil, &tx)
+	assert.False(t, tampered.Verify(root))
+
+	// The proofs can still be checked against the roots published before the next commits
+	_, err = l.Commit(context.Background(), []core.Transaction{{
+		Postings: []core.Posting{
+			{Source: "world", Destination: "users:001", Amount: 100, Asset: "COIN"},
+		},
+	}})
+	assert.NoError(t, err)
+	proof, err = l.InclusionProofAt(context.Background(), 3, 5)
+	assert.NoError(t, err)
+	assert.True(t, proof.Verify(root))
+	proof, err = l.InclusionProof(context.Background(), 3)
+	assert.NoError(t, err)
+	assert.False(t, proof.Verify(root))
+
+	_, err = l.InclusionProof(context.Background(), 6)
+	assert.True(t, errors.Is(err, ErrTransactionNotFound), err)
+	_, err = l.InclusionProofAt(context.Background(), 3, 3)
+	assert.True(t, errors.Is(err, ErrValidation), err)
+}
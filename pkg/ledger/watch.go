@@ -0,0 +1,96 @@
+package ledger
+
+import (
+	"context"
+	"time"
+
+	"github.com/numary/ledger/pkg/core"
+	"github.com/numary/ledger/pkg/storage"
+	"github.com/sirupsen/logrus"
+)
+
+// WatchOptions are the options of WatchAccountWithOptions
+type WatchOptions struct {
+	// Debounce waits for the changes following a change during the given duration, so that a single state is sent
+	// for all of them. The changes are sent as soon as they are committed if zero.
+	Debounce time.Duration
+}
+
+// WatchAccount returns a channel receiving the state of an account after each commit changing its balances.
+// The changes committed while the previous state hasn't been received yet are coalesced, the channel always
+// receiving the latest state of the account. The channel is closed once the context is done,
+// the subscription being cleaned up, or if the account can't be read.
+func (l *Ledger) WatchAccount(ctx context.Context, address string) (<-chan core.Account, error) {
+	return l.WatchAccountWithOptions(ctx, address, WatchOptions{})
+}
+
+// WatchAccountWithOptions watches an account like WatchAccount, with options
+func (l *Ledger) WatchAccountWithOptions(ctx context.Context, address string, opts WatchOptions) (<-chan core.Account, error) {
+	if address == "" {
+		return nil, newValidationError("missing account address")
+	}
+	if opts.Debounce < 0 {
+		return nil, newValidationError("negative debounce")
+	}
+
+	// changed is signaled by the commits changing the account, the signals pending being merged
+	changed := make(chan struct{}, 1)
+	// The bus may be shared by the ledgers of a resolver
+	unsubscribe := l.bus.Subscribe(func(e core.CommittedTransactions) {
+		if e.Ledger != l.name {
+			return
+		}
+		if _, ok := e.Balances[address]; !ok {
+			return
+		}
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	})
+
+	accounts := make(chan core.Account)
+	go func() {
+		defer close(accounts)
+		defer unsubscribe()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-changed:
+			}
+
+			if opts.Debounce > 0 {
+				timer := time.NewTimer(opts.Debounce)
+				select {
+				case <-ctx.Done():
+					timer.Stop()
+					return
+				case <-timer.C:
+				}
+				// The state read below includes the changes signaled in the meantime
+				select {
+				case <-changed:
+				default:
+				}
+			}
+
+			account, err := l.GetAccount(storage.WithConsistentRead(ctx), address)
+			if err != nil {
+				if ctx.Err() == nil {
+					logrus.Errorf("watching account %s of ledger %s: %s", address, l.name, err)
+				}
+				return
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case accounts <- account:
+			}
+		}
+	}()
+
+	return accounts, nil
+}
@@ -0,0 +1,82 @@
+package ledger
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/numary/ledger/pkg/core"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWatchAccount(t *testing.T) {
+	l := newEmptyLedger(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	send := func(destination string, amount int64) {
+		_, err := l.Commit(context.Background(), []core.Transaction{{
+			Postings: []core.Posting{
+				{Source: "world", Destination: destination, Amount: amount, Asset: "COIN"},
+			},
+		}})
+		assert.NoError(t, err)
+	}
+	next := func(accounts <-chan core.Account) core.Account {
+		select {
+		case account, ok := <-accounts:
+			assert.True(t, ok, "watch stopped")
+			return account
+		case <-time.After(5 * time.Second):
+			t.Fatal("no change received")
+			return core.Account{}
+		}
+	}
+
+	_, err := l.WatchAccount(ctx, "")
+	assert.True(t, errors.Is(err, ErrValidation), err)
+
+	accounts, err := l.WatchAccount(ctx, "users:001")
+	assert.NoError(t, err)
+
+	// The commits not changing the account are not sent
+	send("users:002", 10)
+	send("users:001", 100)
+	account := next(accounts)
+	assert.Equal(t, "users:001", account.Address)
+	assert.EqualValues(t, 100, account.Balances["COIN"])
+
+	send("users:001", 50)
+	assert.EqualValues(t, 150, next(accounts).Balances["COIN"])
+
+	// The changes made during the debounce duration are sent at once
+	debounced, err := l.WatchAccountWithOptions(ctx, "users:001", WatchOptions{
+		Debounce: 200 * time.Millisecond,
+	})
+	assert.NoError(t, err)
+	send("users:001", 1)
+	send("users:001", 2)
+	send("users:001", 3)
+	assert.EqualValues(t, 156, next(debounced).Balances["COIN"])
+	for i := 0; i < 3; i++ {
+		// The undebounced watch is sent the latest state, if it hasn't received the previous ones
+		if next(accounts).Balances["COIN"] == 156 {
+			break
+		}
+	}
+
+	// A state being sent may still be received once the context is done
+	cancel()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case _, ok := <-accounts:
+			if !ok {
+				return
+			}
+		case <-timeout:
+			t.Fatal("watch not stopped")
+		}
+	}
+}
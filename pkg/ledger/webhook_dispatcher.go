@@ -0,0 +1,355 @@
+package ledger
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/numary/ledger/pkg/core"
+	"github.com/numary/ledger/pkg/storage"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// WebhookSignatureHeader carries the HMAC-SHA256 of the body of a delivery, keyed by the secret of the webhook,
+	// as sha256=<hex>
+	WebhookSignatureHeader = "X-Webhook-Signature"
+	// WebhookDeliveryHeader carries the id of a delivery, the same for all its attempts, so that the endpoints
+	// can ignore the transactions delivered twice
+	WebhookDeliveryHeader = "X-Webhook-Delivery"
+)
+
+// WebhookEventCommittedTransaction is the type of the events delivered for the committed transactions
+const WebhookEventCommittedTransaction = "committed_transaction"
+
+// WebhookEvent is the body of a delivery
+type WebhookEvent struct {
+	// ID is the id of the delivery, see WebhookDeliveryHeader
+	ID          string           `json:"id"`
+	Type        string           `json:"type"`
+	Ledger      string           `json:"ledger"`
+	Transaction core.Transaction `json:"transaction"`
+}
+
+// WebhookConfig sets the retries and the timeouts of the deliveries
+type WebhookConfig struct {
+	// MaxAttempts is the number of attempts of a delivery, after which it is recorded as dead
+	MaxAttempts int
+	// Backoff is the delay before the second attempt of a delivery, doubled before each of the next ones up to MaxBackoff
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// Timeout bounds each attempt
+	Timeout time.Duration
+	// PollInterval is the interval at which all the ledgers are checked for transactions to deliver,
+	// like the ones committed by other processes or left over by a crash
+	PollInterval time.Duration
+}
+
+var DefaultWebhookConfig = WebhookConfig{
+	MaxAttempts:  5,
+	Backoff:      time.Second,
+	MaxBackoff:   time.Minute,
+	Timeout:      10 * time.Second,
+	PollInterval: 30 * time.Second,
+}
+
+// WebhookDispatcher delivers the transactions committed to the ledgers to their webhooks.
+// The transactions are the outbox of the deliveries: each webhook has a cursor on the transactions,
+// stored along with the outcome of each delivery, so that the dispatcher resumes after the last transaction
+// handled when restarted and nothing is lost on crash. A transaction may be delivered twice if the process stops
+// before recording its delivery, see WebhookDeliveryHeader.
+// The transactions are delivered to a webhook in order, a failing delivery holding back the next ones
+// until it succeeds or is given up after WebhookConfig.MaxAttempts.
+type WebhookDispatcher struct {
+	resolver *Resolver
+	// ledgers returns the names of the ledgers to check besides the ones opened by the resolver,
+	// which are only known once requested
+	ledgers func() []string
+	config  WebhookConfig
+	client  *http.Client
+
+	mu sync.Mutex
+	// running holds the ledgers being dispatched, true if they must be dispatched again when done
+	running map[string]bool
+	wg      sync.WaitGroup
+}
+
+func NewWebhookDispatcher(resolver *Resolver, ledgers func() []string, config WebhookConfig) *WebhookDispatcher {
+	if ledgers == nil {
+		ledgers = func() []string { return nil }
+	}
+	return &WebhookDispatcher{
+		resolver: resolver,
+		ledgers:  ledgers,
+		config:   config,
+		client:   &http.Client{},
+		running:  map[string]bool{},
+	}
+}
+
+// Run dispatches the transactions as they are committed through the resolver, and at each poll interval,
+// until the context is cancelled. It returns once the running deliveries are stopped.
+func (d *WebhookDispatcher) Run(ctx context.Context) {
+	unsubscribe := d.resolver.Subscribe(func(e core.CommittedTransactions) {
+		d.trigger(ctx, e.Ledger)
+	})
+
+	ticker := time.NewTicker(d.config.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		for _, name := range d.names() {
+			d.trigger(ctx, name)
+		}
+
+		select {
+		case <-ctx.Done():
+			unsubscribe()
+			// The triggers checking the context before its cancellation are done adding to the wait group
+			d.mu.Lock()
+			d.mu.Unlock()
+			d.wg.Wait()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (d *WebhookDispatcher) names() []string {
+	names := d.resolver.Ledgers()
+	known := map[string]struct{}{}
+	for _, name := range names {
+		known[name] = struct{}{}
+	}
+	for _, name := range d.ledgers() {
+		if _, ok := known[name]; !ok {
+			known[name] = struct{}{}
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// trigger dispatches a ledger in the background, or once more after the running dispatch if any
+func (d *WebhookDispatcher) trigger(ctx context.Context, name string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if ctx.Err() != nil {
+		return
+	}
+	if _, ok := d.running[name]; ok {
+		d.running[name] = true
+		return
+	}
+	d.running[name] = false
+
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		for {
+			if err := d.dispatch(ctx, name); err != nil && ctx.Err() == nil {
+				logrus.Errorf("ledger %s: dispatching webhooks: %s", name, err)
+			}
+
+			d.mu.Lock()
+			if !d.running[name] || ctx.Err() != nil {
+				delete(d.running, name)
+				d.mu.Unlock()
+				return
+			}
+			d.running[name] = false
+			d.mu.Unlock()
+		}
+	}()
+}
+
+// dispatch delivers the transactions committed to a ledger since the cursor of each of its webhooks,
+// the webhooks being delivered concurrently
+func (d *WebhookDispatcher) dispatch(ctx context.Context, name string) error {
+	ctx = storage.WithConsistentRead(ctx)
+
+	l, err := d.resolver.GetLedger(ctx, name)
+	if err != nil {
+		return err
+	}
+	defer l.Close(context.Background())
+
+	webhooks, err := l.store.FindWebhooks(ctx)
+	if err != nil || len(webhooks) == 0 {
+		return err
+	}
+	last, err := l.store.LastTransaction(ctx)
+	if err != nil || last == nil {
+		return err
+	}
+
+	var wg sync.WaitGroup
+	for _, webhook := range webhooks {
+		if webhook.Cursor >= last.ID {
+			continue
+		}
+		wg.Add(1)
+		go func(webhook core.Webhook) {
+			defer wg.Done()
+			if err := d.deliverAll(ctx, l, webhook, last.ID); err != nil && ctx.Err() == nil {
+				logrus.Errorf("ledger %s: delivering webhook %s: %s", name, webhook.ID, err)
+			}
+		}(webhook)
+	}
+	wg.Wait()
+
+	return nil
+}
+
+// deliverAll delivers the transactions after the cursor of the webhook up to last, moving the cursor as they are
+// handled. The cursor is moved past the transactions filtered out by the webhook once they are all handled,
+// or along with the next delivery.
+func (d *WebhookDispatcher) deliverAll(ctx context.Context, l *Ledger, webhook core.Webhook, last int64) error {
+	saved := webhook.Cursor
+	handled := webhook.Cursor
+
+	err := l.walkTransactions(ctx, webhook.Cursor, func(tx core.Transaction) (bool, error) {
+		if tx.ID > last {
+			return false, nil
+		}
+
+		if matchWebhook(webhook, tx) {
+			delivery := d.deliver(ctx, l.name, webhook, tx)
+			if ctx.Err() != nil {
+				// Stopped before the outcome of the delivery is known, the transaction is delivered again on restart
+				return false, ctx.Err()
+			}
+			err := l.store.SaveWebhookDelivery(ctx, delivery)
+			if err != nil {
+				return false, err
+			}
+			saved = tx.ID
+		}
+		handled = tx.ID
+		return true, nil
+	})
+
+	if handled > saved && ctx.Err() == nil {
+		if cursorErr := l.store.UpdateWebhookCursor(ctx, webhook.ID, handled); err == nil {
+			err = cursorErr
+		}
+	}
+
+	return err
+}
+
+// matchWebhook reports whether a transaction has a posting from or to one of the accounts of the webhook, if any
+func matchWebhook(webhook core.Webhook, tx core.Transaction) bool {
+	if len(webhook.Accounts) == 0 {
+		return true
+	}
+	for _, p := range tx.Postings {
+		for _, account := range webhook.Accounts {
+			if storage.MatchAddress(account, p.Source) || storage.MatchAddress(account, p.Destination) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// deliver sends a transaction to a webhook until it is acknowledged or the attempts are exhausted
+func (d *WebhookDispatcher) deliver(ctx context.Context, ledger string, webhook core.Webhook, tx core.Transaction) core.WebhookDelivery {
+	delivery := core.WebhookDelivery{
+		WebhookID:     webhook.ID,
+		TransactionID: tx.ID,
+	}
+
+	id := fmt.Sprintf("%s-%d", webhook.ID, tx.ID)
+	body, err := json.Marshal(WebhookEvent{
+		ID:          id,
+		Type:        WebhookEventCommittedTransaction,
+		Ledger:      ledger,
+		Transaction: tx,
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	for attempt := 1; ; attempt++ {
+		delivery.Attempts = attempt
+		delivery.StatusCode, err = d.post(ctx, webhook, id, body)
+		if err == nil {
+			delivery.Status = core.WebhookDelivered
+			delivery.Error = ""
+			break
+		}
+		delivery.Error = err.Error()
+		if attempt >= d.config.MaxAttempts {
+			delivery.Status = core.WebhookDead
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			return delivery
+		case <-time.After(d.backoff(attempt)):
+		}
+	}
+	delivery.Timestamp = time.Now().UTC().Format(time.RFC3339)
+
+	return delivery
+}
+
+// backoff returns the delay after an attempt
+func (d *WebhookDispatcher) backoff(attempt int) time.Duration {
+	delay := d.config.Backoff
+	for i := 1; i < attempt && delay < d.config.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if d.config.MaxBackoff > 0 && delay > d.config.MaxBackoff {
+		delay = d.config.MaxBackoff
+	}
+	return delay
+}
+
+// post makes an attempt of a delivery, returning the status of the response if any
+func (d *WebhookDispatcher) post(ctx context.Context, webhook core.Webhook, id string, body []byte) (int, error) {
+	if d.config.Timeout > 0 {
+		var cancel func()
+		ctx, cancel = context.WithTimeout(ctx, d.config.Timeout)
+		defer cancel()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookDeliveryHeader, id)
+	req.Header.Set(WebhookSignatureHeader, SignWebhook(webhook.Secret, body))
+
+	rsp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer rsp.Body.Close()
+	io.Copy(ioutil.Discard, rsp.Body)
+
+	if rsp.StatusCode < 200 || rsp.StatusCode >= 300 {
+		return rsp.StatusCode, fmt.Errorf("unexpected status %d", rsp.StatusCode)
+	}
+	return rsp.StatusCode, nil
+}
+
+// SignWebhook returns the signature of the body of a delivery, as sent in the WebhookSignatureHeader
+func SignWebhook(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
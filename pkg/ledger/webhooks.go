@@ -0,0 +1,80 @@
+package ledger
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/numary/ledger/pkg/core"
+	"github.com/numary/ledger/pkg/storage"
+	"github.com/pborman/uuid"
+)
+
+// RegisterWebhook registers an endpoint notified of the transactions committed from now on, see WebhookDispatcher.
+// The transactions can be restricted to the ones with a posting from or to one of the accounts, given by address
+// or pattern like users:*. The webhook is returned along with the secret signing its deliveries.
+func (l *Ledger) RegisterWebhook(ctx context.Context, endpoint string, accounts []string) (core.Webhook, error) {
+	ctx = storage.WithConsistentRead(ctx)
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return core.Webhook{}, newValidationError("invalid webhook url '%s': expected an absolute http or https url", endpoint)
+	}
+	for _, account := range accounts {
+		if account == "" {
+			return core.Webhook{}, newValidationError("invalid webhook account filter: empty account")
+		}
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return core.Webhook{}, err
+	}
+
+	webhook := core.Webhook{
+		ID:        uuid.New(),
+		URL:       endpoint,
+		Secret:    hex.EncodeToString(secret),
+		Accounts:  accounts,
+		Cursor:    -1,
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+	}
+	// The transactions committed before the registration are not delivered
+	last, err := l.store.LastTransaction(ctx)
+	if err != nil {
+		return core.Webhook{}, err
+	}
+	if last != nil {
+		webhook.Cursor = last.ID
+	}
+
+	return webhook, l.store.SaveWebhook(ctx, webhook)
+}
+
+// Webhooks returns the webhooks of the ledger in the order of their registration, without their secret
+func (l *Ledger) Webhooks(ctx context.Context) ([]core.Webhook, error) {
+	webhooks, err := l.store.FindWebhooks(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for i := range webhooks {
+		webhooks[i].Secret = ""
+	}
+
+	return webhooks, nil
+}
+
+// WebhookDeliveries returns the deliveries of a webhook, the most recent first
+func (l *Ledger) WebhookDeliveries(ctx context.Context, id string) ([]core.WebhookDelivery, error) {
+	webhook, err := l.store.GetWebhook(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if webhook == nil {
+		return nil, fmt.Errorf("%w: %s", ErrWebhookNotFound, id)
+	}
+
+	return l.store.FindWebhookDeliveries(ctx, id)
+}
@@ -0,0 +1,216 @@
+package ledger
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/numary/ledger/pkg/core"
+	"github.com/numary/ledger/pkg/storage"
+	"github.com/numary/ledger/pkg/storage/memorystorage"
+	"github.com/stretchr/testify/assert"
+)
+
+// webhookEndpoint records the deliveries it receives, answering with the given status
+type webhookEndpoint struct {
+	mu         sync.Mutex
+	status     int
+	events     []WebhookEvent
+	signatures []string
+	bodies     [][]byte
+	received   chan struct{}
+}
+
+func newWebhookEndpoint(status int) (*webhookEndpoint, *httptest.Server) {
+	e := &webhookEndpoint{
+		status:   status,
+		received: make(chan struct{}, 100),
+	}
+	return e, httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		var event WebhookEvent
+		_ = json.Unmarshal(body, &event)
+
+		e.mu.Lock()
+		e.events = append(e.events, event)
+		e.signatures = append(e.signatures, r.Header.Get(WebhookSignatureHeader))
+		e.bodies = append(e.bodies, body)
+		status := e.status
+		e.mu.Unlock()
+
+		w.WriteHeader(status)
+		e.received <- struct{}{}
+	}))
+}
+
+func (e *webhookEndpoint) setStatus(status int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.status = status
+}
+
+func commitTransfer(t *testing.T, l *Ledger, destination string) {
+	_, err := l.Commit(context.Background(), []core.Transaction{{
+		Postings: []core.Posting{
+			{Source: "world", Destination: destination, Amount: 100, Asset: "COIN"},
+		},
+	}})
+	assert.NoError(t, err)
+}
+
+func TestWebhooks(t *testing.T) {
+	resolver := NewResolver(WithStorageFactory(storage.NewDefaultFactory(memorystorage.NewDriver("memory"))))
+	l, err := resolver.GetLedger(context.Background(), "webhooks")
+	assert.NoError(t, err)
+	defer l.Close(context.Background())
+
+	endpoint, server := newWebhookEndpoint(http.StatusOK)
+	defer server.Close()
+
+	for _, url := range []string{"", "localhost/hook", "ftp://localhost/hook", "http://"} {
+		_, err = l.RegisterWebhook(context.Background(), url, nil)
+		assert.True(t, errors.Is(err, ErrValidation), url)
+	}
+
+	// The transactions committed before the registration are not delivered
+	commitTransfer(t, l, "users:000")
+	webhook, err := l.RegisterWebhook(context.Background(), server.URL, []string{"users:*"})
+	assert.NoError(t, err)
+	assert.NotEmpty(t, webhook.Secret)
+	assert.EqualValues(t, 0, webhook.Cursor)
+
+	commitTransfer(t, l, "users:001")
+	commitTransfer(t, l, "fees")
+	commitTransfer(t, l, "users:002")
+
+	dispatcher := NewWebhookDispatcher(resolver, nil, DefaultWebhookConfig)
+	assert.NoError(t, dispatcher.dispatch(context.Background(), "webhooks"))
+
+	if assert.Len(t, endpoint.events, 2) {
+		assert.Equal(t, WebhookEvent{
+			ID:          webhook.ID + "-1",
+			Type:        WebhookEventCommittedTransaction,
+			Ledger:      "webhooks",
+			Transaction: endpoint.events[0].Transaction,
+		}, endpoint.events[0])
+		assert.EqualValues(t, 1, endpoint.events[0].Transaction.ID)
+		assert.EqualValues(t, 3, endpoint.events[1].Transaction.ID)
+		for i, body := range endpoint.bodies {
+			assert.Equal(t, SignWebhook(webhook.Secret, body), endpoint.signatures[i])
+		}
+	}
+
+	deliveries, err := l.WebhookDeliveries(context.Background(), webhook.ID)
+	assert.NoError(t, err)
+	if assert.Len(t, deliveries, 2) {
+		assert.EqualValues(t, 3, deliveries[0].TransactionID)
+		assert.Equal(t, core.WebhookDelivered, deliveries[0].Status)
+		assert.Equal(t, 1, deliveries[0].Attempts)
+		assert.Equal(t, http.StatusOK, deliveries[0].StatusCode)
+		assert.EqualValues(t, 1, deliveries[1].TransactionID)
+	}
+
+	// The cursor is stored, the transactions are not delivered again
+	webhooks, err := l.Webhooks(context.Background())
+	assert.NoError(t, err)
+	if assert.Len(t, webhooks, 1) {
+		assert.EqualValues(t, 3, webhooks[0].Cursor)
+		assert.Empty(t, webhooks[0].Secret)
+	}
+	assert.NoError(t, dispatcher.dispatch(context.Background(), "webhooks"))
+	assert.Len(t, endpoint.events, 2)
+
+	_, err = l.WebhookDeliveries(context.Background(), "unknown")
+	assert.True(t, errors.Is(err, ErrWebhookNotFound), err)
+}
+
+func TestWebhookDeadLetter(t *testing.T) {
+	resolver := NewResolver(WithStorageFactory(storage.NewDefaultFactory(memorystorage.NewDriver("memory"))))
+	l, err := resolver.GetLedger(context.Background(), "webhooks")
+	assert.NoError(t, err)
+	defer l.Close(context.Background())
+
+	endpoint, server := newWebhookEndpoint(http.StatusInternalServerError)
+	defer server.Close()
+
+	webhook, err := l.RegisterWebhook(context.Background(), server.URL, nil)
+	assert.NoError(t, err)
+	assert.EqualValues(t, -1, webhook.Cursor)
+	commitTransfer(t, l, "users:001")
+
+	dispatcher := NewWebhookDispatcher(resolver, nil, WebhookConfig{
+		MaxAttempts: 3,
+		Backoff:     time.Millisecond,
+		MaxBackoff:  2 * time.Millisecond,
+		Timeout:     time.Second,
+	})
+	assert.NoError(t, dispatcher.dispatch(context.Background(), "webhooks"))
+	assert.Len(t, endpoint.events, 3)
+
+	// The next transactions are delivered once the failing one is given up
+	endpoint.setStatus(http.StatusNoContent)
+	commitTransfer(t, l, "users:002")
+	assert.NoError(t, dispatcher.dispatch(context.Background(), "webhooks"))
+	assert.Len(t, endpoint.events, 4)
+
+	deliveries, err := l.WebhookDeliveries(context.Background(), webhook.ID)
+	assert.NoError(t, err)
+	if assert.Len(t, deliveries, 2) {
+		assert.Equal(t, core.WebhookDelivered, deliveries[0].Status)
+		assert.Equal(t, http.StatusNoContent, deliveries[0].StatusCode)
+		assert.Equal(t, core.WebhookDelivery{
+			WebhookID:     webhook.ID,
+			TransactionID: 0,
+			Status:        core.WebhookDead,
+			Attempts:      3,
+			StatusCode:    http.StatusInternalServerError,
+			Error:         "unexpected status 500",
+			Timestamp:     deliveries[1].Timestamp,
+		}, deliveries[1])
+	}
+}
+
+func TestWebhookDispatcherRun(t *testing.T) {
+	resolver := NewResolver(WithStorageFactory(storage.NewDefaultFactory(memorystorage.NewDriver("memory"))))
+	l, err := resolver.GetLedger(context.Background(), "webhooks")
+	assert.NoError(t, err)
+	defer l.Close(context.Background())
+
+	endpoint, server := newWebhookEndpoint(http.StatusOK)
+	defer server.Close()
+
+	_, err = l.RegisterWebhook(context.Background(), server.URL, nil)
+	assert.NoError(t, err)
+
+	config := DefaultWebhookConfig
+	config.PollInterval = time.Hour
+	dispatcher := NewWebhookDispatcher(resolver, nil, config)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		dispatcher.Run(ctx)
+		close(stopped)
+	}()
+
+	// The commits trigger the deliveries of their ledger
+	commitTransfer(t, l, "users:001")
+	select {
+	case <-endpoint.received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("transaction not delivered")
+	}
+
+	cancel()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("dispatcher not stopped")
+	}
+}
@@ -0,0 +1,121 @@
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "numary"
+
+// Metrics holds the prometheus collectors of the ledgers, all labeled by ledger name.
+// A nil *Metrics is valid and collects nothing, so that metrics can be disabled entirely.
+type Metrics struct {
+	registry *prometheus.Registry
+
+	commits              *prometheus.CounterVec
+	postings             *prometheus.CounterVec
+	insufficientFunds    *prometheus.CounterVec
+	transactions         *prometheus.GaugeVec
+	operationDuration    *prometheus.HistogramVec
+	storageQueryDuration *prometheus.HistogramVec
+}
+
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		commits: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "ledger",
+			Name:      "commits_total",
+			Help:      "Number of successful commits",
+		}, []string{"ledger"}),
+		postings: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "ledger",
+			Name:      "postings_total",
+			Help:      "Number of postings committed",
+		}, []string{"ledger"}),
+		insufficientFunds: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "ledger",
+			Name:      "insufficient_funds_total",
+			Help:      "Number of commits rejected by the balance checks",
+		}, []string{"ledger"}),
+		transactions: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "ledger",
+			Name:      "transactions",
+			Help:      "Number of transactions, as of the last commit",
+		}, []string{"ledger"}),
+		operationDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "ledger",
+			Name:      "operation_duration_seconds",
+			Help:      "Duration of the ledger operations, like commit",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"ledger", "operation"}),
+		storageQueryDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "storage",
+			Name:      "query_duration_seconds",
+			Help:      "Duration of the storage queries",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"ledger", "query"}),
+	}
+
+	m.registry.MustRegister(
+		m.commits,
+		m.postings,
+		m.insufficientFunds,
+		m.transactions,
+		m.operationDuration,
+		m.storageQueryDuration,
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+	)
+
+	return m
+}
+
+// Handler serves the metrics in the prometheus exposition format
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// Committed records a successful commit of transactions holding the given number of postings,
+// and the number of transactions of the ledger after the commit
+func (m *Metrics) Committed(ledger string, postings int, transactions int64) {
+	if m == nil {
+		return
+	}
+	m.commits.WithLabelValues(ledger).Inc()
+	m.postings.WithLabelValues(ledger).Add(float64(postings))
+	m.transactions.WithLabelValues(ledger).Set(float64(transactions))
+}
+
+// InsufficientFunds records a commit rejected by the balance checks
+func (m *Metrics) InsufficientFunds(ledger string) {
+	if m == nil {
+		return
+	}
+	m.insufficientFunds.WithLabelValues(ledger).Inc()
+}
+
+// ObserveOperation records the duration of a ledger operation started at start
+func (m *Metrics) ObserveOperation(ledger, operation string, start time.Time) {
+	if m == nil {
+		return
+	}
+	m.operationDuration.WithLabelValues(ledger, operation).Observe(time.Since(start).Seconds())
+}
+
+// ObserveStorageQuery records the duration of a storage query started at start
+func (m *Metrics) ObserveStorageQuery(ledger, query string, start time.Time) {
+	if m == nil {
+		return
+	}
+	m.storageQueryDuration.WithLabelValues(ledger, query).Observe(time.Since(start).Seconds())
+}
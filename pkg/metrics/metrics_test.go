@@ -0,0 +1,39 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetrics(t *testing.T) {
+	m := New()
+	m.Committed("quickstart", 2, 10)
+	m.InsufficientFunds("quickstart")
+	m.ObserveOperation("quickstart", "commit", time.Now())
+
+	rec := httptest.NewRecorder()
+	m.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	for _, line := range []string{
+		`numary_ledger_commits_total{ledger="quickstart"} 1`,
+		`numary_ledger_postings_total{ledger="quickstart"} 2`,
+		`numary_ledger_transactions{ledger="quickstart"} 10`,
+		`numary_ledger_insufficient_funds_total{ledger="quickstart"} 1`,
+		`numary_ledger_operation_duration_seconds_count{ledger="quickstart",operation="commit"} 1`,
+	} {
+		if !strings.Contains(rec.Body.String(), line) {
+			t.Errorf("expected %q in the metrics", line)
+		}
+	}
+}
+
+func TestDisabledMetrics(t *testing.T) {
+	var m *Metrics
+	m.Committed("quickstart", 2, 10)
+	m.InsufficientFunds("quickstart")
+	m.ObserveOperation("quickstart", "commit", time.Now())
+	m.ObserveStorageQuery("quickstart", "get_meta", time.Now())
+}
@@ -0,0 +1,22 @@
+package storage
+
+import "github.com/numary/ledger/pkg/core"
+
+// AssetsOf returns the assets with one of the given base codes, in the order of assets,
+// the assets without scale suffix being their own base code
+func AssetsOf(assets []string, codes []string) []string {
+	bases := make(map[string]struct{}, len(codes))
+	for _, code := range codes {
+		bases[code] = struct{}{}
+	}
+
+	filtered := []string{}
+	for _, asset := range assets {
+		base, _ := core.AssetScale(asset)
+		if _, ok := bases[base]; ok {
+			filtered = append(filtered, asset)
+		}
+	}
+
+	return filtered
+}
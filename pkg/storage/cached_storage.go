@@ -2,6 +2,7 @@ package storage
 
 import (
 	"context"
+	"errors"
 
 	"github.com/numary/ledger/pkg/core"
 )
@@ -38,6 +39,44 @@ func (s *cachedStateStorage) LastMetaID(ctx context.Context) (int64, error) {
 
 func (s *cachedStateStorage) SaveTransactions(ctx context.Context, txs []core.Transaction) error {
 	err := s.Store.SaveTransactions(ctx, txs)
+	if errors.Is(err, ErrConflict) {
+		// The cached state is outdated by the concurrent write
+		s.lastTransaction = nil
+		s.lastMetaId = nil
+	}
+	if err != nil {
+		return err
+	}
+	if len(txs) > 0 {
+		s.lastTransaction = &txs[len(txs)-1]
+	}
+	return nil
+}
+
+func (s *cachedStateStorage) SaveTransactionsWithKey(ctx context.Context, key string, txs []core.Transaction) error {
+	err := s.Store.SaveTransactionsWithKey(ctx, key, txs)
+	if errors.Is(err, ErrConflict) {
+		// The cached state is outdated by the concurrent write
+		s.lastTransaction = nil
+		s.lastMetaId = nil
+	}
+	if err != nil {
+		return err
+	}
+	if len(txs) > 0 {
+		s.lastTransaction = &txs[len(txs)-1]
+	}
+	return nil
+}
+
+func (s *cachedStateStorage) SaveTransactionsWithMeta(ctx context.Context, txs []core.Transaction, entries []MetaEntry) error {
+	err := s.Store.SaveTransactionsWithMeta(ctx, txs, entries)
+	// The ids of the metadata have been assigned by the store
+	s.lastMetaId = nil
+	if errors.Is(err, ErrConflict) {
+		// The cached state is outdated by the concurrent write
+		s.lastTransaction = nil
+	}
 	if err != nil {
 		return err
 	}
@@ -56,6 +95,24 @@ func (s *cachedStateStorage) SaveMeta(ctx context.Context, id int64, timestamp,
 	return nil
 }
 
+func (s *cachedStateStorage) SaveMetaBatch(ctx context.Context, entries []MetaEntry) error {
+	err := s.Store.SaveMetaBatch(ctx, entries)
+	if err != nil {
+		return err
+	}
+	if len(entries) > 0 {
+		id := entries[len(entries)-1].ID
+		s.lastMetaId = &id
+	}
+	return nil
+}
+
+func (s *cachedStateStorage) Drop(ctx context.Context) error {
+	s.lastTransaction = nil
+	s.lastMetaId = nil
+	return s.Store.Drop(ctx)
+}
+
 func NewCachedStateStorage(underlying Store) *cachedStateStorage {
 	return &cachedStateStorage{
 		Store: underlying,
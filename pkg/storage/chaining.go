@@ -0,0 +1,18 @@
+package storage
+
+import "context"
+
+type previousTransactionKey struct{}
+
+// WithPreviousTransaction sets the id of the last transaction of the ledger when the transactions saved with the context
+// were prepared, -1 if there was none. Their hashes being chained to it, the stores fail with ErrConflict if another
+// transaction was saved since, even if the ids of the saved transactions are greater than its id.
+func WithPreviousTransaction(ctx context.Context, id int64) context.Context {
+	return context.WithValue(ctx, previousTransactionKey{}, id)
+}
+
+// PreviousTransactionFromContext returns the id of the transaction set with WithPreviousTransaction, false if none is set
+func PreviousTransactionFromContext(ctx context.Context) (int64, bool) {
+	id, ok := ctx.Value(previousTransactionKey{}).(int64)
+	return id, ok
+}
@@ -0,0 +1,17 @@
+package storage
+
+import "context"
+
+type consistentReadKey struct{}
+
+// WithConsistentRead makes the stores read from their primary database rather than from a replica,
+// which may lag behind. The reads the writes depend on, like the balances checked by a commit, must be consistent.
+func WithConsistentRead(ctx context.Context) context.Context {
+	return context.WithValue(ctx, consistentReadKey{}, true)
+}
+
+// IsConsistentRead reports whether the reads must be made against the primary database, see WithConsistentRead
+func IsConsistentRead(ctx context.Context) bool {
+	consistent, _ := ctx.Value(consistentReadKey{}).(bool)
+	return consistent
+}
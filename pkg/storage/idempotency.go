@@ -0,0 +1,11 @@
+package storage
+
+// IdempotencyKey records the range of transactions committed under a client provided key
+type IdempotencyKey struct {
+	Key       string
+	FirstTxID int64
+	LastTxID  int64
+	// Timestamp is the time the key was saved, by the clock of the store rather than the timestamps
+	// of the transactions, which may be set by the client
+	Timestamp string
+}
@@ -0,0 +1,167 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/numary/ledger/pkg/core"
+	"github.com/numary/ledger/pkg/ledger/query"
+)
+
+// MatchAddress reports whether an address matches an account address or pattern (see query.Account)
+func MatchAddress(pattern, address string) bool {
+	if prefix, ok := query.AccountPattern(pattern); ok {
+		return strings.HasPrefix(address, prefix)
+	}
+	return address == pattern
+}
+
+// MatchAccount reports whether an account satisfies the filters of a query, except the cursors.
+// It is meant for the stores which can't evaluate the filters natively.
+func MatchAccount(q query.Query, address string) bool {
+	if q.HasParam("account") && !MatchAddress(q.Params["account"].(string), address) {
+		return false
+	}
+	if q.HasParam("address") && !strings.HasPrefix(address, q.Params["address"].(string)) {
+		return false
+	}
+	return true
+}
+
+// MatchTransaction reports whether a transaction satisfies the filters of a query, except the cursors.
+// As with the sql stores, the posting filters must all be satisfied by the same posting.
+// It is meant for the stores which can't evaluate the filters natively.
+func MatchTransaction(q query.Query, tx core.Transaction) bool {
+	if q.HasParam("reference") && tx.Reference != q.Params["reference"] {
+		return false
+	}
+	if q.HasParam("reference_prefix") && !strings.HasPrefix(tx.Reference, q.Params["reference_prefix"].(string)) {
+		return false
+	}
+
+	if q.HasParam("after_timestamp") || q.HasParam("before_timestamp") {
+		ts, err := time.Parse(time.RFC3339, tx.Timestamp)
+		if err != nil {
+			return false
+		}
+		if q.HasParam("after_timestamp") && !ts.After(q.Params["after_timestamp"].(time.Time)) {
+			return false
+		}
+		if q.HasParam("before_timestamp") && !ts.Before(q.Params["before_timestamp"].(time.Time)) {
+			return false
+		}
+	}
+
+	if q.HasParam("metadata") && !MatchMetadata(q.Params["metadata"].(map[string]string), tx.Metadata) {
+		return false
+	}
+
+	for _, p := range tx.Postings {
+		if q.HasParam("account") {
+			account := q.Params["account"].(string)
+			if !MatchAddress(account, p.Source) && !MatchAddress(account, p.Destination) {
+				continue
+			}
+		}
+		if q.HasParam("asset") && p.Asset != q.Params["asset"] {
+			continue
+		}
+		return true
+	}
+
+	return false
+}
+
+// MetadataFilters returns the keys and values of the metadata filters of a query held by the param,
+// "metadata" for the transactions (see query.Metadata) or "account_metadata" for the accounts, sorted by key
+func MetadataFilters(q query.Query, param string) (keys []string, values []string) {
+	filters, _ := q.Params[param].(map[string]string)
+	for key := range filters {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		values = append(values, filters[key])
+	}
+	return keys, values
+}
+
+// MetadataPath splits the key of a metadata filter into the metadata key and the path of the value inside
+func MetadataPath(key string) (string, []string) {
+	segments := strings.Split(key, ".")
+	return segments[0], segments[1:]
+}
+
+// MatchMetadataValue reports whether the value at the path of a metadata value is the expected one,
+// a string or the JSON text of a number or a boolean
+func MatchMetadataValue(raw json.RawMessage, path []string, expected string) bool {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var value interface{}
+	if err := dec.Decode(&value); err != nil {
+		return false
+	}
+	for _, segment := range path {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		value = object[segment]
+	}
+
+	switch value := value.(type) {
+	case string:
+		return value == expected
+	case json.Number:
+		return value.String() == expected
+	case bool:
+		return strconv.FormatBool(value) == expected
+	}
+	return false
+}
+
+// MatchMetadata reports whether metadata satisfy all the metadata filters of a query
+func MatchMetadata(filters map[string]string, meta core.Metadata) bool {
+	for key, expected := range filters {
+		key, path := MetadataPath(key)
+		raw, ok := meta[key]
+		if !ok || !MatchMetadataValue(raw, path, expected) {
+			return false
+		}
+	}
+	return true
+}
+
+// CursorAfter parses the After cursor of a transactions query, returning -1 if it is not set
+func CursorAfter(q query.Query) (int64, error) {
+	if q.After == "" {
+		return -1, nil
+	}
+	return strconv.ParseInt(q.After, 10, 64)
+}
+
+// CursorBefore parses the Before cursor of a transactions query, returning -1 if it is not set
+func CursorBefore(q query.Query) (int64, error) {
+	if q.Before == "" {
+		return -1, nil
+	}
+	return strconv.ParseInt(q.Before, 10, 64)
+}
+
+// ReverseTransactions reverses a slice of transactions in place
+func ReverseTransactions(txs []core.Transaction) {
+	for i, j := 0, len(txs)-1; i < j; i, j = i+1, j-1 {
+		txs[i], txs[j] = txs[j], txs[i]
+	}
+}
+
+// ReverseAccounts reverses a slice of accounts in place
+func ReverseAccounts(accounts []core.Account) {
+	for i, j := 0, len(accounts)-1; i < j; i, j = i+1, j-1 {
+		accounts[i], accounts[j] = accounts[j], accounts[i]
+	}
+}
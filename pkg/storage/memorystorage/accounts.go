@@ -0,0 +1,196 @@
+package memorystorage
+
+import (
+	"context"
+	"math"
+
+	"github.com/numary/ledger/pkg/core"
+	"github.com/numary/ledger/pkg/ledger/query"
+	"github.com/numary/ledger/pkg/storage"
+)
+
+// findAddresses returns the addresses of the accounts matching the query, ordered as FindAccounts.
+// The store must be locked.
+func (s *Store) findAddresses(q query.Query) []string {
+	addresses := make([]string, 0, len(s.volumes))
+	for address := range s.volumes {
+		if q.After != "" && !storage.AddressFollows(q, address, q.After) {
+			continue
+		}
+		if q.Before != "" && !storage.AddressFollows(q, q.Before, address) {
+			continue
+		}
+		if s.matchAccount(q, address) {
+			addresses = append(addresses, address)
+		}
+	}
+	storage.SortAddresses(q, addresses)
+
+	return addresses
+}
+
+// matchAccount reports whether an account satisfies the query, reading its metadata only if the query filters them.
+// The store must be locked.
+func (s *Store) matchAccount(q query.Query, address string) bool {
+	if !storage.MatchAccount(q, address) {
+		return false
+	}
+	if q.HasParam("account_metadata") {
+		return storage.MatchMetadata(q.Params["account_metadata"].(map[string]string), s.meta("account", address))
+	}
+	return true
+}
+
+// findByBalance returns up to limit addresses of the accounts matching the query sorted by balance,
+// with their balances. The store must be locked.
+func (s *Store) findByBalance(q query.Query, limit int) ([]string, map[string]int64, error) {
+	sorting := q.Sorting()
+
+	balances := map[string]int64{}
+	for _, tx := range s.transactions {
+		if sorting.TxID != nil && tx.ID > *sorting.TxID {
+			break
+		}
+		storage.AddPostings(balances, sorting.Asset, tx)
+	}
+	if q.HasParam("account_metadata") {
+		for address := range balances {
+			if !s.matchAccount(q, address) {
+				delete(balances, address)
+			}
+		}
+	}
+
+	addresses, err := storage.PageByBalance(q, balances, limit)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return addresses, balances, nil
+}
+
+func (s *Store) FindAccounts(ctx context.Context, q query.Query) (query.Cursor, error) {
+	limit := int(math.Max(-1, float64(q.Limit)))
+
+	c := query.Cursor{}
+	results := make([]core.Account, 0)
+
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	// We fetch an additional account to know if we have more documents
+	sorting := q.Sorting()
+	var (
+		addresses []string
+		balances  map[string]int64
+	)
+	if sorting.By == query.SortByBalance {
+		var err error
+		addresses, balances, err = s.findByBalance(q, limit+1)
+		if err != nil {
+			return c, err
+		}
+	} else {
+		addresses = s.findAddresses(q)
+	}
+
+	for _, address := range addresses {
+		if len(results) > limit {
+			break
+		}
+
+		account := core.Account{
+			Address:  address,
+			Contract: "default",
+			Metadata: s.meta("account", address),
+		}
+		if balances != nil {
+			account.Balances = map[string]int64{
+				sorting.Asset: balances[address],
+			}
+		}
+
+		results = append(results, account)
+	}
+
+	c.PageSize = limit
+
+	c.HasMore = len(results) > limit
+	if c.HasMore {
+		results = results[:limit]
+	}
+	if q.Before != "" {
+		storage.ReverseAccounts(results)
+	}
+	c.Data = results
+	c.Total = int64(len(s.volumes))
+
+	return c, nil
+}
+
+// FindBalances returns a page of accounts matching the query with their balances
+func (s *Store) FindBalances(ctx context.Context, q query.Query) (query.Cursor, error) {
+	limit := int(math.Max(-1, float64(q.Limit)))
+
+	c := query.Cursor{}
+	results := make([]core.Account, 0)
+
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	for _, address := range s.findAddresses(q) {
+		// We fetch an additional account to know if we have more documents
+		if len(results) > limit {
+			break
+		}
+
+		balances := map[string]int64{}
+		for asset, v := range s.volumes[address] {
+			balances[asset] = v["input"] - v["output"]
+		}
+
+		results = append(results, core.Account{
+			Address:  address,
+			Contract: "default",
+			Balances: balances,
+		})
+	}
+
+	c.PageSize = limit
+
+	c.HasMore = len(results) > limit
+	if c.HasMore {
+		results = results[:limit]
+	}
+	if q.Before != "" {
+		storage.ReverseAccounts(results)
+	}
+	c.Data = results
+	c.Total = int64(len(s.volumes))
+
+	return c, nil
+}
+
+func (s *Store) GetAccounts(ctx context.Context, addresses []string) ([]core.Account, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	accounts := make([]core.Account, 0, len(addresses))
+	for _, address := range addresses {
+		account := core.Account{
+			Address:  address,
+			Contract: "default",
+			Balances: map[string]int64{},
+		}
+		for asset, v := range s.volumes[address] {
+			account.Balances[asset] = v["input"] - v["output"]
+		}
+		// The accounts which never appeared keep nil metadata
+		if _, ok := s.volumes[address]; ok || len(s.metadata["account"][address]) > 0 {
+			account.Metadata = s.meta("account", address)
+		}
+		accounts = append(accounts, account)
+	}
+
+	return accounts, nil
+}
@@ -0,0 +1,224 @@
+package memorystorage
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/numary/ledger/pkg/ledger/query"
+	"github.com/numary/ledger/pkg/storage"
+)
+
+// volume returns the volumes of an account for an asset, creating them if needed. The store must be locked.
+func (s *Store) volume(address, asset string) map[string]int64 {
+	if _, ok := s.volumes[address]; !ok {
+		s.volumes[address] = map[string]map[string]int64{}
+	}
+	if _, ok := s.volumes[address][asset]; !ok {
+		s.volumes[address][asset] = map[string]int64{}
+	}
+	return s.volumes[address][asset]
+}
+
+// RecomputeVolumes replays the postings of all the transactions
+func (s *Store) RecomputeVolumes(ctx context.Context) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.volumes = map[string]map[string]map[string]int64{}
+	for _, tx := range s.transactions {
+		for _, p := range tx.Postings {
+			s.volume(p.Source, p.Asset)["output"] += p.Amount
+			s.volume(p.Destination, p.Asset)["input"] += p.Amount
+		}
+	}
+
+	return nil
+}
+
+func (s *Store) CountTransactions(ctx context.Context) (int64, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return int64(len(s.transactions)), nil
+}
+
+func (s *Store) CountAccounts(ctx context.Context) (int64, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return int64(len(s.volumes)), nil
+}
+
+// AssetVolumes sums the inputs of the accounts, as each posting is the input of an account
+func (s *Store) FindAssets(ctx context.Context) ([]string, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	seen := map[string]struct{}{}
+	assets := []string{}
+	for _, volumes := range s.volumes {
+		for asset := range volumes {
+			if _, ok := seen[asset]; !ok {
+				seen[asset] = struct{}{}
+				assets = append(assets, asset)
+			}
+		}
+	}
+	sort.Strings(assets)
+
+	return assets, nil
+}
+
+func (s *Store) FindAssetsOf(ctx context.Context, codes []string) ([]string, error) {
+	assets, err := s.FindAssets(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return storage.AssetsOf(assets, codes), nil
+}
+
+func (s *Store) AssetVolumes(ctx context.Context) (map[string]int64, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	volumes := map[string]int64{}
+	for _, assets := range s.volumes {
+		for asset, v := range assets {
+			volumes[asset] += v["input"]
+		}
+	}
+
+	return volumes, nil
+}
+
+func (s *Store) CountMeta(ctx context.Context) (int64, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return s.metaCount, nil
+}
+
+func (s *Store) AggregateBalances(ctx context.Context, address string) (map[string]int64, error) {
+	balances := map[string]int64{}
+
+	volumes, err := s.AggregateVolumes(ctx, address)
+
+	if err != nil {
+		return balances, err
+	}
+
+	for asset := range volumes {
+		balances[asset] = volumes[asset]["input"] - volumes[asset]["output"]
+	}
+
+	return balances, nil
+}
+
+func (s *Store) AggregateBalance(ctx context.Context, address, asset string) (int64, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	volumes := s.volumes[address][asset]
+
+	return volumes["input"] - volumes["output"], nil
+}
+
+func (s *Store) AccountExists(ctx context.Context, address string) (bool, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	_, ok := s.volumes[address]
+
+	return ok, nil
+}
+
+func (s *Store) AggregateVolumes(ctx context.Context, address string) (map[string]map[string]int64, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	volumes := map[string]map[string]int64{}
+
+	for asset, v := range s.volumes[address] {
+		volumes[asset] = map[string]int64{
+			"input":  v["input"],
+			"output": v["output"],
+		}
+	}
+
+	return volumes, nil
+}
+
+func (s *Store) AggregateVolumesAt(ctx context.Context, address string, txid int64) (map[string]map[string]int64, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	volumes := map[string]map[string]int64{}
+	volume := func(asset string) map[string]int64 {
+		if _, ok := volumes[asset]; !ok {
+			volumes[asset] = map[string]int64{
+				"input":  0,
+				"output": 0,
+			}
+		}
+		return volumes[asset]
+	}
+
+	for _, tx := range s.transactions {
+		if tx.ID > txid {
+			break
+		}
+		for _, p := range tx.Postings {
+			if p.Source == address {
+				volume(p.Asset)["output"] += p.Amount
+			}
+			if p.Destination == address {
+				volume(p.Asset)["input"] += p.Amount
+			}
+		}
+	}
+
+	return volumes, nil
+}
+
+func (s *Store) AggregateFlow(ctx context.Context, source, destination, asset string, from, to time.Time) (int64, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	var flow int64
+	for _, tx := range s.transactions {
+		timestamp, err := time.Parse(time.RFC3339, tx.Timestamp)
+		if err != nil || (!from.IsZero() && timestamp.Before(from)) || (!to.IsZero() && !timestamp.Before(to)) {
+			continue
+		}
+		for _, p := range tx.Postings {
+			if p.Source == source && p.Destination == destination && p.Asset == asset {
+				flow += p.Amount
+			}
+		}
+	}
+
+	return flow, nil
+}
+
+// SumBalances sums the balances of the accounts matching the query, grouped by asset
+func (s *Store) SumBalances(ctx context.Context, q query.Query) (map[string]int64, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	balances := map[string]int64{}
+
+	for address, volumes := range s.volumes {
+		if q.HasParam("address") && !strings.HasPrefix(address, q.Params["address"].(string)) {
+			continue
+		}
+
+		for asset, v := range volumes {
+			balances[asset] += v["input"] - v["output"]
+		}
+	}
+
+	return balances, nil
+}
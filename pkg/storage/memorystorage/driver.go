@@ -0,0 +1,47 @@
+package memorystorage
+
+import (
+	"context"
+	"sync"
+
+	"github.com/numary/ledger/pkg/storage"
+)
+
+// driver is a driver which keeps the ledgers in memory until the process exits.
+// Like a database, it returns the same data each time a store is requested for a given ledger.
+type driver struct {
+	name   string
+	lock   sync.Mutex
+	stores map[string]*Store
+}
+
+func (d *driver) Name() string {
+	return d.name
+}
+
+func (d *driver) Initialize(ctx context.Context) error {
+	return nil
+}
+
+func (d *driver) NewStore(name string) (storage.Store, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	store, ok := d.stores[name]
+	if !ok {
+		store = NewStore(name)
+		d.stores[name] = store
+	}
+	return store, nil
+}
+
+func (d *driver) Close(ctx context.Context) error {
+	return nil
+}
+
+func NewDriver(name string) *driver {
+	return &driver{
+		name:   name,
+		stores: map[string]*Store{},
+	}
+}
@@ -0,0 +1,26 @@
+package memorystorage
+
+import (
+	"context"
+
+	"github.com/numary/ledger/pkg/core"
+	"github.com/numary/ledger/pkg/storage"
+)
+
+func (s *Store) GetIdempotencyKey(ctx context.Context, key string) (*storage.IdempotencyKey, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	ik, ok := s.idempotencyKeys[key]
+	if !ok {
+		return nil, nil
+	}
+
+	return &ik, nil
+}
+
+// SaveTransactionsWithKey saves the transactions and records the idempotency key atomically,
+// replacing any previous (expired) record of the key.
+func (s *Store) SaveTransactionsWithKey(ctx context.Context, key string, ts []core.Transaction) error {
+	return s.saveTransactions(ctx, key, ts, nil)
+}
@@ -0,0 +1,128 @@
+package memorystorage
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+
+	"github.com/numary/ledger/pkg/core"
+	"github.com/numary/ledger/pkg/storage"
+)
+
+func (s *Store) LastMetaID(ctx context.Context) (int64, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return s.lastMetaID, nil
+}
+
+// saveMeta saves a metadata entry, only the last value of a key is kept. The store must be locked.
+func (s *Store) saveMeta(e storage.MetaEntry) {
+	if _, ok := s.metadata[e.TargetType]; !ok {
+		s.metadata[e.TargetType] = map[string]map[string]string{}
+	}
+	if _, ok := s.metadata[e.TargetType][e.TargetID]; !ok {
+		s.metadata[e.TargetType][e.TargetID] = map[string]string{}
+	}
+	s.metadata[e.TargetType][e.TargetID][e.Key] = e.Value
+
+	if e.ID > s.lastMetaID {
+		s.lastMetaID = e.ID
+	}
+	s.metaCount++
+}
+
+// meta returns a copy of the metadata of a target, the store must be locked
+func (s *Store) meta(ty string, id string) core.Metadata {
+	meta := core.Metadata{}
+	for k, v := range s.metadata[ty][id] {
+		meta[k] = json.RawMessage(v)
+	}
+	return meta
+}
+
+func (s *Store) GetMeta(ctx context.Context, ty string, id string) (core.Metadata, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return s.meta(ty, id), nil
+}
+
+func (s *Store) FindMetaTargets(ctx context.Context, ty string) ([]string, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	ids := []string{}
+	for id, meta := range s.metadata[ty] {
+		if len(meta) > 0 {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	return ids, nil
+}
+
+func (s *Store) SaveMeta(ctx context.Context, id int64, timestamp, targetType, targetID, key, value string) error {
+	return s.SaveMetaBatch(ctx, []storage.MetaEntry{
+		{
+			ID:         id,
+			Timestamp:  timestamp,
+			TargetType: targetType,
+			TargetID:   targetID,
+			Key:        key,
+			Value:      value,
+		},
+	})
+}
+
+func (s *Store) SaveMetaBatch(ctx context.Context, entries []storage.MetaEntry) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if version, ok := storage.MetaVersionFromContext(ctx); ok {
+		for _, target := range storage.MetaTargets(entries) {
+			if s.metaVersions[target] != version {
+				return storage.ErrMetaVersionMismatch
+			}
+		}
+	}
+
+	for _, e := range entries {
+		s.saveMeta(e)
+	}
+	s.updateMetaVersions(entries)
+
+	return nil
+}
+
+func (s *Store) DeleteMeta(ctx context.Context, targetType, targetID string, keys []string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for _, key := range keys {
+		if _, ok := s.metadata[targetType][targetID][key]; ok {
+			delete(s.metadata[targetType][targetID], key)
+			s.metaCount--
+		}
+	}
+	if len(keys) > 0 {
+		s.metaVersions[storage.MetaTarget{Type: targetType, ID: targetID}]++
+	}
+
+	return nil
+}
+
+func (s *Store) GetMetaVersion(ctx context.Context, targetType, targetID string) (int64, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return s.metaVersions[storage.MetaTarget{Type: targetType, ID: targetID}], nil
+}
+
+// updateMetaVersions increments the versions of the targets of the entries, the store must be locked
+func (s *Store) updateMetaVersions(entries []storage.MetaEntry) {
+	for _, target := range storage.MetaTargets(entries) {
+		s.metaVersions[target]++
+	}
+}
@@ -0,0 +1,66 @@
+package memorystorage
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/numary/ledger/pkg/core"
+	"github.com/numary/ledger/pkg/storage"
+)
+
+func (s *Store) SaveScript(ctx context.Context, script core.StoredScript) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	versions := s.scripts[script.Name]
+	for _, v := range versions {
+		if v.Version == script.Version {
+			return fmt.Errorf("%w: version %d of script %s already exists", storage.ErrConflict, script.Version, script.Name)
+		}
+	}
+	versions = append(versions, script)
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i].Version < versions[j].Version
+	})
+	s.scripts[script.Name] = versions
+
+	return nil
+}
+
+func (s *Store) GetScript(ctx context.Context, name string, version int64) (*core.StoredScript, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	versions := s.scripts[name]
+	if len(versions) == 0 {
+		return nil, nil
+	}
+	if version == 0 {
+		script := versions[len(versions)-1]
+		return &script, nil
+	}
+	for _, v := range versions {
+		if v.Version == version {
+			script := v
+			return &script, nil
+		}
+	}
+
+	return nil, nil
+}
+
+func (s *Store) FindScripts(ctx context.Context) ([]core.StoredScript, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	scripts := make([]core.StoredScript, 0, len(s.scripts))
+	for _, versions := range s.scripts {
+		scripts = append(scripts, versions[len(versions)-1])
+	}
+	sort.Slice(scripts, func(i, j int) bool {
+		return scripts[i].Name < scripts[j].Name
+	})
+
+	return scripts, nil
+}
@@ -0,0 +1,89 @@
+package memorystorage
+
+import (
+	"context"
+	"sync"
+
+	"github.com/numary/ledger/pkg/core"
+	"github.com/numary/ledger/pkg/storage"
+)
+
+// Store is a storage.Store keeping a ledger in memory, mostly useful for tests.
+// It enforces the same constraints as the sql stores, and is safe for concurrent use.
+type Store struct {
+	lock sync.RWMutex
+
+	ledger       string
+	transactions []core.Transaction
+	references   map[string]int64
+	// usedReferences are the keys of the references of the transactions in all the scopes, see storage.UsedReferenceKeys
+	usedReferences map[storage.ReferenceKey]struct{}
+	// hashes are the ids of the transactions by hash
+	hashes map[string]int64
+	// volumes by address, asset then "input" or "output"
+	volumes map[string]map[string]map[string]int64
+	// metadata by target type, target id then key
+	metadata        map[string]map[string]map[string]string
+	lastMetaID      int64
+	metaCount       int64
+	metaVersions    map[storage.MetaTarget]int64
+	idempotencyKeys map[string]storage.IdempotencyKey
+	// scripts by name, sorted by version
+	scripts map[string][]core.StoredScript
+	// webhooks in the order of their registration, and their deliveries by webhook id in the order of delivery
+	webhooks   []core.Webhook
+	deliveries map[string][]core.WebhookDelivery
+}
+
+func NewStore(name string) *Store {
+	return &Store{
+		ledger:          name,
+		references:      map[string]int64{},
+		usedReferences:  map[storage.ReferenceKey]struct{}{},
+		hashes:          map[string]int64{},
+		volumes:         map[string]map[string]map[string]int64{},
+		metadata:        map[string]map[string]map[string]string{},
+		lastMetaID:      -1,
+		metaVersions:    map[storage.MetaTarget]int64{},
+		idempotencyKeys: map[string]storage.IdempotencyKey{},
+		scripts:         map[string][]core.StoredScript{},
+		deliveries:      map[string][]core.WebhookDelivery{},
+	}
+}
+
+func (s *Store) Name() string {
+	return s.ledger
+}
+
+func (s *Store) Initialize(ctx context.Context) error {
+	return nil
+}
+
+func (s *Store) Drop(ctx context.Context) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.transactions = nil
+	s.references = map[string]int64{}
+	s.usedReferences = map[storage.ReferenceKey]struct{}{}
+	s.hashes = map[string]int64{}
+	s.volumes = map[string]map[string]map[string]int64{}
+	s.metadata = map[string]map[string]map[string]string{}
+	s.lastMetaID = -1
+	s.metaCount = 0
+	s.metaVersions = map[storage.MetaTarget]int64{}
+	s.idempotencyKeys = map[string]storage.IdempotencyKey{}
+	s.scripts = map[string][]core.StoredScript{}
+	s.webhooks = nil
+	s.deliveries = map[string][]core.WebhookDelivery{}
+
+	return nil
+}
+
+func (s *Store) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (s *Store) Close(ctx context.Context) error {
+	return nil
+}
@@ -0,0 +1,65 @@
+package memorystorage
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/numary/ledger/pkg/core"
+	"github.com/numary/ledger/pkg/ledger/query"
+	"github.com/numary/ledger/pkg/storage"
+	"github.com/numary/ledger/pkg/storage/storagetesting"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStore(t *testing.T) {
+	storagetesting.TestStore(t, func(t *testing.T) storage.Store {
+		return NewStore("test")
+	})
+}
+
+func TestConcurrentAccess(t *testing.T) {
+	store := NewStore("test")
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				store.SaveMeta(context.Background(), int64(i*100+j), time.Now().Format(time.RFC3339),
+					"account", fmt.Sprintf("users:%d", i), "counter", fmt.Sprint(j))
+				store.GetMeta(context.Background(), "account", fmt.Sprintf("users:%d", i))
+				store.FindTransactions(context.Background(), query.New())
+			}
+		}(i)
+	}
+
+	for i := int64(0); i < 100; i++ {
+		err := store.SaveTransactions(context.Background(), []core.Transaction{
+			{
+				ID: i,
+				Postings: []core.Posting{
+					{
+						Source:      "world",
+						Destination: "users:001",
+						Amount:      1,
+						Asset:       "COIN",
+					},
+				},
+			},
+		})
+		assert.NoError(t, err)
+	}
+	wg.Wait()
+
+	balance, err := store.AggregateBalance(context.Background(), "users:001", "COIN")
+	assert.NoError(t, err)
+	assert.EqualValues(t, 100, balance)
+
+	count, err := store.CountMeta(context.Background())
+	assert.NoError(t, err)
+	assert.EqualValues(t, 1000, count)
+}
@@ -0,0 +1,282 @@
+package memorystorage
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/numary/ledger/pkg/core"
+	"github.com/numary/ledger/pkg/ledger/query"
+	"github.com/numary/ledger/pkg/storage"
+)
+
+func (s *Store) FindTransactions(ctx context.Context, q query.Query) (query.Cursor, error) {
+	limit := int(math.Max(-1, float64(q.Limit)))
+
+	c := query.Cursor{}
+	results := make([]core.Transaction, 0)
+
+	after, err := storage.CursorAfter(q)
+	if err != nil {
+		return c, err
+	}
+	before, err := storage.CursorBefore(q)
+	if err != nil {
+		return c, err
+	}
+
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	// The previous page is fetched in the reverse order, starting from the keyset
+	keyset := after
+	if before >= 0 {
+		keyset = before
+	}
+
+	// We fetch an additional transaction to know if we have more documents
+	if (before >= 0) != q.Sorting().Asc {
+		for i := s.position(keyset + 1); i < len(s.transactions) && len(results) <= limit; i++ {
+			if s.match(q, i) {
+				results = append(results, s.transaction(i))
+			}
+		}
+	} else {
+		end := len(s.transactions) - 1
+		if keyset >= 0 {
+			end = s.position(keyset) - 1
+		}
+		for i := end; i >= 0 && len(results) <= limit; i-- {
+			if s.match(q, i) {
+				results = append(results, s.transaction(i))
+			}
+		}
+	}
+
+	c.PageSize = limit
+
+	c.HasMore = len(results) > limit
+	if c.HasMore {
+		results = results[:limit]
+	}
+	if before >= 0 {
+		storage.ReverseTransactions(results)
+	}
+	c.Data = results
+	c.Total = int64(len(s.transactions))
+
+	return c, nil
+}
+
+// match reports whether the transaction at a position satisfies the query, reading its metadata only if the query
+// filters them. The store must be locked.
+func (s *Store) match(q query.Query, i int) bool {
+	tx := s.transactions[i]
+	if q.HasParam("metadata") {
+		tx.Metadata = s.meta("transaction", fmt.Sprintf("%d", tx.ID))
+	}
+	return storage.MatchTransaction(q, tx)
+}
+
+// transaction returns a copy of the transaction at a position along with its metadata, the store must be locked
+func (s *Store) transaction(i int) core.Transaction {
+	tx := s.transactions[i]
+	tx.Postings = append(core.Postings{}, tx.Postings...)
+	tx.Metadata = s.meta("transaction", fmt.Sprintf("%d", tx.ID))
+	return tx
+}
+
+// position returns the position of the first transaction with an id greater than or equal to id, the number
+// of transactions if there is none. The ids increasing, it is the id itself unless the ids are sparse.
+// The store must be locked.
+func (s *Store) position(id int64) int {
+	if id >= 0 && id < int64(len(s.transactions)) && s.transactions[id].ID == id {
+		return int(id)
+	}
+	return sort.Search(len(s.transactions), func(i int) bool {
+		return s.transactions[i].ID >= id
+	})
+}
+
+// find returns the position of the transaction with an id, false if there is none. The store must be locked.
+func (s *Store) find(id int64) (int, bool) {
+	i := s.position(id)
+	return i, i < len(s.transactions) && s.transactions[i].ID == id
+}
+
+func (s *Store) SaveTransactions(ctx context.Context, ts []core.Transaction) error {
+	return s.saveTransactions(ctx, "", ts, nil)
+}
+
+// SaveTransactionsWithMeta saves the transactions along with metadata of other targets atomically
+func (s *Store) SaveTransactionsWithMeta(ctx context.Context, ts []core.Transaction, entries []storage.MetaEntry) error {
+	return s.saveTransactions(ctx, "", ts, entries)
+}
+
+// saveTransactions appends the transactions and updates the volumes of the accounts,
+// along with the idempotency key if it is not empty and the metadata entries.
+// It fails if the ids don't follow the last transaction or if a reference is already used within its scope,
+// see storage.WithReferenceScope, as the primary key and reference unicity constraints of the sql stores would.
+func (s *Store) saveTransactions(ctx context.Context, key string, ts []core.Transaction, entries []storage.MetaEntry) error {
+	if len(ts) == 0 {
+		return nil
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	// Like a database, nothing is saved once the context is done
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	last := int64(-1)
+	if len(s.transactions) > 0 {
+		last = s.transactions[len(s.transactions)-1].ID
+	}
+	if previous, ok := storage.PreviousTransactionFromContext(ctx); ok && previous != last {
+		return fmt.Errorf("%w: transaction %d was saved after transaction %d", storage.ErrConflict, last, previous)
+	}
+
+	scope := storage.ReferenceScopeFromContext(ctx)
+	references := map[string]struct{}{}
+	for _, t := range ts {
+		if t.ID <= last {
+			return fmt.Errorf("%w: transaction %d does not follow the last transaction", storage.ErrConflict, t.ID)
+		}
+		last = t.ID
+
+		for _, k := range storage.ReferenceKeys(scope, t) {
+			_, used := s.references[k.String()]
+			if _, ok := references[k.String()]; ok || used {
+				return fmt.Errorf("%w: %s", storage.ErrDuplicateReference, t.Reference)
+			}
+			references[k.String()] = struct{}{}
+		}
+	}
+
+	for _, t := range ts {
+		metadata := t.Metadata
+		t.Metadata = nil
+		t.Postings = append(core.Postings{}, t.Postings...)
+		s.transactions = append(s.transactions, t)
+
+		for _, k := range storage.ReferenceKeys(scope, t) {
+			s.references[k.String()] = t.ID
+		}
+		for _, k := range storage.UsedReferenceKeys(t) {
+			s.usedReferences[k] = struct{}{}
+		}
+		if t.Hash != "" {
+			s.hashes[t.Hash] = t.ID
+		}
+
+		for _, p := range t.Postings {
+			s.volume(p.Source, p.Asset)["output"] += p.Amount
+			s.volume(p.Destination, p.Asset)["input"] += p.Amount
+		}
+
+		for k, v := range metadata {
+			s.saveMeta(storage.MetaEntry{
+				ID:         s.lastMetaID + 1,
+				Timestamp:  t.Timestamp,
+				TargetType: "transaction",
+				TargetID:   fmt.Sprintf("%d", t.ID),
+				Key:        k,
+				Value:      string(v),
+			})
+		}
+	}
+
+	for _, e := range entries {
+		e.ID = s.lastMetaID + 1
+		s.saveMeta(e)
+	}
+	s.updateMetaVersions(entries)
+
+	if key != "" {
+		s.idempotencyKeys[key] = storage.IdempotencyKey{
+			Key:       key,
+			FirstTxID: ts[0].ID,
+			LastTxID:  ts[len(ts)-1].ID,
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+		}
+	}
+
+	return nil
+}
+
+func (s *Store) GetTransaction(ctx context.Context, txid string) (core.Transaction, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	id, err := strconv.ParseInt(txid, 10, 64)
+	if err != nil {
+		return core.Transaction{
+			Metadata: core.Metadata{},
+		}, nil
+	}
+	i, ok := s.find(id)
+	if !ok {
+		return core.Transaction{
+			Metadata: core.Metadata{},
+		}, nil
+	}
+
+	return s.transaction(i), nil
+}
+
+func (s *Store) GetTransactionByHash(ctx context.Context, hash string) (core.Transaction, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	id, ok := s.hashes[hash]
+	if !ok {
+		return core.Transaction{
+			Metadata: core.Metadata{},
+		}, nil
+	}
+	i, _ := s.find(id)
+
+	return s.transaction(i), nil
+}
+
+func (s *Store) FindUsedReferences(ctx context.Context, keys []storage.ReferenceKey) ([]storage.ReferenceKey, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	used := make([]storage.ReferenceKey, 0)
+	for _, k := range keys {
+		if _, ok := s.usedReferences[k]; ok {
+			used = append(used, k)
+		}
+	}
+	return used, nil
+}
+
+func (s *Store) LastTransaction(ctx context.Context) (*core.Transaction, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	if len(s.transactions) == 0 {
+		return nil, nil
+	}
+
+	tx := s.transaction(len(s.transactions) - 1)
+	return &tx, nil
+}
+
+func (s *Store) Head(ctx context.Context) (int64, string, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	if len(s.transactions) == 0 {
+		return -1, "", nil
+	}
+
+	last := s.transactions[len(s.transactions)-1]
+	return last.ID, last.Hash, nil
+}
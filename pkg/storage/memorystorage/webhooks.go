@@ -0,0 +1,92 @@
+package memorystorage
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/numary/ledger/pkg/core"
+	"github.com/numary/ledger/pkg/storage"
+)
+
+func (s *Store) SaveWebhook(ctx context.Context, webhook core.Webhook) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for _, w := range s.webhooks {
+		if w.ID == webhook.ID {
+			return fmt.Errorf("%w: webhook %s already exists", storage.ErrConflict, webhook.ID)
+		}
+	}
+	webhook.Accounts = append([]string(nil), webhook.Accounts...)
+	s.webhooks = append(s.webhooks, webhook)
+
+	return nil
+}
+
+func (s *Store) GetWebhook(ctx context.Context, id string) (*core.Webhook, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	for _, w := range s.webhooks {
+		if w.ID == id {
+			webhook := w
+			webhook.Accounts = append([]string(nil), w.Accounts...)
+			return &webhook, nil
+		}
+	}
+
+	return nil, nil
+}
+
+func (s *Store) FindWebhooks(ctx context.Context) ([]core.Webhook, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	webhooks := make([]core.Webhook, 0, len(s.webhooks))
+	for _, w := range s.webhooks {
+		w.Accounts = append([]string(nil), w.Accounts...)
+		webhooks = append(webhooks, w)
+	}
+
+	return webhooks, nil
+}
+
+func (s *Store) UpdateWebhookCursor(ctx context.Context, id string, cursor int64) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.moveWebhookCursor(id, cursor)
+
+	return nil
+}
+
+func (s *Store) SaveWebhookDelivery(ctx context.Context, delivery core.WebhookDelivery) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.deliveries[delivery.WebhookID] = append(s.deliveries[delivery.WebhookID], delivery)
+	s.moveWebhookCursor(delivery.WebhookID, delivery.TransactionID)
+
+	return nil
+}
+
+func (s *Store) moveWebhookCursor(id string, cursor int64) {
+	for i := range s.webhooks {
+		if s.webhooks[i].ID == id {
+			s.webhooks[i].Cursor = cursor
+		}
+	}
+}
+
+func (s *Store) FindWebhookDeliveries(ctx context.Context, id string) ([]core.WebhookDelivery, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	deliveries := s.deliveries[id]
+	ret := make([]core.WebhookDelivery, 0, len(deliveries))
+	for i := len(deliveries) - 1; i >= 0; i-- {
+		ret = append(ret, deliveries[i])
+	}
+
+	return ret, nil
+}
@@ -0,0 +1,52 @@
+package storage
+
+import "context"
+
+// MetaEntry is a single metadata key/value attached to a target, as persisted by the store
+type MetaEntry struct {
+	ID         int64
+	Timestamp  string
+	TargetType string
+	TargetID   string
+	Key        string
+	Value      string
+}
+
+// MetaTarget is a target of metadata
+type MetaTarget struct {
+	Type string
+	ID   string
+}
+
+// MetaTargets returns the distinct targets of the entries, in their order
+func MetaTargets(entries []MetaEntry) []MetaTarget {
+	seen := map[MetaTarget]struct{}{}
+	targets := make([]MetaTarget, 0)
+	for _, e := range entries {
+		target := MetaTarget{
+			Type: e.TargetType,
+			ID:   e.TargetID,
+		}
+		if _, ok := seen[target]; ok {
+			continue
+		}
+		seen[target] = struct{}{}
+		targets = append(targets, target)
+	}
+	return targets
+}
+
+type metaVersionKey struct{}
+
+// WithMetaVersion sets the version the metadata of the targets of the entries saved with the context must be at,
+// see Store.GetMetaVersion. The stores compare and increment the versions atomically, so that concurrent updates
+// of the metadata of a target from the same version can't both succeed.
+func WithMetaVersion(ctx context.Context, version int64) context.Context {
+	return context.WithValue(ctx, metaVersionKey{}, version)
+}
+
+// MetaVersionFromContext returns the version set with WithMetaVersion, false if none is set
+func MetaVersionFromContext(ctx context.Context) (int64, bool) {
+	version, ok := ctx.Value(metaVersionKey{}).(int64)
+	return version, ok
+}
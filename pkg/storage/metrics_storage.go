@@ -0,0 +1,276 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/numary/ledger/pkg/core"
+	"github.com/numary/ledger/pkg/ledger/query"
+	"github.com/numary/ledger/pkg/metrics"
+)
+
+// metricsStorage records the duration of the queries made to the underlying store
+type metricsStorage struct {
+	Store
+	metrics *metrics.Metrics
+}
+
+func (s *metricsStorage) observe(query string) func() {
+	start := time.Now()
+	return func() {
+		s.metrics.ObserveStorageQuery(s.Name(), query, start)
+	}
+}
+
+func (s *metricsStorage) LastTransaction(ctx context.Context) (*core.Transaction, error) {
+	defer s.observe("last_transaction")()
+	return s.Store.LastTransaction(ctx)
+}
+
+func (s *metricsStorage) Head(ctx context.Context) (int64, string, error) {
+	defer s.observe("head")()
+	return s.Store.Head(ctx)
+}
+
+func (s *metricsStorage) LastMetaID(ctx context.Context) (int64, error) {
+	defer s.observe("last_meta_id")()
+	return s.Store.LastMetaID(ctx)
+}
+
+func (s *metricsStorage) SaveTransactions(ctx context.Context, txs []core.Transaction) error {
+	defer s.observe("save_transactions")()
+	return s.Store.SaveTransactions(ctx, txs)
+}
+
+func (s *metricsStorage) SaveTransactionsWithKey(ctx context.Context, key string, txs []core.Transaction) error {
+	defer s.observe("save_transactions_with_key")()
+	return s.Store.SaveTransactionsWithKey(ctx, key, txs)
+}
+
+func (s *metricsStorage) SaveTransactionsWithMeta(ctx context.Context, txs []core.Transaction, entries []MetaEntry) error {
+	defer s.observe("save_transactions_with_meta")()
+	return s.Store.SaveTransactionsWithMeta(ctx, txs, entries)
+}
+
+func (s *metricsStorage) GetIdempotencyKey(ctx context.Context, key string) (*IdempotencyKey, error) {
+	defer s.observe("get_idempotency_key")()
+	return s.Store.GetIdempotencyKey(ctx, key)
+}
+
+func (s *metricsStorage) CountTransactions(ctx context.Context) (int64, error) {
+	defer s.observe("count_transactions")()
+	return s.Store.CountTransactions(ctx)
+}
+
+func (s *metricsStorage) FindTransactions(ctx context.Context, q query.Query) (query.Cursor, error) {
+	defer s.observe("find_transactions")()
+	return s.Store.FindTransactions(ctx, q)
+}
+
+func (s *metricsStorage) GetTransaction(ctx context.Context, txid string) (core.Transaction, error) {
+	defer s.observe("get_transaction")()
+	return s.Store.GetTransaction(ctx, txid)
+}
+
+func (s *metricsStorage) GetTransactionByHash(ctx context.Context, hash string) (core.Transaction, error) {
+	defer s.observe("get_transaction_by_hash")()
+	return s.Store.GetTransactionByHash(ctx, hash)
+}
+
+func (s *metricsStorage) FindUsedReferences(ctx context.Context, keys []ReferenceKey) ([]ReferenceKey, error) {
+	defer s.observe("find_used_references")()
+	return s.Store.FindUsedReferences(ctx, keys)
+}
+
+func (s *metricsStorage) AggregateBalances(ctx context.Context, address string) (map[string]int64, error) {
+	defer s.observe("aggregate_balances")()
+	return s.Store.AggregateBalances(ctx, address)
+}
+
+func (s *metricsStorage) AggregateBalance(ctx context.Context, address, asset string) (int64, error) {
+	defer s.observe("aggregate_balance")()
+	return s.Store.AggregateBalance(ctx, address, asset)
+}
+
+func (s *metricsStorage) AggregateVolumes(ctx context.Context, address string) (map[string]map[string]int64, error) {
+	defer s.observe("aggregate_volumes")()
+	return s.Store.AggregateVolumes(ctx, address)
+}
+
+func (s *metricsStorage) AggregateVolumesAt(ctx context.Context, address string, txid int64) (map[string]map[string]int64, error) {
+	defer s.observe("aggregate_volumes_at")()
+	return s.Store.AggregateVolumesAt(ctx, address, txid)
+}
+
+func (s *metricsStorage) AggregateFlow(ctx context.Context, source, destination, asset string, from, to time.Time) (int64, error) {
+	defer s.observe("aggregate_flow")()
+	return s.Store.AggregateFlow(ctx, source, destination, asset, from, to)
+}
+
+func (s *metricsStorage) AccountExists(ctx context.Context, address string) (bool, error) {
+	defer s.observe("account_exists")()
+	return s.Store.AccountExists(ctx, address)
+}
+
+func (s *metricsStorage) SumBalances(ctx context.Context, q query.Query) (map[string]int64, error) {
+	defer s.observe("sum_balances")()
+	return s.Store.SumBalances(ctx, q)
+}
+
+func (s *metricsStorage) CountAccounts(ctx context.Context) (int64, error) {
+	defer s.observe("count_accounts")()
+	return s.Store.CountAccounts(ctx)
+}
+
+func (s *metricsStorage) AssetVolumes(ctx context.Context) (map[string]int64, error) {
+	defer s.observe("asset_volumes")()
+	return s.Store.AssetVolumes(ctx)
+}
+
+func (s *metricsStorage) FindAssets(ctx context.Context) ([]string, error) {
+	defer s.observe("find_assets")()
+	return s.Store.FindAssets(ctx)
+}
+
+func (s *metricsStorage) FindAssetsOf(ctx context.Context, codes []string) ([]string, error) {
+	defer s.observe("find_assets_of")()
+	return s.Store.FindAssetsOf(ctx, codes)
+}
+
+func (s *metricsStorage) FindAccounts(ctx context.Context, q query.Query) (query.Cursor, error) {
+	defer s.observe("find_accounts")()
+	return s.Store.FindAccounts(ctx, q)
+}
+
+func (s *metricsStorage) FindBalances(ctx context.Context, q query.Query) (query.Cursor, error) {
+	defer s.observe("find_balances")()
+	return s.Store.FindBalances(ctx, q)
+}
+
+func (s *metricsStorage) GetAccounts(ctx context.Context, addresses []string) ([]core.Account, error) {
+	defer s.observe("get_accounts")()
+	return s.Store.GetAccounts(ctx, addresses)
+}
+
+func (s *metricsStorage) SaveMeta(ctx context.Context, id int64, timestamp, targetType, targetID, key, value string) error {
+	defer s.observe("save_meta")()
+	return s.Store.SaveMeta(ctx, id, timestamp, targetType, targetID, key, value)
+}
+
+func (s *metricsStorage) SaveMetaBatch(ctx context.Context, entries []MetaEntry) error {
+	defer s.observe("save_meta_batch")()
+	return s.Store.SaveMetaBatch(ctx, entries)
+}
+
+func (s *metricsStorage) GetMeta(ctx context.Context, targetType, targetID string) (core.Metadata, error) {
+	defer s.observe("get_meta")()
+	return s.Store.GetMeta(ctx, targetType, targetID)
+}
+
+func (s *metricsStorage) FindMetaTargets(ctx context.Context, targetType string) ([]string, error) {
+	defer s.observe("find_meta_targets")()
+	return s.Store.FindMetaTargets(ctx, targetType)
+}
+
+func (s *metricsStorage) DeleteMeta(ctx context.Context, targetType, targetID string, keys []string) error {
+	defer s.observe("delete_meta")()
+	return s.Store.DeleteMeta(ctx, targetType, targetID, keys)
+}
+
+func (s *metricsStorage) RecomputeVolumes(ctx context.Context) error {
+	defer s.observe("recompute_volumes")()
+	return s.Store.RecomputeVolumes(ctx)
+}
+
+func (s *metricsStorage) GetMetaVersion(ctx context.Context, targetType, targetID string) (int64, error) {
+	defer s.observe("get_meta_version")()
+	return s.Store.GetMetaVersion(ctx, targetType, targetID)
+}
+
+func (s *metricsStorage) CountMeta(ctx context.Context) (int64, error) {
+	defer s.observe("count_meta")()
+	return s.Store.CountMeta(ctx)
+}
+
+func (s *metricsStorage) SaveScript(ctx context.Context, script core.StoredScript) error {
+	defer s.observe("save_script")()
+	return s.Store.SaveScript(ctx, script)
+}
+
+func (s *metricsStorage) GetScript(ctx context.Context, name string, version int64) (*core.StoredScript, error) {
+	defer s.observe("get_script")()
+	return s.Store.GetScript(ctx, name, version)
+}
+
+func (s *metricsStorage) FindScripts(ctx context.Context) ([]core.StoredScript, error) {
+	defer s.observe("find_scripts")()
+	return s.Store.FindScripts(ctx)
+}
+
+func (s *metricsStorage) SaveWebhook(ctx context.Context, webhook core.Webhook) error {
+	defer s.observe("save_webhook")()
+	return s.Store.SaveWebhook(ctx, webhook)
+}
+
+func (s *metricsStorage) GetWebhook(ctx context.Context, id string) (*core.Webhook, error) {
+	defer s.observe("get_webhook")()
+	return s.Store.GetWebhook(ctx, id)
+}
+
+func (s *metricsStorage) FindWebhooks(ctx context.Context) ([]core.Webhook, error) {
+	defer s.observe("find_webhooks")()
+	return s.Store.FindWebhooks(ctx)
+}
+
+func (s *metricsStorage) UpdateWebhookCursor(ctx context.Context, id string, cursor int64) error {
+	defer s.observe("update_webhook_cursor")()
+	return s.Store.UpdateWebhookCursor(ctx, id, cursor)
+}
+
+func (s *metricsStorage) SaveWebhookDelivery(ctx context.Context, delivery core.WebhookDelivery) error {
+	defer s.observe("save_webhook_delivery")()
+	return s.Store.SaveWebhookDelivery(ctx, delivery)
+}
+
+func (s *metricsStorage) FindWebhookDeliveries(ctx context.Context, id string) ([]core.WebhookDelivery, error) {
+	defer s.observe("find_webhook_deliveries")()
+	return s.Store.FindWebhookDeliveries(ctx, id)
+}
+
+func (s *metricsStorage) Drop(ctx context.Context) error {
+	defer s.observe("drop")()
+	return s.Store.Drop(ctx)
+}
+
+func NewMetricsStorage(underlying Store, m *metrics.Metrics) *metricsStorage {
+	return &metricsStorage{
+		Store:   underlying,
+		metrics: m,
+	}
+}
+
+type MetricsStorageFactory struct {
+	underlying Factory
+	metrics    *metrics.Metrics
+}
+
+func (f *MetricsStorageFactory) GetStore(name string) (Store, error) {
+	store, err := f.underlying.GetStore(name)
+	if err != nil {
+		return nil, err
+	}
+	return NewMetricsStorage(store, f.metrics), nil
+}
+
+func (f *MetricsStorageFactory) Close(ctx context.Context) error {
+	return f.underlying.Close(ctx)
+}
+
+func NewMetricsStorageFactory(underlying Factory, m *metrics.Metrics) *MetricsStorageFactory {
+	return &MetricsStorageFactory{
+		underlying: underlying,
+		metrics:    m,
+	}
+}
+
+var _ Factory = &MetricsStorageFactory{}
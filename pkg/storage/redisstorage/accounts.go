@@ -0,0 +1,317 @@
+package redisstorage
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/numary/ledger/pkg/core"
+	"github.com/numary/ledger/pkg/ledger/query"
+	"github.com/numary/ledger/pkg/storage"
+)
+
+// findAddresses returns up to limit addresses of accounts matching the query, ordered as FindAccounts
+func (s *Store) findAddresses(ctx context.Context, q query.Query, limit int) ([]string, error) {
+	results := make([]string, 0)
+
+	rng := &redis.ZRangeBy{
+		Min:   "-",
+		Max:   "+",
+		Count: scanPageSize,
+	}
+	// The accounts are sorted in descending order by default, and fetched in the reverse order for a previous page
+	asc := q.Sorting().Asc
+	after, before := &rng.Max, &rng.Min
+	if asc {
+		after, before = before, after
+	}
+	if q.After != "" {
+		*after = "(" + q.After
+	}
+	if q.Before != "" {
+		*before = "(" + q.Before
+	}
+
+	for len(results) < limit {
+		var (
+			addresses []string
+			err       error
+		)
+		if asc == (q.Before == "") {
+			addresses, err = s.client.ZRangeByLex(ctx, s.key("accounts"), rng).Result()
+		} else {
+			addresses, err = s.client.ZRevRangeByLex(ctx, s.key("accounts"), rng).Result()
+		}
+		if err != nil {
+			return results, err
+		}
+
+		for _, address := range addresses {
+			if len(results) == limit {
+				break
+			}
+			ok, err := s.matchAccount(ctx, q, address)
+			if err != nil {
+				return results, err
+			}
+			if !ok {
+				continue
+			}
+			results = append(results, address)
+		}
+
+		if len(addresses) < scanPageSize {
+			break
+		}
+		rng.Offset += scanPageSize
+	}
+
+	return results, nil
+}
+
+// matchAccount reports whether an account satisfies the query, reading its metadata only if the query filters them
+func (s *Store) matchAccount(ctx context.Context, q query.Query, address string) (bool, error) {
+	if !storage.MatchAccount(q, address) {
+		return false, nil
+	}
+	if !q.HasParam("account_metadata") {
+		return true, nil
+	}
+	meta, err := s.GetMeta(ctx, "account", address)
+	if err != nil {
+		return false, err
+	}
+	return storage.MatchMetadata(q.Params["account_metadata"].(map[string]string), meta), nil
+}
+
+// findByBalance returns up to limit addresses of the accounts matching the query sorted by balance,
+// with their balances. The balances are computed by replaying the transactions.
+func (s *Store) findByBalance(ctx context.Context, q query.Query, limit int) ([]string, map[string]int64, error) {
+	sorting := q.Sorting()
+
+	txid := int64(math.MaxInt64)
+	if sorting.TxID != nil {
+		txid = *sorting.TxID
+	}
+	last, err := s.lastPosition(ctx, txid)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	balances := map[string]int64{}
+	for start := int64(0); start <= last; start += scanPageSize {
+		end := start + scanPageSize - 1
+		if end > last {
+			end = last
+		}
+
+		txs, err := s.getTransactions(ctx, start, end)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		for _, tx := range txs {
+			storage.AddPostings(balances, sorting.Asset, tx)
+		}
+
+		if len(txs) < int(end-start+1) {
+			break
+		}
+	}
+	if q.HasParam("account_metadata") {
+		for address := range balances {
+			ok, err := s.matchAccount(ctx, q, address)
+			if err != nil {
+				return nil, nil, err
+			}
+			if !ok {
+				delete(balances, address)
+			}
+		}
+	}
+
+	addresses, err := storage.PageByBalance(q, balances, limit)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return addresses, balances, nil
+}
+
+func (s *Store) FindAccounts(ctx context.Context, q query.Query) (query.Cursor, error) {
+	limit := int(math.Max(-1, float64(q.Limit)))
+
+	c := query.Cursor{}
+	results := make([]core.Account, 0)
+
+	// We fetch an additional account to know if we have more documents
+	sorting := q.Sorting()
+	var (
+		addresses []string
+		balances  map[string]int64
+		err       error
+	)
+	if sorting.By == query.SortByBalance {
+		addresses, balances, err = s.findByBalance(ctx, q, limit+1)
+	} else {
+		addresses, err = s.findAddresses(ctx, q, limit+1)
+	}
+	if err != nil {
+		return c, err
+	}
+
+	for _, address := range addresses {
+		account := core.Account{
+			Address:  address,
+			Contract: "default",
+		}
+		if balances != nil {
+			account.Balances = map[string]int64{
+				sorting.Asset: balances[address],
+			}
+		}
+
+		meta, err := s.GetMeta(ctx, "account", account.Address)
+		if err != nil {
+			return c, err
+		}
+		account.Metadata = meta
+
+		results = append(results, account)
+	}
+
+	c.PageSize = limit
+
+	c.HasMore = len(results) > limit
+	if c.HasMore {
+		results = results[:limit]
+	}
+	if q.Before != "" {
+		storage.ReverseAccounts(results)
+	}
+	c.Data = results
+
+	total, _ := s.CountAccounts(ctx)
+	c.Total = total
+
+	return c, nil
+}
+
+// FindBalances returns a page of accounts matching the query with their balances.
+// The volumes of the whole page are read in a single pipeline.
+func (s *Store) FindBalances(ctx context.Context, q query.Query) (query.Cursor, error) {
+	limit := int(math.Max(-1, float64(q.Limit)))
+
+	c := query.Cursor{}
+	results := make([]core.Account, 0)
+
+	// We fetch an additional account to know if we have more documents
+	addresses, err := s.findAddresses(ctx, q, limit+1)
+	if err != nil {
+		return c, err
+	}
+
+	c.PageSize = limit
+
+	c.HasMore = len(addresses) > limit
+	if c.HasMore {
+		addresses = addresses[:limit]
+	}
+
+	if len(addresses) > 0 {
+		cmds := make([]*redis.StringStringMapCmd, len(addresses))
+		_, err = s.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			for i, address := range addresses {
+				cmds[i] = pipe.HGetAll(ctx, s.key("volumes", address))
+			}
+			return nil
+		})
+		if err != nil {
+			return c, err
+		}
+
+		for i, address := range addresses {
+			volumes, err := parseVolumes(cmds[i].Val())
+			if err != nil {
+				return c, err
+			}
+
+			balances := map[string]int64{}
+			for asset := range volumes {
+				balances[asset] = volumes[asset]["input"] - volumes[asset]["output"]
+			}
+
+			results = append(results, core.Account{
+				Address:  address,
+				Contract: "default",
+				Balances: balances,
+			})
+		}
+	}
+
+	if q.Before != "" {
+		storage.ReverseAccounts(results)
+	}
+	c.Data = results
+
+	total, _ := s.CountAccounts(ctx)
+	c.Total = total
+
+	return c, nil
+}
+
+// GetAccounts reads the volumes and metadata of all the accounts in a single pipeline
+func (s *Store) GetAccounts(ctx context.Context, addresses []string) ([]core.Account, error) {
+	accounts := make([]core.Account, 0, len(addresses))
+	if len(addresses) == 0 {
+		return accounts, nil
+	}
+
+	volumesCmds := make([]*redis.StringStringMapCmd, len(addresses))
+	metaCmds := make([]*redis.StringStringMapCmd, len(addresses))
+	_, err := s.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, address := range addresses {
+			volumesCmds[i] = pipe.HGetAll(ctx, s.key("volumes", address))
+			metaCmds[i] = pipe.HGetAll(ctx, s.key("metadata", "account", address))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for i, address := range addresses {
+		volumes, err := parseVolumes(volumesCmds[i].Val())
+		if err != nil {
+			return nil, err
+		}
+
+		account := core.Account{
+			Address:  address,
+			Contract: "default",
+			Balances: map[string]int64{},
+		}
+		for asset := range volumes {
+			account.Balances[asset] = volumes[asset]["input"] - volumes[asset]["output"]
+		}
+
+		// The accounts which never appeared keep nil metadata
+		values := metaCmds[i].Val()
+		if len(volumes) > 0 || len(values) > 0 {
+			account.Metadata = core.Metadata{}
+		}
+		for k, v := range values {
+			var value json.RawMessage
+			err = json.Unmarshal([]byte(v), &value)
+			if err != nil {
+				return nil, err
+			}
+			account.Metadata[k] = value
+		}
+
+		accounts = append(accounts, account)
+	}
+
+	return accounts, nil
+}
@@ -0,0 +1,327 @@
+package redisstorage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/numary/ledger/pkg/ledger/query"
+	"github.com/numary/ledger/pkg/storage"
+)
+
+// RecomputeVolumes replays the postings of the log and replaces the volumes hashes of the accounts
+// and of the assets in a single redis transaction, which fails with a conflict if transactions are saved meanwhile
+func (s *Store) RecomputeVolumes(ctx context.Context) error {
+	txsKey := s.key("transactions")
+
+	err := s.client.Watch(ctx, func(tx *redis.Tx) error {
+		count, err := tx.LLen(ctx, txsKey).Result()
+		if err != nil {
+			return err
+		}
+
+		addresses, err := tx.ZRange(ctx, s.key("accounts"), 0, -1).Result()
+		if err != nil {
+			return err
+		}
+
+		volumes := map[string]map[string]interface{}{}
+		assetVolumes := map[string]interface{}{}
+		add := func(address, field string, amount int64) {
+			if _, ok := volumes[address]; !ok {
+				volumes[address] = map[string]interface{}{}
+			}
+			previous, _ := volumes[address][field].(int64)
+			volumes[address][field] = previous + amount
+		}
+
+		for start := int64(0); start < count; start += scanPageSize {
+			txs, err := s.getTransactions(ctx, start, start+scanPageSize-1)
+			if err != nil {
+				return err
+			}
+			for _, t := range txs {
+				for _, p := range t.Postings {
+					add(p.Source, "output:"+p.Asset, p.Amount)
+					add(p.Destination, "input:"+p.Asset, p.Amount)
+					previous, _ := assetVolumes[p.Asset].(int64)
+					assetVolumes[p.Asset] = previous + p.Amount
+				}
+			}
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			for _, address := range addresses {
+				pipe.Del(ctx, s.key("volumes", address))
+			}
+			pipe.Del(ctx, s.key("asset_volumes"))
+
+			for address, fields := range volumes {
+				pipe.ZAdd(ctx, s.key("accounts"), &redis.Z{Member: address})
+				pipe.HSet(ctx, s.key("volumes", address), fields)
+			}
+			if len(assetVolumes) > 0 {
+				pipe.HSet(ctx, s.key("asset_volumes"), assetVolumes)
+			}
+			return nil
+		})
+		return err
+	}, txsKey)
+	if errors.Is(err, redis.TxFailedErr) {
+		return fmt.Errorf("%w: %s", storage.ErrConflict, err)
+	}
+
+	return err
+}
+
+func (s *Store) CountTransactions(ctx context.Context) (int64, error) {
+	return s.client.LLen(ctx, s.key("transactions")).Result()
+}
+
+func (s *Store) CountAccounts(ctx context.Context) (int64, error) {
+	return s.client.ZCard(ctx, s.key("accounts")).Result()
+}
+
+// AssetVolumes reads the volumes by asset maintained along with the transactions
+// FindAssets reads the assets from the hash of the volumes by asset
+func (s *Store) FindAssets(ctx context.Context) ([]string, error) {
+	assets, err := s.client.HKeys(ctx, s.key("asset_volumes")).Result()
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(assets)
+
+	return assets, nil
+}
+
+// FindAssetsOf filters the assets of the hash of the volumes by asset, which has a field per asset
+func (s *Store) FindAssetsOf(ctx context.Context, codes []string) ([]string, error) {
+	assets, err := s.FindAssets(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return storage.AssetsOf(assets, codes), nil
+}
+
+func (s *Store) AssetVolumes(ctx context.Context) (map[string]int64, error) {
+	values, err := s.client.HGetAll(ctx, s.key("asset_volumes")).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	volumes := make(map[string]int64, len(values))
+	for asset, value := range values {
+		volume, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		volumes[asset] = volume
+	}
+
+	return volumes, nil
+}
+
+func (s *Store) CountMeta(ctx context.Context) (int64, error) {
+	count, err := s.client.Get(ctx, s.key("metadata_count")).Int64()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	return count, err
+}
+
+func (s *Store) AggregateBalances(ctx context.Context, address string) (map[string]int64, error) {
+	balances := map[string]int64{}
+
+	volumes, err := s.AggregateVolumes(ctx, address)
+
+	if err != nil {
+		return balances, err
+	}
+
+	for asset := range volumes {
+		balances[asset] = volumes[asset]["input"] - volumes[asset]["output"]
+	}
+
+	return balances, nil
+}
+
+func (s *Store) AggregateBalance(ctx context.Context, address, asset string) (int64, error) {
+	values, err := s.client.HMGet(ctx, s.key("volumes", address), "input:"+asset, "output:"+asset).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	volumes, err := parseVolumes(map[string]string{
+		"input:" + asset:  toString(values[0]),
+		"output:" + asset: toString(values[1]),
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return volumes[asset]["input"] - volumes[asset]["output"], nil
+}
+
+func (s *Store) AccountExists(ctx context.Context, address string) (bool, error) {
+	err := s.client.ZScore(ctx, s.key("accounts"), address).Err()
+	if err == redis.Nil {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *Store) AggregateVolumes(ctx context.Context, address string) (map[string]map[string]int64, error) {
+	values, err := s.client.HGetAll(ctx, s.key("volumes", address)).Result()
+	if err != nil {
+		return map[string]map[string]int64{}, err
+	}
+
+	return parseVolumes(values)
+}
+
+// AggregateVolumesAt replays the postings of the transactions up to txid,
+// as only the current volumes of the accounts are maintained
+func (s *Store) AggregateVolumesAt(ctx context.Context, address string, txid int64) (map[string]map[string]int64, error) {
+	volumes := map[string]map[string]int64{}
+	volume := func(asset string) map[string]int64 {
+		if _, ok := volumes[asset]; !ok {
+			volumes[asset] = map[string]int64{
+				"input":  0,
+				"output": 0,
+			}
+		}
+		return volumes[asset]
+	}
+
+	last, err := s.lastPosition(ctx, txid)
+	if err != nil {
+		return volumes, err
+	}
+	for start := int64(0); start <= last; start += scanPageSize {
+		end := start + scanPageSize - 1
+		if end > last {
+			end = last
+		}
+
+		txs, err := s.getTransactions(ctx, start, end)
+		if err != nil {
+			return volumes, err
+		}
+
+		for _, tx := range txs {
+			for _, p := range tx.Postings {
+				if p.Source == address {
+					volume(p.Asset)["output"] += p.Amount
+				}
+				if p.Destination == address {
+					volume(p.Asset)["input"] += p.Amount
+				}
+			}
+		}
+
+		if len(txs) < int(end-start+1) {
+			break
+		}
+	}
+
+	return volumes, nil
+}
+
+// AggregateFlow replays the postings of the log, the transactions not being indexed by account
+func (s *Store) AggregateFlow(ctx context.Context, source, destination, asset string, from, to time.Time) (int64, error) {
+	var flow int64
+	for start := int64(0); ; start += scanPageSize {
+		txs, err := s.getTransactions(ctx, start, start+scanPageSize-1)
+		if err != nil {
+			return 0, err
+		}
+
+		for _, tx := range txs {
+			timestamp, err := time.Parse(time.RFC3339, tx.Timestamp)
+			if err != nil || (!from.IsZero() && timestamp.Before(from)) || (!to.IsZero() && !timestamp.Before(to)) {
+				continue
+			}
+			for _, p := range tx.Postings {
+				if p.Source == source && p.Destination == destination && p.Asset == asset {
+					flow += p.Amount
+				}
+			}
+		}
+
+		if len(txs) < scanPageSize {
+			return flow, nil
+		}
+	}
+}
+
+// SumBalances sums the balances of the accounts matching the query, grouped by asset
+func (s *Store) SumBalances(ctx context.Context, q query.Query) (map[string]int64, error) {
+	balances := map[string]int64{}
+
+	rng := &redis.ZRangeBy{
+		Min: "-",
+		Max: "+",
+	}
+	if q.HasParam("address") {
+		prefix := q.Params["address"].(string)
+		rng.Min = "[" + prefix
+		rng.Max = "[" + prefix + "\xff"
+	}
+
+	addresses, err := s.client.ZRangeByLex(ctx, s.key("accounts"), rng).Result()
+	if err != nil {
+		return balances, err
+	}
+
+	for _, address := range addresses {
+		volumes, err := s.AggregateVolumes(ctx, address)
+		if err != nil {
+			return balances, err
+		}
+
+		for asset := range volumes {
+			balances[asset] += volumes[asset]["input"] - volumes[asset]["output"]
+		}
+	}
+
+	return balances, nil
+}
+
+// parseVolumes converts the fields of a volumes hash, like "input:USD", to volumes by asset.
+// Empty fields are ignored, both input and output are set for the other assets.
+func parseVolumes(values map[string]string) (map[string]map[string]int64, error) {
+	volumes := map[string]map[string]int64{}
+
+	for field, value := range values {
+		if value == "" {
+			continue
+		}
+
+		amount, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return volumes, err
+		}
+
+		parts := strings.SplitN(field, ":", 2)
+		if _, ok := volumes[parts[1]]; !ok {
+			volumes[parts[1]] = map[string]int64{
+				"input":  0,
+				"output": 0,
+			}
+		}
+		volumes[parts[1]][parts[0]] += amount
+	}
+
+	return volumes, nil
+}
+
+func toString(v interface{}) string {
+	s, _ := v.(string)
+	return s
+}
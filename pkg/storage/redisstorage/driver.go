@@ -0,0 +1,45 @@
+package redisstorage
+
+import (
+	"context"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/numary/ledger/pkg/storage"
+)
+
+// driver is a driver which connects on a redis server (or cluster) and keeps the connection open until closed.
+// All the stores it provides share the same client, their keys being prefixed with the ledger name.
+type driver struct {
+	name    string
+	options *redis.UniversalOptions
+	client  redis.UniversalClient
+}
+
+func (d *driver) Name() string {
+	return d.name
+}
+
+func (d *driver) Initialize(ctx context.Context) error {
+	d.client = redis.NewUniversalClient(d.options)
+	return d.client.Ping(ctx).Err()
+}
+
+func (d *driver) NewStore(name string) (storage.Store, error) {
+	return NewStore(name, d.client, func(ctx context.Context) error {
+		return nil
+	})
+}
+
+func (d *driver) Close(ctx context.Context) error {
+	if d.client == nil {
+		return nil
+	}
+	return d.client.Close()
+}
+
+func NewDriver(name string, options *redis.UniversalOptions) *driver {
+	return &driver{
+		name:    name,
+		options: options,
+	}
+}
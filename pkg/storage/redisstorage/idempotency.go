@@ -0,0 +1,40 @@
+package redisstorage
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/numary/ledger/pkg/core"
+	"github.com/numary/ledger/pkg/storage"
+)
+
+func (s *Store) GetIdempotencyKey(ctx context.Context, key string) (*storage.IdempotencyKey, error) {
+	values, err := s.client.HGetAll(ctx, s.key("idempotency_keys", key)).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(values) == 0 {
+		return nil, nil
+	}
+
+	ik := storage.IdempotencyKey{
+		Key:       key,
+		Timestamp: values["timestamp"],
+	}
+	ik.FirstTxID, err = strconv.ParseInt(values["first_txid"], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	ik.LastTxID, err = strconv.ParseInt(values["last_txid"], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ik, nil
+}
+
+// SaveTransactionsWithKey saves the transactions and records the idempotency key in the same redis transaction,
+// replacing any previous (expired) record of the key.
+func (s *Store) SaveTransactionsWithKey(ctx context.Context, key string, ts []core.Transaction) error {
+	return s.saveTransactions(ctx, key, ts, nil)
+}
@@ -0,0 +1,162 @@
+package redisstorage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/numary/ledger/pkg/core"
+	"github.com/numary/ledger/pkg/storage"
+)
+
+func (s *Store) LastMetaID(ctx context.Context) (int64, error) {
+	return s.lastMetaID(ctx, s.client)
+}
+
+// lastMetaID returns the highest metadata id, or -1 if there is no metadata
+func (s *Store) lastMetaID(ctx context.Context, client redis.Cmdable) (int64, error) {
+	id, err := client.Get(ctx, s.key("metadata_last_id")).Int64()
+	if err == redis.Nil {
+		return -1, nil
+	}
+	return id, err
+}
+
+// saveMeta queues the commands saving a metadata entry. The metadata of each target are kept in a hash,
+// only the last value of a key is kept.
+func (s *Store) saveMeta(ctx context.Context, pipe redis.Pipeliner, e storage.MetaEntry) {
+	pipe.HSet(ctx, s.key("metadata", e.TargetType, e.TargetID), e.Key, e.Value)
+	pipe.Set(ctx, s.key("metadata_last_id"), e.ID, 0)
+	pipe.Incr(ctx, s.key("metadata_count"))
+}
+
+func (s *Store) GetMeta(ctx context.Context, ty string, id string) (core.Metadata, error) {
+	values, err := s.client.HGetAll(ctx, s.key("metadata", ty, id)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	meta := core.Metadata{}
+
+	for k, v := range values {
+		var value json.RawMessage
+
+		err = json.Unmarshal([]byte(v), &value)
+		if err != nil {
+			return nil, err
+		}
+
+		meta[k] = value
+	}
+
+	return meta, nil
+}
+
+// FindMetaTargets scans the keys of the metadata hashes of the type, which are deleted along with their last field
+func (s *Store) FindMetaTargets(ctx context.Context, ty string) ([]string, error) {
+	prefix := s.key("metadata", ty, "")
+	ids := []string{}
+
+	iter := s.client.Scan(ctx, 0, globEscaper.Replace(prefix)+"*", scanPageSize).Iterator()
+	for iter.Next(ctx) {
+		ids = append(ids, strings.TrimPrefix(iter.Val(), prefix))
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+	sort.Strings(ids)
+
+	return ids, nil
+}
+
+func (s *Store) SaveMeta(ctx context.Context, id int64, timestamp, targetType, targetID, key, value string) error {
+	return s.SaveMetaBatch(ctx, []storage.MetaEntry{
+		{
+			ID:         id,
+			Timestamp:  timestamp,
+			TargetType: targetType,
+			TargetID:   targetID,
+			Key:        key,
+			Value:      value,
+		},
+	})
+}
+
+func (s *Store) SaveMetaBatch(ctx context.Context, entries []storage.MetaEntry) error {
+	save := func(pipe redis.Pipeliner) error {
+		for _, e := range entries {
+			s.saveMeta(ctx, pipe, e)
+		}
+		s.updateMetaVersions(ctx, pipe, entries)
+		return nil
+	}
+
+	version, ok := storage.MetaVersionFromContext(ctx)
+	if !ok {
+		_, err := s.client.TxPipelined(ctx, save)
+		return err
+	}
+
+	// The versions are watched, the transaction fails if they are updated between their check and the increment
+	versionsKey := s.key("metadata_versions")
+	err := s.client.Watch(ctx, func(tx *redis.Tx) error {
+		for _, target := range storage.MetaTargets(entries) {
+			current, err := tx.HGet(ctx, versionsKey, metaVersionField(target.Type, target.ID)).Int64()
+			if err != nil && err != redis.Nil {
+				return err
+			}
+			if current != version {
+				return storage.ErrMetaVersionMismatch
+			}
+		}
+		_, err := tx.TxPipelined(ctx, save)
+		return err
+	}, versionsKey)
+	if errors.Is(err, redis.TxFailedErr) {
+		return fmt.Errorf("%w: %s", storage.ErrConflict, err)
+	}
+	return err
+}
+
+func (s *Store) DeleteMeta(ctx context.Context, targetType, targetID string, keys []string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	var deleted *redis.IntCmd
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		deleted = pipe.HDel(ctx, s.key("metadata", targetType, targetID), keys...)
+		pipe.HIncrBy(ctx, s.key("metadata_versions"), metaVersionField(targetType, targetID), 1)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return s.client.DecrBy(ctx, s.key("metadata_count"), deleted.Val()).Err()
+}
+
+func (s *Store) GetMetaVersion(ctx context.Context, targetType, targetID string) (int64, error) {
+	version, err := s.client.HGet(ctx, s.key("metadata_versions"), metaVersionField(targetType, targetID)).Int64()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	return version, err
+}
+
+// metaVersionField is the field of the version of a target in the metadata_versions hash,
+// the target types having no colon
+func metaVersionField(targetType, targetID string) string {
+	return targetType + ":" + targetID
+}
+
+// updateMetaVersions queues the commands incrementing the versions of the targets of the entries
+func (s *Store) updateMetaVersions(ctx context.Context, pipe redis.Pipeliner, entries []storage.MetaEntry) {
+	for _, target := range storage.MetaTargets(entries) {
+		pipe.HIncrBy(ctx, s.key("metadata_versions"), metaVersionField(target.Type, target.ID), 1)
+	}
+}
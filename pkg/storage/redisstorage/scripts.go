@@ -0,0 +1,95 @@
+package redisstorage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/numary/ledger/pkg/core"
+	"github.com/numary/ledger/pkg/storage"
+)
+
+// The versions of a script are stored in a hash by version, and the names of the scripts in a set
+
+// SaveScript adds the version to the hash of the script if it isn't there yet, along with the name to the set of the scripts
+func (s *Store) SaveScript(ctx context.Context, script core.StoredScript) error {
+	data, err := json.Marshal(script)
+	if err != nil {
+		return err
+	}
+
+	var saved *redis.BoolCmd
+	_, err = s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		saved = pipe.HSetNX(ctx, s.key("scripts", script.Name), strconv.FormatInt(script.Version, 10), data)
+		pipe.SAdd(ctx, s.key("script_names"), script.Name)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if !saved.Val() {
+		return fmt.Errorf("%w: version %d of script %s already exists", storage.ErrConflict, script.Version, script.Name)
+	}
+
+	return nil
+}
+
+func (s *Store) GetScript(ctx context.Context, name string, version int64) (*core.StoredScript, error) {
+	if version != 0 {
+		data, err := s.client.HGet(ctx, s.key("scripts", name), strconv.FormatInt(version, 10)).Bytes()
+		if err == redis.Nil {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var script core.StoredScript
+		if err := json.Unmarshal(data, &script); err != nil {
+			return nil, err
+		}
+		return &script, nil
+	}
+
+	values, err := s.client.HGetAll(ctx, s.key("scripts", name)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	var last *core.StoredScript
+	for _, data := range values {
+		var script core.StoredScript
+		if err := json.Unmarshal([]byte(data), &script); err != nil {
+			return nil, err
+		}
+		if last == nil || script.Version > last.Version {
+			last = &script
+		}
+	}
+
+	return last, nil
+}
+
+func (s *Store) FindScripts(ctx context.Context) ([]core.StoredScript, error) {
+	names, err := s.client.SMembers(ctx, s.key("script_names")).Result()
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+
+	scripts := make([]core.StoredScript, 0, len(names))
+	for _, name := range names {
+		script, err := s.GetScript(ctx, name, 0)
+		if err != nil {
+			return nil, err
+		}
+		if script != nil {
+			scripts = append(scripts, *script)
+		}
+	}
+
+	return scripts, nil
+}
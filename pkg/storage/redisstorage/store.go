@@ -0,0 +1,67 @@
+package redisstorage
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Store is a storage.Store backed by redis, meant for ephemeral ledgers where throughput matters more than durability.
+//
+// The transactions are appended to a list indexed by their ids, and the volumes of the accounts are
+// maintained in a hash per account. The filters of the queries are evaluated by scanning these structures.
+type Store struct {
+	ledger  string
+	client  redis.UniversalClient
+	onClose func(ctx context.Context) error
+}
+
+// globEscaper escapes the special characters of the redis glob-style patterns
+var globEscaper = strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`, `]`, `\]`)
+
+// key returns the redis key of a ledger structure
+func (s *Store) key(parts ...string) string {
+	return fmt.Sprintf("ledger:%s:%s", s.ledger, strings.Join(parts, ":"))
+}
+
+func NewStore(name string, client redis.UniversalClient, onClose func(ctx context.Context) error) (*Store, error) {
+	return &Store{
+		ledger:  name,
+		client:  client,
+		onClose: onClose,
+	}, nil
+}
+
+func (s *Store) Name() string {
+	return s.ledger
+}
+
+func (s *Store) Initialize(ctx context.Context) error {
+	if err := s.indexHashes(ctx); err != nil {
+		return err
+	}
+	return s.indexUsedReferences(ctx)
+}
+
+func (s *Store) Ping(ctx context.Context) error {
+	return s.client.Ping(ctx).Err()
+}
+
+func (s *Store) Close(ctx context.Context) error {
+	return s.onClose(ctx)
+}
+
+// Drop deletes the keys of the ledger. As the keys are only prefixed with the ledger name, the keys of
+// the ledgers named after it followed by a colon, like "name:other", are deleted as well.
+func (s *Store) Drop(ctx context.Context) error {
+	iter := s.client.Scan(ctx, 0, globEscaper.Replace(s.key())+"*", scanPageSize).Iterator()
+	for iter.Next(ctx) {
+		err := s.client.Del(ctx, iter.Val()).Err()
+		if err != nil {
+			return err
+		}
+	}
+	return iter.Err()
+}
@@ -0,0 +1,34 @@
+package redisstorage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/numary/ledger/pkg/storage"
+	"github.com/numary/ledger/pkg/storage/storagetesting"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStore(t *testing.T) {
+	storagetesting.TestStore(t, func(t *testing.T) storage.Store {
+		server, err := miniredis.Run()
+		assert.NoError(t, err)
+		t.Cleanup(server.Close)
+
+		d := NewDriver("redis", &redis.UniversalOptions{
+			Addrs: []string{server.Addr()},
+		})
+		err = d.Initialize(context.Background())
+		assert.NoError(t, err)
+		t.Cleanup(func() {
+			d.Close(context.Background())
+		})
+
+		store, err := d.NewStore("test")
+		assert.NoError(t, err)
+
+		return store
+	})
+}
@@ -0,0 +1,544 @@
+package redisstorage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/numary/ledger/pkg/core"
+	"github.com/numary/ledger/pkg/ledger/query"
+	"github.com/numary/ledger/pkg/storage"
+)
+
+// scanPageSize is the number of transactions read at once when scanning the log
+const scanPageSize = 100
+
+func (s *Store) FindTransactions(ctx context.Context, q query.Query) (query.Cursor, error) {
+	limit := int(math.Max(-1, float64(q.Limit)))
+
+	c := query.Cursor{}
+	var results []core.Transaction
+
+	count, err := s.CountTransactions(ctx)
+	if err != nil {
+		return c, err
+	}
+
+	after, err := storage.CursorAfter(q)
+	if err != nil {
+		return c, err
+	}
+	before, err := storage.CursorBefore(q)
+	if err != nil {
+		return c, err
+	}
+
+	// The previous page is fetched in the reverse order, starting from the keyset
+	keyset := after
+	if before >= 0 {
+		keyset = before
+	}
+
+	// We fetch an additional transaction to know if we have more documents
+	if (before >= 0) != q.Sorting().Asc {
+		var start int64
+		start, err = s.position(ctx, keyset+1, count)
+		if err == nil {
+			results, err = s.scanForward(ctx, q, start, count-1, limit+1)
+		}
+	} else {
+		end := count - 1
+		if keyset >= 0 {
+			end, err = s.position(ctx, keyset, count)
+			end--
+		}
+		if err == nil {
+			results, err = s.scanBackward(ctx, q, end, limit+1)
+		}
+	}
+	if err != nil {
+		return c, err
+	}
+
+	for i := range results {
+		meta, err := s.GetMeta(ctx, "transaction", fmt.Sprintf("%d", results[i].ID))
+		if err != nil {
+			return c, err
+		}
+		results[i].Metadata = meta
+	}
+
+	c.PageSize = limit
+
+	c.HasMore = len(results) > limit
+	if c.HasMore {
+		results = results[:limit]
+	}
+	if before >= 0 {
+		storage.ReverseTransactions(results)
+	}
+	c.Data = results
+	c.Total = count
+
+	return c, nil
+}
+
+// scanBackward returns up to n transactions matching the query, from the position end down to the first one
+func (s *Store) scanBackward(ctx context.Context, q query.Query, end int64, n int) ([]core.Transaction, error) {
+	results := make([]core.Transaction, 0)
+
+	for end >= 0 && len(results) < n {
+		start := end - scanPageSize + 1
+		if start < 0 {
+			start = 0
+		}
+
+		txs, err := s.getTransactions(ctx, start, end)
+		if err != nil {
+			return nil, err
+		}
+
+		for i := len(txs) - 1; i >= 0 && len(results) < n; i-- {
+			ok, err := s.match(ctx, q, &txs[i])
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				results = append(results, txs[i])
+			}
+		}
+
+		end = start - 1
+	}
+
+	return results, nil
+}
+
+// scanForward returns up to n transactions matching the query, from the position start up to the position end
+func (s *Store) scanForward(ctx context.Context, q query.Query, start, end int64, n int) ([]core.Transaction, error) {
+	results := make([]core.Transaction, 0)
+
+	for start <= end && len(results) < n {
+		stop := start + scanPageSize - 1
+		if stop > end {
+			stop = end
+		}
+
+		txs, err := s.getTransactions(ctx, start, stop)
+		if err != nil {
+			return nil, err
+		}
+
+		for i := 0; i < len(txs) && len(results) < n; i++ {
+			ok, err := s.match(ctx, q, &txs[i])
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				results = append(results, txs[i])
+			}
+		}
+
+		start = stop + 1
+	}
+
+	return results, nil
+}
+
+// match reports whether a transaction satisfies the query, reading its metadata only if the query filters them
+func (s *Store) match(ctx context.Context, q query.Query, tx *core.Transaction) (bool, error) {
+	if q.HasParam("metadata") {
+		meta, err := s.GetMeta(ctx, "transaction", fmt.Sprintf("%d", tx.ID))
+		if err != nil {
+			return false, err
+		}
+		tx.Metadata = meta
+	}
+	return storage.MatchTransaction(q, *tx), nil
+}
+
+// position returns the position in the list of the transactions of the first transaction with an id greater than
+// or equal to id, count if there is none. The ids increasing, it is the id itself unless the ids are sparse,
+// and it is searched by dichotomy otherwise.
+func (s *Store) position(ctx context.Context, id, count int64) (int64, error) {
+	if id <= 0 {
+		return 0, nil
+	}
+	if id < count {
+		txs, err := s.getTransactions(ctx, id, id)
+		if err != nil {
+			return 0, err
+		}
+		if len(txs) > 0 && txs[0].ID == id {
+			return id, nil
+		}
+	}
+
+	low, high := int64(0), count
+	for low < high {
+		middle := low + (high-low)/2
+		txs, err := s.getTransactions(ctx, middle, middle)
+		if err != nil {
+			return 0, err
+		}
+		if len(txs) == 0 {
+			return middle, nil
+		}
+		if txs[0].ID < id {
+			low = middle + 1
+		} else {
+			high = middle
+		}
+	}
+	return low, nil
+}
+
+// lastPosition returns the position of the last transaction with an id lower than or equal to txid, -1 if there is none
+func (s *Store) lastPosition(ctx context.Context, txid int64) (int64, error) {
+	count, err := s.CountTransactions(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if txid == math.MaxInt64 {
+		return count - 1, nil
+	}
+	i, err := s.position(ctx, txid+1, count)
+	return i - 1, err
+}
+
+// getTransactions reads the transactions at the positions between start and end included, without their metadata
+func (s *Store) getTransactions(ctx context.Context, start, end int64) ([]core.Transaction, error) {
+	values, err := s.client.LRange(ctx, s.key("transactions"), start, end).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	txs := make([]core.Transaction, len(values))
+	for i, v := range values {
+		err := json.Unmarshal([]byte(v), &txs[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return txs, nil
+}
+
+func (s *Store) SaveTransactions(ctx context.Context, ts []core.Transaction) error {
+	return s.saveTransactions(ctx, "", ts, nil)
+}
+
+// SaveTransactionsWithMeta saves the transactions along with metadata of other targets in the same redis transaction
+func (s *Store) SaveTransactionsWithMeta(ctx context.Context, ts []core.Transaction, entries []storage.MetaEntry) error {
+	return s.saveTransactions(ctx, "", ts, entries)
+}
+
+// saveTransactions appends the transactions to the log and updates the volumes of the accounts,
+// along with the idempotency key if it is not empty and the metadata entries, in a single redis transaction.
+// It fails if the ids don't follow the last transaction or if a reference is already used within its scope,
+// see storage.WithReferenceScope, as the primary key and reference unicity constraints of the sql stores would.
+func (s *Store) saveTransactions(ctx context.Context, key string, ts []core.Transaction, entries []storage.MetaEntry) error {
+	if len(ts) == 0 {
+		return nil
+	}
+
+	// The reference of each key, keyed by the key as a string
+	scope := storage.ReferenceScopeFromContext(ctx)
+	references := map[string]string{}
+	for _, t := range ts {
+		for _, k := range storage.ReferenceKeys(scope, t) {
+			if _, ok := references[k.String()]; ok {
+				return fmt.Errorf("%w: %s", storage.ErrDuplicateReference, t.Reference)
+			}
+			references[k.String()] = t.Reference
+		}
+	}
+
+	txsKey := s.key("transactions")
+	refsKey := s.key("references")
+
+	err := s.client.Watch(ctx, func(tx *redis.Tx) error {
+		last, err := s.lastID(ctx, tx)
+		if err != nil {
+			return err
+		}
+		if previous, ok := storage.PreviousTransactionFromContext(ctx); ok && previous != last {
+			return fmt.Errorf("%w: transaction %d was saved after transaction %d", storage.ErrConflict, last, previous)
+		}
+		for _, t := range ts {
+			if t.ID <= last {
+				return fmt.Errorf("%w: transaction %d does not follow the last transaction", storage.ErrConflict, t.ID)
+			}
+			last = t.ID
+		}
+
+		for k, ref := range references {
+			used, err := tx.HExists(ctx, refsKey, k).Result()
+			if err != nil {
+				return err
+			}
+			if used {
+				return fmt.Errorf("%w: %s", storage.ErrDuplicateReference, ref)
+			}
+		}
+
+		lastMetaID, err := s.lastMetaID(ctx, tx)
+		if err != nil {
+			return err
+		}
+		nextID := lastMetaID + 1
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			for _, t := range ts {
+				metadata := t.Metadata
+				t.Metadata = nil
+
+				data, err := json.Marshal(t)
+				if err != nil {
+					return err
+				}
+				pipe.RPush(ctx, txsKey, data)
+
+				for _, k := range storage.ReferenceKeys(scope, t) {
+					pipe.HSet(ctx, refsKey, k.String(), t.ID)
+				}
+				for _, k := range storage.UsedReferenceKeys(t) {
+					pipe.HSet(ctx, s.key("used_references"), k.String(), t.ID)
+				}
+				if t.Hash != "" {
+					pipe.HSet(ctx, s.key("hashes"), t.Hash, t.ID)
+				}
+
+				for _, p := range t.Postings {
+					pipe.ZAdd(ctx, s.key("accounts"), &redis.Z{Member: p.Source}, &redis.Z{Member: p.Destination})
+					pipe.HIncrBy(ctx, s.key("volumes", p.Source), "output:"+p.Asset, p.Amount)
+					pipe.HIncrBy(ctx, s.key("volumes", p.Destination), "input:"+p.Asset, p.Amount)
+					pipe.HIncrBy(ctx, s.key("asset_volumes"), p.Asset, p.Amount)
+				}
+
+				for k, v := range metadata {
+					s.saveMeta(ctx, pipe, storage.MetaEntry{
+						ID:         nextID,
+						Timestamp:  t.Timestamp,
+						TargetType: "transaction",
+						TargetID:   fmt.Sprintf("%d", t.ID),
+						Key:        k,
+						Value:      string(v),
+					})
+					nextID++
+				}
+			}
+
+			for _, e := range entries {
+				e.ID = nextID
+				s.saveMeta(ctx, pipe, e)
+				nextID++
+			}
+			s.updateMetaVersions(ctx, pipe, entries)
+
+			if key != "" {
+				pipe.Del(ctx, s.key("idempotency_keys", key))
+				pipe.HSet(ctx, s.key("idempotency_keys", key),
+					"first_txid", ts[0].ID,
+					"last_txid", ts[len(ts)-1].ID,
+					"timestamp", time.Now().UTC().Format(time.RFC3339),
+				)
+			}
+
+			return nil
+		})
+		return err
+	}, txsKey, refsKey)
+	if errors.Is(err, redis.TxFailedErr) {
+		return fmt.Errorf("%w: %s", storage.ErrConflict, err)
+	}
+
+	return err
+}
+
+func (s *Store) GetTransaction(ctx context.Context, txid string) (tx core.Transaction, err error) {
+	tx.Metadata = core.Metadata{}
+
+	id, err := strconv.ParseInt(txid, 10, 64)
+	if err != nil || id < 0 {
+		return tx, nil
+	}
+
+	count, err := s.CountTransactions(ctx)
+	if err != nil {
+		return tx, err
+	}
+	i, err := s.position(ctx, id, count)
+	if err != nil {
+		return tx, err
+	}
+	txs, err := s.getTransactions(ctx, i, i)
+	if err != nil || len(txs) == 0 || txs[0].ID != id {
+		return tx, err
+	}
+	tx = txs[0]
+
+	meta, err := s.GetMeta(ctx, "transaction", txid)
+	if err != nil {
+		return tx, err
+	}
+	tx.Metadata = meta
+
+	return tx, nil
+}
+
+func (s *Store) GetTransactionByHash(ctx context.Context, hash string) (core.Transaction, error) {
+	id, err := s.client.HGet(ctx, s.key("hashes"), hash).Result()
+	if err == redis.Nil {
+		return core.Transaction{
+			Metadata: core.Metadata{},
+		}, nil
+	}
+	if err != nil {
+		return core.Transaction{}, err
+	}
+
+	return s.GetTransaction(ctx, id)
+}
+
+// indexHashes indexes the hashes of the transactions saved before the hashes were indexed
+func (s *Store) indexHashes(ctx context.Context) error {
+	count, err := s.client.LLen(ctx, s.key("transactions")).Result()
+	if err != nil {
+		return err
+	}
+	indexed, err := s.client.HLen(ctx, s.key("hashes")).Result()
+	if err != nil || indexed >= count {
+		return err
+	}
+
+	for start := int64(0); start < count; start += scanPageSize {
+		txs, err := s.getTransactions(ctx, start, start+scanPageSize-1)
+		if err != nil {
+			return err
+		}
+		_, err = s.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			for _, t := range txs {
+				if t.Hash != "" {
+					pipe.HSet(ctx, s.key("hashes"), t.Hash, t.ID)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// indexUsedReferences indexes the keys of the references of the transactions saved before they were indexed,
+// once, see storage.UsedReferenceKeys
+func (s *Store) indexUsedReferences(ctx context.Context) error {
+	indexed, err := s.client.Exists(ctx, s.key("used_references_indexed")).Result()
+	if err != nil || indexed > 0 {
+		return err
+	}
+
+	count, err := s.client.LLen(ctx, s.key("transactions")).Result()
+	if err != nil {
+		return err
+	}
+	for start := int64(0); start < count; start += scanPageSize {
+		txs, err := s.getTransactions(ctx, start, start+scanPageSize-1)
+		if err != nil {
+			return err
+		}
+		_, err = s.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			for _, t := range txs {
+				for _, k := range storage.UsedReferenceKeys(t) {
+					pipe.HSet(ctx, s.key("used_references"), k.String(), t.ID)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return s.client.Set(ctx, s.key("used_references_indexed"), 1, 0).Err()
+}
+
+// FindUsedReferences looks the keys up in the index of the keys of the references in all the scopes
+func (s *Store) FindUsedReferences(ctx context.Context, keys []storage.ReferenceKey) ([]storage.ReferenceKey, error) {
+	used := make([]storage.ReferenceKey, 0)
+	if len(keys) == 0 {
+		return used, nil
+	}
+
+	fields := make([]string, len(keys))
+	for i, k := range keys {
+		fields[i] = k.String()
+	}
+	ids, err := s.client.HMGet(ctx, s.key("used_references"), fields...).Result()
+	if err != nil {
+		return nil, err
+	}
+	for i, id := range ids {
+		if id != nil {
+			used = append(used, keys[i])
+		}
+	}
+	return used, nil
+}
+
+func (s *Store) LastTransaction(ctx context.Context) (*core.Transaction, error) {
+	txs, err := s.getTransactions(ctx, -1, -1)
+	if err != nil || len(txs) == 0 {
+		return nil, err
+	}
+
+	tx := txs[0]
+	tx.Metadata, err = s.GetMeta(ctx, "transaction", fmt.Sprintf("%d", tx.ID))
+	if err != nil {
+		return nil, err
+	}
+
+	return &tx, nil
+}
+
+// lastID returns the id of the last transaction, -1 if there is none
+func (s *Store) lastID(ctx context.Context, client redis.Cmdable) (int64, error) {
+	data, err := client.LIndex(ctx, s.key("transactions"), -1).Bytes()
+	if err == redis.Nil {
+		return -1, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	var tx core.Transaction
+	if err := json.Unmarshal(data, &tx); err != nil {
+		return 0, err
+	}
+	return tx.ID, nil
+}
+
+// Head reads the last transaction of the list of the transactions, without its metadata
+func (s *Store) Head(ctx context.Context) (int64, string, error) {
+	data, err := s.client.LIndex(ctx, s.key("transactions"), -1).Bytes()
+	if err == redis.Nil {
+		return -1, "", nil
+	}
+	if err != nil {
+		return 0, "", err
+	}
+
+	var tx core.Transaction
+	if err := json.Unmarshal(data, &tx); err != nil {
+		return 0, "", err
+	}
+	return tx.ID, tx.Hash, nil
+}
@@ -0,0 +1,133 @@
+package redisstorage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/numary/ledger/pkg/core"
+	"github.com/numary/ledger/pkg/storage"
+)
+
+// The webhooks are stored in a hash by id, along with the list of their ids in the order of their registration,
+// and their cursors in a hash of their own, updated by the deliveries. The deliveries of a webhook are pushed
+// to the head of a list.
+
+func (s *Store) SaveWebhook(ctx context.Context, webhook core.Webhook) error {
+	data, err := json.Marshal(webhook)
+	if err != nil {
+		return err
+	}
+
+	saved, err := s.client.HSetNX(ctx, s.key("webhooks"), webhook.ID, data).Result()
+	if err != nil {
+		return err
+	}
+	if !saved {
+		return fmt.Errorf("%w: webhook %s already exists", storage.ErrConflict, webhook.ID)
+	}
+
+	_, err = s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, s.key("webhook_cursors"), webhook.ID, webhook.Cursor)
+		pipe.RPush(ctx, s.key("webhook_ids"), webhook.ID)
+		return nil
+	})
+	return err
+}
+
+func (s *Store) GetWebhook(ctx context.Context, id string) (*core.Webhook, error) {
+	data, err := s.client.HGet(ctx, s.key("webhooks"), id).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return s.webhook(ctx, data)
+}
+
+func (s *Store) FindWebhooks(ctx context.Context) ([]core.Webhook, error) {
+	ids, err := s.client.LRange(ctx, s.key("webhook_ids"), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	webhooks := make([]core.Webhook, 0, len(ids))
+	if len(ids) == 0 {
+		return webhooks, nil
+	}
+	values, err := s.client.HMGet(ctx, s.key("webhooks"), ids...).Result()
+	if err != nil {
+		return nil, err
+	}
+	for _, value := range values {
+		data, ok := value.(string)
+		if !ok {
+			continue
+		}
+		webhook, err := s.webhook(ctx, []byte(data))
+		if err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, *webhook)
+	}
+
+	return webhooks, nil
+}
+
+// webhook decodes a webhook, with its current cursor
+func (s *Store) webhook(ctx context.Context, data []byte) (*core.Webhook, error) {
+	var webhook core.Webhook
+	if err := json.Unmarshal(data, &webhook); err != nil {
+		return nil, err
+	}
+
+	cursor, err := s.client.HGet(ctx, s.key("webhook_cursors"), webhook.ID).Int64()
+	if err != nil && err != redis.Nil {
+		return nil, err
+	}
+	if err == nil {
+		webhook.Cursor = cursor
+	}
+
+	return &webhook, nil
+}
+
+func (s *Store) UpdateWebhookCursor(ctx context.Context, id string, cursor int64) error {
+	return s.client.HSet(ctx, s.key("webhook_cursors"), id, strconv.FormatInt(cursor, 10)).Err()
+}
+
+func (s *Store) SaveWebhookDelivery(ctx context.Context, delivery core.WebhookDelivery) error {
+	data, err := json.Marshal(delivery)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.LPush(ctx, s.key("webhook_deliveries", delivery.WebhookID), data)
+		pipe.HSet(ctx, s.key("webhook_cursors"), delivery.WebhookID, strconv.FormatInt(delivery.TransactionID, 10))
+		return nil
+	})
+	return err
+}
+
+func (s *Store) FindWebhookDeliveries(ctx context.Context, id string) ([]core.WebhookDelivery, error) {
+	values, err := s.client.LRange(ctx, s.key("webhook_deliveries", id), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	deliveries := make([]core.WebhookDelivery, 0, len(values))
+	for _, data := range values {
+		var delivery core.WebhookDelivery
+		if err := json.Unmarshal([]byte(data), &delivery); err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+
+	return deliveries, nil
+}
@@ -0,0 +1,104 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/numary/ledger/pkg/core"
+)
+
+// ReferenceScope is the scope within which the references of the transactions of a ledger must be unique
+type ReferenceScope string
+
+const (
+	// ReferenceScopeGlobal makes the references unique among all the transactions of the ledger, the default
+	ReferenceScopeGlobal ReferenceScope = "global"
+	// ReferenceScopePerAsset makes the references unique among the transactions moving the same asset,
+	// a reference being reusable by the transactions whose postings use other assets
+	ReferenceScopePerAsset ReferenceScope = "per-asset"
+	// ReferenceScopeNone lets the transactions share their references
+	ReferenceScopeNone ReferenceScope = "none"
+)
+
+// ParseReferenceScope returns the scope of the references named v
+func ParseReferenceScope(v string) (ReferenceScope, error) {
+	switch scope := ReferenceScope(v); scope {
+	case ReferenceScopeGlobal, ReferenceScopePerAsset, ReferenceScopeNone:
+		return scope, nil
+	}
+	return "", fmt.Errorf("invalid reference scope '%s': expected %s, %s or %s",
+		v, ReferenceScopeGlobal, ReferenceScopePerAsset, ReferenceScopeNone)
+}
+
+type referenceScopeKey struct{}
+
+// WithReferenceScope sets the scope of the references of the transactions saved with the context.
+// The stores check the references are unique within it, the scope being global by default.
+func WithReferenceScope(ctx context.Context, scope ReferenceScope) context.Context {
+	return context.WithValue(ctx, referenceScopeKey{}, scope)
+}
+
+// ReferenceScopeFromContext returns the scope of the references set with WithReferenceScope, global by default
+func ReferenceScopeFromContext(ctx context.Context) ReferenceScope {
+	scope, ok := ctx.Value(referenceScopeKey{}).(ReferenceScope)
+	if !ok || scope == "" {
+		return ReferenceScopeGlobal
+	}
+	return scope
+}
+
+// ReferenceKey is a key the stores keep unique for the references of the transactions,
+// the asset being empty for the references unique among all the transactions
+type ReferenceKey struct {
+	Reference string
+	Asset     string
+}
+
+// String returns the key as a single string, for the stores keeping the keys in a map
+func (k ReferenceKey) String() string {
+	if k.Asset == "" {
+		return k.Reference
+	}
+	return k.Asset + "\x00" + k.Reference
+}
+
+// ReferenceKeys returns the keys of the reference of a transaction in a scope: the reference itself in the global scope,
+// the reference along with each asset of the postings in the per asset scope, and none without scope or reference
+func ReferenceKeys(scope ReferenceScope, tx core.Transaction) []ReferenceKey {
+	if tx.Reference == "" {
+		return nil
+	}
+
+	switch scope {
+	case ReferenceScopeNone:
+		return nil
+	case ReferenceScopePerAsset:
+		assets := map[string]struct{}{}
+		keys := make([]ReferenceKey, 0)
+		for _, p := range tx.Postings {
+			if _, ok := assets[p.Asset]; ok {
+				continue
+			}
+			assets[p.Asset] = struct{}{}
+			keys = append(keys, ReferenceKey{
+				Reference: tx.Reference,
+				Asset:     p.Asset,
+			})
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			return keys[i].Asset < keys[j].Asset
+		})
+		return keys
+	default:
+		return []ReferenceKey{{
+			Reference: tx.Reference,
+		}}
+	}
+}
+
+// UsedReferenceKeys returns the keys of the reference of a transaction in the global and the per asset scopes,
+// the keys it uses whatever the scope it is saved in, see Store.FindUsedReferences
+func UsedReferenceKeys(tx core.Transaction) []ReferenceKey {
+	return append(ReferenceKeys(ReferenceScopeGlobal, tx), ReferenceKeys(ReferenceScopePerAsset, tx)...)
+}
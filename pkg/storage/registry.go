@@ -0,0 +1,93 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Config is the configuration of a registered driver. The ledger command reads it from the settings
+// under storage.<name of the driver>, like storage.kv.endpoints for a driver registered as kv.
+type Config map[string]interface{}
+
+// StoreFactory returns the store of a ledger, see RegisterDriver
+type StoreFactory func(ledger string, cfg Config) (Store, error)
+
+var (
+	driversMu sync.RWMutex
+	drivers   = map[string]StoreFactory{}
+)
+
+// RegisterDriver makes a storage backend available under a name, to be selected with the storage.driver setting
+// of the ledger command, or with NewRegisteredDriver. It is meant to be called from the init function
+// of the package of the backend, and panics if the name is already registered or the factory is nil.
+// The built-in drivers of the ledger command (sqlite, postgres, memory and redis) take precedence over
+// the drivers registered with their names.
+//
+// The factory is called each time the store of a ledger is requested, and is given the ledger name.
+// The stores returned must share their data for a given ledger, and behave as described by Store.
+func RegisterDriver(name string, factory func(ledger string, cfg Config) (Store, error)) {
+	driversMu.Lock()
+	defer driversMu.Unlock()
+
+	if factory == nil {
+		panic("storage: RegisterDriver factory is nil")
+	}
+	if _, ok := drivers[name]; ok {
+		panic(fmt.Sprintf("storage: RegisterDriver called twice for driver %s", name))
+	}
+	drivers[name] = factory
+}
+
+// Drivers returns the names of the registered drivers, sorted
+func Drivers() []string {
+	driversMu.RLock()
+	defer driversMu.RUnlock()
+
+	names := make([]string, 0, len(drivers))
+	for name := range drivers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewRegisteredDriver returns a Driver opening the stores with the factory registered under the name,
+// to be used with NewDefaultFactory like the built-in drivers
+func NewRegisteredDriver(name string, cfg Config) (Driver, error) {
+	driversMu.RLock()
+	factory, ok := drivers[name]
+	driversMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown storage driver %s", name)
+	}
+
+	return &registeredDriver{
+		name:    name,
+		cfg:     cfg,
+		factory: factory,
+	}, nil
+}
+
+type registeredDriver struct {
+	name    string
+	cfg     Config
+	factory StoreFactory
+}
+
+func (d *registeredDriver) Initialize(ctx context.Context) error {
+	return nil
+}
+
+func (d *registeredDriver) NewStore(name string) (Store, error) {
+	return d.factory(name, d.cfg)
+}
+
+func (d *registeredDriver) Close(ctx context.Context) error {
+	return nil
+}
+
+func (d *registeredDriver) Name() string {
+	return d.name
+}
@@ -0,0 +1,44 @@
+package storage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type registeredStore struct {
+	noOpStorage
+	ledger string
+	cfg    Config
+}
+
+func TestRegisterDriver(t *testing.T) {
+	RegisterDriver("registry-test", func(ledger string, cfg Config) (Store, error) {
+		return &registeredStore{
+			ledger: ledger,
+			cfg:    cfg,
+		}, nil
+	})
+	assert.Contains(t, Drivers(), "registry-test")
+	assert.Panics(t, func() {
+		RegisterDriver("registry-test", func(ledger string, cfg Config) (Store, error) {
+			return nil, nil
+		})
+	})
+
+	driver, err := NewRegisteredDriver("registry-test", Config{"endpoint": "localhost:1234"})
+	assert.NoError(t, err)
+	assert.Equal(t, "registry-test", driver.Name())
+	assert.NoError(t, driver.Initialize(context.Background()))
+
+	store, err := NewDefaultFactory(driver).GetStore("quickstart")
+	assert.NoError(t, err)
+	assert.Equal(t, &registeredStore{
+		ledger: "quickstart",
+		cfg:    Config{"endpoint": "localhost:1234"},
+	}, store)
+
+	_, err = NewRegisteredDriver("unknown", nil)
+	assert.EqualError(t, err, "unknown storage driver unknown")
+}
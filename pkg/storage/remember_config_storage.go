@@ -15,6 +15,16 @@ func (s *rememberConfigStorage) SaveTransactions(ctx context.Context, txs []core
 	return s.Store.SaveTransactions(ctx, txs)
 }
 
+func (s *rememberConfigStorage) SaveTransactionsWithKey(ctx context.Context, key string, txs []core.Transaction) error {
+	defer config.Remember(s.Name())
+	return s.Store.SaveTransactionsWithKey(ctx, key, txs)
+}
+
+func (s *rememberConfigStorage) SaveTransactionsWithMeta(ctx context.Context, txs []core.Transaction, entries []MetaEntry) error {
+	defer config.Remember(s.Name())
+	return s.Store.SaveTransactionsWithMeta(ctx, txs, entries)
+}
+
 func NewRememberConfigStorage(underlying Store) *rememberConfigStorage {
 	return &rememberConfigStorage{
 		Store: underlying,
@@ -0,0 +1,106 @@
+package storage
+
+import (
+	"sort"
+
+	"github.com/numary/ledger/pkg/core"
+	"github.com/numary/ledger/pkg/ledger/query"
+)
+
+// AddressFollows reports whether an address comes after a keyset in the address order of a query
+func AddressFollows(q query.Query, address, keyset string) bool {
+	if q.Sorting().Asc {
+		return address > keyset
+	}
+	return address < keyset
+}
+
+// SortAddresses sorts addresses in the address order of a query, or in the reverse order when fetching
+// a previous page, as the pages are fetched starting from their keyset
+func SortAddresses(q query.Query, addresses []string) {
+	if q.Sorting().Asc == (q.Before == "") {
+		sort.Strings(addresses)
+	} else {
+		sort.Sort(sort.Reverse(sort.StringSlice(addresses)))
+	}
+}
+
+// PageByBalance returns up to limit addresses matching the filters of a query sorted by balance,
+// given the balances of all the accounts. Like SortAddresses, the addresses of a previous page
+// are returned in the reverse order. It is meant for the stores which can't sort natively.
+func PageByBalance(q query.Query, balances map[string]int64, limit int) ([]string, error) {
+	asc := q.Sorting().Asc
+
+	type entry struct {
+		address string
+		balance int64
+	}
+	// follows reports whether a comes after b in the order of the query
+	follows := func(a, b entry) bool {
+		if a.balance != b.balance {
+			return (a.balance > b.balance) == asc
+		}
+		return a.address != b.address && (a.address > b.address) == asc
+	}
+
+	var after, before *entry
+	if q.After != "" {
+		balance, address, err := query.ParseBalanceKeyset(q.After)
+		if err != nil {
+			return nil, err
+		}
+		after = &entry{address: address, balance: balance}
+	}
+	if q.Before != "" {
+		balance, address, err := query.ParseBalanceKeyset(q.Before)
+		if err != nil {
+			return nil, err
+		}
+		before = &entry{address: address, balance: balance}
+	}
+
+	entries := make([]entry, 0, len(balances))
+	for address, balance := range balances {
+		e := entry{address: address, balance: balance}
+		if after != nil && !follows(e, *after) {
+			continue
+		}
+		if before != nil && !follows(*before, e) {
+			continue
+		}
+		if MatchAccount(q, address) {
+			entries = append(entries, e)
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		if before != nil {
+			return follows(entries[i], entries[j])
+		}
+		return follows(entries[j], entries[i])
+	})
+
+	addresses := make([]string, 0, limit)
+	for _, e := range entries {
+		if len(addresses) == limit {
+			break
+		}
+		addresses = append(addresses, e.address)
+	}
+
+	return addresses, nil
+}
+
+// AddPostings adds the postings of a transaction to the balances of an asset. Every account of the postings
+// gets a balance, zero if it never received nor sent the asset, like the accounts sorted by the sql stores.
+func AddPostings(balances map[string]int64, asset string, tx core.Transaction) {
+	for _, p := range tx.Postings {
+		balances[p.Source] += 0
+		balances[p.Destination] += 0
+		if p.Asset != asset {
+			continue
+		}
+		balances[p.Source] -= p.Amount
+		balances[p.Destination] += p.Amount
+	}
+}
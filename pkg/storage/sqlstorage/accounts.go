@@ -2,59 +2,240 @@ package sqlstorage
 
 import (
 	"context"
-	"github.com/sirupsen/logrus"
+	"fmt"
+	"github.com/numary/ledger/pkg/logging"
 	"math"
 
 	"github.com/huandu/go-sqlbuilder"
 	"github.com/numary/ledger/pkg/core"
 	"github.com/numary/ledger/pkg/ledger/query"
+	"github.com/numary/ledger/pkg/storage"
 )
 
-func (s *Store) FindAccounts(ctx context.Context, q query.Query) (query.Cursor, error) {
-	// We fetch an additional account to know if we have more documents
-	q.Limit = int(math.Max(-1, math.Min(float64(q.Limit), 100))) + 1
-
-	c := query.Cursor{}
-	results := make([]core.Account, 0)
+// findAddresses returns the addresses of the accounts matching the query, ordered as FindAccounts.
+// q.Limit is the number of addresses to fetch.
+func (s *Store) findAddresses(ctx context.Context, q query.Query) ([]string, error) {
+	addresses := make([]string, 0)
 
 	sb := sqlbuilder.NewSelectBuilder()
 	sb.
 		Select("address").
 		From(s.table("addresses")).
 		GroupBy("address").
-		OrderBy("address desc").
 		Limit(q.Limit)
 
+	// The previous page is fetched in the reverse order, starting from the keyset
+	asc := q.Sorting().Asc
+	if asc == (q.Before == "") {
+		sb.OrderBy("address asc")
+	} else {
+		sb.OrderBy("address desc")
+	}
+
 	if q.After != "" {
-		sb.Where(sb.LessThan("address", q.After))
+		if asc {
+			sb.Where(sb.GreaterThan("address", q.After))
+		} else {
+			sb.Where(sb.LessThan("address", q.After))
+		}
+	}
+
+	if q.Before != "" {
+		if asc {
+			sb.Where(sb.LessThan("address", q.Before))
+		} else {
+			sb.Where(sb.GreaterThan("address", q.Before))
+		}
+	}
+
+	if q.HasParam("account") {
+		sb.Where(matchAccount(sb, "address", q.Params["account"].(string)))
+	}
+
+	if q.HasParam("address") {
+		sb.Where(hasPrefix(sb, "address", q.Params["address"].(string)))
+	}
+
+	keys, values := storage.MetadataFilters(q, "account_metadata")
+	for i := range keys {
+		sb.Where(s.matchMetadata(sb, "address", "account", keys[i], values[i]))
 	}
 
 	sqlq, args := sb.BuildWithFlavor(s.flavor)
-	logrus.Debugln(sqlq, args)
+	logging.FromContext(ctx).Debugln(sqlq, args)
 
-	rows, err := s.db.QueryContext(
+	rows, err := s.reader(ctx).QueryContext(
 		ctx,
 		sqlq,
 		args...,
 	)
-
 	if err != nil {
-		return c, err
+		return addresses, err
 	}
+	defer rows.Close()
 
 	for rows.Next() {
 		var address string
 
 		err := rows.Scan(&address)
+		if err != nil {
+			return addresses, err
+		}
+
+		addresses = append(addresses, address)
+	}
+
+	return addresses, rows.Err()
+}
+
+// findByBalance returns the addresses of the accounts matching the query sorted by balance, with their balances.
+// The balances are aggregated from the postings up to the transaction of the sort, and sorted by the database.
+// q.Limit is the number of addresses to fetch.
+func (s *Store) findByBalance(ctx context.Context, q query.Query) ([]string, map[string]int64, error) {
+	sorting := q.Sorting()
+
+	in := sqlbuilder.NewSelectBuilder()
+	in.Select("destination as address", "asset", "amount").From(s.table("postings"))
+
+	out := sqlbuilder.NewSelectBuilder()
+	out.Select("source as address", "asset", "-amount").From(s.table("postings"))
+
+	if sorting.TxID != nil {
+		in.Where(in.LessEqualThan("txid", *sorting.TxID))
+		out.Where(out.LessEqualThan("txid", *sorting.TxID))
+	}
+
+	balances := sqlbuilder.NewSelectBuilder()
+	balances.
+		Select(
+			"address",
+			fmt.Sprintf("sum(CASE WHEN asset = %s THEN amount ELSE 0 END) as balance", balances.Var(sorting.Asset)),
+		).
+		From(balances.BuilderAs(sqlbuilder.UnionAll(in, out), "movements")).
+		GroupBy("address")
+
+	sb := sqlbuilder.NewSelectBuilder()
+	sb.
+		Select("address", "balance").
+		From(sb.BuilderAs(balances, "balances")).
+		Limit(q.Limit)
+
+	// Accounts with a same balance are sorted by address in the same direction,
+	// and the previous page is fetched in the reverse order, starting from the keyset
+	if sorting.Asc == (q.Before == "") {
+		sb.OrderBy("balance asc", "address asc")
+	} else {
+		sb.OrderBy("balance desc", "address desc")
+	}
+
+	if q.After != "" {
+		balance, address, err := query.ParseBalanceKeyset(q.After)
+		if err != nil {
+			return nil, nil, err
+		}
+		sb.Where(followsKeyset(sb, sorting.Asc, balance, address))
+	}
 
+	if q.Before != "" {
+		balance, address, err := query.ParseBalanceKeyset(q.Before)
 		if err != nil {
-			return c, err
+			return nil, nil, err
+		}
+		sb.Where(followsKeyset(sb, !sorting.Asc, balance, address))
+	}
+
+	if q.HasParam("account") {
+		sb.Where(matchAccount(sb, "address", q.Params["account"].(string)))
+	}
+
+	if q.HasParam("address") {
+		sb.Where(hasPrefix(sb, "address", q.Params["address"].(string)))
+	}
+
+	keys, values := storage.MetadataFilters(q, "account_metadata")
+	for i := range keys {
+		sb.Where(s.matchMetadata(sb, "address", "account", keys[i], values[i]))
+	}
+
+	sqlq, args := sb.BuildWithFlavor(s.flavor)
+	logging.FromContext(ctx).Debugln(sqlq, args)
+
+	rows, err := s.reader(ctx).QueryContext(ctx, sqlq, args...)
+	if err != nil {
+		return nil, nil, translateError(err)
+	}
+	defer rows.Close()
+
+	addresses := make([]string, 0)
+	results := map[string]int64{}
+	for rows.Next() {
+		var (
+			address string
+			balance int64
+		)
+
+		err := rows.Scan(&address, &balance)
+		if err != nil {
+			return nil, nil, err
 		}
 
+		addresses = append(addresses, address)
+		results[address] = balance
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, translateError(err)
+	}
+
+	return addresses, results, nil
+}
+
+// followsKeyset builds a condition matching the accounts after a keyset sorted by balance then address,
+// in ascending order if asc is true
+func followsKeyset(sb *sqlbuilder.SelectBuilder, asc bool, balance int64, address string) string {
+	if asc {
+		return sb.Or(
+			sb.GreaterThan("balance", balance),
+			sb.And(sb.Equal("balance", balance), sb.GreaterThan("address", address)),
+		)
+	}
+	return sb.Or(
+		sb.LessThan("balance", balance),
+		sb.And(sb.Equal("balance", balance), sb.LessThan("address", address)),
+	)
+}
+
+func (s *Store) FindAccounts(ctx context.Context, q query.Query) (query.Cursor, error) {
+	// We fetch an additional account to know if we have more documents
+	q.Limit = int(math.Max(-1, float64(q.Limit))) + 1
+
+	c := query.Cursor{}
+	results := make([]core.Account, 0)
+
+	sorting := q.Sorting()
+	var (
+		addresses []string
+		balances  map[string]int64
+		err       error
+	)
+	if sorting.By == query.SortByBalance {
+		addresses, balances, err = s.findByBalance(ctx, q)
+	} else {
+		addresses, err = s.findAddresses(ctx, q)
+	}
+	if err != nil {
+		return c, err
+	}
+
+	for _, address := range addresses {
 		account := core.Account{
 			Address:  address,
 			Contract: "default",
 		}
+		if balances != nil {
+			account.Balances = map[string]int64{
+				sorting.Asset: balances[address],
+			}
+		}
 
 		meta, err := s.GetMeta(ctx, "account", account.Address)
 		if err != nil {
@@ -71,6 +252,53 @@ func (s *Store) FindAccounts(ctx context.Context, q query.Query) (query.Cursor,
 	if c.HasMore {
 		results = results[:len(results)-1]
 	}
+	if q.Before != "" {
+		storage.ReverseAccounts(results)
+	}
+	c.Data = results
+
+	total, _ := s.CountAccounts(ctx)
+	c.Total = total
+
+	return c, nil
+}
+
+// FindBalances returns a page of accounts matching the query with their balances,
+// read for the whole page with a single query
+func (s *Store) FindBalances(ctx context.Context, q query.Query) (query.Cursor, error) {
+	// We fetch an additional account to know if we have more documents
+	q.Limit = int(math.Max(-1, float64(q.Limit))) + 1
+
+	c := query.Cursor{}
+	results := make([]core.Account, 0)
+
+	addresses, err := s.findAddresses(ctx, q)
+	if err != nil {
+		return c, err
+	}
+
+	c.PageSize = q.Limit - 1
+
+	c.HasMore = len(addresses) == q.Limit
+	if c.HasMore {
+		addresses = addresses[:len(addresses)-1]
+	}
+
+	balances, err := s.balancesOf(ctx, addresses)
+	if err != nil {
+		return c, err
+	}
+
+	for _, address := range addresses {
+		results = append(results, core.Account{
+			Address:  address,
+			Contract: "default",
+			Balances: balances[address],
+		})
+	}
+	if q.Before != "" {
+		storage.ReverseAccounts(results)
+	}
 	c.Data = results
 
 	total, _ := s.CountAccounts(ctx)
@@ -78,3 +306,84 @@ func (s *Store) FindAccounts(ctx context.Context, q query.Query) (query.Cursor,
 
 	return c, nil
 }
+
+// balancesOf returns the balances of the given accounts, read from their volumes with a single query.
+// The accounts without postings get empty balances.
+func (s *Store) balancesOf(ctx context.Context, addresses []string) (map[string]map[string]int64, error) {
+	balances := map[string]map[string]int64{}
+	for _, address := range addresses {
+		balances[address] = map[string]int64{}
+	}
+
+	if len(addresses) == 0 {
+		return balances, nil
+	}
+
+	targets := make([]interface{}, len(addresses))
+	for i, address := range addresses {
+		targets[i] = address
+	}
+
+	sb := sqlbuilder.NewSelectBuilder()
+	sb.Select("account", "asset", "input - output")
+	sb.From(s.table("volumes"))
+	sb.Where(sb.In("account", targets...))
+
+	sqlq, args := sb.BuildWithFlavor(s.flavor)
+	logging.FromContext(ctx).Debugln(sqlq, args)
+
+	rows, err := s.reader(ctx).QueryContext(ctx, sqlq, args...)
+	if err != nil {
+		return nil, translateError(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			account string
+			asset   string
+			amount  int64
+		)
+
+		err := rows.Scan(&account, &asset, &amount)
+		if err != nil {
+			return nil, err
+		}
+
+		balances[account][asset] = amount
+	}
+	if err := rows.Err(); err != nil {
+		return nil, translateError(err)
+	}
+
+	return balances, nil
+}
+
+// GetAccounts reads the balances and the metadata of all the accounts with a query each
+func (s *Store) GetAccounts(ctx context.Context, addresses []string) ([]core.Account, error) {
+	balances, err := s.balancesOf(ctx, addresses)
+	if err != nil {
+		return nil, err
+	}
+
+	meta, err := s.metaOf(ctx, "account", addresses)
+	if err != nil {
+		return nil, err
+	}
+
+	accounts := make([]core.Account, 0, len(addresses))
+	for _, address := range addresses {
+		account := core.Account{
+			Address:  address,
+			Contract: "default",
+			Balances: balances[address],
+			Metadata: meta[address],
+		}
+		if account.Metadata == nil && len(account.Balances) > 0 {
+			account.Metadata = core.Metadata{}
+		}
+		accounts = append(accounts, account)
+	}
+
+	return accounts, nil
+}
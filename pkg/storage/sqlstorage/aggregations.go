@@ -3,7 +3,12 @@ package sqlstorage
 import (
 	"context"
 	"github.com/huandu/go-sqlbuilder"
-	"github.com/sirupsen/logrus"
+	"github.com/numary/ledger/pkg/ledger/query"
+	"github.com/numary/ledger/pkg/logging"
+	"github.com/numary/ledger/pkg/storage"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.7.0"
+	"time"
 )
 
 func (s *Store) CountTransactions(ctx context.Context) (int64, error) {
@@ -15,7 +20,7 @@ func (s *Store) CountTransactions(ctx context.Context) (int64, error) {
 
 	sqlq, args := sb.Build()
 
-	err := s.db.QueryRowContext(ctx, sqlq, args...).Scan(&count)
+	err := s.reader(ctx).QueryRowContext(ctx, sqlq, args...).Scan(&count)
 
 	return count, err
 }
@@ -32,11 +37,120 @@ func (s *Store) CountAccounts(ctx context.Context) (int64, error) {
 
 	sqlq, args := sb.Build()
 
-	err := s.db.QueryRowContext(ctx, sqlq, args...).Scan(&count)
+	err := s.reader(ctx).QueryRowContext(ctx, sqlq, args...).Scan(&count)
 
 	return count, err
 }
 
+// FindAssets reads the distinct assets from the volumes, which have a row per account and asset
+func (s *Store) FindAssets(ctx context.Context) ([]string, error) {
+	sb := sqlbuilder.NewSelectBuilder()
+	sb.
+		Select("asset").
+		Distinct().
+		From(s.table("volumes")).
+		OrderBy("asset")
+
+	sqlq, args := sb.BuildWithFlavor(s.flavor)
+	logging.FromContext(ctx).Debugln(sqlq, args)
+
+	rows, err := s.reader(ctx).QueryContext(ctx, sqlq, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	assets := []string{}
+	for rows.Next() {
+		var asset string
+		if err := rows.Scan(&asset); err != nil {
+			return nil, err
+		}
+		assets = append(assets, asset)
+	}
+
+	return assets, rows.Err()
+}
+
+// FindAssetsOf reads the assets of the base codes from the assets table, maintained along with the volumes,
+// which has a row per asset
+func (s *Store) FindAssetsOf(ctx context.Context, codes []string) ([]string, error) {
+	if len(codes) == 0 {
+		return []string{}, nil
+	}
+
+	sb := sqlbuilder.NewSelectBuilder()
+	conditions := make([]string, 0, len(codes)+1)
+	args := make([]interface{}, 0, len(codes))
+	for _, code := range codes {
+		args = append(args, code)
+		conditions = append(conditions, sb.Like("asset", code+"/%"))
+	}
+	conditions = append(conditions, sb.In("asset", args...))
+	sb.
+		Select("asset").
+		From(s.table("assets")).
+		Where(sb.Or(conditions...)).
+		OrderBy("asset")
+
+	sqlq, args := sb.BuildWithFlavor(s.flavor)
+	logging.FromContext(ctx).Debugln(sqlq, args)
+
+	rows, err := s.reader(ctx).QueryContext(ctx, sqlq, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	assets := []string{}
+	for rows.Next() {
+		var asset string
+		if err := rows.Scan(&asset); err != nil {
+			return nil, err
+		}
+		assets = append(assets, asset)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// The wildcards of LIKE possibly in the codes may match the assets of other base codes
+	return storage.AssetsOf(assets, codes), nil
+}
+
+func (s *Store) AssetVolumes(ctx context.Context) (map[string]int64, error) {
+	volumes := map[string]int64{}
+
+	sb := sqlbuilder.NewSelectBuilder()
+	sb.
+		Select("asset", "sum(amount)").
+		From(s.table("postings")).
+		GroupBy("asset")
+
+	sqlq, args := sb.BuildWithFlavor(s.flavor)
+	logging.FromContext(ctx).Debugln(sqlq, args)
+
+	rows, err := s.reader(ctx).QueryContext(ctx, sqlq, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			asset  string
+			volume int64
+		)
+		err := rows.Scan(&asset, &volume)
+		if err != nil {
+			return nil, err
+		}
+		volumes[asset] = volume
+	}
+
+	return volumes, rows.Err()
+}
+
 func (s *Store) CountMeta(ctx context.Context) (int64, error) {
 	var count int64
 
@@ -47,9 +161,9 @@ func (s *Store) CountMeta(ctx context.Context) (int64, error) {
 		From(s.table("metadata"))
 
 	sqlq, args := sb.BuildWithFlavor(s.flavor)
-	logrus.StandardLogger().Debugln(sqlq)
+	logging.FromContext(ctx).Debugln(sqlq)
 
-	q := s.db.QueryRowContext(ctx, sqlq, args...)
+	q := s.reader(ctx).QueryRowContext(ctx, sqlq, args...)
 	err := q.Scan(&count)
 
 	return count, err
@@ -71,8 +185,73 @@ func (s *Store) AggregateBalances(ctx context.Context, address string) (map[stri
 	return balances, nil
 }
 
+func (s *Store) AggregateBalance(ctx context.Context, address, asset string) (int64, error) {
+	volumes, err := s.volumesOf(ctx, address, asset)
+	if err != nil {
+		return 0, err
+	}
+
+	return volumes[asset]["input"] - volumes[asset]["output"], nil
+}
+
+func (s *Store) AccountExists(ctx context.Context, address string) (bool, error) {
+	var count int64
+
+	sb := sqlbuilder.NewSelectBuilder()
+	sb.
+		Select("count(*)").
+		From(s.table("addresses")).
+		Where(sb.Equal("address", address))
+
+	sqlq, args := sb.BuildWithFlavor(s.flavor)
+	logging.FromContext(ctx).Debugln(sqlq, args)
+
+	err := s.reader(ctx).QueryRowContext(ctx, sqlq, args...).Scan(&count)
+
+	return count > 0, err
+}
+
+func (s *Store) AggregateFlow(ctx context.Context, source, destination, asset string, from, to time.Time) (int64, error) {
+	var flow int64
+
+	// Scanned with the p_flow index, the transactions being joined for their timestamps only
+	sb := sqlbuilder.NewSelectBuilder()
+	sb.
+		Select("coalesce(sum(p.amount), 0)").
+		From(s.table("postings")+" p").
+		Join(s.table("transactions")+" t", "p.txid = t.id").
+		Where(
+			sb.Equal("p.source", source),
+			sb.Equal("p.destination", destination),
+			sb.Equal("p.asset", asset),
+		)
+	if !from.IsZero() {
+		sb.Where(sb.GreaterEqualThan("t.timestamp", from.UTC().Format(time.RFC3339)))
+	}
+	if !to.IsZero() {
+		sb.Where(sb.LessThan("t.timestamp", to.UTC().Format(time.RFC3339)))
+	}
+
+	sqlq, args := sb.BuildWithFlavor(s.flavor)
+	logging.FromContext(ctx).Debugln(sqlq, args)
+
+	err := s.reader(ctx).QueryRowContext(ctx, sqlq, args...).Scan(&flow)
+
+	return flow, err
+}
+
 func (s *Store) AggregateVolumes(ctx context.Context, address string) (map[string]map[string]int64, error) {
-	volumes := map[string]map[string]int64{}
+	return s.volumesOf(ctx, address, "")
+}
+
+func (s *Store) AggregateVolumesAt(ctx context.Context, address string, txid int64) (map[string]map[string]int64, error) {
+	return s.aggregateVolumes(ctx, address, "", txid)
+}
+
+// aggregateVolumes computes the volumes of an account, restricted to a single asset if asset is not empty,
+// and to the transactions up to txid included if txid is not negative
+func (s *Store) aggregateVolumes(ctx context.Context, address, asset string, txid int64) (volumes map[string]map[string]int64, err error) {
+	volumes = map[string]map[string]int64{}
 
 	agg1 := sqlbuilder.NewSelectBuilder()
 	agg1.
@@ -86,6 +265,16 @@ func (s *Store) AggregateVolumes(ctx context.Context, address string) (map[strin
 		From(s.table("postings")).Where(agg2.Equal("destination", address)).
 		GroupBy("asset")
 
+	if asset != "" {
+		agg1.Where(agg1.Equal("asset", asset))
+		agg2.Where(agg2.Equal("asset", asset))
+	}
+
+	if txid >= 0 {
+		agg1.Where(agg1.LessEqualThan("txid", txid))
+		agg2.Where(agg2.LessEqualThan("txid", txid))
+	}
+
 	union := sqlbuilder.Union(agg1, agg2)
 
 	sb := sqlbuilder.NewSelectBuilder()
@@ -94,11 +283,20 @@ func (s *Store) AggregateVolumes(ctx context.Context, address string) (map[strin
 
 	sqlq, args := sb.BuildWithFlavor(s.flavor)
 
-	rows, err := s.db.QueryContext(ctx, sqlq, args...)
+	ctx, span := s.startSpan(ctx, "AggregateVolumes",
+		semconv.DBStatementKey.String(sqlq),
+		attribute.String("account", address),
+	)
+	defer func() {
+		endSpan(span, err)
+	}()
+
+	rows, err := s.reader(ctx).QueryContext(ctx, sqlq, args...)
 
 	if err != nil {
-		return volumes, err
+		return volumes, translateError(err)
 	}
+	defer rows.Close()
 
 	for rows.Next() {
 		var row = struct {
@@ -114,7 +312,10 @@ func (s *Store) AggregateVolumes(ctx context.Context, address string) (map[strin
 		}
 
 		if _, ok := volumes[row.asset]; !ok {
-			volumes[row.asset] = map[string]int64{}
+			volumes[row.asset] = map[string]int64{
+				"input":  0,
+				"output": 0,
+			}
 		}
 
 		if row.t == "_out" {
@@ -123,6 +324,56 @@ func (s *Store) AggregateVolumes(ctx context.Context, address string) (map[strin
 			volumes[row.asset]["input"] += row.amount
 		}
 	}
+	if err := rows.Err(); err != nil {
+		// An error interrupting the scan must not be mistaken for empty volumes
+		return volumes, translateError(err)
+	}
 
 	return volumes, nil
 }
+
+// SumBalances sums the balances of the accounts matching the query, grouped by asset
+func (s *Store) SumBalances(ctx context.Context, q query.Query) (map[string]int64, error) {
+	balances := map[string]int64{}
+
+	in := sqlbuilder.NewSelectBuilder()
+	in.Select("asset", "amount").From(s.table("postings"))
+
+	out := sqlbuilder.NewSelectBuilder()
+	out.Select("asset", "-amount").From(s.table("postings"))
+
+	if q.HasParam("address") {
+		in.Where(hasPrefix(in, "destination", q.Params["address"].(string)))
+		out.Where(hasPrefix(out, "source", q.Params["address"].(string)))
+	}
+
+	sb := sqlbuilder.NewSelectBuilder()
+	sb.Select("asset", "sum(amount)")
+	sb.From(sb.BuilderAs(sqlbuilder.UnionAll(in, out), "movements"))
+	sb.GroupBy("asset")
+
+	sqlq, args := sb.BuildWithFlavor(s.flavor)
+	logging.FromContext(ctx).Debugln(sqlq, args)
+
+	rows, err := s.reader(ctx).QueryContext(ctx, sqlq, args...)
+	if err != nil {
+		return balances, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			asset  string
+			amount int64
+		)
+
+		err := rows.Scan(&asset, &amount)
+		if err != nil {
+			return balances, err
+		}
+
+		balances[asset] = amount
+	}
+
+	return balances, rows.Err()
+}
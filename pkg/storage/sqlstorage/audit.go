@@ -0,0 +1,68 @@
+package sqlstorage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+
+	"github.com/huandu/go-sqlbuilder"
+	"github.com/numary/ledger/pkg/audit"
+)
+
+const auditTable = `CREATE TABLE IF NOT EXISTS audit (
+  "id"           varchar,
+  "stage"        varchar,
+  "timestamp"    varchar,
+  "ledger"       varchar,
+  "principal"    varchar,
+  "ip"           varchar,
+  "method"       varchar,
+  "path"         varchar,
+  "action"       varchar,
+  "status"       integer,
+  "transactions" varchar
+)`
+
+// AuditSink writes the audit records to the audit table of a database, shared by all the ledgers
+type AuditSink struct {
+	db     *sql.DB
+	flavor Flavor
+}
+
+// NewAuditSink opens the database and creates the audit table if needed
+func NewAuditSink(ctx context.Context, flavor Flavor, where string) (*AuditSink, error) {
+	db, err := sql.Open(sqlDrivers[flavor].driverName, where)
+	if err != nil {
+		return nil, err
+	}
+	_, err = db.ExecContext(ctx, auditTable)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &AuditSink{
+		db:     db,
+		flavor: flavor,
+	}, nil
+}
+
+func (s *AuditSink) Write(ctx context.Context, record audit.Record) error {
+	transactions, err := json.Marshal(record.Transactions)
+	if err != nil {
+		return err
+	}
+
+	ib := sqlbuilder.NewInsertBuilder()
+	ib.InsertInto("audit")
+	ib.Cols("id", "stage", "timestamp", "ledger", "principal", "ip", "method", "path", "action", "status", "transactions")
+	ib.Values(record.ID, record.Stage, record.Timestamp, record.Ledger, record.Principal, record.IP,
+		record.Method, record.Path, record.Action, record.Status, string(transactions))
+
+	sqlq, args := ib.BuildWithFlavor(s.flavor)
+	_, err = s.db.ExecContext(ctx, sqlq, args...)
+	return err
+}
+
+func (s *AuditSink) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,44 @@
+package sqlstorage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/numary/ledger/pkg/audit"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewAuditSink(t *testing.T) {
+	sink, err := NewAuditSink(context.Background(), SQLite, "file:audit?mode=memory&cache=shared")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer sink.Close()
+
+	err = sink.Write(context.Background(), audit.Record{
+		ID:           "a5c6a9fa-1c2b-4c1e-9b0e-0b1a8d2c4f3e",
+		Stage:        audit.StageCompleted,
+		Timestamp:    "2022-01-01T00:00:00Z",
+		Ledger:       "quickstart",
+		Principal:    "admin",
+		IP:           "192.0.2.1",
+		Method:       "POST",
+		Path:         "/quickstart/transactions",
+		Action:       "POST /:ledger/transactions",
+		Status:       200,
+		Transactions: []int64{0, 1},
+	})
+	assert.NoError(t, err)
+
+	var (
+		principal    string
+		status       int
+		transactions string
+	)
+	err = sink.db.QueryRow(`SELECT principal, status, transactions FROM audit WHERE ledger = 'quickstart'`).
+		Scan(&principal, &status, &transactions)
+	assert.NoError(t, err)
+	assert.Equal(t, "admin", principal)
+	assert.Equal(t, 200, status)
+	assert.Equal(t, "[0,1]", transactions)
+}
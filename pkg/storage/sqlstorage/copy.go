@@ -0,0 +1,152 @@
+package sqlstorage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/stdlib"
+	"github.com/numary/ledger/pkg/core"
+	"github.com/numary/ledger/pkg/storage"
+)
+
+// defaultCopyThreshold is the number of transactions from which the PostgreSQL stores copy a batch
+// with the COPY protocol rather than inserting its rows one by one
+const defaultCopyThreshold = 100
+
+// copyTable holds the rows copied to a table
+type copyTable struct {
+	name string
+	cols []string
+	rows [][]interface{}
+}
+
+// writeTx is a sql transaction saving transactions, along with the connection it runs on,
+// through which the rows of the large batches are copied in the transaction
+type writeTx struct {
+	*sql.Tx
+	conn *sql.Conn
+}
+
+// beginWrite starts a sql transaction saving transactions on a connection of its own
+func (s *Store) beginWrite(ctx context.Context) (*writeTx, error) {
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := conn.BeginTx(ctx, s.txOptions())
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return &writeTx{
+		Tx:   tx,
+		conn: conn,
+	}, nil
+}
+
+// Commit commits the sql transaction and releases its connection
+func (tx *writeTx) Commit() error {
+	defer tx.conn.Close()
+	return tx.Tx.Commit()
+}
+
+// Rollback rolls back the sql transaction and releases its connection
+func (tx *writeTx) Rollback() error {
+	defer tx.conn.Close()
+	return tx.Tx.Rollback()
+}
+
+// copyTransactions copies the transactions, the keys of their references, their postings and their metadata
+// with the COPY protocol of PostgreSQL, in the sql transaction as it runs on the same connection. The rows are copied
+// in the order of the transactions, which hashes were chained by the ledger before saving them, so that the copied
+// chain is the inserted one.
+func (s *Store) copyTransactions(ctx context.Context, tx *writeTx, ts []core.Transaction, firstMetaID int64) error {
+	transactions := make([][]interface{}, 0, len(ts))
+	keys := make([][]interface{}, 0, len(ts))
+	postings := make([][]interface{}, 0, len(ts))
+	metadata := make([][]interface{}, 0)
+	references := make([][]interface{}, 0, len(ts))
+	scope := storage.ReferenceScopeFromContext(ctx)
+
+	nextID := firstMetaID
+	for _, t := range ts {
+		var ref *string
+		if t.Reference != "" {
+			reference := t.Reference
+			ref = &reference
+		}
+
+		timestamp, err := time.Parse(time.RFC3339, t.Timestamp)
+		if err != nil {
+			return fmt.Errorf("transaction %d: invalid timestamp: %w", t.ID, err)
+		}
+
+		transactions = append(transactions, []interface{}{t.ID, ref, timestamp, t.Timestamp, t.Hash})
+		keys = append(keys, []interface{}{t.ID, ref})
+		for _, k := range storage.ReferenceKeys(scope, t) {
+			references = append(references, []interface{}{k.Reference, k.Asset, t.ID})
+		}
+
+		for i, p := range t.Postings {
+			postings = append(postings, []interface{}{int16(i), t.ID, p.Source, p.Destination, p.Amount, p.Asset})
+		}
+
+		for key, value := range t.Metadata {
+			metadata = append(metadata, []interface{}{nextID, "transaction", fmt.Sprintf("%d", t.ID), key, string(value), t.Timestamp})
+			nextID++
+		}
+	}
+
+	// The keys of the partitioned ledgers follow the transactions, so that a conflict
+	// is reported as such rather than as a violation of the constraints of the postings
+	tables := []copyTable{
+		{
+			name: "transactions",
+			cols: []string{"id", "reference", "timestamp", "timestamp_text", "hash"},
+			rows: transactions,
+		},
+	}
+	if s.partitioned {
+		tables = append(tables, copyTable{
+			name: "transactions_keys",
+			cols: []string{"id", "reference"},
+			rows: keys,
+		})
+	}
+	tables = append(tables, []copyTable{
+		{
+			name: "transaction_references",
+			cols: []string{"reference", "asset", "txid"},
+			rows: references,
+		},
+		{
+			name: "postings",
+			cols: []string{"id", "txid", "source", "destination", "amount", "asset"},
+			rows: postings,
+		},
+		{
+			name: "metadata",
+			cols: []string{"meta_id", "meta_target_type", "meta_target_id", "meta_key", "meta_value", "timestamp"},
+			rows: metadata,
+		},
+	}...)
+
+	return tx.conn.Raw(func(driverConn interface{}) error {
+		conn := driverConn.(*stdlib.Conn).Conn()
+		for _, table := range tables {
+			if len(table.rows) == 0 {
+				continue
+			}
+			_, err := conn.CopyFrom(ctx, pgx.Identifier{s.ledger, table.name}, table.cols, pgx.CopyFromRows(table.rows))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -3,9 +3,13 @@ package sqlstorage
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"strings"
+
 	"github.com/huandu/go-sqlbuilder"
+	"github.com/mattn/go-sqlite3"
 	"github.com/numary/ledger/pkg/storage"
 )
 
@@ -16,11 +20,31 @@ var (
 	PostgreSQL = sqlbuilder.PostgreSQL
 )
 
+// SQLiteDriverName is the sqlite driver opened by the stores, registered with the functions used by their queries
+const SQLiteDriverName = "sqlite3_ledger"
+
+func init() {
+	sql.Register(SQLiteDriverName, &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			return conn.RegisterFunc("metadata_match", sqliteMetadataMatch, true)
+		},
+	})
+}
+
+// sqliteMetadataMatch is the sqlite function evaluating the metadata filters, as sqlite lacks the json operators
+func sqliteMetadataMatch(value, path, expected string) bool {
+	var segments []string
+	if path != "" {
+		segments = strings.Split(path, ".")
+	}
+	return storage.MatchMetadataValue(json.RawMessage(value), segments, expected)
+}
+
 var sqlDrivers = map[Flavor]struct {
 	driverName string
 }{
 	SQLite: {
-		driverName: "sqlite3",
+		driverName: SQLiteDriverName,
 	},
 	PostgreSQL: {
 		driverName: "pgx",
@@ -80,6 +104,12 @@ type cachedDBDriver struct {
 	where  string
 	db     *sql.DB
 	flavor Flavor
+	pool   *PoolConfig
+	// replicaWhere is the connection string of an optional read replica, see Store.reader
+	replicaWhere string
+	replica      *sql.DB
+	// partitioning partitions the transactions of the ledgers by month, see WithTransactionsPartitioning
+	partitioning bool
 }
 
 func (s *cachedDBDriver) Name() string {
@@ -93,21 +123,73 @@ func (s *cachedDBDriver) Initialize(ctx context.Context) error {
 		return errors.New("unknown flavor")
 	}
 
-	db, err := sql.Open(cfg.driverName, s.where)
+	if s.pool != nil {
+		err := s.pool.Validate(s.flavor)
+		if err != nil {
+			return fmt.Errorf("invalid %s pool configuration: %w", s.name, err)
+		}
+		s.pool.log(s.name)
+	}
+
+	db, err := s.open(cfg.driverName, s.where)
 	if err != nil {
 		return err
 	}
 	s.db = db
+
+	if s.replicaWhere != "" {
+		replica, err := s.open(cfg.driverName, s.replicaWhere)
+		if err != nil {
+			return fmt.Errorf("opening %s replica: %w", s.name, err)
+		}
+		s.replica = replica
+	}
+
 	return nil
 }
 
+// open opens a database with the pool configuration of the driver, if any
+func (s *cachedDBDriver) open(driverName, where string) (*sql.DB, error) {
+	if s.pool == nil {
+		return sql.Open(driverName, where)
+	}
+
+	var (
+		db  *sql.DB
+		err error
+	)
+	if s.flavor == PostgreSQL {
+		db, err = s.pool.open(where)
+	} else {
+		db, err = sql.Open(driverName, where)
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.pool.apply(db)
+
+	return db, nil
+}
+
 func (s *cachedDBDriver) NewStore(name string) (storage.Store, error) {
-	return NewStore(name, s.flavor, s.db, func(ctx context.Context) error {
+	store, err := NewStore(name, s.flavor, s.db, func(ctx context.Context) error {
 		return nil
 	})
+	if err != nil {
+		return nil, err
+	}
+	store.replica = s.replica
+	store.partitioning = s.partitioning
+	return store, nil
 }
 
 func (d *cachedDBDriver) Close(ctx context.Context) error {
+	if d.replica != nil {
+		err := d.replica.Close()
+		if err != nil {
+			return err
+		}
+	}
 	if d.db == nil {
 		return nil
 	}
@@ -116,19 +198,40 @@ func (d *cachedDBDriver) Close(ctx context.Context) error {
 
 const SQLiteMemoryConnString = "file::memory:?cache=shared"
 
+// SQLiteFileConnString returns the connection string of the SQLite database stored at path, with the default configuration
 func SQLiteFileConnString(path string) string {
-	return fmt.Sprintf(
-		"file:%s?_journal=WAL",
-		path,
-	)
+	return DefaultSQLiteConfig.ConnString(path)
 }
 
-func NewCachedDBDriver(name string, flavor Flavor, where string) *cachedDBDriver {
-	return &cachedDBDriver{
+type CachedDBDriverOption func(d *cachedDBDriver)
+
+// WithReplica sends the read only queries to a replica of the database, unless they must be consistent
+// with the writes, see storage.WithConsistentRead. The replica gets the same pool configuration.
+func WithReplica(where string) CachedDBDriverOption {
+	return func(d *cachedDBDriver) {
+		d.replicaWhere = where
+	}
+}
+
+// WithTransactionsPartitioning partitions the transactions table of the PostgreSQL ledgers by month,
+// converting the existing ledgers when their stores are initialized. The ledgers already partitioned
+// stay partitioned without it.
+func WithTransactionsPartitioning() CachedDBDriverOption {
+	return func(d *cachedDBDriver) {
+		d.partitioning = true
+	}
+}
+
+func NewCachedDBDriver(name string, flavor Flavor, where string, options ...CachedDBDriverOption) *cachedDBDriver {
+	d := &cachedDBDriver{
 		where:  where,
 		name:   name,
 		flavor: flavor,
 	}
+	for _, opt := range options {
+		opt(d)
+	}
+	return d
 }
 
 func NewInMemorySQLiteDriver() *cachedDBDriver {
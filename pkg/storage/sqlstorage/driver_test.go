@@ -2,8 +2,12 @@ package sqlstorage
 
 import (
 	"context"
+	"fmt"
+	"github.com/numary/ledger/pkg/core"
+	"github.com/numary/ledger/pkg/storage"
 	"github.com/stretchr/testify/assert"
 	"testing"
+	"time"
 )
 
 func TestNewOpenCloseDBDriver(t *testing.T) {
@@ -43,3 +47,67 @@ func TestNewCachedDBDriver(t *testing.T) {
 	_, err = store.(*Store).db.Query("select * from transactions")
 	assert.NoError(t, err, "database should have been closed")
 }
+
+func TestCachedDBDriverPool(t *testing.T) {
+	d := NewCachedDBDriver("sqlite", SQLite, SQLiteMemoryConnString, WithPool(PoolConfig{
+		MaxConns:        5,
+		MinConns:        1,
+		MaxConnLifetime: time.Hour,
+	}))
+	err := d.Initialize(context.Background())
+	assert.NoError(t, err)
+	defer d.Close(context.Background())
+
+	assert.Equal(t, 5, d.db.Stats().MaxOpenConnections)
+
+	for _, cfg := range []PoolConfig{
+		{MaxConns: -1},
+		{MaxConns: 1, MinConns: 2},
+		{MaxConnIdleTime: -time.Second},
+		{StatementTimeout: time.Second},
+	} {
+		d := NewCachedDBDriver("sqlite", SQLite, SQLiteMemoryConnString, WithPool(cfg))
+		assert.Error(t, d.Initialize(context.Background()), cfg)
+	}
+}
+
+func TestCachedDBDriverReplica(t *testing.T) {
+	primary := fmt.Sprintf("file:primary_%d?mode=memory&cache=shared", time.Now().UnixNano())
+	replica := fmt.Sprintf("file:replica_%d?mode=memory&cache=shared", time.Now().UnixNano())
+
+	d := NewCachedDBDriver("sqlite", SQLite, primary, WithReplica(replica))
+	err := d.Initialize(context.Background())
+	assert.NoError(t, err)
+	defer d.Close(context.Background())
+
+	store, err := d.NewStore("foo")
+	assert.NoError(t, err)
+	assert.NoError(t, store.Initialize(context.Background()))
+
+	// The replica is left behind
+	replicaStore, err := NewStore("foo", SQLite, d.replica, func(ctx context.Context) error {
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, replicaStore.Initialize(context.Background()))
+
+	err = store.SaveTransactions(context.Background(), []core.Transaction{{
+		Postings: []core.Posting{
+			{Source: "world", Destination: "central_bank", Amount: 100, Asset: "USD"},
+		},
+		Timestamp: time.Now().Format(time.RFC3339),
+	}})
+	assert.NoError(t, err)
+
+	count, err := store.CountTransactions(context.Background())
+	assert.NoError(t, err)
+	assert.EqualValues(t, 0, count)
+
+	count, err = store.CountTransactions(storage.WithConsistentRead(context.Background()))
+	assert.NoError(t, err)
+	assert.EqualValues(t, 1, count)
+
+	last, err := store.LastTransaction(context.Background())
+	assert.NoError(t, err)
+	assert.NotNil(t, last)
+}
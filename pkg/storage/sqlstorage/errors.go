@@ -0,0 +1,45 @@
+package sqlstorage
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgconn"
+	"github.com/mattn/go-sqlite3"
+	"github.com/numary/ledger/pkg/storage"
+)
+
+// translateError wraps the database errors caused by concurrent writes into storage.ErrConflict,
+// and the violations of the unique constraint on the keys of the transactions references into storage.ErrDuplicateReference.
+// The constraints of the ids of the partitioned ledgers are the ones of their transactions_keys table.
+func translateError(err error) error {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		switch {
+		case sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique && strings.Contains(sqliteErr.Error(), "transaction_references.reference"):
+			return fmt.Errorf("%w: %s", storage.ErrDuplicateReference, err)
+		case sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique && strings.Contains(sqliteErr.Error(), "transactions.id"),
+			sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique && strings.Contains(sqliteErr.Error(), "scripts.name"),
+			sqliteErr.Code == sqlite3.ErrBusy,
+			sqliteErr.Code == sqlite3.ErrLocked:
+			return fmt.Errorf("%w: %s", storage.ErrConflict, err)
+		}
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch {
+		case pgErr.Code == "23505" && pgErr.ConstraintName == "transaction_references_reference_asset_key":
+			return fmt.Errorf("%w: %s", storage.ErrDuplicateReference, err)
+		case pgErr.Code == "23505" && (pgErr.ConstraintName == "transactions_id_key" ||
+			pgErr.ConstraintName == "transactions_keys_id_key" ||
+			pgErr.ConstraintName == "scripts_name_version_key"),
+			pgErr.Code == "40001", // serialization_failure
+			pgErr.Code == "40P01": // deadlock_detected
+			return fmt.Errorf("%w: %s", storage.ErrConflict, err)
+		}
+	}
+
+	return err
+}
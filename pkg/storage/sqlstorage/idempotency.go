@@ -0,0 +1,94 @@
+package sqlstorage
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/huandu/go-sqlbuilder"
+	"github.com/numary/ledger/pkg/core"
+	"github.com/numary/ledger/pkg/logging"
+	"github.com/numary/ledger/pkg/storage"
+)
+
+func (s *Store) GetIdempotencyKey(ctx context.Context, key string) (*storage.IdempotencyKey, error) {
+	sb := sqlbuilder.NewSelectBuilder()
+	sb.Select("key", "first_txid", "last_txid", "timestamp")
+	sb.From(s.table("idempotency_keys"))
+	sb.Where(sb.Equal("key", key))
+
+	sqlq, args := sb.BuildWithFlavor(s.flavor)
+	logging.FromContext(ctx).Debugln(sqlq, args)
+
+	ik := storage.IdempotencyKey{}
+	err := s.reader(ctx).QueryRowContext(ctx, sqlq, args...).Scan(
+		&ik.Key,
+		&ik.FirstTxID,
+		&ik.LastTxID,
+		&ik.Timestamp,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &ik, nil
+}
+
+// SaveTransactionsWithKey saves the transactions and records the idempotency key in the same sql transaction,
+// replacing any previous (expired) record of the key.
+func (s *Store) SaveTransactionsWithKey(ctx context.Context, key string, ts []core.Transaction) error {
+	if len(ts) == 0 {
+		return nil
+	}
+
+	err := s.ensurePartitions(ctx, ts)
+	if err != nil {
+		return translateError(err)
+	}
+
+	tx, err := s.beginWrite(ctx)
+	if err != nil {
+		return translateError(err)
+	}
+
+	err = s.saveTransactions(ctx, tx, ts)
+	if err != nil {
+		tx.Rollback()
+
+		return translateError(err)
+	}
+
+	db := sqlbuilder.NewDeleteBuilder()
+	db.DeleteFrom(s.table("idempotency_keys"))
+	db.Where(db.Equal("key", key))
+
+	sqlq, args := db.BuildWithFlavor(s.flavor)
+	logging.FromContext(ctx).Debugln(sqlq, args)
+
+	_, err = tx.ExecContext(ctx, sqlq, args...)
+	if err != nil {
+		tx.Rollback()
+
+		return translateError(err)
+	}
+
+	ib := sqlbuilder.NewInsertBuilder()
+	ib.InsertInto(s.table("idempotency_keys"))
+	ib.Cols("key", "first_txid", "last_txid", "timestamp")
+	ib.Values(key, ts[0].ID, ts[len(ts)-1].ID, time.Now().UTC().Format(time.RFC3339))
+
+	sqlq, args = ib.BuildWithFlavor(s.flavor)
+	logging.FromContext(ctx).Debugln(sqlq, args)
+
+	_, err = tx.ExecContext(ctx, sqlq, args...)
+	if err != nil {
+		tx.Rollback()
+
+		return translateError(err)
+	}
+
+	return translateError(tx.Commit())
+}
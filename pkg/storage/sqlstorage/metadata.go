@@ -2,18 +2,41 @@ package sqlstorage
 
 import (
 	"context"
+	"database/sql"
 	"encoding/json"
 	"github.com/huandu/go-sqlbuilder"
 	"github.com/numary/ledger/pkg/core"
-	"github.com/sirupsen/logrus"
+	"github.com/numary/ledger/pkg/logging"
+	"github.com/numary/ledger/pkg/storage"
 )
 
 func (s *Store) LastMetaID(ctx context.Context) (int64, error) {
-	count, err := s.CountMeta(ctx)
+	return s.lastMetaID(ctx, s.db)
+}
+
+type queryRower interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// lastMetaID returns the highest metadata id, or -1 if there is no metadata.
+// It does not rely on the number of rows as metadata can be deleted.
+func (s *Store) lastMetaID(ctx context.Context, db queryRower) (int64, error) {
+	var id sql.NullInt64
+
+	sb := sqlbuilder.NewSelectBuilder()
+	sb.Select("max(meta_id)").From(s.table("metadata"))
+
+	sqlq, args := sb.BuildWithFlavor(s.flavor)
+	logging.FromContext(ctx).Debugln(sqlq, args)
+
+	err := db.QueryRowContext(ctx, sqlq, args...).Scan(&id)
 	if err != nil {
 		return 0, err
 	}
-	return count - 1, nil
+	if !id.Valid {
+		return -1, nil
+	}
+	return id.Int64, nil
 }
 
 func (s *Store) GetMeta(ctx context.Context, ty string, id string) (core.Metadata, error) {
@@ -29,15 +52,17 @@ func (s *Store) GetMeta(ctx context.Context, ty string, id string) (core.Metadat
 			sb.Equal("meta_target_id", id),
 		),
 	)
+	sb.OrderBy("meta_id asc")
 
 	sqlq, args := sb.BuildWithFlavor(s.flavor)
-	logrus.Debugln(sqlq, args)
+	logging.FromContext(ctx).Debugln(sqlq, args)
 
-	rows, err := s.db.QueryContext(ctx, sqlq, args...)
+	rows, err := s.reader(ctx).QueryContext(ctx, sqlq, args...)
 
 	if err != nil {
-		return nil, err
+		return nil, translateError(err)
 	}
+	defer rows.Close()
 
 	meta := core.Metadata{}
 
@@ -64,51 +89,314 @@ func (s *Store) GetMeta(ctx context.Context, ty string, id string) (core.Metadat
 
 		meta[metaKey] = value
 	}
+	if err := rows.Err(); err != nil {
+		return nil, translateError(err)
+	}
 
 	return meta, nil
 }
 
-func (s *Store) SaveMeta(ctx context.Context, id int64, timestamp, targetType, targetID, key, value string) error {
-	tx, err := s.db.BeginTx(ctx, nil)
+func (s *Store) FindMetaTargets(ctx context.Context, ty string) ([]string, error) {
+	sb := sqlbuilder.NewSelectBuilder()
+	sb.Select("meta_target_id").Distinct()
+	sb.From(s.table("metadata"))
+	sb.Where(sb.Equal("meta_target_type", ty))
+	sb.OrderBy("meta_target_id")
+
+	sqlq, args := sb.BuildWithFlavor(s.flavor)
+	logging.FromContext(ctx).Debugln(sqlq, args)
+
+	rows, err := s.reader(ctx).QueryContext(ctx, sqlq, args...)
 	if err != nil {
-		return err
+		return nil, translateError(err)
 	}
+	defer rows.Close()
 
-	ib := sqlbuilder.NewInsertBuilder()
-	ib.InsertInto(s.table("metadata"))
-	ib.Cols(
-		"meta_id",
-		"meta_target_type",
+	ids := []string{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, translateError(err)
+	}
+
+	return ids, nil
+}
+
+// metaOf returns the metadata of many targets of a same type, by target id.
+// The targets without metadata are missing from the map.
+func (s *Store) metaOf(ctx context.Context, ty string, ids []string) (map[string]core.Metadata, error) {
+	metas := map[string]core.Metadata{}
+	if len(ids) == 0 {
+		return metas, nil
+	}
+
+	targets := make([]interface{}, len(ids))
+	for i, id := range ids {
+		targets[i] = id
+	}
+
+	sb := sqlbuilder.NewSelectBuilder()
+	sb.Select(
 		"meta_target_id",
 		"meta_key",
 		"meta_value",
-		"timestamp",
 	)
-	ib.Values(
-		id,
-		targetType,
-		targetID,
-		key,
-		value,
-		timestamp,
+	sb.From(s.table("metadata"))
+	sb.Where(
+		sb.And(
+			sb.Equal("meta_target_type", ty),
+			sb.In("meta_target_id", targets...),
+		),
 	)
+	sb.OrderBy("meta_id asc")
 
-	sqlq, args := ib.BuildWithFlavor(s.flavor)
-	logrus.Debugln(sqlq, args)
+	sqlq, args := sb.BuildWithFlavor(s.flavor)
+	logging.FromContext(ctx).Debugln(sqlq, args)
+
+	rows, err := s.reader(ctx).QueryContext(ctx, sqlq, args...)
+	if err != nil {
+		return nil, translateError(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			targetID  string
+			metaKey   string
+			metaValue string
+		)
+
+		err := rows.Scan(&targetID, &metaKey, &metaValue)
+		if err != nil {
+			return nil, err
+		}
+
+		var value json.RawMessage
+		err = json.Unmarshal([]byte(metaValue), &value)
+		if err != nil {
+			return nil, err
+		}
+
+		if _, ok := metas[targetID]; !ok {
+			metas[targetID] = core.Metadata{}
+		}
+		metas[targetID][metaKey] = value
+	}
+	if err := rows.Err(); err != nil {
+		return nil, translateError(err)
+	}
+
+	return metas, nil
+}
+
+func (s *Store) SaveMeta(ctx context.Context, id int64, timestamp, targetType, targetID, key, value string) error {
+	return s.SaveMetaBatch(ctx, []storage.MetaEntry{
+		{
+			ID:         id,
+			Timestamp:  timestamp,
+			TargetType: targetType,
+			TargetID:   targetID,
+			Key:        key,
+			Value:      value,
+		},
+	})
+}
+
+// metaBatchSize bounds the number of rows inserted by a single statement,
+// to stay below the bind parameters limits of the database engines
+const metaBatchSize = 1000
 
-	_, err = tx.ExecContext(ctx, sqlq, args...)
+func (s *Store) SaveMetaBatch(ctx context.Context, entries []storage.MetaEntry) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	err = s.saveMetaBatch(ctx, tx, entries)
 	if err != nil {
-		logrus.Debugln("failed to save metadata", err)
+		logging.FromContext(ctx).Debugln("failed to save metadata", err)
 		tx.Rollback()
 
 		return err
 	}
 
-	err = tx.Commit()
+	return tx.Commit()
+}
+
+// saveMetaBatch inserts the metadata entries using the provided sql transaction.
+// The caller is responsible for committing or rolling back tx.
+func (s *Store) saveMetaBatch(ctx context.Context, tx *sql.Tx, entries []storage.MetaEntry) error {
+	for start := 0; start < len(entries); start += metaBatchSize {
+		end := start + metaBatchSize
+		if end > len(entries) {
+			end = len(entries)
+		}
+
+		ib := sqlbuilder.NewInsertBuilder()
+		ib.InsertInto(s.table("metadata"))
+		ib.Cols(
+			"meta_id",
+			"meta_target_type",
+			"meta_target_id",
+			"meta_key",
+			"meta_value",
+			"timestamp",
+		)
+		for _, e := range entries[start:end] {
+			ib.Values(
+				e.ID,
+				e.TargetType,
+				e.TargetID,
+				e.Key,
+				e.Value,
+				e.Timestamp,
+			)
+		}
+
+		sqlq, args := ib.BuildWithFlavor(s.flavor)
+		logging.FromContext(ctx).Debugln(sqlq, args)
+
+		_, err := tx.ExecContext(ctx, sqlq, args...)
+		if err != nil {
+			return err
+		}
+	}
+
+	version, ok := storage.MetaVersionFromContext(ctx)
+	for _, target := range storage.MetaTargets(entries) {
+		var err error
+		if ok {
+			err = s.swapMetaVersion(ctx, tx, target.Type, target.ID, version)
+		} else {
+			err = s.updateMetaVersion(ctx, tx, target.Type, target.ID)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *Store) DeleteMeta(ctx context.Context, targetType, targetID string, keys []string) error {
+	if len(keys) == 0 {
+		return nil
+	}
 
+	args := make([]interface{}, 0, len(keys))
+	for _, key := range keys {
+		args = append(args, key)
+	}
+
+	db := sqlbuilder.NewDeleteBuilder()
+	db.DeleteFrom(s.table("metadata"))
+	db.Where(
+		db.Equal("meta_target_type", targetType),
+		db.Equal("meta_target_id", targetID),
+		db.In("meta_key", args...),
+	)
+
+	sqlq, sqlargs := db.BuildWithFlavor(s.flavor)
+	logging.FromContext(ctx).Debugln(sqlq, sqlargs)
+
+	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
 
+	_, err = tx.ExecContext(ctx, sqlq, sqlargs...)
+	if err == nil {
+		err = s.updateMetaVersion(ctx, tx, targetType, targetID)
+	}
+	if err != nil {
+		tx.Rollback()
+
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *Store) GetMetaVersion(ctx context.Context, targetType, targetID string) (int64, error) {
+	var version int64
+
+	sb := sqlbuilder.NewSelectBuilder()
+	sb.Select("version")
+	sb.From(s.table("metadata_versions"))
+	sb.Where(
+		sb.Equal("target_type", targetType),
+		sb.Equal("target_id", targetID),
+	)
+
+	sqlq, args := sb.BuildWithFlavor(s.flavor)
+	logging.FromContext(ctx).Debugln(sqlq, args)
+
+	err := s.reader(ctx).QueryRowContext(ctx, sqlq, args...).Scan(&version)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return version, translateError(err)
+}
+
+// swapMetaVersion increments the version of the metadata of a target if it is still at version using the provided
+// sql transaction, and fails with storage.ErrMetaVersionMismatch otherwise. The version is compared by the write
+// itself rather than read beforehand, so that a concurrent update from the same version matches no row.
+func (s *Store) swapMetaVersion(ctx context.Context, tx *sql.Tx, targetType, targetID string, version int64) error {
+	var (
+		sqlq string
+		args []interface{}
+	)
+	if version == 0 {
+		// The versions start at 1, a target without version is inserted
+		ib := sqlbuilder.NewInsertBuilder()
+		ib.InsertInto(s.table("metadata_versions"))
+		ib.Cols("target_type", "target_id", "version")
+		ib.Values(targetType, targetID, 1)
+		ib.SQL("ON CONFLICT (target_type, target_id) DO NOTHING")
+		sqlq, args = ib.BuildWithFlavor(s.flavor)
+	} else {
+		ub := sqlbuilder.NewUpdateBuilder()
+		ub.Update(s.table("metadata_versions"))
+		ub.Set(ub.Incr("version"))
+		ub.Where(
+			ub.Equal("target_type", targetType),
+			ub.Equal("target_id", targetID),
+			ub.Equal("version", version),
+		)
+		sqlq, args = ub.BuildWithFlavor(s.flavor)
+	}
+	logging.FromContext(ctx).Debugln(sqlq, args)
+
+	res, err := tx.ExecContext(ctx, sqlq, args...)
+	if err != nil {
+		return translateError(err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return storage.ErrMetaVersionMismatch
+	}
 	return nil
 }
+
+// updateMetaVersion increments the version of the metadata of a target using the provided sql transaction
+func (s *Store) updateMetaVersion(ctx context.Context, tx *sql.Tx, targetType, targetID string) error {
+	ib := sqlbuilder.NewInsertBuilder()
+	ib.InsertInto(s.table("metadata_versions"))
+	ib.Cols("target_type", "target_id", "version")
+	ib.Values(targetType, targetID, 1)
+	ib.SQL("ON CONFLICT (target_type, target_id) DO UPDATE SET version = metadata_versions.version + 1")
+
+	sqlq, args := ib.BuildWithFlavor(s.flavor)
+	logging.FromContext(ctx).Debugln(sqlq, args)
+
+	_, err := tx.ExecContext(ctx, sqlq, args...)
+	return err
+}
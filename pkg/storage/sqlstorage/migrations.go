@@ -0,0 +1,226 @@
+package sqlstorage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/huandu/go-sqlbuilder"
+	"github.com/sirupsen/logrus"
+)
+
+// ErrSchemaTooRecent is returned when initializing a store which schema was migrated by a more recent version
+var ErrSchemaTooRecent = errors.New("schema version not supported")
+
+var migrationNameRegexp = regexp.MustCompile(`^v(\d+)\.sql$`)
+
+// Migration is a version of the schema of the ledgers, read from the embedded migrations directory
+type Migration struct {
+	Version    int
+	Name       string
+	Statements []string
+}
+
+// readMigrations returns the migrations of a flavor, sorted by version
+func readMigrations(flavor sqlbuilder.Flavor) ([]Migration, error) {
+	dir := fmt.Sprintf("migrations/%s", strings.ToLower(flavor.String()))
+
+	entries, err := migrations.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	ms := make([]Migration, 0, len(entries))
+	for _, entry := range entries {
+		match := migrationNameRegexp.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("invalid migration name %s: expected vXXX.sql", entry.Name())
+		}
+		version, _ := strconv.Atoi(match[1])
+
+		b, err := migrations.ReadFile(path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		m := Migration{
+			Version: version,
+			Name:    entry.Name(),
+		}
+		for _, statement := range strings.Split(string(b), "--statement") {
+			if strings.TrimSpace(statement) != "" {
+				m.Statements = append(m.Statements, statement)
+			}
+		}
+		ms = append(ms, m)
+	}
+	sort.Slice(ms, func(i, j int) bool {
+		return ms[i].Version < ms[j].Version
+	})
+
+	return ms, nil
+}
+
+// migrationsTableExists reports whether the table of the applied migrations was created
+func (s *Store) migrationsTableExists(ctx context.Context) (bool, error) {
+	var (
+		sqlq string
+		args []interface{}
+	)
+	switch s.flavor {
+	case sqlbuilder.PostgreSQL:
+		sqlq = `SELECT count(*) FROM information_schema.tables WHERE table_schema = $1 AND table_name = 'migrations'`
+		args = []interface{}{s.ledger}
+	default:
+		sqlq = `SELECT count(*) FROM sqlite_master WHERE type = 'table' AND name = 'migrations'`
+	}
+
+	var count int
+	err := s.db.QueryRowContext(ctx, sqlq, args...).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// appliedMigrations returns the versions of the migrations applied to the schema
+func (s *Store) appliedMigrations(ctx context.Context) (map[int]struct{}, error) {
+	applied := map[int]struct{}{}
+
+	exists, err := s.migrationsTableExists(ctx)
+	if err != nil || !exists {
+		return applied, err
+	}
+
+	sb := sqlbuilder.NewSelectBuilder()
+	sb.Select("version").From(s.table("migrations"))
+
+	sqlq, args := sb.BuildWithFlavor(s.flavor)
+	rows, err := s.db.QueryContext(ctx, sqlq, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var version int
+		err := rows.Scan(&version)
+		if err != nil {
+			return nil, err
+		}
+		applied[version] = struct{}{}
+	}
+
+	return applied, rows.Err()
+}
+
+// PendingMigrations returns the migrations Initialize would apply, in order, without applying them.
+// It fails with ErrSchemaTooRecent if the schema was migrated to a version this one doesn't know.
+func (s *Store) PendingMigrations(ctx context.Context) ([]Migration, error) {
+	ms, err := readMigrations(s.flavor)
+	if err != nil {
+		return nil, err
+	}
+
+	applied, err := s.appliedMigrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	latest := 0
+	if len(ms) > 0 {
+		latest = ms[len(ms)-1].Version
+	}
+	for version := range applied {
+		if version > latest {
+			return nil, fmt.Errorf("%w: ledger %s is at version %d, the latest known version is %d",
+				ErrSchemaTooRecent, s.ledger, version, latest)
+		}
+	}
+
+	pending := make([]Migration, 0)
+	for _, m := range ms {
+		if _, ok := applied[m.Version]; !ok {
+			pending = append(pending, m)
+		}
+	}
+
+	return pending, nil
+}
+
+// migrate applies the pending migrations, each in a transaction recording its version,
+// so that the schema is never left between two versions
+func (s *Store) migrate(ctx context.Context) error {
+	var bootstrap []string
+	if s.flavor == sqlbuilder.PostgreSQL {
+		bootstrap = append(bootstrap, fmt.Sprintf(`CREATE SCHEMA IF NOT EXISTS "%s"`, s.ledger))
+	}
+	bootstrap = append(bootstrap, fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+  "version" integer,
+  "date"    varchar,
+
+  UNIQUE("version")
+)`, s.table("migrations")))
+
+	for _, statement := range bootstrap {
+		_, err := s.db.ExecContext(ctx, statement)
+		if err != nil {
+			return err
+		}
+	}
+
+	pending, err := s.PendingMigrations(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range pending {
+		logrus.Infof("ledger %s: applying migration %s", s.ledger, m.Name)
+
+		err := s.applyMigration(ctx, m)
+		if err != nil {
+			err = fmt.Errorf("failed to apply migration %s: %w", m.Name, err)
+			logrus.Errorln(err)
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *Store) applyMigration(ctx context.Context, m Migration) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for i, statement := range m.Statements {
+		statement = strings.ReplaceAll(statement, "VAR_LEDGER_NAME", s.ledger)
+
+		logrus.Debugf("running statement: %s", statement)
+		_, err = tx.ExecContext(ctx, statement)
+		if err != nil {
+			return fmt.Errorf("failed to run statement %d: %w", i, err)
+		}
+	}
+
+	ib := sqlbuilder.NewInsertBuilder()
+	ib.InsertInto(s.table("migrations"))
+	ib.Cols("version", "date")
+	ib.Values(m.Version, time.Now().UTC().Format(time.RFC3339))
+
+	sqlq, args := ib.BuildWithFlavor(s.flavor)
+	_, err = tx.ExecContext(ctx, sqlq, args...)
+	if err != nil {
+		return translateError(err)
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,9 @@
+--statement
+CREATE TABLE IF NOT EXISTS "VAR_LEDGER_NAME".idempotency_keys (
+  "key"        varchar NOT NULL CHECK (key <> ''),
+  "first_txid" bigint,
+  "last_txid"  bigint,
+  "timestamp"  varchar,
+
+  UNIQUE("key")
+);
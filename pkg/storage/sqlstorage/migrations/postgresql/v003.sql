@@ -0,0 +1,32 @@
+--statement
+ALTER TABLE "VAR_LEDGER_NAME".transactions ADD COLUMN IF NOT EXISTS "timestamp_text" varchar;
+--statement
+CREATE OR REPLACE FUNCTION "VAR_LEDGER_NAME".parse_timestamp(v varchar) RETURNS timestamptz AS $$
+BEGIN
+  RETURN v::timestamptz;
+EXCEPTION WHEN others THEN
+  RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+--statement
+DO $$
+BEGIN
+  IF (
+    SELECT data_type FROM information_schema.columns
+    WHERE table_schema = 'VAR_LEDGER_NAME' AND table_name = 'transactions' AND column_name = 'timestamp'
+  ) <> 'timestamp with time zone' THEN
+    UPDATE "VAR_LEDGER_NAME".transactions SET "timestamp_text" = "timestamp" WHERE "timestamp_text" IS NULL;
+    ALTER TABLE "VAR_LEDGER_NAME".transactions
+      ALTER COLUMN "timestamp" TYPE timestamptz USING "VAR_LEDGER_NAME".parse_timestamp("timestamp");
+  ELSE
+    UPDATE "VAR_LEDGER_NAME".transactions
+      SET "timestamp_text" = to_char("timestamp" AT TIME ZONE 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS"Z"')
+      WHERE "timestamp_text" IS NULL;
+  END IF;
+END $$;
+--statement
+DROP FUNCTION "VAR_LEDGER_NAME".parse_timestamp(varchar);
+--statement
+CREATE INDEX IF NOT EXISTS t_ts ON "VAR_LEDGER_NAME".transactions (
+  "timestamp"
+);
@@ -0,0 +1,8 @@
+--statement
+CREATE TABLE IF NOT EXISTS "VAR_LEDGER_NAME".metadata_versions (
+  "target_type" varchar,
+  "target_id"   varchar,
+  "version"     bigint,
+
+  UNIQUE("target_type", "target_id")
+);
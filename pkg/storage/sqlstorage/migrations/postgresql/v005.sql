@@ -0,0 +1,11 @@
+--statement
+CREATE INDEX IF NOT EXISTS m_i1 ON "VAR_LEDGER_NAME".metadata (
+  "meta_target_type",
+  "meta_key",
+  "meta_target_id",
+  "meta_id"
+);
+--statement
+CREATE INDEX IF NOT EXISTS m_i2 ON "VAR_LEDGER_NAME".metadata USING GIN (
+  (meta_value::jsonb)
+);
@@ -0,0 +1,6 @@
+--statement
+DROP INDEX IF EXISTS "VAR_LEDGER_NAME".m_i2;
+--statement
+CREATE INDEX IF NOT EXISTS m_i3 ON "VAR_LEDGER_NAME".metadata USING GIN (
+  (meta_value::jsonb) jsonb_path_ops
+);
@@ -0,0 +1,16 @@
+--statement
+CREATE TABLE IF NOT EXISTS "VAR_LEDGER_NAME".volumes (
+  "account" varchar,
+  "asset"   varchar,
+  "input"   bigint,
+  "output"  bigint,
+
+  UNIQUE("account", "asset")
+);
+--statement
+INSERT INTO "VAR_LEDGER_NAME".volumes ("account", "asset", "input", "output")
+SELECT "account", "asset", sum("input"), sum("output") FROM (
+  SELECT destination as "account", asset as "asset", amount as "input", 0 as "output" FROM "VAR_LEDGER_NAME".postings
+  UNION ALL
+  SELECT source as "account", asset as "asset", 0 as "input", amount as "output" FROM "VAR_LEDGER_NAME".postings
+) movements GROUP BY "account", "asset";
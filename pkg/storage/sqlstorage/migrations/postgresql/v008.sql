@@ -0,0 +1,9 @@
+--statement
+CREATE TABLE IF NOT EXISTS "VAR_LEDGER_NAME".scripts (
+  "name"      varchar,
+  "version"   bigint,
+  "plain"     text,
+  "timestamp" varchar,
+
+  UNIQUE("name", "version")
+);
@@ -0,0 +1,25 @@
+--statement
+CREATE TABLE IF NOT EXISTS "VAR_LEDGER_NAME".webhooks (
+  "seq"       bigserial,
+  "id"        varchar,
+  "url"       varchar,
+  "secret"    varchar,
+  "accounts"  varchar,
+  "last_txid" bigint,
+  "timestamp" varchar,
+
+  UNIQUE("id")
+);
+--statement
+CREATE TABLE IF NOT EXISTS "VAR_LEDGER_NAME".webhook_deliveries (
+  "seq"         bigserial,
+  "webhook_id"  varchar,
+  "txid"        bigint,
+  "status"      varchar,
+  "attempts"    integer,
+  "status_code" integer,
+  "error"       text,
+  "timestamp"   varchar
+);
+--statement
+CREATE INDEX IF NOT EXISTS webhook_deliveries_webhook_id ON "VAR_LEDGER_NAME".webhook_deliveries ("webhook_id");
@@ -0,0 +1,21 @@
+--statement
+-- The references are kept unique by the keys of their scope, see storage.ReferenceKeys
+CREATE TABLE IF NOT EXISTS "VAR_LEDGER_NAME".transaction_references (
+  "reference" varchar NOT NULL,
+  "asset"     varchar NOT NULL,
+  "txid"      bigint,
+
+  UNIQUE("reference", "asset")
+);
+--statement
+INSERT INTO "VAR_LEDGER_NAME".transaction_references ("reference", "asset", "txid")
+SELECT "reference", '', "id" FROM "VAR_LEDGER_NAME".transactions WHERE "reference" IS NOT NULL AND "reference" <> ''
+ON CONFLICT DO NOTHING;
+--statement
+ALTER TABLE "VAR_LEDGER_NAME".transactions DROP CONSTRAINT IF EXISTS transactions_reference_key;
+--statement
+ALTER TABLE IF EXISTS "VAR_LEDGER_NAME".transactions_keys DROP CONSTRAINT IF EXISTS transactions_keys_reference_key;
+--statement
+CREATE INDEX IF NOT EXISTS t_ref ON "VAR_LEDGER_NAME".transactions (
+  "reference"
+);
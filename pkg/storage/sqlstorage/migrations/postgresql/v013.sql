@@ -0,0 +1,11 @@
+--statement
+-- The assets of the postings, read by the commits checking the scales of the assets, see Store.FindAssetsOf
+CREATE TABLE IF NOT EXISTS "VAR_LEDGER_NAME".assets (
+  "asset" varchar NOT NULL,
+
+  UNIQUE("asset")
+);
+--statement
+INSERT INTO "VAR_LEDGER_NAME".assets ("asset")
+SELECT DISTINCT "asset" FROM "VAR_LEDGER_NAME".volumes
+ON CONFLICT DO NOTHING;
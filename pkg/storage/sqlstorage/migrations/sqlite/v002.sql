@@ -0,0 +1,9 @@
+--statement
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+  "key"        varchar,
+  "first_txid" integer,
+  "last_txid"  integer,
+  "timestamp"  varchar,
+
+  UNIQUE("key")
+);
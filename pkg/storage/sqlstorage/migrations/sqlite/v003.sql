@@ -0,0 +1,4 @@
+--statement
+CREATE INDEX IF NOT EXISTS 't_ts' ON "transactions" (
+  "timestamp"
+);
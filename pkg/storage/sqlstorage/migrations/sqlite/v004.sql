@@ -0,0 +1,8 @@
+--statement
+CREATE TABLE IF NOT EXISTS metadata_versions (
+  "target_type" varchar,
+  "target_id"   varchar,
+  "version"     integer,
+
+  UNIQUE("target_type", "target_id")
+);
@@ -0,0 +1,7 @@
+--statement
+CREATE INDEX IF NOT EXISTS 'm_i1' ON "metadata" (
+  "meta_target_type",
+  "meta_key",
+  "meta_target_id",
+  "meta_id"
+);
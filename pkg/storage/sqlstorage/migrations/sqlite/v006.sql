@@ -0,0 +1,16 @@
+--statement
+CREATE TABLE IF NOT EXISTS volumes (
+  "account" varchar,
+  "asset"   varchar,
+  "input"   integer,
+  "output"  integer,
+
+  UNIQUE("account", "asset")
+);
+--statement
+INSERT INTO volumes ("account", "asset", "input", "output")
+SELECT "account", "asset", sum("input"), sum("output") FROM (
+  SELECT destination as "account", asset as "asset", amount as "input", 0 as "output" FROM postings
+  UNION ALL
+  SELECT source as "account", asset as "asset", 0 as "input", amount as "output" FROM postings
+) movements GROUP BY "account", "asset";
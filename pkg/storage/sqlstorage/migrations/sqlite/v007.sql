@@ -0,0 +1,9 @@
+--statement
+CREATE TABLE IF NOT EXISTS scripts (
+  "name"      varchar,
+  "version"   integer,
+  "plain"     text,
+  "timestamp" varchar,
+
+  UNIQUE("name", "version")
+);
@@ -0,0 +1,25 @@
+--statement
+CREATE TABLE IF NOT EXISTS webhooks (
+  "seq"       integer primary key autoincrement,
+  "id"        varchar,
+  "url"       varchar,
+  "secret"    varchar,
+  "accounts"  varchar,
+  "last_txid" integer,
+  "timestamp" varchar,
+
+  UNIQUE("id")
+);
+--statement
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+  "seq"         integer primary key autoincrement,
+  "webhook_id"  varchar,
+  "txid"        integer,
+  "status"      varchar,
+  "attempts"    integer,
+  "status_code" integer,
+  "error"       text,
+  "timestamp"   varchar
+);
+--statement
+CREATE INDEX IF NOT EXISTS webhook_deliveries_webhook_id ON webhook_deliveries ("webhook_id");
@@ -0,0 +1,7 @@
+--statement
+-- The flows between two accounts are summed from their postings, see Store.AggregateFlow
+CREATE INDEX IF NOT EXISTS 'p_flow' ON "postings" (
+  "source",
+  "destination",
+  "asset"
+);
@@ -0,0 +1,41 @@
+--statement
+-- The references are kept unique by the keys of their scope, see storage.ReferenceKeys
+CREATE TABLE IF NOT EXISTS transaction_references (
+  "reference" varchar NOT NULL,
+  "asset"     varchar NOT NULL,
+  "txid"      integer,
+
+  UNIQUE("reference", "asset")
+);
+--statement
+INSERT OR IGNORE INTO transaction_references ("reference", "asset", "txid")
+SELECT "reference", '', "id" FROM transactions WHERE "reference" IS NOT NULL AND "reference" <> '';
+--statement
+-- The unique constraint of the references can only be dropped by rebuilding the table
+CREATE TABLE transactions_v011 (
+  "id"        integer,
+  "timestamp" varchar,
+  "reference" varchar,
+  "hash"      varchar,
+
+  UNIQUE("id")
+);
+--statement
+INSERT INTO transactions_v011 ("id", "timestamp", "reference", "hash")
+SELECT "id", "timestamp", "reference", "hash" FROM transactions;
+--statement
+DROP TABLE transactions;
+--statement
+ALTER TABLE transactions_v011 RENAME TO transactions;
+--statement
+CREATE INDEX IF NOT EXISTS 't_ts' ON "transactions" (
+  "timestamp"
+);
+--statement
+CREATE INDEX IF NOT EXISTS 't_hash' ON "transactions" (
+  "hash"
+);
+--statement
+CREATE INDEX IF NOT EXISTS 't_ref' ON "transactions" (
+  "reference"
+);
@@ -0,0 +1,10 @@
+--statement
+-- The assets of the postings, read by the commits checking the scales of the assets, see Store.FindAssetsOf
+CREATE TABLE IF NOT EXISTS assets (
+  "asset" varchar NOT NULL,
+
+  UNIQUE("asset")
+);
+--statement
+INSERT OR IGNORE INTO assets ("asset")
+SELECT DISTINCT "asset" FROM volumes;
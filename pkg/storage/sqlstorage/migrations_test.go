@@ -0,0 +1,195 @@
+package sqlstorage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/numary/ledger/pkg/core"
+	"github.com/numary/ledger/pkg/ledgertesting"
+	"github.com/numary/ledger/pkg/storage"
+	"github.com/pborman/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMigrations(t *testing.T) {
+	db, err := sql.Open("sqlite3", fmt.Sprintf("file:migrations_%d?mode=memory&cache=shared", time.Now().UnixNano()))
+	assert.NoError(t, err)
+	defer db.Close()
+
+	store, err := NewStore("migrations", SQLite, db, func(ctx context.Context) error {
+		return nil
+	})
+	assert.NoError(t, err)
+
+	ms, err := readMigrations(SQLite)
+	assert.NoError(t, err)
+
+	// The dry run doesn't touch the database
+	pending, err := store.PendingMigrations(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, ms, pending)
+	exists, err := store.migrationsTableExists(context.Background())
+	assert.NoError(t, err)
+	assert.False(t, exists)
+
+	for i := 0; i < 2; i++ {
+		err = store.Initialize(context.Background())
+		assert.NoError(t, err)
+
+		pending, err = store.PendingMigrations(context.Background())
+		assert.NoError(t, err)
+		assert.Empty(t, pending)
+	}
+
+	var count int
+	err = db.QueryRow(`SELECT count(*) FROM migrations`).Scan(&count)
+	assert.NoError(t, err)
+	assert.Equal(t, len(ms), count)
+
+	// A schema migrated by a more recent version is refused
+	_, err = db.Exec(`INSERT INTO migrations (version, date) VALUES (?, ?)`, ms[len(ms)-1].Version+1, time.Now().Format(time.RFC3339))
+	assert.NoError(t, err)
+
+	err = store.Initialize(context.Background())
+	assert.True(t, errors.Is(err, ErrSchemaTooRecent), err)
+}
+
+func TestReferencesMigration(t *testing.T) {
+	db, err := sql.Open("sqlite3", fmt.Sprintf("file:references_%d?mode=memory&cache=shared", time.Now().UnixNano()))
+	assert.NoError(t, err)
+	defer db.Close()
+
+	store, err := NewStore("references", SQLite, db, func(ctx context.Context) error {
+		return nil
+	})
+	assert.NoError(t, err)
+
+	ms, err := readMigrations(SQLite)
+	assert.NoError(t, err)
+
+	// The references saved before the migration keep being unique
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS migrations ("version" integer, "date" varchar, UNIQUE("version"))`)
+	assert.NoError(t, err)
+	for _, m := range ms {
+		if m.Name == "v011.sql" {
+			break
+		}
+		assert.NoError(t, store.applyMigration(context.Background(), m))
+	}
+	_, err = db.Exec(`INSERT INTO transactions (id, timestamp, reference, hash) VALUES (0, ?, 'ref', '')`, time.Now().Format(time.RFC3339))
+	assert.NoError(t, err)
+	_, err = db.Exec(`INSERT INTO postings (id, txid, source, destination, amount, asset) VALUES (0, 0, 'world', 'users:001', 10, 'USD')`)
+	assert.NoError(t, err)
+
+	assert.NoError(t, store.Initialize(context.Background()))
+
+	tx, err := store.GetTransaction(context.Background(), "0")
+	assert.NoError(t, err)
+	assert.Equal(t, "ref", tx.Reference)
+
+	err = store.SaveTransactions(context.Background(), []core.Transaction{{
+		ID:        1,
+		Reference: "ref",
+		Postings: []core.Posting{
+			{Source: "world", Destination: "users:001", Amount: 10, Asset: "USD"},
+		},
+		Timestamp: time.Now().Format(time.RFC3339),
+	}})
+	assert.True(t, errors.Is(err, storage.ErrDuplicateReference), err)
+}
+
+func TestAssetsMigration(t *testing.T) {
+	db, err := sql.Open("sqlite3", fmt.Sprintf("file:assets_%d?mode=memory&cache=shared", time.Now().UnixNano()))
+	assert.NoError(t, err)
+	defer db.Close()
+
+	store, err := NewStore("assets", SQLite, db, func(ctx context.Context) error {
+		return nil
+	})
+	assert.NoError(t, err)
+
+	ms, err := readMigrations(SQLite)
+	assert.NoError(t, err)
+
+	// The assets of the volumes saved before the migration are found by their base code
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS migrations ("version" integer, "date" varchar, UNIQUE("version"))`)
+	assert.NoError(t, err)
+	for _, m := range ms {
+		if m.Name == "v012.sql" {
+			break
+		}
+		assert.NoError(t, store.applyMigration(context.Background(), m))
+	}
+	_, err = db.Exec(`INSERT INTO volumes (account, asset, input, output) VALUES ('users:001', 'USD/2', 10, 0), ('world', 'USD/2', 0, 10), ('world', 'GEM', 0, 10)`)
+	assert.NoError(t, err)
+
+	assert.NoError(t, store.Initialize(context.Background()))
+
+	assets, err := store.FindAssetsOf(context.Background(), []string{"USD"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"USD/2"}, assets)
+}
+
+func TestTimestampsMigration(t *testing.T) {
+	pgServer, err := ledgertesting.PostgresServer()
+	if err != nil {
+		t.Skipf("postgres unavailable: %s", err)
+	}
+	defer pgServer.Close()
+
+	db, err := sql.Open("pgx", pgServer.ConnString())
+	assert.NoError(t, err)
+	defer db.Close()
+
+	ledger := uuid.New()
+	store, err := NewStore(ledger, PostgreSQL, db, func(ctx context.Context) error {
+		return nil
+	})
+	assert.NoError(t, err)
+
+	ms, err := readMigrations(PostgreSQL)
+	assert.NoError(t, err)
+
+	// The timestamps saved as text before the migration are read back as they were hashed,
+	// the ones which are not timestamps not failing the migration
+	_, err = db.Exec(fmt.Sprintf(`CREATE SCHEMA "%s"`, ledger))
+	assert.NoError(t, err)
+	_, err = db.Exec(fmt.Sprintf(`CREATE TABLE %s ("version" integer, "date" varchar, UNIQUE("version"))`, store.table("migrations")))
+	assert.NoError(t, err)
+	for _, m := range ms {
+		if m.Name == "v003.sql" {
+			break
+		}
+		assert.NoError(t, store.applyMigration(context.Background(), m))
+	}
+	timestamps := []string{"2021-01-01T02:00:00.5+02:00", "2021-01-01T00:00:01Z", "yesterday at noon"}
+	for i, ts := range timestamps {
+		_, err = db.Exec(fmt.Sprintf(`INSERT INTO %s (id, timestamp, hash) VALUES ($1, $2, '')`, store.table("transactions")), i, ts)
+		assert.NoError(t, err)
+		_, err = db.Exec(fmt.Sprintf(`INSERT INTO %s (id, txid, source, destination, amount, asset) VALUES (0, $1, 'world', 'users:001', 10, 'USD')`,
+			store.table("postings")), i)
+		assert.NoError(t, err)
+	}
+
+	assert.NoError(t, store.Initialize(context.Background()))
+
+	for i, ts := range timestamps {
+		tx, err := store.GetTransaction(context.Background(), fmt.Sprint(i))
+		assert.NoError(t, err)
+		assert.Equal(t, ts, tx.Timestamp)
+	}
+
+	// The transactions saved since keep their timestamps too
+	assert.NoError(t, store.SaveTransactions(context.Background(), []core.Transaction{{
+		ID:        3,
+		Postings:  []core.Posting{{Source: "world", Destination: "users:001", Amount: 10, Asset: "USD"}},
+		Timestamp: "2021-01-02T08:30:00+02:00",
+	}}))
+	tx, err := store.GetTransaction(context.Background(), "3")
+	assert.NoError(t, err)
+	assert.Equal(t, "2021-01-02T08:30:00+02:00", tx.Timestamp)
+}
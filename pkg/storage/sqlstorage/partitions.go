@@ -0,0 +1,177 @@
+package sqlstorage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/numary/ledger/pkg/core"
+	"github.com/numary/ledger/pkg/logging"
+	"github.com/sirupsen/logrus"
+)
+
+// The transactions of a partitioned PostgreSQL ledger are partitioned by range of timestamps, with a partition
+// per month named transactions_YYYY_MM, so that the queries bounded in time only scan the partitions of their months.
+// As the unique constraints of a partitioned table must include its partition key, the unicity of the ids
+// is enforced by the transactions_keys table, the one of the references by the transaction_references table.
+
+// execer is either a database or a sql transaction
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// partitionOf returns the name of the partition of the month of a timestamp, along with the bounds of the month
+func partitionOf(t time.Time) (string, time.Time, time.Time) {
+	t = t.UTC()
+	from := time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	return "transactions_" + from.Format("2006_01"), from, from.AddDate(0, 1, 0)
+}
+
+// transactionsPartitioned reports whether the transactions table of the ledger is partitioned
+func (s *Store) transactionsPartitioned(ctx context.Context) (bool, error) {
+	var count int
+	err := s.db.QueryRowContext(ctx, `
+		SELECT count(*) FROM pg_partitioned_table pt
+		JOIN pg_class c ON c.oid = pt.partrelid
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		WHERE n.nspname = $1 AND c.relname = 'transactions'`, s.ledger).Scan(&count)
+	return count > 0, err
+}
+
+// createPartition creates the partition of the month of a timestamp if it doesn't exist yet
+func (s *Store) createPartition(ctx context.Context, db execer, t time.Time) error {
+	name, from, to := partitionOf(t)
+	statement := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s PARTITION OF %s FOR VALUES FROM ('%s') TO ('%s')`,
+		s.table(name), s.table("transactions"), from.Format(time.RFC3339), to.Format(time.RFC3339))
+	logging.FromContext(ctx).Debugln(statement)
+
+	_, err := db.ExecContext(ctx, statement)
+
+	// The partition was created by a concurrent commit
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && (pgErr.Code == "42P07" || pgErr.Code == "23505") {
+		return nil
+	}
+	return err
+}
+
+// ensurePartitions creates the missing partitions of the months of the transactions, outside of the sql transaction
+// saving them as creating a partition locks the whole table. The partitions known to exist are remembered,
+// so that only the first transaction of a month creates its partition.
+func (s *Store) ensurePartitions(ctx context.Context, ts []core.Transaction) error {
+	if !s.partitioned {
+		return nil
+	}
+
+	for _, t := range ts {
+		timestamp, err := time.Parse(time.RFC3339, t.Timestamp)
+		if err != nil {
+			return fmt.Errorf("transaction %d: invalid timestamp: %w", t.ID, err)
+		}
+		name, _, _ := partitionOf(timestamp)
+
+		s.partitionsMu.Lock()
+		_, ok := s.partitions[name]
+		s.partitionsMu.Unlock()
+		if ok {
+			continue
+		}
+
+		err = s.createPartition(ctx, s.db, timestamp)
+		if err != nil {
+			return err
+		}
+
+		s.partitionsMu.Lock()
+		s.partitions[name] = struct{}{}
+		s.partitionsMu.Unlock()
+	}
+
+	return nil
+}
+
+// partitionTransactions replaces the transactions table of the ledger by a table partitioned by month
+// holding the same transactions, in a single sql transaction locking the table meanwhile
+func (s *Store) partitionTransactions(ctx context.Context) error {
+	logrus.Infof("ledger %s: partitioning the transactions by month", s.ledger)
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	exec := func(statement string) error {
+		logging.FromContext(ctx).Debugln(statement)
+		_, err := tx.ExecContext(ctx, statement)
+		return err
+	}
+
+	for _, statement := range []string{
+		fmt.Sprintf(`LOCK TABLE %s IN ACCESS EXCLUSIVE MODE`, s.table("transactions")),
+		fmt.Sprintf(`ALTER TABLE %s RENAME TO transactions_unpartitioned`, s.table("transactions")),
+		fmt.Sprintf(`CREATE TABLE %s (
+			"id"             bigint NOT NULL,
+			"timestamp"      timestamptz NOT NULL,
+			"timestamp_text" varchar,
+			"reference"      varchar,
+			"hash"           varchar
+		) PARTITION BY RANGE ("timestamp")`, s.table("transactions")),
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+			"id"        bigint,
+			"reference" varchar,
+
+			UNIQUE("id")
+		)`, s.table("transactions_keys")),
+	} {
+		if err := exec(statement); err != nil {
+			return err
+		}
+	}
+
+	rows, err := tx.QueryContext(ctx, fmt.Sprintf(
+		`SELECT DISTINCT date_trunc('month', "timestamp" AT TIME ZONE 'UTC') FROM %s`, s.table("transactions_unpartitioned")))
+	if err != nil {
+		return err
+	}
+	months := make([]time.Time, 0)
+	for rows.Next() {
+		var month time.Time
+		if err := rows.Scan(&month); err != nil {
+			rows.Close()
+			return err
+		}
+		months = append(months, month)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	for _, month := range months {
+		if err := s.createPartition(ctx, tx, month); err != nil {
+			return err
+		}
+	}
+
+	for _, statement := range []string{
+		fmt.Sprintf(`INSERT INTO %s ("id", "timestamp", "timestamp_text", "reference", "hash")
+			SELECT "id", "timestamp", "timestamp_text", "reference", "hash" FROM %s`, s.table("transactions"), s.table("transactions_unpartitioned")),
+		fmt.Sprintf(`INSERT INTO %s ("id", "reference")
+			SELECT "id", "reference" FROM %s`, s.table("transactions_keys"), s.table("transactions_unpartitioned")),
+		fmt.Sprintf(`DROP TABLE %s`, s.table("transactions_unpartitioned")),
+		fmt.Sprintf(`CREATE INDEX t_id ON %s ("id")`, s.table("transactions")),
+		fmt.Sprintf(`CREATE INDEX t_ts ON %s ("timestamp")`, s.table("transactions")),
+		fmt.Sprintf(`CREATE INDEX t_ref ON %s ("reference")`, s.table("transactions")),
+		fmt.Sprintf(`CREATE INDEX t_hash ON %s ("hash")`, s.table("transactions")),
+	} {
+		if err := exec(statement); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,141 @@
+package sqlstorage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/numary/ledger/pkg/core"
+	"github.com/numary/ledger/pkg/ledger/query"
+	"github.com/numary/ledger/pkg/ledgertesting"
+	"github.com/numary/ledger/pkg/storage"
+	"github.com/pborman/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPartitionOf(t *testing.T) {
+	name, from, to := partitionOf(time.Date(2021, time.December, 31, 23, 30, 0, 0, time.FixedZone("", -2*3600)))
+	assert.Equal(t, "transactions_2022_01", name)
+	assert.Equal(t, time.Date(2022, time.January, 1, 0, 0, 0, 0, time.UTC), from)
+	assert.Equal(t, time.Date(2022, time.February, 1, 0, 0, 0, 0, time.UTC), to)
+}
+
+func TestPartitionedTransactions(t *testing.T) {
+	pgServer, err := ledgertesting.PostgresServer()
+	if err != nil {
+		t.Skipf("postgres unavailable: %s", err)
+	}
+	defer pgServer.Close()
+
+	db, err := sql.Open("pgx", pgServer.ConnString())
+	assert.NoError(t, err)
+	defer db.Close()
+
+	ledger := uuid.New()
+	store, err := NewStore(ledger, PostgreSQL, db, func(ctx context.Context) error {
+		return nil
+	})
+	assert.NoError(t, err)
+
+	// The transactions saved before the partitioning are moved to the partitions of their months
+	assert.NoError(t, store.Initialize(context.Background()))
+	assert.NoError(t, store.SaveTransactions(context.Background(), []core.Transaction{{
+		ID:        0,
+		Postings:  []core.Posting{{Source: "world", Destination: "users:001", Amount: 100, Asset: "USD"}},
+		Reference: "first",
+		Timestamp: "2021-12-15T10:00:00Z",
+	}}))
+
+	indexed := indexedColumns(t, db, ledger)
+	assert.ElementsMatch(t, []string{"id", "timestamp", "reference", "hash"}, indexed)
+
+	store.partitioning = true
+	assert.NoError(t, store.Initialize(context.Background()))
+	assert.True(t, store.partitioned)
+
+	// The columns indexed before the partitioning still are
+	assert.ElementsMatch(t, indexed, indexedColumns(t, db, ledger))
+
+	// The partition of a new month is created by its first transaction
+	assert.NoError(t, store.SaveTransactions(context.Background(), []core.Transaction{{
+		ID:        1,
+		Postings:  []core.Posting{{Source: "world", Destination: "users:001", Amount: 100, Asset: "USD"}},
+		Timestamp: "2022-01-10T10:00:00Z",
+	}}))
+
+	var partitions []string
+	rows, err := db.Query(`
+		SELECT c.relname FROM pg_inherits i
+		JOIN pg_class c ON c.oid = i.inhrelid
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		WHERE n.nspname = $1 AND c.relkind = 'r'
+		ORDER BY c.relname`, ledger)
+	assert.NoError(t, err)
+	for rows.Next() {
+		var name string
+		assert.NoError(t, rows.Scan(&name))
+		partitions = append(partitions, name)
+	}
+	assert.NoError(t, rows.Close())
+	assert.Equal(t, []string{"transactions_2021_12", "transactions_2022_01"}, partitions)
+
+	// The ids and references are still unique
+	err = store.SaveTransactions(context.Background(), []core.Transaction{{
+		ID:        2,
+		Postings:  []core.Posting{{Source: "world", Destination: "users:001", Amount: 100, Asset: "USD"}},
+		Reference: "first",
+		Timestamp: "2022-01-11T10:00:00Z",
+	}})
+	assert.True(t, errors.Is(err, storage.ErrDuplicateReference), err)
+	err = store.SaveTransactions(context.Background(), []core.Transaction{{
+		ID:        1,
+		Postings:  []core.Posting{{Source: "world", Destination: "users:001", Amount: 100, Asset: "USD"}},
+		Timestamp: "2021-12-11T10:00:00Z",
+	}})
+	assert.True(t, errors.Is(err, storage.ErrConflict), err)
+
+	cursor, err := store.FindTransactions(context.Background(), query.New([]query.QueryModifier{
+		query.TimestampAfter(time.Date(2022, time.January, 1, 0, 0, 0, 0, time.UTC)),
+	}))
+	assert.NoError(t, err)
+	assert.Len(t, cursor.Data, 1)
+	assert.EqualValues(t, 1, cursor.Data.([]core.Transaction)[0].ID)
+
+	// A query bounded in time only scans the partitions of its months
+	rows, err = db.Query(`EXPLAIN SELECT id FROM "`+ledger+`".transactions WHERE timestamp > $1 AND timestamp < $2`,
+		"2022-01-01T00:00:00Z", "2022-01-31T00:00:00Z")
+	assert.NoError(t, err)
+	plan := make([]string, 0)
+	for rows.Next() {
+		var line string
+		assert.NoError(t, rows.Scan(&line))
+		plan = append(plan, line)
+	}
+	assert.NoError(t, rows.Close())
+	assert.Contains(t, strings.Join(plan, "\n"), "transactions_2022_01")
+	assert.NotContains(t, strings.Join(plan, "\n"), "transactions_2021_12")
+}
+
+// indexedColumns returns the columns of the transactions table of a ledger which are indexed
+func indexedColumns(t *testing.T, db *sql.DB, ledger string) []string {
+	rows, err := db.Query(`
+		SELECT DISTINCT a.attname FROM pg_index i
+		JOIN pg_class c ON c.oid = i.indrelid
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		JOIN pg_attribute a ON a.attrelid = c.oid AND a.attnum = ANY(i.indkey)
+		WHERE n.nspname = $1 AND c.relname = 'transactions'`, ledger)
+	assert.NoError(t, err)
+	defer rows.Close()
+
+	columns := make([]string, 0)
+	for rows.Next() {
+		var column string
+		assert.NoError(t, rows.Scan(&column))
+		columns = append(columns, column)
+	}
+	assert.NoError(t, rows.Err())
+	return columns
+}
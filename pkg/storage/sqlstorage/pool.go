@@ -0,0 +1,84 @@
+package sqlstorage
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/stdlib"
+	"github.com/sirupsen/logrus"
+)
+
+// PoolConfig configures the connections of the databases shared by the stores of a cachedDBDriver.
+// The zero values keep the defaults of database/sql, except for MinConns.
+type PoolConfig struct {
+	// MaxConns is the maximum number of open connections, 0 for no limit
+	MaxConns int
+	// MinConns is the number of connections kept open when idle
+	MinConns int
+	// MaxConnLifetime is the duration after which a connection is closed, 0 to keep them forever
+	MaxConnLifetime time.Duration
+	// MaxConnIdleTime is the duration after which an idle connection is closed, 0 to keep them forever
+	MaxConnIdleTime time.Duration
+	// StatementTimeout aborts the statements running for longer, 0 to disable. It is only supported by PostgreSQL.
+	StatementTimeout time.Duration
+}
+
+// Validate checks the values of the configuration
+func (c PoolConfig) Validate(flavor Flavor) error {
+	switch {
+	case c.MaxConns < 0:
+		return fmt.Errorf("invalid max conns %d: expected a positive number", c.MaxConns)
+	case c.MinConns < 0:
+		return fmt.Errorf("invalid min conns %d: expected a positive number", c.MinConns)
+	case c.MaxConns > 0 && c.MinConns > c.MaxConns:
+		return fmt.Errorf("invalid min conns %d: expected at most max conns (%d)", c.MinConns, c.MaxConns)
+	case c.MaxConnLifetime < 0:
+		return fmt.Errorf("invalid max conn lifetime %s: expected a positive duration", c.MaxConnLifetime)
+	case c.MaxConnIdleTime < 0:
+		return fmt.Errorf("invalid max conn idle time %s: expected a positive duration", c.MaxConnIdleTime)
+	case c.StatementTimeout < 0:
+		return fmt.Errorf("invalid statement timeout %s: expected a positive duration", c.StatementTimeout)
+	case c.StatementTimeout > 0 && flavor != PostgreSQL:
+		return fmt.Errorf("statement timeout is only supported by PostgreSQL")
+	}
+	return nil
+}
+
+// open opens a PostgreSQL database setting the statement timeout on each connection
+func (c PoolConfig) open(where string) (*sql.DB, error) {
+	cfg, err := pgx.ParseConfig(where)
+	if err != nil {
+		return nil, err
+	}
+	if c.StatementTimeout > 0 {
+		cfg.RuntimeParams["statement_timeout"] = strconv.FormatInt(c.StatementTimeout.Milliseconds(), 10)
+	}
+	return stdlib.OpenDB(*cfg), nil
+}
+
+func (c PoolConfig) apply(db *sql.DB) {
+	db.SetMaxOpenConns(c.MaxConns)
+	db.SetMaxIdleConns(c.MinConns)
+	db.SetConnMaxLifetime(c.MaxConnLifetime)
+	db.SetConnMaxIdleTime(c.MaxConnIdleTime)
+}
+
+func (c PoolConfig) log(driver string) {
+	logrus.WithFields(logrus.Fields{
+		"max_conns":          c.MaxConns,
+		"min_conns":          c.MinConns,
+		"max_conn_lifetime":  c.MaxConnLifetime,
+		"max_conn_idle_time": c.MaxConnIdleTime,
+		"statement_timeout":  c.StatementTimeout,
+	}).Infof("%s connection pool", driver)
+}
+
+// WithPool configures the connections of the database, see PoolConfig
+func WithPool(config PoolConfig) CachedDBDriverOption {
+	return func(d *cachedDBDriver) {
+		d.pool = &config
+	}
+}
@@ -0,0 +1,89 @@
+package sqlstorage
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/huandu/go-sqlbuilder"
+	"github.com/numary/ledger/pkg/core"
+	"github.com/numary/ledger/pkg/logging"
+)
+
+func (s *Store) SaveScript(ctx context.Context, script core.StoredScript) error {
+	ib := sqlbuilder.NewInsertBuilder()
+	ib.InsertInto(s.table("scripts"))
+	ib.Cols("name", "version", "plain", "timestamp")
+	ib.Values(script.Name, script.Version, script.Plain, script.Timestamp)
+
+	sqlq, args := ib.BuildWithFlavor(s.flavor)
+	logging.FromContext(ctx).Debugln(sqlq, args)
+
+	_, err := s.db.ExecContext(ctx, sqlq, args...)
+	return translateError(err)
+}
+
+func (s *Store) GetScript(ctx context.Context, name string, version int64) (*core.StoredScript, error) {
+	sb := sqlbuilder.NewSelectBuilder()
+	sb.Select("name", "version", "plain", "timestamp")
+	sb.From(s.table("scripts"))
+	sb.Where(sb.Equal("name", name))
+	if version != 0 {
+		sb.Where(sb.Equal("version", version))
+	}
+	sb.OrderBy("version").Desc()
+	sb.Limit(1)
+
+	sqlq, args := sb.BuildWithFlavor(s.flavor)
+	logging.FromContext(ctx).Debugln(sqlq, args)
+
+	script := core.StoredScript{}
+	err := s.reader(ctx).QueryRowContext(ctx, sqlq, args...).Scan(
+		&script.Name,
+		&script.Version,
+		&script.Plain,
+		&script.Timestamp,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, translateError(err)
+	}
+
+	return &script, nil
+}
+
+func (s *Store) FindScripts(ctx context.Context) ([]core.StoredScript, error) {
+	last := sqlbuilder.NewSelectBuilder()
+	last.Select("name", "max(version) as version").From(s.table("scripts")).GroupBy("name")
+
+	sb := sqlbuilder.NewSelectBuilder()
+	sb.Select("s.name", "s.version", "s.plain", "s.timestamp")
+	sb.From(sb.As(s.table("scripts"), "s"))
+	sb.Join(sb.BuilderAs(last, "l"), "s.name = l.name", "s.version = l.version")
+	sb.OrderBy("s.name")
+
+	sqlq, args := sb.BuildWithFlavor(s.flavor)
+	logging.FromContext(ctx).Debugln(sqlq, args)
+
+	rows, err := s.reader(ctx).QueryContext(ctx, sqlq, args...)
+	if err != nil {
+		return nil, translateError(err)
+	}
+	defer rows.Close()
+
+	scripts := make([]core.StoredScript, 0)
+	for rows.Next() {
+		script := core.StoredScript{}
+		err := rows.Scan(&script.Name, &script.Version, &script.Plain, &script.Timestamp)
+		if err != nil {
+			return nil, err
+		}
+		scripts = append(scripts, script)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, translateError(err)
+	}
+
+	return scripts, nil
+}
@@ -0,0 +1,54 @@
+package sqlstorage
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// SQLiteConfig configures the connections to the SQLite databases, so that the concurrent commits wait
+// for each other rather than failing with "database is locked".
+// The sql transactions of the stores begin immediately, taking the write lock of the database upfront:
+// a deferred transaction upgrading its read lock can't wait for a concurrent writer without deadlocking,
+// and fails right away whatever the busy timeout.
+type SQLiteConfig struct {
+	// JournalMode is the journal mode of the databases. With WAL, the readers don't block the writer
+	// and the writer doesn't block the readers.
+	JournalMode string
+	// BusyTimeout is how long a writer waits for the lock of the database held by another one before failing
+	BusyTimeout time.Duration
+}
+
+var DefaultSQLiteConfig = SQLiteConfig{
+	JournalMode: "WAL",
+	BusyTimeout: 5 * time.Second,
+}
+
+var sqliteJournalModes = []string{"DELETE", "TRUNCATE", "PERSIST", "MEMORY", "WAL", "OFF"}
+
+// Validate checks the values of the configuration
+func (c SQLiteConfig) Validate() error {
+	valid := false
+	for _, mode := range sqliteJournalModes {
+		if strings.EqualFold(c.JournalMode, mode) {
+			valid = true
+		}
+	}
+	switch {
+	case !valid:
+		return fmt.Errorf("invalid journal mode '%s': expected one of %s", c.JournalMode, strings.Join(sqliteJournalModes, ", "))
+	case c.BusyTimeout < 0:
+		return fmt.Errorf("invalid busy timeout %s: expected a positive duration", c.BusyTimeout)
+	}
+	return nil
+}
+
+// ConnString returns the connection string of the SQLite database stored at path
+func (c SQLiteConfig) ConnString(path string) string {
+	return fmt.Sprintf(
+		"file:%s?_journal_mode=%s&_busy_timeout=%d&_txlock=immediate",
+		path,
+		strings.ToUpper(c.JournalMode),
+		c.BusyTimeout.Milliseconds(),
+	)
+}
@@ -0,0 +1,93 @@
+package sqlstorage
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/numary/ledger/pkg/core"
+	"github.com/numary/ledger/pkg/ledger/query"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSQLiteConfig(t *testing.T) {
+	assert.NoError(t, DefaultSQLiteConfig.Validate())
+	assert.NoError(t, SQLiteConfig{JournalMode: "delete"}.Validate())
+	assert.Error(t, SQLiteConfig{JournalMode: "unknown"}.Validate())
+	assert.Error(t, SQLiteConfig{JournalMode: "WAL", BusyTimeout: -time.Second}.Validate())
+	assert.Equal(t, "file:/tmp/ledger.db?_journal_mode=WAL&_busy_timeout=5000&_txlock=immediate",
+		DefaultSQLiteConfig.ConnString("/tmp/ledger.db"))
+}
+
+// TestSQLiteConcurrentWrites writes to a database from several stores at once, like processes sharing it,
+// while others read from it
+func TestSQLiteConcurrentWrites(t *testing.T) {
+	const (
+		writers      = 16
+		transactions = 50
+	)
+
+	file := path.Join(t.TempDir(), "ledger.db")
+	d := NewOpenCloseDBDriver("sqlite", SQLite, func(name string) string {
+		return SQLiteFileConnString(file)
+	})
+	store, err := d.NewStore("concurrency")
+	assert.NoError(t, err)
+	defer store.Close(context.Background())
+	assert.NoError(t, store.Initialize(context.Background()))
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 3*writers*transactions)
+	for w := 0; w < writers; w++ {
+		wg.Add(2)
+		go func(w int) {
+			defer wg.Done()
+			store, err := d.NewStore("concurrency")
+			if err != nil {
+				errs <- err
+				return
+			}
+			defer store.Close(context.Background())
+
+			for i := 0; i < transactions; i++ {
+				id := int64(w*transactions + i)
+				errs <- store.SaveTransactions(context.Background(), []core.Transaction{{
+					ID: id,
+					Postings: []core.Posting{
+						{Source: "world", Destination: fmt.Sprintf("users:%03d", w), Amount: 100, Asset: "USD"},
+					},
+					Timestamp: time.Now().UTC().Format(time.RFC3339),
+					Hash:      fmt.Sprintf("hash%d", id),
+				}})
+				errs <- store.SaveMeta(context.Background(), id, time.Now().UTC().Format(time.RFC3339),
+					"account", fmt.Sprintf("users:%03d", w), "count", fmt.Sprintf("%d", i))
+			}
+		}(w)
+		go func() {
+			defer wg.Done()
+			store, err := d.NewStore("concurrency")
+			if err != nil {
+				errs <- err
+				return
+			}
+			defer store.Close(context.Background())
+
+			for i := 0; i < transactions; i++ {
+				_, err := store.FindTransactions(context.Background(), query.New())
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		assert.NoError(t, err)
+	}
+	count, err := store.CountTransactions(context.Background())
+	assert.NoError(t, err)
+	assert.EqualValues(t, writers*transactions, count)
+}
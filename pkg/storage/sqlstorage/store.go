@@ -6,9 +6,10 @@ import (
 	"embed"
 	"fmt"
 	"github.com/huandu/go-sqlbuilder"
+	"github.com/numary/ledger/pkg/storage"
 	"github.com/sirupsen/logrus"
-	"path"
 	"strings"
+	"sync"
 
 	_ "github.com/jackc/pgx/v4/stdlib"
 	_ "github.com/mattn/go-sqlite3"
@@ -18,10 +19,29 @@ import (
 var migrations embed.FS
 
 type Store struct {
-	flavor  sqlbuilder.Flavor
-	ledger  string
-	db      *sql.DB
+	flavor sqlbuilder.Flavor
+	ledger string
+	db     *sql.DB
+	// replica is an optional read replica of db, see reader
+	replica *sql.DB
 	onClose func(ctx context.Context) error
+	// partitioning converts the transactions table to a table partitioned by month on initialization,
+	// and partitioned tells if it is, see partitions.go
+	partitioning bool
+	partitioned  bool
+	partitionsMu sync.Mutex
+	partitions   map[string]struct{}
+	// copyThreshold is the number of transactions from which a batch is copied, see copyTransactions
+	copyThreshold int
+}
+
+// reader returns the database the read only queries are sent to, the replica if any,
+// unless the read must be consistent with the writes, see storage.WithConsistentRead
+func (s *Store) reader(ctx context.Context) *sql.DB {
+	if s.replica == nil || storage.IsConsistentRead(ctx) {
+		return s.db
+	}
+	return s.replica
 }
 
 func (s *Store) table(name string) string {
@@ -35,10 +55,12 @@ func (s *Store) table(name string) string {
 
 func NewStore(name string, flavor sqlbuilder.Flavor, db *sql.DB, onClose func(ctx context.Context) error) (*Store, error) {
 	return &Store{
-		ledger:  name,
-		db:      db,
-		flavor:  flavor,
-		onClose: onClose,
+		ledger:        name,
+		db:            db,
+		flavor:        flavor,
+		onClose:       onClose,
+		partitions:    map[string]struct{}{},
+		copyThreshold: defaultCopyThreshold,
 	}, nil
 }
 
@@ -46,42 +68,64 @@ func (s *Store) Name() string {
 	return s.ledger
 }
 
+// Initialize migrates the schema of the ledger to the latest version, see PendingMigrations.
+// With PostgreSQL, it also partitions the transactions by month if the store was asked to.
 func (s *Store) Initialize(ctx context.Context) error {
-	logrus.Debugln("initializing sqlite db")
+	logrus.Debugf("initializing %s store of ledger %s", s.flavor, s.ledger)
 
-	statements := make([]string, 0)
+	err := s.migrate(ctx)
+	if err != nil || s.flavor != sqlbuilder.PostgreSQL {
+		return err
+	}
+
+	s.partitioned, err = s.transactionsPartitioned(ctx)
+	if err != nil {
+		return err
+	}
+	if s.partitioning && !s.partitioned {
+		err = s.partitionTransactions(ctx)
+		if err != nil {
+			return fmt.Errorf("partitioning transactions: %w", err)
+		}
+		s.partitioned = true
+	}
 
-	migrationsDir := fmt.Sprintf("migrations/%s", strings.ToLower(s.flavor.String()))
+	return nil
+}
 
-	entries, err := migrations.ReadDir(migrationsDir)
+// Drop drops the schema of the ledger with PostgreSQL. With SQLite, where each ledger has its own database
+// unless the database is shared, like the in memory one, it drops all the tables and views of the database.
+func (s *Store) Drop(ctx context.Context) error {
+	if s.flavor == sqlbuilder.PostgreSQL {
+		_, err := s.db.ExecContext(ctx, fmt.Sprintf(`DROP SCHEMA IF EXISTS "%s" CASCADE`, s.ledger))
+		return err
+	}
 
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT type, name FROM sqlite_master WHERE type IN ('view', 'table') AND name NOT LIKE 'sqlite_%' ORDER BY type = 'table'`)
 	if err != nil {
 		return err
 	}
+	defer rows.Close()
 
-	for _, m := range entries {
-		logrus.Debugf("running migrations %s\n", m.Name())
-
-		b, err := migrations.ReadFile(path.Join(migrationsDir, m.Name()))
+	statements := make([]string, 0)
+	for rows.Next() {
+		var kind, name string
+		err := rows.Scan(&kind, &name)
 		if err != nil {
 			return err
 		}
-
-		plain := strings.ReplaceAll(string(b), "VAR_LEDGER_NAME", s.ledger)
-
-		statements = append(
-			statements,
-			strings.Split(plain, "--statement")...,
-		)
+		statements = append(statements, fmt.Sprintf(`DROP %s IF EXISTS "%s"`, strings.ToUpper(kind), name))
+	}
+	if err := rows.Err(); err != nil {
+		return err
 	}
+	rows.Close()
 
-	for i, statement := range statements {
+	for _, statement := range statements {
 		logrus.Debugf("running statement: %s", statement)
-		_, err = s.db.ExecContext(ctx, statement)
-
+		_, err := s.db.ExecContext(ctx, statement)
 		if err != nil {
-			err = fmt.Errorf("failed to run statement %d: %w", i, err)
-			logrus.Errorln(err)
 			return err
 		}
 	}
@@ -89,6 +133,10 @@ func (s *Store) Initialize(ctx context.Context) error {
 	return nil
 }
 
+func (s *Store) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
 func (s *Store) Close(ctx context.Context) error {
 	err := s.onClose(ctx)
 	if err != nil {
@@ -4,6 +4,7 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/huandu/go-sqlbuilder"
 	"github.com/numary/ledger/pkg/core"
@@ -13,8 +14,10 @@ import (
 	"github.com/pborman/uuid"
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
+	"math"
 	"os"
 	"path"
+	"strings"
 	"testing"
 	"time"
 )
@@ -30,25 +33,38 @@ func TestStore(t *testing.T) {
 	defer pgServer.Close()
 
 	type driverConfig struct {
-		driver     string
-		connString ConnStringResolver
-		flavor     sqlbuilder.Flavor
+		name         string
+		driver       string
+		connString   ConnStringResolver
+		flavor       sqlbuilder.Flavor
+		partitioning bool
 	}
 	var drivers = []driverConfig{
 		{
-			driver: "sqlite3",
+			name:   SQLiteDriverName,
+			driver: SQLiteDriverName,
 			connString: func(name string) string {
 				return SQLiteFileConnString(path.Join(os.TempDir(), name))
 			},
 			flavor: sqlbuilder.SQLite,
 		},
 		{
+			name:   "pgx",
 			driver: "pgx",
 			connString: func(name string) string {
 				return pgServer.ConnString()
 			},
 			flavor: sqlbuilder.PostgreSQL,
 		},
+		{
+			name:   "pgx_partitioned",
+			driver: "pgx",
+			connString: func(name string) string {
+				return pgServer.ConnString()
+			},
+			flavor:       sqlbuilder.PostgreSQL,
+			partitioning: true,
+		},
 	}
 
 	type testingFunction struct {
@@ -62,10 +78,26 @@ func TestStore(t *testing.T) {
 				name: "SaveTransactions",
 				fn:   testSaveTransaction,
 			},
+			{
+				name: "SparseTransactionIDs",
+				fn:   testSparseTransactionIDs,
+			},
+			{
+				name: "SaveTransactionsWithMeta",
+				fn:   testSaveTransactionsWithMeta,
+			},
+			{
+				name: "SaveLargeBatch",
+				fn:   testSaveLargeBatch,
+			},
 			{
 				name: "SaveMeta",
 				fn:   testSaveMeta,
 			},
+			{
+				name: "SaveMetaBatch",
+				fn:   testSaveMetaBatch,
+			},
 			{
 				name: "LastTransaction",
 				fn:   testLastTransaction,
@@ -82,10 +114,26 @@ func TestStore(t *testing.T) {
 				name: "AggregateBalances",
 				fn:   testAggregateBalances,
 			},
+			{
+				name: "AggregateBalance",
+				fn:   testAggregateBalance,
+			},
 			{
 				name: "AggregateVolumes",
 				fn:   testAggregateVolumes,
 			},
+			{
+				name: "AccountExists",
+				fn:   testAccountExists,
+			},
+			{
+				name: "SumBalances",
+				fn:   testSumBalances,
+			},
+			{
+				name: "AccountPattern",
+				fn:   testAccountPattern,
+			},
 			{
 				name: "CountMeta",
 				fn:   testCountMeta,
@@ -94,6 +142,22 @@ func TestStore(t *testing.T) {
 				name: "FindAccounts",
 				fn:   testFindAccounts,
 			},
+			{
+				name: "FindAccountsByMetadata",
+				fn:   testFindAccountsByMetadata,
+			},
+			{
+				name: "FindBalances",
+				fn:   testFindBalances,
+			},
+			{
+				name: "GetAccounts",
+				fn:   testGetAccounts,
+			},
+			{
+				name: "SortAccounts",
+				fn:   testSortAccounts,
+			},
 			{
 				name: "CountTransactions",
 				fn:   testCountTransactions,
@@ -102,16 +166,88 @@ func TestStore(t *testing.T) {
 				name: "FindTransactions",
 				fn:   testFindTransactions,
 			},
+			{
+				name: "FindTransactionsAscending",
+				fn:   testFindTransactionsAscending,
+			},
+			{
+				name: "FindTransactionsByReferencePrefix",
+				fn:   testFindTransactionsByReferencePrefix,
+			},
+			{
+				name: "ReferenceScopes",
+				fn:   testReferenceScopes,
+			},
+			{
+				name: "FindAssets",
+				fn:   testFindAssets,
+			},
+			{
+				name: "FindMetaTargets",
+				fn:   testFindMetaTargets,
+			},
+			{
+				name: "FindTransactionsByMetadata",
+				fn:   testFindTransactionsByMetadata,
+			},
 			{
 				name: "GetMeta",
 				fn:   testGetMeta,
 			},
+			{
+				name: "DeleteMeta",
+				fn:   testDeleteMeta,
+			},
+			{
+				name: "MetaVersion",
+				fn:   testMetaVersion,
+			},
+			{
+				name: "RecomputeVolumes",
+				fn:   testRecomputeVolumes,
+			},
 			{
 				name: "GetTransaction",
 				fn:   testGetTransaction,
 			},
+			{
+				name: "IdempotencyKey",
+				fn:   testIdempotencyKey,
+			},
+			{
+				name: "Scripts",
+				fn:   testScripts,
+			},
+			{
+				name: "Webhooks",
+				fn:   testWebhooks,
+			},
+			{
+				name: "Head",
+				fn:   testHead,
+			},
+			{
+				name: "GetTransactionByHash",
+				fn:   testGetTransactionByHash,
+			},
+			{
+				name: "AggregateFlow",
+				fn:   testAggregateFlow,
+			},
+			{
+				name: "UniqueConstraints",
+				fn:   testUniqueConstraints,
+			},
+			{
+				name: "ExpiredContext",
+				fn:   testExpiredContext,
+			},
+			{
+				name: "Drop",
+				fn:   testDrop,
+			},
 		} {
-			t.Run(fmt.Sprintf("%s/%s", driver.driver, tf.name), func(t *testing.T) {
+			t.Run(fmt.Sprintf("%s/%s", driver.name, tf.name), func(t *testing.T) {
 				ledger := uuid.New()
 
 				db, err := sql.Open(driver.driver, driver.connString(ledger))
@@ -137,6 +273,7 @@ func TestStore(t *testing.T) {
 					return db.Close()
 				})
 				assert.NoError(t, err)
+				store.partitioning = driver.partitioning
 				defer store.Close(context.Background())
 
 				err = store.Initialize(context.Background())
@@ -148,6 +285,65 @@ func TestStore(t *testing.T) {
 	}
 }
 
+// largeBatch returns a batch of transactions large enough to be copied, with chained hashes
+func largeBatch(first int64, size int) []core.Transaction {
+	ts := make([]core.Transaction, size)
+	for i := range ts {
+		ts[i] = core.Transaction{
+			ID: first + int64(i),
+			Postings: []core.Posting{
+				{Source: "world", Destination: fmt.Sprintf("users:%03d", i%10), Amount: 10, Asset: "USD"},
+				{Source: fmt.Sprintf("users:%03d", i%10), Destination: "fees", Amount: 1, Asset: "USD"},
+			},
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+		}
+		if i%3 == 0 {
+			ts[i].Reference = fmt.Sprintf("ref:%d", ts[i].ID)
+			ts[i].Metadata = core.Metadata{
+				"batch": json.RawMessage(`"large"`),
+			}
+		}
+		var previous *core.Transaction
+		if i > 0 {
+			previous = &ts[i-1]
+		}
+		ts[i].Hash = core.Hash(previous, &ts[i])
+	}
+	return ts
+}
+
+func testSaveLargeBatch(t *testing.T, store storage.Store) {
+	size := defaultCopyThreshold + 50
+	err := store.SaveTransactions(context.Background(), largeBatch(0, size))
+	assert.NoError(t, err)
+
+	// The saved chain is still valid
+	var previous *core.Transaction
+	for id := 0; id < size; id++ {
+		tx, err := store.GetTransaction(context.Background(), fmt.Sprintf("%d", id))
+		assert.NoError(t, err)
+		assert.Len(t, tx.Postings, 2)
+		assert.Equal(t, core.Hash(previous, &tx), tx.Hash, id)
+		if id%3 == 0 {
+			assert.Equal(t, fmt.Sprintf("ref:%d", id), tx.Reference)
+			assert.Equal(t, json.RawMessage(`"large"`), tx.Metadata["batch"])
+		}
+		previous = &tx
+	}
+
+	count, err := store.CountMeta(context.Background())
+	assert.NoError(t, err)
+	assert.EqualValues(t, (size+2)/3, count)
+
+	balance, err := store.AggregateBalance(context.Background(), "fees", "USD")
+	assert.NoError(t, err)
+	assert.EqualValues(t, size, balance)
+
+	// The constraints are enforced on the copied rows
+	err = store.SaveTransactions(context.Background(), largeBatch(int64(size-1), size))
+	assert.True(t, errors.Is(err, storage.ErrConflict), err)
+}
+
 func testSaveTransaction(t *testing.T, store storage.Store) {
 	txs := []core.Transaction{
 		{
@@ -167,12 +363,224 @@ func testSaveTransaction(t *testing.T, store storage.Store) {
 	assert.NoError(t, err)
 }
 
+func testSparseTransactionIDs(t *testing.T, store storage.Store) {
+	transfer := func(id int64) core.Transaction {
+		return core.Transaction{
+			ID: id,
+			Postings: []core.Posting{
+				{Source: "world", Destination: "users:001", Amount: 100, Asset: "USD"},
+			},
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+		}
+	}
+
+	err := store.SaveTransactions(context.Background(), []core.Transaction{
+		transfer(5),
+		transfer(9),
+	})
+	assert.NoError(t, err)
+	err = store.SaveTransactions(context.Background(), []core.Transaction{
+		transfer(100),
+	})
+	assert.NoError(t, err)
+
+	// The transactions chained to a transaction followed by another one are not saved
+	ctx := storage.WithPreviousTransaction(context.Background(), 9)
+	err = store.SaveTransactions(ctx, []core.Transaction{
+		transfer(200),
+	})
+	assert.True(t, errors.Is(err, storage.ErrConflict), err)
+
+	ctx = storage.WithPreviousTransaction(context.Background(), 100)
+	err = store.SaveTransactions(ctx, []core.Transaction{
+		transfer(150),
+	})
+	assert.NoError(t, err)
+
+	// The ids of the chained transactions must follow the last one
+	ctx = storage.WithPreviousTransaction(context.Background(), 150)
+	err = store.SaveTransactions(ctx, []core.Transaction{
+		transfer(120),
+	})
+	assert.True(t, errors.Is(err, storage.ErrConflict), err)
+
+	// The ids of the other ones must be unique
+	err = store.SaveTransactions(context.Background(), []core.Transaction{
+		transfer(150),
+	})
+	assert.True(t, errors.Is(err, storage.ErrConflict), err)
+
+	tx, err := store.GetTransaction(context.Background(), "9")
+	assert.NoError(t, err)
+	assert.EqualValues(t, 9, tx.ID)
+	missing, err := store.GetTransaction(context.Background(), "10")
+	assert.NoError(t, err)
+	assert.Nil(t, missing.Postings)
+
+	ids := func(q query.Query) []int64 {
+		c, err := store.FindTransactions(context.Background(), q)
+		assert.NoError(t, err)
+		ids := make([]int64, 0)
+		for _, tx := range c.Data.([]core.Transaction) {
+			ids = append(ids, tx.ID)
+		}
+		return ids
+	}
+	q := query.New()
+	assert.Equal(t, []int64{150, 100, 9, 5}, ids(q))
+	q.Modify(query.After("100"))
+	assert.Equal(t, []int64{9, 5}, ids(q))
+	q.Modify(query.After("9"))
+	q.Modify(query.SortTransactions(true))
+	assert.Equal(t, []int64{100, 150}, ids(q))
+
+	count, err := store.CountTransactions(context.Background())
+	assert.NoError(t, err)
+	assert.EqualValues(t, 4, count)
+
+	txid, _, err := store.Head(context.Background())
+	assert.NoError(t, err)
+	assert.EqualValues(t, 150, txid)
+
+	last, err := store.LastTransaction(context.Background())
+	assert.NoError(t, err)
+	assert.EqualValues(t, 150, last.ID)
+}
+
+func testExpiredContext(t *testing.T, store storage.Store) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	<-ctx.Done()
+
+	err := store.SaveTransactions(ctx, []core.Transaction{
+		{
+			ID: 0,
+			Postings: []core.Posting{
+				{Source: "world", Destination: "central_bank", Amount: 100, Asset: "USD"},
+			},
+			Timestamp: time.Now().Format(time.RFC3339),
+		},
+	})
+	assert.True(t, errors.Is(err, context.DeadlineExceeded), err)
+
+	count, err := store.CountTransactions(context.Background())
+	assert.NoError(t, err)
+	assert.EqualValues(t, 0, count)
+}
+
+func testSaveTransactionsWithMeta(t *testing.T, store storage.Store) {
+	now := time.Now().Format(time.RFC3339)
+	err := store.SaveTransactions(context.Background(), []core.Transaction{
+		{
+			ID: 0,
+			Postings: []core.Posting{
+				{Source: "world", Destination: "users:001", Amount: 100, Asset: "USD"},
+			},
+			Timestamp: now,
+			Metadata: core.Metadata{
+				"category": json.RawMessage(`"gold"`),
+			},
+		},
+	})
+	assert.NoError(t, err)
+
+	entries := []storage.MetaEntry{
+		{Timestamp: now, TargetType: "transaction", TargetID: "0", Key: "state", Value: `"reverted"`},
+	}
+	reverse := core.Transaction{
+		ID: 1,
+		Postings: []core.Posting{
+			{Source: "users:001", Destination: "world", Amount: 100, Asset: "USD"},
+		},
+		Timestamp: now,
+	}
+
+	// A conflicting write must not save the metadata entries
+	conflicting := reverse
+	conflicting.ID = 0
+	err = store.SaveTransactionsWithMeta(context.Background(), []core.Transaction{conflicting}, entries)
+	assert.Error(t, err)
+
+	meta, err := store.GetMeta(context.Background(), "transaction", "0")
+	assert.NoError(t, err)
+	assert.Equal(t, core.Metadata{
+		"category": json.RawMessage(`"gold"`),
+	}, meta)
+
+	err = store.SaveTransactionsWithMeta(context.Background(), []core.Transaction{reverse}, entries)
+	assert.NoError(t, err)
+
+	meta, err = store.GetMeta(context.Background(), "transaction", "0")
+	assert.NoError(t, err)
+	assert.Equal(t, core.Metadata{
+		"category": json.RawMessage(`"gold"`),
+		"state":    json.RawMessage(`"reverted"`),
+	}, meta)
+
+	lastMetaID, err := store.LastMetaID(context.Background())
+	assert.NoError(t, err)
+	assert.EqualValues(t, 1, lastMetaID)
+
+	count, err := store.CountTransactions(context.Background())
+	assert.NoError(t, err)
+	assert.EqualValues(t, 2, count)
+}
+
+func testUniqueConstraints(t *testing.T, store storage.Store) {
+	txs := []core.Transaction{
+		{
+			ID: 0,
+			Postings: []core.Posting{
+				{
+					Source:      "world",
+					Destination: "central_bank",
+					Amount:      100,
+					Asset:       "USD",
+				},
+			},
+			Reference: "foo",
+			Timestamp: time.Now().Format(time.RFC3339),
+		},
+	}
+	err := store.SaveTransactions(context.Background(), txs)
+	assert.NoError(t, err)
+
+	txs[0].ID = 1
+	err = store.SaveTransactions(context.Background(), txs)
+	assert.True(t, errors.Is(err, storage.ErrDuplicateReference))
+
+	txs[0].ID = 0
+	txs[0].Reference = ""
+	err = store.SaveTransactions(context.Background(), txs)
+	assert.True(t, errors.Is(err, storage.ErrConflict))
+}
+
 func testSaveMeta(t *testing.T, store storage.Store) {
 	err := store.SaveMeta(context.Background(), 1, time.Now().Format(time.RFC3339),
 		"transaction", "1", "firstname", "\"YYY\"")
 	assert.NoError(t, err)
 }
 
+func testSaveMetaBatch(t *testing.T, store storage.Store) {
+	now := time.Now().Format(time.RFC3339)
+	err := store.SaveMetaBatch(context.Background(), []storage.MetaEntry{
+		{ID: 0, Timestamp: now, TargetType: "account", TargetID: "central_bank", Key: "firstname", Value: "\"John\""},
+		{ID: 1, Timestamp: now, TargetType: "account", TargetID: "central_bank", Key: "firstname", Value: "\"Jane\""},
+		{ID: 2, Timestamp: now, TargetType: "account", TargetID: "world", Key: "lastname", Value: "\"Doe\""},
+	})
+	assert.NoError(t, err)
+
+	meta, err := store.GetMeta(context.Background(), "account", "central_bank")
+	assert.NoError(t, err)
+	assert.Equal(t, core.Metadata{
+		"firstname": json.RawMessage(`"Jane"`),
+	}, meta)
+
+	count, err := store.CountMeta(context.Background())
+	assert.NoError(t, err)
+	assert.EqualValues(t, 3, count)
+}
+
 func testGetMeta(t *testing.T, store storage.Store) {
 	var (
 		firstname = "\"John\""
@@ -193,6 +601,147 @@ func testGetMeta(t *testing.T, store storage.Store) {
 	}, meta)
 }
 
+func testDeleteMeta(t *testing.T, store storage.Store) {
+	err := store.SaveMeta(context.Background(), 0, time.Now().Format(time.RFC3339), "transaction", "1", "firstname", "\"John\"")
+	assert.NoError(t, err)
+
+	err = store.SaveMeta(context.Background(), 1, time.Now().Format(time.RFC3339), "transaction", "1", "lastname", "\"Doe\"")
+	assert.NoError(t, err)
+
+	err = store.DeleteMeta(context.Background(), "transaction", "1", []string{"lastname"})
+	assert.NoError(t, err)
+
+	meta, err := store.GetMeta(context.Background(), "transaction", "1")
+	assert.NoError(t, err)
+	assert.Equal(t, core.Metadata{
+		"firstname": json.RawMessage(`"John"`),
+	}, meta)
+
+	lastMetaID, err := store.LastMetaID(context.Background())
+	assert.NoError(t, err)
+	assert.EqualValues(t, 0, lastMetaID)
+}
+
+func testRecomputeVolumes(t *testing.T, store storage.Store) {
+	now := time.Now().Format(time.RFC3339)
+	err := store.SaveTransactions(context.Background(), []core.Transaction{
+		{
+			ID: 0,
+			Postings: []core.Posting{
+				{Source: "world", Destination: "users:001", Amount: 100, Asset: "USD"},
+				{Source: "world", Destination: "users:001", Amount: 10, Asset: "EUR"},
+			},
+			Timestamp: now,
+		},
+		{
+			ID: 1,
+			Postings: []core.Posting{
+				{Source: "users:001", Destination: "users:002", Amount: 30, Asset: "USD"},
+				{Source: "users:002", Destination: "users:001", Amount: 5, Asset: "USD"},
+			},
+			Timestamp: now,
+		},
+	})
+	assert.NoError(t, err)
+
+	// The maintained volumes are the aggregation of the postings
+	consistent := func() {
+		for _, address := range []string{"world", "users:001", "users:002"} {
+			volumes, err := store.AggregateVolumes(context.Background(), address)
+			assert.NoError(t, err)
+			aggregated, err := store.AggregateVolumesAt(context.Background(), address, 1)
+			assert.NoError(t, err)
+			assert.Equal(t, aggregated, volumes, address)
+		}
+	}
+	consistent()
+
+	// A drift of the volumes is repaired from the postings
+	_, err = store.(*Store).db.Exec(fmt.Sprintf(`UPDATE %s SET input = 0`, store.(*Store).table("volumes")))
+	assert.NoError(t, err)
+	balance, err := store.AggregateBalance(context.Background(), "users:001", "USD")
+	assert.NoError(t, err)
+	assert.EqualValues(t, -30, balance)
+
+	err = store.RecomputeVolumes(context.Background())
+	assert.NoError(t, err)
+	consistent()
+
+	balance, err = store.AggregateBalance(context.Background(), "users:001", "USD")
+	assert.NoError(t, err)
+	assert.EqualValues(t, 75, balance)
+}
+
+func testMetaVersion(t *testing.T, store storage.Store) {
+	version, err := store.GetMetaVersion(context.Background(), "account", "users:001")
+	assert.NoError(t, err)
+	assert.EqualValues(t, 0, version)
+
+	now := time.Now().Format(time.RFC3339)
+	err = store.SaveMetaBatch(context.Background(), []storage.MetaEntry{
+		{ID: 0, Timestamp: now, TargetType: "account", TargetID: "users:001", Key: "firstname", Value: `"John"`},
+		{ID: 1, Timestamp: now, TargetType: "account", TargetID: "users:001", Key: "lastname", Value: `"Doe"`},
+		{ID: 2, Timestamp: now, TargetType: "account", TargetID: "users:002", Key: "firstname", Value: `"Jane"`},
+	})
+	assert.NoError(t, err)
+
+	version, err = store.GetMetaVersion(context.Background(), "account", "users:001")
+	assert.NoError(t, err)
+	assert.EqualValues(t, 1, version)
+
+	err = store.SaveMeta(context.Background(), 3, now, "account", "users:001", "firstname", `"Jack"`)
+	assert.NoError(t, err)
+
+	err = store.DeleteMeta(context.Background(), "account", "users:001", []string{"lastname"})
+	assert.NoError(t, err)
+
+	version, err = store.GetMetaVersion(context.Background(), "account", "users:001")
+	assert.NoError(t, err)
+	assert.EqualValues(t, 3, version)
+
+	version, err = store.GetMetaVersion(context.Background(), "account", "users:002")
+	assert.NoError(t, err)
+	assert.EqualValues(t, 1, version)
+	// The versions are compared by the write, the metadata of the other targets don't change them
+	ctx := storage.WithMetaVersion(context.Background(), 2)
+	err = store.SaveMetaBatch(ctx, []storage.MetaEntry{
+		{ID: 5, Timestamp: now, TargetType: "account", TargetID: "users:001", Key: "firstname", Value: `"Joe"`},
+	})
+	assert.True(t, errors.Is(err, storage.ErrMetaVersionMismatch), err)
+
+	ctx = storage.WithMetaVersion(context.Background(), 3)
+	err = store.SaveMetaBatch(ctx, []storage.MetaEntry{
+		{ID: 5, Timestamp: now, TargetType: "account", TargetID: "users:001", Key: "firstname", Value: `"Joe"`},
+	})
+	assert.NoError(t, err)
+	err = store.SaveMetaBatch(ctx, []storage.MetaEntry{
+		{ID: 6, Timestamp: now, TargetType: "account", TargetID: "users:001", Key: "firstname", Value: `"Jim"`},
+	})
+	assert.True(t, errors.Is(err, storage.ErrMetaVersionMismatch), err)
+
+	ctx = storage.WithMetaVersion(context.Background(), 0)
+	err = store.SaveMetaBatch(ctx, []storage.MetaEntry{
+		{ID: 6, Timestamp: now, TargetType: "account", TargetID: "users:003", Key: "firstname", Value: `"Jim"`},
+	})
+	assert.NoError(t, err)
+	err = store.SaveMetaBatch(ctx, []storage.MetaEntry{
+		{ID: 7, Timestamp: now, TargetType: "account", TargetID: "users:003", Key: "firstname", Value: `"Jo"`},
+	})
+	assert.True(t, errors.Is(err, storage.ErrMetaVersionMismatch), err)
+
+	version, err = store.GetMetaVersion(context.Background(), "account", "users:001")
+	assert.NoError(t, err)
+	assert.EqualValues(t, 4, version)
+	meta, err := store.GetMeta(context.Background(), "account", "users:001")
+	assert.NoError(t, err)
+	assert.Equal(t, core.Metadata{
+		"firstname": json.RawMessage(`"Joe"`),
+	}, meta)
+	version, err = store.GetMetaVersion(context.Background(), "account", "users:003")
+	assert.NoError(t, err)
+	assert.EqualValues(t, 1, version)
+}
+
 func testLastTransaction(t *testing.T, store storage.Store) {
 	txs := []core.Transaction{
 		{
@@ -280,7 +829,7 @@ func testAggregateBalances(t *testing.T, store storage.Store) {
 	assert.EqualValues(t, 100, balances["USD"])
 }
 
-func testAggregateVolumes(t *testing.T, store storage.Store) {
+func testAggregateBalance(t *testing.T, store storage.Store) {
 	txs := []core.Transaction{
 		{
 			ID: 1,
@@ -291,6 +840,12 @@ func testAggregateVolumes(t *testing.T, store storage.Store) {
 					Amount:      100,
 					Asset:       "USD",
 				},
+				{
+					Source:      "world",
+					Destination: "central_bank",
+					Amount:      10,
+					Asset:       "EUR",
+				},
 			},
 			Timestamp: time.Now().Format(time.RFC3339),
 		},
@@ -298,24 +853,44 @@ func testAggregateVolumes(t *testing.T, store storage.Store) {
 	err := store.SaveTransactions(context.Background(), txs)
 	assert.NoError(t, err)
 
-	volumes, err := store.AggregateVolumes(context.Background(), "central_bank")
+	balance, err := store.AggregateBalance(context.Background(), "central_bank", "USD")
+	assert.NoError(t, err)
+	assert.EqualValues(t, 100, balance)
+
+	balance, err = store.AggregateBalance(context.Background(), "central_bank", "GBP")
 	assert.NoError(t, err)
-	assert.Len(t, volumes, 1)
-	assert.Len(t, volumes["USD"], 1)
-	assert.EqualValues(t, 100, volumes["USD"]["input"])
+	assert.EqualValues(t, 0, balance)
 }
 
-func testFindAccounts(t *testing.T, store storage.Store) {
+func testSumBalances(t *testing.T, store storage.Store) {
 	txs := []core.Transaction{
 		{
-			ID: 1,
+			ID: 0,
 			Postings: []core.Posting{
 				{
 					Source:      "world",
-					Destination: "central_bank",
+					Destination: "users:001",
 					Amount:      100,
 					Asset:       "USD",
 				},
+				{
+					Source:      "world",
+					Destination: "users:002",
+					Amount:      50,
+					Asset:       "USD",
+				},
+				{
+					Source:      "users:001",
+					Destination: "users:002",
+					Amount:      10,
+					Asset:       "USD",
+				},
+				{
+					Source:      "world",
+					Destination: "users_001",
+					Amount:      5,
+					Asset:       "EUR",
+				},
 			},
 			Timestamp: time.Now().Format(time.RFC3339),
 		},
@@ -323,38 +898,61 @@ func testFindAccounts(t *testing.T, store storage.Store) {
 	err := store.SaveTransactions(context.Background(), txs)
 	assert.NoError(t, err)
 
-	accounts, err := store.FindAccounts(context.Background(), query.Query{
-		Limit: 1,
+	balances, err := store.SumBalances(context.Background(), query.Query{
+		Params: map[string]interface{}{
+			"address": "users:",
+		},
 	})
 	assert.NoError(t, err)
-	assert.EqualValues(t, 2, accounts.Total)
-	assert.True(t, accounts.HasMore)
-	assert.Equal(t, 1, accounts.PageSize)
+	assert.Equal(t, map[string]int64{
+		"USD": 150,
+	}, balances)
 
-	accounts, err = store.FindAccounts(context.Background(), query.Query{
-		Limit: 1,
-		After: accounts.Data.([]core.Account)[0].Address,
+	accounts, err := store.FindAccounts(context.Background(), query.Query{
+		Limit: 10,
+		Params: map[string]interface{}{
+			"address": "users:",
+		},
 	})
 	assert.NoError(t, err)
-	assert.EqualValues(t, 2, accounts.Total)
-	assert.False(t, accounts.HasMore)
-	assert.Equal(t, 1, accounts.PageSize)
+	assert.Len(t, accounts.Data, 2)
 }
 
-func testCountMeta(t *testing.T, store storage.Store) {
+func testAccountPattern(t *testing.T, store storage.Store) {
 	txs := []core.Transaction{
+		{
+			ID: 0,
+			Postings: []core.Posting{
+				{
+					Source:      "world",
+					Destination: "users:001:wallet",
+					Amount:      100,
+					Asset:       "USD",
+				},
+			},
+			Timestamp: time.Now().Format(time.RFC3339),
+		},
 		{
 			ID: 1,
 			Postings: []core.Posting{
 				{
 					Source:      "world",
-					Destination: "central_bank",
+					Destination: "users:0010",
 					Amount:      100,
 					Asset:       "USD",
 				},
 			},
-			Metadata: map[string]json.RawMessage{
-				"lastname": json.RawMessage(`"XXX"`),
+			Timestamp: time.Now().Format(time.RFC3339),
+		},
+		{
+			ID: 2,
+			Postings: []core.Posting{
+				{
+					Source:      "world",
+					Destination: "users:001",
+					Amount:      100,
+					Asset:       "USD",
+				},
 			},
 			Timestamp: time.Now().Format(time.RFC3339),
 		},
@@ -362,16 +960,47 @@ func testCountMeta(t *testing.T, store storage.Store) {
 	err := store.SaveTransactions(context.Background(), txs)
 	assert.NoError(t, err)
 
-	err = store.SaveMeta(context.Background(), 1, time.Now().Format(time.RFC3339),
-		"transaction", "1", "firstname", "\"YYY\"")
+	accounts, err := store.FindAccounts(context.Background(), query.Query{
+		Limit: 10,
+		Params: map[string]interface{}{
+			"account": "users:001:*",
+		},
+	})
 	assert.NoError(t, err)
+	assert.Len(t, accounts.Data, 1)
+	assert.Equal(t, "users:001:wallet", accounts.Data.([]core.Account)[0].Address)
 
-	countMeta, err := store.CountMeta(context.Background())
+	accounts, err = store.FindAccounts(context.Background(), query.Query{
+		Limit: 10,
+		Params: map[string]interface{}{
+			"account": "users:001",
+		},
+	})
 	assert.NoError(t, err)
-	assert.EqualValues(t, 2, countMeta)
+	assert.Len(t, accounts.Data, 1)
+	assert.Equal(t, "users:001", accounts.Data.([]core.Account)[0].Address)
+
+	cursor, err := store.FindTransactions(context.Background(), query.Query{
+		Limit: 10,
+		Params: map[string]interface{}{
+			"account": "users:*",
+		},
+	})
+	assert.NoError(t, err)
+	assert.Len(t, cursor.Data, 3)
+
+	cursor, err = store.FindTransactions(context.Background(), query.Query{
+		Limit: 10,
+		Params: map[string]interface{}{
+			"account": "users:001:*",
+		},
+	})
+	assert.NoError(t, err)
+	assert.Len(t, cursor.Data, 1)
+	assert.EqualValues(t, 0, cursor.Data.([]core.Transaction)[0].ID)
 }
 
-func testCountTransactions(t *testing.T, store storage.Store) {
+func testAccountExists(t *testing.T, store storage.Store) {
 	txs := []core.Transaction{
 		{
 			ID: 1,
@@ -383,24 +1012,25 @@ func testCountTransactions(t *testing.T, store storage.Store) {
 					Asset:       "USD",
 				},
 			},
-			Metadata: map[string]json.RawMessage{
-				"lastname": json.RawMessage(`"XXX"`),
-			},
 			Timestamp: time.Now().Format(time.RFC3339),
 		},
 	}
 	err := store.SaveTransactions(context.Background(), txs)
 	assert.NoError(t, err)
 
-	countTransactions, err := store.CountTransactions(context.Background())
+	exists, err := store.AccountExists(context.Background(), "central_bank")
 	assert.NoError(t, err)
-	assert.EqualValues(t, 1, countTransactions)
+	assert.True(t, exists)
+
+	exists, err = store.AccountExists(context.Background(), "unknown")
+	assert.NoError(t, err)
+	assert.False(t, exists)
 }
 
-func testFindTransactions(t *testing.T, store storage.Store) {
+func testAggregateVolumes(t *testing.T, store storage.Store) {
 	txs := []core.Transaction{
 		{
-			ID: 0,
+			ID: 1,
 			Postings: []core.Posting{
 				{
 					Source:      "world",
@@ -410,8 +1040,90 @@ func testFindTransactions(t *testing.T, store storage.Store) {
 				},
 			},
 			Timestamp: time.Now().Format(time.RFC3339),
-			Reference: "tx1",
 		},
+	}
+	err := store.SaveTransactions(context.Background(), txs)
+	assert.NoError(t, err)
+
+	volumes, err := store.AggregateVolumes(context.Background(), "central_bank")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]map[string]int64{
+		"USD": {
+			"input":  100,
+			"output": 0,
+		},
+	}, volumes)
+
+	volumes, err = store.AggregateVolumes(context.Background(), "world")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]map[string]int64{
+		"USD": {
+			"input":  0,
+			"output": 100,
+		},
+	}, volumes)
+
+	volumes, err = store.AggregateVolumesAt(context.Background(), "central_bank", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]map[string]int64{
+		"USD": {
+			"input":  100,
+			"output": 0,
+		},
+	}, volumes)
+
+	volumes, err = store.AggregateVolumesAt(context.Background(), "central_bank", 0)
+	assert.NoError(t, err)
+	assert.Len(t, volumes, 0)
+}
+
+func testFindAccountsByMetadata(t *testing.T, store storage.Store) {
+	now := time.Now().Format(time.RFC3339)
+	err := store.SaveTransactions(context.Background(), []core.Transaction{
+		{
+			ID: 0,
+			Postings: []core.Posting{
+				{Source: "world", Destination: "users:001", Amount: 100, Asset: "USD"},
+				{Source: "world", Destination: "users:002", Amount: 200, Asset: "USD"},
+				{Source: "world", Destination: "users:003", Amount: 300, Asset: "USD"},
+			},
+			Timestamp: now,
+		},
+	})
+	assert.NoError(t, err)
+
+	err = store.SaveMetaBatch(context.Background(), []storage.MetaEntry{
+		{ID: 0, Timestamp: now, TargetType: "account", TargetID: "users:001", Key: "kyc_status", Value: `"verified"`},
+		{ID: 1, Timestamp: now, TargetType: "account", TargetID: "users:002", Key: "kyc_status", Value: `"pending"`},
+		{ID: 2, Timestamp: now, TargetType: "account", TargetID: "users:003", Key: "kyc_status", Value: `"verified"`},
+		{ID: 3, Timestamp: now, TargetType: "account", TargetID: "users:003", Key: "kyc", Value: `{"level": 2}`},
+		{ID: 4, Timestamp: now, TargetType: "account", TargetID: "users:002", Key: "kyc_status", Value: `"verified"`},
+		{ID: 5, Timestamp: now, TargetType: "account", TargetID: "users:001", Key: "kyc_status", Value: `"revoked"`},
+	})
+	assert.NoError(t, err)
+
+	find := func(modifiers ...query.QueryModifier) []string {
+		cursor, err := store.FindAccounts(context.Background(), query.New(modifiers))
+		assert.NoError(t, err)
+		addresses := make([]string, 0)
+		for _, account := range cursor.Data.([]core.Account) {
+			addresses = append(addresses, account.Address)
+		}
+		return addresses
+	}
+
+	assert.Equal(t, []string{"users:003", "users:002"}, find(query.AccountMetadata("kyc_status", "verified")))
+	assert.Equal(t, []string{"users:003"}, find(query.AccountMetadata("kyc_status", "verified"), query.AccountMetadata("kyc.level", "2")))
+	assert.Equal(t, []string{"users:002"}, find(query.AccountMetadata("kyc_status", "verified"), query.Limit(1), query.After("users:003")))
+	assert.Equal(t, []string{"users:001"}, find(query.AccountMetadata("kyc_status", "revoked")))
+	assert.Equal(t, []string{"users:003", "users:002"}, find(
+		query.AccountMetadata("kyc_status", "verified"),
+		query.SortAccounts(query.Sort{By: query.SortByBalance, Asset: "USD"}),
+	))
+}
+
+func testFindAccounts(t *testing.T, store storage.Store) {
+	txs := []core.Transaction{
 		{
 			ID: 1,
 			Postings: []core.Posting{
@@ -423,41 +1135,89 @@ func testFindTransactions(t *testing.T, store storage.Store) {
 				},
 			},
 			Timestamp: time.Now().Format(time.RFC3339),
-			Reference: "tx2",
 		},
 	}
 	err := store.SaveTransactions(context.Background(), txs)
 	assert.NoError(t, err)
 
-	cursor, err := store.FindTransactions(context.Background(), query.Query{
+	accounts, err := store.FindAccounts(context.Background(), query.Query{
 		Limit: 1,
 	})
 	assert.NoError(t, err)
-	assert.Equal(t, 1, cursor.PageSize)
-	assert.True(t, cursor.HasMore)
+	assert.EqualValues(t, 2, accounts.Total)
+	assert.True(t, accounts.HasMore)
+	assert.Equal(t, 1, accounts.PageSize)
 
-	cursor, err = store.FindTransactions(context.Background(), query.Query{
-		After: fmt.Sprint(cursor.Data.([]core.Transaction)[0].ID),
+	accounts, err = store.FindAccounts(context.Background(), query.Query{
 		Limit: 1,
+		After: accounts.Data.([]core.Account)[0].Address,
 	})
 	assert.NoError(t, err)
-	assert.Equal(t, 1, cursor.PageSize)
-	assert.False(t, cursor.HasMore)
+	assert.EqualValues(t, 2, accounts.Total)
+	assert.False(t, accounts.HasMore)
+	assert.Equal(t, 1, accounts.PageSize)
+}
 
-	cursor, err = store.FindTransactions(context.Background(), query.Query{
-		Params: map[string]interface{}{
-			"account":   "world",
-			"reference": "tx1",
-		},
-		Limit: 1,
-	})
+func testSortAccounts(t *testing.T, store storage.Store) {
+	txs := make([]core.Transaction, 0)
+	for i, p := range []core.Posting{
+		{Source: "world", Destination: "users:a", Amount: 100, Asset: "USD"},
+		{Source: "world", Destination: "users:b", Amount: 300, Asset: "USD"},
+		{Source: "world", Destination: "users:c", Amount: 100, Asset: "USD"},
+		{Source: "world", Destination: "users:d", Amount: 50, Asset: "EUR"},
+		{Source: "users:b", Destination: "users:a", Amount: 50, Asset: "USD"},
+	} {
+		txs = append(txs, core.Transaction{
+			ID:        int64(i),
+			Postings:  []core.Posting{p},
+			Timestamp: time.Now().Format(time.RFC3339),
+		})
+	}
+	err := store.SaveTransactions(context.Background(), txs)
 	assert.NoError(t, err)
-	assert.Equal(t, 1, cursor.PageSize)
-	assert.False(t, cursor.HasMore)
 
+	addresses := func(m ...query.QueryModifier) []string {
+		cursor, err := store.FindAccounts(context.Background(), query.New(m))
+		assert.NoError(t, err)
+		addresses := make([]string, 0)
+		for _, account := range cursor.Data.([]core.Account) {
+			addresses = append(addresses, account.Address)
+		}
+		return addresses
+	}
+
+	byAddress := query.SortAccounts(query.Sort{By: query.SortByAddress, Asc: true})
+	assert.Equal(t, []string{"users:a", "users:b"}, addresses(byAddress, query.Limit(2)))
+	assert.Equal(t, []string{"users:c", "users:d"}, addresses(byAddress, query.Limit(2), query.After("users:b")))
+	assert.Equal(t, []string{"users:a", "users:b"}, addresses(byAddress, query.Limit(2), query.Before("users:c")))
+
+	// The balances up to the transaction 3, the ties are sorted by address
+	txid := int64(3)
+	byBalance := query.SortAccounts(query.Sort{By: query.SortByBalance, Asset: "USD", TxID: &txid})
+
+	accounts, err := store.FindAccounts(context.Background(), query.New([]query.QueryModifier{byBalance, query.Limit(2)}))
+	assert.NoError(t, err)
+	assert.True(t, accounts.HasMore)
+	assert.Equal(t, []core.Account{
+		{Address: "users:b", Contract: "default", Balances: map[string]int64{"USD": 300}, Metadata: core.Metadata{}},
+		{Address: "users:c", Contract: "default", Balances: map[string]int64{"USD": 100}, Metadata: core.Metadata{}},
+	}, accounts.Data)
+
+	next := query.After(query.BalanceKeyset(100, "users:c"))
+	assert.Equal(t, []string{"users:a", "users:d"}, addresses(byBalance, query.Limit(2), next))
+	next = query.After(query.BalanceKeyset(0, "users:d"))
+	assert.Equal(t, []string{"world"}, addresses(byBalance, query.Limit(2), next))
+	previous := query.Before(query.BalanceKeyset(100, "users:a"))
+	assert.Equal(t, []string{"users:b", "users:c"}, addresses(byBalance, query.Limit(2), previous))
+
+	// The current balances, in ascending order
+	byBalance = query.SortAccounts(query.Sort{By: query.SortByBalance, Asset: "USD", Asc: true})
+	assert.Equal(t, []string{"users:d", "users:c", "users:a", "users:b"},
+		addresses(byBalance, query.Address("users:")))
 }
 
-func testGetTransaction(t *testing.T, store storage.Store) {
+func testDrop(t *testing.T, store storage.Store) {
+	// The key is recorded with the time it is saved, whatever the timestamps of the transactions
 	txs := []core.Transaction{
 		{
 			ID: 0,
@@ -469,30 +1229,985 @@ func testGetTransaction(t *testing.T, store storage.Store) {
 					Asset:       "USD",
 				},
 			},
+			Timestamp: time.Now().Add(-48 * time.Hour).Format(time.RFC3339),
+		},
+	}
+	err := store.SaveTransactions(context.Background(), txs)
+	assert.NoError(t, err)
+
+	err = store.Drop(context.Background())
+	assert.NoError(t, err)
+
+	_, err = store.CountTransactions(context.Background())
+	assert.Error(t, err)
+
+	err = store.Initialize(context.Background())
+	assert.NoError(t, err)
+
+	count, err := store.CountTransactions(context.Background())
+	assert.NoError(t, err)
+	assert.EqualValues(t, 0, count)
+
+	err = store.SaveTransactions(context.Background(), txs)
+	assert.NoError(t, err)
+}
+
+func testGetAccounts(t *testing.T, store storage.Store) {
+	txs := []core.Transaction{
+		{
+			ID: 0,
+			Postings: []core.Posting{
+				{
+					Source:      "world",
+					Destination: "users:001",
+					Amount:      100,
+					Asset:       "USD",
+				},
+				{
+					Source:      "users:001",
+					Destination: "users:002",
+					Amount:      30,
+					Asset:       "USD",
+				},
+				{
+					Source:      "world",
+					Destination: "users:002",
+					Amount:      5,
+					Asset:       "EUR",
+				},
+			},
 			Timestamp: time.Now().Format(time.RFC3339),
-			Reference: "tx1",
-			Metadata:  core.Metadata{},
+		},
+	}
+	err := store.SaveTransactions(context.Background(), txs)
+	assert.NoError(t, err)
+
+	err = store.SaveMeta(context.Background(), 0, time.Now().Format(time.RFC3339),
+		"account", "users:002", "firstname", `"John"`)
+	assert.NoError(t, err)
+	err = store.SaveMeta(context.Background(), 1, time.Now().Format(time.RFC3339),
+		"account", "users:003", "firstname", `"Jane"`)
+	assert.NoError(t, err)
+
+	accounts, err := store.GetAccounts(context.Background(), []string{"users:002", "unknown", "users:001", "users:003"})
+	assert.NoError(t, err)
+	assert.Equal(t, []core.Account{
+		{
+			Address:  "users:002",
+			Contract: "default",
+			Balances: map[string]int64{
+				"USD": 30,
+				"EUR": 5,
+			},
+			Metadata: core.Metadata{
+				"firstname": json.RawMessage(`"John"`),
+			},
 		},
 		{
-			ID: 1,
+			Address:  "unknown",
+			Contract: "default",
+			Balances: map[string]int64{},
+		},
+		{
+			Address:  "users:001",
+			Contract: "default",
+			Balances: map[string]int64{
+				"USD": 70,
+			},
+			Metadata: core.Metadata{},
+		},
+		{
+			Address:  "users:003",
+			Contract: "default",
+			Balances: map[string]int64{},
+			Metadata: core.Metadata{
+				"firstname": json.RawMessage(`"Jane"`),
+			},
+		},
+	}, accounts)
+
+	accounts, err = store.GetAccounts(context.Background(), []string{})
+	assert.NoError(t, err)
+	assert.Empty(t, accounts)
+}
+
+func testFindBalances(t *testing.T, store storage.Store) {
+	txs := []core.Transaction{
+		{
+			ID: 0,
 			Postings: []core.Posting{
 				{
 					Source:      "world",
-					Destination: "central_bank",
+					Destination: "users:001",
 					Amount:      100,
 					Asset:       "USD",
 				},
+				{
+					Source:      "users:001",
+					Destination: "users:002",
+					Amount:      30,
+					Asset:       "USD",
+				},
+				{
+					Source:      "world",
+					Destination: "users:002",
+					Amount:      5,
+					Asset:       "EUR",
+				},
 			},
 			Timestamp: time.Now().Format(time.RFC3339),
-			Reference: "tx2",
-			Metadata:  core.Metadata{},
 		},
 	}
 	err := store.SaveTransactions(context.Background(), txs)
 	assert.NoError(t, err)
 
-	tx, err := store.GetTransaction(context.Background(), "1")
+	balances, err := store.FindBalances(context.Background(), query.Query{
+		Limit: 2,
+	})
 	assert.NoError(t, err)
-	assert.Equal(t, txs[1], tx)
+	assert.EqualValues(t, 3, balances.Total)
+	assert.True(t, balances.HasMore)
+	assert.Equal(t, []core.Account{
+		{
+			Address:  "world",
+			Contract: "default",
+			Balances: map[string]int64{
+				"USD": -100,
+				"EUR": -5,
+			},
+		},
+		{
+			Address:  "users:002",
+			Contract: "default",
+			Balances: map[string]int64{
+				"USD": 30,
+				"EUR": 5,
+			},
+		},
+	}, balances.Data)
 
+	balances, err = store.FindBalances(context.Background(), query.Query{
+		Limit: 2,
+		After: "users:002",
+	})
+	assert.NoError(t, err)
+	assert.False(t, balances.HasMore)
+	assert.Equal(t, []core.Account{
+		{
+			Address:  "users:001",
+			Contract: "default",
+			Balances: map[string]int64{
+				"USD": 70,
+			},
+		},
+	}, balances.Data)
+}
+
+func testCountMeta(t *testing.T, store storage.Store) {
+	txs := []core.Transaction{
+		{
+			ID: 1,
+			Postings: []core.Posting{
+				{
+					Source:      "world",
+					Destination: "central_bank",
+					Amount:      100,
+					Asset:       "USD",
+				},
+			},
+			Metadata: map[string]json.RawMessage{
+				"lastname": json.RawMessage(`"XXX"`),
+			},
+			Timestamp: time.Now().Format(time.RFC3339),
+		},
+	}
+	err := store.SaveTransactions(context.Background(), txs)
+	assert.NoError(t, err)
+
+	err = store.SaveMeta(context.Background(), 1, time.Now().Format(time.RFC3339),
+		"transaction", "1", "firstname", "\"YYY\"")
+	assert.NoError(t, err)
+
+	countMeta, err := store.CountMeta(context.Background())
+	assert.NoError(t, err)
+	assert.EqualValues(t, 2, countMeta)
+}
+
+func testCountTransactions(t *testing.T, store storage.Store) {
+	txs := []core.Transaction{
+		{
+			ID: 1,
+			Postings: []core.Posting{
+				{
+					Source:      "world",
+					Destination: "central_bank",
+					Amount:      100,
+					Asset:       "USD",
+				},
+			},
+			Metadata: map[string]json.RawMessage{
+				"lastname": json.RawMessage(`"XXX"`),
+			},
+			Timestamp: time.Now().Format(time.RFC3339),
+		},
+	}
+	err := store.SaveTransactions(context.Background(), txs)
+	assert.NoError(t, err)
+
+	countTransactions, err := store.CountTransactions(context.Background())
+	assert.NoError(t, err)
+	assert.EqualValues(t, 1, countTransactions)
+}
+
+func testFindTransactionsAscending(t *testing.T, store storage.Store) {
+	txs := make([]core.Transaction, 0)
+	for i := int64(0); i < 10; i++ {
+		txs = append(txs, core.Transaction{
+			ID: i,
+			Postings: []core.Posting{
+				{Source: "world", Destination: fmt.Sprintf("users:%03d", i%2), Amount: 10, Asset: "USD"},
+			},
+			Timestamp: time.Now().Format(time.RFC3339),
+		})
+	}
+	assert.NoError(t, store.SaveTransactions(context.Background(), txs))
+
+	ids := func(m ...query.QueryModifier) []int64 {
+		cursor, err := store.FindTransactions(context.Background(), query.New(m))
+		assert.NoError(t, err)
+		ids := []int64{}
+		for _, tx := range cursor.Data.([]core.Transaction) {
+			ids = append(ids, tx.ID)
+		}
+		return ids
+	}
+
+	asc := query.SortTransactions(true)
+	assert.Equal(t, []int64{0, 1, 2}, ids(asc, query.Limit(3)))
+	assert.Equal(t, []int64{3, 4, 5}, ids(asc, query.Limit(3), query.After("2")))
+	assert.Equal(t, []int64{9}, ids(asc, query.Limit(3), query.After("8")))
+	// The previous page keeps the ascending order
+	assert.Equal(t, []int64{2, 3, 4}, ids(asc, query.Limit(3), query.Before("5")))
+	assert.Equal(t, []int64{1, 3}, ids(asc, query.Limit(2), query.Account("users:001")))
+	assert.Equal(t, []int64{5, 7}, ids(asc, query.Limit(2), query.Account("users:001"), query.After("3")))
+	assert.Equal(t, []int64{9, 8, 7}, ids(query.SortTransactions(false), query.Limit(3)))
+
+	cursor, err := store.FindTransactions(context.Background(), query.New([]query.QueryModifier{asc, query.Limit(3), query.After("6")}))
+	assert.NoError(t, err)
+	assert.False(t, cursor.HasMore)
+	cursor, err = store.FindTransactions(context.Background(), query.New([]query.QueryModifier{asc, query.Limit(3), query.After("5")}))
+	assert.NoError(t, err)
+	assert.True(t, cursor.HasMore)
+}
+
+func testFindTransactionsByReferencePrefix(t *testing.T, store storage.Store) {
+	txs := make([]core.Transaction, 0)
+	for i, ref := range []string{"import_1", "import_2", "other", "", "import%_3"} {
+		txs = append(txs, core.Transaction{
+			ID: int64(i),
+			Postings: []core.Posting{
+				{Source: "world", Destination: "users:001", Amount: 10, Asset: "USD"},
+			},
+			Reference: ref,
+			Timestamp: time.Now().Format(time.RFC3339),
+		})
+	}
+	assert.NoError(t, store.SaveTransactions(context.Background(), txs))
+
+	ids := func(prefix string) []int64 {
+		cursor, err := store.FindTransactions(context.Background(), query.New([]query.QueryModifier{
+			query.ReferencePrefix(prefix),
+		}))
+		assert.NoError(t, err)
+		ids := []int64{}
+		for _, tx := range cursor.Data.([]core.Transaction) {
+			ids = append(ids, tx.ID)
+		}
+		return ids
+	}
+
+	assert.Equal(t, []int64{4, 1, 0}, ids("import"))
+	assert.Equal(t, []int64{1, 0}, ids("import_"))
+	// The wildcards of the prefix are matched literally
+	assert.Equal(t, []int64{4}, ids("import%"))
+	assert.Equal(t, []int64{4, 3, 2, 1, 0}, ids(""))
+}
+
+func testReferenceScopes(t *testing.T, store storage.Store) {
+	tx := func(id int64, ref string, assets ...string) core.Transaction {
+		t := core.Transaction{
+			ID:        id,
+			Reference: ref,
+			Timestamp: time.Now().Format(time.RFC3339),
+		}
+		for _, asset := range assets {
+			t.Postings = append(t.Postings, core.Posting{Source: "world", Destination: "users:001", Amount: 10, Asset: asset})
+		}
+		return t
+	}
+	save := func(scope storage.ReferenceScope, ts ...core.Transaction) error {
+		return store.SaveTransactions(storage.WithReferenceScope(context.Background(), scope), ts)
+	}
+
+	// The references are unique among all the transactions by default
+	assert.NoError(t, store.SaveTransactions(context.Background(), []core.Transaction{tx(0, "global", "USD")}))
+	err := save(storage.ReferenceScopeGlobal, tx(1, "global", "EUR"))
+	assert.True(t, errors.Is(err, storage.ErrDuplicateReference), err)
+
+	assert.NoError(t, save(storage.ReferenceScopePerAsset, tx(1, "asset", "USD"), tx(2, "asset", "EUR")))
+	err = save(storage.ReferenceScopePerAsset, tx(3, "asset", "GEM", "USD"))
+	assert.True(t, errors.Is(err, storage.ErrDuplicateReference), err)
+	err = save(storage.ReferenceScopePerAsset, tx(3, "batch", "GEM"), tx(4, "batch", "GEM"))
+	assert.True(t, errors.Is(err, storage.ErrDuplicateReference), err)
+
+	assert.NoError(t, save(storage.ReferenceScopeNone, tx(3, "none", "USD"), tx(4, "none", "USD")))
+	assert.NoError(t, save(storage.ReferenceScopeNone, tx(5, "global", "USD")))
+
+	count, err := store.CountTransactions(context.Background())
+	assert.NoError(t, err)
+	assert.EqualValues(t, 6, count)
+
+	// The references are found whatever the scope they were saved in
+	used, err := store.FindUsedReferences(context.Background(), []storage.ReferenceKey{
+		{Reference: "global"},
+		{Reference: "global", Asset: "USD"},
+		{Reference: "global", Asset: "GEM"},
+		{Reference: "asset"},
+		{Reference: "asset", Asset: "EUR"},
+		{Reference: "none", Asset: "USD"},
+		{Reference: "batch"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []storage.ReferenceKey{
+		{Reference: "global"},
+		{Reference: "global", Asset: "USD"},
+		{Reference: "asset"},
+		{Reference: "asset", Asset: "EUR"},
+		{Reference: "none", Asset: "USD"},
+	}, used)
+
+	used, err = store.FindUsedReferences(context.Background(), nil)
+	assert.NoError(t, err)
+	assert.Empty(t, used)
+}
+
+func testFindAssets(t *testing.T, store storage.Store) {
+	txs := []core.Transaction{}
+	for i, p := range []core.Posting{
+		{Source: "world", Destination: "users:001", Amount: 100, Asset: "USD"},
+		{Source: "world", Destination: "users:002", Amount: 100, Asset: "EUR"},
+		{Source: "users:001", Destination: "users:002", Amount: 10, Asset: "USD"},
+		{Source: "world", Destination: "users:001", Amount: 100, Asset: "GEM/2"},
+	} {
+		txs = append(txs, core.Transaction{
+			ID:        int64(i),
+			Postings:  []core.Posting{p},
+			Timestamp: time.Now().Format(time.RFC3339),
+		})
+	}
+	assets, err := store.FindAssets(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []string{}, assets)
+
+	assert.NoError(t, store.SaveTransactions(context.Background(), txs))
+
+	assets, err = store.FindAssets(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"EUR", "GEM/2", "USD"}, assets)
+}
+
+func testFindMetaTargets(t *testing.T, store storage.Store) {
+	ids, err := store.FindMetaTargets(context.Background(), "asset")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{}, ids)
+
+	for i, target := range []string{"USD", "EUR", "USD"} {
+		err := store.SaveMeta(context.Background(), int64(i), time.Now().Format(time.RFC3339), "asset", target, "scale", "2")
+		assert.NoError(t, err)
+	}
+	err = store.SaveMeta(context.Background(), 3, time.Now().Format(time.RFC3339), "account", "users:001", "scale", "2")
+	assert.NoError(t, err)
+
+	ids, err = store.FindMetaTargets(context.Background(), "asset")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"EUR", "USD"}, ids)
+
+	assert.NoError(t, store.DeleteMeta(context.Background(), "asset", "EUR", []string{"scale"}))
+	ids, err = store.FindMetaTargets(context.Background(), "asset")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"USD"}, ids)
+}
+
+func testFindTransactionsByMetadata(t *testing.T, store storage.Store) {
+	now := time.Now().Format(time.RFC3339)
+	txs := []core.Transaction{
+		{
+			ID: 0,
+			Postings: []core.Posting{
+				{Source: "world", Destination: "users:001", Amount: 100, Asset: "USD"},
+			},
+			Metadata: core.Metadata{
+				"order_id": json.RawMessage(`"order-1"`),
+				"customer": json.RawMessage(`{"id": "customer-1", "vip": true}`),
+			},
+			Timestamp: now,
+		},
+		{
+			ID: 1,
+			Postings: []core.Posting{
+				{Source: "world", Destination: "users:002", Amount: 100, Asset: "USD"},
+			},
+			Metadata: core.Metadata{
+				"order_id": json.RawMessage(`"order-2"`),
+				"customer": json.RawMessage(`{"id": "customer-1", "vip": false}`),
+				"attempt":  json.RawMessage(`2`),
+			},
+			Timestamp: now,
+		},
+	}
+	err := store.SaveTransactions(context.Background(), txs)
+	assert.NoError(t, err)
+
+	find := func(modifiers ...query.QueryModifier) []int64 {
+		cursor, err := store.FindTransactions(context.Background(), query.New(modifiers))
+		assert.NoError(t, err)
+		ids := make([]int64, 0)
+		for _, tx := range cursor.Data.([]core.Transaction) {
+			ids = append(ids, tx.ID)
+		}
+		return ids
+	}
+
+	assert.Equal(t, []int64{0}, find(query.Metadata("order_id", "order-1")))
+	assert.Equal(t, []int64{1, 0}, find(query.Metadata("customer.id", "customer-1")))
+	assert.Equal(t, []int64{0}, find(query.Metadata("customer.id", "customer-1"), query.Metadata("customer.vip", "true")))
+	assert.Equal(t, []int64{1}, find(query.Metadata("attempt", "2")))
+	assert.Equal(t, []int64{}, find(query.Metadata("customer", "customer-1")))
+	assert.Equal(t, []int64{}, find(query.Metadata("order_id.id", "order-1")))
+
+	// Only the latest value of a key is matched
+	err = store.SaveMeta(context.Background(), 10, now, "transaction", "0", "order_id", `"order-3"`)
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{}, find(query.Metadata("order_id", "order-1")))
+	assert.Equal(t, []int64{0}, find(query.Metadata("order_id", "order-3")))
+}
+
+func testFindTransactions(t *testing.T, store storage.Store) {
+	txs := []core.Transaction{
+		{
+			ID: 0,
+			Postings: []core.Posting{
+				{
+					Source:      "world",
+					Destination: "central_bank",
+					Amount:      100,
+					Asset:       "USD",
+				},
+			},
+			Timestamp: time.Now().Format(time.RFC3339),
+			Reference: "tx1",
+		},
+		{
+			ID: 1,
+			Postings: []core.Posting{
+				{
+					Source:      "world",
+					Destination: "central_bank",
+					Amount:      100,
+					Asset:       "USD",
+				},
+			},
+			Timestamp: time.Now().Format(time.RFC3339),
+			Reference: "tx2",
+		},
+		{
+			ID: 2,
+			Postings: []core.Posting{
+				{
+					Source:      "central_bank",
+					Destination: "users:1",
+					Amount:      10,
+					Asset:       "EUR",
+				},
+			},
+			Timestamp: time.Now().Format(time.RFC3339),
+			Reference: "tx3",
+		},
+	}
+	err := store.SaveTransactions(context.Background(), txs)
+	assert.NoError(t, err)
+
+	cursor, err := store.FindTransactions(context.Background(), query.Query{
+		Params: map[string]interface{}{
+			"account": "central_bank",
+			"asset":   "USD",
+		},
+		Limit: 10,
+	})
+	assert.NoError(t, err)
+	assert.Len(t, cursor.Data.([]core.Transaction), 2)
+
+	cursor, err = store.FindTransactions(context.Background(), query.Query{
+		Params: map[string]interface{}{
+			"account": "users:1",
+		},
+		Limit: 10,
+	})
+	assert.NoError(t, err)
+	assert.Len(t, cursor.Data.([]core.Transaction), 1)
+
+	cursor, err = store.FindTransactions(context.Background(), query.Query{
+		After: "2",
+		Limit: 1,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, cursor.PageSize)
+	assert.True(t, cursor.HasMore)
+
+	cursor, err = store.FindTransactions(context.Background(), query.Query{
+		After: fmt.Sprint(cursor.Data.([]core.Transaction)[0].ID),
+		Limit: 1,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, cursor.PageSize)
+	assert.False(t, cursor.HasMore)
+
+	cursor, err = store.FindTransactions(context.Background(), query.Query{
+		Before: "0",
+		Limit:  10,
+	})
+	assert.NoError(t, err)
+	assert.False(t, cursor.HasMore)
+	assert.Len(t, cursor.Data.([]core.Transaction), 2)
+	assert.EqualValues(t, 2, cursor.Data.([]core.Transaction)[0].ID)
+
+	cursor, err = store.FindTransactions(context.Background(), query.Query{
+		Params: map[string]interface{}{
+			"account":   "world",
+			"reference": "tx1",
+		},
+		Limit: 1,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, cursor.PageSize)
+	assert.False(t, cursor.HasMore)
+
+}
+
+func testGetTransaction(t *testing.T, store storage.Store) {
+	txs := []core.Transaction{
+		{
+			ID: 0,
+			Postings: []core.Posting{
+				{
+					Source:      "world",
+					Destination: "central_bank",
+					Amount:      100,
+					Asset:       "USD",
+				},
+			},
+			Timestamp: time.Now().Format(time.RFC3339),
+			Reference: "tx1",
+			Metadata:  core.Metadata{},
+		},
+		{
+			ID: 1,
+			Postings: []core.Posting{
+				{
+					Source:      "world",
+					Destination: "central_bank",
+					Amount:      100,
+					Asset:       "USD",
+				},
+			},
+			Timestamp: time.Now().Format(time.RFC3339),
+			Reference: "tx2",
+			Metadata:  core.Metadata{},
+		},
+	}
+	err := store.SaveTransactions(context.Background(), txs)
+	assert.NoError(t, err)
+
+	tx, err := store.GetTransaction(context.Background(), "1")
+	assert.NoError(t, err)
+	assert.Equal(t, txs[1], tx)
+
+}
+
+func testScripts(t *testing.T, store storage.Store) {
+	script, err := store.GetScript(context.Background(), "payout", 0)
+	assert.NoError(t, err)
+	assert.Nil(t, script)
+
+	scripts := []core.StoredScript{
+		{Name: "payout", Version: 1, Plain: "send [COIN 1] (source = @world destination = @users:001)", Timestamp: "2022-01-01T00:00:00Z"},
+		{Name: "payout", Version: 2, Plain: "send [COIN 2] (source = @world destination = @users:001)", Timestamp: "2022-01-02T00:00:00Z"},
+		{Name: "fees", Version: 1, Plain: "send [COIN 3] (source = @world destination = @fees)", Timestamp: "2022-01-03T00:00:00Z"},
+	}
+	for _, s := range scripts {
+		assert.NoError(t, store.SaveScript(context.Background(), s))
+	}
+
+	err = store.SaveScript(context.Background(), core.StoredScript{Name: "payout", Version: 2, Plain: "fail"})
+	assert.True(t, errors.Is(err, storage.ErrConflict), err)
+
+	script, err = store.GetScript(context.Background(), "payout", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, &scripts[1], script)
+
+	script, err = store.GetScript(context.Background(), "payout", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, &scripts[0], script)
+
+	script, err = store.GetScript(context.Background(), "payout", 3)
+	assert.NoError(t, err)
+	assert.Nil(t, script)
+
+	found, err := store.FindScripts(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []core.StoredScript{scripts[2], scripts[1]}, found)
+}
+
+func testWebhooks(t *testing.T, store storage.Store) {
+	webhook, err := store.GetWebhook(context.Background(), "hook1")
+	assert.NoError(t, err)
+	assert.Nil(t, webhook)
+
+	webhooks := []core.Webhook{
+		{ID: "hook1", URL: "http://localhost/hook1", Secret: "secret1", Accounts: []string{"users:*", "fees"}, Cursor: -1, Timestamp: "2022-01-01T00:00:00Z"},
+		{ID: "hook0", URL: "http://localhost/hook0", Secret: "secret0", Cursor: 3, Timestamp: "2022-01-02T00:00:00Z"},
+	}
+	for _, w := range webhooks {
+		assert.NoError(t, store.SaveWebhook(context.Background(), w))
+	}
+
+	webhook, err = store.GetWebhook(context.Background(), "hook1")
+	assert.NoError(t, err)
+	assert.Equal(t, &webhooks[0], webhook)
+
+	found, err := store.FindWebhooks(context.Background())
+	assert.NoError(t, err)
+	if assert.Len(t, found, 2) {
+		assert.Equal(t, "hook1", found[0].ID)
+		assert.Equal(t, "hook0", found[1].ID)
+	}
+
+	// The deliveries move the cursor of their webhook
+	deliveries := []core.WebhookDelivery{
+		{WebhookID: "hook1", TransactionID: 1, Status: core.WebhookDelivered, Attempts: 1, StatusCode: 200, Timestamp: "2022-01-03T00:00:00Z"},
+		{WebhookID: "hook1", TransactionID: 4, Status: core.WebhookDead, Attempts: 5, StatusCode: 500, Error: "unexpected status 500", Timestamp: "2022-01-04T00:00:00Z"},
+	}
+	for _, d := range deliveries {
+		assert.NoError(t, store.SaveWebhookDelivery(context.Background(), d))
+	}
+	webhook, err = store.GetWebhook(context.Background(), "hook1")
+	assert.NoError(t, err)
+	assert.EqualValues(t, 4, webhook.Cursor)
+
+	assert.NoError(t, store.UpdateWebhookCursor(context.Background(), "hook0", 6))
+	webhook, err = store.GetWebhook(context.Background(), "hook0")
+	assert.NoError(t, err)
+	assert.EqualValues(t, 6, webhook.Cursor)
+
+	history, err := store.FindWebhookDeliveries(context.Background(), "hook1")
+	assert.NoError(t, err)
+	assert.Equal(t, []core.WebhookDelivery{deliveries[1], deliveries[0]}, history)
+
+	history, err = store.FindWebhookDeliveries(context.Background(), "hook0")
+	assert.NoError(t, err)
+	assert.Empty(t, history)
+}
+
+func testHead(t *testing.T, store storage.Store) {
+	txid, hash, err := store.Head(context.Background())
+	assert.NoError(t, err)
+	assert.EqualValues(t, -1, txid)
+	assert.Empty(t, hash)
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	err = store.SaveTransactions(context.Background(), []core.Transaction{
+		{
+			ID: 0,
+			Postings: []core.Posting{
+				{Source: "world", Destination: "users:001", Amount: 100, Asset: "USD"},
+			},
+			Timestamp: now,
+			Hash:      "hash0",
+		},
+		{
+			ID: 1,
+			Postings: []core.Posting{
+				{Source: "world", Destination: "users:001", Amount: 100, Asset: "USD"},
+			},
+			Timestamp: now,
+			Hash:      "hash1",
+		},
+	})
+	assert.NoError(t, err)
+
+	txid, hash, err = store.Head(context.Background())
+	assert.NoError(t, err)
+	assert.EqualValues(t, 1, txid)
+	assert.Equal(t, "hash1", hash)
+}
+
+func testGetTransactionByHash(t *testing.T, store storage.Store) {
+	now := time.Now().UTC().Format(time.RFC3339)
+	txs := []core.Transaction{
+		{
+			ID: 0,
+			Postings: []core.Posting{
+				{Source: "world", Destination: "users:001", Amount: 100, Asset: "USD"},
+			},
+			Timestamp: now,
+			Hash:      "hash0",
+		},
+		{
+			ID: 1,
+			Postings: []core.Posting{
+				{Source: "world", Destination: "users:002", Amount: 50, Asset: "USD"},
+			},
+			Timestamp: now,
+			Hash:      "hash1",
+		},
+	}
+	assert.NoError(t, store.SaveTransactions(context.Background(), txs))
+
+	tx, err := store.GetTransactionByHash(context.Background(), "hash1")
+	assert.NoError(t, err)
+	assert.EqualValues(t, 1, tx.ID)
+	assert.Equal(t, "hash1", tx.Hash)
+	assert.Equal(t, txs[1].Postings, tx.Postings)
+
+	tx, err = store.GetTransactionByHash(context.Background(), "unknown")
+	assert.NoError(t, err)
+	assert.Empty(t, tx.Postings)
+}
+
+func testAggregateFlow(t *testing.T, store storage.Store) {
+	transfer := func(id int64, source, destination string, amount int64) core.Transaction {
+		return core.Transaction{
+			ID: id,
+			Postings: []core.Posting{
+				{Source: source, Destination: destination, Amount: amount, Asset: "USD"},
+			},
+		}
+	}
+	multi := transfer(0, "users:001", "merchants:001", 100)
+	multi.Postings = append(multi.Postings,
+		core.Posting{Source: "users:001", Destination: "merchants:001", Amount: 10, Asset: "EUR"},
+		core.Posting{Source: "users:001", Destination: "merchants:002", Amount: 10, Asset: "USD"},
+	)
+	base := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(tx core.Transaction, days int) core.Transaction {
+		tx.Timestamp = base.AddDate(0, 0, days).Format(time.RFC3339)
+		return tx
+	}
+	assert.NoError(t, store.SaveTransactions(context.Background(), []core.Transaction{
+		at(multi, 0),
+		at(transfer(1, "users:001", "merchants:001", 50), 1),
+		at(transfer(2, "merchants:001", "users:001", 30), 2),
+		at(transfer(3, "users:001", "merchants:001", 20), 3),
+		at(transfer(4, "users:001", "merchants:001", 5), 3),
+	}))
+
+	for _, tc := range []struct {
+		from, to time.Time
+		expected int64
+	}{
+		{expected: 175},
+		{from: base.AddDate(0, 0, 1), expected: 75},
+		{to: base.AddDate(0, 0, 1), expected: 100},
+		{from: base.AddDate(0, 0, 1), to: base.AddDate(0, 0, 3), expected: 50},
+		{from: base.AddDate(0, 0, 4), expected: 0},
+	} {
+		flow, err := store.AggregateFlow(context.Background(), "users:001", "merchants:001", "USD", tc.from, tc.to)
+		assert.NoError(t, err)
+		assert.EqualValues(t, tc.expected, flow, "from %s to %s", tc.from, tc.to)
+	}
+
+	flow, err := store.AggregateFlow(context.Background(), "merchants:001", "users:001", "USD", time.Time{}, time.Time{})
+	assert.NoError(t, err)
+	assert.EqualValues(t, 30, flow)
+
+	flow, err = store.AggregateFlow(context.Background(), "users:001", "merchants:002", "USD", time.Time{}, time.Time{})
+	assert.NoError(t, err)
+	assert.EqualValues(t, 10, flow)
+}
+
+func testIdempotencyKey(t *testing.T, store storage.Store) {
+	ik, err := store.GetIdempotencyKey(context.Background(), "foo")
+	assert.NoError(t, err)
+	assert.Nil(t, ik)
+
+	txs := []core.Transaction{
+		{
+			ID: 0,
+			Postings: []core.Posting{
+				{
+					Source:      "world",
+					Destination: "central_bank",
+					Amount:      100,
+					Asset:       "USD",
+				},
+			},
+			Timestamp: time.Now().Format(time.RFC3339),
+		},
+		{
+			ID: 1,
+			Postings: []core.Posting{
+				{
+					Source:      "world",
+					Destination: "central_bank",
+					Amount:      100,
+					Asset:       "USD",
+				},
+			},
+			Timestamp: time.Now().Format(time.RFC3339),
+		},
+	}
+	before := time.Now().Truncate(time.Second)
+	err = store.SaveTransactionsWithKey(context.Background(), "foo", txs)
+	assert.NoError(t, err)
+
+	ik, err = store.GetIdempotencyKey(context.Background(), "foo")
+	assert.NoError(t, err)
+	assert.NotNil(t, ik)
+	assert.EqualValues(t, 0, ik.FirstTxID)
+	assert.EqualValues(t, 1, ik.LastTxID)
+	savedAt, err := time.Parse(time.RFC3339, ik.Timestamp)
+	assert.NoError(t, err)
+	assert.False(t, savedAt.Before(before), ik.Timestamp)
+}
+
+// BenchmarkFindAccountsByMetadata checks that the metadata filters of postgres are served by the GIN index
+// of the values on a million metadata, and measures the search of the accounts.
+func BenchmarkFindAccountsByMetadata(b *testing.B) {
+	pgServer, err := ledgertesting.PostgresServer()
+	if err != nil {
+		b.Skipf("postgres unavailable: %s", err)
+	}
+	defer pgServer.Close()
+
+	ledger := uuid.New()
+	db, err := sql.Open("pgx", pgServer.ConnString())
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	store, err := NewStore(ledger, sqlbuilder.PostgreSQL, db, func(ctx context.Context) error {
+		return db.Close()
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer store.Close(context.Background())
+
+	err = store.Initialize(context.Background())
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	// One account out of a hundred is verified, and only those have postings
+	// so that the aggregation of the addresses doesn't hide the cost of the filter
+	for _, statement := range []string{
+		`INSERT INTO "%s".metadata (meta_id, meta_target_type, meta_target_id, meta_key, meta_value, timestamp)
+			SELECT i, 'account', 'users:' || i, 'kyc_status',
+				CASE WHEN i % 100 = 0 THEN '"verified"' ELSE '"pending"' END, '2021-01-01T00:00:00Z'
+			FROM generate_series(1, 1000000) i`,
+		`INSERT INTO "%s".postings (id, txid, source, destination, amount, asset)
+			SELECT 0, i, 'world', 'users:' || i, 100, 'USD'
+			FROM generate_series(100, 1000000, 100) i`,
+		`ANALYZE "%s".metadata`,
+	} {
+		_, err := db.Exec(strings.ReplaceAll(statement, "%s", ledger))
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	sqlq, args := store.metadataTargets("account", "kyc_status", "verified").BuildWithFlavor(sqlbuilder.PostgreSQL)
+	rows, err := db.Query("EXPLAIN "+sqlq, args...)
+	if err != nil {
+		b.Fatal(err)
+	}
+	plan := make([]string, 0)
+	for rows.Next() {
+		var line string
+		if err := rows.Scan(&line); err != nil {
+			b.Fatal(err)
+		}
+		plan = append(plan, line)
+	}
+	_ = rows.Close()
+	if !strings.Contains(strings.Join(plan, "\n"), "m_i3") {
+		b.Fatalf("expected the GIN index of the metadata values to be used, got plan:\n%s", strings.Join(plan, "\n"))
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		cursor, err := store.FindAccounts(context.Background(), query.New([]query.QueryModifier{
+			query.AccountMetadata("kyc_status", "verified"),
+		}))
+		if err != nil {
+			b.Fatal(err)
+		}
+		if len(cursor.Data.([]core.Account)) != query.DEFAULT_LIMIT {
+			b.Fatalf("expected %d accounts, got %d", query.DEFAULT_LIMIT, len(cursor.Data.([]core.Account)))
+		}
+	}
+}
+
+// BenchmarkSaveTransactions compares the inserts and the copy of batches of a thousand transactions in postgres
+func BenchmarkSaveTransactions(b *testing.B) {
+	pgServer, err := ledgertesting.PostgresServer()
+	if err != nil {
+		b.Skipf("postgres unavailable: %s", err)
+	}
+	defer pgServer.Close()
+
+	for _, bc := range []struct {
+		name      string
+		threshold int
+	}{
+		{name: "insert", threshold: math.MaxInt32},
+		{name: "copy", threshold: defaultCopyThreshold},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			db, err := sql.Open("pgx", pgServer.ConnString())
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			store, err := NewStore(uuid.New(), sqlbuilder.PostgreSQL, db, func(ctx context.Context) error {
+				return db.Close()
+			})
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer store.Close(context.Background())
+			store.copyThreshold = bc.threshold
+
+			err = store.Initialize(context.Background())
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				batch := largeBatch(int64(i*1000), 1000)
+				for j := range batch {
+					batch[j].Reference = ""
+					batch[j].Metadata = nil
+				}
+				b.StartTimer()
+
+				err := store.SaveTransactions(context.Background(), batch)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
 }
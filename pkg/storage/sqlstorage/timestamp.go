@@ -0,0 +1,37 @@
+package sqlstorage
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/huandu/go-sqlbuilder"
+)
+
+// timestamp scans a transaction timestamp, whether it is read as text or as timestamptz, see timestampColumn
+type timestamp string
+
+func (t *timestamp) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case time.Time:
+		*t = timestamp(v.UTC().Format(time.RFC3339))
+	case string:
+		*t = timestamp(v)
+	case []byte:
+		*t = timestamp(v)
+	case nil:
+		*t = ""
+	default:
+		return fmt.Errorf("unsupported timestamp type %T", src)
+	}
+	return nil
+}
+
+// timestampColumn returns the column of the timestamps of the transactions as they were committed, prefixed with alias.
+// The PostgreSQL ledgers filter the transactions on a timestamptz column, and keep the committed strings, which
+// are hashed, in the timestamp_text column.
+func (s *Store) timestampColumn(alias string) string {
+	if s.flavor == sqlbuilder.PostgreSQL {
+		return alias + ".timestamp_text"
+	}
+	return alias + ".timestamp"
+}
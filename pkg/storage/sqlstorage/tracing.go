@@ -0,0 +1,39 @@
+package sqlstorage
+
+import (
+	"context"
+
+	"github.com/huandu/go-sqlbuilder"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.7.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/numary/ledger/pkg/storage/sqlstorage")
+
+// startSpan starts a span for a query to the database of the store
+func (s *Store) startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	system := semconv.DBSystemSqlite
+	if s.flavor == sqlbuilder.PostgreSQL {
+		system = semconv.DBSystemPostgreSQL
+	}
+
+	return tracer.Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(append([]attribute.KeyValue{
+			system,
+			semconv.DBNameKey.String(s.ledger),
+		}, attrs...)...),
+	)
+}
+
+// endSpan ends a span started with startSpan, recording the error of the query if any
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -4,17 +4,20 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
-	"github.com/sirupsen/logrus"
+	"github.com/numary/ledger/pkg/logging"
 	"math"
 	"sort"
+	"time"
 
 	"github.com/huandu/go-sqlbuilder"
 	"github.com/numary/ledger/pkg/core"
 	"github.com/numary/ledger/pkg/ledger/query"
+	"github.com/numary/ledger/pkg/storage"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 func (s *Store) FindTransactions(ctx context.Context, q query.Query) (query.Cursor, error) {
-	q.Limit = int(math.Max(-1, math.Min(float64(q.Limit), 100))) + 1
+	q.Limit = int(math.Max(-1, float64(q.Limit))) + 1
 
 	c := query.Cursor{}
 	results := make([]core.Transaction, 0)
@@ -22,30 +25,68 @@ func (s *Store) FindTransactions(ctx context.Context, q query.Query) (query.Curs
 	in := sqlbuilder.NewSelectBuilder()
 	in.Select("txid").From(s.table("postings"))
 	in.GroupBy("txid")
-	in.OrderBy("txid desc")
 	in.Limit(q.Limit)
 
-	if q.After != "" {
-		in.Where(in.LessThan("txid", q.After))
+	// The previous page is fetched in the reverse order, starting from the keyset
+	keyset := q.After
+	if q.Before != "" {
+		keyset = q.Before
+	}
+	ascending := (q.Before != "") != q.Sorting().Asc
+	if ascending {
+		in.OrderBy("txid asc")
+		if keyset != "" {
+			in.Where(in.GreaterThan("txid", keyset))
+		}
+	} else {
+		in.OrderBy("txid desc")
+		if keyset != "" {
+			in.Where(in.LessThan("txid", keyset))
+		}
 	}
 
 	if q.HasParam("account") {
+		account := q.Params["account"].(string)
 		in.Where(in.Or(
-			in.Equal("source", q.Params["account"]),
-			in.Equal("destination", q.Params["account"]),
+			matchAccount(in, "source", account),
+			matchAccount(in, "destination", account),
 		))
 	}
 
-	if q.HasParam("reference") {
+	if q.HasParam("asset") {
 		in.Where(
-			in.Equal("reference", q.Params["reference"]),
+			in.Equal("asset", q.Params["asset"]),
 		)
 	}
 
+	// The filters on the columns of the transactions select their ids, the postings having none of these columns
+	if q.HasParam("after_timestamp") || q.HasParam("before_timestamp") || q.HasParam("reference") || q.HasParam("reference_prefix") {
+		tsb := sqlbuilder.NewSelectBuilder()
+		tsb.Select("id").From(s.table("transactions"))
+		if q.HasParam("after_timestamp") {
+			tsb.Where(tsb.GreaterThan("timestamp", q.Params["after_timestamp"].(time.Time).UTC().Format(time.RFC3339)))
+		}
+		if q.HasParam("before_timestamp") {
+			tsb.Where(tsb.LessThan("timestamp", q.Params["before_timestamp"].(time.Time).UTC().Format(time.RFC3339)))
+		}
+		if q.HasParam("reference") {
+			tsb.Where(tsb.Equal("reference", q.Params["reference"]))
+		}
+		if q.HasParam("reference_prefix") {
+			tsb.Where(hasPrefix(tsb, "reference", q.Params["reference_prefix"].(string)))
+		}
+		in.Where(in.In("txid", tsb))
+	}
+
+	keys, values := storage.MetadataFilters(q, "metadata")
+	for i := range keys {
+		in.Where(s.matchMetadata(in, "txid", "transaction", keys[i], values[i]))
+	}
+
 	sb := sqlbuilder.NewSelectBuilder()
 	sb.Select(
 		"t.id",
-		"t.timestamp",
+		s.timestampColumn("t"),
 		"t.hash",
 		"t.reference",
 		"p.source",
@@ -58,10 +99,18 @@ func (s *Store) FindTransactions(ctx context.Context, q query.Query) (query.Curs
 	sb.JoinWithOption(sqlbuilder.LeftJoin, sb.As(s.table("postings"), "p"), "p.txid = t.id")
 	sb.OrderBy("t.id desc, p.id asc")
 
+	// Bounding the timestamps of the outer query too lets the partitioned ledgers scan only the partitions of the range
+	if q.HasParam("after_timestamp") {
+		sb.Where(sb.GreaterThan("t.timestamp", q.Params["after_timestamp"].(time.Time).UTC().Format(time.RFC3339)))
+	}
+	if q.HasParam("before_timestamp") {
+		sb.Where(sb.LessThan("t.timestamp", q.Params["before_timestamp"].(time.Time).UTC().Format(time.RFC3339)))
+	}
+
 	sqlq, args := sb.BuildWithFlavor(s.flavor)
-	logrus.Debugln(sqlq, args)
+	logging.FromContext(ctx).Debugln(sqlq, args)
 
-	rows, err := s.db.QueryContext(
+	rows, err := s.reader(ctx).QueryContext(
 		ctx,
 		sqlq,
 		args...,
@@ -75,7 +124,7 @@ func (s *Store) FindTransactions(ctx context.Context, q query.Query) (query.Curs
 
 	for rows.Next() {
 		var txid int64
-		var ts string
+		var ts timestamp
 		var thash string
 		var ref sql.NullString
 
@@ -99,7 +148,7 @@ func (s *Store) FindTransactions(ctx context.Context, q query.Query) (query.Curs
 			transactions[txid] = core.Transaction{
 				ID:        txid,
 				Postings:  []core.Posting{},
-				Timestamp: ts,
+				Timestamp: string(ts),
 				Hash:      thash,
 				Reference: ref.String,
 				Metadata:  core.Metadata{},
@@ -122,6 +171,9 @@ func (s *Store) FindTransactions(ctx context.Context, q query.Query) (query.Curs
 	}
 
 	sort.Slice(results, func(i, j int) bool {
+		if ascending {
+			return results[i].ID < results[j].ID
+		}
 		return results[i].ID > results[j].ID
 	})
 
@@ -131,6 +183,9 @@ func (s *Store) FindTransactions(ctx context.Context, q query.Query) (query.Curs
 	if c.HasMore {
 		results = results[:len(results)-1]
 	}
+	if q.Before != "" {
+		storage.ReverseTransactions(results)
+	}
 	c.Data = results
 
 	total, _ := s.CountTransactions(ctx)
@@ -140,12 +195,179 @@ func (s *Store) FindTransactions(ctx context.Context, q query.Query) (query.Curs
 }
 
 func (s *Store) SaveTransactions(ctx context.Context, ts []core.Transaction) error {
+	err := s.ensurePartitions(ctx, ts)
+	if err != nil {
+		return translateError(err)
+	}
+
+	tx, err := s.beginWrite(ctx)
+	if err != nil {
+		return translateError(err)
+	}
+
+	err = s.saveTransactions(ctx, tx, ts)
+	if err != nil {
+		tx.Rollback()
+
+		return translateError(err)
+	}
+
+	return translateError(tx.Commit())
+}
+
+// SaveTransactionsWithMeta saves the transactions along with metadata of other targets in the same sql transaction
+func (s *Store) SaveTransactionsWithMeta(ctx context.Context, ts []core.Transaction, entries []storage.MetaEntry) error {
+	err := s.ensurePartitions(ctx, ts)
+	if err != nil {
+		return translateError(err)
+	}
+
+	tx, err := s.beginWrite(ctx)
+	if err != nil {
+		return translateError(err)
+	}
+
+	err = s.saveTransactionsWithMeta(ctx, tx, ts, entries)
+	if err != nil {
+		tx.Rollback()
+
+		return translateError(err)
+	}
+
+	return translateError(tx.Commit())
+}
+
+// saveTransactionsWithMeta inserts the transactions and the metadata entries, which ids follow
+// the metadata of the transactions, using the provided sql transaction.
+func (s *Store) saveTransactionsWithMeta(ctx context.Context, tx *writeTx, ts []core.Transaction, entries []storage.MetaEntry) error {
+	err := s.saveTransactions(ctx, tx, ts)
+	if err != nil {
+		return err
+	}
+
+	lastMetaID, err := s.lastMetaID(ctx, tx)
+	if err != nil {
+		return err
+	}
+
+	entries = append([]storage.MetaEntry{}, entries...)
+	for i := range entries {
+		entries[i].ID = lastMetaID + int64(i) + 1
+	}
+
+	return s.saveMetaBatch(ctx, tx.Tx, entries)
+}
+
+// txOptions returns the options of the sql transactions saving transactions.
+// They are serializable on postgres, so that concurrent commits fail with a conflict instead of interleaving.
+func (s *Store) txOptions() *sql.TxOptions {
+	if s.flavor == sqlbuilder.PostgreSQL {
+		return &sql.TxOptions{Isolation: sql.LevelSerializable}
+	}
+	return nil
+}
+
+// saveTransactions saves the transactions and updates the volumes of their accounts using the provided sql transaction.
+// The large batches are copied with PostgreSQL, see copyTransactions. The caller is responsible for committing
+// or rolling back tx.
+func (s *Store) saveTransactions(ctx context.Context, tx *writeTx, ts []core.Transaction) (err error) {
+	ctx, span := s.startSpan(ctx, "InsertTransactions", attribute.Int("transactions", len(ts)))
+	defer func() {
+		endSpan(span, err)
+	}()
+
+	err = s.checkFollows(ctx, tx, ts)
+	if err != nil {
+		return err
+	}
+
+	lastMetaID, err := s.lastMetaID(ctx, tx)
+	if err != nil {
+		return err
+	}
+
+	if s.flavor == sqlbuilder.PostgreSQL && len(ts) >= s.copyThreshold {
+		err = s.copyTransactions(ctx, tx, ts, lastMetaID+1)
+	} else {
+		err = s.insertTransactions(ctx, tx.Tx, ts, lastMetaID+1)
+		if err == nil {
+			err = s.insertReferences(ctx, tx.Tx, ts)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	return s.updateVolumes(ctx, tx.Tx, ts)
+}
+
+// checkFollows fails with ErrConflict if the last transaction is not the one the transactions were chained to,
+// see storage.WithPreviousTransaction, or if their ids don't follow its id.
+// The transactions saved without a previous transaction only need unique ids, so that the stores sharing a database
+// can write concurrently. The transactions being serializable on postgres, a concurrent save fails the sql transaction.
+func (s *Store) checkFollows(ctx context.Context, tx *writeTx, ts []core.Transaction) error {
+	previous, ok := storage.PreviousTransactionFromContext(ctx)
+	if !ok {
+		return nil
+	}
+
+	sb := sqlbuilder.NewSelectBuilder()
+	sb.Select("max(id)").From(s.table("transactions"))
+
+	sqlq, args := sb.BuildWithFlavor(s.flavor)
+	logging.FromContext(ctx).Debugln(sqlq, args)
 
-	tx, err := s.db.BeginTx(ctx, nil)
+	var id sql.NullInt64
+	err := tx.QueryRowContext(ctx, sqlq, args...).Scan(&id)
 	if err != nil {
 		return err
 	}
+	last := int64(-1)
+	if id.Valid {
+		last = id.Int64
+	}
+
+	if previous != last {
+		return fmt.Errorf("%w: transaction %d was saved after transaction %d", storage.ErrConflict, last, previous)
+	}
+	for _, t := range ts {
+		if t.ID <= last {
+			return fmt.Errorf("%w: transaction %d does not follow the last transaction", storage.ErrConflict, t.ID)
+		}
+		last = t.ID
+	}
+	return nil
+}
+
+// insertReferences inserts the keys of the references of the transactions in the scope of the context,
+// which unique constraint rejects the references already used, see storage.WithReferenceScope
+func (s *Store) insertReferences(ctx context.Context, tx *sql.Tx, ts []core.Transaction) error {
+	scope := storage.ReferenceScopeFromContext(ctx)
+
+	ib := sqlbuilder.NewInsertBuilder()
+	ib.InsertInto(s.table("transaction_references"))
+	ib.Cols("reference", "asset", "txid")
+	keys := 0
+	for _, t := range ts {
+		for _, k := range storage.ReferenceKeys(scope, t) {
+			ib.Values(k.Reference, k.Asset, t.ID)
+			keys++
+		}
+	}
+	if keys == 0 {
+		return nil
+	}
+
+	sqlq, args := ib.BuildWithFlavor(s.flavor)
+	logging.FromContext(ctx).Debugln(sqlq, args)
+
+	_, err := tx.ExecContext(ctx, sqlq, args...)
+	return err
+}
 
+// insertTransactions inserts the transactions, their postings and their metadata row by row,
+// the ids of the metadata starting at nextID
+func (s *Store) insertTransactions(ctx context.Context, tx *sql.Tx, ts []core.Transaction, nextID int64) error {
 	for _, t := range ts {
 		var ref *string
 
@@ -155,17 +377,33 @@ func (s *Store) SaveTransactions(ctx context.Context, ts []core.Transaction) err
 
 		ib := sqlbuilder.NewInsertBuilder()
 		ib.InsertInto(s.table("transactions"))
-		ib.Cols("id", "reference", "timestamp", "hash")
-		ib.Values(t.ID, ref, t.Timestamp, t.Hash)
+		if s.flavor == sqlbuilder.PostgreSQL {
+			ib.Cols("id", "reference", "timestamp", "timestamp_text", "hash")
+			ib.Values(t.ID, ref, t.Timestamp, t.Timestamp, t.Hash)
+		} else {
+			ib.Cols("id", "reference", "timestamp", "hash")
+			ib.Values(t.ID, ref, t.Timestamp, t.Hash)
+		}
 
 		sqlq, args := ib.BuildWithFlavor(s.flavor)
 		_, err := tx.ExecContext(ctx, sqlq, args...)
 		if err != nil {
-			tx.Rollback()
-
 			return err
 		}
 
+		if s.partitioned {
+			ib := sqlbuilder.NewInsertBuilder()
+			ib.InsertInto(s.table("transactions_keys"))
+			ib.Cols("id", "reference")
+			ib.Values(t.ID, ref)
+
+			sqlq, args := ib.BuildWithFlavor(s.flavor)
+			_, err := tx.ExecContext(ctx, sqlq, args...)
+			if err != nil {
+				return err
+			}
+		}
+
 		for i, p := range t.Postings {
 			ib := sqlbuilder.NewInsertBuilder()
 			ib.InsertInto(s.table("postings"))
@@ -177,19 +415,10 @@ func (s *Store) SaveTransactions(ctx context.Context, ts []core.Transaction) err
 			_, err := tx.ExecContext(ctx, sqlq, args...)
 
 			if err != nil {
-				tx.Rollback()
-
 				return err
 			}
 		}
 
-		nextID, err := s.CountMeta(ctx)
-		if err != nil {
-			tx.Rollback()
-
-			return err
-		}
-
 		for key, value := range t.Metadata {
 			ib := sqlbuilder.NewInsertBuilder()
 			ib.InsertInto(s.table("metadata"))
@@ -211,12 +440,10 @@ func (s *Store) SaveTransactions(ctx context.Context, ts []core.Transaction) err
 			)
 
 			sqlq, args := ib.BuildWithFlavor(s.flavor)
-			logrus.Debugln(sqlq, args)
+			logging.FromContext(ctx).Debugln(sqlq, args)
 
 			_, err = tx.ExecContext(ctx, sqlq, args...)
 			if err != nil {
-				tx.Rollback()
-
 				return err
 			}
 
@@ -224,14 +451,14 @@ func (s *Store) SaveTransactions(ctx context.Context, ts []core.Transaction) err
 		}
 	}
 
-	return tx.Commit()
+	return nil
 }
 
 func (s *Store) GetTransaction(ctx context.Context, txid string) (tx core.Transaction, err error) {
 	sb := sqlbuilder.NewSelectBuilder()
 	sb.Select(
 		"t.id",
-		"t.timestamp",
+		s.timestampColumn("t"),
 		"t.hash",
 		"t.reference",
 		"p.source",
@@ -245,9 +472,9 @@ func (s *Store) GetTransaction(ctx context.Context, txid string) (tx core.Transa
 	sb.OrderBy("p.id asc")
 
 	sqlq, args := sb.BuildWithFlavor(s.flavor)
-	logrus.Debugln(sqlq, args)
+	logging.FromContext(ctx).Debugln(sqlq, args)
 
-	rows, err := s.db.QueryContext(
+	rows, err := s.reader(ctx).QueryContext(
 		ctx,
 		sqlq,
 		args...,
@@ -259,7 +486,7 @@ func (s *Store) GetTransaction(ctx context.Context, txid string) (tx core.Transa
 
 	for rows.Next() {
 		var txid int64
-		var ts string
+		var ts timestamp
 		var thash string
 		var tref sql.NullString
 
@@ -280,7 +507,7 @@ func (s *Store) GetTransaction(ctx context.Context, txid string) (tx core.Transa
 		}
 
 		tx.ID = txid
-		tx.Timestamp = ts
+		tx.Timestamp = string(ts)
 		tx.Hash = thash
 		tx.Metadata = core.Metadata{}
 		tx.Reference = tref.String
@@ -297,7 +524,83 @@ func (s *Store) GetTransaction(ctx context.Context, txid string) (tx core.Transa
 	return tx, nil
 }
 
+func (s *Store) GetTransactionByHash(ctx context.Context, hash string) (core.Transaction, error) {
+	sb := sqlbuilder.NewSelectBuilder()
+	sb.Select("id")
+	sb.From(s.table("transactions"))
+	sb.Where(sb.Equal("hash", hash))
+
+	sqlq, args := sb.BuildWithFlavor(s.flavor)
+	logging.FromContext(ctx).Debugln(sqlq, args)
+
+	var id int64
+	err := s.reader(ctx).QueryRowContext(ctx, sqlq, args...).Scan(&id)
+	if err == sql.ErrNoRows {
+		return core.Transaction{
+			Metadata: core.Metadata{},
+		}, nil
+	}
+	if err != nil {
+		return core.Transaction{}, translateError(err)
+	}
+
+	return s.GetTransaction(ctx, fmt.Sprintf("%d", id))
+}
+
+// FindUsedReferences looks the references up in the transactions by their index, along with the assets
+// of their postings, in a single query
+func (s *Store) FindUsedReferences(ctx context.Context, keys []storage.ReferenceKey) ([]storage.ReferenceKey, error) {
+	used := make([]storage.ReferenceKey, 0)
+	if len(keys) == 0 {
+		return used, nil
+	}
+
+	references := make([]interface{}, 0, len(keys))
+	for _, k := range keys {
+		references = append(references, k.Reference)
+	}
+
+	sb := sqlbuilder.NewSelectBuilder()
+	sb.Select("t.reference", "p.asset")
+	sb.Distinct()
+	sb.From(sb.As(s.table("transactions"), "t"))
+	sb.Join(sb.As(s.table("postings"), "p"), "p.txid = t.id")
+	sb.Where(sb.In("t.reference", references...))
+
+	sqlq, args := sb.BuildWithFlavor(s.flavor)
+	logging.FromContext(ctx).Debugln(sqlq, args)
+
+	rows, err := s.reader(ctx).QueryContext(ctx, sqlq, args...)
+	if err != nil {
+		return nil, translateError(err)
+	}
+	defer rows.Close()
+
+	found := map[storage.ReferenceKey]struct{}{}
+	for rows.Next() {
+		var k storage.ReferenceKey
+		if err := rows.Scan(&k.Reference, &k.Asset); err != nil {
+			return nil, err
+		}
+		found[k] = struct{}{}
+		found[storage.ReferenceKey{Reference: k.Reference}] = struct{}{}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, k := range keys {
+		if _, ok := found[k]; ok {
+			used = append(used, k)
+		}
+	}
+	return used, nil
+}
+
+// LastTransaction reads the last transaction from the primary database, as the ids of the new transactions follow it
 func (s *Store) LastTransaction(ctx context.Context) (*core.Transaction, error) {
+	ctx = storage.WithConsistentRead(ctx)
+
 	var lastTransaction core.Transaction
 
 	q := query.New()
@@ -315,3 +618,28 @@ func (s *Store) LastTransaction(ctx context.Context) (*core.Transaction, error)
 	}
 	return nil, nil
 }
+
+func (s *Store) Head(ctx context.Context) (int64, string, error) {
+	sb := sqlbuilder.NewSelectBuilder()
+	sb.Select("id", "hash")
+	sb.From(s.table("transactions"))
+	sb.OrderBy("id").Desc()
+	sb.Limit(1)
+
+	sqlq, args := sb.BuildWithFlavor(s.flavor)
+	logging.FromContext(ctx).Debugln(sqlq, args)
+
+	var (
+		id   int64
+		hash string
+	)
+	err := s.reader(ctx).QueryRowContext(ctx, sqlq, args...).Scan(&id, &hash)
+	if err == sql.ErrNoRows {
+		return -1, "", nil
+	}
+	if err != nil {
+		return 0, "", translateError(err)
+	}
+
+	return id, hash, nil
+}
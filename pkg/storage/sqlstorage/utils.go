@@ -0,0 +1,99 @@
+package sqlstorage
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/huandu/go-sqlbuilder"
+	"github.com/numary/ledger/pkg/ledger/query"
+	"github.com/numary/ledger/pkg/storage"
+)
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// hasPrefix builds a condition matching the values of field starting with prefix,
+// escaping the LIKE wildcards the prefix may contain
+func hasPrefix(sb *sqlbuilder.SelectBuilder, field, prefix string) string {
+	return fmt.Sprintf(`%s LIKE %s ESCAPE '\'`, field, sb.Var(likeEscaper.Replace(prefix)+"%"))
+}
+
+// matchAccount builds a condition matching field against an account address or pattern (see query.Account)
+func matchAccount(sb *sqlbuilder.SelectBuilder, field, account string) string {
+	if prefix, ok := query.AccountPattern(account); ok {
+		return hasPrefix(sb, field, prefix)
+	}
+	return sb.Equal(field, account)
+}
+
+// matchMetadata builds a condition matching field against the ids of the targets of a type
+// which metadata have the value at the key, see query.Metadata.
+func (s *Store) matchMetadata(sb *sqlbuilder.SelectBuilder, field, targetType, key, value string) string {
+	return sb.In(field, s.metadataTargets(targetType, key, value))
+}
+
+// metadataTargets selects the ids of the targets of a type which metadata have the value at the key.
+// Only the latest value of the key is matched.
+func (s *Store) metadataTargets(targetType, key, value string) *sqlbuilder.SelectBuilder {
+	key, path := storage.MetadataPath(key)
+
+	id := "m.meta_target_id"
+	if targetType == "transaction" {
+		id = "CAST(m.meta_target_id AS BIGINT)"
+	}
+
+	// The values of a key are kept along with the previous ones
+	newer := sqlbuilder.NewSelectBuilder()
+	newer.Select("1").From(newer.As(s.table("metadata"), "n"))
+	newer.Where(
+		"n.meta_target_type = m.meta_target_type",
+		"n.meta_target_id = m.meta_target_id",
+		"n.meta_key = m.meta_key",
+		"n.meta_id > m.meta_id",
+	)
+
+	mb := sqlbuilder.NewSelectBuilder()
+	mb.Select(id).From(mb.As(s.table("metadata"), "m"))
+	mb.Where(
+		mb.Equal("m.meta_target_type", targetType),
+		mb.Equal("m.meta_key", key),
+		fmt.Sprintf("NOT EXISTS (%s)", mb.Var(newer)),
+	)
+	switch s.flavor {
+	case sqlbuilder.PostgreSQL:
+		// The containment queries are served by the GIN index of the values, the expression
+		// must stay the one of the index (meta_value::jsonb) for the planner to use it
+		conds := make([]string, 0)
+		for _, document := range containedDocuments(path, value) {
+			conds = append(conds, fmt.Sprintf("CAST(m.meta_value AS jsonb) @> CAST(%s AS jsonb)", mb.Var(document)))
+		}
+		mb.Where(mb.Or(conds...))
+	default:
+		mb.Where(fmt.Sprintf("metadata_match(m.meta_value, %s, %s)", mb.Var(strings.Join(path, ".")), mb.Var(value)))
+	}
+
+	return mb
+}
+
+// containedDocuments returns the json documents containing the value at the path, as a string,
+// and also as a number or a boolean if the value is the JSON text of one
+func containedDocuments(path []string, value string) []string {
+	values := []interface{}{value}
+	var scalar interface{}
+	if err := json.Unmarshal([]byte(value), &scalar); err == nil {
+		switch scalar.(type) {
+		case float64, bool:
+			values = append(values, json.RawMessage(value))
+		}
+	}
+
+	documents := make([]string, 0, len(values))
+	for _, v := range values {
+		for i := len(path) - 1; i >= 0; i-- {
+			v = map[string]interface{}{path[i]: v}
+		}
+		data, _ := json.Marshal(v)
+		documents = append(documents, string(data))
+	}
+	return documents
+}
@@ -0,0 +1,212 @@
+package sqlstorage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+
+	"github.com/huandu/go-sqlbuilder"
+	"github.com/numary/ledger/pkg/core"
+	"github.com/numary/ledger/pkg/logging"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.7.0"
+)
+
+// updateVolumes adds the postings of the transactions to the volumes of the accounts, and their assets to the assets
+// table, using the provided sql transaction. The volumes are updated in the order of the accounts and assets,
+// so that concurrent commits lock them in the same order.
+func (s *Store) updateVolumes(ctx context.Context, tx *sql.Tx, ts []core.Transaction) error {
+	type target struct {
+		account string
+		asset   string
+	}
+
+	volumes := map[target]map[string]int64{}
+	add := func(account, asset, kind string, amount int64) {
+		t := target{account: account, asset: asset}
+		if _, ok := volumes[t]; !ok {
+			volumes[t] = map[string]int64{}
+		}
+		volumes[t][kind] += amount
+	}
+	for _, t := range ts {
+		for _, p := range t.Postings {
+			add(p.Source, p.Asset, "output", p.Amount)
+			add(p.Destination, p.Asset, "input", p.Amount)
+		}
+	}
+
+	targets := make([]target, 0, len(volumes))
+	for t := range volumes {
+		targets = append(targets, t)
+	}
+	sort.Slice(targets, func(i, j int) bool {
+		if targets[i].account != targets[j].account {
+			return targets[i].account < targets[j].account
+		}
+		return targets[i].asset < targets[j].asset
+	})
+
+	for _, t := range targets {
+		ib := sqlbuilder.NewInsertBuilder()
+		ib.InsertInto(s.table("volumes"))
+		ib.Cols("account", "asset", "input", "output")
+		ib.Values(t.account, t.asset, volumes[t]["input"], volumes[t]["output"])
+		ib.SQL("ON CONFLICT (account, asset) DO UPDATE SET input = volumes.input + excluded.input, output = volumes.output + excluded.output")
+
+		sqlq, args := ib.BuildWithFlavor(s.flavor)
+		logging.FromContext(ctx).Debugln(sqlq, args)
+
+		_, err := tx.ExecContext(ctx, sqlq, args...)
+		if err != nil {
+			return err
+		}
+	}
+
+	seen := map[string]struct{}{}
+	assets := make([]string, 0)
+	for _, t := range targets {
+		if _, ok := seen[t.asset]; !ok {
+			seen[t.asset] = struct{}{}
+			assets = append(assets, t.asset)
+		}
+	}
+	sort.Strings(assets)
+
+	return s.insertAssets(ctx, tx, assets)
+}
+
+// insertAssets adds the assets missing from the assets table, using the provided sql transaction
+func (s *Store) insertAssets(ctx context.Context, tx *sql.Tx, assets []string) error {
+	if len(assets) == 0 {
+		return nil
+	}
+
+	ib := sqlbuilder.NewInsertBuilder()
+	ib.InsertInto(s.table("assets"))
+	ib.Cols("asset")
+	for _, asset := range assets {
+		ib.Values(asset)
+	}
+	ib.SQL("ON CONFLICT (asset) DO NOTHING")
+
+	sqlq, args := ib.BuildWithFlavor(s.flavor)
+	logging.FromContext(ctx).Debugln(sqlq, args)
+
+	_, err := tx.ExecContext(ctx, sqlq, args...)
+	return err
+}
+
+// volumesOf reads the volumes of an account, restricted to a single asset if asset is not empty
+func (s *Store) volumesOf(ctx context.Context, address, asset string) (volumes map[string]map[string]int64, err error) {
+	volumes = map[string]map[string]int64{}
+
+	sb := sqlbuilder.NewSelectBuilder()
+	sb.
+		Select("asset", "input", "output").
+		From(s.table("volumes")).
+		Where(sb.Equal("account", address))
+
+	if asset != "" {
+		sb.Where(sb.Equal("asset", asset))
+	}
+
+	sqlq, args := sb.BuildWithFlavor(s.flavor)
+
+	ctx, span := s.startSpan(ctx, "AggregateVolumes",
+		semconv.DBStatementKey.String(sqlq),
+		attribute.String("account", address),
+	)
+	defer func() {
+		endSpan(span, err)
+	}()
+
+	rows, err := s.reader(ctx).QueryContext(ctx, sqlq, args...)
+	if err != nil {
+		return volumes, translateError(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			asset         string
+			input, output int64
+		)
+
+		err := rows.Scan(&asset, &input, &output)
+		if err != nil {
+			return volumes, err
+		}
+
+		volumes[asset] = map[string]int64{
+			"input":  input,
+			"output": output,
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return volumes, translateError(err)
+	}
+
+	return volumes, nil
+}
+
+// RecomputeVolumes replaces the volumes of the accounts by the aggregation of the postings, in a single sql transaction
+func (s *Store) RecomputeVolumes(ctx context.Context) error {
+	tx, err := s.db.BeginTx(ctx, s.txOptions())
+	if err != nil {
+		return translateError(err)
+	}
+
+	err = s.recomputeVolumes(ctx, tx)
+	if err != nil {
+		tx.Rollback()
+
+		return translateError(err)
+	}
+
+	return translateError(tx.Commit())
+}
+
+// recomputeVolumes replaces the volumes using the provided sql transaction
+func (s *Store) recomputeVolumes(ctx context.Context, tx *sql.Tx) error {
+	db := sqlbuilder.NewDeleteBuilder()
+	db.DeleteFrom(s.table("volumes"))
+
+	sqlq, args := db.BuildWithFlavor(s.flavor)
+	logging.FromContext(ctx).Debugln(sqlq, args)
+
+	_, err := tx.ExecContext(ctx, sqlq, args...)
+	if err != nil {
+		return err
+	}
+
+	in := sqlbuilder.NewSelectBuilder()
+	in.Select("destination as account", "asset", "amount as input", "0 as output").From(s.table("postings"))
+
+	out := sqlbuilder.NewSelectBuilder()
+	out.Select("source as account", "asset", "0 as input", "amount as output").From(s.table("postings"))
+
+	sb := sqlbuilder.NewSelectBuilder()
+	sb.Select("account", "asset", "sum(input)", "sum(output)")
+	sb.From(sb.BuilderAs(sqlbuilder.UnionAll(in, out), "movements"))
+	sb.GroupBy("account", "asset")
+
+	// The insert builder can't insert the rows of a select
+	sqlq, args = sb.BuildWithFlavor(s.flavor)
+	sqlq = fmt.Sprintf("INSERT INTO %s (account, asset, input, output) %s", s.table("volumes"), sqlq)
+	logging.FromContext(ctx).Debugln(sqlq, args)
+
+	_, err = tx.ExecContext(ctx, sqlq, args...)
+	if err != nil {
+		return err
+	}
+
+	// The assets are only added, as the postings are never removed
+	sqlq = fmt.Sprintf("INSERT INTO %s (asset) SELECT DISTINCT asset FROM %s WHERE true ON CONFLICT (asset) DO NOTHING",
+		s.table("assets"), s.table("volumes"))
+	logging.FromContext(ctx).Debugln(sqlq)
+
+	_, err = tx.ExecContext(ctx, sqlq)
+	return err
+}
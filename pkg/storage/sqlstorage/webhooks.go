@@ -0,0 +1,189 @@
+package sqlstorage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+
+	"github.com/huandu/go-sqlbuilder"
+	"github.com/numary/ledger/pkg/core"
+	"github.com/numary/ledger/pkg/logging"
+)
+
+func (s *Store) SaveWebhook(ctx context.Context, webhook core.Webhook) error {
+	accounts, err := json.Marshal(webhook.Accounts)
+	if err != nil {
+		return err
+	}
+
+	ib := sqlbuilder.NewInsertBuilder()
+	ib.InsertInto(s.table("webhooks"))
+	ib.Cols("id", "url", "secret", "accounts", "last_txid", "timestamp")
+	ib.Values(webhook.ID, webhook.URL, webhook.Secret, string(accounts), webhook.Cursor, webhook.Timestamp)
+
+	sqlq, args := ib.BuildWithFlavor(s.flavor)
+	logging.FromContext(ctx).Debugln(sqlq, args)
+
+	_, err = s.db.ExecContext(ctx, sqlq, args...)
+	return translateError(err)
+}
+
+func (s *Store) webhooksQuery() *sqlbuilder.SelectBuilder {
+	sb := sqlbuilder.NewSelectBuilder()
+	sb.Select("id", "url", "secret", "accounts", "last_txid", "timestamp")
+	sb.From(s.table("webhooks"))
+	return sb
+}
+
+func scanWebhook(scanner interface{ Scan(...interface{}) error }) (core.Webhook, error) {
+	var (
+		webhook  core.Webhook
+		accounts string
+	)
+	err := scanner.Scan(
+		&webhook.ID,
+		&webhook.URL,
+		&webhook.Secret,
+		&accounts,
+		&webhook.Cursor,
+		&webhook.Timestamp,
+	)
+	if err != nil {
+		return core.Webhook{}, err
+	}
+	if err := json.Unmarshal([]byte(accounts), &webhook.Accounts); err != nil {
+		return core.Webhook{}, err
+	}
+
+	return webhook, nil
+}
+
+func (s *Store) GetWebhook(ctx context.Context, id string) (*core.Webhook, error) {
+	sb := s.webhooksQuery()
+	sb.Where(sb.Equal("id", id))
+
+	sqlq, args := sb.BuildWithFlavor(s.flavor)
+	logging.FromContext(ctx).Debugln(sqlq, args)
+
+	webhook, err := scanWebhook(s.reader(ctx).QueryRowContext(ctx, sqlq, args...))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, translateError(err)
+	}
+
+	return &webhook, nil
+}
+
+func (s *Store) FindWebhooks(ctx context.Context) ([]core.Webhook, error) {
+	sb := s.webhooksQuery()
+	sb.OrderBy("seq")
+
+	sqlq, args := sb.BuildWithFlavor(s.flavor)
+	logging.FromContext(ctx).Debugln(sqlq, args)
+
+	rows, err := s.reader(ctx).QueryContext(ctx, sqlq, args...)
+	if err != nil {
+		return nil, translateError(err)
+	}
+	defer rows.Close()
+
+	webhooks := make([]core.Webhook, 0)
+	for rows.Next() {
+		webhook, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, webhook)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, translateError(err)
+	}
+
+	return webhooks, nil
+}
+
+func (s *Store) UpdateWebhookCursor(ctx context.Context, id string, cursor int64) error {
+	return s.updateWebhookCursor(ctx, s.db, id, cursor)
+}
+
+func (s *Store) updateWebhookCursor(ctx context.Context, exec execer, id string, cursor int64) error {
+	ub := sqlbuilder.NewUpdateBuilder()
+	ub.Update(s.table("webhooks"))
+	ub.Set(ub.Assign("last_txid", cursor))
+	ub.Where(ub.Equal("id", id))
+
+	sqlq, args := ub.BuildWithFlavor(s.flavor)
+	logging.FromContext(ctx).Debugln(sqlq, args)
+
+	_, err := exec.ExecContext(ctx, sqlq, args...)
+	return translateError(err)
+}
+
+func (s *Store) SaveWebhookDelivery(ctx context.Context, delivery core.WebhookDelivery) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	ib := sqlbuilder.NewInsertBuilder()
+	ib.InsertInto(s.table("webhook_deliveries"))
+	ib.Cols("webhook_id", "txid", "status", "attempts", "status_code", "error", "timestamp")
+	ib.Values(delivery.WebhookID, delivery.TransactionID, delivery.Status, delivery.Attempts,
+		delivery.StatusCode, delivery.Error, delivery.Timestamp)
+
+	sqlq, args := ib.BuildWithFlavor(s.flavor)
+	logging.FromContext(ctx).Debugln(sqlq, args)
+
+	_, err = tx.ExecContext(ctx, sqlq, args...)
+	if err == nil {
+		err = s.updateWebhookCursor(ctx, tx, delivery.WebhookID, delivery.TransactionID)
+	}
+	if err != nil {
+		tx.Rollback()
+		return translateError(err)
+	}
+
+	return translateError(tx.Commit())
+}
+
+func (s *Store) FindWebhookDeliveries(ctx context.Context, id string) ([]core.WebhookDelivery, error) {
+	sb := sqlbuilder.NewSelectBuilder()
+	sb.Select("webhook_id", "txid", "status", "attempts", "status_code", "error", "timestamp")
+	sb.From(s.table("webhook_deliveries"))
+	sb.Where(sb.Equal("webhook_id", id))
+	sb.OrderBy("seq").Desc()
+
+	sqlq, args := sb.BuildWithFlavor(s.flavor)
+	logging.FromContext(ctx).Debugln(sqlq, args)
+
+	rows, err := s.reader(ctx).QueryContext(ctx, sqlq, args...)
+	if err != nil {
+		return nil, translateError(err)
+	}
+	defer rows.Close()
+
+	deliveries := make([]core.WebhookDelivery, 0)
+	for rows.Next() {
+		delivery := core.WebhookDelivery{}
+		err := rows.Scan(
+			&delivery.WebhookID,
+			&delivery.TransactionID,
+			&delivery.Status,
+			&delivery.Attempts,
+			&delivery.StatusCode,
+			&delivery.Error,
+			&delivery.Timestamp,
+		)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, translateError(err)
+	}
+
+	return deliveries, nil
+}
@@ -2,25 +2,121 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"github.com/numary/ledger/pkg/core"
 	"github.com/numary/ledger/pkg/ledger/query"
+	"time"
 )
 
+var (
+	// ErrDuplicateReference is returned by the stores when saving a transaction with an already used reference
+	ErrDuplicateReference = errors.New("reference already used")
+	// ErrConflict is returned by the stores when transactions could not be saved because of a concurrent write,
+	// like transactions saved with the same ids. Saving them again with up to date ids can succeed.
+	ErrConflict = errors.New("conflict with a concurrent write")
+	// ErrMetaVersionMismatch is returned by the stores when saving metadata whose target is no longer at the version
+	// set with WithMetaVersion
+	ErrMetaVersionMismatch = errors.New("metadata version mismatch")
+)
+
+// Store holds the data of a ledger. Besides the built-in ones, stores can be provided by third-party drivers,
+// see RegisterDriver, which must behave the same way. storagetesting.TestStore checks most of the following
+// and can be run against them.
+//
+// The transactions are saved with the ids assigned by the ledger, greater than the id of the last one, following it
+// without gap unless the ledger generates sparse ids, and are returned in the descending order of their ids unless
+// stated otherwise. The transactions of a batch are saved atomically: on error none of them must be visible.
+// A transaction reusing a reference fails the batch with ErrDuplicateReference, and a transaction saved with an id
+// already taken or not following the transaction it was chained to, because of a concurrent write,
+// see WithPreviousTransaction, with ErrConflict. Both are matched with errors.Is, so they can be wrapped.
+//
+// The missing data are not errors: the getters return nil, empty values or a transaction without postings,
+// as documented on each method. The errors are the failures of the backend. The context of the calls carries
+// the deadline of the request and whether the read must see the last writes, see WithConsistentRead.
 type Store interface {
 	LastTransaction(context.Context) (*core.Transaction, error)
+	// Head returns the id and the hash of the last transaction with a single indexed lookup, -1 if there is none
+	Head(context.Context) (int64, string, error)
 	LastMetaID(context.Context) (int64, error)
 	SaveTransactions(context.Context, []core.Transaction) error
+	SaveTransactionsWithKey(context.Context, string, []core.Transaction) error
+	// SaveTransactionsWithMeta saves the transactions along with metadata of other targets, atomically.
+	// The ids of the metadata entries are assigned by the store.
+	SaveTransactionsWithMeta(context.Context, []core.Transaction, []MetaEntry) error
+	GetIdempotencyKey(context.Context, string) (*IdempotencyKey, error)
 	CountTransactions(context.Context) (int64, error)
 	FindTransactions(context.Context, query.Query) (query.Cursor, error)
 	GetTransaction(context.Context, string) (core.Transaction, error)
+	// GetTransactionByHash returns the transaction with the hash, with an index lookup.
+	// Like GetTransaction, the transaction has no postings if there is none.
+	GetTransactionByHash(context.Context, string) (core.Transaction, error)
+	// FindUsedReferences returns the keys among the given ones used by the transactions, with index lookups,
+	// whatever the scope the transactions were saved in: a key without asset is used by the transactions
+	// with its reference, and a key with an asset by those of them with a posting of the asset
+	FindUsedReferences(context.Context, []ReferenceKey) ([]ReferenceKey, error)
 	AggregateBalances(context.Context, string) (map[string]int64, error)
+	AggregateBalance(context.Context, string, string) (int64, error)
 	AggregateVolumes(context.Context, string) (map[string]map[string]int64, error)
+	// AggregateVolumesAt computes the volumes of an account from the transactions up to an id included
+	AggregateVolumesAt(context.Context, string, int64) (map[string]map[string]int64, error)
+	// AggregateFlow sums the amounts of an asset sent from a source to a destination by the postings of the
+	// transactions with a timestamp from the first time included to the second one excluded, the zero times
+	// leaving the range open
+	AggregateFlow(ctx context.Context, source, destination, asset string, from, to time.Time) (int64, error)
+	AccountExists(context.Context, string) (bool, error)
+	// RecomputeVolumes rebuilds the volumes of the accounts, maintained along with the transactions,
+	// from their postings
+	RecomputeVolumes(context.Context) error
+	SumBalances(context.Context, query.Query) (map[string]int64, error)
 	CountAccounts(context.Context) (int64, error)
+	// AssetVolumes returns the sum of the amounts of the postings, by asset
+	AssetVolumes(context.Context) (map[string]int64, error)
+	// FindAssets returns the distinct assets of the postings, sorted, read from the volumes maintained
+	// along with the transactions rather than from the postings
+	FindAssets(context.Context) ([]string, error)
+	// FindAssetsOf returns the distinct assets of the postings with one of the given base codes, sorted,
+	// like USD and USD/2 for USD, without reading the assets of the other base codes
+	FindAssetsOf(context.Context, []string) ([]string, error)
 	FindAccounts(context.Context, query.Query) (query.Cursor, error)
+	// FindBalances returns the accounts matching the query with their balances, paginated as FindAccounts
+	FindBalances(context.Context, query.Query) (query.Cursor, error)
+	// GetAccounts returns the accounts with the given addresses, in the same order, with their balances and metadata.
+	// The accounts which never appeared are returned with empty balances and nil metadata.
+	GetAccounts(context.Context, []string) ([]core.Account, error)
 	SaveMeta(context.Context, int64, string, string, string, string, string) error
+	SaveMetaBatch(context.Context, []MetaEntry) error
 	GetMeta(context.Context, string, string) (core.Metadata, error)
+	// FindMetaTargets returns the ids of the targets of a type which have metadata, sorted
+	FindMetaTargets(context.Context, string) ([]string, error)
+	DeleteMeta(context.Context, string, string, []string) error
+	// GetMetaVersion returns the number of updates of the metadata of a target, 0 if never updated.
+	// SaveMeta, SaveMetaBatch, DeleteMeta and the metadata entries of SaveTransactionsWithMeta increment it once
+	// per target, the metadata of the new transactions don't. SaveMetaBatch checks the version set with WithMetaVersion
+	// in the same write as it increments it, failing with ErrMetaVersionMismatch if it differs.
+	GetMetaVersion(context.Context, string, string) (int64, error)
 	CountMeta(context.Context) (int64, error)
+	// SaveScript saves a version of a named script, failing with ErrConflict if the version already exists
+	SaveScript(context.Context, core.StoredScript) error
+	// GetScript returns a version of a named script, or its last version if the version is 0, nil if there is none
+	GetScript(context.Context, string, int64) (*core.StoredScript, error)
+	// FindScripts returns the last version of each script, sorted by name
+	FindScripts(context.Context) ([]core.StoredScript, error)
+	SaveWebhook(context.Context, core.Webhook) error
+	// GetWebhook returns a webhook by id, nil if there is none
+	GetWebhook(context.Context, string) (*core.Webhook, error)
+	// FindWebhooks returns the webhooks in the order of their registration
+	FindWebhooks(context.Context) ([]core.Webhook, error)
+	// UpdateWebhookCursor moves the cursor of a webhook to the id of the last transaction handled
+	UpdateWebhookCursor(context.Context, string, int64) error
+	// SaveWebhookDelivery records a delivery and moves the cursor of its webhook to its transaction, atomically
+	SaveWebhookDelivery(context.Context, core.WebhookDelivery) error
+	// FindWebhookDeliveries returns the deliveries of a webhook, the most recent first
+	FindWebhookDeliveries(context.Context, string) ([]core.WebhookDelivery, error)
 	Initialize(context.Context) error
+	// Drop deletes all the data of the ledger. The store must be initialized again to be used.
+	Drop(context.Context) error
 	Name() string
+	// Ping checks that the backend of the store can be reached, without reading the data of the ledger
+	Ping(context.Context) error
 	Close(context.Context) error
 }
@@ -0,0 +1,1381 @@
+package storagetesting
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/numary/ledger/pkg/core"
+	"github.com/numary/ledger/pkg/ledger/query"
+	"github.com/numary/ledger/pkg/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestStore runs the store test suite, each test against a new empty store returned by newStore.
+// It is meant for the stores which don't evaluate queries with sql.
+func TestStore(t *testing.T, newStore func(t *testing.T) storage.Store) {
+	for _, tf := range []struct {
+		name string
+		fn   func(t *testing.T, store storage.Store)
+	}{
+		{
+			name: "SaveTransactions",
+			fn:   testSaveTransactions,
+		},
+		{
+			name: "SaveTransactionsWithMeta",
+			fn:   testSaveTransactionsWithMeta,
+		},
+		{
+			name: "SparseTransactionIDs",
+			fn:   testSparseTransactionIDs,
+		},
+		{
+			name: "DuplicateReference",
+			fn:   testDuplicateReference,
+		},
+		{
+			name: "ExpiredContext",
+			fn:   testExpiredContext,
+		},
+		{
+			name: "FindTransactions",
+			fn:   testFindTransactions,
+		},
+		{
+			name: "FindTransactionsAscending",
+			fn:   testFindTransactionsAscending,
+		},
+		{
+			name: "FindTransactionsByReferencePrefix",
+			fn:   testFindTransactionsByReferencePrefix,
+		},
+		{
+			name: "ReferenceScopes",
+			fn:   testReferenceScopes,
+		},
+		{
+			name: "FindAssets",
+			fn:   testFindAssets,
+		},
+		{
+			name: "FindAssetsOf",
+			fn:   testFindAssetsOf,
+		},
+		{
+			name: "FindMetaTargets",
+			fn:   testFindMetaTargets,
+		},
+		{
+			name: "FindTransactionsByMetadata",
+			fn:   testFindTransactionsByMetadata,
+		},
+		{
+			name: "FindAccounts",
+			fn:   testFindAccounts,
+		},
+		{
+			name: "FindAccountsByMetadata",
+			fn:   testFindAccountsByMetadata,
+		},
+		{
+			name: "FindBalances",
+			fn:   testFindBalances,
+		},
+		{
+			name: "GetAccounts",
+			fn:   testGetAccounts,
+		},
+		{
+			name: "SortAccounts",
+			fn:   testSortAccounts,
+		},
+		{
+			name: "Aggregations",
+			fn:   testAggregations,
+		},
+		{
+			name: "Meta",
+			fn:   testMeta,
+		},
+		{
+			name: "MetaVersion",
+			fn:   testMetaVersion,
+		},
+		{
+			name: "RecomputeVolumes",
+			fn:   testRecomputeVolumes,
+		},
+		{
+			name: "IdempotencyKey",
+			fn:   testIdempotencyKey,
+		},
+		{
+			name: "Scripts",
+			fn:   testScripts,
+		},
+		{
+			name: "Webhooks",
+			fn:   testWebhooks,
+		},
+		{
+			name: "Head",
+			fn:   testHead,
+		},
+		{
+			name: "GetTransactionByHash",
+			fn:   testGetTransactionByHash,
+		},
+		{
+			name: "AggregateFlow",
+			fn:   testAggregateFlow,
+		},
+		{
+			name: "Drop",
+			fn:   testDrop,
+		},
+	} {
+		t.Run(tf.name, func(t *testing.T) {
+			store := newStore(t)
+
+			err := store.Initialize(context.Background())
+			assert.NoError(t, err)
+			defer store.Close(context.Background())
+
+			tf.fn(t, store)
+		})
+	}
+}
+
+func transfer(id int64, source, destination string, amount int64, asset string) core.Transaction {
+	return core.Transaction{
+		ID: id,
+		Postings: []core.Posting{
+			{
+				Source:      source,
+				Destination: destination,
+				Amount:      amount,
+				Asset:       asset,
+			},
+		},
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+	}
+}
+
+func testSaveTransactions(t *testing.T, store storage.Store) {
+	last, err := store.LastTransaction(context.Background())
+	assert.NoError(t, err)
+	assert.Nil(t, last)
+
+	tx := transfer(0, "world", "central_bank", 100, "USD")
+	tx.Metadata = core.Metadata{
+		"foo": json.RawMessage(`"bar"`),
+	}
+	err = store.SaveTransactions(context.Background(), []core.Transaction{tx})
+	assert.NoError(t, err)
+
+	err = store.SaveTransactions(context.Background(), []core.Transaction{
+		transfer(0, "world", "central_bank", 100, "USD"),
+	})
+	assert.True(t, errors.Is(err, storage.ErrConflict))
+
+	saved, err := store.GetTransaction(context.Background(), "0")
+	assert.NoError(t, err)
+	assert.Equal(t, tx, saved)
+
+	last, err = store.LastTransaction(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, tx, *last)
+
+	missing, err := store.GetTransaction(context.Background(), "1")
+	assert.NoError(t, err)
+	assert.Nil(t, missing.Postings)
+
+	count, err := store.CountTransactions(context.Background())
+	assert.NoError(t, err)
+	assert.EqualValues(t, 1, count)
+}
+
+func testSaveTransactionsWithMeta(t *testing.T, store storage.Store) {
+	now := time.Now().Format(time.RFC3339)
+	err := store.SaveTransactions(context.Background(), []core.Transaction{
+		{
+			ID: 0,
+			Postings: []core.Posting{
+				{Source: "world", Destination: "users:001", Amount: 100, Asset: "USD"},
+			},
+			Timestamp: now,
+			Metadata: core.Metadata{
+				"category": json.RawMessage(`"gold"`),
+			},
+		},
+	})
+	assert.NoError(t, err)
+
+	entries := []storage.MetaEntry{
+		{Timestamp: now, TargetType: "transaction", TargetID: "0", Key: "state", Value: `"reverted"`},
+	}
+	reverse := core.Transaction{
+		ID: 1,
+		Postings: []core.Posting{
+			{Source: "users:001", Destination: "world", Amount: 100, Asset: "USD"},
+		},
+		Timestamp: now,
+	}
+
+	// A conflicting write must not save the metadata entries
+	conflicting := reverse
+	conflicting.ID = 0
+	err = store.SaveTransactionsWithMeta(context.Background(), []core.Transaction{conflicting}, entries)
+	assert.Error(t, err)
+
+	meta, err := store.GetMeta(context.Background(), "transaction", "0")
+	assert.NoError(t, err)
+	assert.Equal(t, core.Metadata{
+		"category": json.RawMessage(`"gold"`),
+	}, meta)
+
+	err = store.SaveTransactionsWithMeta(context.Background(), []core.Transaction{reverse}, entries)
+	assert.NoError(t, err)
+
+	meta, err = store.GetMeta(context.Background(), "transaction", "0")
+	assert.NoError(t, err)
+	assert.Equal(t, core.Metadata{
+		"category": json.RawMessage(`"gold"`),
+		"state":    json.RawMessage(`"reverted"`),
+	}, meta)
+
+	lastMetaID, err := store.LastMetaID(context.Background())
+	assert.NoError(t, err)
+	assert.EqualValues(t, 1, lastMetaID)
+
+	count, err := store.CountTransactions(context.Background())
+	assert.NoError(t, err)
+	assert.EqualValues(t, 2, count)
+}
+
+func testDuplicateReference(t *testing.T, store storage.Store) {
+	tx := transfer(0, "world", "central_bank", 100, "USD")
+	tx.Reference = "foo"
+	err := store.SaveTransactions(context.Background(), []core.Transaction{tx})
+	assert.NoError(t, err)
+
+	tx = transfer(1, "world", "central_bank", 100, "USD")
+	tx.Reference = "foo"
+	err = store.SaveTransactions(context.Background(), []core.Transaction{tx})
+	assert.True(t, errors.Is(err, storage.ErrDuplicateReference))
+
+	count, err := store.CountTransactions(context.Background())
+	assert.NoError(t, err)
+	assert.EqualValues(t, 1, count)
+}
+
+func testSparseTransactionIDs(t *testing.T, store storage.Store) {
+	transfer := func(id int64) core.Transaction {
+		return core.Transaction{
+			ID: id,
+			Postings: []core.Posting{
+				{Source: "world", Destination: "users:001", Amount: 100, Asset: "USD"},
+			},
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+		}
+	}
+
+	err := store.SaveTransactions(context.Background(), []core.Transaction{
+		transfer(5),
+		transfer(9),
+	})
+	assert.NoError(t, err)
+	err = store.SaveTransactions(context.Background(), []core.Transaction{
+		transfer(100),
+	})
+	assert.NoError(t, err)
+
+	// The transactions chained to a transaction followed by another one are not saved
+	ctx := storage.WithPreviousTransaction(context.Background(), 9)
+	err = store.SaveTransactions(ctx, []core.Transaction{
+		transfer(200),
+	})
+	assert.True(t, errors.Is(err, storage.ErrConflict), err)
+
+	ctx = storage.WithPreviousTransaction(context.Background(), 100)
+	err = store.SaveTransactions(ctx, []core.Transaction{
+		transfer(150),
+	})
+	assert.NoError(t, err)
+
+	// The ids must follow the last one
+	err = store.SaveTransactions(context.Background(), []core.Transaction{
+		transfer(120),
+	})
+	assert.True(t, errors.Is(err, storage.ErrConflict), err)
+
+	tx, err := store.GetTransaction(context.Background(), "9")
+	assert.NoError(t, err)
+	assert.EqualValues(t, 9, tx.ID)
+	missing, err := store.GetTransaction(context.Background(), "10")
+	assert.NoError(t, err)
+	assert.Nil(t, missing.Postings)
+
+	ids := func(q query.Query) []int64 {
+		c, err := store.FindTransactions(context.Background(), q)
+		assert.NoError(t, err)
+		ids := make([]int64, 0)
+		for _, tx := range c.Data.([]core.Transaction) {
+			ids = append(ids, tx.ID)
+		}
+		return ids
+	}
+	q := query.New()
+	assert.Equal(t, []int64{150, 100, 9, 5}, ids(q))
+	q.Modify(query.After("100"))
+	assert.Equal(t, []int64{9, 5}, ids(q))
+	q.Modify(query.After("9"))
+	q.Modify(query.SortTransactions(true))
+	assert.Equal(t, []int64{100, 150}, ids(q))
+
+	count, err := store.CountTransactions(context.Background())
+	assert.NoError(t, err)
+	assert.EqualValues(t, 4, count)
+
+	txid, _, err := store.Head(context.Background())
+	assert.NoError(t, err)
+	assert.EqualValues(t, 150, txid)
+
+	last, err := store.LastTransaction(context.Background())
+	assert.NoError(t, err)
+	assert.EqualValues(t, 150, last.ID)
+}
+
+func testExpiredContext(t *testing.T, store storage.Store) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	<-ctx.Done()
+
+	err := store.SaveTransactions(ctx, []core.Transaction{transfer(0, "world", "central_bank", 100, "USD")})
+	assert.True(t, errors.Is(err, context.DeadlineExceeded), err)
+
+	count, err := store.CountTransactions(context.Background())
+	assert.NoError(t, err)
+	assert.EqualValues(t, 0, count)
+}
+
+func testFindTransactions(t *testing.T, store storage.Store) {
+	txs := make([]core.Transaction, 0)
+	for i := int64(0); i < 250; i++ {
+		txs = append(txs, transfer(i, "world", fmt.Sprintf("users:%03d", i%10), 10, "USD"))
+	}
+	txs[42].Reference = "ref"
+	err := store.SaveTransactions(context.Background(), txs)
+	assert.NoError(t, err)
+
+	cursor, err := store.FindTransactions(context.Background(), query.New())
+	assert.NoError(t, err)
+	assert.True(t, cursor.HasMore)
+	assert.EqualValues(t, 250, cursor.Total)
+	assert.Len(t, cursor.Data, query.DEFAULT_LIMIT)
+	assert.EqualValues(t, 249, cursor.Data.([]core.Transaction)[0].ID)
+
+	q := query.New()
+	q.Modify(query.Account("users:001"))
+	q.Modify(query.After("200"))
+	q.Modify(query.Limit(100))
+	cursor, err = store.FindTransactions(context.Background(), q)
+	assert.NoError(t, err)
+	assert.False(t, cursor.HasMore)
+	assert.Len(t, cursor.Data, 20)
+	assert.EqualValues(t, 191, cursor.Data.([]core.Transaction)[0].ID)
+
+	q = query.New()
+	q.Modify(query.Account("users:001"))
+	q.Modify(query.Before("200"))
+	q.Modify(query.Limit(3))
+	cursor, err = store.FindTransactions(context.Background(), q)
+	assert.NoError(t, err)
+	assert.True(t, cursor.HasMore)
+	assert.Len(t, cursor.Data, 3)
+	assert.EqualValues(t, 221, cursor.Data.([]core.Transaction)[0].ID)
+	assert.EqualValues(t, 201, cursor.Data.([]core.Transaction)[2].ID)
+
+	q = query.New()
+	q.Modify(query.Before("249"))
+	cursor, err = store.FindTransactions(context.Background(), q)
+	assert.NoError(t, err)
+	assert.False(t, cursor.HasMore)
+	assert.Len(t, cursor.Data, 0)
+
+	q = query.New()
+	q.Modify(query.Reference("ref"))
+	cursor, err = store.FindTransactions(context.Background(), q)
+	assert.NoError(t, err)
+	assert.Len(t, cursor.Data, 1)
+	assert.EqualValues(t, 42, cursor.Data.([]core.Transaction)[0].ID)
+
+	q = query.New()
+	q.Modify(query.Asset("EUR"))
+	cursor, err = store.FindTransactions(context.Background(), q)
+	assert.NoError(t, err)
+	assert.Len(t, cursor.Data, 0)
+}
+
+func testFindTransactionsAscending(t *testing.T, store storage.Store) {
+	txs := make([]core.Transaction, 0)
+	for i := int64(0); i < 10; i++ {
+		txs = append(txs, transfer(i, "world", fmt.Sprintf("users:%03d", i%2), 10, "USD"))
+	}
+	assert.NoError(t, store.SaveTransactions(context.Background(), txs))
+
+	ids := func(m ...query.QueryModifier) []int64 {
+		cursor, err := store.FindTransactions(context.Background(), query.New(m))
+		assert.NoError(t, err)
+		ids := []int64{}
+		for _, tx := range cursor.Data.([]core.Transaction) {
+			ids = append(ids, tx.ID)
+		}
+		return ids
+	}
+
+	asc := query.SortTransactions(true)
+	assert.Equal(t, []int64{0, 1, 2}, ids(asc, query.Limit(3)))
+	assert.Equal(t, []int64{3, 4, 5}, ids(asc, query.Limit(3), query.After("2")))
+	assert.Equal(t, []int64{9}, ids(asc, query.Limit(3), query.After("8")))
+	// The previous page keeps the ascending order
+	assert.Equal(t, []int64{2, 3, 4}, ids(asc, query.Limit(3), query.Before("5")))
+	assert.Equal(t, []int64{1, 3}, ids(asc, query.Limit(2), query.Account("users:001")))
+	assert.Equal(t, []int64{5, 7}, ids(asc, query.Limit(2), query.Account("users:001"), query.After("3")))
+	assert.Equal(t, []int64{9, 8, 7}, ids(query.SortTransactions(false), query.Limit(3)))
+
+	cursor, err := store.FindTransactions(context.Background(), query.New([]query.QueryModifier{asc, query.Limit(3), query.After("6")}))
+	assert.NoError(t, err)
+	assert.False(t, cursor.HasMore)
+	cursor, err = store.FindTransactions(context.Background(), query.New([]query.QueryModifier{asc, query.Limit(3), query.After("5")}))
+	assert.NoError(t, err)
+	assert.True(t, cursor.HasMore)
+}
+
+func testFindTransactionsByReferencePrefix(t *testing.T, store storage.Store) {
+	txs := make([]core.Transaction, 0)
+	for i, ref := range []string{"import_1", "import_2", "other", "", "import%_3"} {
+		tx := transfer(int64(i), "world", "users:001", 10, "USD")
+		tx.Reference = ref
+		txs = append(txs, tx)
+	}
+	assert.NoError(t, store.SaveTransactions(context.Background(), txs))
+
+	ids := func(prefix string) []int64 {
+		cursor, err := store.FindTransactions(context.Background(), query.New([]query.QueryModifier{
+			query.ReferencePrefix(prefix),
+		}))
+		assert.NoError(t, err)
+		ids := []int64{}
+		for _, tx := range cursor.Data.([]core.Transaction) {
+			ids = append(ids, tx.ID)
+		}
+		return ids
+	}
+
+	assert.Equal(t, []int64{4, 1, 0}, ids("import"))
+	assert.Equal(t, []int64{1, 0}, ids("import_"))
+	// The wildcards of the prefix are matched literally
+	assert.Equal(t, []int64{4}, ids("import%"))
+	assert.Equal(t, []int64{4, 3, 2, 1, 0}, ids(""))
+}
+
+func testReferenceScopes(t *testing.T, store storage.Store) {
+	tx := func(id int64, ref string, assets ...string) core.Transaction {
+		t := transfer(id, "world", "users:001", 10, assets[0])
+		t.Reference = ref
+		for _, asset := range assets[1:] {
+			t.Postings = append(t.Postings, core.Posting{Source: "world", Destination: "users:001", Amount: 10, Asset: asset})
+		}
+		return t
+	}
+	save := func(scope storage.ReferenceScope, ts ...core.Transaction) error {
+		return store.SaveTransactions(storage.WithReferenceScope(context.Background(), scope), ts)
+	}
+
+	// The references are unique among all the transactions by default
+	assert.NoError(t, store.SaveTransactions(context.Background(), []core.Transaction{tx(0, "global", "USD")}))
+	err := save(storage.ReferenceScopeGlobal, tx(1, "global", "EUR"))
+	assert.True(t, errors.Is(err, storage.ErrDuplicateReference), err)
+
+	assert.NoError(t, save(storage.ReferenceScopePerAsset, tx(1, "asset", "USD"), tx(2, "asset", "EUR")))
+	err = save(storage.ReferenceScopePerAsset, tx(3, "asset", "GEM", "USD"))
+	assert.True(t, errors.Is(err, storage.ErrDuplicateReference), err)
+	err = save(storage.ReferenceScopePerAsset, tx(3, "batch", "GEM"), tx(4, "batch", "GEM"))
+	assert.True(t, errors.Is(err, storage.ErrDuplicateReference), err)
+
+	assert.NoError(t, save(storage.ReferenceScopeNone, tx(3, "none", "USD"), tx(4, "none", "USD")))
+	assert.NoError(t, save(storage.ReferenceScopeNone, tx(5, "global", "USD")))
+
+	count, err := store.CountTransactions(context.Background())
+	assert.NoError(t, err)
+	assert.EqualValues(t, 6, count)
+
+	// The references are found whatever the scope they were saved in
+	used, err := store.FindUsedReferences(context.Background(), []storage.ReferenceKey{
+		{Reference: "global"},
+		{Reference: "global", Asset: "USD"},
+		{Reference: "global", Asset: "GEM"},
+		{Reference: "asset"},
+		{Reference: "asset", Asset: "EUR"},
+		{Reference: "none", Asset: "USD"},
+		{Reference: "batch"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []storage.ReferenceKey{
+		{Reference: "global"},
+		{Reference: "global", Asset: "USD"},
+		{Reference: "asset"},
+		{Reference: "asset", Asset: "EUR"},
+		{Reference: "none", Asset: "USD"},
+	}, used)
+
+	used, err = store.FindUsedReferences(context.Background(), nil)
+	assert.NoError(t, err)
+	assert.Empty(t, used)
+}
+
+func testFindAssets(t *testing.T, store storage.Store) {
+	txs := []core.Transaction{
+		transfer(0, "world", "users:001", 100, "USD"),
+		transfer(1, "world", "users:002", 100, "EUR"),
+		transfer(2, "users:001", "users:002", 10, "USD"),
+		transfer(3, "world", "users:001", 100, "GEM/2"),
+	}
+	assets, err := store.FindAssets(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []string{}, assets)
+
+	assert.NoError(t, store.SaveTransactions(context.Background(), txs))
+
+	assets, err = store.FindAssets(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"EUR", "GEM/2", "USD"}, assets)
+}
+
+func testFindAssetsOf(t *testing.T, store storage.Store) {
+	txs := []core.Transaction{
+		transfer(0, "world", "users:001", 100, "USD"),
+		transfer(1, "world", "users:002", 100, "USD/2"),
+		transfer(2, "world", "users:001", 100, "USDC/2"),
+		transfer(3, "world", "users:001", 100, "GEM/2"),
+	}
+	assert.NoError(t, store.SaveTransactions(context.Background(), txs))
+
+	assets, err := store.FindAssetsOf(context.Background(), []string{"USD"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"USD", "USD/2"}, assets)
+
+	assets, err = store.FindAssetsOf(context.Background(), []string{"GEM", "EUR"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"GEM/2"}, assets)
+
+	assets, err = store.FindAssetsOf(context.Background(), []string{})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{}, assets)
+}
+
+func testFindMetaTargets(t *testing.T, store storage.Store) {
+	ids, err := store.FindMetaTargets(context.Background(), "asset")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{}, ids)
+
+	for i, target := range []string{"USD", "EUR", "USD"} {
+		err := store.SaveMeta(context.Background(), int64(i), time.Now().Format(time.RFC3339), "asset", target, "scale", "2")
+		assert.NoError(t, err)
+	}
+	err = store.SaveMeta(context.Background(), 3, time.Now().Format(time.RFC3339), "account", "users:001", "scale", "2")
+	assert.NoError(t, err)
+
+	ids, err = store.FindMetaTargets(context.Background(), "asset")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"EUR", "USD"}, ids)
+
+	assert.NoError(t, store.DeleteMeta(context.Background(), "asset", "EUR", []string{"scale"}))
+	ids, err = store.FindMetaTargets(context.Background(), "asset")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"USD"}, ids)
+}
+
+func testFindTransactionsByMetadata(t *testing.T, store storage.Store) {
+	now := time.Now().Format(time.RFC3339)
+	txs := []core.Transaction{
+		{
+			ID: 0,
+			Postings: []core.Posting{
+				{Source: "world", Destination: "users:001", Amount: 100, Asset: "USD"},
+			},
+			Metadata: core.Metadata{
+				"order_id": json.RawMessage(`"order-1"`),
+				"customer": json.RawMessage(`{"id": "customer-1", "vip": true}`),
+			},
+			Timestamp: now,
+		},
+		{
+			ID: 1,
+			Postings: []core.Posting{
+				{Source: "world", Destination: "users:002", Amount: 100, Asset: "USD"},
+			},
+			Metadata: core.Metadata{
+				"order_id": json.RawMessage(`"order-2"`),
+				"customer": json.RawMessage(`{"id": "customer-1", "vip": false}`),
+				"attempt":  json.RawMessage(`2`),
+			},
+			Timestamp: now,
+		},
+	}
+	err := store.SaveTransactions(context.Background(), txs)
+	assert.NoError(t, err)
+
+	find := func(modifiers ...query.QueryModifier) []int64 {
+		cursor, err := store.FindTransactions(context.Background(), query.New(modifiers))
+		assert.NoError(t, err)
+		ids := make([]int64, 0)
+		for _, tx := range cursor.Data.([]core.Transaction) {
+			ids = append(ids, tx.ID)
+		}
+		return ids
+	}
+
+	assert.Equal(t, []int64{0}, find(query.Metadata("order_id", "order-1")))
+	assert.Equal(t, []int64{1, 0}, find(query.Metadata("customer.id", "customer-1")))
+	assert.Equal(t, []int64{0}, find(query.Metadata("customer.id", "customer-1"), query.Metadata("customer.vip", "true")))
+	assert.Equal(t, []int64{1}, find(query.Metadata("attempt", "2")))
+	assert.Equal(t, []int64{}, find(query.Metadata("customer", "customer-1")))
+	assert.Equal(t, []int64{}, find(query.Metadata("order_id.id", "order-1")))
+
+	// Only the latest value of a key is matched
+	err = store.SaveMeta(context.Background(), 10, now, "transaction", "0", "order_id", `"order-3"`)
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{}, find(query.Metadata("order_id", "order-1")))
+	assert.Equal(t, []int64{0}, find(query.Metadata("order_id", "order-3")))
+}
+
+func testFindAccountsByMetadata(t *testing.T, store storage.Store) {
+	now := time.Now().Format(time.RFC3339)
+	err := store.SaveTransactions(context.Background(), []core.Transaction{
+		{
+			ID: 0,
+			Postings: []core.Posting{
+				{Source: "world", Destination: "users:001", Amount: 100, Asset: "USD"},
+				{Source: "world", Destination: "users:002", Amount: 200, Asset: "USD"},
+				{Source: "world", Destination: "users:003", Amount: 300, Asset: "USD"},
+			},
+			Timestamp: now,
+		},
+	})
+	assert.NoError(t, err)
+
+	err = store.SaveMetaBatch(context.Background(), []storage.MetaEntry{
+		{ID: 0, Timestamp: now, TargetType: "account", TargetID: "users:001", Key: "kyc_status", Value: `"verified"`},
+		{ID: 1, Timestamp: now, TargetType: "account", TargetID: "users:002", Key: "kyc_status", Value: `"pending"`},
+		{ID: 2, Timestamp: now, TargetType: "account", TargetID: "users:003", Key: "kyc_status", Value: `"verified"`},
+		{ID: 3, Timestamp: now, TargetType: "account", TargetID: "users:003", Key: "kyc", Value: `{"level": 2}`},
+		{ID: 4, Timestamp: now, TargetType: "account", TargetID: "users:002", Key: "kyc_status", Value: `"verified"`},
+		{ID: 5, Timestamp: now, TargetType: "account", TargetID: "users:001", Key: "kyc_status", Value: `"revoked"`},
+	})
+	assert.NoError(t, err)
+
+	find := func(modifiers ...query.QueryModifier) []string {
+		cursor, err := store.FindAccounts(context.Background(), query.New(modifiers))
+		assert.NoError(t, err)
+		addresses := make([]string, 0)
+		for _, account := range cursor.Data.([]core.Account) {
+			addresses = append(addresses, account.Address)
+		}
+		return addresses
+	}
+
+	assert.Equal(t, []string{"users:003", "users:002"}, find(query.AccountMetadata("kyc_status", "verified")))
+	assert.Equal(t, []string{"users:003"}, find(query.AccountMetadata("kyc_status", "verified"), query.AccountMetadata("kyc.level", "2")))
+	assert.Equal(t, []string{"users:002"}, find(query.AccountMetadata("kyc_status", "verified"), query.Limit(1), query.After("users:003")))
+	assert.Equal(t, []string{"users:001"}, find(query.AccountMetadata("kyc_status", "revoked")))
+	assert.Equal(t, []string{"users:003", "users:002"}, find(
+		query.AccountMetadata("kyc_status", "verified"),
+		query.SortAccounts(query.Sort{By: query.SortByBalance, Asset: "USD"}),
+	))
+}
+
+func testFindAccounts(t *testing.T, store storage.Store) {
+	err := store.SaveTransactions(context.Background(), []core.Transaction{
+		transfer(0, "world", "users:001", 100, "USD"),
+		transfer(1, "world", "users:001:wallet", 100, "USD"),
+		transfer(2, "world", "users:0010", 100, "USD"),
+	})
+	assert.NoError(t, err)
+
+	err = store.SaveMeta(context.Background(), 0, time.Now().Format(time.RFC3339),
+		"account", "users:001", "firstname", `"John"`)
+	assert.NoError(t, err)
+
+	cursor, err := store.FindAccounts(context.Background(), query.New())
+	assert.NoError(t, err)
+	assert.EqualValues(t, 4, cursor.Total)
+	assert.Len(t, cursor.Data, 4)
+	assert.Equal(t, "world", cursor.Data.([]core.Account)[0].Address)
+
+	q := query.New()
+	q.Modify(query.Limit(1))
+	q.Modify(query.After("users:001:wallet"))
+	cursor, err = store.FindAccounts(context.Background(), q)
+	assert.NoError(t, err)
+	assert.True(t, cursor.HasMore)
+	assert.Equal(t, core.Account{
+		Address:  "users:0010",
+		Contract: "default",
+		Metadata: core.Metadata{},
+	}, cursor.Data.([]core.Account)[0])
+
+	q = query.New()
+	q.Modify(query.Limit(1))
+	q.Modify(query.Before("users:001"))
+	cursor, err = store.FindAccounts(context.Background(), q)
+	assert.NoError(t, err)
+	assert.True(t, cursor.HasMore)
+	assert.Equal(t, "users:0010", cursor.Data.([]core.Account)[0].Address)
+
+	q = query.New()
+	q.Modify(query.Account("users:001"))
+	cursor, err = store.FindAccounts(context.Background(), q)
+	assert.NoError(t, err)
+	assert.Len(t, cursor.Data, 1)
+	assert.Equal(t, core.Metadata{
+		"firstname": json.RawMessage(`"John"`),
+	}, cursor.Data.([]core.Account)[0].Metadata)
+
+	q = query.New()
+	q.Modify(query.Account("users:001:*"))
+	cursor, err = store.FindAccounts(context.Background(), q)
+	assert.NoError(t, err)
+	assert.Len(t, cursor.Data, 1)
+
+	q = query.New()
+	q.Modify(query.Address("users:"))
+	cursor, err = store.FindAccounts(context.Background(), q)
+	assert.NoError(t, err)
+	assert.Len(t, cursor.Data, 3)
+}
+
+func testSortAccounts(t *testing.T, store storage.Store) {
+	err := store.SaveTransactions(context.Background(), []core.Transaction{
+		transfer(0, "world", "users:a", 100, "USD"),
+		transfer(1, "world", "users:b", 300, "USD"),
+		transfer(2, "world", "users:c", 100, "USD"),
+		transfer(3, "world", "users:d", 50, "EUR"),
+		transfer(4, "users:b", "users:a", 50, "USD"),
+	})
+	assert.NoError(t, err)
+
+	addresses := func(m ...query.QueryModifier) []string {
+		cursor, err := store.FindAccounts(context.Background(), query.New(m))
+		assert.NoError(t, err)
+		addresses := make([]string, 0)
+		for _, account := range cursor.Data.([]core.Account) {
+			addresses = append(addresses, account.Address)
+		}
+		return addresses
+	}
+
+	byAddress := query.SortAccounts(query.Sort{By: query.SortByAddress, Asc: true})
+	assert.Equal(t, []string{"users:a", "users:b"}, addresses(byAddress, query.Limit(2)))
+	assert.Equal(t, []string{"users:c", "users:d"}, addresses(byAddress, query.Limit(2), query.After("users:b")))
+	assert.Equal(t, []string{"users:a", "users:b"}, addresses(byAddress, query.Limit(2), query.Before("users:c")))
+
+	// The balances up to the transaction 3, the ties are sorted by address
+	txid := int64(3)
+	byBalance := query.SortAccounts(query.Sort{By: query.SortByBalance, Asset: "USD", TxID: &txid})
+
+	cursor, err := store.FindAccounts(context.Background(), query.New([]query.QueryModifier{byBalance, query.Limit(2)}))
+	assert.NoError(t, err)
+	assert.True(t, cursor.HasMore)
+	assert.Equal(t, []core.Account{
+		{Address: "users:b", Contract: "default", Balances: map[string]int64{"USD": 300}, Metadata: core.Metadata{}},
+		{Address: "users:c", Contract: "default", Balances: map[string]int64{"USD": 100}, Metadata: core.Metadata{}},
+	}, cursor.Data)
+
+	next := query.After(query.BalanceKeyset(100, "users:c"))
+	assert.Equal(t, []string{"users:a", "users:d"}, addresses(byBalance, query.Limit(2), next))
+	next = query.After(query.BalanceKeyset(0, "users:d"))
+	assert.Equal(t, []string{"world"}, addresses(byBalance, query.Limit(2), next))
+	previous := query.Before(query.BalanceKeyset(100, "users:a"))
+	assert.Equal(t, []string{"users:b", "users:c"}, addresses(byBalance, query.Limit(2), previous))
+
+	// The current balances, in ascending order
+	byBalance = query.SortAccounts(query.Sort{By: query.SortByBalance, Asset: "USD", Asc: true})
+	assert.Equal(t, []string{"users:d", "users:c", "users:a", "users:b"},
+		addresses(byBalance, query.Address("users:")))
+
+	_, err = store.FindAccounts(context.Background(), query.New([]query.QueryModifier{byBalance, query.After("users:a")}))
+	assert.Equal(t, query.ErrInvalidToken, err)
+}
+
+func testGetAccounts(t *testing.T, store storage.Store) {
+	err := store.SaveTransactions(context.Background(), []core.Transaction{
+		transfer(0, "world", "users:001", 100, "USD"),
+		transfer(1, "users:001", "users:002", 30, "USD"),
+		transfer(2, "world", "users:002", 5, "EUR"),
+	})
+	assert.NoError(t, err)
+
+	err = store.SaveMeta(context.Background(), 0, time.Now().Format(time.RFC3339),
+		"account", "users:002", "firstname", `"John"`)
+	assert.NoError(t, err)
+	err = store.SaveMeta(context.Background(), 1, time.Now().Format(time.RFC3339),
+		"account", "users:003", "firstname", `"Jane"`)
+	assert.NoError(t, err)
+
+	accounts, err := store.GetAccounts(context.Background(), []string{"users:002", "unknown", "users:001", "users:003"})
+	assert.NoError(t, err)
+	assert.Equal(t, []core.Account{
+		{
+			Address:  "users:002",
+			Contract: "default",
+			Balances: map[string]int64{
+				"USD": 30,
+				"EUR": 5,
+			},
+			Metadata: core.Metadata{
+				"firstname": json.RawMessage(`"John"`),
+			},
+		},
+		{
+			Address:  "unknown",
+			Contract: "default",
+			Balances: map[string]int64{},
+		},
+		{
+			Address:  "users:001",
+			Contract: "default",
+			Balances: map[string]int64{
+				"USD": 70,
+			},
+			Metadata: core.Metadata{},
+		},
+		{
+			Address:  "users:003",
+			Contract: "default",
+			Balances: map[string]int64{},
+			Metadata: core.Metadata{
+				"firstname": json.RawMessage(`"Jane"`),
+			},
+		},
+	}, accounts)
+
+	accounts, err = store.GetAccounts(context.Background(), []string{})
+	assert.NoError(t, err)
+	assert.Empty(t, accounts)
+}
+
+func testFindBalances(t *testing.T, store storage.Store) {
+	err := store.SaveTransactions(context.Background(), []core.Transaction{
+		transfer(0, "world", "users:001", 100, "USD"),
+		transfer(1, "users:001", "users:002", 30, "USD"),
+		transfer(2, "world", "users:002", 5, "EUR"),
+		transfer(3, "world", "bank", 10, "EUR"),
+	})
+	assert.NoError(t, err)
+
+	q := query.New()
+	q.Modify(query.Limit(1))
+	q.Modify(query.Address("users:"))
+	cursor, err := store.FindBalances(context.Background(), q)
+	assert.NoError(t, err)
+	assert.True(t, cursor.HasMore)
+	assert.Equal(t, []core.Account{
+		{
+			Address:  "users:002",
+			Contract: "default",
+			Balances: map[string]int64{
+				"USD": 30,
+				"EUR": 5,
+			},
+		},
+	}, cursor.Data)
+
+	q.Modify(query.After("users:002"))
+	cursor, err = store.FindBalances(context.Background(), q)
+	assert.NoError(t, err)
+	assert.False(t, cursor.HasMore)
+	assert.Equal(t, []core.Account{
+		{
+			Address:  "users:001",
+			Contract: "default",
+			Balances: map[string]int64{
+				"USD": 70,
+			},
+		},
+	}, cursor.Data)
+}
+
+func testDrop(t *testing.T, store storage.Store) {
+	err := store.SaveTransactions(context.Background(), []core.Transaction{
+		transfer(0, "world", "users:001", 100, "USD"),
+	})
+	assert.NoError(t, err)
+
+	err = store.SaveMeta(context.Background(), 0, time.Now().Format(time.RFC3339),
+		"account", "users:001", "firstname", `"John"`)
+	assert.NoError(t, err)
+
+	err = store.Drop(context.Background())
+	assert.NoError(t, err)
+
+	err = store.Initialize(context.Background())
+	assert.NoError(t, err)
+
+	last, err := store.LastTransaction(context.Background())
+	assert.NoError(t, err)
+	assert.Nil(t, last)
+
+	count, err := store.CountAccounts(context.Background())
+	assert.NoError(t, err)
+	assert.EqualValues(t, 0, count)
+
+	meta, err := store.GetMeta(context.Background(), "account", "users:001")
+	assert.NoError(t, err)
+	assert.Empty(t, meta)
+
+	// The ids start again from zero
+	err = store.SaveTransactions(context.Background(), []core.Transaction{
+		transfer(0, "world", "users:002", 100, "USD"),
+	})
+	assert.NoError(t, err)
+}
+
+func testAggregations(t *testing.T, store storage.Store) {
+	err := store.SaveTransactions(context.Background(), []core.Transaction{
+		transfer(0, "world", "users:001", 100, "USD"),
+		transfer(1, "users:001", "users:002", 30, "USD"),
+		transfer(2, "world", "users:002", 5, "EUR"),
+	})
+	assert.NoError(t, err)
+
+	volumes, err := store.AggregateVolumes(context.Background(), "users:001")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]map[string]int64{
+		"USD": {
+			"input":  100,
+			"output": 30,
+		},
+	}, volumes)
+
+	// Both volumes are set, even when the account only received or only sent an asset
+	volumes, err = store.AggregateVolumes(context.Background(), "users:002")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]map[string]int64{
+		"USD": {
+			"input":  30,
+			"output": 0,
+		},
+		"EUR": {
+			"input":  5,
+			"output": 0,
+		},
+	}, volumes)
+
+	volumes, err = store.AggregateVolumesAt(context.Background(), "users:002", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]map[string]int64{
+		"USD": {
+			"input":  30,
+			"output": 0,
+		},
+	}, volumes)
+
+	volumes, err = store.AggregateVolumesAt(context.Background(), "users:001", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]map[string]int64{
+		"USD": {
+			"input":  100,
+			"output": 0,
+		},
+	}, volumes)
+
+	balances, err := store.AggregateBalances(context.Background(), "users:002")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int64{
+		"USD": 30,
+		"EUR": 5,
+	}, balances)
+
+	balance, err := store.AggregateBalance(context.Background(), "users:001", "USD")
+	assert.NoError(t, err)
+	assert.EqualValues(t, 70, balance)
+
+	balance, err = store.AggregateBalance(context.Background(), "users:001", "EUR")
+	assert.NoError(t, err)
+	assert.EqualValues(t, 0, balance)
+
+	exists, err := store.AccountExists(context.Background(), "users:002")
+	assert.NoError(t, err)
+	assert.True(t, exists)
+
+	exists, err = store.AccountExists(context.Background(), "users:003")
+	assert.NoError(t, err)
+	assert.False(t, exists)
+
+	q := query.New()
+	q.Modify(query.Address("users:"))
+	sums, err := store.SumBalances(context.Background(), q)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int64{
+		"USD": 100,
+		"EUR": 5,
+	}, sums)
+
+	sums, err = store.SumBalances(context.Background(), query.New())
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int64{
+		"USD": 0,
+		"EUR": 0,
+	}, sums)
+}
+
+func testMeta(t *testing.T, store storage.Store) {
+	lastMetaID, err := store.LastMetaID(context.Background())
+	assert.NoError(t, err)
+	assert.EqualValues(t, -1, lastMetaID)
+
+	now := time.Now().Format(time.RFC3339)
+	err = store.SaveMetaBatch(context.Background(), []storage.MetaEntry{
+		{ID: 0, Timestamp: now, TargetType: "transaction", TargetID: "1", Key: "firstname", Value: `"John"`},
+		{ID: 1, Timestamp: now, TargetType: "transaction", TargetID: "1", Key: "firstname", Value: `"Jane"`},
+		{ID: 2, Timestamp: now, TargetType: "transaction", TargetID: "1", Key: "lastname", Value: `"Doe"`},
+	})
+	assert.NoError(t, err)
+
+	meta, err := store.GetMeta(context.Background(), "transaction", "1")
+	assert.NoError(t, err)
+	assert.Equal(t, core.Metadata{
+		"firstname": json.RawMessage(`"Jane"`),
+		"lastname":  json.RawMessage(`"Doe"`),
+	}, meta)
+
+	err = store.DeleteMeta(context.Background(), "transaction", "1", []string{"lastname"})
+	assert.NoError(t, err)
+
+	meta, err = store.GetMeta(context.Background(), "transaction", "1")
+	assert.NoError(t, err)
+	assert.Equal(t, core.Metadata{
+		"firstname": json.RawMessage(`"Jane"`),
+	}, meta)
+
+	lastMetaID, err = store.LastMetaID(context.Background())
+	assert.NoError(t, err)
+	assert.EqualValues(t, 2, lastMetaID)
+}
+
+func testRecomputeVolumes(t *testing.T, store storage.Store) {
+	now := time.Now().Format(time.RFC3339)
+	err := store.SaveTransactions(context.Background(), []core.Transaction{
+		{
+			ID: 0,
+			Postings: []core.Posting{
+				{Source: "world", Destination: "users:001", Amount: 100, Asset: "USD"},
+				{Source: "world", Destination: "users:001", Amount: 10, Asset: "EUR"},
+			},
+			Timestamp: now,
+		},
+		{
+			ID: 1,
+			Postings: []core.Posting{
+				{Source: "users:001", Destination: "users:002", Amount: 30, Asset: "USD"},
+				{Source: "users:002", Destination: "users:001", Amount: 5, Asset: "USD"},
+			},
+			Timestamp: now,
+		},
+	})
+	assert.NoError(t, err)
+
+	// The maintained volumes are the aggregation of the postings
+	consistent := func() {
+		for _, address := range []string{"world", "users:001", "users:002"} {
+			volumes, err := store.AggregateVolumes(context.Background(), address)
+			assert.NoError(t, err)
+			aggregated, err := store.AggregateVolumesAt(context.Background(), address, 1)
+			assert.NoError(t, err)
+			assert.Equal(t, aggregated, volumes, address)
+		}
+	}
+	consistent()
+
+	err = store.RecomputeVolumes(context.Background())
+	assert.NoError(t, err)
+	consistent()
+
+	balance, err := store.AggregateBalance(context.Background(), "users:001", "USD")
+	assert.NoError(t, err)
+	assert.EqualValues(t, 75, balance)
+
+	count, err := store.CountAccounts(context.Background())
+	assert.NoError(t, err)
+	assert.EqualValues(t, 3, count)
+}
+
+func testMetaVersion(t *testing.T, store storage.Store) {
+	version, err := store.GetMetaVersion(context.Background(), "account", "users:001")
+	assert.NoError(t, err)
+	assert.EqualValues(t, 0, version)
+
+	now := time.Now().Format(time.RFC3339)
+	err = store.SaveMetaBatch(context.Background(), []storage.MetaEntry{
+		{ID: 0, Timestamp: now, TargetType: "account", TargetID: "users:001", Key: "firstname", Value: `"John"`},
+		{ID: 1, Timestamp: now, TargetType: "account", TargetID: "users:001", Key: "lastname", Value: `"Doe"`},
+		{ID: 2, Timestamp: now, TargetType: "account", TargetID: "users:002", Key: "firstname", Value: `"Jane"`},
+	})
+	assert.NoError(t, err)
+
+	version, err = store.GetMetaVersion(context.Background(), "account", "users:001")
+	assert.NoError(t, err)
+	assert.EqualValues(t, 1, version)
+
+	err = store.SaveMeta(context.Background(), 3, now, "account", "users:001", "firstname", `"Jack"`)
+	assert.NoError(t, err)
+
+	err = store.DeleteMeta(context.Background(), "account", "users:001", []string{"lastname"})
+	assert.NoError(t, err)
+
+	version, err = store.GetMetaVersion(context.Background(), "account", "users:001")
+	assert.NoError(t, err)
+	assert.EqualValues(t, 3, version)
+
+	version, err = store.GetMetaVersion(context.Background(), "account", "users:002")
+	assert.NoError(t, err)
+	assert.EqualValues(t, 1, version)
+	// The versions are compared by the write, the metadata of the other targets don't change them
+	ctx := storage.WithMetaVersion(context.Background(), 2)
+	err = store.SaveMetaBatch(ctx, []storage.MetaEntry{
+		{ID: 5, Timestamp: now, TargetType: "account", TargetID: "users:001", Key: "firstname", Value: `"Joe"`},
+	})
+	assert.True(t, errors.Is(err, storage.ErrMetaVersionMismatch), err)
+
+	ctx = storage.WithMetaVersion(context.Background(), 3)
+	err = store.SaveMetaBatch(ctx, []storage.MetaEntry{
+		{ID: 5, Timestamp: now, TargetType: "account", TargetID: "users:001", Key: "firstname", Value: `"Joe"`},
+	})
+	assert.NoError(t, err)
+	err = store.SaveMetaBatch(ctx, []storage.MetaEntry{
+		{ID: 6, Timestamp: now, TargetType: "account", TargetID: "users:001", Key: "firstname", Value: `"Jim"`},
+	})
+	assert.True(t, errors.Is(err, storage.ErrMetaVersionMismatch), err)
+
+	ctx = storage.WithMetaVersion(context.Background(), 0)
+	err = store.SaveMetaBatch(ctx, []storage.MetaEntry{
+		{ID: 6, Timestamp: now, TargetType: "account", TargetID: "users:003", Key: "firstname", Value: `"Jim"`},
+	})
+	assert.NoError(t, err)
+	err = store.SaveMetaBatch(ctx, []storage.MetaEntry{
+		{ID: 7, Timestamp: now, TargetType: "account", TargetID: "users:003", Key: "firstname", Value: `"Jo"`},
+	})
+	assert.True(t, errors.Is(err, storage.ErrMetaVersionMismatch), err)
+
+	version, err = store.GetMetaVersion(context.Background(), "account", "users:001")
+	assert.NoError(t, err)
+	assert.EqualValues(t, 4, version)
+	meta, err := store.GetMeta(context.Background(), "account", "users:001")
+	assert.NoError(t, err)
+	assert.Equal(t, core.Metadata{
+		"firstname": json.RawMessage(`"Joe"`),
+	}, meta)
+	version, err = store.GetMetaVersion(context.Background(), "account", "users:003")
+	assert.NoError(t, err)
+	assert.EqualValues(t, 1, version)
+}
+
+func testScripts(t *testing.T, store storage.Store) {
+	script, err := store.GetScript(context.Background(), "payout", 0)
+	assert.NoError(t, err)
+	assert.Nil(t, script)
+
+	scripts := []core.StoredScript{
+		{Name: "payout", Version: 1, Plain: "send [COIN 1] (source = @world destination = @users:001)", Timestamp: "2022-01-01T00:00:00Z"},
+		{Name: "payout", Version: 2, Plain: "send [COIN 2] (source = @world destination = @users:001)", Timestamp: "2022-01-02T00:00:00Z"},
+		{Name: "fees", Version: 1, Plain: "send [COIN 3] (source = @world destination = @fees)", Timestamp: "2022-01-03T00:00:00Z"},
+	}
+	for _, s := range scripts {
+		assert.NoError(t, store.SaveScript(context.Background(), s))
+	}
+
+	err = store.SaveScript(context.Background(), core.StoredScript{Name: "payout", Version: 2, Plain: "fail"})
+	assert.True(t, errors.Is(err, storage.ErrConflict), err)
+
+	script, err = store.GetScript(context.Background(), "payout", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, &scripts[1], script)
+
+	script, err = store.GetScript(context.Background(), "payout", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, &scripts[0], script)
+
+	script, err = store.GetScript(context.Background(), "payout", 3)
+	assert.NoError(t, err)
+	assert.Nil(t, script)
+
+	found, err := store.FindScripts(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []core.StoredScript{scripts[2], scripts[1]}, found)
+}
+
+func testWebhooks(t *testing.T, store storage.Store) {
+	webhook, err := store.GetWebhook(context.Background(), "hook1")
+	assert.NoError(t, err)
+	assert.Nil(t, webhook)
+
+	webhooks := []core.Webhook{
+		{ID: "hook1", URL: "http://localhost/hook1", Secret: "secret1", Accounts: []string{"users:*", "fees"}, Cursor: -1, Timestamp: "2022-01-01T00:00:00Z"},
+		{ID: "hook0", URL: "http://localhost/hook0", Secret: "secret0", Cursor: 3, Timestamp: "2022-01-02T00:00:00Z"},
+	}
+	for _, w := range webhooks {
+		assert.NoError(t, store.SaveWebhook(context.Background(), w))
+	}
+
+	webhook, err = store.GetWebhook(context.Background(), "hook1")
+	assert.NoError(t, err)
+	assert.Equal(t, &webhooks[0], webhook)
+
+	found, err := store.FindWebhooks(context.Background())
+	assert.NoError(t, err)
+	if assert.Len(t, found, 2) {
+		assert.Equal(t, "hook1", found[0].ID)
+		assert.Equal(t, "hook0", found[1].ID)
+	}
+
+	// The deliveries move the cursor of their webhook
+	deliveries := []core.WebhookDelivery{
+		{WebhookID: "hook1", TransactionID: 1, Status: core.WebhookDelivered, Attempts: 1, StatusCode: 200, Timestamp: "2022-01-03T00:00:00Z"},
+		{WebhookID: "hook1", TransactionID: 4, Status: core.WebhookDead, Attempts: 5, StatusCode: 500, Error: "unexpected status 500", Timestamp: "2022-01-04T00:00:00Z"},
+	}
+	for _, d := range deliveries {
+		assert.NoError(t, store.SaveWebhookDelivery(context.Background(), d))
+	}
+	webhook, err = store.GetWebhook(context.Background(), "hook1")
+	assert.NoError(t, err)
+	assert.EqualValues(t, 4, webhook.Cursor)
+
+	assert.NoError(t, store.UpdateWebhookCursor(context.Background(), "hook0", 6))
+	webhook, err = store.GetWebhook(context.Background(), "hook0")
+	assert.NoError(t, err)
+	assert.EqualValues(t, 6, webhook.Cursor)
+
+	history, err := store.FindWebhookDeliveries(context.Background(), "hook1")
+	assert.NoError(t, err)
+	assert.Equal(t, []core.WebhookDelivery{deliveries[1], deliveries[0]}, history)
+
+	history, err = store.FindWebhookDeliveries(context.Background(), "hook0")
+	assert.NoError(t, err)
+	assert.Empty(t, history)
+}
+
+func testHead(t *testing.T, store storage.Store) {
+	txid, hash, err := store.Head(context.Background())
+	assert.NoError(t, err)
+	assert.EqualValues(t, -1, txid)
+	assert.Empty(t, hash)
+
+	txs := []core.Transaction{
+		transfer(0, "world", "users:001", 100, "USD"),
+		transfer(1, "world", "users:001", 100, "USD"),
+	}
+	txs[0].Hash = "hash0"
+	txs[1].Hash = "hash1"
+	assert.NoError(t, store.SaveTransactions(context.Background(), txs))
+
+	txid, hash, err = store.Head(context.Background())
+	assert.NoError(t, err)
+	assert.EqualValues(t, 1, txid)
+	assert.Equal(t, "hash1", hash)
+}
+
+func testGetTransactionByHash(t *testing.T, store storage.Store) {
+	txs := []core.Transaction{
+		transfer(0, "world", "users:001", 100, "USD"),
+		transfer(1, "world", "users:002", 50, "USD"),
+	}
+	txs[0].Hash = "hash0"
+	txs[1].Hash = "hash1"
+	assert.NoError(t, store.SaveTransactions(context.Background(), txs))
+
+	tx, err := store.GetTransactionByHash(context.Background(), "hash1")
+	assert.NoError(t, err)
+	assert.EqualValues(t, 1, tx.ID)
+	assert.Equal(t, "hash1", tx.Hash)
+	assert.Equal(t, txs[1].Postings, tx.Postings)
+
+	tx, err = store.GetTransactionByHash(context.Background(), "unknown")
+	assert.NoError(t, err)
+	assert.Empty(t, tx.Postings)
+}
+
+func testAggregateFlow(t *testing.T, store storage.Store) {
+	multi := transfer(0, "users:001", "merchants:001", 100, "USD")
+	multi.Postings = append(multi.Postings,
+		core.Posting{Source: "users:001", Destination: "merchants:001", Amount: 10, Asset: "EUR"},
+		core.Posting{Source: "users:001", Destination: "merchants:002", Amount: 10, Asset: "USD"},
+	)
+	base := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(tx core.Transaction, days int) core.Transaction {
+		tx.Timestamp = base.AddDate(0, 0, days).Format(time.RFC3339)
+		return tx
+	}
+	assert.NoError(t, store.SaveTransactions(context.Background(), []core.Transaction{
+		at(multi, 0),
+		at(transfer(1, "users:001", "merchants:001", 50, "USD"), 1),
+		at(transfer(2, "merchants:001", "users:001", 30, "USD"), 2),
+		at(transfer(3, "users:001", "merchants:001", 20, "USD"), 3),
+		at(transfer(4, "users:001", "merchants:001", 5, "USD"), 3),
+	}))
+
+	for _, tc := range []struct {
+		from, to time.Time
+		expected int64
+	}{
+		{expected: 175},
+		{from: base.AddDate(0, 0, 1), expected: 75},
+		{to: base.AddDate(0, 0, 1), expected: 100},
+		{from: base.AddDate(0, 0, 1), to: base.AddDate(0, 0, 3), expected: 50},
+		{from: base.AddDate(0, 0, 4), expected: 0},
+	} {
+		flow, err := store.AggregateFlow(context.Background(), "users:001", "merchants:001", "USD", tc.from, tc.to)
+		assert.NoError(t, err)
+		assert.EqualValues(t, tc.expected, flow, "from %s to %s", tc.from, tc.to)
+	}
+
+	flow, err := store.AggregateFlow(context.Background(), "merchants:001", "users:001", "USD", time.Time{}, time.Time{})
+	assert.NoError(t, err)
+	assert.EqualValues(t, 30, flow)
+
+	flow, err = store.AggregateFlow(context.Background(), "users:001", "merchants:002", "USD", time.Time{}, time.Time{})
+	assert.NoError(t, err)
+	assert.EqualValues(t, 10, flow)
+}
+
+func testIdempotencyKey(t *testing.T, store storage.Store) {
+	ik, err := store.GetIdempotencyKey(context.Background(), "foo")
+	assert.NoError(t, err)
+	assert.Nil(t, ik)
+
+	// The key is recorded with the time it is saved, whatever the timestamps of the transactions
+	txs := []core.Transaction{
+		transfer(0, "world", "users:001", 100, "USD"),
+		transfer(1, "world", "users:001", 100, "USD"),
+	}
+	txs[0].Timestamp = time.Now().Add(-48 * time.Hour).UTC().Format(time.RFC3339)
+	before := time.Now().Truncate(time.Second)
+	err = store.SaveTransactionsWithKey(context.Background(), "foo", txs)
+	assert.NoError(t, err)
+
+	ik, err = store.GetIdempotencyKey(context.Background(), "foo")
+	assert.NoError(t, err)
+	if assert.NotNil(t, ik) {
+		assert.Equal(t, "foo", ik.Key)
+		assert.EqualValues(t, 0, ik.FirstTxID)
+		assert.EqualValues(t, 1, ik.LastTxID)
+		savedAt, err := time.Parse(time.RFC3339, ik.Timestamp)
+		assert.NoError(t, err)
+		assert.False(t, savedAt.Before(before), ik.Timestamp)
+	}
+}